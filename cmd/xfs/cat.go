@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+func runCat(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("cat", stderr)
+	offset := flags.Int64("offset", 0, "start reading at `bytes` into the file")
+	length := flags.Int64("length", -1, "read at most `bytes`, -1 reads to the end of the file")
+	follow := followFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 || *offset < 0 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	name, err := resolvePath(img, flags.Arg(1), *follow)
+	if err != nil {
+		return err
+	}
+	f, err := img.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := io.Reader(f)
+	if *offset > 0 {
+		s, ok := f.(io.Seeker)
+		if !ok {
+			return xerrors.Errorf("%s is not seekable", flags.Arg(1))
+		}
+		if _, err := s.Seek(*offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if *length >= 0 {
+		r = io.LimitReader(r, *length)
+	}
+	_, err = io.Copy(stdout, r)
+	return err
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+func runCmp(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("cmp", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	// image-path and local-dir are the same file, the names below them are
+	// compared relative to both
+	src, dst := fsPath(flags.Arg(1)), flags.Arg(2)
+	a, err := imageStates(img, src)
+	if err != nil {
+		return err
+	}
+	b, err := localStates(dst)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	differ := false
+	for _, name := range names {
+		sa, inImage := a[name]
+		sb, inLocal := b[name]
+		switch {
+		case !inLocal:
+			fmt.Fprintf(stdout, "- %s\n", name)
+		case !inImage:
+			fmt.Fprintf(stdout, "+ %s\n", name)
+		default:
+			changes, err := compareLocal(img, path.Join(src, name), filepath.Join(dst, filepath.FromSlash(name)), sa, sb)
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			fmt.Fprintf(stdout, "M %s (%s)\n", name, strings.Join(changes, ", "))
+		}
+		differ = true
+	}
+	if differ {
+		return xerrors.New("local files differ from the image")
+	}
+	return nil
+}
+
+// imageStates returns the state of root and every file below it by the path
+// relative to root
+func imageStates(img *image, root string) (map[string]fileState, error) {
+	states := map[string]fileState{}
+	err := fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s := fileState{mode: info.Mode(), size: info.Size()}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if s.link, err = img.ReadLink(name); err != nil {
+				return err
+			}
+		}
+		rel := name
+		if root != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		}
+		if rel == "" {
+			rel = "."
+		}
+		states[rel] = s
+		return nil
+	})
+	return states, err
+}
+
+// localStates is imageStates for a local directory, symlinks are not followed
+func localStates(root string) (map[string]fileState, error) {
+	states := map[string]fileState{}
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s := fileState{mode: info.Mode(), size: info.Size()}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if s.link, err = os.Readlink(name); err != nil {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		states[filepath.ToSlash(rel)] = s
+		return nil
+	})
+	return states, err
+}
+
+// compareLocal compares the type, the mode bits extract preserves, the size,
+// the symlink target and the sha256 of regular files of equal size. Times and
+// owners are not compared, installing files usually changes them.
+func compareLocal(img *image, name, local string, a, b fileState) ([]string, error) {
+	if a.mode.Type() != b.mode.Type() {
+		return []string{"type"}, nil
+	}
+	var changes []string
+	if a.mode&extractMode != b.mode&extractMode {
+		changes = append(changes, "mode")
+	}
+	// the size of directories depends on the file system
+	if a.size != b.size && !a.mode.IsDir() {
+		changes = append(changes, "size")
+	}
+	if a.link != b.link {
+		changes = append(changes, "target")
+	}
+	if len(changes) == 0 && a.mode.IsRegular() {
+		same, err := sameLocalContent(img, name, local)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			changes = append(changes, "content")
+		}
+	}
+	return changes, nil
+}
+
+func sameLocalContent(img *image, name, local string) (bool, error) {
+	sumA, err := hashFile(img, name, sha256.New())
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return bytes.Equal(sumA, h.Sum(nil)), nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// stdin is read by the debug shell, tests replace it
+var stdin io.Reader = os.Stdin
+
+// debugShell is the state of the debug shell, cur is the buffer of the last
+// inode or block read and used by hexdump.
+type debugShell struct {
+	img     *image
+	stdout  io.Writer
+	cur     []byte
+	curName string
+}
+
+var debugCommands = []struct {
+	name  string
+	usage string
+	run   func(sh *debugShell, args []string) error
+}{
+	{"sb", "sb [AG]              print the superblock", (*debugShell).sb},
+	{"agf", "agf [AG]             print the AGF", (*debugShell).agf},
+	{"agi", "agi [AG]             print the AGI", (*debugShell).agi},
+	{"inode", "inode INO            print the inode core and select the inode", (*debugShell).inode},
+	{"block", "block FSBNO          select the file system block", (*debugShell).block},
+	{"dir", "dir INO              list the on-disk entries of a directory inode", (*debugShell).dir},
+	{"bmap", "bmap INO             print the data fork extents of an inode", (*debugShell).bmap},
+	{"hexdump", "hexdump [OFF [LEN]]  hexdump the selected inode or block", (*debugShell).hexdump},
+}
+
+func runDebug(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("debug", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	sh := &debugShell{img: img, stdout: stdout}
+	scanner := bufio.NewScanner(stdin)
+	for {
+		// the prompt goes to stderr, so the output of piped commands stays clean
+		fmt.Fprint(stderr, "xfs> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(stderr)
+			return scanner.Err()
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return nil
+		}
+		if err := sh.exec(args); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", args[0], err)
+		}
+	}
+}
+
+func (sh *debugShell) exec(args []string) error {
+	if args[0] == "help" {
+		for _, c := range debugCommands {
+			fmt.Fprintf(sh.stdout, "%s\n", c.usage)
+		}
+		fmt.Fprintln(sh.stdout, "quit                 leave the shell")
+		return nil
+	}
+	for _, c := range debugCommands {
+		if c.name == args[0] {
+			return c.run(sh, args[1:])
+		}
+	}
+	return xerrors.New("unknown command, try help")
+}
+
+// number parses the only argument, numbers may be given in hex with 0x
+func number(args []string) (uint64, error) {
+	if len(args) != 1 {
+		return 0, xerrors.New("expected one number")
+	}
+	return strconv.ParseUint(args[0], 0, 64)
+}
+
+// agNumber parses an optional AG number, it defaults to AG 0
+func (sh *debugShell) agNumber(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	n, err := number(args)
+	if err != nil {
+		return 0, err
+	}
+	if n >= uint64(len(sh.img.AGs)) {
+		return 0, xerrors.Errorf("AG %d does not exist, the file system has %d AGs", n, len(sh.img.AGs))
+	}
+	return int(n), nil
+}
+
+func (sh *debugShell) sb(args []string) error {
+	n, err := sh.agNumber(args)
+	if err != nil {
+		return err
+	}
+	printFields(sh.stdout, headerFields(sh.img.AGs[n].SuperBlock))
+	return nil
+}
+
+func (sh *debugShell) agf(args []string) error {
+	n, err := sh.agNumber(args)
+	if err != nil {
+		return err
+	}
+	printFields(sh.stdout, headerFields(sh.img.AGs[n].Agf))
+	return nil
+}
+
+func (sh *debugShell) agi(args []string) error {
+	n, err := sh.agNumber(args)
+	if err != nil {
+		return err
+	}
+	printFields(sh.stdout, headerFields(sh.img.AGs[n].Agi))
+	return nil
+}
+
+func (sh *debugShell) inode(args []string) error {
+	ino, err := number(args)
+	if err != nil {
+		return err
+	}
+	// select the raw inode first, so corrupted inodes can still be dumped
+	b, err := sh.img.ReadRawInode(ino)
+	if err != nil {
+		return err
+	}
+	sh.cur, sh.curName = b, fmt.Sprintf("inode %d", ino)
+	st, err := statInode(sh.img, fmt.Sprintf("ino:%d", ino), false)
+	if err != nil {
+		return err
+	}
+	printStat(sh.stdout, st)
+	return nil
+}
+
+func (sh *debugShell) block(args []string) error {
+	n, err := number(args)
+	if err != nil {
+		return err
+	}
+	b, err := sh.img.ReadBlock(n)
+	if err != nil {
+		return err
+	}
+	sh.cur, sh.curName = b, fmt.Sprintf("block %d", n)
+	sb := sh.img.PrimaryAG.SuperBlock
+	fmt.Fprintf(sh.stdout, "block %d: AG %d, AG block %d, offset 0x%x, magic 0x%x\n", n, sb.BlockToAgNumber(n),
+		sb.BlockToAgBlockNumber(n), sb.BlockToPhysicalOffset(n)*int64(sb.BlockSize), b[:4])
+	return nil
+}
+
+func (sh *debugShell) dir(args []string) error {
+	ino, err := number(args)
+	if err != nil {
+		return err
+	}
+	entries, err := sh.img.RawReadDirInode(ino)
+	for _, entry := range entries {
+		fmt.Fprintf(sh.stdout, "%10d %s\n", entry.InodeNumber(), entry.Name())
+	}
+	return err
+}
+
+func (sh *debugShell) bmap(args []string) error {
+	ino, err := number(args)
+	if err != nil {
+		return err
+	}
+	info, err := sh.img.InodeInfo(ino)
+	if err != nil {
+		return err
+	}
+	for _, e := range info.Extents() {
+		fmt.Fprintf(sh.stdout, "[%d] block %d count %d\n", e.StartOff, e.StartBlock, e.BlockCount)
+	}
+	return nil
+}
+
+func (sh *debugShell) hexdump(args []string) error {
+	if sh.cur == nil {
+		return xerrors.New("no inode or block selected")
+	}
+	if len(args) > 2 {
+		return xerrors.New("expected an offset and a length")
+	}
+	off, length := uint64(0), uint64(len(sh.cur))
+	var err error
+	if len(args) > 0 {
+		if off, err = strconv.ParseUint(args[0], 0, 64); err != nil {
+			return err
+		}
+		if off > uint64(len(sh.cur)) {
+			return xerrors.Errorf("offset %d exceeds %s of %d bytes", off, sh.curName, len(sh.cur))
+		}
+		length -= off
+	}
+	if len(args) > 1 {
+		n, err := strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			return err
+		}
+		if n < length {
+			length = n
+		}
+	}
+	fmt.Fprintf(sh.stdout, "%s:\n", sh.curName)
+	hexdump(sh.stdout, sh.cur[off:off+length], off)
+	return nil
+}
+
+// hexdump writes b in the format of hexdump -C, offsets start at base
+func hexdump(w io.Writer, b []byte, base uint64) {
+	for i := 0; i < len(b); i += 16 {
+		line := b[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		var hexCols, text strings.Builder
+		for j := 0; j < 16; j++ {
+			if j == 8 {
+				hexCols.WriteByte(' ')
+			}
+			if j >= len(line) {
+				hexCols.WriteString("   ")
+				continue
+			}
+			hexCols.WriteString(hex.EncodeToString(line[j:j+1]) + " ")
+			if line[j] >= 0x20 && line[j] < 0x7f {
+				text.WriteByte(line[j])
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "%08x  %s |%s|\n", base+uint64(i), hexCols.String(), text.String())
+	}
+}
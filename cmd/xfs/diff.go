@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// fileState is the part of a file, that diff compares
+type fileState struct {
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+	link  string
+}
+
+func runDiff(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("diff", stderr)
+	hash := flags.Bool("hash", false, "compare the sha256 of regular files of equal size")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	imgA, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer imgA.Close()
+	imgB, err := openImage(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer imgB.Close()
+
+	a, err := snapshot(imgA)
+	if err != nil {
+		return xerrors.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	b, err := snapshot(imgB)
+	if err != nil {
+		return xerrors.Errorf("%s: %w", flags.Arg(1), err)
+	}
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	differ := false
+	for _, name := range names {
+		sa, inA := a[name]
+		sb, inB := b[name]
+		switch {
+		case !inB:
+			fmt.Fprintf(stdout, "- %s\n", name)
+		case !inA:
+			fmt.Fprintf(stdout, "+ %s\n", name)
+		default:
+			changes := compareStates(sa, sb)
+			if len(changes) == 0 && *hash && sa.mode.IsRegular() && sb.mode.IsRegular() {
+				same, err := sameContent(imgA, imgB, name)
+				if err != nil {
+					return err
+				}
+				if !same {
+					changes = append(changes, "content")
+				}
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			fmt.Fprintf(stdout, "M %s (%s)\n", name, strings.Join(changes, ", "))
+		}
+		differ = true
+	}
+	if differ {
+		return xerrors.New("images differ")
+	}
+	return nil
+}
+
+// snapshot returns the state of every file in the image by path
+func snapshot(img *image) (map[string]fileState, error) {
+	states := map[string]fileState{}
+	err := fs.WalkDir(img, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s := fileState{mode: info.Mode(), size: info.Size(), mtime: info.ModTime()}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if s.link, err = img.ReadLink(name); err != nil {
+				return err
+			}
+		}
+		states[name] = s
+		return nil
+	})
+	return states, err
+}
+
+func compareStates(a, b fileState) []string {
+	var changes []string
+	if a.mode.Type() != b.mode.Type() {
+		return []string{"type"}
+	}
+	if a.mode != b.mode {
+		changes = append(changes, "mode")
+	}
+	// the size of directories depends on their format, not only the entries
+	if a.size != b.size && !a.mode.IsDir() {
+		changes = append(changes, "size")
+	}
+	if !a.mtime.Equal(b.mtime) {
+		changes = append(changes, "mtime")
+	}
+	if a.link != b.link {
+		changes = append(changes, "target")
+	}
+	return changes
+}
+
+func sameContent(imgA, imgB *image, name string) (bool, error) {
+	sumA, err := hashFile(imgA, name, sha256.New())
+	if err != nil {
+		return false, err
+	}
+	sumB, err := hashFile(imgB, name, sha256.New())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sumA, sumB), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/qcow2"
+	"github.com/masahiro331/go-xfs-filesystem/vhd"
+	"github.com/masahiro331/go-xfs-filesystem/vmdk"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// openDisk returns the virtual disk of qcow2, VMDK and VHD images, and r
+// itself for raw images
+func openDisk(r xfs.SizeReaderAt) (xfs.SizeReaderAt, error) {
+	switch {
+	case qcow2.IsImage(r):
+		img, err := qcow2.Open(r)
+		if err != nil {
+			return nil, xerrors.Errorf("qcow2: %w", err)
+		}
+		return img, nil
+	case vmdk.IsImage(r):
+		img, err := vmdk.Open(r)
+		if err != nil {
+			return nil, xerrors.Errorf("vmdk: %w", err)
+		}
+		return img, nil
+	case vhd.IsImage(r):
+		img, err := vhd.Open(r)
+		if err != nil {
+			return nil, xerrors.Errorf("vhd: %w", err)
+		}
+		return img, nil
+	}
+	return r, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func runDu(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("du", stderr)
+	summary := flags.Bool("s", false, "print only the total of path")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	name := fsPath(flags.Arg(1))
+	info, err := img.Lstat(name)
+	if err != nil {
+		return err
+	}
+	d := du{
+		img:       img,
+		w:         stdout,
+		summary:   *summary,
+		blockSize: uint64(img.Info().BlockSize),
+		seen:      map[uint64]bool{},
+	}
+	fmt.Fprintln(stdout, "apparent\tallocated\tpath")
+	apparent, allocated, err := d.walk(name, info.(xfs.FileInfo))
+	if err != nil {
+		return err
+	}
+	if *summary || !info.IsDir() {
+		fmt.Fprintf(stdout, "%d\t%d\t%s\n", apparent, allocated, name)
+	}
+	return nil
+}
+
+type du struct {
+	img       *image
+	w         io.Writer
+	summary   bool
+	blockSize uint64
+
+	// seen holds the inodes already counted, so hard links count once
+	seen map[uint64]bool
+}
+
+// walk returns the apparent size and the allocated bytes of name and all
+// inodes below it, that are not counted yet
+func (d *du) walk(name string, info xfs.FileInfo) (apparent, allocated uint64, err error) {
+	if !d.seen[info.Ino()] {
+		d.seen[info.Ino()] = true
+		apparent = uint64(info.Size())
+		allocated = info.Sys().(*xfs.InodeCore).Nblocks * d.blockSize
+	}
+	if !info.IsDir() {
+		return apparent, allocated, nil
+	}
+
+	entries, err := d.img.ReadDir(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		child, err := entry.Info()
+		if err != nil {
+			return 0, 0, err
+		}
+		a, b, err := d.walk(path.Join(name, entry.Name()), child.(xfs.FileInfo))
+		if err != nil {
+			return 0, 0, err
+		}
+		apparent += a
+		allocated += b
+	}
+	if !d.summary {
+		fmt.Fprintf(d.w, "%d\t%d\t%s\n", apparent, allocated, name)
+	}
+	return apparent, allocated, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// btreeV4Header is the short form btree block header of v4 file systems, v5
+// blocks have the xfs.BtreeShortBlock header
+type btreeV4Header struct {
+	Magicnum uint32
+	Level    uint16
+	Numrecs  uint16
+	Leftsib  uint32
+	Rightsib uint32
+}
+
+// blockStructures names the structures identified by the magic number at the
+// start of a block
+var blockStructures = map[uint32]string{
+	xfs.XFS_SB_MAGIC:         "superblock",
+	xfs.XFS_AGF_MAGIC:        "agf",
+	xfs.XFS_AGI_MAGIC:        "agi",
+	xfs.XFS_AGFL_MAGIC:       "agfl",
+	xfs.XFS_SYMLINK_MAGIC:    "remote symlink",
+	xfs.XFS_ABTB_MAGIC:       "free space by block btree",
+	xfs.XFS_ABTB_CRC_MAGIC:   "free space by block btree",
+	xfs.XFS_ABTC_MAGIC:       "free space by count btree",
+	xfs.XFS_ABTC_CRC_MAGIC:   "free space by count btree",
+	xfs.XFS_IBT_MAGIC:        "inode btree",
+	xfs.XFS_IBT_CRC_MAGIC:    "inode btree",
+	xfs.XFS_FIBT_MAGIC:       "free inode btree",
+	xfs.XFS_FIBT_CRC_MAGIC:   "free inode btree",
+	xfs.XFS_BMAP_MAGICa:      "block map btree",
+	xfs.XFS_BMAP_CRC_MAGIC:   "block map btree",
+	xfs.XFS_DIR2_BLOCK_MAGIC: "directory block",
+	xfs.XFS_DIR3_BLOCK_MAGIC: "directory block",
+	xfs.XFS_DIR2_DATA_MAGIC:  "directory data block",
+	xfs.XFS_DIR3_DATA_MAGIC:  "directory data block",
+	xfs.XFS_DIR2_FREE_MAGIC:  "directory free block",
+	xfs.XFS_DIR3_FREE_MAGIC:  "directory free block",
+	xfs.XFS_ATTR3_RMT_MAGIC:  "remote attribute value",
+	xfs.XFS_RMAP_CRC_MAGIC:   "reverse mapping btree",
+	xfs.XFS_REFC_CRC_MAGIC:   "reference count btree",
+}
+
+// daStructures names the structures with a 16 bit magic number in the
+// da_blkinfo header at offset 8
+var daStructures = map[uint16]string{
+	xfs.XFS_DA_NODE_MAGIC:    "directory or attribute node",
+	xfs.XFS_DA3_NODE_MAGIC:   "directory or attribute node",
+	xfs.XFS_DIR2_LEAF1_MAGIC: "directory leaf",
+	xfs.XFS_DIR3_LEAF1_MAGIC: "directory leaf",
+	xfs.XFS_DIR2_LEAFN_MAGIC: "directory leafn",
+	xfs.XFS_DIR3_LEAFN_MAGIC: "directory leafn",
+	xfs.XFS_ATTR_LEAF_MAGIC:  "attribute leaf",
+	xfs.XFS_ATTR3_LEAF_MAGIC: "attribute leaf",
+}
+
+var btreeMagics = map[uint32]bool{
+	xfs.XFS_ABTB_MAGIC: true, xfs.XFS_ABTB_CRC_MAGIC: true,
+	xfs.XFS_ABTC_MAGIC: true, xfs.XFS_ABTC_CRC_MAGIC: true,
+	xfs.XFS_IBT_MAGIC: true, xfs.XFS_IBT_CRC_MAGIC: true,
+	xfs.XFS_FIBT_MAGIC: true, xfs.XFS_FIBT_CRC_MAGIC: true,
+	xfs.XFS_RMAP_CRC_MAGIC: true, xfs.XFS_REFC_CRC_MAGIC: true,
+}
+
+func runDump(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("dump", stderr)
+	block := flags.String("block", "", "dump the file system block `N`")
+	ino := flags.String("ino", "", "dump the inode `N`")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || (*block == "") == (*ino == "") {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	if *ino != "" {
+		n, err := number([]string{*ino})
+		if err != nil {
+			return errUsage
+		}
+		b, err := img.ReadRawInode(n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "inode %d, offset 0x%x:\n", n, img.PrimaryAG.SuperBlock.InodeAbsOffset(n))
+		// the raw bytes are the point of dump, the decoded view is best effort
+		if st, err := statInode(img, fmt.Sprintf("ino:%d", n), false); err != nil {
+			fmt.Fprintf(stdout, "decode failed: %v\n", err)
+		} else {
+			printStat(stdout, st)
+		}
+		fmt.Fprintln(stdout)
+		hexdump(stdout, b, 0)
+		return nil
+	}
+
+	n, err := number([]string{*block})
+	if err != nil {
+		return errUsage
+	}
+	b, err := img.ReadBlock(n)
+	if err != nil {
+		return err
+	}
+	sb := img.PrimaryAG.SuperBlock
+	fmt.Fprintf(stdout, "block %d: AG %d, AG block %d, offset 0x%x\n", n, sb.BlockToAgNumber(n),
+		sb.BlockToAgBlockNumber(n), sb.BlockToPhysicalOffset(n)*int64(sb.BlockSize))
+	decodeBlock(stdout, b, sb.IsV5())
+	fmt.Fprintln(stdout)
+	hexdump(stdout, b, 0)
+	return nil
+}
+
+// decodeBlock prints the structure identified by the magic number of b and
+// its header, when it is known
+func decodeBlock(w io.Writer, b []byte, v5 bool) {
+	magic := binary.BigEndian.Uint32(b)
+	name, ok := blockStructures[magic]
+	if !ok {
+		name, ok = daStructures[binary.BigEndian.Uint16(b[8:])]
+	}
+	if !ok && binary.BigEndian.Uint16(b) == xfs.XFS_DINODE_MAGIC {
+		name, ok = "inode cluster", true
+	}
+	if !ok {
+		fmt.Fprintf(w, "structure: unknown, magic 0x%x\n", b[:4])
+		return
+	}
+	fmt.Fprintf(w, "structure: %s\n", name)
+
+	var header interface{}
+	switch {
+	case magic == xfs.XFS_SB_MAGIC:
+		header = &xfs.SuperBlock{}
+	case magic == xfs.XFS_AGF_MAGIC:
+		header = &xfs.AGF{}
+	case magic == xfs.XFS_AGI_MAGIC:
+		header = &xfs.AGI{}
+	case btreeMagics[magic] && v5:
+		header = &xfs.BtreeShortBlock{}
+	case btreeMagics[magic]:
+		header = &btreeV4Header{}
+	default:
+		return
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, header); err != nil {
+		fmt.Fprintf(w, "decode failed: %v\n", err)
+		return
+	}
+	printFields(w, headerFields(header))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// extractMode is the part of the mode, that extract preserves
+const extractMode = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+func runExtract(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("extract", stderr)
+	owner := flags.Bool("owner", false, "preserve uid and gid, usually requires root")
+	xattrs := flags.Bool("xattrs", false, "restore the extended attributes of files and directories, security and trusted ones usually require root")
+	follow := followFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	name := fsPath(flags.Arg(1))
+	src, err := resolvePath(img, name, *follow)
+	if err != nil {
+		return err
+	}
+	e := extractor{img: img, stderr: stderr, owner: *owner, xattrs: *xattrs, base: path.Base(name)}
+	return e.extract(src, flags.Arg(2))
+}
+
+type extractor struct {
+	img    *image
+	stderr io.Writer
+	owner  bool
+	xattrs bool
+	// base is the name of the copy of src, it differs from the base name of
+	// src when a symlink was followed
+	base string
+}
+
+// extract copies src into the directory dst, the contents of the root are
+// copied to dst itself.
+func (e extractor) extract(src, dst string) error {
+	if info, err := os.Stat(dst); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return xerrors.Errorf("%s is not a directory", dst)
+	}
+
+	// directories are made writable while their contents are extracted, and
+	// get their mode and times after that
+	var dirs []string
+	var infos []fs.FileInfo
+	err := fs.WalkDir(e.img, src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := name
+		if src != "." {
+			rel, err = filepath.Rel(src, name)
+			if err != nil {
+				return err
+			}
+			rel = filepath.Join(e.base, rel)
+		}
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if !within(dst, target) {
+			return xerrors.Errorf("%s is outside of %s", target, dst)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if name != "." {
+				if err := os.Mkdir(target, 0700); err != nil {
+					return err
+				}
+			}
+			if err := e.setXattrs(name, target); err != nil {
+				return err
+			}
+			dirs = append(dirs, target)
+			infos = append(infos, info)
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := e.img.ReadLink(name)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return e.chown(target, info)
+		case d.Type().IsRegular():
+			if err := e.extractFile(name, target); err != nil {
+				return err
+			}
+			if err := e.setXattrs(name, target); err != nil {
+				return err
+			}
+			return e.setAttributes(target, info)
+		default:
+			fmt.Fprintf(e.stderr, "xfs extract: skipping %s: unsupported file type %s\n", name, d.Type())
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := e.setAttributes(dirs[i], infos[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// within reports whether target is dst or a path below it
+func within(dst, target string) bool {
+	rel, err := filepath.Rel(dst, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (e extractor) extractFile(name, target string) error {
+	r, err := e.img.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// O_EXCL keeps a crafted image from writing through an extracted symlink
+	w, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return xerrors.Errorf("failed to extract %s: %w", name, err)
+	}
+	return w.Close()
+}
+
+func (e extractor) setAttributes(target string, info fs.FileInfo) error {
+	if err := e.chown(target, info); err != nil {
+		return err
+	}
+	if err := os.Chmod(target, info.Mode()&extractMode); err != nil {
+		return err
+	}
+	atime := info.ModTime()
+	if i, ok := info.(xfs.FileInfo); ok {
+		atime = i.AccessTime()
+	}
+	return os.Chtimes(target, atime, info.ModTime())
+}
+
+// setXattrs copies the extended attributes of name to target with --xattrs,
+// while target is still writable. Symlinks keep none, Linux refuses user
+// attributes on them.
+func (e extractor) setXattrs(name, target string) error {
+	if !e.xattrs {
+		return nil
+	}
+	xattrs, err := e.img.ListXattrs(name)
+	if err != nil {
+		return err
+	}
+	for _, x := range xattrs {
+		if err := lsetxattr(target, x.Name, x.Value); err != nil {
+			return xerrors.Errorf("failed to set %s of %s: %w", x.Name, target, err)
+		}
+	}
+	return nil
+}
+
+func (e extractor) chown(target string, info fs.FileInfo) error {
+	if !e.owner {
+		return nil
+	}
+	core, ok := info.Sys().(*xfs.InodeCore)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(target, int(core.UID), int(core.GID))
+}
@@ -0,0 +1,9 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// lsetxattr sets the extended attribute name of path, without following a
+// symlink
+func lsetxattr(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestExtractXattrs(t *testing.T) {
+	const label = "unconfined_u:object_r:unlabeled_t:s0\x00"
+	dst := t.TempDir()
+	// security attributes need root, or a label SELinux accepts
+	probe := filepath.Join(dst, "probe")
+	if err := os.WriteFile(probe, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Lsetxattr(probe, "security.selinux", []byte(label), 0); err != nil {
+		t.Skipf("cannot set security.selinux: %v", err)
+	}
+
+	if _, stderr, code := runCommand(t, "extract", "--xattrs", testImage, "etc/os-release", dst); code != 0 {
+		t.Fatalf("extract: %s", stderr)
+	}
+	b := make([]byte, 256)
+	n, err := unix.Lgetxattr(filepath.Join(dst, "os-release"), "security.selinux", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != label {
+		t.Errorf("unexpected label %q", b[:n])
+	}
+
+	// without --xattrs the attributes are not copied
+	dst = t.TempDir()
+	if _, stderr, code := runCommand(t, "extract", testImage, "etc/os-release", dst); code != 0 {
+		t.Fatalf("extract: %s", stderr)
+	}
+	if _, err := unix.Lgetxattr(filepath.Join(dst, "os-release"), "security.selinux", b); err != unix.ENODATA {
+		t.Errorf("expected %v, actual %v", unix.ENODATA, err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
+
+func lsetxattr(path, name string, value []byte) error {
+	return xerrors.Errorf("extended attributes are not supported on %s", runtime.GOOS)
+}
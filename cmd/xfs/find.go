@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+var fileTypes = map[string]fs.FileMode{
+	"f": 0,
+	"d": fs.ModeDir,
+	"l": fs.ModeSymlink,
+	"p": fs.ModeNamedPipe,
+	"s": fs.ModeSocket,
+	"c": fs.ModeDevice | fs.ModeCharDevice,
+	"b": fs.ModeDevice,
+}
+
+func runFind(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the filters
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("find", stderr)
+	var f filter
+	flags.StringVar(&f.name, "name", "", "match base names against the `glob`")
+	fileType := flags.String("type", "", "match the file `type`, one of f, d, l, p, s, c, b")
+	newer := flags.String("newer-than", "", "match files modified after `T`, an RFC 3339 time, a date or a duration before now")
+	size := flags.String("size", "", "match sizes of `[+-]N[kMG]` bytes, + means greater and - less than N")
+	perm := flags.String("perm", "", "match permission bits `[/-]MODE` in octal, / means any and - all of MODE")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	if err := f.parse(*fileType, *newer, *size, *perm, time.Now()); err != nil {
+		fmt.Fprintf(stderr, "xfs find: %v\n", err)
+		return errUsage
+	}
+
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	var walkErr error
+	root := fsPath(strings.Join(positional[1:], ""))
+	err = fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			// report unreadable directories and carry on like find(1)
+			fmt.Fprintf(stderr, "xfs find: %v\n", err)
+			walkErr = xerrors.New("some directories could not be read")
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if f.match(info) {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// filter holds the find conditions, all of which have to match
+type filter struct {
+	name string
+
+	hasType  bool
+	fileType fs.FileMode
+
+	newerThan time.Time
+
+	sizeCmp int
+	size    int64
+
+	permCmp byte
+	perm    uint16
+}
+
+func (f *filter) parse(fileType, newer, size, perm string, now time.Time) error {
+	if f.name != "" {
+		if _, err := path.Match(f.name, ""); err != nil {
+			return xerrors.Errorf("invalid --name %q: %w", f.name, err)
+		}
+	}
+
+	if fileType != "" {
+		t, ok := fileTypes[fileType]
+		if !ok {
+			return xerrors.Errorf("invalid --type %q", fileType)
+		}
+		f.hasType, f.fileType = true, t
+	}
+
+	if newer != "" {
+		t, err := parseTime(newer, now)
+		if err != nil {
+			return xerrors.Errorf("invalid --newer-than %q: %w", newer, err)
+		}
+		f.newerThan = t
+	}
+
+	if size != "" {
+		if size[0] == '+' || size[0] == '-' {
+			f.sizeCmp = 1
+			if size[0] == '-' {
+				f.sizeCmp = -1
+			}
+			size = size[1:]
+		}
+		n, err := parseSize(size)
+		if err != nil {
+			return xerrors.Errorf("invalid --size: %w", err)
+		}
+		f.size = n
+	} else {
+		f.size = -1
+	}
+
+	if perm != "" {
+		if perm[0] == '/' || perm[0] == '-' {
+			f.permCmp = perm[0]
+			perm = perm[1:]
+		} else {
+			f.permCmp = '='
+		}
+		p, err := strconv.ParseUint(perm, 8, 12)
+		if err != nil {
+			return xerrors.Errorf("invalid --perm: %w", err)
+		}
+		f.perm = uint16(p)
+	}
+	return nil
+}
+
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, xerrors.New("expected an RFC 3339 time, a date or a duration")
+	}
+	return now.Add(-d), nil
+}
+
+func parseSize(s string) (int64, error) {
+	unit := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, xerrors.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+func (f *filter) match(info fs.FileInfo) bool {
+	if f.name != "" {
+		if ok, _ := path.Match(f.name, info.Name()); !ok {
+			return false
+		}
+	}
+	if f.hasType && info.Mode().Type() != f.fileType {
+		return false
+	}
+	if !f.newerThan.IsZero() && !info.ModTime().After(f.newerThan) {
+		return false
+	}
+	if f.size >= 0 {
+		switch {
+		case f.sizeCmp > 0 && info.Size() <= f.size,
+			f.sizeCmp < 0 && info.Size() >= f.size,
+			f.sizeCmp == 0 && info.Size() != f.size:
+			return false
+		}
+	}
+	if f.permCmp != 0 {
+		var mode uint16
+		if core, ok := info.Sys().(*xfs.InodeCore); ok {
+			mode = core.Mode & 07777
+		}
+		switch {
+		case f.permCmp == '/' && mode&f.perm == 0,
+			f.permCmp == '-' && mode&f.perm != f.perm,
+			f.permCmp == '=' && mode != f.perm:
+			return false
+		}
+	}
+	return true
+}
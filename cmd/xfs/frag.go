@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func runFrag(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("frag", stderr)
+	verbose := flags.Bool("v", false, "print the extents of every file with more than one")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	var files, actual, ideal, most uint64
+	var mostPath string
+	// bucket i counts the files with 2^i to 2^(i+1)-1 extents
+	var histogram [65]uint64
+	// seen holds the inodes already counted, so hard links count once
+	seen := map[uint64]bool{}
+	if *verbose {
+		fmt.Fprintln(stdout, "extents\tideal\tpath")
+	}
+	var walkErr error
+	root := fsPath(strings.Join(positional[1:], ""))
+	err = fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			fmt.Fprintf(stderr, "xfs frag: %v\n", err)
+			walkErr = xerrors.New("some directories could not be read")
+			return nil
+		}
+		if !d.Type().IsRegular() && !d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fi := xfsInfo(info)
+		if seen[fi.Ino()] {
+			return nil
+		}
+		seen[fi.Ino()] = true
+		extents := fi.Extents()
+		if len(extents) == 0 {
+			return nil
+		}
+
+		n, best := uint64(len(extents)), idealExtents(extents)
+		files++
+		actual += n
+		ideal += best
+		histogram[bits.Len64(n)-1]++
+		if n > most {
+			most, mostPath = n, name
+		}
+		if *verbose && n > 1 {
+			fmt.Fprintf(stdout, "%d\t%d\t%s\n", n, best, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		fmt.Fprintln(stdout)
+	}
+
+	// the fragmentation factor of xfs_db frag
+	factor := 0.0
+	if actual > 0 {
+		factor = float64(actual-ideal) * 100 / float64(actual)
+	}
+	fmt.Fprintln(stdout, "files\textents\tideal\tfactor\tmost\tpath")
+	fmt.Fprintf(stdout, "%d\t%d\t%d\t%.2f%%\t%d\t%s\n", files, actual, ideal, factor, most, mostPath)
+
+	fmt.Fprintln(stdout)
+	fmt.Fprintln(stdout, "from\tto\tfiles")
+	for i, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "%d\t%d\t%d\n", uint64(1)<<i, uint64(1)<<(i+1)-1, count)
+	}
+	return walkErr
+}
+
+// idealExtents returns the number of extents left when the extents that
+// continue the previous one both in the file and on disk are merged
+func idealExtents(extents []xfs.BmbtIrec) uint64 {
+	n := uint64(0)
+	for i, e := range extents {
+		if i > 0 {
+			prev := extents[i-1]
+			if prev.StartOff+prev.BlockCount == e.StartOff && prev.StartBlock+prev.BlockCount == e.StartBlock {
+				continue
+			}
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+func runFreesp(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("freesp", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	extents, err := img.FreeExtents()
+	if err != nil {
+		return err
+	}
+
+	// per AG totals, AGs without free space are listed too
+	type agSummary struct {
+		extents, blocks uint64
+		longest         uint32
+	}
+	ags := make([]agSummary, len(img.AGs))
+	var total agSummary
+	// bucket i holds the extents of 2^i to 2^(i+1)-1 blocks, as xfs_db
+	// freesp does by default
+	var histogram [33]agSummary
+	for _, e := range extents {
+		for _, s := range []*agSummary{&ags[e.AG], &total, &histogram[bits.Len32(e.Count)-1]} {
+			s.extents++
+			s.blocks += uint64(e.Count)
+			if e.Count > s.longest {
+				s.longest = e.Count
+			}
+		}
+	}
+
+	fmt.Fprintln(stdout, "ag\textents\tblocks\tlongest")
+	for i, s := range ags {
+		fmt.Fprintf(stdout, "%d\t%d\t%d\t%d\n", i, s.extents, s.blocks, s.longest)
+	}
+	fmt.Fprintf(stdout, "total\t%d\t%d\t%d\n", total.extents, total.blocks, total.longest)
+
+	fmt.Fprintln(stdout)
+	fmt.Fprintln(stdout, "from\tto\textents\tblocks\tpct")
+	for i, s := range histogram {
+		if s.extents == 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "%d\t%d\t%d\t%d\t%.2f\n", uint64(1)<<i, uint64(1)<<(i+1)-1, s.extents, s.blocks,
+			float64(s.blocks)*100/float64(total.blocks))
+	}
+	return nil
+}
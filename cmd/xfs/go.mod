@@ -0,0 +1,32 @@
+module github.com/masahiro331/go-xfs-filesystem/cmd/xfs
+
+go 1.18
+
+require (
+	github.com/hanwen/go-fuse/v2 v2.7.2
+	github.com/masahiro331/go-xfs-filesystem v0.0.0-00010101000000-000000000000
+	github.com/masahiro331/go-xfs-filesystem/xfsfuse v0.0.0-00010101000000-000000000000
+	github.com/masahiro331/go-xfs-filesystem/xfsnfs v0.0.0-00010101000000-000000000000
+	go.uber.org/zap v1.23.0
+	golang.org/x/sys v0.24.0
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+)
+
+require (
+	github.com/go-git/go-billy/v5 v5.6.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/masahiro331/go-xfs-filesystem/xfsbilly v0.0.0-00010101000000-000000000000 // indirect
+	github.com/rasky/go-xdr v0.0.0-20170124162913-1a41d1a06c93 // indirect
+	github.com/willscott/go-nfs v0.0.3 // indirect
+	github.com/willscott/go-nfs-client v0.0.0-20240104095149-b44639837b00 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
+
+replace (
+	github.com/masahiro331/go-xfs-filesystem => ../../
+	github.com/masahiro331/go-xfs-filesystem/xfsbilly => ../../xfsbilly
+	github.com/masahiro331/go-xfs-filesystem/xfsfuse => ../../xfsfuse
+	github.com/masahiro331/go-xfs-filesystem/xfsnfs => ../../xfsnfs
+)
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// maxLineSize is the longest line grep matches, longer lines are split
+const maxLineSize = 1 << 20
+
+func runGrep(args []string, stdout, stderr io.Writer) error {
+	// the image, pattern and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("grep", stderr)
+	var f filter
+	flags.StringVar(&f.name, "name", "", "search only files whose base names match the `glob`")
+	size := flags.String("size", "", "search only files with sizes of `[+-]N[kMG]` bytes")
+	ignoreCase := flags.Bool("i", false, "ignore case")
+	filesOnly := flags.Bool("l", false, "print only the paths of matching files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 2 || len(positional) > 3 {
+		return errUsage
+	}
+	// only regular files have content to search
+	if err := f.parse("f", "", *size, "", time.Now()); err != nil {
+		fmt.Fprintf(stderr, "xfs grep: %v\n", err)
+		return errUsage
+	}
+	pattern := positional[1]
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Fprintf(stderr, "xfs grep: invalid pattern: %v\n", err)
+		return errUsage
+	}
+
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	var walkErr error
+	root := fsPath(strings.Join(positional[2:], ""))
+	err = fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			fmt.Fprintf(stderr, "xfs grep: %v\n", err)
+			walkErr = xerrors.New("some files could not be read")
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !f.match(info) {
+			return nil
+		}
+		if err := grepFile(img, name, re, *filesOnly, stdout); err != nil {
+			fmt.Fprintf(stderr, "xfs grep: %v\n", err)
+			walkErr = xerrors.New("some files could not be read")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// grepFile streams the content of name through re and prints the matching
+// lines as path:line:text. Like grep(1) a file with a NUL byte in a matching
+// line is reported as binary.
+func grepFile(img *image, name string, re *regexp.Regexp, filesOnly bool, w io.Writer) error {
+	f, err := img.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	scanner.Split(scanLines)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if !re.Match(line) {
+			continue
+		}
+		switch {
+		case filesOnly:
+			fmt.Fprintln(w, name)
+			return nil
+		case bytes.IndexByte(line, 0) >= 0:
+			fmt.Fprintf(w, "Binary file %s matches\n", name)
+			return nil
+		}
+		fmt.Fprintf(w, "%s:%d:%s\n", name, n, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return xerrors.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// scanLines is bufio.ScanLines, which splits lines longer than maxLineSize
+// instead of failing
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= maxLineSize {
+		return len(data), data, nil
+	}
+	return advance, token, err
+}
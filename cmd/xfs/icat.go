@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"strconv"
+)
+
+func runIcat(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("icat", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	ino, err := strconv.ParseUint(flags.Arg(1), 0, 64)
+	if err != nil {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	f, err := img.OpenInode(ino)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(stdout, f)
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfsoci"
+)
+
+func runLayer(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	var opts xfsoci.LayerOptions
+	flags := newFlagSet("layer", stderr)
+	gzipOutput := flags.Bool("gzip", false, "compress the layer with gzip")
+	flags.Func("uid-map", "map the owners `FROM:TO[:COUNT]`, may be repeated", func(s string) error {
+		m, err := xfsoci.ParseIDMap(s)
+		opts.UIDMaps = append(opts.UIDMaps, m)
+		return err
+	})
+	flags.Func("gid-map", "map the groups `FROM:TO[:COUNT]`, may be repeated", func(s string) error {
+		m, err := xfsoci.ParseIDMap(s)
+		opts.GIDMaps = append(opts.GIDMaps, m)
+		return err
+	})
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	w := stdout
+	if *gzipOutput {
+		zw := gzip.NewWriter(stdout)
+		defer zw.Close()
+		w = zw
+	}
+	diffID, err := xfsoci.WriteLayer(w, img.FileSystem, fsPath(strings.Join(positional[1:], "")), opts)
+	if err != nil {
+		return err
+	}
+	// the diff ID goes into rootfs.diff_ids of the image config
+	fmt.Fprintf(stderr, "diff ID %s\n", diffID)
+	return nil
+}
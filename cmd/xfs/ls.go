@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func runLs(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("ls", stderr)
+	long := flags.Bool("l", false, "use a long listing format")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	name := fsPath(flags.Arg(1))
+	info, err := img.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		printEntry(stdout, info, *long)
+		return nil
+	}
+	entries, err := img.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		printEntry(stdout, info, *long)
+	}
+	return nil
+}
+
+func printEntry(w io.Writer, info fs.FileInfo, long bool) {
+	if !long {
+		fmt.Fprintln(w, info.Name())
+		return
+	}
+	var nlink, uid, gid uint32
+	if core, ok := info.Sys().(*xfs.InodeCore); ok {
+		nlink, uid, gid = core.NLink, core.UID, core.GID
+	}
+	fmt.Fprintf(w, "%s %3d %5d %5d %10d %s %s\n", info.Mode(), nlink, uid, gid, info.Size(),
+		info.ModTime().UTC().Format("2006-01-02 15:04"), path.Base(info.Name()))
+}
@@ -0,0 +1,271 @@
+// Command xfs inspects XFS images without mounting them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/device"
+	"github.com/masahiro331/go-xfs-filesystem/log"
+	"github.com/masahiro331/go-xfs-filesystem/lvm"
+	"github.com/masahiro331/go-xfs-filesystem/partition"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"ls", "ls [-l] <image> [path]", "list directory contents", runLs},
+	{"cat", "cat [-L] [--offset N] [--length N] <image> <path>", "write file content to stdout", runCat},
+	{"icat", "icat <image> <ino>", "write the content of a regular file by inode number to stdout", runIcat},
+	{"extract", "extract [-L] [--owner] [--xattrs] <image> <src-path> <dst-dir>", "copy a file or directory tree out of the image", runExtract},
+	{"stat", "stat [-L] [--json] <image> <path|ino:N>", "print the inode core", runStat},
+	{"tree", "tree [--max-depth N] <image> [path]", "print the directory tree", runTree},
+	{"du", "du [-s] <image> [path]", "print apparent and allocated sizes per directory", runDu},
+	{"find", "find <image> [path] [--name GLOB] [--type T] [--newer-than T] [--size [+-]N] [--perm [/-]MODE]", "print paths matching filters", runFind},
+	{"xattr", "xattr [--name ATTR] <image> <path>", "list extended attributes or print the value of one", runXattr},
+	{"sb", "sb <image> [--ag N] [--json]", "print the superblock and AG headers", runSb},
+	{"debug", "debug <image>", "explore inodes, blocks and directories interactively", runDebug},
+	{"dump", "dump (--block N | --ino N) <image>", "hexdump a block or an inode with a decoded view", runDump},
+	{"verify", "verify [--json] <image>", "check checksums and the directory tree, exit 1 on problems", runVerify},
+	{"tar", "tar <image> [path] [--gzip]", "write a tar archive of a directory tree to stdout", runTar},
+	{"zip", "zip <image> [path]", "write a zip archive of a directory tree to stdout", runZip},
+	{"layer", "layer <image> [path] [--gzip] [--uid-map FROM:TO[:COUNT]] [--gid-map FROM:TO[:COUNT]]", "write an OCI layer tarball of a directory tree to stdout", runLayer},
+	{"diff", "diff [--hash] <image-a> <image-b>", "list files added, removed or changed between two images", runDiff},
+	{"cmp", "cmp <image> <image-path> <local-dir>", "compare the type, mode, size and sha256 of files in the image with a local copy", runCmp},
+	{"recover", "recover <image> [--out DIR]", "list deleted inodes with surviving extents and write their data", runRecover},
+	{"orphans", "orphans <image>", "list the inodes on the AGI unlinked lists, open when the file system went down", runOrphans},
+	{"freesp", "freesp <image>", "summarize the free extents per AG and as a size histogram", runFreesp},
+	{"frag", "frag <image> [path] [-v]", "print extents per file statistics and the fragmentation factor", runFrag},
+	{"timeline", "timeline <image> [path] [--format bodyfile|csv]", "print the MACB timestamps of every inode for DFIR timelines", runTimeline},
+	{"grep", "grep <image> <pattern> [path] [-i] [-l] [--name GLOB] [--size [+-]N]", "search file contents for a regular expression", runGrep},
+	{"meta", "meta <image> [path] [--json]", "print path, inode, permissions, owners, times and xattrs of every file", runMeta},
+	{"sum", "sum <image> [path] [--algo sha256]", "print a sha256sum compatible manifest of every regular file", runSum},
+	{"mount", "mount [--debug] <image> <mountpoint>", "mount the image read-only with FUSE on Linux and macOS", runMount},
+	{"serve", "serve (http|nfs|9p) <image> [--addr ADDR]", "serve the image read-only over HTTP, NFSv3 or 9P", runServe},
+	{"parts", "parts <image>", "list the partitions of a whole disk image, commands accept --part N", runParts},
+}
+
+// errUsage is returned by commands, whose arguments are invalid
+var errUsage = xerrors.New("invalid usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	log.SetLogger(zap.NewNop().Sugar())
+	partNumber, lvName = 0, ""
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		return 2
+	}
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(args[1:], stdout, stderr)
+		switch {
+		case err == nil:
+			return 0
+		case xerrors.Is(err, errUsage):
+			fmt.Fprintf(stderr, "usage: xfs %s\n", c.usage)
+			return 2
+		case xerrors.Is(err, flag.ErrHelp):
+			return 2
+		default:
+			fmt.Fprintf(stderr, "xfs %s: %v\n", c.name, err)
+			return 1
+		}
+	}
+	fmt.Fprintf(stderr, "xfs: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: xfs <command> [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.IntVar(&partNumber, "part", 0, "open partition `N` of a whole disk image, by default the only or first XFS partition")
+	flags.StringVar(&lvName, "lv", "", "open logical volume `NAME` of an LVM2 physical volume, by default root or the first XFS one")
+	return flags
+}
+
+// partNumber and lvName are the partition and logical volume selected with
+// --part and --lv, which every command accepts
+var (
+	partNumber int
+	lvName     string
+)
+
+// image is an opened image file
+type image struct {
+	*xfs.FileSystem
+	f *device.Device
+}
+
+// openImage opens a file system image or a partition of a whole disk image,
+// raw or in a qcow2, VMDK or VHD image
+func openImage(name string) (*image, error) {
+	f, err := device.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	disk, err := openDisk(f)
+	if err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("failed to open %s: %w", name, err)
+	}
+	r, err := locateFileSystem(disk, disk.Size())
+	if err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("failed to open %s: %w", name, err)
+	}
+	fileSystem, err := xfs.OpenReaderAt(r, nil)
+	if err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("failed to open %s: %w", name, err)
+	}
+	return &image{FileSystem: fileSystem, f: f}, nil
+}
+
+// locateFileSystem returns the region of the file system, the whole file
+// unless it starts with a partition table or an LVM2 label instead of a
+// superblock, or a partition or logical volume is selected with --part or --lv
+func locateFileSystem(r io.ReaderAt, size int64) (xfs.SizeReaderAt, error) {
+	region := io.NewSectionReader(r, 0, size)
+	if partNumber == 0 && lvName == "" && xfs.Check(region) {
+		return region, nil
+	}
+	parts, err := partition.Read(r, size)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case parts != nil:
+		p, err := selectPartition(parts)
+		if err != nil {
+			return nil, err
+		}
+		region = p.SectionReader()
+	case partNumber != 0:
+		return nil, xerrors.New("no partition table found")
+	}
+	if (lvName != "" || !xfs.Check(region)) && lvm.IsPhysicalVolume(region) {
+		return openLogicalVolume(region)
+	}
+	if lvName != "" {
+		return nil, xerrors.New("no LVM2 physical volume found")
+	}
+	// NewFS reports the invalid superblock
+	return region, nil
+}
+
+// selectPartition returns the partition selected with --part, by default the
+// first XFS partition, or the first LVM2 physical volume without one or with
+// --lv
+func selectPartition(parts []partition.Partition) (partition.Partition, error) {
+	p, err := partition.Select(parts, partNumber)
+	if partNumber != 0 || err == nil && lvName == "" {
+		return p, err
+	}
+	for _, p := range parts {
+		if lvm.IsPhysicalVolume(p.SectionReader()) {
+			return p, nil
+		}
+	}
+	if lvName != "" {
+		return partition.Partition{}, xerrors.New("no LVM2 physical volume found")
+	}
+	return partition.Partition{}, err
+}
+
+// openLogicalVolume returns the logical volume selected with --lv, by default
+// root or the first XFS one. Only the physical volume pv is read, logical
+// volumes with extents on others fail to read.
+func openLogicalVolume(pv xfs.SizeReaderAt) (xfs.SizeReaderAt, error) {
+	vg, err := lvm.Read(pv)
+	if err != nil {
+		return nil, err
+	}
+	if lvName != "" {
+		return vg.LogicalVolume(lvName)
+	}
+	var first *lvm.LogicalVolume
+	for _, lv := range vg.LogicalVolumes {
+		if !lv.Linear || !xfs.Check(io.NewSectionReader(lv, 0, lv.Size())) {
+			continue
+		}
+		if lv.Name == "root" {
+			return lv, nil
+		}
+		if first == nil {
+			first = lv
+		}
+	}
+	if first == nil {
+		return nil, xerrors.Errorf("no XFS logical volume found in %s", vg.Name)
+	}
+	return first, nil
+}
+
+func (img *image) Close() error {
+	return img.f.Close()
+}
+
+// fsPath converts a path in the image, which may be absolute, to an io/fs path
+func fsPath(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// followFlag adds --follow and its short form -L
+func followFlag(flags *flag.FlagSet) *bool {
+	follow := new(bool)
+	flags.BoolVar(follow, "follow", false, "follow symlinks on the path, including the last component")
+	flags.BoolVar(follow, "L", false, "short for --follow")
+	return follow
+}
+
+// resolvePath converts name with fsPath and follows the symlinks on it, when
+// follow is set. Targets are resolved inside the image, as in a chroot.
+func resolvePath(img *image, name string, follow bool) (string, error) {
+	name = fsPath(name)
+	if !follow {
+		return name, nil
+	}
+	return img.EvalSymlinks(name)
+}
+
+// xfsInfo returns the xfs.FileInfo behind info, the root of fs.WalkDir is
+// a *xfs.FileInfo returned by Stat of the opened directory
+func xfsInfo(info fs.FileInfo) xfs.FileInfo {
+	if i, ok := info.(*xfs.FileInfo); ok {
+		return *i
+	}
+	return info.(xfs.FileInfo)
+}
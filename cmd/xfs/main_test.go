@@ -0,0 +1,1279 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const testImage = "../../xfs/testdata/image.xfs"
+
+const osRelease = `NAME="CentOS Linux"
+VERSION="8"
+ID="centos"
+ID_LIKE="rhel fedora"
+VERSION_ID="8"
+PLATFORM_ID="platform:el8"
+PRETTY_NAME="CentOS Linux 8"
+ANSI_COLOR="0;31"
+CPE_NAME="cpe:/o:centos:centos:8"
+HOME_URL="https://centos.org/"
+BUG_REPORT_URL="https://bugs.centos.org/"
+CENTOS_MANTISBT_PROJECT="CentOS-8"
+CENTOS_MANTISBT_PROJECT_VERSION="8"
+`
+
+func runCommand(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			name:   "ls root",
+			args:   []string{"ls", testImage},
+			stdout: "fmt_local_directory\nfmt_extents_block_directories\nfmt_leaf_directories\nfmt_node_directories\nfmt_extents_file_1024\nfmt_extents_file_4096\nfmt_extents_file_16384\nparent\netc\n",
+		},
+		{
+			name:   "ls absolute path",
+			args:   []string{"ls", testImage, "/etc"},
+			stdout: "os-release\n",
+		},
+		{
+			name:   "ls long file",
+			args:   []string{"ls", "-l", testImage, "etc/os-release"},
+			stdout: "-rw-r--r--   1     0     0        333 2021-06-05 15:25 os-release\n",
+		},
+		{
+			name: "ls not exist",
+			args: []string{"ls", testImage, "missing"},
+			code: 1,
+		},
+		{
+			name: "ls without image",
+			args: []string{"ls"},
+			code: 2,
+		},
+		{
+			name:   "cat",
+			args:   []string{"cat", testImage, "/etc/os-release"},
+			stdout: osRelease,
+		},
+		{
+			name:   "cat range",
+			args:   []string{"cat", "--offset", "5", "--length", "12", testImage, "etc/os-release"},
+			stdout: osRelease[5:17],
+		},
+		{
+			name:   "cat offset beyond end",
+			args:   []string{"cat", "-offset", "1000", testImage, "etc/os-release"},
+			stdout: "",
+		},
+		{
+			name:   "icat",
+			args:   []string{"icat", testImage, "20453"},
+			stdout: osRelease,
+		},
+		{
+			name: "icat directory",
+			args: []string{"icat", testImage, "20452"},
+			code: 1,
+		},
+		{
+			name: "icat invalid inode",
+			args: []string{"icat", testImage, "etc/os-release"},
+			code: 2,
+		},
+		{
+			name:   "freesp",
+			args:   []string{"freesp", testImage},
+			stdout: "ag\textents\tblocks\tlongest\n0\t1\t2326\t2326\ntotal\t1\t2326\t2326\n\nfrom\tto\textents\tblocks\tpct\n2048\t4095\t1\t2326\t100.00\n",
+		},
+		{
+			name: "freesp extra argument",
+			args: []string{"freesp", testImage, "etc"},
+			code: 2,
+		},
+		{
+			name: "cat directory",
+			args: []string{"cat", testImage, "etc"},
+			code: 1,
+		},
+		{
+			name:   "tree",
+			args:   []string{"tree", testImage, "parent/child/child/child"},
+			stdout: "parent/child/child/child\n└── child\n    ├── child\n    │   └── executable\n    ├── executable\n    └── nonexecutable\n\n2 directories, 3 files\n",
+		},
+		{
+			name:   "tree max depth",
+			args:   []string{"tree", "--max-depth", "1", testImage, "parent/child/child/child"},
+			stdout: "parent/child/child/child\n└── child\n\n1 directories, 0 files\n",
+		},
+		{
+			name: "tree file",
+			args: []string{"tree", testImage, "etc/os-release"},
+			code: 1,
+		},
+		{
+			name:   "du",
+			args:   []string{"du", testImage, "parent/child/child/child/child"},
+			stdout: "apparent\tallocated\tpath\n1048\t4096\tparent/child/child/child/child/child\n3154\t12288\tparent/child/child/child/child\n",
+		},
+		{
+			name:   "du summary",
+			args:   []string{"du", "-s", testImage, "/"},
+			stdout: "apparent\tallocated\tpath\n5063566\t5107712\t.\n",
+		},
+		{
+			name:   "find name and type",
+			args:   []string{"find", testImage, "parent", "--name", "exec*", "--type", "f"},
+			stdout: "parent/child/child/child/child/child/executable\nparent/child/child/child/child/executable\n",
+		},
+		{
+			name:   "find perm",
+			args:   []string{"find", testImage, "--perm", "/111", "--type", "f"},
+			stdout: "parent/child/child/child/child/child/executable\nparent/child/child/child/child/executable\n",
+		},
+		{
+			name:   "find size",
+			args:   []string{"find", testImage, "--size", "+8k"},
+			stdout: "fmt_node_directories\nfmt_extents_file_16384\n",
+		},
+		{
+			name:   "find newer than",
+			args:   []string{"find", testImage, "parent", "--type", "d", "--newer-than", "2021-06-05T15:25:40.076Z"},
+			stdout: "parent/child/child/child/child\n",
+		},
+		{
+			name: "find invalid type",
+			args: []string{"find", testImage, "--type", "x"},
+			code: 2,
+		},
+		{
+			name:   "xattr",
+			args:   []string{"xattr", testImage, "/etc/os-release"},
+			stdout: "security.selinux=\"unconfined_u:object_r:unlabeled_t:s0\"\n",
+		},
+		{
+			name:   "xattr name",
+			args:   []string{"xattr", "--name", "security.selinux", testImage, "etc/os-release"},
+			stdout: "unconfined_u:object_r:unlabeled_t:s0\x00",
+		},
+		{
+			name:   "xattr none",
+			args:   []string{"xattr", testImage, "/"},
+			stdout: "",
+		},
+		{
+			name: "xattr missing name",
+			args: []string{"xattr", "--name", "user.missing", testImage, "etc/os-release"},
+			code: 1,
+		},
+		{
+			name:   "verify",
+			args:   []string{"verify", testImage},
+			stdout: "",
+		},
+		{
+			name:   "timeline bodyfile",
+			args:   []string{"timeline", testImage, "etc"},
+			stdout: "0|/etc|20452|d/drwxr-xr-x|0|0|24|1622906740|1622906740|1622906740|1622906740\n0|/etc/os-release|20453|r/rrw-r--r--|0|0|333|1622906740|1622906740|1622906740|1622906740\n",
+		},
+		{
+			name:   "timeline csv",
+			args:   []string{"timeline", testImage, "etc/os-release", "--format", "csv"},
+			stdout: "time,macb,path,inode,mode,uid,gid,size\n2021-06-05T15:25:40.08143651Z,macb,/etc/os-release,20453,-rw-r--r--,0,0,333\n",
+		},
+		{
+			name: "timeline invalid format",
+			args: []string{"timeline", testImage, "--format", "json"},
+			code: 2,
+		},
+		{
+			name:   "grep",
+			args:   []string{"grep", testImage, "^(NAME|ID)=", "etc"},
+			stdout: "etc/os-release:1:NAME=\"CentOS Linux\"\netc/os-release:3:ID=\"centos\"\n",
+		},
+		{
+			name:   "grep files only",
+			args:   []string{"grep", testImage, "CENTOS", "-i", "-l", "--name", "*-release"},
+			stdout: "etc/os-release\n",
+		},
+		{
+			name:   "grep size filter",
+			args:   []string{"grep", testImage, "centos", "--size", "+1k"},
+			stdout: "",
+		},
+		{
+			name: "grep invalid pattern",
+			args: []string{"grep", testImage, "("},
+			code: 2,
+		},
+		{
+			name:   "sum",
+			args:   []string{"sum", testImage, "etc"},
+			stdout: "30a1390632ded0e88bc7133f80a18053e7e495fc3a5b88bdc70964af0460bbdf  etc/os-release\n",
+		},
+		{
+			name:   "sum md5",
+			args:   []string{"sum", testImage, "/etc/os-release", "--algo", "md5"},
+			stdout: "0868a2ea7fc0d17dec85d63f452563d4  etc/os-release\n",
+		},
+		{
+			name: "sum invalid algo",
+			args: []string{"sum", testImage, "--algo", "crc32"},
+			code: 2,
+		},
+		{
+			name: "unknown command",
+			args: []string{"unknown"},
+			code: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, actual %d: %s", tt.code, code, stderr)
+			}
+			if tt.code == 0 && stdout != tt.stdout {
+				t.Errorf("expected %q, actual %q", tt.stdout, stdout)
+			}
+			if tt.code != 0 && !strings.Contains(stderr, "xfs") {
+				t.Errorf("expected an error message, actual %q", stderr)
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dst := t.TempDir()
+	for _, src := range []string{"/etc/os-release", "parent"} {
+		if _, stderr, code := runCommand(t, "extract", testImage, src, dst); code != 0 {
+			t.Fatalf("extract %s: %s", src, stderr)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(dst, "os-release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != osRelease {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	mtime := time.Date(2021, 6, 5, 15, 25, 40, 75436327, time.UTC)
+	tests := []struct {
+		name  string
+		mode  fs.FileMode
+		mtime time.Time
+	}{
+		{name: "parent/child/child/child/child/child", mode: fs.ModeDir | 0755, mtime: mtime},
+		{name: "parent/child/child/child/child/child/executable", mode: 0755, mtime: mtime},
+	}
+	for _, tt := range tests {
+		info, err := os.Lstat(filepath.Join(dst, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != tt.mode || !info.ModTime().Equal(tt.mtime) {
+			t.Errorf("%s: expected %s %s, actual %s %s", tt.name, tt.mode, tt.mtime, info.Mode(), info.ModTime())
+		}
+	}
+
+	// an existing file is not overwritten
+	if _, _, code := runCommand(t, "extract", testImage, "etc/os-release", dst); code != 1 {
+		t.Errorf("expected exit code 1, actual %d", code)
+	}
+}
+
+func TestStat(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected inodeStat
+	}{
+		{
+			name: "file",
+			args: []string{"stat", "--json", testImage, "fmt_extents_file_16384"},
+			expected: inodeStat{
+				Path:     "fmt_extents_file_16384",
+				Ino:      20442,
+				Mode:     "-rw-r--r--",
+				Format:   "extents",
+				NLink:    1,
+				Size:     16384,
+				Nextents: 1,
+				Extents:  []extentStat{{Offset: 0, Block: 2777, Count: 4}},
+			},
+		},
+		{
+			name: "inode number",
+			args: []string{"stat", "--json", testImage, "ino:11072"},
+			expected: inodeStat{
+				Ino:     11072,
+				Mode:    "drwxr-xr-x",
+				Format:  "local",
+				NLink:   8,
+				Size:    239,
+				Extents: []extentStat{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != 0 {
+				t.Fatal(stderr)
+			}
+			var actual inodeStat
+			if err := json.Unmarshal([]byte(stdout), &actual); err != nil {
+				t.Fatal(err)
+			}
+			if actual.Path != tt.expected.Path || actual.Ino != tt.expected.Ino || actual.Mode != tt.expected.Mode ||
+				actual.Format != tt.expected.Format || actual.NLink != tt.expected.NLink || actual.Size != tt.expected.Size ||
+				actual.Nextents != tt.expected.Nextents || !reflect.DeepEqual(actual.Extents, tt.expected.Extents) {
+				t.Errorf("expected %+v, actual %+v", tt.expected, actual)
+			}
+			if actual.Crtime == nil || actual.Mtime.IsZero() {
+				t.Errorf("expected timestamps, actual %+v", actual)
+			}
+		})
+	}
+
+	stdout, stderr, code := runCommand(t, "stat", testImage, "etc/os-release")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	for _, line := range []string{"inode:     20453\n", "size:      333\n", "mtime:     2021-06-05 15:25:40."} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("expected %q in %q", line, stdout)
+		}
+	}
+}
+
+func TestSb(t *testing.T) {
+	stdout, stderr, code := runCommand(t, "sb", testImage, "--json")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	var actual struct {
+		AG         uint32                 `json:"ag"`
+		SuperBlock map[string]interface{} `json:"superblock"`
+		AGF        map[string]interface{} `json:"agf"`
+		AGI        map[string]interface{} `json:"agi"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if actual.SuperBlock["blocksize"] != 4096.0 || actual.SuperBlock["rootino"] != 11072.0 ||
+		actual.SuperBlock["uuid"] != "6ddec983-229d-4c1a-b5fa-4681a8f6e665" {
+		t.Errorf("unexpected superblock %v", actual.SuperBlock)
+	}
+	if actual.AGF["freeblks"] != 2326.0 || actual.AGI["count"] != 1280.0 {
+		t.Errorf("unexpected AG headers %v %v", actual.AGF, actual.AGI)
+	}
+
+	stdout, stderr, code = runCommand(t, "sb", testImage)
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	for _, line := range []string{"superblock 0:\nmagicnum = 0x58465342\n", "versionnum = 0xb4b5\n", "agi 0:\n", "unlinked = []\n"} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("expected %q in %q", line, stdout)
+		}
+	}
+
+	if _, _, code := runCommand(t, "sb", testImage, "--ag", "1"); code != 1 {
+		t.Errorf("expected exit code 1, actual %d", code)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = strings.NewReader("inode 11072\nhexdump 0 4\nblock 0\nhexdump 0x20 0x10\ndir 20452\nbmap 20442\nbogus\nquit\nsb\n")
+
+	stdout, stderr, code := runCommand(t, "debug", testImage)
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	for _, s := range []string{
+		"inode:     11072\n",
+		"inode 11072:\n00000000  49 4e 41 ed                                       |INA.|\n",
+		"block 0: AG 0, AG block 0, offset 0x0, magic 0x58465342\n",
+		"block 0:\n00000020  6d de c9 83 22 9d 4c 1a  b5 fa 46 81 a8 f6 e6 65  |m...\".L...F....e|\n",
+		"     20452 .\n     11072 ..\n     20453 os-release\n",
+		"[0] block 2777 count 4\n",
+	} {
+		if !strings.Contains(stdout, s) {
+			t.Errorf("expected %q in %q", s, stdout)
+		}
+	}
+	if strings.Contains(stdout, "magicnum") {
+		t.Errorf("expected no output after quit, actual %q", stdout)
+	}
+	if !strings.Contains(stderr, "bogus: unknown command") {
+		t.Errorf("expected an error for bogus, actual %q", stderr)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	b, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// di_gen of etc/os-release
+	b[0x9fca00+91]++
+	image := filepath.Join(t.TempDir(), "image.xfs")
+	if err := os.WriteFile(image, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runCommand(t, "verify", "--json", image)
+	if code != 1 || !strings.Contains(stderr, "1 problems found") {
+		t.Fatalf("expected exit code 1, actual %d: %s", code, stderr)
+	}
+	var actual struct {
+		Problems []problemReport `json:"problems"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.Problems) != 1 {
+		t.Fatalf("expected 1 problem, actual %+v", actual.Problems)
+	}
+	p := actual.Problems[0]
+	if p.Path != "etc/os-release" || p.Ino != 20453 || p.Structure != "inode" || p.Offset == nil || *p.Offset != 0x9fca00 {
+		t.Errorf("unexpected problem %+v", p)
+	}
+}
+
+func TestTar(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		t.Run(fmt.Sprintf("gzip %t", gzipped), func(t *testing.T) {
+			args := []string{"tar", testImage, "/etc"}
+			if gzipped {
+				args = append(args, "--gzip")
+			}
+			stdout, stderr, code := runCommand(t, args...)
+			if code != 0 {
+				t.Fatal(stderr)
+			}
+			r := io.Reader(strings.NewReader(stdout))
+			if gzipped {
+				zr, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = zr
+			}
+
+			tr := tar.NewReader(r)
+			var names []string
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, hdr.Name)
+				if hdr.Name != "etc/os-release" {
+					continue
+				}
+				b, err := io.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != osRelease || hdr.Mode != 0o644 || hdr.ModTime.IsZero() {
+					t.Errorf("unexpected os-release %+v: %q", hdr, b)
+				}
+				if selinux := hdr.PAXRecords["SCHILY.xattr.security.selinux"]; selinux != "unconfined_u:object_r:unlabeled_t:s0\x00" {
+					t.Errorf("unexpected security.selinux %q", selinux)
+				}
+			}
+			if expected := []string{"etc/", "etc/os-release"}; !reflect.DeepEqual(names, expected) {
+				t.Errorf("expected %q, actual %q", expected, names)
+			}
+		})
+	}
+}
+
+func TestZip(t *testing.T) {
+	stdout, stderr, code := runCommand(t, "zip", testImage, "/etc")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	zr, err := zip.NewReader(strings.NewReader(stdout), int64(len(stdout)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"etc/", "etc/os-release"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
+
+func TestLayer(t *testing.T) {
+	stdout, stderr, code := runCommand(t, "layer", testImage, "/etc", "--uid-map", "0:1000", "--gid-map", "0:2000:10")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	if digest := fmt.Sprintf("diff ID sha256:%x\n", sha256.Sum256([]byte(stdout))); stderr != digest {
+		t.Errorf("expected %q, actual %q", digest, stderr)
+	}
+	tr := tar.NewReader(strings.NewReader(stdout))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "os-release" || hdr.Uid != 1000 || hdr.Gid != 2000 {
+		t.Errorf("unexpected header %+v", hdr)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected a single entry, actual %v", err)
+	}
+
+	if _, _, code := runCommand(t, "layer", testImage, "--uid-map", "0"); code != 1 {
+		t.Errorf("expected exit code 1 for an invalid map, actual %d", code)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	b, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first data block of etc/os-release
+	copy(b[2784*4096:], "LABEL")
+	changed := filepath.Join(t.TempDir(), "image.xfs")
+	if err := os.WriteFile(changed, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			name: "same image",
+			args: []string{"diff", testImage, testImage},
+		},
+		{
+			name: "content without hash",
+			args: []string{"diff", testImage, changed},
+		},
+		{
+			name:   "content",
+			args:   []string{"diff", "--hash", testImage, changed},
+			code:   1,
+			stdout: "M etc/os-release (content)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, actual %d: %s", tt.code, code, stderr)
+			}
+			if stdout != tt.stdout {
+				t.Errorf("expected %q, actual %q", tt.stdout, stdout)
+			}
+		})
+	}
+
+	stdout, _, code := runCommand(t, "diff", testImage, "../../xfs/testdata/image40.xfs")
+	if code != 1 {
+		t.Fatalf("expected exit code 1, actual %d", code)
+	}
+	for _, line := range []string{"+ fmt_extents_file_8388608\n", "M etc/os-release (size, mtime)\n"} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("expected %q in %q", line, stdout)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	b, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the free inode 20454 with the extent of etc/os-release left behind,
+	// di_format extents and the record 0, block 2784, count 1
+	inode := b[20454*512:]
+	inode[5] = 2
+	copy(inode[176:], []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x5c, 0, 0, 1})
+	dir := t.TempDir()
+	image := filepath.Join(dir, "image.xfs")
+	if err := os.WriteFile(image, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runCommand(t, "recover", image, "--out", out)
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	if !strings.HasPrefix(stdout, "inode\textents\tsize\tdeleted\n20454\t1\t4096\t") {
+		t.Errorf("unexpected listing %q", stdout)
+	}
+	recovered, err := os.ReadFile(filepath.Join(out, "inode_20454"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 4096 || !strings.HasPrefix(string(recovered), osRelease) {
+		t.Errorf("unexpected content %q", recovered[:len(osRelease)])
+	}
+}
+
+func TestMeta(t *testing.T) {
+	stdout, stderr, code := runCommand(t, "meta", testImage, "etc", "--json")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	var files []fileMeta
+	d := json.NewDecoder(strings.NewReader(stdout))
+	for d.More() {
+		var m fileMeta
+		if err := d.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, m)
+	}
+	if len(files) != 2 || files[0].Path != "etc" || files[0].Type != "dir" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+	m := files[1]
+	if m.Path != "etc/os-release" || m.Ino != 20453 || m.Type != "file" || m.Perm != 0o644 || m.Size != 333 ||
+		m.Mtime.IsZero() || m.Crtime == nil {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+	if selinux := m.Xattrs["security.selinux"]; selinux != "unconfined_u:object_r:unlabeled_t:s0" {
+		t.Errorf("unexpected security.selinux %q", selinux)
+	}
+
+	stdout, stderr, code = runCommand(t, "meta", testImage, "etc/os-release")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	expected := "ino\tmode\tuid\tgid\tsize\tmtime\txattrs\tpath\n20453\t-rw-r--r--\t0\t0\t333\t2021-06-05T15:25:40.08143651Z\t1\tetc/os-release\n"
+	if stdout != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	img, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const start = 2048
+	sectors := uint32(len(img) / 512)
+	newDisk := func(t *testing.T, table func(disk []byte)) string {
+		disk := make([]byte, start*512+len(img))
+		copy(disk[start*512:], img)
+		table(disk)
+		binary.LittleEndian.PutUint16(disk[510:], 0xaa55)
+		name := filepath.Join(t.TempDir(), "disk.img")
+		if err := os.WriteFile(name, disk, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	mbr := newDisk(t, func(disk []byte) {
+		disk[446+4] = 0x83
+		binary.LittleEndian.PutUint32(disk[446+8:], start)
+		binary.LittleEndian.PutUint32(disk[446+12:], sectors)
+	})
+	gpt := newDisk(t, func(disk []byte) {
+		disk[446+4] = 0xee
+		binary.LittleEndian.PutUint32(disk[446+8:], 1)
+		binary.LittleEndian.PutUint32(disk[446+12:], uint32(len(disk)/512-1))
+		copy(disk[512:], "EFI PART")
+		binary.LittleEndian.PutUint64(disk[512+72:], 2)
+		binary.LittleEndian.PutUint32(disk[512+80:], 128)
+		binary.LittleEndian.PutUint32(disk[512+84:], 128)
+		for i, p := range []struct {
+			typ         string
+			first, last uint64
+			name        string
+		}{
+			// EFI system and Linux file system partitions
+			{"28732ac11ff8d211ba4b00a0c93ec93b", 40, start - 1, "EFI"},
+			{"af3dc60f838472478e793d69d8477de4", start, start + uint64(sectors) - 1, "root"},
+		} {
+			e := disk[1024+i*128:]
+			hex.Decode(e, []byte(p.typ))
+			binary.LittleEndian.PutUint64(e[32:], p.first)
+			binary.LittleEndian.PutUint64(e[40:], p.last)
+			for j, r := range p.name {
+				binary.LittleEndian.PutUint16(e[56+j*2:], uint16(r))
+			}
+		}
+	})
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			name:   "parts mbr",
+			args:   []string{"parts", mbr},
+			stdout: fmt.Sprintf("part\toffset\tsize\txfs\ttype\tname\n1\t%d\t%d\ttrue\t0x83\t\n", start*512, len(img)),
+		},
+		{
+			name: "parts gpt",
+			args: []string{"parts", gpt},
+			stdout: fmt.Sprintf("part\toffset\tsize\txfs\ttype\tname\n"+
+				"1\t%d\t%d\tfalse\tC12A7328-F81F-11D2-BA4B-00A0C93EC93B\tEFI\n"+
+				"2\t%d\t%d\ttrue\t0FC63DAF-8483-4772-8E79-3D69D8477DE4\troot\n", 40*512, (start-40)*512, start*512, len(img)),
+		},
+		{
+			name: "parts without table",
+			args: []string{"parts", testImage},
+			code: 1,
+		},
+		{
+			name:   "first xfs partition",
+			args:   []string{"cat", gpt, "etc/os-release"},
+			stdout: osRelease,
+		},
+		{
+			name:   "selected partition",
+			args:   []string{"cat", "--part", "1", mbr, "etc/os-release"},
+			stdout: osRelease,
+		},
+		{
+			name:   "selected partition after path",
+			args:   []string{"find", gpt, "etc", "--part", "2"},
+			stdout: "etc\netc/os-release\n",
+		},
+		{
+			name: "selected partition without xfs",
+			args: []string{"ls", "--part", "1", gpt},
+			code: 1,
+		},
+		{
+			name: "selected partition without table",
+			args: []string{"ls", "--part", "1", testImage},
+			code: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, actual %d: %s", tt.code, code, stderr)
+			}
+			if tt.code == 0 && stdout != tt.stdout {
+				t.Errorf("expected %q, actual %q", tt.stdout, stdout)
+			}
+		})
+	}
+}
+
+func TestLogicalVolumes(t *testing.T) {
+	img, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a physical volume of 1 MiB extents from 1 MiB, holding swap then root
+	const extent = 1 << 20
+	extents := (len(img) + extent - 1) / extent
+	pv := make([]byte, (2+extents)*extent)
+	copy(pv[2*extent:], img)
+	label := pv[512:]
+	copy(label, "LABELONE")
+	binary.LittleEndian.PutUint64(label[8:], 1)
+	binary.LittleEndian.PutUint32(label[20:], 32)
+	copy(label[24:], "LVM2 001")
+	copy(label[32:], "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	binary.LittleEndian.PutUint64(label[72:], extent)
+	binary.LittleEndian.PutUint64(label[104:], 4096)
+	binary.LittleEndian.PutUint64(label[112:], extent-4096)
+	metadata := fmt.Sprintf(`vg0 {
+	extent_size = 2048
+	physical_volumes {
+		pv0 {
+			id = "aaaaaa-aaaa-aaaa-aaaa-aaaa-aaaa-aaaaaa"
+			pe_start = 2048
+		}
+	}
+	logical_volumes {
+		swap {
+			segment1 {
+				start_extent = 0
+				extent_count = 1
+				type = "striped"
+				stripe_count = 1
+				stripes = ["pv0", 0]
+			}
+		}
+		root {
+			segment1 {
+				start_extent = 0
+				extent_count = %d
+				type = "striped"
+				stripe_count = 1
+				stripes = ["pv0", 1]
+			}
+		}
+	}
+}
+`, extents)
+	mda := pv[4096:]
+	copy(mda[4:], " LVM2 x[5A%r0N*>")
+	binary.LittleEndian.PutUint32(mda[20:], 1)
+	binary.LittleEndian.PutUint64(mda[32:], extent-4096)
+	binary.LittleEndian.PutUint64(mda[40:], 512)
+	binary.LittleEndian.PutUint64(mda[48:], uint64(len(metadata)))
+	copy(mda[512:], metadata)
+
+	// a whole disk with the physical volume in an MBR partition of type 0x8e
+	const start = 2048
+	disk := make([]byte, start*512+len(pv))
+	copy(disk[start*512:], pv)
+	disk[446+4] = 0x8e
+	binary.LittleEndian.PutUint32(disk[446+8:], start)
+	binary.LittleEndian.PutUint32(disk[446+12:], uint32(len(pv)/512))
+	binary.LittleEndian.PutUint16(disk[510:], 0xaa55)
+
+	dir := t.TempDir()
+	pvImage, diskImage := filepath.Join(dir, "pv.img"), filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(pvImage, pv, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(diskImage, disk, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			name:   "physical volume",
+			args:   []string{"cat", pvImage, "etc/os-release"},
+			stdout: osRelease,
+		},
+		{
+			name:   "physical volume partition",
+			args:   []string{"cat", diskImage, "etc/os-release"},
+			stdout: osRelease,
+		},
+		{
+			name:   "selected logical volume",
+			args:   []string{"find", diskImage, "etc", "--part", "1", "--lv", "root"},
+			stdout: "etc\netc/os-release\n",
+		},
+		{
+			name: "selected logical volume without xfs",
+			args: []string{"ls", "--lv", "swap", diskImage},
+			code: 1,
+		},
+		{
+			name: "missing logical volume",
+			args: []string{"ls", "--lv", "home", pvImage},
+			code: 1,
+		},
+		{
+			name: "logical volume without physical volume",
+			args: []string{"ls", "--lv", "root", testImage},
+			code: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, actual %d: %s", tt.code, code, stderr)
+			}
+			if tt.code == 0 && stdout != tt.stdout {
+				t.Errorf("expected %q, actual %q", tt.stdout, stdout)
+			}
+		})
+	}
+}
+
+func TestVirtualDisk(t *testing.T) {
+	img, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a fixed VHD of a disk with the image in an MBR partition
+	const start = 2048
+	disk := make([]byte, start*512+len(img), start*512+len(img)+512)
+	copy(disk[start*512:], img)
+	disk[446+4] = 0x83
+	binary.LittleEndian.PutUint32(disk[446+8:], start)
+	binary.LittleEndian.PutUint32(disk[446+12:], uint32(len(img)/512))
+	binary.LittleEndian.PutUint16(disk[510:], 0xaa55)
+
+	footer := make([]byte, 512)
+	copy(footer, "conectix")
+	binary.BigEndian.PutUint64(footer[16:], 0xffffffffffffffff)
+	binary.BigEndian.PutUint64(footer[40:], uint64(len(disk)))
+	binary.BigEndian.PutUint64(footer[48:], uint64(len(disk)))
+	binary.BigEndian.PutUint32(footer[60:], 2)
+	var sum uint32
+	for _, c := range footer {
+		sum += uint32(c)
+	}
+	binary.BigEndian.PutUint32(footer[64:], ^sum)
+	name := filepath.Join(t.TempDir(), "disk.vhd")
+	if err := os.WriteFile(name, append(disk, footer...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runCommand(t, "cat", name, "etc/os-release")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	if stdout != osRelease {
+		t.Errorf("unexpected content %q", stdout)
+	}
+	stdout, stderr, code = runCommand(t, "parts", name)
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	if expected := fmt.Sprintf("part\toffset\tsize\txfs\ttype\tname\n1\t%d\t%d\ttrue\t0x83\t\n", start*512, len(img)); stdout != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	b, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rewrite regular files into local format symlinks, the inodes are in
+	// the first AG at ino * 512
+	for ino, target := range map[int]string{
+		20440: "etc/os-release",
+		20441: "/etc",
+		20442: "fmt_extents_file_16384",
+	} {
+		inode := b[ino*512:]
+		binary.BigEndian.PutUint16(inode[2:], 0o120777)
+		inode[5] = 1
+		binary.BigEndian.PutUint64(inode[56:], uint64(len(target)))
+		copy(inode[176:], target)
+	}
+	dir := t.TempDir()
+	image := filepath.Join(dir, "image.xfs")
+	if err := os.WriteFile(image, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			name: "cat symlink",
+			args: []string{"cat", image, "fmt_extents_file_1024"},
+			code: 1,
+		},
+		{
+			name:   "cat follow",
+			args:   []string{"cat", "-L", image, "fmt_extents_file_1024"},
+			stdout: osRelease,
+		},
+		{
+			name:   "cat follow directory symlink",
+			args:   []string{"cat", "--follow", image, "/fmt_extents_file_4096/os-release"},
+			stdout: osRelease,
+		},
+		{
+			name: "cat follow loop",
+			args: []string{"cat", "-L", image, "fmt_extents_file_16384"},
+			code: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, actual %d: %s", tt.code, code, stderr)
+			}
+			if tt.code == 0 && stdout != tt.stdout {
+				t.Errorf("expected %q, actual %q", tt.stdout, stdout)
+			}
+		})
+	}
+
+	stdout, stderr, code := runCommand(t, "stat", "-L", image, "fmt_extents_file_1024")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	if !strings.HasPrefix(stdout, "path:      fmt_extents_file_1024\ninode:     20453\n") {
+		t.Errorf("expected the inode of etc/os-release, actual %q", stdout)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCommand(t, "extract", "-L", image, "fmt_extents_file_4096", out); code != 0 {
+		t.Fatal(stderr)
+	}
+	extracted, err := os.ReadFile(filepath.Join(out, "fmt_extents_file_4096", "os-release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(extracted) != osRelease {
+		t.Errorf("unexpected content %q", extracted)
+	}
+}
+
+func TestDump(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		contains []string
+	}{
+		{
+			name: "superblock",
+			args: []string{"dump", "--block", "0", testImage},
+			contains: []string{
+				"block 0: AG 0, AG block 0, offset 0x0\nstructure: superblock\nmagicnum = 0x58465342\nblocksize = 4096\n",
+				"\n00000000  58 46 53 42 00 00 10 00  00 00 00 00 00 00 13 f7  |XFSB............|\n",
+			},
+		},
+		{
+			name:     "btree block",
+			args:     []string{"dump", "--block", "1", testImage},
+			contains: []string{"structure: free space by block btree\nmagicnum = 0x41423342\nlevel = 0\n"},
+		},
+		{
+			name:     "file data",
+			args:     []string{"dump", "--block", "0xae0", testImage},
+			contains: []string{"block 2784: AG 0, AG block 2784, offset 0xae0000\nstructure: unknown, magic 0x4e414d45\n"},
+		},
+		{
+			name: "inode",
+			args: []string{"dump", "--ino", "20453", testImage},
+			contains: []string{
+				"inode 20453, offset 0x9fca00:\ninode:     20453\nmode:      -rw-r--r-- (0100644)\n",
+				"  [0] block 2784 count 1\n\n00000000  49 4e 81 a4 03 02 00 00",
+			},
+		},
+		{
+			name: "block and inode",
+			args: []string{"dump", "--block", "0", "--ino", "20453", testImage},
+			code: 2,
+		},
+		{
+			name: "block out of range",
+			args: []string{"dump", "--block", "100000", testImage},
+			code: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, code := runCommand(t, tt.args...)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d, actual %d: %s", tt.code, code, stderr)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(stdout, s) {
+					t.Errorf("expected %q in %q", s, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	stdout, stderr, code := runCommand(t, "orphans", testImage)
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	const header = "inode\tag\tbucket\tmode\tnlink\tuid\tgid\tsize\tctime\n"
+	if stdout != header {
+		t.Errorf("expected only the header, actual %q", stdout)
+	}
+
+	b, err := os.ReadFile(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// etc/os-release in bucket 37 of the AGI, the third sector
+	binary.BigEndian.PutUint32(b[512+512+40+37*4:], 20453)
+	image := filepath.Join(t.TempDir(), "image.xfs")
+	if err := os.WriteFile(image, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code = runCommand(t, "orphans", image)
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	expected := header + "20453\t0\t37\t-rw-r--r--\t1\t0\t0\t333\t2021-06-05T15:25:40Z\n"
+	if stdout != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+}
+
+func TestFrag(t *testing.T) {
+	stdout, stderr, code := runCommand(t, "frag", testImage, "-v")
+	if code != 0 {
+		t.Fatal(stderr)
+	}
+	expected := "extents\tideal\tpath\n2\t2\tfmt_leaf_directories\n10\t10\tfmt_node_directories\n\n" +
+		"files\textents\tideal\tfactor\tmost\tpath\n1233\t1243\t1243\t0.00%\t10\tfmt_node_directories\n\n" +
+		"from\tto\tfiles\n1\t1\t1231\n2\t3\t1\n8\t15\t1\n"
+	if stdout != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+
+	// extents continuing the previous one in the file and on disk are merged,
+	// a hole or a jump on disk is not
+	extents := []xfs.BmbtIrec{
+		{StartOff: 0, StartBlock: 100, BlockCount: 2},
+		{StartOff: 2, StartBlock: 102, BlockCount: 1},
+		{StartOff: 4, StartBlock: 103, BlockCount: 1},
+		{StartOff: 5, StartBlock: 200, BlockCount: 1},
+	}
+	if n := idealExtents(extents); n != 3 {
+		t.Errorf("expected 3 ideal extents, actual %d", n)
+	}
+}
+
+func TestCmp(t *testing.T) {
+	dir := t.TempDir()
+	if _, stderr, code := runCommand(t, "extract", testImage, "parent", dir); code != 0 {
+		t.Fatal(stderr)
+	}
+	local := filepath.Join(dir, "parent")
+	stdout, stderr, code := runCommand(t, "cmp", testImage, "/parent", local)
+	if code != 0 {
+		t.Fatalf("expected no differences, actual %q: %s", stdout, stderr)
+	}
+
+	child := filepath.Join(local, "child", "child", "child", "child")
+	b, err := os.ReadFile(filepath.Join(child, "executable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if err := os.WriteFile(filepath.Join(child, "executable"), b, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(child, "nonexecutable"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(child, "child", "executable")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "new"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, code = runCommand(t, "cmp", testImage, "parent", local)
+	if code != 1 {
+		t.Fatalf("expected exit code 1, actual %d", code)
+	}
+	expected := "- child/child/child/child/child/executable\nM child/child/child/child/executable (content)\n" +
+		"M child/child/child/child/nonexecutable (mode)\n+ new\n"
+	if stdout != expected {
+		t.Errorf("expected %q, actual %q", expected, stdout)
+	}
+
+	// a single file is compared with a local file
+	if _, stderr, code := runCommand(t, "extract", testImage, "etc/os-release", dir); code != 0 {
+		t.Fatal(stderr)
+	}
+	if stdout, stderr, code := runCommand(t, "cmp", testImage, "etc/os-release", filepath.Join(dir, "os-release")); code != 0 {
+		t.Errorf("expected no differences, actual %q: %s", stdout, stderr)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	img, err := openImage(testImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	server := httptest.NewServer(newHTTPHandler(img))
+	defer server.Close()
+
+	get := func(method, path string, header map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(b)
+	}
+
+	resp, body := get(http.MethodGet, "/etc/os-release", nil)
+	if resp.StatusCode != http.StatusOK || body != osRelease || resp.ContentLength != int64(len(osRelease)) {
+		t.Errorf("expected os-release, actual %d %d %q", resp.StatusCode, resp.ContentLength, body)
+	}
+	resp, body = get(http.MethodGet, "/etc/os-release", map[string]string{"Range": "bytes=5-16"})
+	if resp.StatusCode != http.StatusPartialContent || body != osRelease[5:17] {
+		t.Errorf("expected a partial os-release, actual %d %q", resp.StatusCode, body)
+	}
+	resp, body = get(http.MethodGet, "/etc/", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `<a href="os-release">os-release</a>`) {
+		t.Errorf("expected a listing of etc, actual %d %q", resp.StatusCode, body)
+	}
+	if resp, _ = get(http.MethodGet, "/missing", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, actual %d", resp.StatusCode)
+	}
+	if resp, _ = get(http.MethodPut, "/etc/os-release", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, actual %d", resp.StatusCode)
+	}
+}
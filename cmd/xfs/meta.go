@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// fileMeta is the metadata of a file written by meta, one JSON object per line
+type fileMeta struct {
+	Path   string            `json:"path"`
+	Ino    uint64            `json:"ino"`
+	Type   string            `json:"type"`
+	Mode   string            `json:"mode"`
+	Perm   uint16            `json:"perm"`
+	NLink  uint32            `json:"nlink"`
+	UID    uint32            `json:"uid"`
+	GID    uint32            `json:"gid"`
+	Size   int64             `json:"size"`
+	Atime  time.Time         `json:"atime"`
+	Mtime  time.Time         `json:"mtime"`
+	Ctime  time.Time         `json:"ctime"`
+	Crtime *time.Time        `json:"crtime,omitempty"`
+	Target string            `json:"target,omitempty"`
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+}
+
+var typeNames = map[fs.FileMode]string{
+	0:                                 "file",
+	fs.ModeDir:                        "dir",
+	fs.ModeSymlink:                    "symlink",
+	fs.ModeNamedPipe:                  "fifo",
+	fs.ModeSocket:                     "socket",
+	fs.ModeDevice | fs.ModeCharDevice: "chardev",
+	fs.ModeDevice:                     "blockdev",
+}
+
+func runMeta(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("meta", stderr)
+	jsonOutput := flags.Bool("json", false, "print a JSON object per file, NDJSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	e := json.NewEncoder(stdout)
+	if !*jsonOutput {
+		fmt.Fprintln(stdout, "ino\tmode\tuid\tgid\tsize\tmtime\txattrs\tpath")
+	}
+	var walkErr error
+	root := fsPath(strings.Join(positional[1:], ""))
+	err = fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			fmt.Fprintf(stderr, "xfs meta: %v\n", err)
+			walkErr = xerrors.New("some directories could not be read")
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		m, err := newFileMeta(img, name, xfsInfo(info))
+		if err != nil {
+			// the entry is still written, without what could not be read
+			fmt.Fprintf(stderr, "xfs meta: %v\n", err)
+			walkErr = xerrors.New("some files could not be read")
+		}
+		if *jsonOutput {
+			return e.Encode(m)
+		}
+		fmt.Fprintf(stdout, "%d\t%s\t%d\t%d\t%d\t%s\t%d\t%s\n", m.Ino, m.Mode, m.UID, m.GID, m.Size,
+			m.Mtime.UTC().Format(time.RFC3339Nano), len(m.Xattrs), m.Path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+func newFileMeta(img *image, name string, info xfs.FileInfo) (fileMeta, error) {
+	core := info.Sys().(*xfs.InodeCore)
+	m := fileMeta{
+		Path:  name,
+		Ino:   info.Ino(),
+		Type:  typeNames[info.Mode().Type()],
+		Mode:  info.Mode().String(),
+		Perm:  core.Mode & 07777,
+		NLink: core.NLink,
+		UID:   core.UID,
+		GID:   core.GID,
+		Size:  info.Size(),
+		Atime: info.AccessTime(),
+		Mtime: info.ModTime(),
+		Ctime: info.ChangeTime(),
+	}
+	if crtime, ok := info.BirthTime(); ok {
+		m.Crtime = &crtime
+	}
+	if info.Mode().Type() == fs.ModeSymlink {
+		target, err := img.ReadLink(name)
+		if err != nil {
+			return m, err
+		}
+		m.Target = target
+	}
+	xattrs, err := img.ListXattrs(name)
+	if err != nil {
+		return m, err
+	}
+	if len(xattrs) > 0 {
+		m.Xattrs = map[string]string{}
+	}
+	for _, x := range xattrs {
+		m.Xattrs[x.Name] = jsonXattrValue(x.Value)
+	}
+	return m, nil
+}
+
+// jsonXattrValue returns text values as they are, without a trailing NUL,
+// and anything else hex encoded with a 0x prefix like xattr
+func jsonXattrValue(value []byte) string {
+	text := value
+	if n := len(text); n > 0 && text[n-1] == 0 {
+		text = text[:n-1]
+	}
+	if utf8.Valid(text) && !strings.ContainsRune(string(text), 0) {
+		return string(text)
+	}
+	return "0x" + hex.EncodeToString(value)
+}
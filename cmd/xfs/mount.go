@@ -0,0 +1,52 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfsfuse"
+)
+
+func runMount(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("mount", stderr)
+	debug := flags.Bool("debug", false, "log the FUSE requests")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	server, err := xfsfuse.Mount(img.FileSystem, flags.Arg(1), fuse.MountOptions{
+		FsName: flags.Arg(0),
+		Name:   "xfs",
+		Debug:  *debug,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "mounted %s on %s, interrupt to unmount\n", flags.Arg(0), flags.Arg(1))
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if err := server.Unmount(); err != nil {
+			fmt.Fprintf(stderr, "xfs mount: %v\n", err)
+		}
+	}()
+	server.Wait()
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"io"
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
+
+func runMount(args []string, stdout, stderr io.Writer) error {
+	return xerrors.Errorf("mount is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func runOrphans(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("orphans", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	unlinked, err := img.UnlinkedInodes()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "inode\tag\tbucket\tmode\tnlink\tuid\tgid\tsize\tctime")
+	for _, u := range unlinked {
+		info, err := img.InodeInfo(u.Ino)
+		if err != nil {
+			return err
+		}
+		core := info.Sys().(*xfs.InodeCore)
+		fmt.Fprintf(stdout, "%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d\t%s\n", u.Ino, u.AG, u.Bucket, info.Mode(), core.NLink,
+			core.UID, core.GID, info.Size(), info.ChangeTime().UTC().Format(time.RFC3339))
+	}
+	return nil
+}
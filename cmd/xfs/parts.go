@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/device"
+	"github.com/masahiro331/go-xfs-filesystem/partition"
+)
+
+func runParts(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("parts", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	f, err := device.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	disk, err := openDisk(f)
+	if err != nil {
+		return err
+	}
+
+	parts, err := partition.Read(disk, disk.Size())
+	if err != nil {
+		return err
+	}
+	if parts == nil {
+		return xerrors.Errorf("%s has no partition table", flags.Arg(0))
+	}
+	fmt.Fprintln(stdout, "part\toffset\tsize\txfs\ttype\tname")
+	for _, p := range parts {
+		fmt.Fprintf(stdout, "%d\t%d\t%d\t%t\t%s\t%s\n", p.Number, p.Offset, p.Size, p.XFS, p.Type, p.Name)
+	}
+	return nil
+}
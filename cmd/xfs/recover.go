@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+func runRecover(args []string, stdout, stderr io.Writer) error {
+	// the image comes before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("recover", stderr)
+	out := flags.String("out", "", "write the data of every deleted inode to `dir`/inode_N")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) != 1 {
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	deleted, err := img.DeletedInodes()
+	if err != nil {
+		return err
+	}
+	blockSize := img.Info().BlockSize
+	fmt.Fprintln(stdout, "inode\textents\tsize\tdeleted")
+	for _, d := range deleted {
+		fmt.Fprintf(stdout, "%d\t%d\t%d\t%s\n", d.Ino, len(d.Extents), d.Size(blockSize), d.DeletionTime().UTC().Format(time.RFC3339))
+	}
+	if *out == "" {
+		return nil
+	}
+
+	for _, d := range deleted {
+		target := filepath.Join(*out, fmt.Sprintf("inode_%d", d.Ino))
+		// O_EXCL keeps earlier recoveries from being overwritten
+		w, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := img.RecoverDeleted(d, w); err != nil {
+			w.Close()
+			return xerrors.Errorf("failed to recover inode %d: %w", d.Ino, err)
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// field is a decoded header field, hex fields are printed in hexadecimal
+type field struct {
+	name  string
+	value interface{}
+	hex   bool
+}
+
+// fieldList keeps the on-disk order of the fields in JSON
+type fieldList []field
+
+func (l fieldList) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range l {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// agDump is the decoded superblock and AG headers printed by sb
+type agDump struct {
+	AG         uint32    `json:"ag"`
+	SuperBlock fieldList `json:"superblock"`
+	AGF        fieldList `json:"agf"`
+	AGI        fieldList `json:"agi"`
+}
+
+func runSb(args []string, stdout, stderr io.Writer) error {
+	// the image comes before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("sb", stderr)
+	agNumber := flags.Uint("ag", 0, "print the headers of AG `N`")
+	jsonOutput := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) != 1 {
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	if *agNumber >= uint(len(img.AGs)) {
+		return xerrors.Errorf("AG %d does not exist, the file system has %d AGs", *agNumber, len(img.AGs))
+	}
+	ag := img.AGs[*agNumber]
+	dump := agDump{
+		AG:         uint32(*agNumber),
+		SuperBlock: headerFields(ag.SuperBlock),
+		AGF:        headerFields(ag.Agf),
+		AGI:        headerFields(ag.Agi),
+	}
+	if *jsonOutput {
+		e := json.NewEncoder(stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(dump)
+	}
+	for i, h := range []struct {
+		name   string
+		fields fieldList
+	}{{"superblock", dump.SuperBlock}, {"agf", dump.AGF}, {"agi", dump.AGI}} {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintf(stdout, "%s %d:\n", h.name, dump.AG)
+		printFields(stdout, h.fields)
+	}
+	return nil
+}
+
+// headerFields decodes the fields of an on-disk header struct or a pointer to
+// one, the field names are lower cased and padding is left out.
+func headerFields(header interface{}) fieldList {
+	v := reflect.Indirect(reflect.ValueOf(header))
+	t := v.Type()
+	var fields fieldList
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if strings.HasPrefix(name, "Spare") || strings.HasPrefix(name, "Pad") {
+			continue
+		}
+		f := field{name: strings.ToLower(name), value: v.Field(i).Interface()}
+		switch value := f.value.(type) {
+		case [16]byte:
+			f.value = formatUUID(value)
+		case [12]byte:
+			f.value = string(bytes.TrimRight(value[:], "\x00"))
+		case [256]byte:
+			// agi_unlinked is an array of 64 bucket heads
+			buckets := make([]uint32, len(value)/4)
+			for j := range buckets {
+				buckets[j] = binary.BigEndian.Uint32(value[j*4:])
+			}
+			f.value = buckets
+		}
+		f.hex = name == "Magicnum" || name == "CRC" || strings.Contains(name, "Features") ||
+			name == "Versionnum" && t == reflect.TypeOf(xfs.SuperBlock{})
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func printFields(w io.Writer, fields fieldList) {
+	for _, f := range fields {
+		switch {
+		case f.name == "unlinked":
+			// only the buckets holding an unlinked inode, like xfs_db
+			var heads []string
+			for i, head := range f.value.([]uint32) {
+				if head != 0xffffffff {
+					heads = append(heads, fmt.Sprintf("%d:%d", i, head))
+				}
+			}
+			fmt.Fprintf(w, "%s = [%s]\n", f.name, strings.Join(heads, " "))
+		case f.hex:
+			fmt.Fprintf(w, "%s = %#x\n", f.name, f.value)
+		default:
+			fmt.Fprintf(w, "%s = %v\n", f.name, f.value)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs9p"
+	"github.com/masahiro331/go-xfs-filesystem/xfsnfs"
+)
+
+// servers are the protocols of serve by name
+var servers = map[string]func(args []string, stdout, stderr io.Writer) error{
+	"http": runServeHTTP,
+	"nfs":  runServeNFS,
+	"9p":   runServe9P,
+}
+
+func runServe(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	serve, ok := servers[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "xfs serve: unknown protocol %q\n", args[0])
+		return errUsage
+	}
+	return serve(args[1:], stdout, stderr)
+}
+
+func runServeHTTP(args []string, stdout, stderr io.Writer) error {
+	name, addr, err := parseServeArgs("http", ":8080", args, stderr)
+	if err != nil {
+		return err
+	}
+	img, err := openImage(name)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	server := &http.Server{Addr: addr, Handler: newHTTPHandler(img)}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(stdout, "serving %s on %s\n", name, addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func runServeNFS(args []string, stdout, stderr io.Writer) error {
+	name, addr, err := parseServeArgs("nfs", ":2049", args, stderr)
+	if err != nil {
+		return err
+	}
+	img, err := openImage(name)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	fmt.Fprintf(stdout, "serving %s on %s, mount with -o vers=3,proto=tcp,port=%d,mountport=%d,nolock\n",
+		name, l.Addr(), l.Addr().(*net.TCPAddr).Port, l.Addr().(*net.TCPAddr).Port)
+	if err := xfsnfs.Serve(l, img.FileSystem); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func runServe9P(args []string, stdout, stderr io.Writer) error {
+	name, addr, err := parseServeArgs("9p", ":564", args, stderr)
+	if err != nil {
+		return err
+	}
+	img, err := openImage(name)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	fmt.Fprintf(stdout, "serving %s on %s, mount with -t 9p -o trans=tcp,port=%d,version=9p2000.L,ro\n",
+		name, l.Addr(), l.Addr().(*net.TCPAddr).Port)
+	if err := xfs9p.Serve(l, img.FileSystem); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// parseServeArgs returns the image and the --addr of serve, the image comes
+// before the flags
+func parseServeArgs(protocol, defaultAddr string, args []string, stderr io.Writer) (string, string, error) {
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("serve "+protocol, stderr)
+	addr := flags.String("addr", defaultAddr, "listen on `address`")
+	if err := flags.Parse(args); err != nil {
+		return "", "", err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) != 1 {
+		return "", "", errUsage
+	}
+	return positional[0], *addr, nil
+}
+
+// newHTTPHandler serves the files of img read-only with directory listings,
+// range requests and symlinks followed inside the image
+func newHTTPHandler(img *image) http.Handler {
+	files := http.FileServer(http.FS(img.FollowFS()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
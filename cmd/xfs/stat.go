@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+var inodeFormats = []string{"dev", "local", "extents", "btree", "uuid", "rmap"}
+
+var inodeFlags = []struct {
+	flag uint16
+	name string
+}{
+	{xfs.XFS_DIFLAG_REALTIME, "realtime"},
+	{xfs.XFS_DIFLAG_PREALLOC, "prealloc"},
+	{xfs.XFS_DIFLAG_NEWRTBM, "newrtbm"},
+	{xfs.XFS_DIFLAG_IMMUTABLE, "immutable"},
+	{xfs.XFS_DIFLAG_APPEND, "append"},
+	{xfs.XFS_DIFLAG_SYNC, "sync"},
+	{xfs.XFS_DIFLAG_NOATIME, "noatime"},
+	{xfs.XFS_DIFLAG_NODUMP, "nodump"},
+	{xfs.XFS_DIFLAG_RTINHERIT, "rtinherit"},
+	{xfs.XFS_DIFLAG_PROJINHERIT, "projinherit"},
+	{xfs.XFS_DIFLAG_NOSYMLINKS, "nosymlinks"},
+	{xfs.XFS_DIFLAG_EXTSIZE, "extsize"},
+	{xfs.XFS_DIFLAG_EXTSZINHERIT, "extszinherit"},
+	{xfs.XFS_DIFLAG_NODEFRAG, "nodefrag"},
+	{xfs.XFS_DIFLAG_FILESTREAM, "filestream"},
+}
+
+var inodeFlags2 = []struct {
+	flag uint64
+	name string
+}{
+	{xfs.XFS_DIFLAG2_DAX, "dax"},
+	{xfs.XFS_DIFLAG2_REFLINK, "reflink"},
+	{xfs.XFS_DIFLAG2_COWEXTSIZE, "cowextsize"},
+	{xfs.XFS_DIFLAG2_BIGTIME, "bigtime"},
+	{xfs.XFS_DIFLAG2_NREXT64, "nrext64"},
+}
+
+// inodeStat is the decoded inode core printed by stat
+type inodeStat struct {
+	Path      string       `json:"path,omitempty"`
+	Ino       uint64       `json:"ino"`
+	Mode      string       `json:"mode"`
+	RawMode   uint16       `json:"raw_mode"`
+	Version   uint8        `json:"version"`
+	Format    string       `json:"format"`
+	NLink     uint32       `json:"nlink"`
+	UID       uint32       `json:"uid"`
+	GID       uint32       `json:"gid"`
+	ProjID    uint16       `json:"projid"`
+	Size      uint64       `json:"size"`
+	Blocks    uint64       `json:"blocks"`
+	Extsize   uint32       `json:"extsize"`
+	Nextents  uint32       `json:"nextents"`
+	Anextents uint16       `json:"anextents"`
+	Forkoff   uint8        `json:"forkoff"`
+	Aformat   string       `json:"aformat"`
+	Gen       uint32       `json:"gen"`
+	Flags     []string     `json:"flags"`
+	Flags2    []string     `json:"flags2"`
+	Atime     time.Time    `json:"atime"`
+	Mtime     time.Time    `json:"mtime"`
+	Ctime     time.Time    `json:"ctime"`
+	Crtime    *time.Time   `json:"crtime,omitempty"`
+	Extents   []extentStat `json:"extents"`
+}
+
+type extentStat struct {
+	Offset    uint64 `json:"offset"`
+	Block     uint64 `json:"block"`
+	Count     uint64 `json:"count"`
+	Unwritten bool   `json:"unwritten,omitempty"`
+}
+
+func runStat(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("stat", stderr)
+	jsonOutput := flags.Bool("json", false, "print JSON")
+	follow := followFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	st, err := statInode(img, flags.Arg(1), *follow)
+	if err != nil {
+		return err
+	}
+	if *jsonOutput {
+		e := json.NewEncoder(stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(st)
+	}
+	printStat(stdout, st)
+	return nil
+}
+
+// statInode decodes the inode of name, which is a path or ino:N. With follow
+// the symlinks on the path are followed, the path printed is still name.
+func statInode(img *image, name string, follow bool) (inodeStat, error) {
+	var info xfs.FileInfo
+	if strings.HasPrefix(name, "ino:") {
+		ino, err := strconv.ParseUint(strings.TrimPrefix(name, "ino:"), 0, 64)
+		if err != nil {
+			return inodeStat{}, errUsage
+		}
+		if info, err = img.InodeInfo(ino); err != nil {
+			return inodeStat{}, err
+		}
+		name = ""
+	} else {
+		resolved, err := resolvePath(img, name, follow)
+		if err != nil {
+			return inodeStat{}, err
+		}
+		name = fsPath(name)
+		i, err := img.Lstat(resolved)
+		if err != nil {
+			return inodeStat{}, err
+		}
+		info = i.(xfs.FileInfo)
+	}
+	core := info.Sys().(*xfs.InodeCore)
+
+	st := inodeStat{
+		Path:      name,
+		Ino:       info.Ino(),
+		Mode:      info.Mode().String(),
+		RawMode:   core.Mode,
+		Version:   core.Version,
+		Format:    formatName(core.Format),
+		NLink:     core.NLink,
+		UID:       core.UID,
+		GID:       core.GID,
+		ProjID:    core.ProjId,
+		Size:      core.Size,
+		Blocks:    core.Nblocks,
+		Extsize:   core.Extsize,
+		Nextents:  core.Nextents,
+		Anextents: core.Anextents,
+		Forkoff:   core.Forkoff,
+		Aformat:   formatName(core.Aformat),
+		Gen:       core.Gen,
+		Flags:     []string{},
+		Flags2:    []string{},
+		Atime:     info.AccessTime(),
+		Mtime:     info.ModTime(),
+		Ctime:     info.ChangeTime(),
+		Extents:   []extentStat{},
+	}
+	for _, f := range inodeFlags {
+		if core.Flags&f.flag != 0 {
+			st.Flags = append(st.Flags, f.name)
+		}
+	}
+	for _, f := range inodeFlags2 {
+		if core.Flags2&f.flag != 0 {
+			st.Flags2 = append(st.Flags2, f.name)
+		}
+	}
+	if crtime, ok := info.BirthTime(); ok {
+		st.Crtime = &crtime
+	}
+	for _, e := range info.Extents() {
+		st.Extents = append(st.Extents, extentStat{
+			Offset:    e.StartOff,
+			Block:     e.StartBlock,
+			Count:     e.BlockCount,
+			Unwritten: e.State == xfs.XFS_EXT_UNWRITTEN,
+		})
+	}
+	return st, nil
+}
+
+func formatName(format uint8) string {
+	if int(format) < len(inodeFormats) {
+		return inodeFormats[format]
+	}
+	return strconv.Itoa(int(format))
+}
+
+func printStat(w io.Writer, st inodeStat) {
+	const layout = "2006-01-02 15:04:05.000000000 -0700"
+	if st.Path != "" {
+		fmt.Fprintf(w, "path:      %s\n", st.Path)
+	}
+	fmt.Fprintf(w, "inode:     %d\n", st.Ino)
+	fmt.Fprintf(w, "mode:      %s (%#o)\n", st.Mode, st.RawMode)
+	fmt.Fprintf(w, "version:   %d\n", st.Version)
+	fmt.Fprintf(w, "format:    %s\n", st.Format)
+	fmt.Fprintf(w, "nlink:     %d\n", st.NLink)
+	fmt.Fprintf(w, "uid:       %d\n", st.UID)
+	fmt.Fprintf(w, "gid:       %d\n", st.GID)
+	fmt.Fprintf(w, "projid:    %d\n", st.ProjID)
+	fmt.Fprintf(w, "size:      %d\n", st.Size)
+	fmt.Fprintf(w, "blocks:    %d\n", st.Blocks)
+	fmt.Fprintf(w, "extsize:   %d\n", st.Extsize)
+	fmt.Fprintf(w, "gen:       %d\n", st.Gen)
+	fmt.Fprintf(w, "flags:     %s\n", strings.Join(st.Flags, ","))
+	fmt.Fprintf(w, "flags2:    %s\n", strings.Join(st.Flags2, ","))
+	fmt.Fprintf(w, "atime:     %s\n", st.Atime.UTC().Format(layout))
+	fmt.Fprintf(w, "mtime:     %s\n", st.Mtime.UTC().Format(layout))
+	fmt.Fprintf(w, "ctime:     %s\n", st.Ctime.UTC().Format(layout))
+	if st.Crtime != nil {
+		fmt.Fprintf(w, "crtime:    %s\n", st.Crtime.UTC().Format(layout))
+	}
+	if st.Forkoff == 0 {
+		fmt.Fprintln(w, "attr fork: none")
+	} else {
+		fmt.Fprintf(w, "attr fork: %s, offset %d, %d extents\n", st.Aformat, uint(st.Forkoff)*8, st.Anextents)
+	}
+	fmt.Fprintf(w, "extents:   %d\n", st.Nextents)
+	for _, e := range st.Extents {
+		state := ""
+		if e.Unwritten {
+			state = " unwritten"
+		}
+		fmt.Fprintf(w, "  [%d] block %d count %d%s\n", e.Offset, e.Block, e.Count, state)
+	}
+}
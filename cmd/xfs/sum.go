@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func runSum(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("sum", stderr)
+	algo := flags.String("algo", "sha256", "hash `algorithm`, one of md5, sha1, sha256, sha512")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	newHash, ok := hashAlgorithms[*algo]
+	if !ok {
+		fmt.Fprintf(stderr, "xfs sum: invalid --algo %q\n", *algo)
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	var walkErr error
+	root := fsPath(strings.Join(positional[1:], ""))
+	err = fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			fmt.Fprintf(stderr, "xfs sum: %v\n", err)
+			walkErr = xerrors.New("some files could not be read")
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		sum, err := hashFile(img, name, newHash())
+		if err != nil {
+			fmt.Fprintf(stderr, "xfs sum: %v\n", err)
+			walkErr = xerrors.New("some files could not be read")
+			return nil
+		}
+		fmt.Fprintln(stdout, sumLine(sum, name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// sumLine formats a line of sha256sum -c input. Like coreutils, a name with a
+// backslash or newline is escaped and the line starts with a backslash.
+func sumLine(sum []byte, name string) string {
+	prefix := ""
+	if strings.ContainsAny(name, "\\\n") {
+		prefix = "\\"
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	}
+	return fmt.Sprintf("%s%s  %s", prefix, hex.EncodeToString(sum), name)
+}
+
+func hashFile(img *image, name string, h hash.Hash) ([]byte, error) {
+	f, err := img.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
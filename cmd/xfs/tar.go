@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func runTar(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("tar", stderr)
+	gzipOutput := flags.Bool("gzip", false, "compress the archive with gzip")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	w := stdout
+	if *gzipOutput {
+		zw := gzip.NewWriter(stdout)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	t := tarWriter{img: img, tw: tw, stderr: stderr, links: map[uint64]string{}}
+	if err := t.write(fsPath(strings.Join(positional[1:], ""))); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+type tarWriter struct {
+	img    *image
+	tw     *tar.Writer
+	stderr io.Writer
+
+	// links maps the inode numbers of files with several links to their
+	// first name in the archive
+	links map[uint64]string
+}
+
+// write adds src to the archive, names are relative to the parent of src and
+// the contents of the root are added without the root itself.
+func (t tarWriter) write(src string) error {
+	return fs.WalkDir(t.img, src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		rel := name
+		if src != "." {
+			rel = strings.TrimPrefix(name, path.Dir(src)+"/")
+		}
+		i, err := d.Info()
+		if err != nil {
+			return err
+		}
+		info := xfsInfo(i)
+
+		var link string
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			if link, err = t.img.ReadLink(name); err != nil {
+				return err
+			}
+		case d.Type()&(fs.ModeDevice|fs.ModeCharDevice) != 0:
+			fmt.Fprintf(t.stderr, "xfs tar: skipping %s: unsupported file type %s\n", name, d.Type())
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		core := info.Sys().(*xfs.InodeCore)
+		hdr.Uid, hdr.Gid = int(core.UID), int(core.GID)
+		hdr.AccessTime, hdr.ChangeTime = info.AccessTime(), info.ChangeTime()
+		hdr.Format = tar.FormatPAX
+
+		xattrs, err := t.img.ListXattrs(name)
+		if err != nil {
+			return err
+		}
+		for _, x := range xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = map[string]string{}
+			}
+			hdr.PAXRecords["SCHILY.xattr."+x.Name] = string(x.Value)
+		}
+
+		if d.Type().IsRegular() && core.NLink > 1 {
+			if first, ok := t.links[info.Ino()]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+				return t.tw.WriteHeader(hdr)
+			}
+			t.links[info.Ino()] = hdr.Name
+		}
+		if err := t.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := t.img.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(t.tw, f)
+		return err
+	})
+}
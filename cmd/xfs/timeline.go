@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// timelineEntry holds the MACB timestamps of a path, birth is zero for v1
+// and v2 inodes
+type timelineEntry struct {
+	path                        string
+	info                        xfs.FileInfo
+	mtime, atime, ctime, crtime time.Time
+}
+
+func runTimeline(args []string, stdout, stderr io.Writer) error {
+	// the image and path come before the flags
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	flags := newFlagSet("timeline", stderr)
+	format := flags.String("format", "bodyfile", "output `format`, bodyfile for mactime(1) or csv sorted by time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	positional = append(positional, flags.Args()...)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errUsage
+	}
+	if *format != "bodyfile" && *format != "csv" {
+		fmt.Fprintf(stderr, "xfs timeline: invalid --format %q\n", *format)
+		return errUsage
+	}
+
+	img, err := openImage(positional[0])
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	var entries []timelineEntry
+	var walkErr error
+	root := fsPath(strings.Join(positional[1:], ""))
+	err = fs.WalkDir(img, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root {
+				return err
+			}
+			fmt.Fprintf(stderr, "xfs timeline: %v\n", err)
+			walkErr = xerrors.New("some directories could not be read")
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		info := xfsInfo(fi)
+		e := timelineEntry{
+			path:  path.Join("/", name),
+			info:  info,
+			mtime: info.ModTime(),
+			atime: info.AccessTime(),
+			ctime: info.ChangeTime(),
+		}
+		e.crtime, _ = info.BirthTime()
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *format == "csv" {
+		err = writeTimelineCSV(stdout, entries)
+	} else {
+		err = writeBodyfile(stdout, entries)
+	}
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// writeBodyfile writes the entries in the bodyfile format of The Sleuth Kit
+// 3.x, MD5|name|inode|mode|UID|GID|size|atime|mtime|ctime|crtime
+func writeBodyfile(w io.Writer, entries []timelineEntry) error {
+	for _, e := range entries {
+		core := e.info.Sys().(*xfs.InodeCore)
+		_, err := fmt.Fprintf(w, "0|%s|%d|%s|%d|%d|%d|%d|%d|%d|%d\n", e.path, e.info.Ino(), tskMode(e.info.Mode()),
+			core.UID, core.GID, e.info.Size(), unixTime(e.atime), unixTime(e.mtime), unixTime(e.ctime), unixTime(e.crtime))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTimelineCSV writes a row for every distinct timestamp of every entry,
+// like mactime(1) the macb column flags which timestamps have the time.
+func writeTimelineCSV(w io.Writer, entries []timelineEntry) error {
+	type row struct {
+		t    time.Time
+		macb string
+		e    *timelineEntry
+	}
+	var rows []row
+	for i := range entries {
+		e := &entries[i]
+		times := [4]time.Time{e.mtime, e.atime, e.ctime, e.crtime}
+		for j, t := range times {
+			if t.IsZero() {
+				continue
+			}
+			duplicate := false
+			for _, prev := range times[:j] {
+				duplicate = duplicate || prev.Equal(t)
+			}
+			if duplicate {
+				continue
+			}
+			macb := []byte("....")
+			for k, other := range times {
+				if !other.IsZero() && other.Equal(t) {
+					macb[k] = "macb"[k]
+				}
+			}
+			rows = append(rows, row{t: t, macb: string(macb), e: e})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if !rows[i].t.Equal(rows[j].t) {
+			return rows[i].t.Before(rows[j].t)
+		}
+		return rows[i].e.path < rows[j].e.path
+	})
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "macb", "path", "inode", "mode", "uid", "gid", "size"})
+	for _, r := range rows {
+		core := r.e.info.Sys().(*xfs.InodeCore)
+		cw.Write([]string{
+			r.t.UTC().Format(time.RFC3339Nano),
+			r.macb,
+			r.e.path,
+			strconv.FormatUint(r.e.info.Ino(), 10),
+			r.e.info.Mode().String(),
+			strconv.FormatUint(uint64(core.UID), 10),
+			strconv.FormatUint(uint64(core.GID), 10),
+			strconv.FormatInt(r.e.info.Size(), 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// tskMode formats mode like fls, the file type followed by the ls mode
+// string, e.g. r/rrw-r--r-- and d/drwxr-xr-x
+func tskMode(mode fs.FileMode) string {
+	var t byte
+	switch mode.Type() {
+	case fs.ModeDir:
+		t = 'd'
+	case fs.ModeSymlink:
+		t = 'l'
+	case fs.ModeNamedPipe:
+		t = 'p'
+	case fs.ModeSocket:
+		t = 's'
+	case fs.ModeDevice | fs.ModeCharDevice:
+		t = 'c'
+	case fs.ModeDevice:
+		t = 'b'
+	default:
+		t = 'r'
+	}
+	perm := (mode & fs.ModePerm).String()
+	return fmt.Sprintf("%c/%c%s", t, t, perm[1:])
+}
+
+// unixTime returns the seconds since the epoch, 0 for the zero time
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
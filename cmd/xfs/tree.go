@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+func runTree(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("tree", stderr)
+	maxDepth := flags.Int("max-depth", 0, "descend at most `N` levels, 0 is unlimited")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 || *maxDepth < 0 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	name := fsPath(flags.Arg(1))
+	if _, err := img.ReadDir(name); err != nil {
+		return err
+	}
+	t := tree{img: img, w: stdout, maxDepth: *maxDepth}
+	fmt.Fprintln(stdout, name)
+	if err := t.print(name, "", 1); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\n%d directories, %d files\n", t.dirs, t.files)
+	return nil
+}
+
+type tree struct {
+	img      *image
+	w        io.Writer
+	maxDepth int
+
+	dirs, files int
+}
+
+func (t *tree) print(dir, prefix string, depth int) error {
+	entries, err := t.img.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		branch, indent := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, indent = "└── ", "    "
+		}
+		name := path.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir():
+			t.dirs++
+			fmt.Fprintf(t.w, "%s%s%s\n", prefix, branch, entry.Name())
+			if t.maxDepth == 0 || depth < t.maxDepth {
+				if err := t.print(name, prefix+indent, depth+1); err != nil {
+					return err
+				}
+			}
+		case entry.Type()&fs.ModeSymlink != 0:
+			t.files++
+			target, err := t.img.ReadLink(name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(t.w, "%s%s%s -> %s\n", prefix, branch, entry.Name(), target)
+		default:
+			t.files++
+			fmt.Fprintf(t.w, "%s%s%s\n", prefix, branch, entry.Name())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// problemReport is a problem printed by verify, structure and offset are set
+// for corrupted on-disk structures.
+type problemReport struct {
+	Path      string `json:"path,omitempty"`
+	Ino       uint64 `json:"ino,omitempty"`
+	Structure string `json:"structure,omitempty"`
+	Offset    *int64 `json:"offset,omitempty"`
+	Error     string `json:"error"`
+}
+
+func runVerify(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("verify", stderr)
+	jsonOutput := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	problems, err := img.Verify()
+	if err != nil {
+		return err
+	}
+	reports := []problemReport{}
+	for _, p := range problems {
+		r := problemReport{Path: p.Path, Ino: p.Ino, Error: p.Error()}
+		var corrupted *xfs.CorruptedError
+		if xerrors.As(p.Err, &corrupted) {
+			r.Structure = corrupted.Structure
+			if corrupted.Offset >= 0 {
+				offset := corrupted.Offset
+				r.Offset = &offset
+			}
+		}
+		reports = append(reports, r)
+	}
+
+	if *jsonOutput {
+		e := json.NewEncoder(stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(struct {
+			Problems []problemReport `json:"problems"`
+		}{reports}); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range reports {
+			fmt.Fprintln(stdout, r.Error)
+		}
+	}
+	if len(reports) > 0 {
+		return xerrors.Errorf("%d problems found", len(reports))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+func runXattr(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("xattr", stderr)
+	name := flags.String("name", "", "write the raw value of the `attribute` to stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	img, err := openImage(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	path := fsPath(flags.Arg(1))
+	if *name != "" {
+		value, err := img.GetXattr(path, *name)
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(value)
+		return err
+	}
+	xattrs, err := img.ListXattrs(path)
+	if err != nil {
+		return err
+	}
+	for _, x := range xattrs {
+		fmt.Fprintf(stdout, "%s=%s\n", x.Name, xattrValue(x.Value))
+	}
+	return nil
+}
+
+// xattrValue formats value like getfattr, text is quoted and anything else is
+// hex encoded. A trailing NUL, as in security.selinux, is treated as text.
+func xattrValue(value []byte) string {
+	text := value
+	if n := len(text); n > 0 && text[n-1] == 0 {
+		text = text[:n-1]
+	}
+	if !utf8.Valid(text) {
+		return "0x" + hex.EncodeToString(value)
+	}
+	for _, r := range string(text) {
+		if !strconv.IsPrint(r) {
+			return "0x" + hex.EncodeToString(value)
+		}
+	}
+	return strconv.Quote(string(text))
+}
@@ -0,0 +1,27 @@
+module github.com/masahiro331/go-xfs-filesystem/cmd/xfsd
+
+go 1.18
+
+require (
+	github.com/masahiro331/go-xfs-filesystem v0.0.0-00010101000000-000000000000
+	github.com/masahiro331/go-xfs-filesystem/xfsgrpc v0.0.0-00010101000000-000000000000
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+	google.golang.org/grpc v1.51.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.2 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.23.0 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sys v0.24.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto v0.0.0-20210226172003-ab064af71705 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
+)
+
+replace (
+	github.com/masahiro331/go-xfs-filesystem => ../../
+	github.com/masahiro331/go-xfs-filesystem/xfsgrpc => ../../xfsgrpc
+)
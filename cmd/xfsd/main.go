@@ -0,0 +1,80 @@
+// Command xfsd serves XFS images read-only over gRPC to xfsgrpc clients.
+//
+//	xfsd --listen :7070 root=disk.img /images/data.xfs
+//
+// Images are named by NAME=PATH arguments, or by the base name of PATH.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+
+	"github.com/masahiro331/go-xfs-filesystem/device"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsgrpc"
+)
+
+func main() {
+	listen := flag.String("listen", ":7070", "listen on `address`")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: xfsd [--listen ADDR] [NAME=]IMAGE...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*listen, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "xfsd: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(addr string, args []string) error {
+	images := map[string]*xfs.FileSystem{}
+	for _, arg := range args {
+		name, p := filepath.Base(arg), arg
+		if i := strings.IndexByte(arg, '='); i >= 0 {
+			name, p = arg[:i], arg[i+1:]
+		}
+		if _, ok := images[name]; ok {
+			return xerrors.Errorf("duplicate image name %q", name)
+		}
+		d, err := device.Open(p)
+		if err != nil {
+			return err
+		}
+		defer d.Close()
+		fileSystem, err := xfs.OpenReaderAt(d, nil)
+		if err != nil {
+			return xerrors.Errorf("failed to open %s: %w", p, err)
+		}
+		images[name] = fileSystem
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	xfsgrpc.Register(server, images)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	fmt.Printf("serving %d images on %s\n", len(images), l.Addr())
+	return server.Serve(l)
+}
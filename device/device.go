@@ -0,0 +1,124 @@
+// Package device opens image files and disk devices for reading, such as
+// /dev/sdb on Linux or \\.\PhysicalDrive1 on Windows, where devices must be
+// read in whole sectors at sector aligned offsets.
+package device
+
+import (
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/xerrors"
+)
+
+// maxChunk bounds the buffer of unaligned reads, larger reads are split
+const maxChunk = 1 << 20
+
+// Device is an image file or a disk device opened for reading, it implements
+// xfs.SizeReaderAt
+type Device struct {
+	f          *os.File
+	size       int64
+	sectorSize int64
+}
+
+// Open opens the image file or disk device name for reading. Devices are
+// opened sharing reads and writes with other processes, so disks mounted or
+// in use by the system can be read.
+func Open(name string) (*Device, error) {
+	d, err := open(name)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// Size returns the size in bytes
+func (d *Device) Size() int64 {
+	return d.size
+}
+
+// SectorSize returns the size reads are aligned to, 1 when reads need no
+// alignment
+func (d *Device) SectorSize() int64 {
+	return d.sectorSize
+}
+
+// ReadAt reads len(p) bytes at off, reading the sectors around them from
+// devices requiring aligned reads
+func (d *Device) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	if off >= d.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if remaining := d.size - off; int64(n) > remaining {
+		n = int(remaining)
+	}
+
+	var err error
+	if d.sectorSize <= 1 {
+		n, err = d.f.ReadAt(p[:n], off)
+	} else {
+		n, err = d.readAligned(p[:n], off)
+	}
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (d *Device) readAligned(p []byte, off int64) (int, error) {
+	if off%d.sectorSize == 0 && int64(len(p))%d.sectorSize == 0 && aligned(p, d.sectorSize) {
+		return d.f.ReadAt(p, off)
+	}
+
+	var buf []byte
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		start := pos - pos%d.sectorSize
+		end := pos + int64(len(p)-read)
+		if end-start > maxChunk {
+			end = start + maxChunk
+		}
+		// the size of devices is a whole number of sectors
+		if rem := end % d.sectorSize; rem != 0 {
+			end += d.sectorSize - rem
+		}
+		if buf == nil {
+			buf = alignedBuffer(end-start, d.sectorSize)
+		}
+		chunk := buf[:end-start]
+		if n, err := d.f.ReadAt(chunk, start); n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+		read += copy(p[read:], chunk[pos-start:])
+	}
+	return read, nil
+}
+
+// Close closes the file or device
+func (d *Device) Close() error {
+	return d.f.Close()
+}
+
+// alignedBuffer returns a buffer of n bytes starting at a multiple of align,
+// as unbuffered reads of devices require
+func alignedBuffer(n, align int64) []byte {
+	buf := make([]byte, n+align)
+	skip := int64(0)
+	if rem := int64(uintptr(unsafe.Pointer(&buf[0]))) % align; rem != 0 {
+		skip = align - rem
+	}
+	return buf[skip : skip+n : skip+n]
+}
+
+func aligned(p []byte, align int64) bool {
+	return len(p) == 0 || int64(uintptr(unsafe.Pointer(&p[0])))%align == 0
+}
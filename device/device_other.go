@@ -0,0 +1,31 @@
+//go:build !windows
+
+package device
+
+import (
+	"io"
+	"os"
+)
+
+func open(name string) (*Device, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := info.Size()
+	// block devices have no size in their stat, it is the end of the device
+	if info.Mode()&os.ModeDevice != 0 {
+		size, err = f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	// reads of block devices go through the page cache and need no alignment
+	return &Device{f: f, size: size, sectorSize: 1}, nil
+}
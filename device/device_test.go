@@ -0,0 +1,69 @@
+package device
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeviceReadAt(t *testing.T) {
+	data := make([]byte, 3*maxChunk)
+	rand.New(rand.NewSource(1)).Read(data)
+	name := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.Size() != int64(len(data)) || d.SectorSize() != 1 {
+		t.Errorf("unexpected size %d and sector size %d", d.Size(), d.SectorSize())
+	}
+
+	for _, sectorSize := range []int64{1, 512, 4096} {
+		d.sectorSize = sectorSize
+		for _, tt := range []struct {
+			off, n int64
+		}{
+			{off: 0, n: 512},
+			{off: 4096, n: 8192},
+			{off: 100, n: 10},
+			{off: 511, n: 2},
+			{off: 1000, n: 2*maxChunk + 3000},
+			{off: int64(len(data)) - 10, n: 10},
+		} {
+			buf := make([]byte, tt.n)
+			n, err := d.ReadAt(buf, tt.off)
+			if err != nil || int64(n) != tt.n {
+				t.Errorf("sector size %d: read of %d bytes at %d: %d %v", sectorSize, tt.n, tt.off, n, err)
+				continue
+			}
+			if !bytes.Equal(buf, data[tt.off:tt.off+tt.n]) {
+				t.Errorf("sector size %d: unexpected content of %d bytes at %d", sectorSize, tt.n, tt.off)
+			}
+		}
+
+		buf := make([]byte, 100)
+		if n, err := d.ReadAt(buf, int64(len(data))-30); n != 30 || err != io.EOF {
+			t.Errorf("sector size %d: expected 30 bytes and EOF at the end, actual %d %v", sectorSize, n, err)
+		}
+		if n, err := d.ReadAt(buf, int64(len(data))); n != 0 || err != io.EOF {
+			t.Errorf("sector size %d: expected EOF after the end, actual %d %v", sectorSize, n, err)
+		}
+	}
+}
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, align := range []int64{512, 4096} {
+		buf := alignedBuffer(1000, align)
+		if len(buf) != 1000 || !aligned(buf, align) {
+			t.Errorf("buffer of %d bytes not aligned to %d", len(buf), align)
+		}
+	}
+}
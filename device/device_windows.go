@@ -0,0 +1,73 @@
+//go:build windows
+
+package device
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/xerrors"
+)
+
+const (
+	ioctlDiskGetDriveGeometry = 0x70000
+	ioctlDiskGetLengthInfo    = 0x7405c
+
+	defaultSectorSize = 512
+)
+
+// isDevice reports whether name is a Win32 device path such as
+// \\.\PhysicalDrive0 or \\.\C:
+func isDevice(name string) bool {
+	return strings.HasPrefix(name, `\\.\`) || strings.HasPrefix(name, `\\?\GLOBALROOT\`)
+}
+
+func open(name string) (*Device, error) {
+	if !isDevice(name) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &Device{f: f, size: info.Size(), sectorSize: 1}, nil
+	}
+
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	// sharing writes and deletes lets the device be opened while the system
+	// uses it
+	h, err := windows.CreateFile(path, windows.GENERIC_READ,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// GET_LENGTH_INFORMATION
+	var length [8]byte
+	var returned uint32
+	if err := windows.DeviceIoControl(h, ioctlDiskGetLengthInfo, nil, 0, &length[0], uint32(len(length)), &returned, nil); err != nil {
+		windows.CloseHandle(h)
+		return nil, xerrors.Errorf("failed to get the size: %w", err)
+	}
+	size := int64(binary.LittleEndian.Uint64(length[:]))
+
+	// DISK_GEOMETRY, the sector size follows the cylinders, media type,
+	// tracks per cylinder and sectors per track
+	sectorSize := int64(defaultSectorSize)
+	var geometry [24]byte
+	if err := windows.DeviceIoControl(h, ioctlDiskGetDriveGeometry, nil, 0, &geometry[0], uint32(len(geometry)), &returned, nil); err == nil {
+		if n := int64(binary.LittleEndian.Uint32(geometry[20:])); n > 0 {
+			sectorSize = n
+		}
+	}
+	return &Device{f: os.NewFile(uintptr(h), name), size: size, sectorSize: sectorSize}, nil
+}
@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	go.uber.org/zap v1.23.0
+	golang.org/x/sys v0.24.0
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
 )
 
@@ -4,6 +4,8 @@ import (
 	"go.uber.org/zap"
 )
 
+// Logger is the global logger of the file systems opened without
+// xfs.WithLogger, their structured fields are logged with Debugw and Warnw
 var Logger *zap.SugaredLogger
 
 func init() {
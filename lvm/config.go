@@ -0,0 +1,223 @@
+package lvm
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// configSection is a section of the LVM2 text metadata, e.g.
+//
+//	vg0 {
+//		extent_size = 8192
+//		physical_volumes {
+//			pv0 { ... }
+//		}
+//	}
+//
+// Values are int64, string or []interface{} of them.
+type configSection struct {
+	name     string
+	values   map[string]interface{}
+	sections []*configSection
+}
+
+func (s *configSection) section(name string) *configSection {
+	for _, sub := range s.sections {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (s *configSection) int(key string) (int64, error) {
+	v, ok := s.values[key].(int64)
+	if !ok {
+		return 0, xerrors.Errorf("%s: %s is not a number", s.name, key)
+	}
+	return v, nil
+}
+
+func (s *configSection) str(key string) (string, error) {
+	v, ok := s.values[key].(string)
+	if !ok {
+		return "", xerrors.Errorf("%s: %s is not a string", s.name, key)
+	}
+	return v, nil
+}
+
+// strings returns the strings of the array key, other elements are skipped
+func (s *configSection) strings(key string) []string {
+	values, _ := s.values[key].([]interface{})
+	var strs []string
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+// parseConfig parses the LVM2 text metadata, the returned section holds the
+// top level values and sections
+func parseConfig(text string) (*configSection, error) {
+	p := &configParser{text: text}
+	root := &configSection{values: map[string]interface{}{}}
+	if err := p.parseSection(root, true); err != nil {
+		return nil, xerrors.Errorf("invalid metadata at line %d: %w", p.line(), err)
+	}
+	return root, nil
+}
+
+type configParser struct {
+	text string
+	pos  int
+}
+
+func (p *configParser) line() int {
+	return strings.Count(p.text[:p.pos], "\n") + 1
+}
+
+func (p *configParser) parseSection(s *configSection, top bool) error {
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok == "":
+			if !top {
+				return xerrors.Errorf("section %s is not closed", s.name)
+			}
+			return nil
+		case tok == "}":
+			if top {
+				return xerrors.New("unexpected }")
+			}
+			return nil
+		case !isIdent(tok):
+			return xerrors.Errorf("unexpected %q", tok)
+		}
+
+		op, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "{":
+			sub := &configSection{name: tok, values: map[string]interface{}{}}
+			if err := p.parseSection(sub, false); err != nil {
+				return err
+			}
+			s.sections = append(s.sections, sub)
+		case "=":
+			v, err := p.parseValue()
+			if err != nil {
+				return err
+			}
+			s.values[tok] = v
+		default:
+			return xerrors.Errorf("unexpected %q after %s", op, tok)
+		}
+	}
+}
+
+func (p *configParser) parseValue() (interface{}, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok != "[" {
+		return scalar(tok)
+	}
+	values := []interface{}{}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "]":
+			return values, nil
+		case ",":
+			continue
+		case "":
+			return nil, xerrors.New("array is not closed")
+		}
+		v, err := scalar(tok)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+}
+
+// scalar converts a string or number token
+func scalar(tok string) (interface{}, error) {
+	if strings.HasPrefix(tok, `"`) {
+		return strconv.Unquote(tok)
+	}
+	if n, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		return n, nil
+	}
+	if _, err := strconv.ParseFloat(tok, 64); err == nil {
+		// floats only appear in values this package does not use
+		return tok, nil
+	}
+	return nil, xerrors.Errorf("unexpected %q", tok)
+}
+
+// next returns the next token, "" at the end of the text
+func (p *configParser) next() (string, error) {
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch {
+		case c == '#':
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == 0:
+			p.pos++
+		case strings.IndexByte("{}[]=,", c) >= 0:
+			p.pos++
+			return string(c), nil
+		case c == '"':
+			start := p.pos
+			for p.pos++; p.pos < len(p.text); p.pos++ {
+				switch p.text[p.pos] {
+				case '\\':
+					p.pos++
+				case '"':
+					p.pos++
+					return p.text[start:p.pos], nil
+				}
+			}
+			return "", xerrors.New("string is not closed")
+		default:
+			start := p.pos
+			for p.pos < len(p.text) && isIdentByte(p.text[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return "", xerrors.Errorf("unexpected character %q", c)
+			}
+			return p.text[start:p.pos], nil
+		}
+	}
+	return "", nil
+}
+
+func isIdent(tok string) bool {
+	for i := 0; i < len(tok); i++ {
+		if !isIdentByte(tok[i]) {
+			return false
+		}
+	}
+	return tok != ""
+}
+
+func isIdentByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("_.+-", c) >= 0
+}
@@ -0,0 +1,326 @@
+// Package lvm reads the LVM2 metadata of physical volumes and exposes the
+// linear logical volumes as readers, to open the XFS file systems of default
+// RHEL layouts without the device mapper.
+package lvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	sectorSize = 512
+	// the label is in one of the first four sectors, the second by default
+	labelSectors = 4
+
+	mdaHeaderSize = 512
+	maxMetadata   = 16 << 20
+)
+
+var (
+	labelID   = []byte("LABELONE")
+	labelType = []byte("LVM2 001")
+	mdaMagic  = []byte(" LVM2 x[5A%r0N*>")
+)
+
+// ErrNotLinear is returned when reading a logical volume with segments other
+// than linear ones, such as striped, mirrored, thin or cached volumes
+var ErrNotLinear = xerrors.New("not a linear logical volume")
+
+// VolumeGroup is the volume group described by the metadata of its physical
+// volumes
+type VolumeGroup struct {
+	Name string
+	// ExtentSize is in bytes
+	ExtentSize int64
+	// LogicalVolumes are in the order of the metadata
+	LogicalVolumes []*LogicalVolume
+}
+
+// LogicalVolume is a logical volume of a VolumeGroup, it implements
+// xfs.SizeReaderAt when Linear is set
+type LogicalVolume struct {
+	Name string
+	// Linear is set when every segment maps the extents to a single physical
+	// volume, only those volumes are readable
+	Linear bool
+
+	size int64
+	// r reads the segments of linear volumes
+	r xfs.SizeReaderAt
+}
+
+// missingVolume reads the extents of a physical volume not given to Read
+type missingVolume string
+
+func (name missingVolume) ReadAt([]byte, int64) (int, error) {
+	return 0, xerrors.Errorf("physical volume %s is missing", string(name))
+}
+
+// physicalVolume is the label and metadata of a physical volume
+type physicalVolume struct {
+	r        xfs.SizeReaderAt
+	uuid     string
+	metadata string
+}
+
+// IsPhysicalVolume reports whether r starts with an LVM2 label
+func IsPhysicalVolume(r io.ReaderAt) bool {
+	_, _, err := findLabel(r)
+	return err == nil
+}
+
+// findLabel returns the sector holding the label and the offset of the
+// physical volume header in it
+func findLabel(r io.ReaderAt) ([]byte, int64, error) {
+	sector := make([]byte, sectorSize)
+	for i := int64(0); i < labelSectors; i++ {
+		if _, err := r.ReadAt(sector, i*sectorSize); err != nil {
+			return nil, 0, xerrors.Errorf("failed to read sector %d: %w", i, err)
+		}
+		if !bytes.Equal(sector[:8], labelID) || !bytes.Equal(sector[24:32], labelType) {
+			continue
+		}
+		if binary.LittleEndian.Uint64(sector[8:]) != uint64(i) {
+			return nil, 0, xerrors.Errorf("label of sector %d found in sector %d", binary.LittleEndian.Uint64(sector[8:]), i)
+		}
+		return sector, i * sectorSize, nil
+	}
+	return nil, 0, xerrors.New("no LVM2 label found")
+}
+
+// readPhysicalVolume reads the label of r and the metadata of its first
+// metadata area, metadata is empty when the physical volume has none.
+// Checksums are not verified.
+func readPhysicalVolume(r xfs.SizeReaderAt) (*physicalVolume, error) {
+	sector, _, err := findLabel(r)
+	if err != nil {
+		return nil, err
+	}
+	offset := binary.LittleEndian.Uint32(sector[20:])
+	if offset < 32 || offset+40 > sectorSize {
+		return nil, xerrors.Errorf("invalid physical volume header offset %d", offset)
+	}
+	hdr := sector[offset:]
+	pv := &physicalVolume{r: r, uuid: string(hdr[:32])}
+
+	// the data areas then the metadata areas, each list ends with a zero entry
+	locations := hdr[40:]
+	var mdas [][2]int64
+	for list := 0; list < 2; list++ {
+		for {
+			if len(locations) < 16 {
+				return nil, xerrors.New("physical volume header beyond the label sector")
+			}
+			off, size := int64(binary.LittleEndian.Uint64(locations)), int64(binary.LittleEndian.Uint64(locations[8:]))
+			locations = locations[16:]
+			if off == 0 {
+				break
+			}
+			if list == 1 {
+				mdas = append(mdas, [2]int64{off, size})
+			}
+		}
+	}
+	if len(mdas) == 0 {
+		return pv, nil
+	}
+	pv.metadata, err = readMetadata(r, mdas[0][0], mdas[0][1])
+	if err != nil {
+		return nil, err
+	}
+	return pv, nil
+}
+
+// readMetadata reads the current text metadata of the metadata area at off,
+// a circular buffer after its header
+func readMetadata(r io.ReaderAt, off, size int64) (string, error) {
+	hdr := make([]byte, mdaHeaderSize)
+	if _, err := r.ReadAt(hdr, off); err != nil {
+		return "", xerrors.Errorf("failed to read the metadata area header: %w", err)
+	}
+	if !bytes.Equal(hdr[4:20], mdaMagic) {
+		return "", xerrors.Errorf("invalid metadata area magic %q", hdr[4:20])
+	}
+	if version := binary.LittleEndian.Uint32(hdr[20:]); version != 1 {
+		return "", xerrors.Errorf("unsupported metadata area version %d", version)
+	}
+	if mdaSize := int64(binary.LittleEndian.Uint64(hdr[32:])); mdaSize != 0 {
+		size = mdaSize
+	}
+
+	// the first raw location is the committed metadata
+	loc := hdr[40:]
+	textOff, textSize := int64(binary.LittleEndian.Uint64(loc)), int64(binary.LittleEndian.Uint64(loc[8:]))
+	if textOff == 0 || textSize == 0 {
+		return "", nil
+	}
+	if textOff < mdaHeaderSize || textOff >= size || textSize > maxMetadata || textSize > size-mdaHeaderSize {
+		return "", xerrors.Errorf("invalid metadata location %d of %d bytes", textOff, textSize)
+	}
+	text := make([]byte, textSize)
+	first := textSize
+	if textOff+textSize > size {
+		first = size - textOff
+	}
+	if _, err := r.ReadAt(text[:first], off+textOff); err != nil {
+		return "", xerrors.Errorf("failed to read the metadata: %w", err)
+	}
+	if first < textSize {
+		if _, err := r.ReadAt(text[first:], off+mdaHeaderSize); err != nil {
+			return "", xerrors.Errorf("failed to read the metadata: %w", err)
+		}
+	}
+	return string(bytes.TrimRight(text, "\x00")), nil
+}
+
+// Read parses the volume group of the physical volumes pvs, the metadata is
+// read from the first one holding a copy. Segments on physical volumes
+// missing from pvs fail to read.
+func Read(pvs ...xfs.SizeReaderAt) (*VolumeGroup, error) {
+	var volumes []*physicalVolume
+	var metadata string
+	for i, r := range pvs {
+		pv, err := readPhysicalVolume(r)
+		if err != nil {
+			return nil, xerrors.Errorf("physical volume %d: %w", i, err)
+		}
+		volumes = append(volumes, pv)
+		if metadata == "" {
+			metadata = pv.metadata
+		}
+	}
+	if metadata == "" {
+		return nil, xerrors.New("no metadata found in the physical volumes")
+	}
+	config, err := parseConfig(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.sections) != 1 {
+		return nil, xerrors.Errorf("expected one volume group in the metadata, found %d", len(config.sections))
+	}
+	return parseVolumeGroup(config.sections[0], volumes)
+}
+
+func parseVolumeGroup(s *configSection, volumes []*physicalVolume) (*VolumeGroup, error) {
+	extentSize, err := s.int("extent_size")
+	if err != nil {
+		return nil, err
+	}
+	if extentSize <= 0 {
+		return nil, xerrors.Errorf("invalid extent size %d", extentSize)
+	}
+	vg := &VolumeGroup{Name: s.name, ExtentSize: extentSize * sectorSize}
+
+	// the physical volumes by their name in the metadata, such as pv0
+	type pvLocation struct {
+		r       io.ReaderAt
+		peStart int64
+	}
+	pvs := map[string]pvLocation{}
+	if section := s.section("physical_volumes"); section != nil {
+		for _, p := range section.sections {
+			id, err := p.str("id")
+			if err != nil {
+				return nil, err
+			}
+			peStart, err := p.int("pe_start")
+			if err != nil {
+				return nil, err
+			}
+			loc := pvLocation{r: missingVolume(p.name), peStart: peStart * sectorSize}
+			for _, pv := range volumes {
+				if pv.uuid == strings.ReplaceAll(id, "-", "") {
+					loc.r = pv.r
+				}
+			}
+			pvs[p.name] = loc
+		}
+	}
+
+	if section := s.section("logical_volumes"); section != nil {
+		for _, l := range section.sections {
+			lv := &LogicalVolume{Name: l.name, Linear: true}
+			var segments []xfs.LinearSegment
+			for _, seg := range l.sections {
+				if !strings.HasPrefix(seg.name, "segment") {
+					continue
+				}
+				startExtent, err := seg.int("start_extent")
+				if err != nil {
+					return nil, err
+				}
+				extentCount, err := seg.int("extent_count")
+				if err != nil {
+					return nil, err
+				}
+				lv.size += extentCount * vg.ExtentSize
+
+				typ, _ := seg.str("type")
+				stripeCount, _ := seg.int("stripe_count")
+				stripes, _ := seg.values["stripes"].([]interface{})
+				if typ != "striped" || stripeCount != 1 || len(stripes) != 2 {
+					lv.Linear = false
+					continue
+				}
+				name, _ := stripes[0].(string)
+				pe, _ := stripes[1].(int64)
+				loc, ok := pvs[name]
+				if !ok {
+					return nil, xerrors.Errorf("%s: %s: unknown physical volume %q", lv.Name, seg.name, name)
+				}
+				segments = append(segments, xfs.LinearSegment{
+					Offset: startExtent * vg.ExtentSize,
+					Size:   extentCount * vg.ExtentSize,
+					R:      loc.r,
+					Start:  loc.peStart + pe*vg.ExtentSize,
+				})
+			}
+			if lv.Linear {
+				r, err := xfs.Linear(lv.size, segments...)
+				if err != nil {
+					return nil, xerrors.Errorf("%s: %w", lv.Name, err)
+				}
+				lv.r = r
+			}
+			vg.LogicalVolumes = append(vg.LogicalVolumes, lv)
+		}
+	}
+	return vg, nil
+}
+
+// LogicalVolume returns the logical volume name
+func (vg *VolumeGroup) LogicalVolume(name string) (*LogicalVolume, error) {
+	var names []string
+	for _, lv := range vg.LogicalVolumes {
+		if lv.Name == name {
+			return lv, nil
+		}
+		names = append(names, lv.Name)
+	}
+	return nil, xerrors.Errorf("logical volume %q not found in %s, it has %s", name, vg.Name, strings.Join(names, ", "))
+}
+
+// Size returns the size of the logical volume in bytes
+func (lv *LogicalVolume) Size() int64 {
+	return lv.size
+}
+
+// ReadAt reads the extents of the logical volume from its physical volumes
+func (lv *LogicalVolume) ReadAt(p []byte, off int64) (int, error) {
+	if !lv.Linear {
+		return 0, xerrors.Errorf("%s: %w", lv.Name, ErrNotLinear)
+	}
+	n, err := lv.r.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = xerrors.Errorf("%s: %w", lv.Name, err)
+	}
+	return n, err
+}
@@ -0,0 +1,299 @@
+package lvm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/lvm"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	mdaOffset  = 4096
+	mdaSize    = 1<<20 - mdaOffset
+	peStart    = 1 << 20
+	extentSize = 64 << 10
+)
+
+// newPhysicalVolume lays out a physical volume of extents as pvcreate does:
+// the label in sector 1, the metadata area at 4 KiB and the extents from
+// 1 MiB. The metadata text is written at textOff of the metadata area,
+// wrapping around its end, no metadata area is created for empty metadata.
+func newPhysicalVolume(uuid string, extents int64, metadata string, textOff int64) []byte {
+	pv := make([]byte, peStart+extents*extentSize)
+	label := pv[512:]
+	copy(label, "LABELONE")
+	binary.LittleEndian.PutUint64(label[8:], 1)
+	binary.LittleEndian.PutUint32(label[20:], 32)
+	copy(label[24:], "LVM2 001")
+	hdr := label[32:]
+	copy(hdr, uuid)
+	binary.LittleEndian.PutUint64(hdr[32:], uint64(len(pv)))
+	binary.LittleEndian.PutUint64(hdr[40:], peStart)
+	if metadata == "" {
+		return pv
+	}
+	binary.LittleEndian.PutUint64(hdr[72:], mdaOffset)
+	binary.LittleEndian.PutUint64(hdr[80:], mdaSize)
+
+	mda := pv[mdaOffset : mdaOffset+mdaSize]
+	copy(mda[4:], " LVM2 x[5A%r0N*>")
+	binary.LittleEndian.PutUint32(mda[20:], 1)
+	binary.LittleEndian.PutUint64(mda[24:], mdaOffset)
+	binary.LittleEndian.PutUint64(mda[32:], mdaSize)
+	binary.LittleEndian.PutUint64(mda[40:], uint64(textOff))
+	binary.LittleEndian.PutUint64(mda[48:], uint64(len(metadata)))
+	n := copy(mda[textOff:], metadata)
+	copy(mda[512:], metadata[n:])
+	return pv
+}
+
+// metadata describes the volume group vg0 of two physical volumes: root is
+// linear over both, its first extents after swap on pv0, and data is striped
+func metadata(rootExtents int64) string {
+	return fmt.Sprintf(`# Generated by LVM2 version 2.03.14(2) (2021-10-20): Thu Jan  1 00:00:00 2022
+
+contents = "Text Format Volume Group"
+version = 1
+
+description = "Created *after* executing 'vgcreate vg0 /dev/sda2 /dev/sdb'"
+
+vg0 {
+	id = "Tq1dU7-0bkp-FYVH-Hhvj-CRCd-NErW-QHKKrD"
+	seqno = 4
+	format = "lvm2"	# informational
+	status = ["RESIZEABLE", "READ", "WRITE"]
+	flags = []
+	extent_size = %d	# 64 Kilobytes
+	max_lv = 0
+	max_pv = 0
+	metadata_copies = 0
+
+	physical_volumes {
+
+		pv0 {
+			id = "aaaaaa-aaaa-aaaa-aaaa-aaaa-aaaa-aaaaaa"
+			device = "/dev/sda2"	# Hint only
+
+			status = ["ALLOCATABLE"]
+			flags = []
+			dev_size = 65536	# 32 Megabytes
+			pe_start = %d
+			pe_count = 400	# 25 Megabytes
+		}
+
+		pv1 {
+			id = "bbbbbb-bbbb-bbbb-bbbb-bbbb-bbbb-bbbbbb"
+			device = "/dev/sdb"	# Hint only
+
+			status = ["ALLOCATABLE"]
+			flags = []
+			dev_size = 65536	# 32 Megabytes
+			pe_start = %[2]d
+			pe_count = 400	# 25 Megabytes
+		}
+	}
+
+	logical_volumes {
+
+		swap {
+			id = "SwApSw-ApSw-ApSw-ApSw-ApSw-ApSw-ApSwAp"
+			status = ["READ", "WRITE", "VISIBLE"]
+			flags = []
+			creation_time = 1640995200	# 2022-01-01 00:00:00 +0000
+			creation_host = "localhost.localdomain"
+			segment_count = 1
+
+			segment1 {
+				start_extent = 0
+				extent_count = 16	# 1 Megabytes
+
+				type = "striped"
+				stripe_count = 1	# linear
+
+				stripes = [
+					"pv0", 0
+				]
+			}
+		}
+
+		root {
+			id = "RoOtRo-OtRo-OtRo-OtRo-OtRo-OtRo-OtRoOt"
+			status = ["READ", "WRITE", "VISIBLE"]
+			flags = []
+			segment_count = 2
+
+			segment2 {
+				start_extent = 100
+				extent_count = %[3]d
+
+				type = "striped"
+				stripe_count = 1	# linear
+
+				stripes = [
+					"pv1", 10
+				]
+			}
+			segment1 {
+				start_extent = 0
+				extent_count = 100	# 6.25 Megabytes
+
+				type = "striped"
+				stripe_count = 1	# linear
+
+				stripes = [
+					"pv0", 16
+				]
+			}
+		}
+
+		data {
+			id = "DaTaDa-TaDa-TaDa-TaDa-TaDa-TaDa-TaDaTa"
+			status = ["READ", "WRITE", "VISIBLE"]
+			flags = []
+			segment_count = 1
+
+			segment1 {
+				start_extent = 0
+				extent_count = 8
+
+				type = "striped"
+				stripe_count = 2
+				stripe_size = 128	# 64 Kilobytes
+
+				stripes = [
+					"pv0", 116,
+					"pv1", 0
+				]
+			}
+		}
+	}
+}
+`, extentSize/512, peStart/512, rootExtents-100)
+}
+
+func TestRead(t *testing.T) {
+	img, err := os.ReadFile("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("../xfs/testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootExtents := (int64(len(img)) + extentSize - 1) / extentSize
+	text := metadata(rootExtents)
+
+	for _, tt := range []struct {
+		name    string
+		textOff int64
+	}{
+		{name: "metadata", textOff: 512},
+		{name: "wrapped metadata", textOff: mdaSize - 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pv0 := newPhysicalVolume("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 400, text, tt.textOff)
+			pv1 := newPhysicalVolume("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", 400, "", 0)
+			// root extents 0-99 on pv0 from extent 16, the rest on pv1 from extent 10
+			copy(pv0[peStart+16*extentSize:], img[:100*extentSize])
+			copy(pv1[peStart+10*extentSize:], img[100*extentSize:])
+
+			if !lvm.IsPhysicalVolume(bytes.NewReader(pv1)) {
+				t.Error("IsPhysicalVolume failed on a physical volume")
+			}
+			vg, err := lvm.Read(bytes.NewReader(pv1), bytes.NewReader(pv0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vg.Name != "vg0" || vg.ExtentSize != extentSize {
+				t.Errorf("unexpected volume group %s of extent size %d", vg.Name, vg.ExtentSize)
+			}
+			var names []string
+			for _, lv := range vg.LogicalVolumes {
+				names = append(names, fmt.Sprintf("%s:%d:%t", lv.Name, lv.Size(), lv.Linear))
+			}
+			if actual, want := fmt.Sprint(names), fmt.Sprintf("[swap:%d:true root:%d:true data:%d:false]",
+				16*extentSize, rootExtents*extentSize, 8*extentSize); actual != want {
+				t.Errorf("expected %s, actual %s", want, actual)
+			}
+
+			root, err := vg.LogicalVolume("root")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := xfs.OpenReaderAt(root, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := fileSystem.ReadFile("etc/os-release")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("unexpected content %q", actual)
+			}
+
+			// a read across the two segments
+			buf := make([]byte, 2*extentSize)
+			if _, err := root.ReadAt(buf, 99*extentSize); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, img[99*extentSize:101*extentSize]) {
+				t.Error("unexpected content across segments")
+			}
+			if n, err := root.ReadAt(buf, root.Size()-10); n != 10 || err != io.EOF {
+				t.Errorf("expected 10 bytes and EOF at the end, actual %d %v", n, err)
+			}
+		})
+	}
+
+	t.Run("not linear", func(t *testing.T) {
+		pv0 := newPhysicalVolume("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 400, text, 512)
+		vg, err := lvm.Read(bytes.NewReader(pv0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := vg.LogicalVolume("data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := data.ReadAt(make([]byte, 512), 0); !errors.Is(err, lvm.ErrNotLinear) {
+			t.Errorf("expected ErrNotLinear, actual %v", err)
+		}
+	})
+
+	t.Run("missing physical volume", func(t *testing.T) {
+		pv0 := newPhysicalVolume("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 400, text, 512)
+		vg, err := lvm.Read(bytes.NewReader(pv0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := vg.LogicalVolume("root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := root.ReadAt(make([]byte, 512), 0); err != nil {
+			t.Errorf("unexpected error on pv0: %v", err)
+		}
+		if _, err := root.ReadAt(make([]byte, 512), 100*extentSize); err == nil {
+			t.Error("expected an error on the missing pv1")
+		}
+		if _, err := vg.LogicalVolume("home"); err == nil {
+			t.Error("expected an error for a missing logical volume")
+		}
+	})
+
+	t.Run("not a physical volume", func(t *testing.T) {
+		if lvm.IsPhysicalVolume(bytes.NewReader(img)) {
+			t.Error("IsPhysicalVolume succeeded on an XFS image")
+		}
+		if _, err := lvm.Read(bytes.NewReader(img)); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+// httpObject is an object served over HTTP with range requests
+type httpObject struct {
+	client *http.Client
+	url    string
+}
+
+// NewHTTP returns the object at url read with range requests, such as a
+// presigned S3 URL, a signed or public Google Cloud Storage URL or any server
+// supporting ranges. http.DefaultClient is used when client is nil.
+func NewHTTP(client *http.Client, url string) Object {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpObject{client: client, url: url}
+}
+
+// Size returns the size of the object from the Content-Range of the first
+// byte, presigned URLs are signed for GET only
+func (o *httpObject) Size(ctx context.Context) (int64, error) {
+	resp, err := o.get(ctx, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Content-Range: bytes 0-0/<size>
+	var first, last, size int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &size); err != nil {
+		return 0, xerrors.Errorf("invalid Content-Range %q: %w", resp.Header.Get("Content-Range"), err)
+	}
+	return size, nil
+}
+
+func (o *httpObject) ReadRange(ctx context.Context, off, n int64) (io.ReadCloser, error) {
+	resp, err := o.get(ctx, off, n)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (o *httpObject) get(ctx context.Context, off, n int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(off+n-1, 10))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return resp, nil
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, xerrors.Errorf("%s: %w", resp.Status, fs.ErrNotExist)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, xerrors.Errorf("%s: %w", resp.Status, fs.ErrPermission)
+	case http.StatusOK:
+		return nil, xerrors.New("the server does not support range requests")
+	}
+	return nil, xerrors.Errorf("unexpected status %s", resp.Status)
+}
@@ -0,0 +1,259 @@
+// Package objectstore reads objects of S3 compatible stores and HTTP servers
+// as xfs.SizeReaderAt, so disk images exported to object storage can be
+// opened in place:
+//
+//	object := objectstore.NewS3(client, "snapshots", "vm-0123.raw")
+//	r, err := objectstore.NewReaderAt(ctx, object, objectstore.Options{})
+//	fileSystem, err := xfs.OpenReaderAt(r, nil)
+//
+// Objects are read in blocks with ranged GETs, in parallel when a read spans
+// several blocks, and the recent blocks are kept in memory. It is a module of
+// its own so that the main module does not depend on cloud SDKs.
+package objectstore
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Object is an object of a store, read by byte ranges
+type Object interface {
+	// Size returns the size of the object in bytes
+	Size(ctx context.Context) (int64, error)
+	// ReadRange returns the n bytes at off of the object. Errors wrapping
+	// fs.ErrNotExist or fs.ErrPermission are not retried.
+	ReadRange(ctx context.Context, off, n int64) (io.ReadCloser, error)
+}
+
+// Options tune a ReaderAt, zero values select the defaults
+type Options struct {
+	// BlockSize is the size of the ranges read, 1 MiB by default
+	BlockSize int64
+	// CacheBlocks is the number of blocks kept in memory, 64 by default
+	CacheBlocks int
+	// Concurrency bounds the requests in flight, 8 by default
+	Concurrency int
+	// Retries is the number of times a failed request is retried, 3 by
+	// default and none when negative
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each
+	// following one, 100ms by default
+	Backoff time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.BlockSize <= 0 {
+		o.BlockSize = 1 << 20
+	}
+	if o.CacheBlocks <= 0 {
+		o.CacheBlocks = 64
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 8
+	}
+	if o.Retries == 0 {
+		o.Retries = 3
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 100 * time.Millisecond
+	}
+}
+
+// ReaderAt reads an Object through a cache of blocks, it is safe for
+// concurrent use
+type ReaderAt struct {
+	ctx    context.Context
+	object Object
+	size   int64
+	opts   Options
+	// sem holds a token per request in flight
+	sem chan struct{}
+
+	mu sync.Mutex
+	// blocks are the cached blocks by index, lru orders them from the most
+	// recently used
+	blocks map[int64]*list.Element
+	lru    *list.List
+	// fetches are the blocks being read, concurrent reads of a block wait
+	// for the same request
+	fetches map[int64]*fetch
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+type fetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewReaderAt returns a ReaderAt of object, ctx bounds every request made by
+// the reader
+func NewReaderAt(ctx context.Context, object Object, opts Options) (*ReaderAt, error) {
+	opts.setDefaults()
+	r := &ReaderAt{
+		ctx:     ctx,
+		object:  object,
+		opts:    opts,
+		sem:     make(chan struct{}, opts.Concurrency),
+		blocks:  map[int64]*list.Element{},
+		lru:     list.New(),
+		fetches: map[int64]*fetch{},
+	}
+	err := r.retry(func() (err error) {
+		r.size, err = object.Size(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get the size: %w", err)
+	}
+	return r, nil
+}
+
+// Size returns the size of the object in bytes
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes at off, fetching the blocks missing from the
+// cache in parallel
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	first, last := off/r.opts.BlockSize, (end-1)/r.opts.BlockSize
+
+	blocks := make([][]byte, last-first+1)
+	errs := make([]error, len(blocks))
+	var wg sync.WaitGroup
+	for i := range blocks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			blocks[i], errs[i] = r.block(first + int64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	n := 0
+	for i, data := range blocks {
+		if errs[i] != nil {
+			return n, errs[i]
+		}
+		start := (first + int64(i)) * r.opts.BlockSize
+		if pos := off + int64(n); pos > start {
+			data = data[pos-start:]
+		}
+		n += copy(p[n:end-off], data)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block returns the block index from the cache or the object
+func (r *ReaderAt) block(index int64) ([]byte, error) {
+	r.mu.Lock()
+	if e, ok := r.blocks[index]; ok {
+		r.lru.MoveToFront(e)
+		r.mu.Unlock()
+		return e.Value.(*cachedBlock).data, nil
+	}
+	if f, ok := r.fetches[index]; ok {
+		r.mu.Unlock()
+		<-f.done
+		return f.data, f.err
+	}
+	f := &fetch{done: make(chan struct{})}
+	r.fetches[index] = f
+	r.mu.Unlock()
+
+	f.data, f.err = r.fetch(index)
+	close(f.done)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.fetches, index)
+	if f.err == nil {
+		r.blocks[index] = r.lru.PushFront(&cachedBlock{index: index, data: f.data})
+		for r.lru.Len() > r.opts.CacheBlocks {
+			oldest := r.lru.Remove(r.lru.Back()).(*cachedBlock)
+			delete(r.blocks, oldest.index)
+		}
+	}
+	return f.data, f.err
+}
+
+// fetch reads the block index from the object
+func (r *ReaderAt) fetch(index int64) ([]byte, error) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+	defer func() { <-r.sem }()
+
+	off := index * r.opts.BlockSize
+	n := r.opts.BlockSize
+	if off+n > r.size {
+		n = r.size - off
+	}
+	data := make([]byte, n)
+	err := r.retry(func() error {
+		body, err := r.object.ReadRange(r.ctx, off, n)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.ReadFull(body, data)
+		return err
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %d bytes at %d: %w", n, off, err)
+	}
+	return data, nil
+}
+
+// retry calls fn until it succeeds, fails with an error not worth retrying
+// or the retries are exhausted
+func (r *ReaderAt) retry(fn func() error) error {
+	backoff := r.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == r.opts.Retries || !retryable(err) {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func retryable(err error) bool {
+	return !xerrors.Is(err, fs.ErrNotExist) && !xerrors.Is(err, fs.ErrPermission) &&
+		!xerrors.Is(err, context.Canceled) && !xerrors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,219 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/xerrors"
+)
+
+// memObject is an Object in memory counting its requests, the first failures
+// requests fail
+type memObject struct {
+	data []byte
+
+	mu       sync.Mutex
+	requests int
+	failures int
+	err      error
+}
+
+func (o *memObject) Size(context.Context) (int64, error) {
+	return int64(len(o.data)), nil
+}
+
+func (o *memObject) ReadRange(_ context.Context, off, n int64) (io.ReadCloser, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests++
+	if o.failures > 0 {
+		o.failures--
+		return nil, o.err
+	}
+	return io.NopCloser(bytes.NewReader(o.data[off : off+n])), nil
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestReaderAt(t *testing.T) {
+	data := randomData(10*4096 + 100)
+	object := &memObject{data: data}
+	r, err := NewReaderAt(context.Background(), object, Options{BlockSize: 4096, CacheBlocks: 4, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, actual %d", len(data), r.Size())
+	}
+
+	for _, tt := range []struct {
+		off, n   int64
+		requests int
+	}{
+		{off: 0, n: 512, requests: 1},
+		{off: 100, n: 100, requests: 0},
+		{off: 5*4096 - 96, n: 3 * 4096, requests: 4},
+		// the first block was evicted by the 4 others
+		{off: 0, n: 1, requests: 1},
+		{off: 10 * 4096, n: 100, requests: 1},
+	} {
+		before := object.requests
+		buf := make([]byte, tt.n)
+		if n, err := r.ReadAt(buf, tt.off); err != nil || int64(n) != tt.n {
+			t.Fatalf("read of %d bytes at %d: %d %v", tt.n, tt.off, n, err)
+		}
+		if !bytes.Equal(buf, data[tt.off:tt.off+tt.n]) {
+			t.Errorf("unexpected content of %d bytes at %d", tt.n, tt.off)
+		}
+		if requests := object.requests - before; requests != tt.requests {
+			t.Errorf("read of %d bytes at %d: expected %d requests, actual %d", tt.n, tt.off, tt.requests, requests)
+		}
+	}
+
+	buf := make([]byte, 200)
+	if n, err := r.ReadAt(buf, int64(len(data))-50); n != 50 || err != io.EOF {
+		t.Errorf("expected 50 bytes and EOF at the end, actual %d %v", n, err)
+	}
+	if n, err := r.ReadAt(buf, int64(len(data))); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF after the end, actual %d %v", n, err)
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		object := &memObject{data: data}
+		r, err := NewReaderAt(context.Background(), object, Options{BlockSize: 4096})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := make([]byte, len(data))
+				if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+					t.Error(err)
+				}
+				if !bytes.Equal(buf, data) {
+					t.Error("unexpected content")
+				}
+			}()
+		}
+		wg.Wait()
+		if object.requests != 11 {
+			t.Errorf("expected a request per block, actual %d", object.requests)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		object := &memObject{data: data, failures: 2, err: xerrors.New("connection reset")}
+		r, err := NewReaderAt(context.Background(), object, Options{BlockSize: 4096, Backoff: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadAt(make([]byte, 10), 0); err != nil {
+			t.Errorf("expected the read to succeed after 2 failures, actual %v", err)
+		}
+
+		object.failures = 10
+		if _, err := r.ReadAt(make([]byte, 10), 4096); err == nil || object.failures != 6 {
+			t.Errorf("expected an error after 3 retries, actual %v with %d failures left", err, object.failures)
+		}
+
+		object.failures, object.err = 10, fs.ErrPermission
+		if _, err := r.ReadAt(make([]byte, 10), 8192); !xerrors.Is(err, fs.ErrPermission) || object.failures != 9 {
+			t.Errorf("expected ErrPermission without retry, actual %v with %d failures left", err, object.failures)
+		}
+	})
+}
+
+func TestHTTP(t *testing.T) {
+	data := randomData(100000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/image.raw" {
+			http.NotFound(w, req)
+			return
+		}
+		http.ServeContent(w, req, "image.raw", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	r, err := NewReaderAt(context.Background(), NewHTTP(nil, srv.URL+"/image.raw"), Options{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, actual %d", len(data), r.Size())
+	}
+	buf := make([]byte, 10000)
+	if _, err := r.ReadAt(buf, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[5000:15000]) {
+		t.Error("unexpected content")
+	}
+
+	if _, err := NewReaderAt(context.Background(), NewHTTP(nil, srv.URL+"/missing"), Options{}); !xerrors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, actual %v", err)
+	}
+}
+
+func TestS3(t *testing.T) {
+	data := randomData(100000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/snapshots/image.raw" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if req.Method == http.MethodGet {
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+			return
+		}
+		http.ServeContent(w, req, "image.raw", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		EndpointResolver: s3.EndpointResolverFromURL(srv.URL),
+		UsePathStyle:     true,
+		Credentials:      aws.AnonymousCredentials{},
+	})
+	r, err := NewReaderAt(context.Background(), NewS3(client, "snapshots", "image.raw"), Options{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, actual %d", len(data), r.Size())
+	}
+	buf := make([]byte, 10000)
+	if _, err := r.ReadAt(buf, 95000); err != io.EOF {
+		t.Fatalf("expected EOF, actual %v", err)
+	}
+	if !bytes.Equal(buf[:5000], data[95000:]) {
+		t.Error("unexpected content")
+	}
+
+	_, err = NewReaderAt(context.Background(), NewS3(client, "snapshots", "missing"), Options{})
+	if !xerrors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "s3://snapshots/missing") {
+		t.Errorf("expected ErrNotExist, actual %v", err)
+	}
+	object := NewS3(client, "snapshots", "missing")
+	if _, err := object.ReadRange(context.Background(), 0, 10); !xerrors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist from GetObject, actual %v", err)
+	}
+}
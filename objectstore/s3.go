@@ -0,0 +1,64 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/xerrors"
+)
+
+// S3Client is the part of *s3.Client reading objects
+type S3Client interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// s3Object is an object of an S3 bucket
+type s3Object struct {
+	client S3Client
+	bucket string
+	key    string
+}
+
+// NewS3 returns the object key of bucket. Other S3 compatible stores, such
+// as Google Cloud Storage with HMAC keys or MinIO, are read with a client
+// configured with their endpoint.
+func NewS3(client S3Client, bucket, key string) Object {
+	return &s3Object{client: client, bucket: bucket, key: key}
+}
+
+func (o *s3Object) Size(ctx context.Context) (int64, error) {
+	out, err := o.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(o.key)})
+	if err != nil {
+		return 0, o.wrapError(err)
+	}
+	return out.ContentLength, nil
+}
+
+func (o *s3Object) ReadRange(ctx context.Context, off, n int64) (io.ReadCloser, error) {
+	out, err := o.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(o.key),
+		Range:  aws.String("bytes=" + strconv.FormatInt(off, 10) + "-" + strconv.FormatInt(off+n-1, 10)),
+	})
+	if err != nil {
+		return nil, o.wrapError(err)
+	}
+	return out.Body, nil
+}
+
+// wrapError marks missing objects with fs.ErrNotExist, HeadObject reports
+// them as NotFound and GetObject as NoSuchKey
+func (o *s3Object) wrapError(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if xerrors.As(err, &noSuchKey) || xerrors.As(err, &notFound) {
+		return xerrors.Errorf("s3://%s/%s: %w", o.bucket, o.key, fs.ErrNotExist)
+	}
+	return xerrors.Errorf("s3://%s/%s: %w", o.bucket, o.key, err)
+}
@@ -0,0 +1,208 @@
+// Package partition reads the MBR and GPT partition tables of whole disk
+// images, to locate the XFS file systems in them without another dependency.
+package partition
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	sectorSize = 512
+
+	mbrSignature   = 0xaa55
+	mbrProtective  = 0xee
+	maxLogicalPart = 128
+)
+
+var gptSignature = []byte("EFI PART")
+
+// mbrExtended are the MBR types of extended partitions holding logical ones
+var mbrExtended = map[byte]bool{0x05: true, 0x0f: true, 0x85: true}
+
+// GPT partition type GUIDs of Linux file systems and LVM physical volumes
+const (
+	TypeLinuxFilesystem = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+	TypeLinuxLVM        = "E6D6D379-F507-44C2-A23C-238F2A3DF928"
+)
+
+// Partition is an entry of an MBR or GPT partition table
+type Partition struct {
+	// Number is the number Linux gives the partition, from 1. MBR logical
+	// partitions start at 5.
+	Number int
+	// Offset and Size are in bytes from the start of the disk
+	Offset int64
+	Size   int64
+	// Type is the MBR type such as 0x83, or the GPT type GUID in upper case
+	Type string
+	// Name is the GPT partition name, MBR partitions have none
+	Name string
+	// XFS is set when the partition starts with an XFS superblock
+	XFS bool
+
+	r io.ReaderAt
+}
+
+// SectionReader returns the reader of the partition, to open it with
+// xfs.OpenReaderAt
+func (p Partition) SectionReader() *io.SectionReader {
+	return io.NewSectionReader(p.r, p.Offset, p.Size)
+}
+
+// Read parses the MBR or GPT partition table of a whole disk image of size
+// bytes, nil is returned when r has no partition table.
+func Read(r io.ReaderAt, size int64) ([]Partition, error) {
+	mbr := make([]byte, sectorSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return nil, xerrors.Errorf("failed to read the MBR: %w", err)
+	}
+	if binary.LittleEndian.Uint16(mbr[510:]) != mbrSignature {
+		return nil, nil
+	}
+
+	var parts []Partition
+	for i := 0; i < 4; i++ {
+		e := mbr[446+i*16:]
+		typ := e[4]
+		start, sectors := int64(binary.LittleEndian.Uint32(e[8:])), int64(binary.LittleEndian.Uint32(e[12:]))
+		switch {
+		case typ == 0 || sectors == 0:
+			continue
+		case typ == mbrProtective:
+			return readGPT(r, size)
+		case mbrExtended[typ]:
+			logical, err := readLogicalPartitions(r, start*sectorSize)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, logical...)
+			continue
+		}
+		parts = append(parts, Partition{
+			Number: i + 1,
+			Offset: start * sectorSize,
+			Size:   sectors * sectorSize,
+			Type:   fmt.Sprintf("0x%02x", typ),
+		})
+	}
+	return checkPartitions(r, size, parts)
+}
+
+// readLogicalPartitions follows the chain of extended boot records, the
+// offsets of the next EBR are relative to the extended partition.
+func readLogicalPartitions(r io.ReaderAt, extended int64) ([]Partition, error) {
+	var parts []Partition
+	ebr := make([]byte, sectorSize)
+	offset := extended
+	for len(parts) < maxLogicalPart {
+		if _, err := r.ReadAt(ebr, offset); err != nil {
+			return nil, xerrors.Errorf("failed to read the EBR at %d: %w", offset, err)
+		}
+		if binary.LittleEndian.Uint16(ebr[510:]) != mbrSignature {
+			return nil, xerrors.Errorf("invalid EBR signature at %d", offset)
+		}
+		if sectors := binary.LittleEndian.Uint32(ebr[446+12:]); sectors != 0 {
+			parts = append(parts, Partition{
+				Number: 5 + len(parts),
+				Offset: offset + int64(binary.LittleEndian.Uint32(ebr[446+8:]))*sectorSize,
+				Size:   int64(sectors) * sectorSize,
+				Type:   fmt.Sprintf("0x%02x", ebr[446+4]),
+			})
+		}
+		next := int64(binary.LittleEndian.Uint32(ebr[462+8:])) * sectorSize
+		if next == 0 {
+			return parts, nil
+		}
+		offset = extended + next
+	}
+	return nil, xerrors.Errorf("more than %d logical partitions, the EBR chain loops", maxLogicalPart)
+}
+
+// readGPT parses the primary GPT header and its partition entries
+func readGPT(r io.ReaderAt, size int64) ([]Partition, error) {
+	hdr := make([]byte, 92)
+	if _, err := r.ReadAt(hdr, sectorSize); err != nil {
+		return nil, xerrors.Errorf("failed to read the GPT header: %w", err)
+	}
+	if !bytes.Equal(hdr[:8], gptSignature) {
+		return nil, xerrors.New("protective MBR without a GPT header")
+	}
+	entriesLBA := int64(binary.LittleEndian.Uint64(hdr[72:]))
+	count := binary.LittleEndian.Uint32(hdr[80:])
+	entrySize := binary.LittleEndian.Uint32(hdr[84:])
+	if entrySize < 128 || count > 1024 {
+		return nil, xerrors.Errorf("invalid GPT: %d entries of %d bytes", count, entrySize)
+	}
+	entries := make([]byte, int64(count)*int64(entrySize))
+	if _, err := r.ReadAt(entries, entriesLBA*sectorSize); err != nil {
+		return nil, xerrors.Errorf("failed to read the GPT entries: %w", err)
+	}
+
+	var parts []Partition
+	for i := 0; i < int(count); i++ {
+		e := entries[i*int(entrySize):]
+		var typ [16]byte
+		copy(typ[:], e)
+		if typ == [16]byte{} {
+			continue
+		}
+		first, last := int64(binary.LittleEndian.Uint64(e[32:])), int64(binary.LittleEndian.Uint64(e[40:]))
+		if last < first {
+			return nil, xerrors.Errorf("invalid GPT entry %d: LBA %d-%d", i+1, first, last)
+		}
+		name := make([]uint16, 36)
+		for j := range name {
+			name[j] = binary.LittleEndian.Uint16(e[56+j*2:])
+		}
+		parts = append(parts, Partition{
+			Number: i + 1,
+			Offset: first * sectorSize,
+			Size:   (last - first + 1) * sectorSize,
+			Type:   formatGUID(typ),
+			Name:   strings.TrimRight(string(utf16.Decode(name)), "\x00"),
+		})
+	}
+	return checkPartitions(r, size, parts)
+}
+
+// checkPartitions rejects partitions beyond the end of the image and looks
+// for an XFS superblock in the others
+func checkPartitions(r io.ReaderAt, size int64, parts []Partition) ([]Partition, error) {
+	for i, p := range parts {
+		if p.Offset+p.Size > size {
+			return nil, xerrors.Errorf("partition %d ends at %d, beyond the image of %d bytes", p.Number, p.Offset+p.Size, size)
+		}
+		parts[i].r = r
+		parts[i].XFS = xfs.Check(parts[i].SectionReader())
+	}
+	return parts, nil
+}
+
+// formatGUID formats a GPT GUID, whose first three fields are little endian
+func formatGUID(g [16]byte) string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X", binary.LittleEndian.Uint32(g[0:]), binary.LittleEndian.Uint16(g[4:]),
+		binary.LittleEndian.Uint16(g[6:]), g[8:10], g[10:16])
+}
+
+// Select returns the partition number, or the only or first XFS partition
+// when number is 0
+func Select(parts []Partition, number int) (Partition, error) {
+	for _, p := range parts {
+		if number == 0 && p.XFS || number != 0 && p.Number == number {
+			return p, nil
+		}
+	}
+	if number == 0 {
+		return Partition{}, xerrors.New("no XFS partition found")
+	}
+	return Partition{}, xerrors.Errorf("partition %d does not exist", number)
+}
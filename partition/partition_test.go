@@ -0,0 +1,154 @@
+package partition_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/partition"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func TestRead(t *testing.T) {
+	img, err := os.ReadFile("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const start = 2048
+	sectors := uint32(len(img) / 512)
+	newDisk := func(extra int, table func(disk []byte)) []byte {
+		disk := make([]byte, start*512+len(img)+extra)
+		copy(disk[start*512:], img)
+		table(disk)
+		binary.LittleEndian.PutUint16(disk[510:], 0xaa55)
+		return disk
+	}
+	mbrEntry := func(e []byte, typ byte, first, count uint32) {
+		e[4] = typ
+		binary.LittleEndian.PutUint32(e[8:], first)
+		binary.LittleEndian.PutUint32(e[12:], count)
+	}
+
+	tests := []struct {
+		name     string
+		disk     []byte
+		expected []partition.Partition
+	}{
+		{
+			name: "mbr",
+			disk: newDisk(0, func(disk []byte) {
+				mbrEntry(disk[446:], 0x83, start, sectors)
+			}),
+			expected: []partition.Partition{
+				{Number: 1, Offset: start * 512, Size: int64(len(img)), Type: "0x83", XFS: true},
+			},
+		},
+		{
+			// an extended partition after the XFS one, holding a logical
+			// partition of 8 sectors after its EBR
+			name: "mbr logical",
+			disk: newDisk(16*512, func(disk []byte) {
+				extended := start + sectors
+				mbrEntry(disk[446:], 0x83, start, sectors)
+				mbrEntry(disk[462:], 0x05, extended, 16)
+				ebr := disk[extended*512:]
+				mbrEntry(ebr[446:], 0x82, 1, 8)
+				binary.LittleEndian.PutUint16(ebr[510:], 0xaa55)
+			}),
+			expected: []partition.Partition{
+				{Number: 1, Offset: start * 512, Size: int64(len(img)), Type: "0x83", XFS: true},
+				{Number: 5, Offset: (start + int64(sectors) + 1) * 512, Size: 8 * 512, Type: "0x82"},
+			},
+		},
+		{
+			name: "gpt",
+			disk: newDisk(0, func(disk []byte) {
+				mbrEntry(disk[446:], 0xee, 1, uint32(len(disk)/512-1))
+				copy(disk[512:], "EFI PART")
+				binary.LittleEndian.PutUint64(disk[512+72:], 2)
+				binary.LittleEndian.PutUint32(disk[512+80:], 128)
+				binary.LittleEndian.PutUint32(disk[512+84:], 128)
+				for i, p := range []struct {
+					typ         string
+					first, last uint64
+					name        string
+				}{
+					{"28732ac11ff8d211ba4b00a0c93ec93b", 40, start - 1, "EFI"},
+					{"af3dc60f838472478e793d69d8477de4", start, start + uint64(sectors) - 1, "root"},
+				} {
+					e := disk[1024+i*128:]
+					hex.Decode(e, []byte(p.typ))
+					binary.LittleEndian.PutUint64(e[32:], p.first)
+					binary.LittleEndian.PutUint64(e[40:], p.last)
+					for j, r := range p.name {
+						binary.LittleEndian.PutUint16(e[56+j*2:], uint16(r))
+					}
+				}
+			}),
+			expected: []partition.Partition{
+				{Number: 1, Offset: 40 * 512, Size: (start - 40) * 512, Type: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", Name: "EFI"},
+				{Number: 2, Offset: start * 512, Size: int64(len(img)), Type: partition.TypeLinuxFilesystem, Name: "root", XFS: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.disk)
+			parts, err := partition.Read(r, r.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != len(tt.expected) {
+				t.Fatalf("expected %d partitions, actual %d", len(tt.expected), len(parts))
+			}
+			for i, p := range parts {
+				section := p.SectionReader()
+				if section.Size() != p.Size {
+					t.Errorf("partition %d: section of %d bytes", p.Number, section.Size())
+				}
+				// the reader is not part of the comparison
+				p = partition.Partition{Number: p.Number, Offset: p.Offset, Size: p.Size, Type: p.Type, Name: p.Name, XFS: p.XFS}
+				if !reflect.DeepEqual(p, tt.expected[i]) {
+					t.Errorf("expected %+v, actual %+v", tt.expected[i], p)
+				}
+			}
+
+			p, err := partition.Select(parts, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := xfs.OpenReaderAt(p.SectionReader(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fileSystem.Stat("etc/os-release"); err != nil {
+				t.Error(err)
+			}
+			if _, err := partition.Select(parts, 9); err == nil {
+				t.Error("expected an error for a missing partition")
+			}
+		})
+	}
+
+	t.Run("no table", func(t *testing.T) {
+		parts, err := partition.Read(bytes.NewReader(img), int64(len(img)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parts != nil {
+			t.Errorf("unexpected partitions %+v", parts)
+		}
+	})
+
+	t.Run("beyond the end", func(t *testing.T) {
+		disk := newDisk(0, func(disk []byte) {
+			mbrEntry(disk[446:], 0x83, start, sectors+1)
+		})
+		if _, err := partition.Read(bytes.NewReader(disk), int64(len(disk))); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
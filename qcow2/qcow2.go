@@ -0,0 +1,274 @@
+// Package qcow2 reads the virtual disk of QEMU qcow2 images, version 2 and 3,
+// as an xfs.SizeReaderAt. Compressed clusters are inflated, encrypted images
+// and images with a backing file or an external data file are not supported.
+package qcow2
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	minClusterBits = 9
+	maxClusterBits = 21
+	// maxL1Size is the limit of QEMU on the size of the L1 table
+	maxL1Size = 32 << 20
+
+	// offsetMask selects the host offset of L1 and standard L2 entries
+	offsetMask = 0x00fffffffffffe00
+	// compressedFlag marks L2 entries of compressed clusters
+	compressedFlag = 1 << 62
+	// zeroFlag marks standard L2 entries of clusters reading as zeros, version 3
+	zeroFlag = 1
+
+	// incompatible features of version 3
+	featureDirty   = 1 << 0
+	featureCorrupt = 1 << 1
+)
+
+var magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// ErrUnsupported is returned by Open for images using features this package
+// does not read
+var ErrUnsupported = xerrors.New("unsupported qcow2 image")
+
+// header is the header of version 2, version 3 extends it with headerV3
+type header struct {
+	Magic                 [4]byte
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+}
+
+type headerV3 struct {
+	IncompatibleFeatures uint64
+	CompatibleFeatures   uint64
+	AutoclearFeatures    uint64
+	RefcountOrder        uint32
+	HeaderLength         uint32
+}
+
+// Image is the virtual disk of a qcow2 image, unallocated clusters read as
+// zeros. It implements xfs.SizeReaderAt and is safe for concurrent use.
+type Image struct {
+	r           xfs.SizeReaderAt
+	size        int64
+	clusterBits uint32
+	version     uint32
+	l1          []uint64
+
+	mu sync.Mutex
+	// l2 caches the L2 tables by their offset in the image
+	l2 map[uint64][]uint64
+}
+
+// IsImage reports whether r starts with the qcow2 magic
+func IsImage(r io.ReaderAt) bool {
+	b := make([]byte, len(magic))
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(b, magic)
+}
+
+// Open reads the header and the L1 table of the qcow2 image r
+func Open(r xfs.SizeReaderAt) (*Image, error) {
+	var hdr header
+	if err := binary.Read(io.NewSectionReader(r, 0, r.Size()), binary.BigEndian, &hdr); err != nil {
+		return nil, xerrors.Errorf("failed to read the header: %w", err)
+	}
+	if !bytes.Equal(hdr.Magic[:], magic) {
+		return nil, xerrors.New("not a qcow2 image")
+	}
+	switch hdr.Version {
+	case 2:
+	case 3:
+		var v3 headerV3
+		if err := binary.Read(io.NewSectionReader(r, int64(binary.Size(hdr)), r.Size()), binary.BigEndian, &v3); err != nil {
+			return nil, xerrors.Errorf("failed to read the header: %w", err)
+		}
+		if v3.IncompatibleFeatures&featureCorrupt != 0 {
+			return nil, xerrors.New("image is marked corrupt")
+		}
+		// dirty images only have stale refcounts, which are not read
+		if features := v3.IncompatibleFeatures &^ featureDirty; features != 0 {
+			return nil, xerrors.Errorf("incompatible features %#x: %w", features, ErrUnsupported)
+		}
+	default:
+		return nil, xerrors.Errorf("version %d: %w", hdr.Version, ErrUnsupported)
+	}
+	if hdr.ClusterBits < minClusterBits || hdr.ClusterBits > maxClusterBits {
+		return nil, xerrors.Errorf("invalid cluster bits %d", hdr.ClusterBits)
+	}
+	if hdr.CryptMethod != 0 {
+		return nil, xerrors.Errorf("encryption method %d: %w", hdr.CryptMethod, ErrUnsupported)
+	}
+	if hdr.BackingFileOffset != 0 {
+		return nil, xerrors.Errorf("backing file: %w", ErrUnsupported)
+	}
+	if int64(hdr.Size) < 0 {
+		return nil, xerrors.Errorf("invalid size %d", hdr.Size)
+	}
+
+	// each L1 entry maps an L2 table of a cluster of 8 byte entries
+	l2Entries := uint64(1) << (hdr.ClusterBits - 3)
+	clusters := (hdr.Size + 1<<hdr.ClusterBits - 1) >> hdr.ClusterBits
+	if uint64(hdr.L1Size) < (clusters+l2Entries-1)/l2Entries {
+		return nil, xerrors.Errorf("L1 table of %d entries does not cover the size %d", hdr.L1Size, hdr.Size)
+	}
+	if hdr.L1Size > maxL1Size/8 {
+		return nil, xerrors.Errorf("L1 table of %d entries is too large", hdr.L1Size)
+	}
+	b := make([]byte, 8*int(hdr.L1Size))
+	if _, err := r.ReadAt(b, int64(hdr.L1TableOffset)); err != nil {
+		return nil, xerrors.Errorf("failed to read the L1 table: %w", err)
+	}
+	l1 := make([]uint64, hdr.L1Size)
+	for i := range l1 {
+		l1[i] = binary.BigEndian.Uint64(b[i*8:])
+	}
+
+	return &Image{
+		r:           r,
+		size:        int64(hdr.Size),
+		clusterBits: hdr.ClusterBits,
+		version:     hdr.Version,
+		l1:          l1,
+		l2:          make(map[uint64][]uint64),
+	}, nil
+}
+
+// Size returns the size of the virtual disk
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ReadAt reads the virtual disk, reading each cluster with one ReadAt of the
+// image
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	clusterSize := int64(1) << img.clusterBits
+	var n int
+	for n < len(p) {
+		if off >= img.size {
+			return n, io.EOF
+		}
+		within := off & (clusterSize - 1)
+		chunk := p[n:]
+		if rest := clusterSize - within; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if rest := img.size - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if err := img.readCluster(chunk, off>>img.clusterBits, within); err != nil {
+			return n, xerrors.Errorf("failed to read offset %d: %w", off, err)
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	return n, nil
+}
+
+// readCluster fills p from offset within of the virtual cluster
+func (img *Image) readCluster(p []byte, cluster, within int64) error {
+	entry, err := img.l2Entry(cluster)
+	if err != nil {
+		return err
+	}
+	if entry&compressedFlag != 0 {
+		data, err := img.inflate(entry)
+		if err != nil {
+			return err
+		}
+		copy(p, data[within:])
+		return nil
+	}
+	host := entry & offsetMask
+	if host == 0 || (img.version >= 3 && entry&zeroFlag != 0) {
+		for i := range p {
+			p[i] = 0
+		}
+		return nil
+	}
+	if _, err := img.r.ReadAt(p, int64(host)+within); err != nil {
+		return xerrors.Errorf("failed to read cluster at %d: %w", host, err)
+	}
+	return nil
+}
+
+// l2Entry returns the L2 entry of the virtual cluster, 0 when no L2 table maps
+// it
+func (img *Image) l2Entry(cluster int64) (uint64, error) {
+	l2Bits := img.clusterBits - 3
+	l1Index := cluster >> l2Bits
+	if l1Index >= int64(len(img.l1)) {
+		return 0, nil
+	}
+	offset := img.l1[l1Index] & offsetMask
+	if offset == 0 {
+		return 0, nil
+	}
+
+	img.mu.Lock()
+	table, ok := img.l2[offset]
+	img.mu.Unlock()
+	if !ok {
+		b := make([]byte, 1<<img.clusterBits)
+		if _, err := img.r.ReadAt(b, int64(offset)); err != nil {
+			return 0, xerrors.Errorf("failed to read the L2 table at %d: %w", offset, err)
+		}
+		table = make([]uint64, len(b)/8)
+		for i := range table {
+			table[i] = binary.BigEndian.Uint64(b[i*8:])
+		}
+		img.mu.Lock()
+		img.l2[offset] = table
+		img.mu.Unlock()
+	}
+	return table[cluster&(1<<l2Bits-1)], nil
+}
+
+// inflate returns the content of the compressed cluster of the L2 entry. The
+// entry holds the offset of the deflate stream and the number of 512 byte
+// sectors it spans after the first.
+func (img *Image) inflate(entry uint64) ([]byte, error) {
+	shift := 62 - (img.clusterBits - 8)
+	offset := int64(entry & (1<<shift - 1))
+	sectors := int64(entry>>shift&(1<<(img.clusterBits-8)-1)) + 1
+	length := sectors*512 - offset&511
+	// the last stream of the image may end before its last sector
+	if size := img.r.Size(); offset+length > size {
+		length = size - offset
+	}
+	if length <= 0 {
+		return nil, xerrors.Errorf("compressed cluster at %d beyond the image", offset)
+	}
+	b := make([]byte, length)
+	if _, err := img.r.ReadAt(b, offset); err != nil {
+		return nil, xerrors.Errorf("failed to read compressed cluster at %d: %w", offset, err)
+	}
+	data := make([]byte, 1<<img.clusterBits)
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(b)), data); err != nil {
+		return nil, xerrors.Errorf("failed to inflate cluster at %d: %w", offset, err)
+	}
+	return data, nil
+}
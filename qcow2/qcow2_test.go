@@ -0,0 +1,219 @@
+package qcow2_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/qcow2"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	clusterBits = 16
+	clusterSize = 1 << clusterBits
+	// partOffset is the offset of the XFS partition in the virtual disk
+	partOffset = 1 << 20
+)
+
+// newDisk returns a virtual disk holding the tiny v4 file system at
+// partOffset, followed by unused space
+func newDisk(t *testing.T) []byte {
+	t.Helper()
+	raw, err := os.ReadFile("../xfs/testdata/tiny/v4.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := make([]byte, partOffset+len(raw)+partOffset)
+	copy(disk[partOffset:], raw)
+	return disk
+}
+
+// newImage lays out disk as qemu-img does: the header cluster, the L1 table,
+// then the L2 tables and the clusters that are not zero. The clusters are
+// stored backwards, so virtual and image offsets differ. Compressed clusters
+// are packed without alignment. In version 3, zero clusters of mapped L2
+// tables point at a cluster of garbage with the zero flag set.
+func newImage(disk []byte, version uint32, compress bool) []byte {
+	const l2Entries = clusterSize / 8
+	clusters := (len(disk) + clusterSize - 1) / clusterSize
+	l1Size := (clusters + l2Entries - 1) / l2Entries
+
+	image := make([]byte, clusterSize)
+	hdr := image
+	copy(hdr, "QFI\xfb")
+	binary.BigEndian.PutUint32(hdr[4:], version)
+	binary.BigEndian.PutUint32(hdr[20:], clusterBits)
+	binary.BigEndian.PutUint64(hdr[24:], uint64(len(disk)))
+	binary.BigEndian.PutUint32(hdr[36:], uint32(l1Size))
+	binary.BigEndian.PutUint64(hdr[40:], clusterSize)
+	if version == 3 {
+		binary.BigEndian.PutUint32(hdr[96:], 4)
+		binary.BigEndian.PutUint32(hdr[100:], 104)
+	}
+	l1 := make([]byte, (l1Size*8+clusterSize-1)/clusterSize*clusterSize)
+	image = append(image, l1...)
+
+	zero := make([]byte, clusterSize)
+	cluster := func(i int) []byte {
+		c := make([]byte, clusterSize)
+		copy(c, disk[i*clusterSize:])
+		return c
+	}
+	garbage := int64(0)
+	if version == 3 {
+		garbage = int64(len(image))
+		image = append(image, bytes.Repeat([]byte{0xa5}, clusterSize)...)
+	}
+	for l1Index := l1Size - 1; l1Index >= 0; l1Index-- {
+		first, last := l1Index*l2Entries, (l1Index+1)*l2Entries
+		if last > clusters {
+			last = clusters
+		}
+		l2Offset := int64(len(image))
+		image = append(image, zero...)
+		for i := last - 1; i >= first; i-- {
+			var entry uint64
+			switch c := cluster(i); {
+			case bytes.Equal(c, zero):
+				if garbage != 0 {
+					entry = uint64(garbage) | 1
+				}
+			case compress:
+				var b bytes.Buffer
+				w, _ := flate.NewWriter(&b, flate.BestCompression)
+				w.Write(c)
+				w.Close()
+				offset := int64(len(image))
+				sectors := (offset&511 + int64(b.Len()) + 511) / 512
+				entry = 1<<62 | uint64(sectors-1)<<(62-(clusterBits-8)) | uint64(offset)
+				image = append(image, b.Bytes()...)
+			default:
+				entry = uint64(len(image))
+				image = append(image, c...)
+			}
+			binary.BigEndian.PutUint64(image[l2Offset+int64(i-first)*8:], entry)
+		}
+		binary.BigEndian.PutUint64(image[clusterSize+l1Index*8:], uint64(l2Offset)|1<<63)
+	}
+	return image
+}
+
+func TestOpen(t *testing.T) {
+	disk := newDisk(t)
+	tests := []struct {
+		name     string
+		version  uint32
+		compress bool
+	}{
+		{name: "version 2", version: 2},
+		{name: "version 3", version: 3},
+		{name: "compressed", version: 3, compress: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := newImage(disk, tt.version, tt.compress)
+			if len(image) >= len(disk)/2 {
+				t.Fatalf("the image is not sparse: %d bytes for a disk of %d", len(image), len(disk))
+			}
+			if !qcow2.IsImage(bytes.NewReader(image)) {
+				t.Fatal("IsImage failed")
+			}
+
+			// XFS in a partition of the virtual disk of a qcow2 object
+			img, err := qcow2.Open(bytes.NewReader(image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Size() != int64(len(disk)) {
+				t.Fatalf("expected size %d, actual %d", len(disk), img.Size())
+			}
+			actual, err := io.ReadAll(io.NewSectionReader(img, 0, img.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, disk) {
+				t.Fatal("unexpected content of the virtual disk")
+			}
+
+			part := io.NewSectionReader(img, partOffset, int64(len(disk)-2*partOffset))
+			fileSystem, err := xfs.OpenReaderAt(part, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := fileSystem.ReadFile("hello.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "hello, world\n" {
+				t.Errorf("unexpected content %q", b)
+			}
+
+			// reads spanning clusters, from many goroutines
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(off int64) {
+					defer wg.Done()
+					p := make([]byte, 3*clusterSize)
+					if _, err := img.ReadAt(p, off); err != nil {
+						t.Error(err)
+						return
+					}
+					if !bytes.Equal(p, disk[off:off+int64(len(p))]) {
+						t.Errorf("unexpected content at %d", off)
+					}
+				}(partOffset + int64(i)*clusterSize/3)
+			}
+			wg.Wait()
+
+			if n, err := img.ReadAt(make([]byte, 10), img.Size()-4); n != 4 || err != io.EOF {
+				t.Errorf("expected 4 and io.EOF at the end, actual %d %v", n, err)
+			}
+		})
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	image := newImage(make([]byte, 4*clusterSize), 3, false)
+	tests := []struct {
+		name        string
+		modify      func(hdr []byte)
+		unsupported bool
+	}{
+		{name: "magic", modify: func(hdr []byte) { hdr[0] = 'q' }},
+		{name: "version", modify: func(hdr []byte) { binary.BigEndian.PutUint32(hdr[4:], 1) }, unsupported: true},
+		{name: "backing file", modify: func(hdr []byte) { binary.BigEndian.PutUint64(hdr[8:], 512) }, unsupported: true},
+		{name: "cluster bits", modify: func(hdr []byte) { binary.BigEndian.PutUint32(hdr[20:], 30) }},
+		{name: "encryption", modify: func(hdr []byte) { binary.BigEndian.PutUint32(hdr[32:], 2) }, unsupported: true},
+		{name: "L1 size", modify: func(hdr []byte) { binary.BigEndian.PutUint64(hdr[24:], 1<<40) }},
+		{name: "corrupt", modify: func(hdr []byte) { binary.BigEndian.PutUint64(hdr[72:], 2) }},
+		{name: "external data file", modify: func(hdr []byte) { binary.BigEndian.PutUint64(hdr[72:], 4) }, unsupported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, image...)
+			tt.modify(b)
+			_, err := qcow2.Open(bytes.NewReader(b))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if xerrors.Is(err, qcow2.ErrUnsupported) != tt.unsupported {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+
+	// dirty images are read
+	b := append([]byte{}, image...)
+	binary.BigEndian.PutUint64(b[72:], 1)
+	if _, err := qcow2.Open(bytes.NewReader(b)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package vhd reads the virtual disk of fixed and dynamic VHD images, as
+// written by Hyper-V, Virtual PC, Azure and qemu-img, as an xfs.SizeReaderAt.
+// Differencing disks are not supported.
+package vhd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	sectorSize = 512
+	footerSize = 512
+	// dynamicHeaderSize is the size of the dynamic disk header, all of which
+	// its checksum covers
+	dynamicHeaderSize = 1024
+
+	diskFixed        = 2
+	diskDynamic      = 3
+	diskDifferencing = 4
+
+	// unallocated marks BAT entries of blocks reading as zeros
+	unallocated = 0xffffffff
+	// maxBATSize bounds the block allocation table read by Open
+	maxBATSize = 64 << 20
+)
+
+var (
+	footerCookie  = []byte("conectix")
+	dynamicCookie = []byte("cxsparse")
+)
+
+// ErrUnsupported is returned by Open for differencing disks
+var ErrUnsupported = xerrors.New("unsupported VHD image")
+
+// footer is the hard disk footer at the end of every image, dynamic images
+// also copy it to their start
+type footer struct {
+	Cookie             [8]byte
+	Features           uint32
+	FileFormatVersion  uint32
+	DataOffset         uint64
+	TimeStamp          uint32
+	CreatorApplication [4]byte
+	CreatorVersion     uint32
+	CreatorHostOS      [4]byte
+	OriginalSize       uint64
+	CurrentSize        uint64
+	DiskGeometry       uint32
+	DiskType           uint32
+	Checksum           uint32
+	UniqueID           [16]byte
+	SavedState         uint8
+}
+
+// dynamicHeader is the header of dynamic and differencing disks, up to the
+// fields describing the parent
+type dynamicHeader struct {
+	Cookie          [8]byte
+	DataOffset      uint64
+	TableOffset     uint64
+	HeaderVersion   uint32
+	MaxTableEntries uint32
+	BlockSize       uint32
+	Checksum        uint32
+}
+
+// Image is the virtual disk of a VHD image. It implements xfs.SizeReaderAt
+// and is safe for concurrent use.
+type Image struct {
+	r    xfs.SizeReaderAt
+	size int64
+
+	// blockSize, bitmapSize and bat are set for dynamic disks, the data of
+	// a block follows its sector bitmap
+	blockSize  int64
+	bitmapSize int64
+	bat        []uint32
+}
+
+// IsImage reports whether r ends with a VHD footer
+func IsImage(r xfs.SizeReaderAt) bool {
+	if r.Size() < footerSize {
+		return false
+	}
+	b := make([]byte, len(footerCookie))
+	if _, err := r.ReadAt(b, r.Size()-footerSize); err != nil {
+		return false
+	}
+	return bytes.Equal(b, footerCookie)
+}
+
+// Open reads the footer of the VHD image r and the block allocation table of
+// dynamic disks
+func Open(r xfs.SizeReaderAt) (*Image, error) {
+	if r.Size() < footerSize {
+		return nil, xerrors.New("not a VHD image")
+	}
+	b := make([]byte, footerSize)
+	if _, err := r.ReadAt(b, r.Size()-footerSize); err != nil {
+		return nil, xerrors.Errorf("failed to read the footer: %w", err)
+	}
+	var ftr footer
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &ftr); err != nil {
+		return nil, xerrors.Errorf("failed to read the footer: %w", err)
+	}
+	if !bytes.Equal(ftr.Cookie[:], footerCookie) {
+		return nil, xerrors.New("not a VHD image")
+	}
+	if sum := checksum(b, 64); sum != ftr.Checksum {
+		return nil, xerrors.Errorf("footer checksum %#x, expected %#x", ftr.Checksum, sum)
+	}
+	if int64(ftr.CurrentSize) < 0 {
+		return nil, xerrors.Errorf("invalid size %d", ftr.CurrentSize)
+	}
+	img := &Image{r: r, size: int64(ftr.CurrentSize)}
+
+	switch ftr.DiskType {
+	case diskFixed:
+		if img.size > r.Size()-footerSize {
+			return nil, xerrors.Errorf("size %d exceeds the image", img.size)
+		}
+		return img, nil
+	case diskDynamic:
+	case diskDifferencing:
+		return nil, xerrors.Errorf("differencing disk: %w", ErrUnsupported)
+	default:
+		return nil, xerrors.Errorf("invalid disk type %d", ftr.DiskType)
+	}
+
+	b = make([]byte, dynamicHeaderSize)
+	if _, err := r.ReadAt(b, int64(ftr.DataOffset)); err != nil {
+		return nil, xerrors.Errorf("failed to read the dynamic disk header: %w", err)
+	}
+	var hdr dynamicHeader
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &hdr); err != nil {
+		return nil, xerrors.Errorf("failed to read the dynamic disk header: %w", err)
+	}
+	if !bytes.Equal(hdr.Cookie[:], dynamicCookie) {
+		return nil, xerrors.New("invalid dynamic disk header cookie")
+	}
+	if sum := checksum(b, 36); sum != hdr.Checksum {
+		return nil, xerrors.Errorf("dynamic disk header checksum %#x, expected %#x", hdr.Checksum, sum)
+	}
+	if hdr.BlockSize < sectorSize || hdr.BlockSize&(hdr.BlockSize-1) != 0 {
+		return nil, xerrors.Errorf("invalid block size %d", hdr.BlockSize)
+	}
+	img.blockSize = int64(hdr.BlockSize)
+	if blocks := (img.size + img.blockSize - 1) / img.blockSize; int64(hdr.MaxTableEntries) < blocks {
+		return nil, xerrors.Errorf("block allocation table of %d entries does not cover the size %d", hdr.MaxTableEntries, img.size)
+	}
+	if hdr.MaxTableEntries > maxBATSize/4 {
+		return nil, xerrors.Errorf("block allocation table of %d entries is too large", hdr.MaxTableEntries)
+	}
+	// one bit per sector, padded to a sector
+	img.bitmapSize = (img.blockSize/sectorSize/8 + sectorSize - 1) / sectorSize * sectorSize
+
+	b = make([]byte, 4*int(hdr.MaxTableEntries))
+	if _, err := r.ReadAt(b, int64(hdr.TableOffset)); err != nil {
+		return nil, xerrors.Errorf("failed to read the block allocation table: %w", err)
+	}
+	img.bat = make([]uint32, hdr.MaxTableEntries)
+	for i := range img.bat {
+		img.bat[i] = binary.BigEndian.Uint32(b[i*4:])
+	}
+	return img, nil
+}
+
+// checksum is the one's complement of the sum of the bytes of b, skipping
+// the checksum field at offset
+func checksum(b []byte, offset int) uint32 {
+	var sum uint32
+	for i, c := range b {
+		if i >= offset && i < offset+4 {
+			continue
+		}
+		sum += uint32(c)
+	}
+	return ^sum
+}
+
+// Size returns the size of the virtual disk
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ReadAt reads the virtual disk, unallocated blocks of dynamic disks read as
+// zeros
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	if img.bat == nil {
+		if off >= img.size {
+			return 0, io.EOF
+		}
+		if rest := img.size - off; int64(len(p)) > rest {
+			n, err := img.r.ReadAt(p[:rest], off)
+			if err == nil {
+				err = io.EOF
+			}
+			return n, err
+		}
+		return img.r.ReadAt(p, off)
+	}
+
+	var n int
+	for n < len(p) {
+		if off >= img.size {
+			return n, io.EOF
+		}
+		block, within := off/img.blockSize, off%img.blockSize
+		chunk := p[n:]
+		if rest := img.blockSize - within; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if rest := img.size - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if sector := img.bat[block]; sector == unallocated {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else if _, err := img.r.ReadAt(chunk, int64(sector)*sectorSize+img.bitmapSize+within); err != nil {
+			return n, xerrors.Errorf("failed to read block %d at sector %d: %w", block, sector, err)
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	return n, nil
+}
@@ -0,0 +1,220 @@
+package vhd_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/vhd"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	blockSize = 2 << 20
+	// partOffset is the offset of the XFS partition in the virtual disk
+	partOffset = 1 << 20
+)
+
+// newDisk returns a virtual disk holding the tiny v4 file system at
+// partOffset, followed by unused space up to a whole number of blocks
+func newDisk(t *testing.T) []byte {
+	t.Helper()
+	raw, err := os.ReadFile("../xfs/testdata/tiny/v4.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := make([]byte, partOffset+len(raw)+3*partOffset)
+	copy(disk[partOffset:], raw)
+	return disk
+}
+
+// setChecksum writes the checksum of the footer or the dynamic disk header b
+// at offset
+func setChecksum(b []byte, offset int) {
+	binary.BigEndian.PutUint32(b[offset:], 0)
+	var sum uint32
+	for _, c := range b {
+		sum += uint32(c)
+	}
+	binary.BigEndian.PutUint32(b[offset:], ^sum)
+}
+
+func newFooter(size int, diskType uint32) []byte {
+	f := make([]byte, 512)
+	copy(f, "conectix")
+	binary.BigEndian.PutUint32(f[8:], 2)
+	binary.BigEndian.PutUint32(f[12:], 0x00010000)
+	binary.BigEndian.PutUint64(f[16:], 0xffffffffffffffff)
+	copy(f[28:], "qemu")
+	copy(f[36:], "Wi2k")
+	binary.BigEndian.PutUint64(f[40:], uint64(size))
+	binary.BigEndian.PutUint64(f[48:], uint64(size))
+	binary.BigEndian.PutUint32(f[60:], diskType)
+	if diskType != 2 {
+		binary.BigEndian.PutUint64(f[16:], 512)
+	}
+	copy(f[68:], "0123456789abcdef")
+	setChecksum(f, 64)
+	return f
+}
+
+// newFixed appends the footer to disk
+func newFixed(disk []byte) []byte {
+	return append(append([]byte{}, disk...), newFooter(len(disk), 2)...)
+}
+
+// newDynamic lays out disk as a dynamic image: the footer copy, the dynamic
+// disk header, the block allocation table, then the blocks that are not zero,
+// each after its sector bitmap, and the footer. The blocks are stored
+// backwards, so virtual and image offsets differ.
+func newDynamic(disk []byte) []byte {
+	const bitmapSize = 512
+	blocks := (len(disk) + blockSize - 1) / blockSize
+	ftr := newFooter(len(disk), 3)
+
+	image := append([]byte{}, ftr...)
+	hdr := make([]byte, 1024)
+	copy(hdr, "cxsparse")
+	binary.BigEndian.PutUint64(hdr[8:], 0xffffffffffffffff)
+	binary.BigEndian.PutUint64(hdr[16:], 1536)
+	binary.BigEndian.PutUint32(hdr[24:], 0x00010000)
+	binary.BigEndian.PutUint32(hdr[28:], uint32(blocks))
+	binary.BigEndian.PutUint32(hdr[32:], blockSize)
+	setChecksum(hdr, 36)
+	image = append(image, hdr...)
+
+	batOffset := len(image)
+	image = append(image, make([]byte, (blocks*4+511)/512*512)...)
+	zero := make([]byte, blockSize)
+	for i := blocks - 1; i >= 0; i-- {
+		block := make([]byte, blockSize)
+		copy(block, disk[i*blockSize:])
+		entry := uint32(0xffffffff)
+		if !bytes.Equal(block, zero) {
+			entry = uint32(len(image) / 512)
+			image = append(image, bytes.Repeat([]byte{0xff}, bitmapSize)...)
+			image = append(image, block...)
+		}
+		binary.BigEndian.PutUint32(image[batOffset+i*4:], entry)
+	}
+	return append(image, ftr...)
+}
+
+func TestOpen(t *testing.T) {
+	disk := newDisk(t)
+	tests := []struct {
+		name  string
+		image []byte
+	}{
+		{name: "fixed", image: newFixed(disk)},
+		{name: "dynamic", image: newDynamic(disk)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !vhd.IsImage(bytes.NewReader(tt.image)) {
+				t.Fatal("IsImage failed")
+			}
+
+			// XFS in a partition of the virtual disk of a VHD object
+			img, err := vhd.Open(bytes.NewReader(tt.image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Size() != int64(len(disk)) {
+				t.Fatalf("expected size %d, actual %d", len(disk), img.Size())
+			}
+			actual, err := io.ReadAll(io.NewSectionReader(img, 0, img.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, disk) {
+				t.Fatal("unexpected content of the virtual disk")
+			}
+
+			part := io.NewSectionReader(img, partOffset, int64(len(disk)-4*partOffset))
+			fileSystem, err := xfs.OpenReaderAt(part, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := fileSystem.ReadFile("hello.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "hello, world\n" {
+				t.Errorf("unexpected content %q", b)
+			}
+
+			if n, err := img.ReadAt(make([]byte, 10), img.Size()-4); n != 4 || err != io.EOF {
+				t.Errorf("expected 4 and io.EOF at the end, actual %d %v", n, err)
+			}
+		})
+	}
+
+	if vhd.IsImage(bytes.NewReader(disk)) {
+		t.Error("IsImage succeeded on a raw disk")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	disk := make([]byte, 4*blockSize)
+	disk[0] = 1
+	tests := []struct {
+		name        string
+		image       []byte
+		unsupported bool
+	}{
+		{name: "checksum", image: func() []byte {
+			b := newFixed(disk)
+			b[len(b)-1] ^= 1
+			return b
+		}()},
+		{name: "size", image: func() []byte {
+			b := newFixed(disk)
+			f := b[len(b)-512:]
+			binary.BigEndian.PutUint64(f[48:], uint64(len(b)))
+			setChecksum(f, 64)
+			return b
+		}()},
+		{name: "differencing", image: func() []byte {
+			b := newDynamic(disk)
+			f := b[len(b)-512:]
+			binary.BigEndian.PutUint32(f[60:], 4)
+			setChecksum(f, 64)
+			return b
+		}(), unsupported: true},
+		{name: "block size", image: func() []byte {
+			b := newDynamic(disk)
+			hdr := b[512:1536]
+			binary.BigEndian.PutUint32(hdr[32:], 3000)
+			setChecksum(hdr, 36)
+			return b
+		}()},
+		{name: "table entries", image: func() []byte {
+			b := newDynamic(disk)
+			hdr := b[512:1536]
+			binary.BigEndian.PutUint32(hdr[28:], 1)
+			setChecksum(hdr, 36)
+			return b
+		}()},
+		{name: "header checksum", image: func() []byte {
+			b := newDynamic(disk)
+			b[600] ^= 1
+			return b
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := vhd.Open(bytes.NewReader(tt.image))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if xerrors.Is(err, vhd.ErrUnsupported) != tt.unsupported {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+// Package vmdk reads the virtual disk of monolithic sparse and stream
+// optimized VMDK images, the single file layouts of VMware products and OVA
+// appliances, as an xfs.SizeReaderAt. Monolithic flat images are read
+// directly through their -flat.vmdk extent.
+package vmdk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	sectorSize = 512
+
+	// gdAtEnd is the grain directory offset of stream optimized images, the
+	// footer at the end of the image holds the actual one
+	gdAtEnd = 0xffffffffffffffff
+	// footerOffset is the offset of the footer from the end of the image,
+	// it is followed by the end-of-stream marker
+	footerOffset = 2 * sectorSize
+
+	flagValidNewLine   = 1 << 0
+	flagCompressed     = 1 << 16
+	compressionDeflate = 1
+
+	// grain table entries of unallocated grains and of grains reading as
+	// zeros
+	grainUnallocated = 0
+	grainZero        = 1
+
+	maxGrainSize = 1 << 20
+	maxGTEsPerGT = 1 << 16
+	// maxGDSize bounds the grain directory read by Open
+	maxGDSize = 64 << 20
+)
+
+var magic = []byte("KDMV")
+
+// ErrUnsupported is returned by Open for images using features this package
+// does not read
+var ErrUnsupported = xerrors.New("unsupported VMDK image")
+
+// header is the sparse extent header, repeated by the footer of stream
+// optimized images
+type header struct {
+	Magic              [4]byte
+	Version            uint32
+	Flags              uint32
+	Capacity           uint64
+	GrainSize          uint64
+	DescriptorOffset   uint64
+	DescriptorSize     uint64
+	NumGTEsPerGT       uint32
+	RgdOffset          uint64
+	GdOffset           uint64
+	OverHead           uint64
+	UncleanShutdown    uint8
+	SingleEndLineChar  byte
+	NonEndLineChar     byte
+	DoubleEndLineChar1 byte
+	DoubleEndLineChar2 byte
+	CompressAlgorithm  uint16
+}
+
+// Image is the virtual disk of a VMDK sparse extent, unallocated grains read
+// as zeros. It implements xfs.SizeReaderAt and is safe for concurrent use.
+type Image struct {
+	r          xfs.SizeReaderAt
+	size       int64
+	grainSize  int64
+	gtEntries  int64
+	compressed bool
+	gd         []uint32
+
+	mu sync.Mutex
+	// gt caches the grain tables by their index in the grain directory
+	gt map[int64][]uint32
+}
+
+// IsImage reports whether r starts with the magic of a sparse extent
+func IsImage(r io.ReaderAt) bool {
+	b := make([]byte, len(magic))
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(b, magic)
+}
+
+func readHeader(r io.ReaderAt, off int64) (header, error) {
+	var hdr header
+	if err := binary.Read(io.NewSectionReader(r, off, sectorSize), binary.LittleEndian, &hdr); err != nil {
+		return hdr, err
+	}
+	if !bytes.Equal(hdr.Magic[:], magic) {
+		return hdr, xerrors.New("not a VMDK sparse extent")
+	}
+	return hdr, nil
+}
+
+// Open reads the header and the grain directory of the sparse extent r
+func Open(r xfs.SizeReaderAt) (*Image, error) {
+	hdr, err := readHeader(r, 0)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the header: %w", err)
+	}
+	if hdr.GdOffset == gdAtEnd {
+		if r.Size() < footerOffset {
+			return nil, xerrors.New("no footer found")
+		}
+		if hdr, err = readHeader(r, r.Size()-footerOffset); err != nil {
+			return nil, xerrors.Errorf("failed to read the footer: %w", err)
+		}
+	}
+	if hdr.Version < 1 || hdr.Version > 3 {
+		return nil, xerrors.Errorf("version %d: %w", hdr.Version, ErrUnsupported)
+	}
+	// the line end characters detect images transferred in text mode
+	if hdr.Flags&flagValidNewLine != 0 && (hdr.SingleEndLineChar != '\n' || hdr.NonEndLineChar != ' ' ||
+		hdr.DoubleEndLineChar1 != '\r' || hdr.DoubleEndLineChar2 != '\n') {
+		return nil, xerrors.New("corrupted line end characters, transferred in text mode")
+	}
+	compressed := hdr.Flags&flagCompressed != 0
+	if compressed && hdr.CompressAlgorithm != compressionDeflate {
+		return nil, xerrors.Errorf("compression algorithm %d: %w", hdr.CompressAlgorithm, ErrUnsupported)
+	}
+	if hdr.GrainSize == 0 || hdr.GrainSize&(hdr.GrainSize-1) != 0 || hdr.GrainSize*sectorSize > maxGrainSize {
+		return nil, xerrors.Errorf("invalid grain size %d", hdr.GrainSize)
+	}
+	if hdr.NumGTEsPerGT == 0 || hdr.NumGTEsPerGT > maxGTEsPerGT {
+		return nil, xerrors.Errorf("invalid number of grain table entries %d", hdr.NumGTEsPerGT)
+	}
+	if hdr.Capacity > 1<<63/sectorSize {
+		return nil, xerrors.Errorf("invalid capacity %d", hdr.Capacity)
+	}
+	if hdr.GdOffset == gdAtEnd || hdr.GdOffset == 0 {
+		return nil, xerrors.New("no grain directory found")
+	}
+
+	gtCoverage := hdr.GrainSize * uint64(hdr.NumGTEsPerGT)
+	entries := (hdr.Capacity + gtCoverage - 1) / gtCoverage
+	if entries > maxGDSize/4 {
+		return nil, xerrors.Errorf("grain directory of %d entries is too large", entries)
+	}
+	b := make([]byte, 4*entries)
+	if _, err := r.ReadAt(b, int64(hdr.GdOffset)*sectorSize); err != nil {
+		return nil, xerrors.Errorf("failed to read the grain directory: %w", err)
+	}
+	gd := make([]uint32, entries)
+	for i := range gd {
+		gd[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+
+	return &Image{
+		r:          r,
+		size:       int64(hdr.Capacity) * sectorSize,
+		grainSize:  int64(hdr.GrainSize) * sectorSize,
+		gtEntries:  int64(hdr.NumGTEsPerGT),
+		compressed: compressed,
+		gd:         gd,
+		gt:         make(map[int64][]uint32),
+	}, nil
+}
+
+// Size returns the size of the virtual disk
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ReadAt reads the virtual disk, reading each grain with one ReadAt of the
+// image
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	var n int
+	for n < len(p) {
+		if off >= img.size {
+			return n, io.EOF
+		}
+		within := off % img.grainSize
+		chunk := p[n:]
+		if rest := img.grainSize - within; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if rest := img.size - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if err := img.readGrain(chunk, off/img.grainSize, within); err != nil {
+			return n, xerrors.Errorf("failed to read offset %d: %w", off, err)
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	return n, nil
+}
+
+// readGrain fills p from offset within of the virtual grain
+func (img *Image) readGrain(p []byte, grain, within int64) error {
+	sector, err := img.gtEntry(grain)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sector == grainUnallocated || sector == grainZero:
+		for i := range p {
+			p[i] = 0
+		}
+	case img.compressed:
+		data, err := img.inflate(int64(sector) * sectorSize)
+		if err != nil {
+			return err
+		}
+		copy(p, data[within:])
+	default:
+		if _, err := img.r.ReadAt(p, int64(sector)*sectorSize+within); err != nil {
+			return xerrors.Errorf("failed to read grain at sector %d: %w", sector, err)
+		}
+	}
+	return nil
+}
+
+// gtEntry returns the grain table entry of the virtual grain
+func (img *Image) gtEntry(grain int64) (uint32, error) {
+	index := grain / img.gtEntries
+	sector := img.gd[index]
+	if sector == 0 {
+		return grainUnallocated, nil
+	}
+
+	img.mu.Lock()
+	table, ok := img.gt[index]
+	img.mu.Unlock()
+	if !ok {
+		b := make([]byte, 4*img.gtEntries)
+		if _, err := img.r.ReadAt(b, int64(sector)*sectorSize); err != nil {
+			return 0, xerrors.Errorf("failed to read the grain table at sector %d: %w", sector, err)
+		}
+		table = make([]uint32, img.gtEntries)
+		for i := range table {
+			table[i] = binary.LittleEndian.Uint32(b[i*4:])
+		}
+		img.mu.Lock()
+		img.gt[index] = table
+		img.mu.Unlock()
+	}
+	return table[grain%img.gtEntries], nil
+}
+
+// inflate returns the content of the compressed grain at offset, a zlib
+// stream after the grain marker of its virtual sector and length. The last
+// grain of the disk may inflate to less than a grain.
+func (img *Image) inflate(offset int64) ([]byte, error) {
+	marker := make([]byte, 12)
+	if _, err := img.r.ReadAt(marker, offset); err != nil {
+		return nil, xerrors.Errorf("failed to read the grain marker at %d: %w", offset, err)
+	}
+	length := int64(binary.LittleEndian.Uint32(marker[8:]))
+	if length == 0 || length > img.r.Size()-offset-12 {
+		return nil, xerrors.Errorf("invalid compressed grain length %d at %d", length, offset)
+	}
+	zr, err := zlib.NewReader(io.NewSectionReader(img.r, offset+12, length))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to inflate grain at %d: %w", offset, err)
+	}
+	data := make([]byte, img.grainSize)
+	if _, err := io.ReadFull(zr, data); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, xerrors.Errorf("failed to inflate grain at %d: %w", offset, err)
+	}
+	return data, nil
+}
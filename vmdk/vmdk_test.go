@@ -0,0 +1,241 @@
+package vmdk_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/vmdk"
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+const (
+	grainSectors = 128
+	grainSize    = grainSectors * 512
+	gtEntries    = 512
+	// partOffset is the offset of the XFS partition in the virtual disk
+	partOffset = 1 << 20
+)
+
+// newDisk returns a virtual disk holding the tiny v4 file system at
+// partOffset, followed by unused space
+func newDisk(t *testing.T) []byte {
+	t.Helper()
+	raw, err := os.ReadFile("../xfs/testdata/tiny/v4.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk := make([]byte, partOffset+len(raw)+partOffset)
+	copy(disk[partOffset:], raw)
+	return disk
+}
+
+func newHeader(disk []byte, stream bool, gdOffset uint64) []byte {
+	hdr := make([]byte, 512)
+	copy(hdr, "KDMV")
+	flags, version := uint32(1), uint32(1)
+	if stream {
+		flags, version = 1|1<<16|1<<17, 3
+		binary.LittleEndian.PutUint16(hdr[77:], 1)
+	}
+	binary.LittleEndian.PutUint32(hdr[4:], version)
+	binary.LittleEndian.PutUint32(hdr[8:], flags)
+	binary.LittleEndian.PutUint64(hdr[12:], uint64(len(disk)/512))
+	binary.LittleEndian.PutUint64(hdr[20:], grainSectors)
+	binary.LittleEndian.PutUint64(hdr[28:], 1)
+	binary.LittleEndian.PutUint64(hdr[36:], 1)
+	binary.LittleEndian.PutUint32(hdr[44:], gtEntries)
+	binary.LittleEndian.PutUint64(hdr[56:], gdOffset)
+	copy(hdr[73:], "\n \r\n")
+	return hdr
+}
+
+func pad(b []byte) []byte {
+	return append(b, make([]byte, (512-len(b)%512)%512)...)
+}
+
+// newImage lays out disk as a monolithic sparse extent: the header, the
+// descriptor, the grain directory and tables, then the grains that are not
+// zero. Stream optimized extents hold the compressed grains after the
+// descriptor, then the grain tables and directory, and repeat the header with
+// the grain directory offset in the footer. The grains are stored backwards,
+// so virtual and image offsets differ.
+func newImage(disk []byte, stream bool) []byte {
+	grains := (len(disk) + grainSize - 1) / grainSize
+	tables := (grains + gtEntries - 1) / gtEntries
+	descriptor := pad([]byte("# Disk DescriptorFile\nversion=1\ncreateType=\"monolithicSparse\"\n"))
+
+	gd := pad(make([]byte, tables*4))
+	gts := make([]byte, tables*gtEntries*4)
+	var grainData []byte
+	// grainsOffset is the offset of the grains, gtsOffset of the tables
+	var grainsOffset, gtsOffset int
+	if stream {
+		grainsOffset = 512 + len(descriptor)
+	} else {
+		gtsOffset = 512 + len(descriptor) + len(gd)
+		grainsOffset = gtsOffset + len(gts)
+	}
+
+	zero := make([]byte, grainSize)
+	for i := grains - 1; i >= 0; i-- {
+		grain := make([]byte, grainSize)
+		copy(grain, disk[i*grainSize:])
+		if bytes.Equal(grain, zero) {
+			continue
+		}
+		sector := uint32((grainsOffset + len(grainData)) / 512)
+		binary.LittleEndian.PutUint32(gts[i*4:], sector)
+		if !stream {
+			grainData = append(grainData, grain...)
+			continue
+		}
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		w.Write(grain)
+		w.Close()
+		marker := make([]byte, 12)
+		binary.LittleEndian.PutUint64(marker, uint64(i*grainSectors))
+		binary.LittleEndian.PutUint32(marker[8:], uint32(b.Len()))
+		grainData = append(grainData, pad(append(marker, b.Bytes()...))...)
+	}
+	if stream {
+		gtsOffset = grainsOffset + len(grainData)
+	}
+	for i := 0; i < tables; i++ {
+		binary.LittleEndian.PutUint32(gd[i*4:], uint32(gtsOffset/512+i*gtEntries*4/512))
+	}
+
+	if !stream {
+		image := newHeader(disk, false, uint64(512+len(descriptor))/512)
+		image = append(image, descriptor...)
+		image = append(image, gd...)
+		image = append(image, gts...)
+		return append(image, grainData...)
+	}
+	image := newHeader(disk, true, 0xffffffffffffffff)
+	image = append(image, descriptor...)
+	image = append(image, grainData...)
+	image = append(image, gts...)
+	gdOffset := len(image)
+	image = append(image, gd...)
+	// footer marker, footer and end-of-stream marker
+	image = append(image, make([]byte, 512)...)
+	image = append(image, newHeader(disk, true, uint64(gdOffset/512))...)
+	return append(image, make([]byte, 512)...)
+}
+
+func TestOpen(t *testing.T) {
+	disk := newDisk(t)
+	tests := []struct {
+		name   string
+		stream bool
+	}{
+		{name: "monolithic sparse"},
+		{name: "stream optimized", stream: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := newImage(disk, tt.stream)
+			if len(image) >= len(disk)/2 {
+				t.Fatalf("the image is not sparse: %d bytes for a disk of %d", len(image), len(disk))
+			}
+			if !vmdk.IsImage(bytes.NewReader(image)) {
+				t.Fatal("IsImage failed")
+			}
+
+			// XFS in a partition of the virtual disk of a VMDK object
+			img, err := vmdk.Open(bytes.NewReader(image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.Size() != int64(len(disk)) {
+				t.Fatalf("expected size %d, actual %d", len(disk), img.Size())
+			}
+			actual, err := io.ReadAll(io.NewSectionReader(img, 0, img.Size()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, disk) {
+				t.Fatal("unexpected content of the virtual disk")
+			}
+
+			part := io.NewSectionReader(img, partOffset, int64(len(disk)-2*partOffset))
+			fileSystem, err := xfs.OpenReaderAt(part, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := fileSystem.ReadFile("hello.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "hello, world\n" {
+				t.Errorf("unexpected content %q", b)
+			}
+
+			// reads spanning grains, from many goroutines
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(off int64) {
+					defer wg.Done()
+					p := make([]byte, 3*grainSize)
+					if _, err := img.ReadAt(p, off); err != nil {
+						t.Error(err)
+						return
+					}
+					if !bytes.Equal(p, disk[off:off+int64(len(p))]) {
+						t.Errorf("unexpected content at %d", off)
+					}
+				}(partOffset + int64(i)*grainSize/3)
+			}
+			wg.Wait()
+
+			if n, err := img.ReadAt(make([]byte, 10), img.Size()-4); n != 4 || err != io.EOF {
+				t.Errorf("expected 4 and io.EOF at the end, actual %d %v", n, err)
+			}
+		})
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	disk := make([]byte, 4*grainSize)
+	disk[0] = 1
+	image := newImage(disk, false)
+	tests := []struct {
+		name        string
+		modify      func(hdr []byte)
+		unsupported bool
+	}{
+		{name: "magic", modify: func(hdr []byte) { hdr[0] = 'k' }},
+		{name: "version", modify: func(hdr []byte) { binary.LittleEndian.PutUint32(hdr[4:], 4) }, unsupported: true},
+		{name: "text mode", modify: func(hdr []byte) { copy(hdr[73:], "\n \n\n") }},
+		{name: "compression", modify: func(hdr []byte) {
+			binary.LittleEndian.PutUint32(hdr[8:], 1|1<<16)
+			binary.LittleEndian.PutUint16(hdr[77:], 2)
+		}, unsupported: true},
+		{name: "grain size", modify: func(hdr []byte) { binary.LittleEndian.PutUint64(hdr[20:], 100) }},
+		{name: "grain table entries", modify: func(hdr []byte) { binary.LittleEndian.PutUint32(hdr[44:], 0) }},
+		{name: "no grain directory", modify: func(hdr []byte) { binary.LittleEndian.PutUint64(hdr[56:], 0) }},
+		{name: "no footer", modify: func(hdr []byte) { binary.LittleEndian.PutUint64(hdr[56:], 0xffffffffffffffff) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, image...)
+			tt.modify(b)
+			_, err := vmdk.Open(bytes.NewReader(b))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if xerrors.Is(err, vmdk.ErrUnsupported) != tt.unsupported {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
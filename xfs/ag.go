@@ -120,22 +120,37 @@ func parseSuperBlock(r io.Reader) (SuperBlock, error) {
 		return SuperBlock{}, xerrors.Errorf("failed to read superblock: %w", err)
 	}
 	if sb.Magicnum != XFS_SB_MAGIC {
-		return SuperBlock{}, xerrors.Errorf("failed to parse superblock magic byte error: %08x", sb.Magicnum)
+		return SuperBlock{}, xerrors.Errorf("failed to parse superblock magic byte error: %08x: %w", sb.Magicnum, ErrNotXFS)
+	}
+	if err := sb.validate(); err != nil {
+		return SuperBlock{}, err
 	}
 	return sb, nil
 }
 
+// ParseAG parses the AG headers, the superblock, AGF, AGI and AGFL each take
+// one sector of sb_sectsize bytes.
 func ParseAG(reader io.Reader) (*AG, error) {
-	r := io.LimitReader(reader, int64(utils.BlockSize))
-
 	var ag AG
 	var err error
-	ag.SuperBlock, err = parseSuperBlock(r)
+	ag.SuperBlock, err = parseSuperBlock(reader)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to parse super block: %w", err)
 	}
 
-	buf, err := utils.ReadSector(r)
+	sectSize := int64(ag.SuperBlock.Sectsize)
+	if _, err := io.CopyN(io.Discard, reader, sectSize-utils.SectorSize); err != nil {
+		return nil, xerrors.Errorf("failed to skip superblock sector: %w", err)
+	}
+	readSector := func() ([]byte, error) {
+		buf := make([]byte, sectSize)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, xerrors.Errorf("failed to read: %w", err)
+		}
+		return buf, nil
+	}
+
+	buf, err := readSector()
 	if err != nil {
 		return nil, xerrors.Errorf("failed to create afg reader: %w", err)
 	}
@@ -143,10 +158,13 @@ func ParseAG(reader io.Reader) (*AG, error) {
 		return nil, xerrors.Errorf("failed to read afg: %w", err)
 	}
 	if ag.Agf.Magicnum != XFS_AGF_MAGIC {
-		return nil, xerrors.Errorf("failed to parse agf magic byte error: %08x", ag.Agf.Magicnum)
+		return nil, newCorruptedError("agf", -1, "magic byte error: %08x", ag.Agf.Magicnum)
+	}
+	if ag.Agf.Versionnum != XFS_AGF_VERSION {
+		return nil, newCorruptedError("agf", -1, "unknown version %d", ag.Agf.Versionnum)
 	}
 
-	buf, err = utils.ReadSector(r)
+	buf, err = readSector()
 	if err != nil {
 		return nil, xerrors.Errorf("failed to create agi reader: %w", err)
 	}
@@ -154,18 +172,22 @@ func ParseAG(reader io.Reader) (*AG, error) {
 		return nil, xerrors.Errorf("failed to read agi: %w", err)
 	}
 	if ag.Agi.Magicnum != XFS_AGI_MAGIC {
-		return nil, xerrors.Errorf("failed to parse agi magic byte error: %08x", ag.Agi.Magicnum)
+		return nil, newCorruptedError("agi", -1, "magic byte error: %08x", ag.Agi.Magicnum)
+	}
+	if ag.Agi.Versionnum != XFS_AGI_VERSION {
+		return nil, newCorruptedError("agi", -1, "unknown version %d", ag.Agi.Versionnum)
 	}
 
-	buf, err = utils.ReadSector(r)
+	buf, err = readSector()
 	if err != nil {
 		return nil, xerrors.Errorf("failed to create agfl reader: %w", err)
 	}
 	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &ag.Agfl); err != nil {
 		return nil, xerrors.Errorf("failed to read agfl: %w", err)
 	}
-	if ag.Agfl.Magicnum != XFS_AGFL_MAGIC {
-		return nil, xerrors.Errorf("failed to parse agfl magic byte error: %08x", ag.Agfl.Magicnum)
+	// the AGFL header was added in v5, older AGFLs are the bare block array
+	if ag.SuperBlock.IsV5() && ag.Agfl.Magicnum != XFS_AGFL_MAGIC {
+		return nil, newCorruptedError("agfl", -1, "magic byte error: %08x", ag.Agfl.Magicnum)
 	}
 
 	return &ag, nil
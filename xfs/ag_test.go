@@ -0,0 +1,122 @@
+package xfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"golang.org/x/xerrors"
+)
+
+func TestParseAGSectorSize(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	headers := make([]byte, 4*512)
+	if _, err := f.ReadAt(headers, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		sectorSize int
+		sectorLog  uint8
+	}{
+		{name: "512 bytes sectors", sectorSize: 512, sectorLog: 9},
+		{name: "4096 bytes sectors", sectorSize: 4096, sectorLog: 12},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// place the superblock, AGF, AGI and AGFL at the start of each sector
+			b := make([]byte, 4*tt.sectorSize)
+			for i := 0; i < 4; i++ {
+				copy(b[i*tt.sectorSize:], headers[i*512:(i+1)*512])
+			}
+			binary.BigEndian.PutUint16(b[102:], uint16(tt.sectorSize))
+			b[121] = tt.sectorLog
+
+			ag, err := xfs.ParseAG(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ag.Agf.Magicnum != xfs.XFS_AGF_MAGIC || ag.Agi.Magicnum != xfs.XFS_AGI_MAGIC || ag.Agfl.Magicnum != xfs.XFS_AGFL_MAGIC {
+				t.Errorf("unexpected AG headers: %08x %08x %08x", ag.Agf.Magicnum, ag.Agi.Magicnum, ag.Agfl.Magicnum)
+			}
+		})
+	}
+
+	t.Run("sector log mismatch", func(t *testing.T) {
+		b := make([]byte, len(headers))
+		copy(b, headers)
+		b[121] = 12
+		if xfs.Check(bytes.NewReader(b)) {
+			t.Fatal("expected invalid superblock")
+		}
+	})
+}
+
+func TestParseAGMagic(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	headers := make([]byte, 4*512)
+	if _, err := f.ReadAt(headers, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		modify      func(b []byte)
+		expectedErr error
+	}{
+		{
+			name:   "valid headers",
+			modify: func(b []byte) {},
+		},
+		{
+			name:        "AGF magic",
+			modify:      func(b []byte) { binary.BigEndian.PutUint32(b[512:], 0) },
+			expectedErr: xfs.ErrCorrupted,
+		},
+		{
+			name:        "AGI version",
+			modify:      func(b []byte) { binary.BigEndian.PutUint32(b[2*512+4:], 2) },
+			expectedErr: xfs.ErrCorrupted,
+		},
+		{
+			name:        "AGFL magic",
+			modify:      func(b []byte) { binary.BigEndian.PutUint32(b[3*512:], 0) },
+			expectedErr: xfs.ErrCorrupted,
+		},
+		{
+			name: "v4 AGFL without header",
+			modify: func(b []byte) {
+				binary.BigEndian.PutUint16(b[100:], 4)
+				binary.BigEndian.PutUint32(b[3*512:], 0)
+			},
+		},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, len(headers))
+			copy(b, headers)
+			tt.modify(b)
+			_, err := xfs.ParseAG(bytes.NewReader(b))
+			if tt.expectedErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !xerrors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected %v, actual %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
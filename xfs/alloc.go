@@ -0,0 +1,215 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// blockAlloc is an allocation of blocks planned in the free extents of an AG,
+// commit writes it
+type blockAlloc struct {
+	xfs      *FileSystem
+	agNumber uint64
+	// free are the free extents of the AG in block order left by the
+	// allocation and the allocations planned before it
+	free []FreeExtent
+	// extents are the allocated extents in file order
+	extents []FreeExtent
+	count   uint64
+}
+
+// planBlocks finds count blocks in at most maxExtents extents, taken from the
+// start of the largest free extents of the AG agNumber, or of the next AG
+// holding them. The blocks of the planned allocations are not free anymore,
+// they must be committed before this one.
+func (xfs *FileSystem) planBlocks(agNumber, count uint64, maxExtents int, planned ...*blockAlloc) (*blockAlloc, error) {
+	if count == 0 {
+		return &blockAlloc{xfs: xfs, agNumber: agNumber}, nil
+	}
+	agCount := uint64(len(xfs.AGs))
+	for i := uint64(0); i < agCount; i++ {
+		ag := (agNumber + i) % agCount
+		freeblks := uint64(xfs.AGs[ag].Agf.Freeblks)
+		var free []FreeExtent
+		for _, p := range planned {
+			if p.agNumber == ag && p.count > 0 {
+				freeblks -= p.count
+				free = p.free
+			}
+		}
+		if freeblks < count {
+			continue
+		}
+		if agf := xfs.AGs[ag].Agf; agf.Levels[0] != 1 || agf.Levels[1] != 1 {
+			return nil, newUnsupportedFeatureError(fmt.Sprintf("writing free space btrees of more than one level in AG %d", ag))
+		}
+		if free == nil {
+			var err error
+			if free, err = xfs.agFreeExtents(ag); err != nil {
+				return nil, xerrors.Errorf("failed to read the free space btree of AG %d: %w", ag, err)
+			}
+		}
+		free = append([]FreeExtent(nil), free...)
+		largest := make([]int, len(free))
+		for j := range largest {
+			largest[j] = j
+		}
+		sort.SliceStable(largest, func(i, j int) bool { return free[largest[i]].Count > free[largest[j]].Count })
+
+		var extents []FreeExtent
+		remaining := count
+		for _, j := range largest {
+			if remaining == 0 || len(extents) == maxExtents {
+				break
+			}
+			n := uint64(free[j].Count)
+			if n > XFS_MAX_BMBT_EXTLEN {
+				n = XFS_MAX_BMBT_EXTLEN
+			}
+			if n > remaining {
+				n = remaining
+			}
+			extents = append(extents, FreeExtent{AG: free[j].AG, Start: free[j].Start, Count: uint32(n)})
+			free[j].Start += uint32(n)
+			free[j].Count -= uint32(n)
+			remaining -= n
+		}
+		if remaining > 0 {
+			continue
+		}
+		left := free[:0]
+		for _, e := range free {
+			if e.Count > 0 {
+				left = append(left, e)
+			}
+		}
+		return &blockAlloc{xfs: xfs, agNumber: ag, free: left, extents: extents, count: count}, nil
+	}
+	return nil, xerrors.Errorf("no %d free blocks in %d extents: %w", count, maxExtents, ErrNoSpace)
+}
+
+// commit removes the allocated extents from the free space btrees
+func (a *blockAlloc) commit() error {
+	if a.count == 0 {
+		return nil
+	}
+	return a.xfs.writeFreeSpace(a.agNumber, a.free, -int64(a.count))
+}
+
+// blockFree is a release of extents planned in the free space btrees of
+// their AGs, commit writes it
+type blockFree struct {
+	xfs *FileSystem
+	// free are the free extents of the AGs with released extents after the
+	// release, in block order, and count the number of released blocks
+	free  map[uint64][]FreeExtent
+	count map[uint64]uint64
+}
+
+// planFree merges the extents into the free extents of their AGs, an extent
+// overlapping free space is corrupted
+func (xfs *FileSystem) planFree(extents []BmbtIrec) (*blockFree, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	f := &blockFree{xfs: xfs, free: map[uint64][]FreeExtent{}, count: map[uint64]uint64{}}
+	released := map[uint64][]FreeExtent{}
+	for _, e := range extents {
+		if err := xfs.validateExtent(e.StartBlock, e.BlockCount); err != nil {
+			return nil, xfs.wrapBlockError(e.StartBlock, err)
+		}
+		ag := sb.BlockToAgNumber(e.StartBlock)
+		released[ag] = append(released[ag], FreeExtent{
+			AG:    uint32(ag),
+			Start: uint32(sb.BlockToAgBlockNumber(e.StartBlock)),
+			Count: uint32(e.BlockCount),
+		})
+		f.count[ag] += e.BlockCount
+	}
+
+	for ag, extents := range released {
+		bno := xfs.bnoBtree(ag)
+		if agf := xfs.AGs[ag].Agf; agf.Levels[0] != 1 || agf.Levels[1] != 1 {
+			return nil, newUnsupportedFeatureError(fmt.Sprintf("writing free space btrees of more than one level in AG %d", ag))
+		}
+		free, err := xfs.agFreeExtents(ag)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the free space btree of AG %d: %w", ag, err)
+		}
+		free = append(free, extents...)
+		sort.Slice(free, func(i, j int) bool { return free[i].Start < free[j].Start })
+		merged := free[:1]
+		for _, e := range free[1:] {
+			last := &merged[len(merged)-1]
+			switch end := last.Start + last.Count; {
+			case e.Start < end:
+				return nil, newCorruptedError(bno.name, -1, "extent %d+%d of AG %d is already free", e.Start, e.Count, ag)
+			case e.Start == end:
+				last.Count += e.Count
+			default:
+				merged = append(merged, e)
+			}
+		}
+		if len(merged) > bno.maxRootRecs(sb.BlockSize) {
+			return nil, newUnsupportedFeatureError(fmt.Sprintf("splitting the root of the %s of AG %d", bno.name, ag))
+		}
+		f.free[ag] = merged
+	}
+	return f, nil
+}
+
+// commit adds the released extents to the free space btrees
+func (f *blockFree) commit() error {
+	ags := make([]uint64, 0, len(f.free))
+	for ag := range f.free {
+		ags = append(ags, ag)
+	}
+	sort.Slice(ags, func(i, j int) bool { return ags[i] < ags[j] })
+	for _, ag := range ags {
+		if err := f.xfs.writeFreeSpace(ag, f.free[ag], int64(f.count[ag])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFreeSpace writes the free extents of the AG agNumber in block order to
+// both free space btrees, and adds delta to the free block counters
+func (xfs *FileSystem) writeFreeSpace(agNumber uint64, free []FreeExtent, delta int64) error {
+	byCount := append([]FreeExtent(nil), free...)
+	sort.Slice(byCount, func(i, j int) bool {
+		if byCount[i].Count != byCount[j].Count {
+			return byCount[i].Count < byCount[j].Count
+		}
+		return byCount[i].Start < byCount[j].Start
+	})
+	for _, t := range []struct {
+		bt      shortBtree
+		extents []FreeExtent
+	}{
+		{xfs.bnoBtree(agNumber), free},
+		{xfs.cntBtree(agNumber), byCount},
+	} {
+		recs := make([]byte, 8*len(t.extents))
+		for i, e := range t.extents {
+			binary.BigEndian.PutUint32(recs[8*i:], e.Start)
+			binary.BigEndian.PutUint32(recs[8*i+4:], e.Count)
+		}
+		if err := xfs.writeShortBtreeRoot(t.bt, recs); err != nil {
+			return xerrors.Errorf("failed to write the %s of AG %d: %w", t.bt.name, agNumber, err)
+		}
+	}
+
+	agf := &xfs.AGs[agNumber].Agf
+	agf.Freeblks = uint32(int64(agf.Freeblks) + delta)
+	agf.Longest = 0
+	if len(byCount) > 0 {
+		agf.Longest = byCount[len(byCount)-1].Count
+	}
+	if err := xfs.writeAGF(agNumber); err != nil {
+		return err
+	}
+	xfs.PrimaryAG.SuperBlock.Fdblocks = uint64(int64(xfs.PrimaryAG.SuperBlock.Fdblocks) + delta)
+	return xfs.writeSuperBlock()
+}
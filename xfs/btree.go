@@ -0,0 +1,196 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// shortBtree describes a per-AG btree with short (32 bit) block pointers, as
+// the free space and inode btrees.
+type shortBtree struct {
+	name        string
+	magic       uint32
+	crcMagic    uint32
+	recSize     int
+	keySize     int
+	agNumber    uint64
+	root, level uint32
+}
+
+// walkShortBtree calls fn with the records of the btree bt in key order
+func (xfs *FileSystem) walkShortBtree(bt shortBtree, fn func(rec []byte) error) error {
+	if bt.level == 0 || bt.level > XFS_BTREE_MAXLEVELS {
+		return newCorruptedError(bt.name, -1, "AG %d: invalid level %d", bt.agNumber, bt.level)
+	}
+	return xfs.walkShortBtreeBlock(bt, bt.root, bt.level-1, btreeVisited{}, fn)
+}
+
+func (xfs *FileSystem) walkShortBtreeBlock(bt shortBtree, agBlock uint32, level uint32, visited btreeVisited, fn func(rec []byte) error) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	block := bt.agNumber<<sb.Agblklog | uint64(agBlock)
+	if err := visited.visit(block); err != nil {
+		return err
+	}
+	if err := xfs.validateExtent(block, 1); err != nil {
+		return xfs.wrapBlockError(block, err)
+	}
+	b, err := xfs.readBlock(sb.BlockToPhysicalOffset(block), 1)
+	if err != nil {
+		return xfs.wrapBlockError(block, err)
+	}
+
+	hdrSize, magic := 16, bt.magic
+	if sb.IsV5() {
+		hdrSize, magic = 56, bt.crcMagic
+	}
+	if m := binary.BigEndian.Uint32(b); m != magic {
+		return xfs.wrapBlockError(block, newCorruptedError(bt.name, -1, "magic byte error: %08x", m))
+	}
+	blockLevel, numrecs := binary.BigEndian.Uint16(b[4:]), int(binary.BigEndian.Uint16(b[6:]))
+	if uint32(blockLevel) != level {
+		return xfs.wrapBlockError(block, newCorruptedError(bt.name, -1, "unexpected level %d, expected %d", blockLevel, level))
+	}
+
+	if level == 0 {
+		if hdrSize+numrecs*bt.recSize > len(b) {
+			return xfs.wrapBlockError(block, newCorruptedError(bt.name, -1, "numrecs %d exceeds block", numrecs))
+		}
+		for i := 0; i < numrecs; i++ {
+			off := hdrSize + i*bt.recSize
+			if err := fn(b[off : off+bt.recSize]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the pointers follow the keys of a full block
+	maxrecs := (len(b) - hdrSize) / (bt.keySize + 4)
+	if numrecs > maxrecs {
+		return xfs.wrapBlockError(block, newCorruptedError(bt.name, -1, "numrecs %d exceeds maxrecs %d", numrecs, maxrecs))
+	}
+	ptrs := b[hdrSize+maxrecs*bt.keySize:]
+	for i := 0; i < numrecs; i++ {
+		if err := xfs.walkShortBtreeBlock(bt, binary.BigEndian.Uint32(ptrs[i*4:]), level-1, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shortBtreeCRCHdrSize is the size of the header of v5 short btree blocks
+const shortBtreeCRCHdrSize = 56
+
+// writeShortBtreeRoot replaces the records of the btree bt with recs, the
+// records of bt.recSize bytes each in key order. Only btrees whose root is a
+// leaf are written, a root that would have to split is unsupported.
+func (xfs *FileSystem) writeShortBtreeRoot(bt shortBtree, recs []byte) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	if bt.level != 1 {
+		return newUnsupportedFeatureError(fmt.Sprintf("writing %s of %d levels", bt.name, bt.level))
+	}
+	block := bt.agNumber<<sb.Agblklog | uint64(bt.root)
+	b, err := xfs.readBlock(sb.BlockToPhysicalOffset(block), 1)
+	if err != nil {
+		return xfs.wrapBlockError(block, err)
+	}
+	if m := binary.BigEndian.Uint32(b); m != bt.crcMagic {
+		return xfs.wrapBlockError(block, newCorruptedError(bt.name, -1, "magic byte error: %08x", m))
+	}
+	if len(recs) > bt.maxRootRecs(sb.BlockSize)*bt.recSize {
+		return newUnsupportedFeatureError(fmt.Sprintf("splitting the root of the %s", bt.name))
+	}
+	binary.BigEndian.PutUint16(b[6:], uint16(len(recs)/bt.recSize))
+	copy(b[shortBtreeCRCHdrSize:], recs)
+	for i := shortBtreeCRCHdrSize + len(recs); i < len(b); i++ {
+		b[i] = 0
+	}
+	setCRC(b, XFS_BTREE_SBLOCK_CRC_OFF)
+	if err := xfs.writeBlock(sb.BlockToPhysicalOffset(block), b); err != nil {
+		return xfs.wrapBlockError(block, err)
+	}
+	return nil
+}
+
+// maxRootRecs returns the number of records a v5 leaf root block holds
+func (bt shortBtree) maxRootRecs(blockSize uint32) int {
+	return (int(blockSize) - shortBtreeCRCHdrSize) / bt.recSize
+}
+
+// bnoBtree returns the free space btree of the AG agNumber keyed by block
+func (xfs *FileSystem) bnoBtree(agNumber uint64) shortBtree {
+	agf := xfs.AGs[agNumber].Agf
+	return shortBtree{
+		name:     "free space btree",
+		magic:    XFS_ABTB_MAGIC,
+		crcMagic: XFS_ABTB_CRC_MAGIC,
+		recSize:  8,
+		keySize:  8,
+		agNumber: agNumber,
+		root:     agf.Roots[0],
+		level:    agf.Levels[0],
+	}
+}
+
+// cntBtree returns the free space btree of the AG agNumber keyed by size
+func (xfs *FileSystem) cntBtree(agNumber uint64) shortBtree {
+	agf := xfs.AGs[agNumber].Agf
+	return shortBtree{
+		name:     "free space by size btree",
+		magic:    XFS_ABTC_MAGIC,
+		crcMagic: XFS_ABTC_CRC_MAGIC,
+		recSize:  8,
+		keySize:  8,
+		agNumber: agNumber,
+		root:     agf.Roots[1],
+		level:    agf.Levels[1],
+	}
+}
+
+// inoBtree returns the inode btree of the AG agNumber
+func (xfs *FileSystem) inoBtree(agNumber uint64) shortBtree {
+	agi := xfs.AGs[agNumber].Agi
+	return shortBtree{
+		name:     "inode btree",
+		magic:    XFS_IBT_MAGIC,
+		crcMagic: XFS_IBT_CRC_MAGIC,
+		recSize:  16,
+		keySize:  4,
+		agNumber: agNumber,
+		root:     agi.Root,
+		level:    agi.Level,
+	}
+}
+
+// finoBtree returns the free inode btree of the AG agNumber
+func (xfs *FileSystem) finoBtree(agNumber uint64) shortBtree {
+	agi := xfs.AGs[agNumber].Agi
+	return shortBtree{
+		name:     "free inode btree",
+		magic:    XFS_FIBT_MAGIC,
+		crcMagic: XFS_FIBT_CRC_MAGIC,
+		recSize:  16,
+		keySize:  4,
+		agNumber: agNumber,
+		root:     agi.FreeRoot,
+		level:    agi.FreeLevel,
+	}
+}
+
+// inodeChunks returns the inode btree records of the AG agNumber, a record
+// covers a chunk of 64 inodes from Startino and Free has a bit set for every
+// free inode.
+func (xfs *FileSystem) inodeChunks(agNumber uint64) ([]InobtRec, error) {
+	var recs []InobtRec
+	err := xfs.walkShortBtree(xfs.inoBtree(agNumber), func(rec []byte) error {
+		// sparse inode records keep holemask, count and freecount in the
+		// place of the 32 bit freecount, holes are marked free
+		recs = append(recs, InobtRec{
+			Startino:  binary.BigEndian.Uint32(rec),
+			Freecount: binary.BigEndian.Uint32(rec[4:]),
+			Free:      binary.BigEndian.Uint64(rec[8:]),
+		})
+		return nil
+	})
+	return recs, err
+}
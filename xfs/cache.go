@@ -1,6 +1,9 @@
 package xfs
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 var (
 	_ Cache[string, Inode] = &mockCache[string, Inode]{}
@@ -27,3 +30,64 @@ func (c *mockCache[K, V]) Get(_ K) (v V, evicted bool) {
 func inodeCacheKey(n uint64) string {
 	return fmt.Sprintf("xfs:%d", n)
 }
+
+// intCache is a bounded cache keyed by inode numbers.
+// A nil *intCache is a disabled cache.
+type intCache[V any] struct {
+	mu    sync.Mutex
+	size  int
+	items map[uint64]V
+}
+
+// newIntCache returns a cache holding at most size items, the map is pre-sized
+// to the smaller of size and hint so that it is not rehashed while filling.
+func newIntCache[V any](size int, hint uint64) *intCache[V] {
+	if size <= 0 {
+		return nil
+	}
+	capacity := size
+	if hint < uint64(size) {
+		capacity = int(hint)
+	}
+	return &intCache[V]{
+		size:  size,
+		items: make(map[uint64]V, capacity),
+	}
+}
+
+func (c *intCache[V]) Get(key uint64) (v V, ok bool) {
+	if c == nil {
+		return v, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok = c.items[key]
+	return v, ok
+}
+
+func (c *intCache[V]) Add(key uint64, value V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok && len(c.items) >= c.size {
+		// evict an arbitrary item, map iteration order is random
+		for k := range c.items {
+			delete(c.items, k)
+			break
+		}
+	}
+	c.items[key] = value
+}
+
+// Remove drops key, write operations remove the inodes and directories they
+// change
+func (c *intCache[V]) Remove(key uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
@@ -0,0 +1,66 @@
+package xfs
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestIntCache(t *testing.T) {
+	var disabled *intCache[int]
+	disabled.Add(1, 1)
+	if _, ok := disabled.Get(1); ok {
+		t.Fatal("disabled cache must not hold items")
+	}
+	if c := newIntCache[int](0, 100); c != nil {
+		t.Fatal("zero size cache must be disabled")
+	}
+
+	c := newIntCache[int](2, 100)
+	for i := uint64(0); i < 10; i++ {
+		c.Add(i, int(i))
+	}
+	if len(c.items) != 2 {
+		t.Fatalf("expected %d, actual %d", 2, len(c.items))
+	}
+	v, ok := c.Get(9)
+	if !ok || v != 9 {
+		t.Fatalf("expected last added item, actual %d, %t", v, ok)
+	}
+}
+
+func TestFileSystemIntCache(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil,
+		WithInodeCacheSize(64),
+		WithDirCacheSize(8),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		dirEntries, err := fileSystem.ReadDir("fmt_extents_block_directories")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dirEntries) != 8 {
+			t.Fatalf("expected %d, actual %d", 8, len(dirEntries))
+		}
+	}
+
+	if _, ok := fileSystem.inodeCache.Get(fileSystem.PrimaryAG.SuperBlock.Rootino); !ok {
+		t.Error("root inode is not cached")
+	}
+	if len(fileSystem.dirCache.items) == 0 {
+		t.Error("directory entries are not cached")
+	}
+}
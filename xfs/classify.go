@@ -0,0 +1,156 @@
+package xfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultHeadSize is the bytes Classify reads by default, enough for the
+// ustar magic at offset 257
+const defaultHeadSize = 512
+
+// ContentType is the type of the content of a file detected from its first
+// bytes, ContentUnknown when no magic matches
+type ContentType string
+
+const (
+	ContentUnknown ContentType = ""
+
+	// executables
+	ContentELF   ContentType = "elf"
+	ContentPE    ContentType = "pe"
+	ContentMachO ContentType = "mach-o"
+	// ContentScript starts with "#!", the interpreter follows
+	ContentScript ContentType = "script"
+
+	// archives and compressed files
+	ContentGzip  ContentType = "gzip"
+	ContentBzip2 ContentType = "bzip2"
+	ContentXz    ContentType = "xz"
+	ContentZstd  ContentType = "zstd"
+	ContentZip   ContentType = "zip"
+	ContentTar   ContentType = "tar"
+	Content7z    ContentType = "7z"
+	ContentAr    ContentType = "ar"
+	ContentRPM   ContentType = "rpm"
+
+	// PEM encoded keys and certificates
+	ContentCertificate ContentType = "certificate"
+	ContentPrivateKey  ContentType = "private-key"
+)
+
+// IsExecutable reports whether t is a binary executable or a script
+func (t ContentType) IsExecutable() bool {
+	switch t {
+	case ContentELF, ContentPE, ContentMachO, ContentScript:
+		return true
+	}
+	return false
+}
+
+// IsArchive reports whether t is an archive or a compressed file
+func (t ContentType) IsArchive() bool {
+	switch t {
+	case ContentGzip, ContentBzip2, ContentXz, ContentZstd, ContentZip, ContentTar, Content7z, ContentAr, ContentRPM:
+		return true
+	}
+	return false
+}
+
+// contentMagics are the magic bytes at the start of files
+var contentMagics = []struct {
+	magic []byte
+	typ   ContentType
+}{
+	{[]byte("\x7fELF"), ContentELF},
+	{[]byte("MZ"), ContentPE},
+	{[]byte("\xfe\xed\xfa\xce"), ContentMachO},
+	{[]byte("\xfe\xed\xfa\xcf"), ContentMachO},
+	{[]byte("\xce\xfa\xed\xfe"), ContentMachO},
+	{[]byte("\xcf\xfa\xed\xfe"), ContentMachO},
+	{[]byte("#!"), ContentScript},
+	{[]byte("\x1f\x8b"), ContentGzip},
+	{[]byte("BZh"), ContentBzip2},
+	{[]byte("\xfd7zXZ\x00"), ContentXz},
+	{[]byte("\x28\xb5\x2f\xfd"), ContentZstd},
+	{[]byte("PK\x03\x04"), ContentZip},
+	{[]byte("PK\x05\x06"), ContentZip},
+	{[]byte("7z\xbc\xaf\x27\x1c"), Content7z},
+	{[]byte("!<arch>\n"), ContentAr},
+	{[]byte("\xed\xab\xee\xdb"), ContentRPM},
+}
+
+// DetectContentType returns the type of the content starting with head
+func DetectContentType(head []byte) ContentType {
+	for _, m := range contentMagics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.typ
+		}
+	}
+	if len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")) {
+		return ContentTar
+	}
+	// PEM blocks may follow comments or other blocks
+	if i := bytes.Index(head, []byte("-----BEGIN ")); i >= 0 {
+		label := head[i+len("-----BEGIN "):]
+		if end := bytes.Index(label, []byte("-----")); end >= 0 {
+			label = label[:end]
+			switch {
+			case bytes.Equal(label, []byte("CERTIFICATE")), bytes.Equal(label, []byte("TRUSTED CERTIFICATE")):
+				return ContentCertificate
+			case bytes.HasSuffix(label, []byte("PRIVATE KEY")):
+				return ContentPrivateKey
+			}
+		}
+	}
+	return ContentUnknown
+}
+
+// ClassifyOptions select the files of Classify and the bytes read to detect
+// their type
+type ClassifyOptions struct {
+	WalkOptions
+	// HeadSize is the number of bytes read from the start of each regular
+	// file, 512 when 0
+	HeadSize int
+}
+
+// ClassifyFunc receives the files of Classify. r reads the whole content of
+// regular files, the head included, and is nil for other types, it is valid
+// until the function returns.
+type ClassifyFunc func(path string, info fs.FileInfo, typ ContentType, r io.Reader) error
+
+// Classify walks the tree at root selecting files with opts as Walk does,
+// reads the first opts.HeadSize bytes of each regular file and calls fn with
+// the type detected from them, so a scanner classifies and reads the files
+// in one pass without opening them twice. Returning fs.SkipDir for a
+// directory skips it, other errors stop the walk.
+func (xfs *FileSystem) Classify(root string, opts ClassifyOptions, fn ClassifyFunc) error {
+	const op = "classify"
+
+	if !validPath(root) {
+		return xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	headSize := opts.HeadSize
+	if headSize <= 0 {
+		headSize = defaultHeadSize
+	}
+	head := make([]byte, headSize)
+	err := xfs.stream(root, opts.WalkOptions, func(name string, info fs.FileInfo, r io.Reader) error {
+		if r == nil {
+			return fn(name, info, ContentUnknown, nil)
+		}
+		n, err := io.ReadFull(r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return xerrors.Errorf("failed to read %s: %w", name, err)
+		}
+		return fn(name, info, DetectContentType(head[:n]), io.MultiReader(bytes.NewReader(head[:n]), r))
+	})
+	if err != nil {
+		return xfs.wrapError(op, root, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package xfs_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// TestFileSystemConcurrentAccess runs Open, Read, ReadDir and Stat from many
+// goroutines on one FileSystem, run it with -race to check the FileSystem is
+// safe for concurrent use.
+func TestFileSystemConcurrentAccess(t *testing.T) {
+	const goroutines = 8
+
+	tests := []struct {
+		name string
+		opts []xfs.Option
+	}{
+		{name: "no cache"},
+		{name: "cache", opts: []xfs.Option{xfs.WithInodeCacheSize(64), xfs.WithDirCacheSize(8)}},
+		{name: "pinned", opts: []xfs.Option{xfs.WithPinnedPaths("etc", "fmt_node_directories")}},
+	}
+	for _, image := range []string{"testdata/image.xfs", "testdata/image40.xfs"} {
+		for _, tt := range tests {
+			t.Run(image+"/"+tt.name, func(t *testing.T) {
+				f, err := os.Open(image)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				info, err := f.Stat()
+				if err != nil {
+					t.Fatal(err)
+				}
+				fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil, tt.opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				expected, err := walk(fileSystem)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var wg sync.WaitGroup
+				errs := make(chan error, goroutines)
+				for i := 0; i < goroutines; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						actual, err := walk(fileSystem)
+						if err != nil {
+							errs <- err
+							return
+						}
+						for name, b := range expected {
+							if !bytes.Equal(actual[name], b) {
+								t.Errorf("%s: content differs from the sequential walk", name)
+							}
+						}
+						if len(actual) != len(expected) {
+							t.Errorf("expected %d files, actual %d", len(expected), len(actual))
+						}
+					}()
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+// walk reads the root directory and every directory and regular file in it,
+// and returns the contents of the files and the names of the directory
+// entries by path.
+func walk(fileSystem *xfs.FileSystem) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	readDir := func(name string) ([]fs.DirEntry, error) {
+		entries, err := fileSystem.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		var names []byte
+		for _, entry := range entries {
+			names = append(names, entry.Name()...)
+			names = append(names, '\n')
+		}
+		files[name] = names
+		return entries, nil
+	}
+
+	entries, err := readDir(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		info, err := fileSystem.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case info.IsDir():
+			if _, err := readDir(name); err != nil {
+				return nil, err
+			}
+		case info.Mode().IsRegular():
+			b, err := fileSystem.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = b
+		}
+	}
+	return files, nil
+}
@@ -2,9 +2,28 @@ package xfs
 
 const (
 	BMBT_EXNTFLAG_BITLEN = 1
+	XFS_EXT_NORM         = 0
+	XFS_EXT_UNWRITTEN    = 1
 	INODEV3_SIZE         = 176
+	INODEV2_SIZE         = 100
 	INODE_SIZE           = 96
 	LEAF_ENTRY_SIZE      = 8
+	XFS_SYMLINK_MAXLEN   = 1024
+	XFS_MAXNAMELEN       = 255
+	XFS_MAX_BMBT_EXTLEN  = 1<<21 - 1
+
+	XFS_MIN_BLOCKSIZE        = 512
+	XFS_MAX_BLOCKSIZE        = 65536
+	XFS_MAX_BLOCKSIZE_LOG    = 16
+	XFS_MIN_SECTORSIZE       = 512
+	XFS_MAX_SECTORSIZE       = 32768
+	XFS_DINODE_MIN_SIZE      = 256
+	XFS_DINODE_MAX_SIZE      = 2048
+	XFS_MIN_AG_BLOCKS        = 64
+	XFS_BTREE_MAXLEVELS      = 9
+	XFS_MAXINUMBER           = 1<<56 - 1
+	XFS_AGI_UNLINKED_BUCKETS = 64
+	NULLAGINO                = 0xffffffff
 
 	XFS_DIR2_DATA_FD_COUNT  = 3
 	XFS_DIR2_DATA_FREE_TAG  = 0xffff
@@ -46,6 +65,40 @@ const (
 	XFS_RTRMAP_CRC_MAGIC = 0x4d415052
 	XFS_REFC_CRC_MAGIC   = 0x52334643
 	XFS_MD_MAGIC         = 0x5846534d
+
+	XFS_AGF_VERSION = 1
+	XFS_AGI_VERSION = 1
+)
+
+const (
+	BBSIZE = 512
+
+	XLOG_HEADER_MAGIC_NUM  = 0xfeedbabe
+	XLOG_VERSION_2         = 2
+	XLOG_HEADER_CYCLE_SIZE = 32 * 1024
+	XLOG_MAX_RECORD_BSIZE  = 256 * 1024
+	XLOG_UNMOUNT_TRANS     = 0x20
+	XFS_LOG                = 0xaa
+)
+
+const (
+	XFS_SB_VERSION_NUMBITS = 0x000f
+	XFS_SB_VERSION_5       = 5
+	XFS_SB_VERSION_BORGBIT = 0x4000 // ASCII only case-insensitive names
+)
+
+const (
+	// sb_features_ro_compat
+	XFS_SB_FEAT_RO_COMPAT_FINOBT   = 1 << 0
+	XFS_SB_FEAT_RO_COMPAT_RMAPBT   = 1 << 1
+	XFS_SB_FEAT_RO_COMPAT_REFLINK  = 1 << 2
+	XFS_SB_FEAT_RO_COMPAT_INOBTCNT = 1 << 3
+
+	// sb_features_incompat
+	XFS_SB_FEAT_INCOMPAT_FTYPE     = 1 << 0
+	XFS_SB_FEAT_INCOMPAT_SPINODES  = 1 << 1
+	XFS_SB_FEAT_INCOMPAT_META_UUID = 1 << 2
+	XFS_SB_FEAT_INCOMPAT_BIGTIME   = 1 << 3
 )
 
 const (
@@ -59,6 +112,12 @@ const (
 	XFS_SB_VERSION2_FTYPE          = 0x00000200 /* inode type in dir */
 )
 
+const (
+	XFS_UQUOTA_ACCT = 0x0001 /* user quota accounting ON */
+	XFS_PQUOTA_ACCT = 0x0008 /* project quota accounting ON */
+	XFS_GQUOTA_ACCT = 0x0040 /* group quota accounting ON */
+)
+
 const (
 	XFS_DIR2_DATA_SPACE int64 = iota
 	XFS_DIR2_LEAF_SPACE
@@ -74,3 +133,93 @@ const (
 	XFS_DINODE_FMT_UUID
 	XFS_DINODE_FMT_RMAP
 )
+
+const (
+	// offsets of the crc fields in the v5 metadata, the crc is the little
+	// endian crc32c of the structure with the field zeroed
+	XFS_SB_CRC_OFF         = 224
+	XFS_AGF_CRC_OFF        = 216
+	XFS_AGI_CRC_OFF        = 312
+	XFS_AGFL_CRC_OFF       = 32
+	XFS_DINODE_CRC_OFF     = 100
+	XFS_DIR3_DATA_CRC_OFF  = 4
+	XFS_DA3_NODE_CRC_OFF   = 12
+	XFS_ATTR3_LEAF_CRC_OFF = 12
+
+	XFS_BTREE_SBLOCK_CRC_OFF = 52
+)
+
+const (
+	// xfs_attr_leaf_entry flags, entries without XFS_ATTR_ROOT and
+	// XFS_ATTR_SECURE are in the user namespace
+	XFS_ATTR_LOCAL      = 1 << 0
+	XFS_ATTR_ROOT       = 1 << 1
+	XFS_ATTR_SECURE     = 1 << 2
+	XFS_ATTR_PARENT     = 1 << 3
+	XFS_ATTR_INCOMPLETE = 1 << 7
+
+	XFS_DA_NODE_MAXDEPTH = 5
+)
+
+const (
+	// di_flags
+	XFS_DIFLAG_REALTIME     = 1 << 0
+	XFS_DIFLAG_PREALLOC     = 1 << 1
+	XFS_DIFLAG_NEWRTBM      = 1 << 2
+	XFS_DIFLAG_IMMUTABLE    = 1 << 3
+	XFS_DIFLAG_APPEND       = 1 << 4
+	XFS_DIFLAG_SYNC         = 1 << 5
+	XFS_DIFLAG_NOATIME      = 1 << 6
+	XFS_DIFLAG_NODUMP       = 1 << 7
+	XFS_DIFLAG_RTINHERIT    = 1 << 8
+	XFS_DIFLAG_PROJINHERIT  = 1 << 9
+	XFS_DIFLAG_NOSYMLINKS   = 1 << 10
+	XFS_DIFLAG_EXTSIZE      = 1 << 11
+	XFS_DIFLAG_EXTSZINHERIT = 1 << 12
+	XFS_DIFLAG_NODEFRAG     = 1 << 13
+	XFS_DIFLAG_FILESTREAM   = 1 << 14
+)
+
+const (
+	// di_flags2
+	XFS_DIFLAG2_DAX        = 1 << 0
+	XFS_DIFLAG2_REFLINK    = 1 << 1
+	XFS_DIFLAG2_COWEXTSIZE = 1 << 2
+	XFS_DIFLAG2_NREXT64    = 1 << 4
+
+	// inode timestamps are nanoseconds since XFS_BIGTIME_EPOCH_OFFSET
+	XFS_DIFLAG2_BIGTIME = 1 << 3
+
+	// seconds between the bigtime epoch and the unix epoch, -S32_MIN
+	XFS_BIGTIME_EPOCH_OFFSET = 1 << 31
+)
+
+const (
+	// file type stored in directory entries, see. xfs_da_format.h XFS_DIR3_FT_*
+	XFS_DIR3_FT_UNKNOWN = iota
+	XFS_DIR3_FT_REG_FILE
+	XFS_DIR3_FT_DIR
+	XFS_DIR3_FT_CHRDEV
+	XFS_DIR3_FT_BLKDEV
+	XFS_DIR3_FT_FIFO
+	XFS_DIR3_FT_SOCK
+	XFS_DIR3_FT_SYMLINK
+	XFS_DIR3_FT_WHT
+)
+
+const (
+	// file type bits of di_mode
+	S_IFMT   = 0xF000
+	S_IFSOCK = 0xC000
+	S_IFLNK  = 0xA000
+	S_IFREG  = 0x8000
+	S_IFBLK  = 0x6000
+	S_IFDIR  = 0x4000
+	S_IFCHR  = 0x2000
+	S_IFIFO  = 0x1000
+
+	// special bits of di_mode
+	S_ISUID = 0x800
+	S_ISGID = 0x400
+	S_ISVTX = 0x200
+)
@@ -0,0 +1,212 @@
+package xfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// DuplicateOptions select the files compared by Duplicates
+type DuplicateOptions struct {
+	WalkOptions
+	// MinSize skips the files smaller than MinSize bytes, empty files are
+	// always skipped
+	MinSize int64
+	// Content groups the files by the SHA-256 of their content, otherwise
+	// only the files sharing the same extents, such as reflink copies, are
+	// grouped
+	Content bool
+}
+
+// DuplicateFile is an inode of a DuplicateGroup
+type DuplicateFile struct {
+	Ino uint64
+	// Paths are the hard links of the inode under the root of the scan
+	Paths []string
+}
+
+// DuplicateGroup are files of the same content
+type DuplicateGroup struct {
+	Size int64
+	// SHA256 is the hash of the content, only set with DuplicateOptions.Content
+	SHA256 []byte
+	// Files are in the order of their first path
+	Files []DuplicateFile
+	// Shared is set when every file has the same extents, the copies
+	// already share their blocks
+	Shared bool
+	// Reclaimable is the number of bytes freed by sharing the blocks of the
+	// files, the blocks already shared by reflinks are counted once
+	Reclaimable int64
+}
+
+type duplicateCandidate struct {
+	info  FileInfo
+	paths []string
+	// layout is the key of the extents, empty for inline content
+	layout string
+}
+
+// Duplicates walks the tree at root and returns the groups of files with
+// the same content, by decreasing reclaimable bytes. Files of the same size
+// are compared by their extents and, with opts.Content, by the hash of their
+// content, read once per distinct extents.
+func (xfs *FileSystem) Duplicates(root string, opts DuplicateOptions) ([]DuplicateGroup, error) {
+	const op = "duplicates"
+
+	if !validPath(root) {
+		return nil, xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	// the candidates by inode, then by size
+	inodes := map[uint64]*duplicateCandidate{}
+	var order []*duplicateCandidate
+	err := xfs.Walk(root, opts.WalkOptions, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		i, err := d.Info()
+		if err != nil {
+			return err
+		}
+		info := toFileInfo(i)
+		if info.Size() == 0 || info.Size() < opts.MinSize {
+			return nil
+		}
+		if c, ok := inodes[info.Ino()]; ok {
+			c.paths = append(c.paths, name)
+			return nil
+		}
+		c := &duplicateCandidate{info: info, paths: []string{name}, layout: extentLayout(info.Extents())}
+		inodes[info.Ino()] = c
+		order = append(order, c)
+		return nil
+	})
+	if err != nil {
+		return nil, xfs.wrapError(op, root, err)
+	}
+	bySize := map[int64][]*duplicateCandidate{}
+	for _, c := range order {
+		bySize[c.info.Size()] = append(bySize[c.info.Size()], c)
+	}
+
+	var groups []DuplicateGroup
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		keys := map[string][]*duplicateCandidate{}
+		var hashes map[string][]byte
+		if opts.Content {
+			hashes = map[string][]byte{}
+		}
+		for _, c := range candidates {
+			key := c.layout
+			if opts.Content {
+				sum, ok := hashes[c.layout]
+				if !ok || c.layout == "" {
+					sum, err = xfs.hashFile(c.info)
+					if err != nil {
+						return nil, xfs.wrapError(op, c.paths[0], err)
+					}
+					hashes[c.layout] = sum
+				}
+				key = string(sum)
+			} else if key == "" {
+				// the content is in the inode, there are no blocks to share
+				continue
+			}
+			keys[key] = append(keys[key], c)
+		}
+		for key, members := range keys {
+			if len(members) < 2 {
+				continue
+			}
+			group := xfs.newDuplicateGroup(size, members)
+			if opts.Content {
+				group.SHA256 = []byte(key)
+			}
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reclaimable != groups[j].Reclaimable {
+			return groups[i].Reclaimable > groups[j].Reclaimable
+		}
+		return groups[i].Files[0].Paths[0] < groups[j].Files[0].Paths[0]
+	})
+	return groups, nil
+}
+
+func (xfs *FileSystem) newDuplicateGroup(size int64, members []*duplicateCandidate) DuplicateGroup {
+	sort.Slice(members, func(i, j int) bool { return members[i].paths[0] < members[j].paths[0] })
+	group := DuplicateGroup{Size: size, Shared: true}
+	// the blocks of the group counted once, less the blocks of one copy
+	var blocks []BmbtIrec
+	var largest uint64
+	for _, c := range members {
+		group.Files = append(group.Files, DuplicateFile{Ino: c.info.Ino(), Paths: c.paths})
+		if c.layout != members[0].layout || c.layout == "" {
+			group.Shared = false
+		}
+		var n uint64
+		for _, e := range c.info.Extents() {
+			n += e.BlockCount
+		}
+		if n > largest {
+			largest = n
+		}
+		blocks = append(blocks, c.info.Extents()...)
+	}
+	group.Reclaimable = int64(countBlocks(blocks)-largest) * int64(xfs.PrimaryAG.SuperBlock.BlockSize)
+	return group
+}
+
+// hashFile returns the SHA-256 of the content of the regular file info
+func (xfs *FileSystem) hashFile(info FileInfo) ([]byte, error) {
+	f, err := xfs.newFile(dirEntry{info})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, xerrors.Errorf("failed to read: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// extentLayout returns a key of the extents, equal for files sharing all
+// their blocks at the same offsets
+func extentLayout(extents []BmbtIrec) string {
+	var b strings.Builder
+	for _, e := range extents {
+		fmt.Fprintf(&b, "%d:%d:%d:%d,", e.StartOff, e.StartBlock, e.BlockCount, e.State)
+	}
+	return b.String()
+}
+
+// countBlocks returns the number of distinct blocks of the extents
+func countBlocks(extents []BmbtIrec) uint64 {
+	extents = append([]BmbtIrec(nil), extents...)
+	sort.Slice(extents, func(i, j int) bool { return extents[i].StartBlock < extents[j].StartBlock })
+	var n, end uint64
+	for _, e := range extents {
+		start := e.StartBlock
+		if start < end {
+			start = end
+		}
+		if e.StartBlock+e.BlockCount > start {
+			n += e.StartBlock + e.BlockCount - start
+			end = e.StartBlock + e.BlockCount
+		}
+	}
+	return n
+}
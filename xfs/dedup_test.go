@@ -0,0 +1,96 @@
+package xfs
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileSystemDuplicates(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := fileSystem.Duplicates("parent", DuplicateOptions{Content: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("expected 1 group, actual %d", len(groups))
+	}
+	group := groups[0]
+	var paths []string
+	for _, f := range group.Files {
+		paths = append(paths, f.Paths...)
+	}
+	if want := []string{
+		"parent/child/child/child/child/child/executable",
+		"parent/child/child/child/child/executable",
+		"parent/child/child/child/child/nonexecutable",
+	}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected %v, actual %v", want, paths)
+	}
+	if group.Size != 1024 || group.Shared || group.Reclaimable != 2*4096 || len(group.SHA256) != 32 {
+		t.Errorf("unexpected group %+v", group)
+	}
+
+	groups, err = fileSystem.Duplicates(".", DuplicateOptions{Content: true, MinSize: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Size != 4096 || groups[0].Reclaimable != int64(len(groups[0].Files)-1)*4096 {
+		t.Errorf("unexpected groups of files from 2048 bytes %+v", groups)
+	}
+
+	// without reflinks no file shares its extents
+	groups, err = fileSystem.Duplicates(".", DuplicateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("expected no group by extents, actual %d", len(groups))
+	}
+}
+
+func TestCountBlocks(t *testing.T) {
+	tests := []struct {
+		name     string
+		extents  []BmbtIrec
+		expected uint64
+	}{
+		{name: "none", expected: 0},
+		{
+			name:     "disjoint",
+			extents:  []BmbtIrec{{StartBlock: 10, BlockCount: 2}, {StartBlock: 1, BlockCount: 3}},
+			expected: 5,
+		},
+		{
+			name:     "reflink copy",
+			extents:  []BmbtIrec{{StartBlock: 10, BlockCount: 4}, {StartBlock: 10, BlockCount: 4}},
+			expected: 4,
+		},
+		{
+			name:     "partially shared",
+			extents:  []BmbtIrec{{StartBlock: 10, BlockCount: 4}, {StartBlock: 12, BlockCount: 4}, {StartBlock: 11, BlockCount: 1}},
+			expected: 6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := countBlocks(tt.extents); actual != tt.expected {
+				t.Errorf("expected %d, actual %d", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package xfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// ChangeKind is the kind of a Change
+type ChangeKind int
+
+const (
+	// ChangeAdded is a path missing in the old file system
+	ChangeAdded ChangeKind = iota + 1
+	// ChangeRemoved is a path missing in the new file system
+	ChangeRemoved
+	// ChangeReplaced is a path naming another inode, or an inode freed and
+	// allocated again as its generation tells, or another file type
+	ChangeReplaced
+	// ChangeModified is a regular file whose content was written
+	ChangeModified
+	// ChangeMetadata is a file whose attributes, such as the mode, owners,
+	// extended attributes or the extent map, changed but not its content
+	ChangeMetadata
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeReplaced:
+		return "replaced"
+	case ChangeModified:
+		return "modified"
+	case ChangeMetadata:
+		return "metadata"
+	}
+	return "unknown"
+}
+
+// ByteRange is a range of bytes of a file
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// Change is a file that differs between two file systems
+type Change struct {
+	Path string
+	Kind ChangeKind
+	// Old is the file in the old file system, the zero FileInfo for
+	// ChangeAdded
+	Old FileInfo
+	// New is the file in the new file system, the zero FileInfo for
+	// ChangeRemoved
+	New FileInfo
+	// Ranges are the bytes of a regular file to copy from the new file
+	// system: the whole file when it is added or replaced, the bytes that
+	// differ from the old file when it is modified. A copy of the old file
+	// is brought up to date by writing them and truncating it to the size
+	// of the new file.
+	Ranges []ByteRange
+}
+
+// Diff compares the tree at root with the tree at root of old, an earlier
+// state of the same volume, and returns the changes in the order of their
+// paths. Inodes of the same number and generation with the same ctime are
+// not compared any further, as every write and attribute change updates the
+// ctime. The ranges of a modified file are the blocks mapped differently in
+// both extent maps, and the blocks mapped at the same place whose content
+// differs, as XFS overwrites blocks in place.
+func (xfs *FileSystem) Diff(old *FileSystem, root string, opts WalkOptions) ([]Change, error) {
+	const op = "diff"
+
+	if !validPath(root) {
+		return nil, xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	oldFiles, err := old.walkInfo(root, opts)
+	if err != nil {
+		return nil, xfs.wrapError(op, root, xerrors.Errorf("failed to walk the old file system: %w", err))
+	}
+	newFiles, err := xfs.walkInfo(root, opts)
+	if err != nil {
+		return nil, xfs.wrapError(op, root, err)
+	}
+
+	var changes []Change
+	for name, n := range newFiles {
+		o, ok := oldFiles[name]
+		if !ok {
+			changes = append(changes, Change{Path: name, Kind: ChangeAdded, New: n, Ranges: wholeFile(n)})
+			continue
+		}
+		change, err := xfs.compare(old, name, o, n)
+		if err != nil {
+			return nil, xfs.wrapError(op, name, err)
+		}
+		if change.Kind != 0 {
+			changes = append(changes, change)
+		}
+	}
+	for name, o := range oldFiles {
+		if _, ok := newFiles[name]; !ok {
+			changes = append(changes, Change{Path: name, Kind: ChangeRemoved, Old: o})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// walkInfo returns the FileInfo of the files under root by path
+func (xfs *FileSystem) walkInfo(root string, opts WalkOptions) (map[string]FileInfo, error) {
+	files := map[string]FileInfo{}
+	err := xfs.Walk(root, opts, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		i, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[name] = toFileInfo(i)
+		return nil
+	})
+	return files, err
+}
+
+// compare returns the change of the file name from o in old to n, a zero
+// Change when the file did not change
+func (xfs *FileSystem) compare(old *FileSystem, name string, o, n FileInfo) (Change, error) {
+	change := Change{Path: name, Old: o, New: n}
+	oc, nc := o.inode.inodeCore, n.inode.inodeCore
+	if o.Ino() != n.Ino() || oc.Gen != nc.Gen || o.Mode().Type() != n.Mode().Type() {
+		change.Kind = ChangeReplaced
+		change.Ranges = wholeFile(n)
+		return change, nil
+	}
+	if oc.Ctime == nc.Ctime {
+		return Change{}, nil
+	}
+	change.Kind = ChangeMetadata
+	if !n.Mode().IsRegular() || (oc.Mtime == nc.Mtime && oc.Size == nc.Size) {
+		return change, nil
+	}
+
+	ranges, err := xfs.changedRanges(old, o, n)
+	if err != nil {
+		return Change{}, err
+	}
+	change.Kind = ChangeModified
+	change.Ranges = ranges
+	return change, nil
+}
+
+// wholeFile returns the range of the content of a regular file
+func wholeFile(info FileInfo) []ByteRange {
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return nil
+	}
+	return []ByteRange{{Offset: 0, Length: info.Size()}}
+}
+
+// changedRanges returns the bytes of n differing from o, the same inode in
+// the file system old
+func (xfs *FileSystem) changedRanges(old *FileSystem, o, n FileInfo) ([]ByteRange, error) {
+	if o.inode.inodeCore.Format == XFS_DINODE_FMT_LOCAL || n.inode.inodeCore.Format == XFS_DINODE_FMT_LOCAL {
+		// the content is in the inode, it is compared whole
+		return xfs.compareContent(old, o, n)
+	}
+
+	sb := xfs.PrimaryAG.SuperBlock
+	blockSize := int64(sb.BlockSize)
+	oldBlocks := (o.Size() + blockSize - 1) / blockSize
+	newBlocks := (n.Size() + blockSize - 1) / blockSize
+	oldMap, newMap := blockMap(sb, o.Extents()), blockMap(sb, n.Extents())
+
+	var ranges []ByteRange
+	changed := func(start, end int64) {
+		offset, length := start*blockSize, (end-start)*blockSize
+		if offset+length > n.Size() {
+			length = n.Size() - offset
+		}
+		if k := len(ranges) - 1; k >= 0 && ranges[k].Offset+ranges[k].Length == offset {
+			ranges[k].Length += length
+			return
+		}
+		ranges = append(ranges, ByteRange{Offset: offset, Length: length})
+	}
+	for _, s := range segments(oldMap, newMap, oldBlocks, newBlocks) {
+		switch {
+		case s.start >= oldBlocks:
+			// past the old end of file
+			changed(s.start, s.end)
+		case s.old == nil && s.new == nil:
+			// a hole of both files
+		case s.old == nil || s.new == nil || s.old.block(s.start) != s.new.block(s.start):
+			changed(s.start, s.end)
+		default:
+			// the blocks were not remapped, they may be overwritten in place
+			if err := xfs.compareBlocks(old, s.new.block(s.start), s.start, s.end, changed); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ranges, nil
+}
+
+// compareContent compares the whole content of o and n
+func (xfs *FileSystem) compareContent(old *FileSystem, o, n FileInfo) ([]ByteRange, error) {
+	read := func(fileSystem *FileSystem, info FileInfo) ([]byte, error) {
+		f, err := fileSystem.newFile(dirEntry{info})
+		if err != nil {
+			return nil, xerrors.Errorf("failed to open: %w", err)
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	ob, err := read(old, o)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := read(xfs, n)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(ob, nb) {
+		// the file was truncated, or only touched
+		return nil, nil
+	}
+	return wholeFile(n), nil
+}
+
+// compareBlocks compares the file blocks [start, end) of both file systems,
+// mapped at the physical block physical of both, and calls changed with the
+// ranges that differ
+func (xfs *FileSystem) compareBlocks(old *FileSystem, physical, start, end int64, changed func(start, end int64)) error {
+	// the blocks are read in chunks of up to 1 MiB
+	chunk := int64(1<<20) / int64(xfs.PrimaryAG.SuperBlock.BlockSize)
+	blockSize := int(xfs.PrimaryAG.SuperBlock.BlockSize)
+	for start < end {
+		count := end - start
+		if count > chunk {
+			count = chunk
+		}
+		ob, err := old.readBlock(physical, uint32(count))
+		if err != nil {
+			return xerrors.Errorf("failed to read the old blocks: %w", err)
+		}
+		nb, err := xfs.readBlock(physical, uint32(count))
+		if err != nil {
+			return err
+		}
+		for i := int64(0); i < count; i++ {
+			b := int(i) * blockSize
+			if !bytes.Equal(ob[b:b+blockSize], nb[b:b+blockSize]) {
+				changed(start+i, start+i+1)
+			}
+		}
+		start += count
+		physical += count
+	}
+	return nil
+}
+
+// mappedExtent is an extent in file and physical block numbers
+type mappedExtent struct {
+	start, end int64
+	physical   int64
+}
+
+// block returns the physical block of the file block n of the extent
+func (e *mappedExtent) block(n int64) int64 {
+	return e.physical + n - e.start
+}
+
+// blockMap returns the written extents sorted by file block, unwritten
+// extents read as zeros like holes
+func blockMap(sb SuperBlock, extents []BmbtIrec) []mappedExtent {
+	var m []mappedExtent
+	for _, e := range extents {
+		if e.State != 0 {
+			continue
+		}
+		m = append(m, mappedExtent{
+			start:    int64(e.StartOff),
+			end:      int64(e.StartOff + e.BlockCount),
+			physical: sb.BlockToPhysicalOffset(e.StartBlock),
+		})
+	}
+	sort.Slice(m, func(i, j int) bool { return m[i].start < m[j].start })
+	return m
+}
+
+// segment is a range of file blocks mapped by at most one extent of each file
+type segment struct {
+	start, end int64
+	old, new   *mappedExtent
+}
+
+// segments splits the file blocks [0, blocks) at the boundaries of the
+// extents of both maps and at the old end of file
+func segments(oldMap, newMap []mappedExtent, oldBlocks, blocks int64) []segment {
+	bounds := []int64{0, oldBlocks, blocks}
+	for _, m := range [][]mappedExtent{oldMap, newMap} {
+		for _, e := range m {
+			bounds = append(bounds, e.start, e.end)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	find := func(m []mappedExtent, n int64) *mappedExtent {
+		i := sort.Search(len(m), func(i int) bool { return m[i].end > n })
+		if i < len(m) && m[i].start <= n {
+			return &m[i]
+		}
+		return nil
+	}
+	var s []segment
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if start == end || start >= blocks {
+			continue
+		}
+		if end > blocks {
+			end = blocks
+		}
+		s = append(s, segment{start: start, end: end, old: find(oldMap, start), new: find(newMap, start)})
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileSystemDiff(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := old.Diff(old, ".", WalkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, actual %+v", changes)
+	}
+
+	// modify a copy of the image as a later state of the volume
+	b = append([]byte(nil), b...)
+	sb := old.PrimaryAG.SuperBlock
+	inode := func(name string) []byte {
+		info, err := old.Lstat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := sb.InodeAbsOffset(info.(FileInfo).Ino())
+		return b[offset : offset+uint64(sb.Inodesize)]
+	}
+	touch := func(core []byte, offset int) {
+		binary.BigEndian.PutUint64(core[offset:], binary.BigEndian.Uint64(core[offset:])+1)
+	}
+	// overwrite the second block in place
+	core := inode("fmt_extents_file_16384")
+	touch(core, 40)
+	touch(core, 48)
+	block := sb.BlockToPhysicalOffset(2777) + 1
+	copy(b[block*int64(sb.BlockSize):], "changed")
+	// change the attributes only
+	touch(inode("etc/os-release"), 48)
+	// free and allocate the inode again
+	binary.BigEndian.PutUint32(inode("fmt_extents_file_4096")[92:], 1)
+	// rename a shortform entry
+	dir := inode("fmt_local_directory")
+	i := bytes.Index(dir, []byte("short_form"))
+	copy(dir[i:], "short_fore")
+
+	fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = fileSystem.Diff(old, ".", WalkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type change struct {
+		Path   string
+		Kind   ChangeKind
+		Ranges []ByteRange
+	}
+	var actual []change
+	for _, c := range changes {
+		actual = append(actual, change{c.Path, c.Kind, c.Ranges})
+	}
+	expected := []change{
+		{"etc/os-release", ChangeMetadata, nil},
+		{"fmt_extents_file_16384", ChangeModified, []ByteRange{{Offset: 4096, Length: 4096}}},
+		{"fmt_extents_file_4096", ChangeReplaced, []ByteRange{{Offset: 0, Length: 4096}}},
+		{"fmt_local_directory/short_fore", ChangeAdded, nil},
+		{"fmt_local_directory/short_form", ChangeRemoved, nil},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %+v, actual %+v", expected, actual)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	oldMap := []mappedExtent{{start: 0, end: 4, physical: 100}}
+	newMap := []mappedExtent{{start: 0, end: 2, physical: 100}, {start: 6, end: 8, physical: 200}}
+	var actual [][2]int64
+	for _, s := range segments(oldMap, newMap, 4, 8) {
+		actual = append(actual, [2]int64{s.start, s.end})
+	}
+	expected := [][2]int64{{0, 2}, {2, 4}, {4, 6}, {6, 8}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, actual %v", expected, actual)
+	}
+}
@@ -0,0 +1,150 @@
+package xfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+
+	"golang.org/x/xerrors"
+)
+
+// dirIterator yields the entries of a directory one by one.
+// Data blocks of extent directories are read and decoded on demand, only
+// after the entries of the previous block have been consumed.
+type dirIterator struct {
+	xfs     *FileSystem
+	ino     uint64
+	pending []Entry
+
+	recs  []BmbtRec
+	rec   int
+	irec  BmbtIrec
+	block uint64
+
+	// skipped holds the unsupported blocks, that were passed over
+	skipped []uint64
+}
+
+func (xfs *FileSystem) newDirIterator(ino uint64) (*dirIterator, error) {
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse inode: %w", err)
+	}
+
+	if !inode.inodeCore.IsDir() {
+		return nil, xerrors.Errorf("inode %d is not directory: %w", ino, fs.ErrNotExist)
+	}
+
+	it := &dirIterator{xfs: xfs, ino: ino}
+	if inode.directoryLocal != nil {
+		for _, entry := range inode.directoryLocal.entries {
+			it.pending = append(it.pending, entry)
+		}
+	} else if inode.directoryExtents != nil {
+		if len(inode.directoryExtents.bmbtRecs) == 0 {
+			return nil, newCorruptedError("directory", -1, "inode %d has no extents", ino)
+		}
+		it.recs = inode.directoryExtents.bmbtRecs
+		it.irec = it.recs[0].Unpack()
+	} else {
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("reading entries of directory inode %d in format %d", ino, inode.inodeCore.Format))
+	}
+	return it, nil
+}
+
+// Next returns the next entry, io.EOF is returned after the last entry
+func (it *dirIterator) Next() (Entry, error) {
+	for len(it.pending) == 0 {
+		if err := it.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	entry := it.pending[0]
+	it.pending = it.pending[1:]
+	return entry, nil
+}
+
+// readBlock decodes the next directory data block into pending
+func (it *dirIterator) readBlock() error {
+	blockSize := int64(it.xfs.PrimaryAG.SuperBlock.BlockSize)
+	for {
+		if it.rec >= len(it.recs) {
+			return io.EOF
+		}
+		if it.block < it.irec.BlockCount {
+			break
+		}
+		it.nextRec()
+	}
+
+	// Leaf and Free blocks follow all data blocks, extents are sorted by offset
+	if int64(it.irec.StartOff+it.block)*blockSize >= XFS_DIR2_LEAF_OFFSET {
+		it.rec = len(it.recs)
+		return io.EOF
+	}
+
+	startBlock := it.irec.StartBlock + it.block
+	b, err := it.readDirBlock()
+	if err != nil {
+		return it.xfs.wrapInodeError(it.ino, it.xfs.wrapBlockError(startBlock, err))
+	}
+	block, err := it.xfs.parseDir2Block(b)
+	if err != nil {
+		err = it.xfs.wrapInodeError(it.ino, it.xfs.wrapBlockError(startBlock, err))
+		if !xerrors.Is(err, UnsupportedDir2BlockHeaderErr) {
+			return xerrors.Errorf("failed to parse dir2 block: %w", err)
+		}
+		it.xfs.Logger().Warn("skipping unsupported directory block", it.xfs.blockFields(startBlock, "dir", it.ino, "error", err)...)
+		it.skipped = append(it.skipped, startBlock)
+		return nil
+	}
+
+	for _, entry := range block.Entries {
+		it.pending = append(it.pending, entry)
+	}
+	return nil
+}
+
+// readDirBlock reads the directory block at the current position, a directory
+// block is 1 << sb_dirblklog file system blocks and may span several extents.
+func (it *dirIterator) readDirBlock() ([]byte, error) {
+	sb := it.xfs.PrimaryAG.SuperBlock
+	count := uint64(1) << sb.Dirblklog
+	startOff := it.irec.StartOff + it.block
+	if startOff%count != 0 {
+		return nil, newCorruptedError("directory block", -1, "offset %d is not aligned to %d blocks", startOff, count)
+	}
+
+	buf := make([]byte, 0, count*uint64(sb.BlockSize))
+	for i := uint64(0); i < count; i++ {
+		for it.rec < len(it.recs) && it.block >= it.irec.BlockCount {
+			it.nextRec()
+		}
+		if it.rec >= len(it.recs) || it.irec.StartOff+it.block != startOff+i {
+			return nil, newCorruptedError("directory block", -1, "offset %d: block %d is not mapped", startOff, startOff+i)
+		}
+		b, err := it.xfs.readMetadataBlock(sb.BlockToPhysicalOffset(it.irec.StartBlock + it.block))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read block: %w", err)
+		}
+		buf = append(buf, b...)
+		it.block++
+	}
+	return buf, nil
+}
+
+// partialError returns a *PartialDirectoryError when blocks were skipped, nil otherwise
+func (it *dirIterator) partialError() error {
+	if len(it.skipped) == 0 {
+		return nil
+	}
+	return &PartialDirectoryError{Ino: it.ino, SkippedBlocks: it.skipped}
+}
+
+func (it *dirIterator) nextRec() {
+	it.rec++
+	it.block = 0
+	if it.rec < len(it.recs) {
+		it.irec = it.recs[it.rec].Unpack()
+	}
+}
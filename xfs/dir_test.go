@@ -0,0 +1,213 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestDirIteratorSkipsUnsupportedBlock(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFS := func(b []byte) *FileSystem {
+		fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileSystem
+	}
+
+	expected, err := newFS(b).ReadDir("fmt_node_directories")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a zero magic is corruption and fails the listing
+	corrupted := append([]byte(nil), b...)
+	copy(corrupted[1418*4096:], []byte{0, 0, 0, 0})
+	if _, err := newFS(corrupted).ReadDir("fmt_node_directories"); !xerrors.Is(err, ErrCorrupted) {
+		t.Fatalf("expected %v, actual %v", ErrCorrupted, err)
+	}
+
+	// the second data block of fmt_node_directories becomes an unsupported v2 data block
+	binary.BigEndian.PutUint32(corrupted[1418*4096:], XFS_DIR2_DATA_MAGIC)
+	fileSystem := newFS(corrupted)
+
+	entries, err := fileSystem.ReadDir("fmt_node_directories")
+	if !xerrors.Is(err, ErrPartialDirectory) {
+		t.Fatalf("expected %v, actual %v", ErrPartialDirectory, err)
+	}
+	var partial *PartialDirectoryError
+	if !xerrors.As(err, &partial) || len(partial.SkippedBlocks) != 1 || partial.SkippedBlocks[0] != 1418 {
+		t.Fatalf("unexpected partial directory error: %+v", partial)
+	}
+	if len(entries) == 0 || len(entries) >= len(expected) {
+		t.Fatalf("expected a partial listing of %d entries, actual %d", len(expected), len(entries))
+	}
+	// entries of the blocks after the skipped one are still listed
+	if entries[len(entries)-1].Name() != expected[len(expected)-1].Name() {
+		t.Errorf("expected last entry %s, actual %s", expected[len(expected)-1].Name(), entries[len(entries)-1].Name())
+	}
+	if _, ok := fileSystem.dirCache.Get(partial.Ino); ok {
+		t.Error("partial listing must not be cached")
+	}
+	// a name, that is not in the blocks read, may be in the skipped one
+	if _, err := fileSystem.ReadDirInfo("fmt_node_directories/missing"); !xerrors.Is(err, ErrPartialDirectory) {
+		t.Errorf("expected %v, actual %v", ErrPartialDirectory, err)
+	}
+}
+
+func TestDirIteratorMultiBlockDirectory(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all entries of fmt_leaf_directories are in its first 4096 bytes data block
+	const ino = 11086
+	expected, err := source.listEntries(ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) != 202 {
+		t.Fatalf("expected 202 entries, actual %d", len(expected))
+	}
+	inode, err := source.ParseInode(ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	irec := inode.directoryExtents.bmbtRecs[0].Unpack()
+	block := make([]byte, 4096)
+	if _, err := f.ReadAt(block, source.PrimaryAG.SuperBlock.BlockToPhysicalOffset(irec.StartBlock)*4096); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		blockLog    uint8
+		dirBlockLog uint8
+	}{
+		{name: "1024 bytes blocks", blockLog: 10, dirBlockLog: 2},
+		{name: "512 bytes blocks", blockLog: 9, dirBlockLog: 3},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// store the directory block in two extents, at blocks 4 and 12
+			blockSize := 1 << tt.blockLog
+			half := len(block) / 2
+			img := make([]byte, 32*blockSize)
+			copy(img[4*blockSize:], block[:half])
+			copy(img[12*blockSize:], block[half:])
+			fileSystem := newBlockSizeFS(img, tt.blockLog, tt.dirBlockLog)
+
+			count := uint64(half / blockSize)
+			dir := *inode
+			dir.directoryExtents = &DirectoryExtents{bmbtRecs: []BmbtRec{
+				packBmbtRec(0, 4, count),
+				packBmbtRec(count, 12, count),
+			}}
+			fileSystem.pinnedInodes = map[uint64]*Inode{ino: &dir}
+
+			entries, err := fileSystem.listEntries(ino)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(expected) {
+				t.Fatalf("expected %d entries, actual %d", len(expected), len(entries))
+			}
+			for i := range entries {
+				if entries[i].Name() != expected[i].Name() || entries[i].InodeNumber() != expected[i].InodeNumber() {
+					t.Errorf("expected %s (%d), actual %s (%d)", expected[i].Name(), expected[i].InodeNumber(), entries[i].Name(), entries[i].InodeNumber())
+				}
+			}
+		})
+	}
+}
+
+func TestDirIteratorUnusedEntries(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the single data block of fmt_leaf_directories
+	const block = 1383
+	blockSize := int(fileSystem.PrimaryAG.SuperBlock.BlockSize)
+	churned := append([]byte(nil), b...)
+	dataBlock, err := fileSystem.parseDir2Block(churned[block*blockSize : (block+1)*blockSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := dataBlock.Entries
+	entrySize := func(e Dir2DataEntry) int {
+		return (8 + 1 + int(e.Namelen) + 1 + 2 + 7) &^ 7
+	}
+
+	// remove entries as the kernel does, adjacent unused regions are merged
+	// and the old entry is left in the padding of the region
+	removed := make(map[string]bool)
+	for _, r := range [][2]int{{10, 13}, {50, 51}, {len(entries) - 1, len(entries)}} {
+		start := int(entries[r[0]].Tag)
+		length := 0
+		for _, e := range entries[r[0]:r[1]] {
+			length += entrySize(e)
+			removed[e.EntryName] = true
+		}
+		region := churned[block*blockSize+start:]
+		binary.BigEndian.PutUint16(region, XFS_DIR2_DATA_FREE_TAG)
+		binary.BigEndian.PutUint16(region[2:], uint16(length))
+		binary.BigEndian.PutUint16(region[length-2:], uint16(start))
+	}
+
+	fileSystem, err = NewFS(*io.NewSectionReader(bytes.NewReader(churned), 0, int64(len(churned))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fileSystem.listEntries(11086)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	for _, e := range entries {
+		if !removed[e.EntryName] {
+			expected = append(expected, e.EntryName)
+		}
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d entries, actual %d", len(expected), len(actual))
+	}
+	for i, e := range actual {
+		if e.Name() != expected[i] {
+			t.Errorf("expected %s, actual %s", expected[i], e.Name())
+		}
+	}
+
+	// an unused region running past the end of the block is corruption
+	binary.BigEndian.PutUint16(churned[block*blockSize+int(entries[50].Tag)+2:], uint16(blockSize))
+	fileSystem, err = NewFS(*io.NewSectionReader(bytes.NewReader(churned), 0, int64(len(churned))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.listEntries(11086); !xerrors.Is(err, ErrCorrupted) {
+		t.Errorf("expected %v, actual %v", ErrCorrupted, err)
+	}
+}
@@ -0,0 +1,369 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// dir3DataHdrSize is the size of Dir3DataHdr
+	dir3DataHdrSize = 64
+	// dir3DataFirstOffset is the offset of the first entry after "." and
+	// ".." in a data block, XFS_DIR3_DATA_FIRST_OFFSET
+	dir3DataFirstOffset = dir3DataHdrSize + 2*16
+)
+
+// dataEntsize returns the size of a data entry of a name of namelen bytes
+// with the file type, XFS_DIR3_DATA_ENTSIZE
+func dataEntsize(namelen int) int {
+	return (8 + 1 + namelen + 1 + 2 + 7) &^ 7
+}
+
+// daHashName returns the hash of a name in the leaves of directories and
+// attribute forks, xfs_da_hashname
+func daHashName(name []byte) uint32 {
+	var hash uint32
+	for ; len(name) >= 4; name = name[4:] {
+		hash = uint32(name[0])<<21 ^ uint32(name[1])<<14 ^ uint32(name[2])<<7 ^ uint32(name[3]) ^ bits.RotateLeft32(hash, 7*4)
+	}
+	switch len(name) {
+	case 3:
+		return uint32(name[0])<<14 ^ uint32(name[1])<<7 ^ uint32(name[2]) ^ bits.RotateLeft32(hash, 7*3)
+	case 2:
+		return uint32(name[0])<<7 ^ uint32(name[1]) ^ bits.RotateLeft32(hash, 7*2)
+	case 1:
+		return uint32(name[0]) ^ bits.RotateLeft32(hash, 7)
+	}
+	return hash
+}
+
+// dirSlot is an entry of a writableDir
+type dirSlot struct {
+	name  string
+	ftype uint8
+	ino   uint64
+	// offset is the data block offset of an entry of a shortform directory,
+	// 0 until it is placed
+	offset uint16
+}
+
+// writableDir is a shortform or single block directory loaded to be changed,
+// the write operations do not change leaf and node directories
+type writableDir struct {
+	xfs  *FileSystem
+	ino  uint64
+	core InodeCore
+	// parent is the inode number of ".."
+	parent uint64
+	// entries are the entries without "." and ".."
+	entries []dirSlot
+
+	// block is the file system block of a block directory and hdr its header
+	isBlock bool
+	block   uint64
+	hdr     Dir3BlkHdr
+}
+
+// loadDir loads the directory ino to be changed
+func (xfs *FileSystem) loadDir(ino uint64) (*writableDir, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	if !inode.inodeCore.IsDir() {
+		return nil, xerrors.Errorf("inode %d is not a directory: %w", ino, fs.ErrInvalid)
+	}
+
+	d := &writableDir{xfs: xfs, ino: ino, core: inode.inodeCore}
+	switch {
+	case inode.directoryLocal != nil:
+		d.parent = inode.directoryLocal.dir2SfHdr.Parent
+		for _, e := range inode.directoryLocal.entries {
+			d.entries = append(d.entries, dirSlot{
+				name:   e.EntryName,
+				ftype:  e.Filetype,
+				ino:    e.Inumber,
+				offset: binary.BigEndian.Uint16(e.Offset[:]),
+			})
+		}
+	case inode.directoryExtents != nil:
+		dirBlocks := uint64(1) << sb.Dirblklog
+		recs := inode.extents()
+		if d.core.Size != dirBlocks*uint64(sb.BlockSize) || len(recs) != 1 || recs[0].StartOff != 0 || recs[0].BlockCount != dirBlocks {
+			return nil, newUnsupportedFeatureError(fmt.Sprintf("changing leaf and node directories, inode %d", ino))
+		}
+		b, err := xfs.readBlock(sb.BlockToPhysicalOffset(recs[0].StartBlock), uint32(dirBlocks))
+		if err != nil {
+			return nil, xfs.wrapBlockError(recs[0].StartBlock, err)
+		}
+		block, err := xfs.parseDir2Block(b)
+		if err != nil {
+			return nil, xfs.wrapInodeError(ino, xfs.wrapBlockError(recs[0].StartBlock, err))
+		}
+		if block.Header.Magic != XFS_DIR3_BLOCK_MAGIC {
+			return nil, newUnsupportedFeatureError(fmt.Sprintf("changing leaf and node directories, inode %d", ino))
+		}
+		d.isBlock, d.block, d.hdr = true, recs[0].StartBlock, block.Header.Dir3BlkHdr
+		for _, e := range block.Entries {
+			switch e.EntryName {
+			case ".":
+			case "..":
+				d.parent = e.Inumber
+			default:
+				d.entries = append(d.entries, dirSlot{name: e.EntryName, ftype: e.Filetype, ino: e.Inumber})
+			}
+		}
+	default:
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("changing directories in format %d, inode %d", inode.inodeCore.Format, ino))
+	}
+	return d, nil
+}
+
+// add adds the entry name of the inode ino of the file type ftype
+func (d *writableDir) add(name string, ftype uint8, ino uint64) {
+	d.entries = append(d.entries, dirSlot{name: name, ftype: ftype, ino: ino})
+}
+
+// remove removes the entry name, ok is false when it does not exist
+func (d *writableDir) remove(name string) (_ dirSlot, ok bool) {
+	for i, e := range d.entries {
+		if e.name == name {
+			d.entries = append(d.entries[:i], d.entries[i+1:]...)
+			return e, true
+		}
+	}
+	return dirSlot{}, false
+}
+
+// dirChange is a change of a directory planned by writableDir.plan, commit
+// writes it
+type dirChange struct {
+	xfs  *FileSystem
+	ino  uint64
+	core InodeCore
+	// fork is the data fork of a shortform directory, or of a directory
+	// converted to a block directory
+	fork []byte
+	// data is the directory block of a block directory
+	block uint64
+	data  []byte
+	// alloc is the block of a converted directory
+	alloc *blockAlloc
+}
+
+// plan encodes the entries of the directory, changed at now. A shortform
+// directory, whose entries do not fit in the inode, is converted to a block
+// directory in a block allocated after the planned allocations.
+func (d *writableDir) plan(now time.Time, planned ...*blockAlloc) (*dirChange, error) {
+	c := &dirChange{xfs: d.xfs, ino: d.ino, core: d.core}
+	c.core.touch(now)
+	if !d.isBlock {
+		fork, ok := d.encodeShortform()
+		if ok {
+			c.fork = fork
+			c.core.Size = uint64(len(fork))
+			return c, nil
+		}
+		if err := d.toBlock(c, planned); err != nil {
+			return nil, err
+		}
+	}
+	data, ok := d.encodeBlock()
+	if !ok {
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("converting block directory inode %d to leaf format", d.ino))
+	}
+	c.block, c.data = d.block, data
+	return c, nil
+}
+
+// toBlock allocates the block of a shortform directory converted to a block
+// directory, and sets the data fork and the core of c mapping it
+func (d *writableDir) toBlock(c *dirChange, planned []*blockAlloc) error {
+	sb := d.xfs.PrimaryAG.SuperBlock
+	dirBlocks := uint64(1) << sb.Dirblklog
+	agNumber, _, _ := sb.InodeOffset(d.ino)
+	alloc, err := d.xfs.planBlocks(uint64(agNumber), dirBlocks, 1, planned...)
+	if err != nil {
+		return xerrors.Errorf("failed to allocate a block converting directory inode %d: %w", d.ino, err)
+	}
+	e := alloc.extents[0]
+	block := uint64(e.AG)<<sb.Agblklog | uint64(e.Start)
+
+	d.isBlock, d.block = true, block
+	d.hdr = Dir3BlkHdr{
+		Magic:    XFS_DIR3_BLOCK_MAGIC,
+		BlockNo:  uint64(sb.BlockToPhysicalOffset(block)) * uint64(sb.BlockSize) / BBSIZE,
+		MetaUUID: sb.UUID,
+		Owner:    d.ino,
+	}
+	if sb.FeaturesIncompat&XFS_SB_FEAT_INCOMPAT_META_UUID != 0 {
+		d.hdr.MetaUUID = sb.MetaUUID
+	}
+
+	var fork bytes.Buffer
+	rec := BmbtIrec{StartBlock: block, BlockCount: dirBlocks}.Pack()
+	if err := binary.Write(&fork, binary.BigEndian, rec); err != nil {
+		return xerrors.Errorf("failed to encode extent: %w", err)
+	}
+	c.alloc, c.fork = alloc, fork.Bytes()
+	c.core.Format = XFS_DINODE_FMT_EXTENTS
+	c.core.Size = dirBlocks * uint64(sb.BlockSize)
+	c.core.Nblocks += dirBlocks
+	c.core.Nextents = 1
+	return nil
+}
+
+func (c *dirChange) commit() error {
+	sb := c.xfs.PrimaryAG.SuperBlock
+	if c.alloc != nil {
+		if err := c.alloc.commit(); err != nil {
+			return err
+		}
+	}
+	if c.data != nil {
+		if err := c.xfs.writeBlock(sb.BlockToPhysicalOffset(c.block), c.data); err != nil {
+			return c.xfs.wrapBlockError(c.block, err)
+		}
+	}
+	if err := c.xfs.writeInode(c.ino, c.core, c.fork); err != nil {
+		return err
+	}
+	c.xfs.dirCache.Remove(c.ino)
+	return nil
+}
+
+// encodeShortform returns the data fork of the directory in shortform, ok is
+// false when it does not fit in the data fork
+func (d *writableDir) encodeShortform() (_ []byte, ok bool) {
+	if len(d.entries) > math.MaxUint8 {
+		return nil, false
+	}
+	// new entries go after the last entry, the offsets are only compacted
+	// when they would not fit in a block, as xfs_dir2_sf_addname_pick
+	if !d.placeShortform(false) && !d.placeShortform(true) {
+		return nil, false
+	}
+
+	var i8count int
+	for _, ino := range append([]uint64{d.parent}, d.inodeNumbers()...) {
+		if ino > math.MaxUint32 {
+			i8count++
+		}
+	}
+	var b bytes.Buffer
+	putIno := func(ino uint64) {
+		if i8count > 0 {
+			binary.Write(&b, binary.BigEndian, ino)
+			return
+		}
+		binary.Write(&b, binary.BigEndian, uint32(ino))
+	}
+	b.WriteByte(uint8(len(d.entries)))
+	b.WriteByte(uint8(i8count))
+	putIno(d.parent)
+	for _, e := range d.entries {
+		b.WriteByte(uint8(len(e.name)))
+		binary.Write(&b, binary.BigEndian, e.offset)
+		b.WriteString(e.name)
+		b.WriteByte(e.ftype)
+		putIno(e.ino)
+	}
+	if b.Len() > d.xfs.DataForkSize(d.core.Forkoff) {
+		return nil, false
+	}
+	return b.Bytes(), true
+}
+
+// placeShortform sets the offsets of the entries not placed yet after the
+// entry before them, or of all entries when compact, and reports whether the
+// block of the entries fits in a directory block
+func (d *writableDir) placeShortform(compact bool) bool {
+	sb := d.xfs.PrimaryAG.SuperBlock
+	offset := dir3DataFirstOffset
+	for i := range d.entries {
+		e := &d.entries[i]
+		if compact || e.offset == 0 {
+			e.offset = uint16(offset)
+		}
+		offset = int(e.offset) + dataEntsize(len(e.name))
+	}
+	// the leaf entries of the entries and the dot entries, and the tail
+	used := offset + (len(d.entries)+2)*LEAF_ENTRY_SIZE + 8
+	return used <= int(sb.BlockSize)<<sb.Dirblklog
+}
+
+func (d *writableDir) inodeNumbers() []uint64 {
+	inos := make([]uint64, 0, len(d.entries))
+	for _, e := range d.entries {
+		inos = append(inos, e.ino)
+	}
+	return inos
+}
+
+// encodeBlock returns the directory block of the directory, ok is false when
+// the entries and their leaves do not fit in it. The entries are packed from
+// the start of the block in order, followed by a single unused region.
+func (d *writableDir) encodeBlock() (_ []byte, ok bool) {
+	sb := d.xfs.PrimaryAG.SuperBlock
+	size := int(sb.BlockSize) << sb.Dirblklog
+	b := make([]byte, size)
+
+	entries := append([]dirSlot{
+		{name: ".", ftype: XFS_DIR3_FT_DIR, ino: d.ino},
+		{name: "..", ftype: XFS_DIR3_FT_DIR, ino: d.parent},
+	}, d.entries...)
+	leafStart := size - 8 - LEAF_ENTRY_SIZE*len(entries)
+	leaves := make([]Dir2LeafEntry, 0, len(entries))
+	offset := dir3DataHdrSize
+	for _, e := range entries {
+		n := dataEntsize(len(e.name))
+		if offset+n > leafStart {
+			return nil, false
+		}
+		binary.BigEndian.PutUint64(b[offset:], e.ino)
+		b[offset+8] = uint8(len(e.name))
+		copy(b[offset+9:], e.name)
+		b[offset+9+len(e.name)] = e.ftype
+		binary.BigEndian.PutUint16(b[offset+n-2:], uint16(offset))
+		leaves = append(leaves, Dir2LeafEntry{
+			Hashval: daHashName([]byte(e.name)),
+			Address: uint32(offset >> XFS_DIR2_DATA_ALIGN_LOG),
+		})
+		offset += n
+	}
+
+	hdr := Dir3DataHdr{Dir3BlkHdr: d.hdr}
+	hdr.Magic = XFS_DIR3_BLOCK_MAGIC
+	if free := leafStart - offset; free > 0 {
+		binary.BigEndian.PutUint16(b[offset:], XFS_DIR2_DATA_FREE_TAG)
+		binary.BigEndian.PutUint16(b[offset+2:], uint16(free))
+		binary.BigEndian.PutUint16(b[leafStart-2:], uint16(offset))
+		hdr.Frees[0] = Dir2DataFree{Offset: uint16(offset), Length: uint16(free)}
+	}
+	var h bytes.Buffer
+	binary.Write(&h, binary.BigEndian, hdr)
+	copy(b, h.Bytes())
+
+	sort.Slice(leaves, func(i, j int) bool {
+		if leaves[i].Hashval != leaves[j].Hashval {
+			return leaves[i].Hashval < leaves[j].Hashval
+		}
+		return leaves[i].Address < leaves[j].Address
+	})
+	for i, l := range leaves {
+		binary.BigEndian.PutUint32(b[leafStart+LEAF_ENTRY_SIZE*i:], l.Hashval)
+		binary.BigEndian.PutUint32(b[leafStart+LEAF_ENTRY_SIZE*i+4:], l.Address)
+	}
+	binary.BigEndian.PutUint32(b[size-8:], uint32(len(entries)))
+	setCRC(b, XFS_DIR3_DATA_CRC_OFF)
+	return b, true
+}
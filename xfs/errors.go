@@ -0,0 +1,155 @@
+package xfs
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	// ErrNotXFS is returned when the image does not start with an XFS superblock
+	ErrNotXFS = xerrors.New("not xfs file system")
+
+	// ErrUnsupportedFeature matches every *UnsupportedFeatureError
+	ErrUnsupportedFeature = xerrors.New("unsupported feature")
+
+	// ErrCorrupted matches every *CorruptedError
+	ErrCorrupted = xerrors.New("corrupted file system")
+
+	// ErrReadOnly is returned by operations that need to modify the file system
+	ErrReadOnly = xerrors.New("read only file system")
+
+	// ErrNoSpace is returned by write operations, when the free blocks or
+	// inodes cannot hold the change
+	ErrNoSpace = xerrors.New("no space left on device")
+
+	// ErrNotEmpty is returned by Remove for a directory holding entries
+	ErrNotEmpty = xerrors.New("directory not empty")
+
+	// ErrDirtyLog is returned by NewFS when the log was not cleanly unmounted, see WithAllowDirty
+	ErrDirtyLog = xerrors.New("log is dirty")
+
+	// ErrPartialDirectory matches every *PartialDirectoryError
+	ErrPartialDirectory = xerrors.New("partial directory listing")
+
+	// ErrDangling matches every *DanglingSymlinkError
+	ErrDangling = xerrors.New("dangling symlink")
+
+	// ErrNoAttribute is returned by GetXattr for a missing extended attribute
+	ErrNoAttribute = xerrors.New("no such attribute")
+)
+
+// UnsupportedFeatureError is returned when the image uses an on-disk format
+// feature this package does not implement, use errors.Is(err, ErrUnsupportedFeature)
+// to branch on it.
+type UnsupportedFeatureError struct {
+	Feature string
+}
+
+func newUnsupportedFeatureError(feature string) error {
+	return &UnsupportedFeatureError{Feature: feature}
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("unsupported feature: %s", e.Feature)
+}
+
+func (e *UnsupportedFeatureError) Is(target error) bool {
+	return target == ErrUnsupportedFeature
+}
+
+// CorruptedError is returned when an on-disk structure fails validation,
+// use errors.Is(err, ErrCorrupted) to branch on it.
+type CorruptedError struct {
+	// Structure is the name of the on-disk structure, e.g. "inode", "superblock"
+	Structure string
+	// Offset is the byte offset of the structure in the image, -1 when unknown
+	Offset int64
+	Reason string
+}
+
+func newCorruptedError(structure string, offset int64, format string, args ...interface{}) error {
+	return &CorruptedError{
+		Structure: structure,
+		Offset:    offset,
+		Reason:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *CorruptedError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("corrupted %s: %s", e.Structure, e.Reason)
+	}
+	return fmt.Sprintf("corrupted %s at offset 0x%x: %s", e.Structure, e.Offset, e.Reason)
+}
+
+func (e *CorruptedError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// PartialDirectoryError is returned together with the entries that could be
+// read when some blocks of a directory were skipped, use
+// errors.Is(err, ErrPartialDirectory) to keep the partial listing.
+type PartialDirectoryError struct {
+	// Ino is the inode number of the directory
+	Ino uint64
+	// SkippedBlocks are the file system block numbers that were not decoded
+	SkippedBlocks []uint64
+}
+
+func (e *PartialDirectoryError) Error() string {
+	return fmt.Sprintf("partial directory listing: inode %d: %d blocks skipped", e.Ino, len(e.SkippedBlocks))
+}
+
+func (e *PartialDirectoryError) Is(target error) bool {
+	return target == ErrPartialDirectory
+}
+
+// DanglingSymlinkError is returned by Stat, when a symlink on the path points
+// to a target that does not exist in the image, use errors.Is(err, ErrDangling)
+// to branch on it.
+type DanglingSymlinkError struct {
+	// Link is the path of the symlink in the image
+	Link string
+	// Target is the content of the symlink
+	Target string
+	// Reason is set when the target was not looked up, e.g. on a symlink loop
+	Reason string
+}
+
+func (e *DanglingSymlinkError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("dangling symlink %s -> %s: %s", e.Link, e.Target, e.Reason)
+	}
+	return fmt.Sprintf("dangling symlink %s -> %s", e.Link, e.Target)
+}
+
+func (e *DanglingSymlinkError) Is(target error) bool {
+	return target == ErrDangling
+}
+
+// setCorruptedOffset fills the offset of a CorruptedError in err when it is
+// unknown, structures parsed from a buffer learn their offset from the caller.
+func setCorruptedOffset(err error, offset int64) {
+	var corrupted *CorruptedError
+	if xerrors.As(err, &corrupted) && corrupted.Offset < 0 {
+		corrupted.Offset = offset
+	}
+}
+
+// wrapInodeError adds the inode number, AG number and byte offset of the inode to err.
+func (xfs *FileSystem) wrapInodeError(ino uint64, err error) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	agNumber, _, _ := sb.InodeOffset(ino)
+	offset := int64(sb.InodeAbsOffset(ino))
+	setCorruptedOffset(err, offset)
+	return xerrors.Errorf("inode %d (AG %d, offset 0x%x): %w", ino, agNumber, offset, err)
+}
+
+// wrapBlockError adds the file system block number, AG number and byte offset of the block to err.
+func (xfs *FileSystem) wrapBlockError(block uint64, err error) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	offset := sb.BlockToPhysicalOffset(block) * int64(sb.BlockSize)
+	setCorruptedOffset(err, offset)
+	return xerrors.Errorf("block %d (AG %d, offset 0x%x): %w", block, sb.BlockToAgNumber(block), offset, err)
+}
@@ -0,0 +1,292 @@
+package xfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/xerrors"
+)
+
+func TestFileReadSize(t *testing.T) {
+	testCases := []struct {
+		name         string
+		ino          uint64
+		size         uint64
+		modify       func(recs []BmbtRec) []BmbtRec
+		expectedZero bool
+	}{
+		{
+			name: "fmt_extents_file_1024",
+			ino:  20440,
+		},
+		{
+			name: "sparse file",
+			ino:  20442,
+			modify: func(recs []BmbtRec) []BmbtRec {
+				return nil
+			},
+			expectedZero: true,
+		},
+		{
+			name: "sparse file with short tail",
+			ino:  20442,
+			size: 10,
+			modify: func(recs []BmbtRec) []BmbtRec {
+				return nil
+			},
+			expectedZero: true,
+		},
+		{
+			name: "unwritten extents",
+			ino:  20442,
+			modify: func(recs []BmbtRec) []BmbtRec {
+				var ret []BmbtRec
+				for _, rec := range recs {
+					rec.L0 |= 1 << 63
+					ret = append(ret, rec)
+				}
+				return ret
+			},
+			expectedZero: true,
+		},
+	}
+
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			inode, err := fileSystem.ParseInode(tt.ino)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.size != 0 {
+				inode.inodeCore.Size = tt.size
+			}
+			if tt.modify != nil {
+				inode.regularExtent = &RegularExtent{bmbtRecs: tt.modify(inode.regularExtent.bmbtRecs)}
+			}
+
+			file, err := fileSystem.newFile(dirEntry{newFileInfo(tt.name, inode)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(len(buf)) != inode.inodeCore.Size {
+				t.Fatalf("expected %d, actual %d", inode.inodeCore.Size, len(buf))
+			}
+			if tt.expectedZero && !bytes.Equal(buf, make([]byte, len(buf))) {
+				t.Fatal("expected zero filled content")
+			}
+		})
+	}
+}
+
+func TestFileReadSemantics(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		ino   uint64
+		empty bool
+	}{
+		{
+			name: "fmt_extents_file_1024",
+			ino:  20440,
+		},
+		{
+			name: "fmt_extents_file_16384",
+			ino:  20442,
+		},
+		{
+			name:  "zero length file",
+			ino:   20442,
+			empty: true,
+		},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			inode, err := fileSystem.ParseInode(tt.ino)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.empty {
+				inode.inodeCore.Size = 0
+			}
+
+			open := func() *File {
+				file, err := fileSystem.newFile(dirEntry{newFileInfo(tt.name, inode)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return file
+			}
+
+			expected, err := io.ReadAll(open())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := iotest.TestReader(open(), expected); err != nil {
+				t.Fatal(err)
+			}
+
+			// one byte reads and reads after EOF
+			file := open()
+			var actual []byte
+			b := make([]byte, 1)
+			for {
+				n, err := file.Read(b)
+				if err == io.EOF {
+					if n != 0 {
+						t.Fatalf("expected 0 byte with EOF, actual %d", n)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != 1 {
+					t.Fatalf("expected 1 byte, actual %d", n)
+				}
+				actual = append(actual, b...)
+			}
+			if !bytes.Equal(expected, actual) {
+				t.Fatal("one byte reads differ from ReadAll")
+			}
+			for i := 0; i < 2; i++ {
+				if n, err := file.Read(b); n != 0 || err != io.EOF {
+					t.Fatalf("expected 0, EOF after EOF, actual %d, %v", n, err)
+				}
+			}
+		})
+	}
+}
+
+// newBlockSizeFS returns a single AG v5 file system over b with the given block size
+func newBlockSizeFS(b []byte, blockLog, dirBlockLog uint8) *FileSystem {
+	fileSystem := &FileSystem{
+		r:     io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))),
+		cache: &mockCache[string, any]{},
+	}
+	sb := &fileSystem.PrimaryAG.SuperBlock
+	sb.Versionnum = XFS_SB_VERSION_5
+	sb.BlockSize = 1 << blockLog
+	sb.Blocklog = blockLog
+	sb.Dirblklog = dirBlockLog
+	sb.Agcount = 1
+	sb.Agblocks = uint32(len(b) >> blockLog)
+	sb.Agblklog = uint8(bits.Len32(sb.Agblocks - 1))
+	sb.Dblocks = uint64(sb.Agblocks)
+	sb.Inodesize = 512
+	sb.Inopblock = uint16(sb.BlockSize / 512)
+	sb.Inopblog = blockLog - 9
+	return fileSystem
+}
+
+func packBmbtRec(startOff, startBlock, blockCount uint64) BmbtRec {
+	return BmbtRec{
+		L0: startOff<<9 | startBlock>>43,
+		L1: startBlock<<21 | blockCount,
+	}
+}
+
+func TestFileReadBlockSizes(t *testing.T) {
+	for _, blockLog := range []uint8{9, 10, 12, 16} {
+		t.Run(fmt.Sprintf("block size %d", 1<<blockLog), func(t *testing.T) {
+			blockSize := 1 << blockLog
+			content := make([]byte, 4*blockSize+100)
+			for i := range content {
+				content[i] = byte(i % 251)
+			}
+
+			// the file is stored at blocks 2-3 and 6-8 of the image
+			img := make([]byte, 10*blockSize)
+			copy(img[2*blockSize:], content[:2*blockSize])
+			copy(img[6*blockSize:], content[2*blockSize:])
+			fileSystem := newBlockSizeFS(img, blockLog, 0)
+
+			var inode Inode
+			inode.inodeCore.Mode = S_IFREG | 0644
+			inode.inodeCore.Size = uint64(len(content))
+			inode.regularExtent = &RegularExtent{bmbtRecs: []BmbtRec{
+				packBmbtRec(0, 2, 2),
+				packBmbtRec(2, 6, 3),
+			}}
+			file, err := fileSystem.newFile(dirEntry{newFileInfo("file", &inode)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, content) {
+				t.Fatalf("unexpected content, length %d", len(buf))
+			}
+
+			for _, offset := range []int64{0, 1, int64(blockSize), int64(2*blockSize) - 1, int64(len(content)) - 1, int64(len(content)), int64(len(content)) + 10} {
+				if _, err := file.Seek(offset, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				buf, err := io.ReadAll(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := []byte{}
+				if offset < int64(len(content)) {
+					expected = content[offset:]
+				}
+				if !bytes.Equal(buf, expected) {
+					t.Fatalf("unexpected content from offset %d, length %d", offset, len(buf))
+				}
+			}
+
+			if _, err := file.Seek(int64(blockSize)+3, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 5)
+			if _, err := io.ReadFull(file, b); err != nil {
+				t.Fatal(err)
+			}
+			if offset, err := file.Seek(-2, io.SeekCurrent); err != nil || offset != int64(blockSize)+6 {
+				t.Fatalf("expected offset %d, actual %d, %v", blockSize+6, offset, err)
+			}
+			if offset, err := file.Seek(-100, io.SeekEnd); err != nil || offset != int64(4*blockSize) {
+				t.Fatalf("expected offset %d, actual %d, %v", 4*blockSize, offset, err)
+			}
+			if _, err := file.Seek(-1, io.SeekStart); !xerrors.Is(err, fs.ErrInvalid) {
+				t.Fatalf("expected ErrInvalid, actual %v", err)
+			}
+		})
+	}
+}
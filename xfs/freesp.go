@@ -0,0 +1,48 @@
+package xfs
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// FreeExtent is a record of the free space by block btree of an AG, Start is
+// relative to the AG.
+type FreeExtent struct {
+	AG    uint32
+	Start uint32
+	Count uint32
+}
+
+// FreeExtents returns the free extents of every AG in block order
+func (xfs *FileSystem) FreeExtents() ([]FreeExtent, error) {
+	var extents []FreeExtent
+	for agNumber := range xfs.AGs {
+		e, err := xfs.agFreeExtents(uint64(agNumber))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the free space btree of AG %d: %w", agNumber, err)
+		}
+		extents = append(extents, e...)
+	}
+	return extents, nil
+}
+
+// agFreeExtents returns the free extents of the AG agNumber in block order
+func (xfs *FileSystem) agFreeExtents(agNumber uint64) ([]FreeExtent, error) {
+	var extents []FreeExtent
+	bt := xfs.bnoBtree(agNumber)
+	length := xfs.AGs[agNumber].Agf.Length
+	err := xfs.walkShortBtree(bt, func(rec []byte) error {
+		e := FreeExtent{
+			AG:    uint32(agNumber),
+			Start: binary.BigEndian.Uint32(rec),
+			Count: binary.BigEndian.Uint32(rec[4:]),
+		}
+		if e.Count == 0 || uint64(e.Start)+uint64(e.Count) > uint64(length) {
+			return newCorruptedError(bt.name, -1, "AG %d: invalid free extent %d+%d", agNumber, e.Start, e.Count)
+		}
+		extents = append(extents, e)
+		return nil
+	})
+	return extents, err
+}
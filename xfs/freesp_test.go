@@ -0,0 +1,41 @@
+package xfs
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFreeExtents(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extents, err := fileSystem.FreeExtents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FreeExtent{{AG: 0, Start: 2785, Count: 2326}}
+	if !reflect.DeepEqual(extents, expected) {
+		t.Errorf("expected %+v, actual %+v", expected, extents)
+	}
+
+	// the AGF counts the same blocks
+	var blocks uint32
+	for _, e := range extents {
+		blocks += e.Count
+	}
+	if freeblks := fileSystem.AGs[0].Agf.Freeblks; blocks != freeblks {
+		t.Errorf("expected %d free blocks, actual %d", freeblks, blocks)
+	}
+}
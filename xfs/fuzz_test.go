@@ -0,0 +1,210 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+// overlayReaderAt reads base with data laid over it at off, fuzzers replace
+// data to feed a structure to the parsers inside an otherwise valid image.
+type overlayReaderAt struct {
+	base []byte
+	off  int64
+	data []byte
+}
+
+func (o *overlayReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(o.base)) {
+		return 0, io.EOF
+	}
+	n := copy(p, o.base[off:])
+	for i := 0; i < n; i++ {
+		if j := off + int64(i) - o.off; j >= 0 && j < int64(len(o.data)) {
+			p[i] = o.data[j]
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func newFuzzFS(f *testing.F, image string) (*FileSystem, *overlayReaderAt) {
+	b, err := os.ReadFile(image)
+	if err != nil {
+		f.Fatal(err)
+	}
+	overlay := &overlayReaderAt{base: b}
+	fileSystem, err := NewFS(*io.NewSectionReader(overlay, 0, int64(len(b))), nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return fileSystem, overlay
+}
+
+func FuzzParseAG(f *testing.F) {
+	for _, image := range []string{"testdata/image.xfs", "testdata/image40.xfs"} {
+		b, err := os.ReadFile(image)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b[:4*512])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ag, err := ParseAG(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// geometry helpers must not panic on a validated superblock
+		ag.SuperBlock.AGBlocks(0)
+		ag.SuperBlock.InodeAbsOffset(uint64(ag.SuperBlock.Rootino))
+		ag.SuperBlock.BlockToPhysicalOffset(uint64(ag.SuperBlock.Dblocks))
+	})
+}
+
+func FuzzParseInode(f *testing.F) {
+	fileSystem, overlay := newFuzzFS(f, "testdata/image.xfs")
+	sb := fileSystem.PrimaryAG.SuperBlock
+
+	// seed with every inode of the root directory
+	entries, err := fileSystem.listEntries(sb.Rootino)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, ino := range append([]uint64{sb.Rootino}, entryInodes(entries)...) {
+		off := int64(sb.InodeAbsOffset(ino))
+		f.Add(overlay.base[off : off+int64(sb.Inodesize)])
+	}
+
+	// the fuzzed inode replaces the root inode, so that its inode number matches
+	overlay.off = int64(sb.InodeAbsOffset(sb.Rootino))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		overlay.data = data
+		inode, err := fileSystem.ParseInode(sb.Rootino)
+		if err != nil {
+			return
+		}
+		if inode.inodeCore.IsDir() {
+			fileSystem.listEntries(sb.Rootino)
+		}
+	})
+}
+
+func FuzzParseDir2Block(f *testing.F) {
+	fileSystem, overlay := newFuzzFS(f, "testdata/image.xfs")
+	blockSize := int64(fileSystem.PrimaryAG.SuperBlock.BlockSize)
+
+	// data blocks of fmt_leaf_directories and fmt_node_directories
+	for _, block := range []int64{1383, 1416, 2524} {
+		f.Add(overlay.base[block*blockSize : (block+1)*blockSize])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fileSystem.parseDir2Block(data)
+	})
+}
+
+func FuzzParseBmbtRecs(f *testing.F) {
+	fileSystem, overlay := newFuzzFS(f, "testdata/image.xfs")
+	sb := fileSystem.PrimaryAG.SuperBlock
+
+	for _, name := range []string{"fmt_extents_file_1024", "fmt_extents_file_4096", "fmt_extents_file_16384"} {
+		entry, err := fileSystem.lookupEntry(sb.Rootino, name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		off := int64(sb.InodeAbsOffset(entry.InodeNumber())) + int64(sb.InodeCoreSize())
+		f.Add(overlay.base[off : off+int64(fileSystem.DataForkSize(0))])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		recs, err := fileSystem.parseBmbtRecs(bytes.NewReader(data), uint32(len(data)/binary.Size(BmbtRec{})))
+		if err != nil {
+			return
+		}
+		for _, rec := range recs {
+			rec.Unpack()
+		}
+	})
+}
+
+func FuzzParseAttrShortform(f *testing.F) {
+	fileSystem, _ := newFuzzFS(f, "testdata/image.xfs")
+	sb := fileSystem.PrimaryAG.SuperBlock
+
+	// seed with the local attribute forks of the root and etc entries, as the
+	// selinux label of etc/os-release
+	for _, dir := range []string{".", "etc"} {
+		ino, err := fileSystem.resolveDir(dir)
+		if err != nil {
+			f.Fatal(err)
+		}
+		entries, err := fileSystem.listEntries(ino)
+		if err != nil {
+			f.Fatal(err)
+		}
+		for _, ino := range entryInodes(entries) {
+			inode, err := fileSystem.ParseInode(ino)
+			if err != nil {
+				f.Fatal(err)
+			}
+			if inode.inodeCore.Forkoff != 0 && inode.inodeCore.Aformat == XFS_DINODE_FMT_LOCAL {
+				f.Add(inode.attrFork)
+			}
+		}
+	}
+	a := &writableAttrs{xfs: fileSystem, ino: sb.Rootino, attrs: []attrSlot{
+		{name: []byte("a"), value: []byte("1")},
+		{flags: XFS_ATTR_ROOT, name: []byte("root"), value: []byte("value")},
+	}}
+	if b, ok := a.encodeShortform(); ok {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseAttrShortform(data)
+		parseAttrShortformSlots(data)
+	})
+}
+
+func FuzzParseAttrLeaf(f *testing.F) {
+	fileSystem, _ := newFuzzFS(f, "testdata/image.xfs")
+	sb := fileSystem.PrimaryAG.SuperBlock
+
+	// the image has no attribute leaves, seed with encoded ones of local and
+	// remote values
+	large := bytes.Repeat([]byte("v"), 200)
+	for _, attrs := range [][]attrSlot{
+		{{name: []byte("a"), value: []byte("1")}},
+		{
+			{name: []byte("b"), value: large},
+			{flags: XFS_ATTR_ROOT, name: []byte("c"), value: large},
+			{flags: XFS_ATTR_SECURE, name: []byte("selinux"), value: []byte("system_u:object_r:etc_t:s0\x00")},
+			{name: []byte("remote"), remote: true, valueBlk: 1, valueLen: 8192},
+		},
+	} {
+		a := &writableAttrs{xfs: fileSystem, ino: sb.Rootino, attrs: attrs}
+		b, ok := a.encodeLeaf()
+		if !ok {
+			f.Fatal("attributes do not fit in a leaf")
+		}
+		f.Add(b)
+	}
+	// the remote values are in blocks, that are not mapped
+	fork := attrFork{xfs: fileSystem, table: dataTable{}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fork.parseLeaf(0, data)
+		// leaves are read a block at a time for changes
+		b := make([]byte, sb.BlockSize)
+		copy(b, data)
+		parseAttrLeafSlots(b)
+	})
+}
+
+func entryInodes(entries []Entry) []uint64 {
+	var inos []uint64
+	for _, entry := range entries {
+		inos = append(inos, entry.InodeNumber())
+	}
+	return inos
+}
@@ -0,0 +1,198 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"golang.org/x/xerrors"
+)
+
+// sparseInodes reports whether the inode btree records are in the sparse
+// format
+func (sb SuperBlock) sparseInodes() bool {
+	return sb.FeaturesIncompat&XFS_SB_FEAT_INCOMPAT_SPINODES != 0
+}
+
+// holes returns the bits of the inodes in the sparse holes of the chunk, a
+// bit of the holemask covers 4 inodes. Holes are marked free as well.
+func (r InobtRec) holes(sparse bool) uint64 {
+	if !sparse {
+		return 0
+	}
+	holemask := r.Freecount >> 16
+	var holes uint64
+	for i := 0; i < 16; i++ {
+		if holemask&(1<<i) != 0 {
+			holes |= 0xf << (4 * i)
+		}
+	}
+	return holes
+}
+
+// freeCount returns the number of free inodes of the chunk
+func (r InobtRec) freeCount(sparse bool) uint32 {
+	if sparse {
+		return r.Freecount & 0xff
+	}
+	return r.Freecount
+}
+
+func (r *InobtRec) setFreeCount(sparse bool, n uint32) {
+	if sparse {
+		r.Freecount = r.Freecount&^0xff | n&0xff
+		return
+	}
+	r.Freecount = n
+}
+
+// inodeAlloc is an allocation of a free inode planned in the inode chunks of
+// an AG, commit writes it
+type inodeAlloc struct {
+	xfs      *FileSystem
+	agNumber uint64
+	chunks   []InobtRec
+	chunk    int
+	ino      uint64
+}
+
+// planInode finds a free inode in the allocated inode chunks of the AG
+// agNumber, or of the next AG holding one
+func (xfs *FileSystem) planInode(agNumber uint64) (*inodeAlloc, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	agCount := uint64(len(xfs.AGs))
+	for i := uint64(0); i < agCount; i++ {
+		ag := (agNumber + i) % agCount
+		if xfs.AGs[ag].Agi.Freecount == 0 {
+			continue
+		}
+		if err := xfs.inodeBtreesWritable(ag); err != nil {
+			return nil, err
+		}
+		chunks, err := xfs.inodeChunks(ag)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the inode btree of AG %d: %w", ag, err)
+		}
+		for j, chunk := range chunks {
+			free := chunk.Free &^ chunk.holes(sb.sparseInodes())
+			if free == 0 {
+				continue
+			}
+			agino := uint64(chunk.Startino) + uint64(bits.TrailingZeros64(free))
+			return &inodeAlloc{
+				xfs:      xfs,
+				agNumber: ag,
+				chunks:   chunks,
+				chunk:    j,
+				ino:      ag<<(sb.Agblklog+sb.Inopblog) | agino,
+			}, nil
+		}
+	}
+	return nil, xerrors.Errorf("no free inode in the allocated inode chunks: %w", ErrNoSpace)
+}
+
+// inodeBtreesWritable returns an error, when writeInodeChunks cannot write
+// the inode btrees of the AG agNumber
+func (xfs *FileSystem) inodeBtreesWritable(agNumber uint64) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	agi := xfs.AGs[agNumber].Agi
+	if agi.Level != 1 || (sb.FeaturesRoCompat&XFS_SB_FEAT_RO_COMPAT_FINOBT != 0 && agi.FreeLevel != 1) {
+		return newUnsupportedFeatureError(fmt.Sprintf("writing inode btrees of more than one level in AG %d", agNumber))
+	}
+	return nil
+}
+
+// commit marks the inode used in the inode btrees
+func (a *inodeAlloc) commit() error {
+	sb := a.xfs.PrimaryAG.SuperBlock
+	chunk := &a.chunks[a.chunk]
+	chunk.Free &^= 1 << (a.ino&Mask64Lo(int64(sb.Agblklog)+int64(sb.Inopblog)) - uint64(chunk.Startino))
+	chunk.setFreeCount(sb.sparseInodes(), chunk.freeCount(sb.sparseInodes())-1)
+	return a.xfs.writeInodeChunks(a.agNumber, a.chunks, -1)
+}
+
+// inodeFree is a release of an inode planned in the inode btrees, commit
+// writes it
+type inodeFree struct {
+	xfs      *FileSystem
+	agNumber uint64
+	chunks   []InobtRec
+	chunk    int
+	ino      uint64
+}
+
+// planInodeFree finds the chunk of the inode ino in the inode btree, the inode
+// must be in use. The chunk is kept when all of its inodes are free.
+func (xfs *FileSystem) planInodeFree(ino uint64) (*inodeFree, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	agNumber, _, _ := sb.InodeOffset(ino)
+	ag := uint64(agNumber)
+	if err := xfs.inodeBtreesWritable(ag); err != nil {
+		return nil, err
+	}
+	chunks, err := xfs.inodeChunks(ag)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the inode btree of AG %d: %w", ag, err)
+	}
+	agino := ino & Mask64Lo(int64(sb.Agblklog)+int64(sb.Inopblog))
+	for i, chunk := range chunks {
+		if agino < uint64(chunk.Startino) || agino >= uint64(chunk.Startino)+64 {
+			continue
+		}
+		bit := uint64(1) << (agino - uint64(chunk.Startino))
+		if (chunk.Free|chunk.holes(sb.sparseInodes()))&bit != 0 {
+			return nil, xfs.wrapInodeError(ino, newCorruptedError(xfs.inoBtree(ag).name, -1, "inode in use is free"))
+		}
+		return &inodeFree{xfs: xfs, agNumber: ag, chunks: chunks, chunk: i, ino: ino}, nil
+	}
+	return nil, xfs.wrapInodeError(ino, newCorruptedError(xfs.inoBtree(ag).name, -1, "inode in use is not allocated"))
+}
+
+// commit marks the inode free in the inode btrees
+func (f *inodeFree) commit() error {
+	sb := f.xfs.PrimaryAG.SuperBlock
+	chunk := &f.chunks[f.chunk]
+	chunk.Free |= 1 << (f.ino&Mask64Lo(int64(sb.Agblklog)+int64(sb.Inopblog)) - uint64(chunk.Startino))
+	chunk.setFreeCount(sb.sparseInodes(), chunk.freeCount(sb.sparseInodes())+1)
+	return f.xfs.writeInodeChunks(f.agNumber, f.chunks, 1)
+}
+
+// writeInodeChunks writes the inode chunks of the AG agNumber to the inode
+// btree, and the chunks with free inodes to the free inode btree, and adds
+// delta to the free inode counters
+func (xfs *FileSystem) writeInodeChunks(agNumber uint64, chunks []InobtRec, delta int64) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	encode := func(chunks []InobtRec) []byte {
+		recs := make([]byte, 16*len(chunks))
+		for i, c := range chunks {
+			binary.BigEndian.PutUint32(recs[16*i:], c.Startino)
+			binary.BigEndian.PutUint32(recs[16*i+4:], c.Freecount)
+			binary.BigEndian.PutUint64(recs[16*i+8:], c.Free)
+		}
+		return recs
+	}
+	bt := xfs.inoBtree(agNumber)
+	if err := xfs.writeShortBtreeRoot(bt, encode(chunks)); err != nil {
+		return xerrors.Errorf("failed to write the %s of AG %d: %w", bt.name, agNumber, err)
+	}
+	if sb.FeaturesRoCompat&XFS_SB_FEAT_RO_COMPAT_FINOBT != 0 {
+		var free []InobtRec
+		for _, c := range chunks {
+			if c.freeCount(sb.sparseInodes()) > 0 {
+				free = append(free, c)
+			}
+		}
+		bt := xfs.finoBtree(agNumber)
+		if err := xfs.writeShortBtreeRoot(bt, encode(free)); err != nil {
+			return xerrors.Errorf("failed to write the %s of AG %d: %w", bt.name, agNumber, err)
+		}
+	}
+
+	agi := &xfs.AGs[agNumber].Agi
+	agi.Freecount = uint32(int64(agi.Freecount) + delta)
+	if err := xfs.writeAGI(agNumber); err != nil {
+		return err
+	}
+	xfs.PrimaryAG.SuperBlock.Ifree = uint64(int64(xfs.PrimaryAG.SuperBlock.Ifree) + delta)
+	return xfs.writeSuperBlock()
+}
@@ -0,0 +1,45 @@
+package xfs
+
+import (
+	"bytes"
+)
+
+// Info is a summary of the superblock geometry and the state found at mount
+type Info struct {
+	UUID       [16]byte
+	Label      string
+	Version    int
+	BlockSize  uint32
+	SectorSize uint16
+	InodeSize  uint16
+	AGCount    uint32
+	AGBlocks   uint32
+	Blocks     uint64
+	FreeBlocks uint64
+	Inodes     uint64
+	FreeInodes uint64
+
+	// Log is the state of the internal log, LogDirty means the file system
+	// was not cleanly unmounted and metadata may be stale
+	Log LogState
+}
+
+// Info returns the summary of the file system
+func (xfs *FileSystem) Info() Info {
+	sb := xfs.PrimaryAG.SuperBlock
+	return Info{
+		UUID:       sb.UUID,
+		Label:      string(bytes.TrimRight(sb.Fname[:], "\x00")),
+		Version:    int(sb.Versionnum & XFS_SB_VERSION_NUMBITS),
+		BlockSize:  sb.BlockSize,
+		SectorSize: sb.Sectsize,
+		InodeSize:  sb.Inodesize,
+		AGCount:    sb.Agcount,
+		AGBlocks:   sb.Agblocks,
+		Blocks:     sb.Dblocks,
+		FreeBlocks: sb.Fdblocks,
+		Inodes:     sb.Icount,
+		FreeInodes: sb.Ifree,
+		Log:        xfs.logState,
+	}
+}
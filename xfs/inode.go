@@ -4,19 +4,20 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"io/fs"
+	"math"
+	"time"
 	"unsafe"
 
 	"golang.org/x/xerrors"
-
-	"github.com/masahiro331/go-xfs-filesystem/log"
-	"github.com/masahiro331/go-xfs-filesystem/xfs/utils"
 )
 
 var (
 	InodeSupportVersion = 3
 
-	UnsupportedDir2BlockHeaderErr = xerrors.New("unsupported block")
+	UnsupportedDir2BlockHeaderErr = newUnsupportedFeatureError("dir2 block header")
 
 	XFS_DIR2_SPACE_SIZE  = int64(1) << (32 + XFS_DIR2_DATA_ALIGN_LOG)
 	XFS_DIR2_DATA_OFFSET = XFS_DIR2_DATA_SPACE * XFS_DIR2_SPACE_SIZE
@@ -28,6 +29,7 @@ var (
 )
 
 type Inode struct {
+	ino       uint64
 	inodeCore InodeCore
 	// Device
 	device *Device
@@ -42,6 +44,9 @@ type Inode struct {
 
 	// S_IFLNK
 	symlinkString *SymlinkString
+
+	// attrFork is the raw attribute fork, nil without one
+	attrFork []byte
 }
 
 type RegularExtent struct {
@@ -112,7 +117,7 @@ type BmbtPtr uint64
 type Dir2SfHdr struct {
 	Count   uint8
 	I8Count uint8
-	Parent  uint32
+	Parent  uint64
 }
 
 type Dir2Block struct {
@@ -248,9 +253,11 @@ func (xfs *FileSystem) inodeFormatDevice(inode Inode) Inode {
 func (xfs *FileSystem) inodeFormatLocal(r io.Reader, inode Inode) (Inode, error) {
 	if inode.inodeCore.IsDir() {
 		inode.directoryLocal = &DirectoryLocal{}
-		if err := binary.Read(r, binary.BigEndian, &inode.directoryLocal.dir2SfHdr); err != nil {
+		hdr, err := parseDir2SfHdr(r)
+		if err != nil {
 			return Inode{}, xerrors.Errorf("failed to read XFS_DINODE_FMT_LOCAL directory error: %w", err)
 		}
+		inode.directoryLocal.dir2SfHdr = *hdr
 
 		var isI8count bool
 		if inode.directoryLocal.dir2SfHdr.I8Count != 0 {
@@ -265,8 +272,11 @@ func (xfs *FileSystem) inodeFormatLocal(r io.Reader, inode Inode) (Inode, error)
 		}
 	} else if inode.inodeCore.IsSymlink() {
 		inode.symlinkString = &SymlinkString{}
+		if inode.inodeCore.Size > XFS_SYMLINK_MAXLEN || int(inode.inodeCore.Size) > xfs.DataForkSize(inode.inodeCore.Forkoff) {
+			return Inode{}, newCorruptedError("symlink", -1, "XFS_DINODE_FMT_LOCAL size %d exceeds data fork", inode.inodeCore.Size)
+		}
 		buf := make([]byte, inode.inodeCore.Size)
-		n, err := r.Read(buf)
+		n, err := io.ReadFull(r, buf)
 		if err != nil {
 			return Inode{}, xerrors.Errorf("failed to read XFS_DINODE_FMT_LOCAL symlink error: %w", err)
 		}
@@ -275,7 +285,7 @@ func (xfs *FileSystem) inodeFormatLocal(r io.Reader, inode Inode) (Inode, error)
 		}
 		inode.symlinkString.Name = string(buf)
 	} else {
-		log.Logger.Warn("not support XFS_DINODE_FMT_LOCAL")
+		xfs.Logger().Warn("unsupported local format inode", xfs.inodeFields(inode.ino, "mode", inode.inodeCore.Mode)...)
 	}
 	return inode, nil
 }
@@ -287,13 +297,51 @@ func (xfs *FileSystem) parseBmbtRecs(r io.Reader, count uint32) ([]BmbtRec, erro
 		if err := binary.Read(r, binary.BigEndian, &bmbtRec); err != nil {
 			return nil, xerrors.Errorf("read xfs_bmbt_irec error: %w", err)
 		}
+		p := bmbtRec.Unpack()
+		if err := xfs.validateExtent(p.StartBlock, p.BlockCount); err != nil {
+			return nil, xerrors.Errorf("invalid xfs_bmbt_irec[%d]: %w", i, err)
+		}
 		bmbtRecs = append(bmbtRecs, bmbtRec)
 	}
 	return bmbtRecs, nil
 }
 
+// validateExtent checks that blockCount blocks from the file system block
+// startBlock lie within one allocation group, sb_dblocks and the image.
+func (xfs *FileSystem) validateExtent(startBlock, blockCount uint64) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	if blockCount == 0 {
+		return newCorruptedError("extent", -1, "startblock %d: zero block count", startBlock)
+	}
+
+	agNumber := sb.BlockToAgNumber(startBlock)
+	if agNumber >= uint64(sb.Agcount) {
+		return newCorruptedError("extent", -1, "startblock %d, blockcount %d: AG %d out of range, agcount %d",
+			startBlock, blockCount, agNumber, sb.Agcount)
+	}
+	if agBlocks := sb.AGBlocks(agNumber); sb.BlockToAgBlockNumber(startBlock)+blockCount > agBlocks {
+		return newCorruptedError("extent", -1, "startblock %d, blockcount %d: exceeds AG size %d of AG %d",
+			startBlock, blockCount, agBlocks, agNumber)
+	}
+
+	end := uint64(sb.BlockToPhysicalOffset(startBlock)) + blockCount
+	if end > sb.Dblocks {
+		return newCorruptedError("extent", -1, "startblock %d, blockcount %d: exceeds data blocks %d",
+			startBlock, blockCount, sb.Dblocks)
+	}
+	if int64(end)*int64(sb.BlockSize) > xfs.r.Size() {
+		return newCorruptedError("extent", -1, "startblock %d, blockcount %d: exceeds image size %d",
+			startBlock, blockCount, xfs.r.Size())
+	}
+	return nil
+}
+
 func (xfs *FileSystem) inodeFormatExtents(r io.Reader, inode Inode) (Inode, error) {
 	var err error
+	// each extent record is 16 bytes in the data fork
+	if maxExtents := xfs.DataForkSize(inode.inodeCore.Forkoff) / 16; int64(inode.inodeCore.Nextents) > int64(maxExtents) {
+		return Inode{}, newCorruptedError("inode", -1, "nextents %d exceeds data fork capacity %d", inode.inodeCore.Nextents, maxExtents)
+	}
 	if inode.inodeCore.IsDir() {
 		inode.directoryExtents = &DirectoryExtents{}
 		inode.directoryExtents.bmbtRecs, err = xfs.parseBmbtRecs(r, inode.inodeCore.Nextents)
@@ -307,16 +355,27 @@ func (xfs *FileSystem) inodeFormatExtents(r io.Reader, inode Inode) (Inode, erro
 			return Inode{}, xerrors.Errorf("failed to parse regular bmbt recs: %w", err)
 		}
 	} else if inode.inodeCore.IsSymlink() {
-		log.Logger.Warn("not support XFS_DINODE_FMT_EXTENTS isSymlink")
+		xfs.Logger().Warn("unsupported extents format symlink", xfs.inodeFields(inode.ino)...)
 	} else {
-		log.Logger.Debugf("%+v\n", inode)
-		log.Logger.Debug("not support XFS_DINODE_FMT_EXTENTS")
+		xfs.Logger().Debug("unsupported extents format inode", xfs.inodeFields(inode.ino, "inode", fmt.Sprintf("%+v", inode))...)
 	}
 
 	return inode, nil
 }
 
-func (xfs *FileSystem) walkBtree(level uint16, keys []BmbtKey, ptrs []BmbtPtr, inode Inode) (uint16, []BmbtKey, []BmbtPtr, error) {
+// btreeVisited records the blocks of a btree traversal, a block referenced
+// twice means corrupt child pointers that would loop or duplicate records.
+type btreeVisited map[uint64]struct{}
+
+func (v btreeVisited) visit(block uint64) error {
+	if _, ok := v[block]; ok {
+		return newCorruptedError("btree", -1, "block %d is referenced twice", block)
+	}
+	v[block] = struct{}{}
+	return nil
+}
+
+func (xfs *FileSystem) walkBtree(level uint16, keys []BmbtKey, ptrs []BmbtPtr, inode Inode, visited btreeVisited) (uint16, []BmbtKey, []BmbtPtr, error) {
 	if level == 1 {
 		return level, keys, ptrs, nil
 	}
@@ -324,31 +383,37 @@ func (xfs *FileSystem) walkBtree(level uint16, keys []BmbtKey, ptrs []BmbtPtr, i
 	var retKeys []BmbtKey
 	var retPtrs []BmbtPtr
 	for _, ptr := range ptrs {
-		nodeKeys, nodePtrs, err := xfs.parseBtreeNode(int64(ptr), inode)
+		if err := visited.visit(uint64(ptr)); err != nil {
+			return 0, nil, nil, err
+		}
+		nodeKeys, nodePtrs, err := xfs.parseBtreeNode(int64(ptr), level-1)
 		if err != nil {
-			return 0, nil, nil, xerrors.Errorf("parse btree node (inode: %v, ptr: %d) error: %w", inode, ptr, err)
+			return 0, nil, nil, xerrors.Errorf("parse btree node error: %w", xfs.wrapBlockError(uint64(ptr), err))
 		}
 		retKeys = append(retKeys, nodeKeys...)
 		retPtrs = append(retPtrs, nodePtrs...)
 	}
 	level--
-	return xfs.walkBtree(level, retKeys, retPtrs, inode)
+	return xfs.walkBtree(level, retKeys, retPtrs, inode, visited)
 }
 
-func (xfs *FileSystem) parseMultiLevelBtree(level uint16, keys []BmbtKey, ptrs []BmbtPtr, inode Inode) ([]BmbtRec, error) {
-	_, leafKeys, leafPtrs, err := xfs.walkBtree(level, keys, ptrs, inode)
+func (xfs *FileSystem) parseMultiLevelBtree(level uint16, keys []BmbtKey, ptrs []BmbtPtr, inode Inode, visited btreeVisited) ([]BmbtRec, error) {
+	_, leafKeys, leafPtrs, err := xfs.walkBtree(level, keys, ptrs, inode, visited)
 	if err != nil {
 		return nil, xerrors.Errorf("walk Btree error: %w", err)
 	}
-	return xfs.parseSingleLevelBtree(leafKeys, leafPtrs)
+	return xfs.parseSingleLevelBtree(leafKeys, leafPtrs, visited)
 }
 
-func (xfs *FileSystem) parseSingleLevelBtree(keys []BmbtKey, ptrs []BmbtPtr) ([]BmbtRec, error) {
+func (xfs *FileSystem) parseSingleLevelBtree(keys []BmbtKey, ptrs []BmbtPtr, visited btreeVisited) ([]BmbtRec, error) {
 	var ret []BmbtRec
 	for _, ptr := range ptrs {
+		if err := visited.visit(uint64(ptr)); err != nil {
+			return nil, err
+		}
 		recs, err := xfs.parseBtreeLeafNode(int64(ptr))
 		if err != nil {
-			return nil, xerrors.Errorf("parse btree leaf node(ptr: %d) error: %w", ptr, err)
+			return nil, xerrors.Errorf("parse btree leaf node error: %w", xfs.wrapBlockError(uint64(ptr), err))
 		}
 
 		ret = append(ret, recs...)
@@ -356,7 +421,13 @@ func (xfs *FileSystem) parseSingleLevelBtree(keys []BmbtKey, ptrs []BmbtPtr) ([]
 	return ret, nil
 }
 
-func (xfs *FileSystem) parseBmbtKeyPtr(r io.Reader, inode Inode, numrecs uint16) ([]BmbtKey, []BmbtPtr, error) {
+// parseBmbtKeyPtr parses the keys and pointers arrays of a bmbt node,
+// both arrays are sized by maxrecs and only the first numrecs items are used.
+func (xfs *FileSystem) parseBmbtKeyPtr(r io.Reader, numrecs uint16, maxrecs int) ([]BmbtKey, []BmbtPtr, error) {
+	if maxrecs <= 0 || int(numrecs) > maxrecs {
+		return nil, nil, newCorruptedError("bmbt node", -1, "numrecs %d exceeds maxrecs %d", numrecs, maxrecs)
+	}
+
 	// parse bmbt keys
 	var keys []BmbtKey
 	for i := uint16(0); i < numrecs; i++ {
@@ -367,26 +438,9 @@ func (xfs *FileSystem) parseBmbtKeyPtr(r io.Reader, inode Inode, numrecs uint16)
 		keys = append(keys, key)
 	}
 
-	// Aformat is type of attribute fork
-	// 1: local
-	// 2: extents
-	// 3. btree
-	if inode.inodeCore.Aformat != 1 && inode.inodeCore.Forkoff != 0 {
-		return nil, nil, xerrors.Errorf("unsupported attribute fork error")
-	}
-
-	// read memory align
-	// TODO: check please this calculation
-	var tailKeysCount int
-	if inode.inodeCore.Forkoff == 0 {
-		// 20 is default value of key/pointer length
-		tailKeysCount = 20 - int(numrecs)
-	} else {
-		// (Forkoff - padding / 2(key/pointers)) - numrecs
-		tailKeysCount = int((inode.inodeCore.Forkoff-1)/2) - int(numrecs)
-	}
-	tailBuf := make([]byte, 8*tailKeysCount)
-	n, err := r.Read(tailBuf)
+	// skip unused keys
+	tailBuf := make([]byte, 8*(maxrecs-int(numrecs)))
+	n, err := io.ReadFull(r, tailBuf)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("failed to read tail key buf: %w", err)
 	}
@@ -416,7 +470,13 @@ func (xfs *FileSystem) parseBmbrBlock(r io.Reader, inode Inode) (*BmbrBlock, err
 		return nil, xerrors.Errorf("binary read bmbr block numerecs error: %w", err)
 	}
 
-	bmbrBlock.keys, bmbrBlock.ptrs, err = xfs.parseBmbtKeyPtr(r, inode, bmbrBlock.Numrecs)
+	if bmbrBlock.Level == 0 || bmbrBlock.Level > XFS_BTREE_MAXLEVELS {
+		return nil, newCorruptedError("bmbr block", -1, "invalid root level %d", bmbrBlock.Level)
+	}
+
+	// bmdr block header is level and numrecs, 2 bytes each
+	maxrecs := (xfs.DataForkSize(inode.inodeCore.Forkoff) - 4) / 16
+	bmbrBlock.keys, bmbrBlock.ptrs, err = xfs.parseBmbtKeyPtr(r, bmbrBlock.Numrecs, maxrecs)
 	if err != nil {
 		return nil, xerrors.Errorf("parse bmbr key-ptr error: %w", err)
 	}
@@ -425,8 +485,8 @@ func (xfs *FileSystem) parseBmbrBlock(r io.Reader, inode Inode) (*BmbrBlock, err
 
 func (xfs *FileSystem) inodeFormatBtree(r io.Reader, inode Inode) (Inode, error) {
 	if !inode.inodeCore.IsRegular() {
-		log.Logger.Warnf("not support XFS_DINODE_FMT_BTREE type: %+v", inode)
-		return Inode{}, xerrors.Errorf("invalid inode")
+		xfs.Logger().Warn("unsupported btree format inode", xfs.inodeFields(inode.ino, "inode", fmt.Sprintf("%+v", inode))...)
+		return Inode{}, newUnsupportedFeatureError("XFS_DINODE_FMT_BTREE non regular file")
 	}
 
 	bmbrBlock, err := xfs.parseBmbrBlock(r, inode)
@@ -436,10 +496,12 @@ func (xfs *FileSystem) inodeFormatBtree(r io.Reader, inode Inode) (Inode, error)
 	btree := &RegularBtree{
 		bmbrBlock: *bmbrBlock,
 	}
+	visited := btreeVisited{}
 	if bmbrBlock.Level == 1 {
 		btree.bmbtRecs, err = xfs.parseSingleLevelBtree(
 			bmbrBlock.keys,
 			bmbrBlock.ptrs,
+			visited,
 		)
 		if err != nil {
 			return Inode{}, xerrors.Errorf("parse single level btree error: %w", err)
@@ -450,6 +512,7 @@ func (xfs *FileSystem) inodeFormatBtree(r io.Reader, inode Inode) (Inode, error)
 			bmbrBlock.keys,
 			bmbrBlock.ptrs,
 			inode,
+			visited,
 		)
 		if err != nil {
 			return Inode{}, xerrors.Errorf("parse multi level btree error: %w", err)
@@ -459,7 +522,27 @@ func (xfs *FileSystem) inodeFormatBtree(r io.Reader, inode Inode) (Inode, error)
 	return inode, nil
 }
 
+// ParseInode parses the inode ino, errors carry the inode number, AG number and
+// byte offset of the inode.
 func (xfs *FileSystem) ParseInode(ino uint64) (*Inode, error) {
+	inode, err := xfs.parseInode(ino)
+	if err != nil {
+		return nil, xfs.wrapInodeError(ino, err)
+	}
+	return inode, nil
+}
+
+func (xfs *FileSystem) parseInode(ino uint64) (*Inode, error) {
+	if pinned, ok := xfs.pinnedInodes[ino]; ok {
+		i := *pinned
+		return &i, nil
+	}
+	cached, ok := xfs.inodeCache.Get(ino)
+	xfs.cacheLookup("inode", xfs.inodeCache != nil, ok)
+	if ok {
+		return &cached, nil
+	}
+
 	var inode Inode
 	c, ok := xfs.cache.Get(inodeCacheKey(ino))
 	if ok {
@@ -469,64 +552,81 @@ func (xfs *FileSystem) ParseInode(ino uint64) (*Inode, error) {
 		}
 	}
 
-	_, err := xfs.seekInode(ino)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to seek inode: %w", err)
+	sb := xfs.PrimaryAG.SuperBlock
+	if err := sb.verifyInodeNumber(ino); err != nil {
+		return nil, err
 	}
-
-	buf, err := utils.ReadSector(xfs.r)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to read sector: %w", err)
+	buf, ok := xfs.persistent.inode(ino)
+	if !ok {
+		buf = make([]byte, sb.Inodesize)
+		if _, err := xfs.r.ReadAt(buf, int64(sb.InodeAbsOffset(ino))); err != nil {
+			return nil, xerrors.Errorf("failed to read inode: %w", err)
+		}
+		xfs.persistent.addInode(ino, buf)
 	}
-	r := bytes.NewReader(buf)
 
-	if err := binary.Read(r, binary.BigEndian, &inode.inodeCore); err != nil {
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &inode.inodeCore); err != nil {
 		return nil, xerrors.Errorf("failed to read InodeCore: %w", err)
 	}
+	inode.ino = ino
 
 	if inode.inodeCore.Magic != XFS_DINODE_MAGIC {
-		return nil, xerrors.Errorf("invalid magic byte error")
+		return nil, newCorruptedError("inode", -1, "invalid magic byte error")
 	}
 
 	if !inode.inodeCore.isSupported() {
-		return nil, xerrors.Errorf("not support inode version %d", inode.inodeCore.Version)
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("inode version %d", inode.inodeCore.Version))
+	}
+	// v3 inodes are only found on v5 file systems, v1 and v2 inodes on older ones
+	if (inode.inodeCore.Version == 3) != sb.IsV5() {
+		return nil, newCorruptedError("inode", -1, "inode version %d on file system version %d",
+			inode.inodeCore.Version, sb.Versionnum&XFS_SB_VERSION_NUMBITS)
+	}
+	if inode.inodeCore.Version < 3 {
+		inode.inodeCore.clearV3Fields()
+	} else if inode.inodeCore.Ino != ino {
+		return nil, newCorruptedError("inode", -1, "inode number %d in inode core", inode.inodeCore.Ino)
+	}
+	if forkoff := inode.inodeCore.Forkoff; forkoff != 0 {
+		if sb.InodeCoreSize()+int(forkoff)*8 >= len(buf) {
+			return nil, newCorruptedError("inode", -1, "forkoff %d exceeds inode size %d", forkoff, len(buf))
+		}
+		inode.attrFork = buf[sb.InodeCoreSize()+int(forkoff)*8:]
 	}
+	r := bytes.NewReader(buf[sb.InodeCoreSize():])
 
+	var err error
 	switch inode.inodeCore.Format {
 	case XFS_DINODE_FMT_DEV:
 		inode = xfs.inodeFormatDevice(inode)
 	case XFS_DINODE_FMT_LOCAL:
 		inode, err = xfs.inodeFormatLocal(r, inode)
 		if err != nil {
-			log.Logger.Debug("\n", hex.Dump(buf))
+			xfs.Logger().Debug("failed to parse inode", xfs.inodeFields(ino, "error", err, "dump", hex.Dump(buf))...)
 			return nil, xerrors.Errorf("parse inode format local: %w", err)
 		}
 	case XFS_DINODE_FMT_EXTENTS:
 		inode, err = xfs.inodeFormatExtents(r, inode)
 		if err != nil {
-			log.Logger.Debug("\n", hex.Dump(buf))
+			xfs.Logger().Debug("failed to parse inode", xfs.inodeFields(ino, "error", err, "dump", hex.Dump(buf))...)
 			return nil, xerrors.Errorf("parse inode format extents: %w", err)
 		}
 	case XFS_DINODE_FMT_BTREE:
 		inode, err = xfs.inodeFormatBtree(r, inode)
 		if err != nil {
-			log.Logger.Debug("\n", hex.Dump(buf))
+			xfs.Logger().Debug("failed to parse inode", xfs.inodeFields(ino, "error", err, "dump", hex.Dump(buf))...)
 			return nil, xerrors.Errorf("parse inode format btree: %w", err)
 		}
 	case XFS_DINODE_FMT_UUID:
-		log.Logger.Warn("not support XFS_DINODE_FMT_UUID")
+		xfs.Logger().Warn("unsupported uuid format inode", xfs.inodeFields(ino)...)
 	case XFS_DINODE_FMT_RMAP:
-		log.Logger.Warn("not support XFS_DINODE_FMT_RMAP")
+		xfs.Logger().Warn("unsupported rmap format inode", xfs.inodeFields(ino)...)
 	default:
-		log.Logger.Warnf("not support inode format(%d)", inode.inodeCore.Format)
+		xfs.Logger().Warn("unsupported inode format", xfs.inodeFields(ino, "format", inode.inodeCore.Format)...)
 	}
 
-	// TODO: support extend attribute fork , see. Chapter 19 Extended Attributes
-	// if inode.inodeCore.Forkoff != 0 {
-	// 	panic("has extend attribute fork")
-	// }
-
 	xfs.cache.Add(inodeCacheKey(ino), inode)
+	xfs.inodeCache.Add(ino, inode)
 	return &inode, nil
 }
 
@@ -536,18 +636,21 @@ func (xfs *FileSystem) parseBtreeBlock(r io.Reader) (*BtreeBlock, error) {
 		return nil, xerrors.Errorf("failed to read b+tree block: %w", err)
 	}
 	if btreeBlock.Magic != XFS_BMAP_CRC_MAGIC {
-		return nil, xerrors.Errorf("unsupported block header: (%d), expected BMAP_CRC_MAGIC", btreeBlock.Magic)
+		if btreeBlock.Magic == XFS_BMAP_MAGICa {
+			return nil, newUnsupportedFeatureError("BMAP btree block without CRC")
+		}
+		return nil, newCorruptedError("btree block", -1, "magic byte error: %08x, expected BMAP_CRC_MAGIC", btreeBlock.Magic)
 	}
 	return btreeBlock, nil
 }
 
-func (xfs *FileSystem) parseBtreeNode(blockNumber int64, inode Inode) ([]BmbtKey, []BmbtPtr, error) {
-	physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(uint64(blockNumber))
-	_, err := xfs.seekBlock(physicalBlockOffset)
-	if err != nil {
-		return nil, nil, xerrors.Errorf("failed to seek block: %w", err)
+// parseBtreeNode parses the node block, that is expected at the given level of the btree.
+func (xfs *FileSystem) parseBtreeNode(blockNumber int64, level uint16) ([]BmbtKey, []BmbtPtr, error) {
+	if err := xfs.validateExtent(uint64(blockNumber), 1); err != nil {
+		return nil, nil, xerrors.Errorf("invalid btree node pointer: %w", err)
 	}
-	b, err := xfs.readBlock(1)
+	physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(uint64(blockNumber))
+	b, err := xfs.readMetadataBlock(physicalBlockOffset)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("failed to read block: %w", err)
 	}
@@ -555,10 +658,13 @@ func (xfs *FileSystem) parseBtreeNode(blockNumber int64, inode Inode) ([]BmbtKey
 	r := bytes.NewReader(b)
 	btreeBlock, err := xfs.parseBtreeBlock(r)
 	if err != nil {
-		return nil, nil, xerrors.Errorf("parse btree node (offset: %d) error: %w", blockNumber, err)
+		return nil, nil, xerrors.Errorf("parse btree node error: %w", err)
+	}
+	if btreeBlock.Level != level {
+		return nil, nil, newCorruptedError("btree node", -1, "unexpected level %d, expected %d", btreeBlock.Level, level)
 	}
 
-	keys, ptrs, err := xfs.parseBmbtKeyPtr(r, inode, btreeBlock.Numrecs)
+	keys, ptrs, err := xfs.parseBmbtKeyPtr(r, btreeBlock.Numrecs, xfs.btreeBlockMaxRecs())
 	if err != nil {
 		return nil, nil, xerrors.Errorf("parse bmbr key-ptr error: %w", err)
 	}
@@ -566,12 +672,11 @@ func (xfs *FileSystem) parseBtreeNode(blockNumber int64, inode Inode) ([]BmbtKey
 }
 
 func (xfs *FileSystem) parseBtreeLeafNode(blockNumber int64) ([]BmbtRec, error) {
-	physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(uint64(blockNumber))
-	_, err := xfs.seekBlock(physicalBlockOffset)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to seek block: %w", err)
+	if err := xfs.validateExtent(uint64(blockNumber), 1); err != nil {
+		return nil, xerrors.Errorf("invalid btree leaf pointer: %w", err)
 	}
-	b, err := xfs.readBlock(1)
+	physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(uint64(blockNumber))
+	b, err := xfs.readMetadataBlock(physicalBlockOffset)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to read block: %w", err)
 	}
@@ -579,11 +684,14 @@ func (xfs *FileSystem) parseBtreeLeafNode(blockNumber int64) ([]BmbtRec, error)
 	r := bytes.NewReader(b)
 	btreeBlock, err := xfs.parseBtreeBlock(r)
 	if err != nil {
-		return nil, xerrors.Errorf("parse btree node (offset: %d) error: %w", blockNumber*int64(xfs.PrimaryAG.SuperBlock.BlockSize), err)
+		return nil, xerrors.Errorf("parse btree node error: %w", err)
 	}
 
-	if btreeBlock.Level > 1 {
-		return nil, xerrors.Errorf("unsupported deep b+tree level: %d", btreeBlock.Level)
+	if btreeBlock.Level != 0 {
+		return nil, newCorruptedError("btree leaf", -1, "unexpected level %d", btreeBlock.Level)
+	}
+	if int(btreeBlock.Numrecs) > xfs.btreeBlockMaxRecs() {
+		return nil, newCorruptedError("btree leaf", -1, "numrecs %d exceeds maxrecs %d", btreeBlock.Numrecs, xfs.btreeBlockMaxRecs())
 	}
 
 	recs := []BmbtRec{}
@@ -592,6 +700,10 @@ func (xfs *FileSystem) parseBtreeLeafNode(blockNumber int64) ([]BmbtRec, error)
 		if err := binary.Read(r, binary.BigEndian, &bmbtRec); err != nil {
 			return nil, xerrors.Errorf("failed to read extents xfs_bmbt_irec: %w", err)
 		}
+		p := bmbtRec.Unpack()
+		if err := xfs.validateExtent(p.StartBlock, p.BlockCount); err != nil {
+			return nil, xerrors.Errorf("invalid xfs_bmbt_irec[%d]: %w", i, err)
+		}
 		recs = append(recs, bmbtRec)
 	}
 	return recs, nil
@@ -602,15 +714,24 @@ func BmbrMaxRecs(blocklen int) int {
 	return blocklen / 16
 }
 
+// btreeBlockMaxRecs returns the number of records, or key and pointer pairs,
+// that fit in a long format btree block.
+func (xfs *FileSystem) btreeBlockMaxRecs() int {
+	return (int(xfs.PrimaryAG.SuperBlock.BlockSize) - binary.Size(BtreeBlock{})) / 16
+}
+
 // https://github.com/torvalds/linux/blob/d2b6f8a179194de0ffc4886ffc2c4358d86047b8/fs/xfs/libxfs/xfs_format.h#L1077-L1078
 func (xfs *FileSystem) DataForkSize(forkoff uint8) int {
 	if forkoff > 0 {
 		return int(forkoff) << 3
 	}
-	return int(xfs.PrimaryAG.SuperBlock.Inodesize) - 176 // v3 InodeCore size
+	return int(xfs.PrimaryAG.SuperBlock.Inodesize) - xfs.PrimaryAG.SuperBlock.InodeCoreSize()
 }
 
 func (i *Inode) AttributeOffset() uint32 {
+	if i.inodeCore.Version < 3 {
+		return uint32(i.inodeCore.Forkoff)*8 + INODEV2_SIZE
+	}
 	return uint32(i.inodeCore.Forkoff)*8 + INODEV3_SIZE
 }
 
@@ -621,11 +742,18 @@ func (xfs *FileSystem) parseXDB3Block(r io.Reader) ([]Dir2DataEntry, error) {
 		return nil, xerrors.Errorf("failed to read XDB3 block reader: %w", err)
 	}
 	var tail Dir2BlockTail
+	if len(buf) < int(unsafe.Sizeof(tail)) {
+		return nil, newCorruptedError("XDB3 block", -1, "block length %d", len(buf))
+	}
 	tailReader := bytes.NewReader(buf[len(buf)-int(unsafe.Sizeof(tail)):])
 	if err := binary.Read(tailReader, binary.BigEndian, &tail); err != nil {
 		return nil, xerrors.Errorf("failed to read tail binary: %w", err)
 	}
-	reader := bytes.NewReader(buf[:uint32(len(buf))-((tail.Count*LEAF_ENTRY_SIZE)+uint32(unsafe.Sizeof(tail)))])
+	tailSize := uint64(tail.Count)*LEAF_ENTRY_SIZE + uint64(unsafe.Sizeof(tail))
+	if tailSize > uint64(len(buf)) {
+		return nil, newCorruptedError("XDB3 block", -1, "leaf count %d exceeds block", tail.Count)
+	}
+	reader := bytes.NewReader(buf[:uint64(len(buf))-tailSize])
 
 	dir2DataEntries, err := xfs.parseDir2DataEntry(reader)
 	if err != nil {
@@ -650,7 +778,7 @@ func (xfs *FileSystem) parseDir2DataEntry(r io.Reader) ([]Dir2DataEntry, error)
 
 		// Parse Inode number
 		ino := make([]byte, unsafe.Sizeof(entry.Inumber))
-		_, err := r.Read(ino)
+		_, err := io.ReadFull(r, ino)
 		if err != nil {
 			if err == io.EOF {
 				return entries, nil
@@ -661,12 +789,19 @@ func (xfs *FileSystem) parseDir2DataEntry(r io.Reader) ([]Dir2DataEntry, error)
 			return nil, xerrors.Errorf("failed to read inumber binary: %w", err)
 		}
 
-		// Skip FreeTag
+		// Skip unused space, xfs_dir2_data_unused is freetag, length, padding and tag.
+		// The whole region is skipped by its length, its padding is not zeroed
+		// when an entry is removed and may still hold the old entry.
 		if (entry.Inumber >> 48) == XFS_DIR2_DATA_FREE_TAG {
 			freeLen := (entry.Inumber >> 32) & Mask64Lo(16)
+			if freeLen < 8 || freeLen%8 != 0 {
+				return nil, newCorruptedError("unused entry", -1, "length %d", freeLen)
+			}
 			if freeLen != 8 {
-				// Read FreeTag tail
-				_, err := r.Read(make([]byte, freeLen-0x08))
+				_, err := io.ReadFull(r, make([]byte, freeLen-0x08))
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return nil, newCorruptedError("unused entry", -1, "length %d exceeds directory block", freeLen)
+				}
 				if err != nil {
 					return nil, xerrors.Errorf("failed to read unused padding: %w", err)
 				}
@@ -678,16 +813,22 @@ func (xfs *FileSystem) parseDir2DataEntry(r io.Reader) ([]Dir2DataEntry, error)
 		if err := binary.Read(r, binary.BigEndian, &entry.Namelen); err != nil {
 			return nil, xerrors.Errorf("failed to read name length: %w", err)
 		}
+		if entry.Namelen == 0 {
+			return nil, newCorruptedError("data entry", -1, "zero name length")
+		}
 
 		// Parse Name
 		nameBuf := make([]byte, entry.Namelen)
-		n, err := r.Read(nameBuf)
+		n, err := io.ReadFull(r, nameBuf)
 		if err != nil {
 			return nil, xerrors.Errorf("failed to read name: %w", err)
 		}
 		if n != int(entry.Namelen) {
 			return nil, xerrors.Errorf("failed to read name: expected namelen(%d) actual(%d)", entry.Namelen, n)
 		}
+		if err := checkEntryName("data entry", nameBuf); err != nil {
+			return nil, err
+		}
 		entry.EntryName = string(nameBuf)
 
 		// Parse FileType
@@ -701,7 +842,7 @@ func (xfs *FileSystem) parseDir2DataEntry(r io.Reader) ([]Dir2DataEntry, error)
 			int(unsafe.Sizeof(entry.Filetype)) +
 			int(unsafe.Sizeof(entry.Tag)) + n) % 8
 		if align != 0 {
-			n, err = r.Read(make([]byte, 8-align))
+			n, err = io.ReadFull(r, make([]byte, 8-align))
 			if err != nil {
 				return nil, xerrors.Errorf("failed to read alignment: %w", err)
 			}
@@ -718,24 +859,11 @@ func (xfs *FileSystem) parseDir2DataEntry(r io.Reader) ([]Dir2DataEntry, error)
 	}
 }
 
-func (xfs *FileSystem) parseDir2Block(bmbtIrec BmbtIrec) (*Dir2Block, error) {
+// parseDir2Block parses a directory data block, b holds the whole directory
+// block, that is sb_dirblklog file system blocks.
+func (xfs *FileSystem) parseDir2Block(b []byte) (*Dir2Block, error) {
 	block := Dir2Block{}
-	// Skip Leaf and Free node
-	if int64(bmbtIrec.StartOff)*int64(xfs.PrimaryAG.SuperBlock.BlockSize) >= int64(XFS_DIR2_LEAF_OFFSET) {
-		return &block, nil
-	}
-
-	physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(bmbtIrec.StartBlock)
-	_, err := xfs.seekBlock(physicalBlockOffset)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to seek block: %w", err)
-	}
-
-	// TODO: add tests, If Block count greater than 2
-	b, err := utils.ReadBlock(xfs.r)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to read block: %w", err)
-	}
+	var err error
 	r := bytes.NewReader(b)
 	if err := binary.Read(r, binary.BigEndian, &block.Header); err != nil {
 		return nil, xerrors.Errorf("failed to parse block header error: %w", err)
@@ -751,13 +879,48 @@ func (xfs *FileSystem) parseDir2Block(bmbtIrec BmbtIrec) (*Dir2Block, error) {
 		if err != nil {
 			return nil, xerrors.Errorf("failed to parse XDB3 block: %w", err)
 		}
+	case XFS_DIR2_DATA_MAGIC, XFS_DIR2_BLOCK_MAGIC:
+		return nil, xerrors.Errorf("failed to parse header error magic: %08x: %w", block.Header.Magic, UnsupportedDir2BlockHeaderErr)
 	default:
-		return nil, xerrors.Errorf("failed to parse header error magic: %v: %w", block.Header.Magic, UnsupportedDir2BlockHeaderErr)
+		return nil, newCorruptedError("directory block", -1, "magic byte error: %08x", block.Header.Magic)
 	}
 
 	return &block, nil
 }
 
+// parseDir2SfHdr parses shortform directory header,
+// the parent inode number is 8 bytes when i8count is not zero.
+func parseDir2SfHdr(r io.Reader) (*Dir2SfHdr, error) {
+	var hdr Dir2SfHdr
+	if err := binary.Read(r, binary.BigEndian, &hdr.Count); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &hdr.I8Count); err != nil {
+		return nil, err
+	}
+	if hdr.I8Count != 0 {
+		if err := binary.Read(r, binary.BigEndian, &hdr.Parent); err != nil {
+			return nil, err
+		}
+		return &hdr, nil
+	}
+	var parent uint32
+	if err := binary.Read(r, binary.BigEndian, &parent); err != nil {
+		return nil, err
+	}
+	hdr.Parent = uint64(parent)
+	return &hdr, nil
+}
+
+// checkEntryName rejects names holding '/' or NUL, the kernel never writes
+// them and joined to a path they would point outside the directory
+func checkEntryName(structure string, name []byte) error {
+	if bytes.IndexByte(name, '/') >= 0 || bytes.IndexByte(name, 0) >= 0 {
+		return newCorruptedError(structure, -1, "invalid name %q", name)
+	}
+	return nil
+}
+
 func parseEntry(r io.Reader, i8count bool) (*Dir2SfEntry, error) {
 	var entry Dir2SfEntry
 	if err := binary.Read(r, binary.BigEndian, &entry.Namelen); err != nil {
@@ -767,14 +930,20 @@ func parseEntry(r io.Reader, i8count bool) (*Dir2SfEntry, error) {
 	if err := binary.Read(r, binary.BigEndian, &entry.Offset); err != nil {
 		return nil, err
 	}
+	if entry.Namelen == 0 {
+		return nil, newCorruptedError("shortform entry", -1, "zero name length")
+	}
 	buf := make([]byte, entry.Namelen)
-	i, err := r.Read(buf)
+	i, err := io.ReadFull(r, buf)
 	if err != nil {
 		return nil, err
 	}
 	if i != int(entry.Namelen) {
 		return nil, xerrors.Errorf("read name error: %s", string(buf))
 	}
+	if err := checkEntryName("shortform entry", buf); err != nil {
+		return nil, err
+	}
 	entry.EntryName = string(buf)
 	if err := binary.Read(r, binary.BigEndian, &entry.Filetype); err != nil {
 		return nil, err
@@ -803,23 +972,156 @@ func (ic InodeCore) IsRegular() bool {
 }
 
 func (ic InodeCore) IsSocket() bool {
-	return ic.Mode&0xC000 != 0
+	return ic.Mode&S_IFMT == S_IFSOCK
 }
 
 func (ic InodeCore) IsSymlink() bool {
-	return ic.Mode&0xA000 != 0
+	return ic.Mode&S_IFMT == S_IFLNK
+}
+
+// FileModeType returns the io/fs type bits of di_mode
+func (ic InodeCore) FileModeType() fs.FileMode {
+	switch ic.Mode & S_IFMT {
+	case S_IFDIR:
+		return fs.ModeDir
+	case S_IFLNK:
+		return fs.ModeSymlink
+	case S_IFCHR:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case S_IFBLK:
+		return fs.ModeDevice
+	case S_IFIFO:
+		return fs.ModeNamedPipe
+	case S_IFSOCK:
+		return fs.ModeSocket
+	}
+	return 0
+}
+
+// timestamp decodes an on-disk timestamp. Legacy timestamps are signed 32 bit
+// seconds and nanoseconds, inodes with XFS_DIFLAG2_BIGTIME store nanoseconds
+// since XFS_BIGTIME_EPOCH_OFFSET seconds before the unix epoch.
+func (ic InodeCore) timestamp(ts uint64) time.Time {
+	if ic.Version >= 3 && ic.Flags2&XFS_DIFLAG2_BIGTIME != 0 {
+		return time.Unix(int64(ts/1e9)-XFS_BIGTIME_EPOCH_OFFSET, int64(ts%1e9))
+	}
+	return time.Unix(int64(int32(ts>>32)), int64(uint32(ts)))
+}
+
+// encodeTimestamp is the inverse of timestamp, legacy timestamps are clamped
+// to the range of signed 32 bit seconds as the kernel does
+func (ic InodeCore) encodeTimestamp(t time.Time) uint64 {
+	sec := t.Unix()
+	if ic.Version >= 3 && ic.Flags2&XFS_DIFLAG2_BIGTIME != 0 {
+		if sec < -XFS_BIGTIME_EPOCH_OFFSET {
+			return 0
+		}
+		return uint64(sec+XFS_BIGTIME_EPOCH_OFFSET)*1e9 + uint64(t.Nanosecond())
+	}
+	nsec := t.Nanosecond()
+	switch {
+	case sec > math.MaxInt32:
+		sec, nsec = math.MaxInt32, 0
+	case sec < math.MinInt32:
+		sec, nsec = math.MinInt32, 0
+	}
+	return uint64(uint32(int32(sec)))<<32 | uint64(nsec)
+}
+
+// FileMode returns the unix mode bits with the io/fs type and special bits
+// added, so Mode().Type() and Mode().IsDir() work on the result.
+func (ic InodeCore) FileMode() fs.FileMode {
+	mode := fs.FileMode(ic.Mode) | ic.FileModeType()
+	if ic.Mode&S_ISUID != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if ic.Mode&S_ISGID != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if ic.Mode&S_ISVTX != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// fileTypeToMode converts XFS_DIR3_FT_* to io/fs type bits,
+// ok is false when the file type is unknown.
+func fileTypeToMode(ft uint8) (fs.FileMode, bool) {
+	switch ft {
+	case XFS_DIR3_FT_REG_FILE:
+		return 0, true
+	case XFS_DIR3_FT_DIR:
+		return fs.ModeDir, true
+	case XFS_DIR3_FT_CHRDEV:
+		return fs.ModeDevice | fs.ModeCharDevice, true
+	case XFS_DIR3_FT_BLKDEV:
+		return fs.ModeDevice, true
+	case XFS_DIR3_FT_FIFO:
+		return fs.ModeNamedPipe, true
+	case XFS_DIR3_FT_SOCK:
+		return fs.ModeSocket, true
+	case XFS_DIR3_FT_SYMLINK:
+		return fs.ModeSymlink, true
+	}
+	return 0, false
 }
 
 func (ic InodeCore) isSupported() bool {
-	return ic.Version == uint8(InodeSupportVersion)
+	return ic.Version >= 1 && ic.Version <= uint8(InodeSupportVersion)
+}
+
+// clearV3Fields zeroes the fields, that v1 and v2 inode cores do not have,
+// they were decoded from the data fork. v1 inodes keep the link count in onlink.
+func (ic *InodeCore) clearV3Fields() {
+	if ic.Version == 1 {
+		ic.NLink = uint32(ic.OnLink)
+		ic.ProjId = 0
+	}
+	ic.CRC = 0
+	ic.Changecount = 0
+	ic.Lsn = 0
+	ic.Flags2 = 0
+	ic.Cowextsize = 0
+	ic.Padding2 = [12]byte{}
+	ic.Crtime = 0
+	ic.Ino = 0
+	ic.MetaUUID = [16]byte{}
 }
 
 // https://github.com/torvalds/linux/blob/d2b6f8a179194de0ffc4886ffc2c4358d86047b8/fs/xfs/libxfs/xfs_bmap_btree.c#L60
+// extents returns the unpacked data fork extents of regular files and
+// directories in extents or btree format
+func (i *Inode) extents() []BmbtIrec {
+	var recs []BmbtRec
+	switch {
+	case i.regularExtent != nil:
+		recs = i.regularExtent.bmbtRecs
+	case i.regularBtree != nil:
+		recs = i.regularBtree.bmbtRecs
+	case i.directoryExtents != nil:
+		recs = i.directoryExtents.bmbtRecs
+	}
+	irecs := make([]BmbtIrec, 0, len(recs))
+	for _, rec := range recs {
+		irecs = append(irecs, rec.Unpack())
+	}
+	return irecs
+}
+
 func (b BmbtRec) Unpack() BmbtIrec {
 	return BmbtIrec{
 		StartOff:   (b.L0 & Mask64Lo(64-BMBT_EXNTFLAG_BITLEN)) >> 9,
 		StartBlock: ((b.L0 & Mask64Lo(9)) << 43) | (b.L1 >> 21),
 		BlockCount: b.L1 & Mask64Lo(21),
+		State:      uint8(b.L0 >> (64 - BMBT_EXNTFLAG_BITLEN)),
+	}
+}
+
+// Pack is the inverse of Unpack
+func (e BmbtIrec) Pack() BmbtRec {
+	return BmbtRec{
+		L0: uint64(e.State)<<(64-BMBT_EXNTFLAG_BITLEN) | (e.StartOff&Mask64Lo(54))<<9 | (e.StartBlock&Mask64Lo(52))>>43,
+		L1: (e.StartBlock&Mask64Lo(43))<<21 | e.BlockCount&Mask64Lo(21),
 	}
 }
 
@@ -1,11 +1,16 @@
 package xfs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
 )
 
 func TestParseInode(t *testing.T) {
@@ -67,7 +72,7 @@ func TestParseInode(t *testing.T) {
 			filesystem:  "testdata/image.xfs",
 			name:        "no_exist_inode invalid inode range",
 			inodeNumber: 9999999,
-			expectedErr: fmt.Errorf("EOF"),
+			expectedErr: fmt.Errorf("beyond AG count"),
 		},
 	}
 
@@ -104,3 +109,449 @@ func TestParseInode(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateExtent(t *testing.T) {
+	testCases := []struct {
+		name        string
+		startBlock  uint64
+		blockCount  uint64
+		expectedErr string
+	}{
+		{
+			name:       "valid extent",
+			startBlock: 100,
+			blockCount: 4,
+		},
+		{
+			name:        "zero block count",
+			startBlock:  100,
+			expectedErr: "zero block count",
+		},
+		{
+			name:        "AG out of range",
+			startBlock:  1 << 13,
+			blockCount:  1,
+			expectedErr: "out of range",
+		},
+		{
+			name:        "exceeds AG size",
+			startBlock:  5000,
+			blockCount:  1 << 20,
+			expectedErr: "exceeds AG size",
+		},
+		{
+			name:        "huge start block",
+			startBlock:  1<<52 - 1,
+			blockCount:  1,
+			expectedErr: "out of range",
+		},
+	}
+
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fileSystem.validateExtent(tt.startBlock, tt.blockCount)
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("expected %s, actual %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestParseCorruptedStructures(t *testing.T) {
+	fileSystem := &FileSystem{}
+	fileSystem.PrimaryAG.SuperBlock.BlockSize = 4096
+
+	freeTag := func(length uint16) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint16(b[0:], XFS_DIR2_DATA_FREE_TAG)
+		binary.BigEndian.PutUint16(b[2:], length)
+		return b
+	}
+	blockTail := func(count uint32) []byte {
+		b := make([]byte, 4096-64)
+		binary.BigEndian.PutUint32(b[len(b)-8:], count)
+		return b
+	}
+
+	testCases := []struct {
+		name        string
+		parse       func() error
+		expectedErr string
+	}{
+		{
+			name: "unused entry shorter than its header",
+			parse: func() error {
+				_, err := fileSystem.parseDir2DataEntry(bytes.NewReader(freeTag(4)))
+				return err
+			},
+			expectedErr: "corrupted unused entry",
+		},
+		{
+			name: "unused entry longer than the block",
+			parse: func() error {
+				_, err := fileSystem.parseDir2DataEntry(bytes.NewReader(freeTag(0xfff8)))
+				return err
+			},
+			expectedErr: "exceeds directory block",
+		},
+		{
+			name: "zero name length",
+			parse: func() error {
+				_, err := fileSystem.parseDir2DataEntry(bytes.NewReader(make([]byte, 16)))
+				return err
+			},
+			expectedErr: "zero name length",
+		},
+		{
+			name: "name with a slash",
+			parse: func() error {
+				_, err := fileSystem.parseDir2DataEntry(bytes.NewReader(append([]byte{0, 0, 0, 0, 0, 0, 0, 128, 5}, "../x/"...)))
+				return err
+			},
+			expectedErr: "invalid name",
+		},
+		{
+			name: "block leaf count exceeds block",
+			parse: func() error {
+				_, err := fileSystem.parseXDB3Block(bytes.NewReader(blockTail(0xffffffff)))
+				return err
+			},
+			expectedErr: "corrupted XDB3 block",
+		},
+		{
+			name: "bmbt numrecs exceeds maxrecs",
+			parse: func() error {
+				_, _, err := fileSystem.parseBmbtKeyPtr(bytes.NewReader(make([]byte, 512)), 21, 20)
+				return err
+			},
+			expectedErr: "exceeds maxrecs",
+		},
+		{
+			name: "shortform entry zero name length",
+			parse: func() error {
+				_, err := parseEntry(bytes.NewReader(make([]byte, 16)), false)
+				return err
+			},
+			expectedErr: "zero name length",
+		},
+		{
+			name: "shortform entry name with NUL",
+			parse: func() error {
+				_, err := parseEntry(bytes.NewReader([]byte{2, 0, 0, 'a', 0, 0}), false)
+				return err
+			},
+			expectedErr: "invalid name",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.parse()
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("expected %s, actual %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestBtreeTraversalLimits(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write an empty bmbt leaf block to a block past the used space
+	const leafBlock = 5000
+	binary.BigEndian.PutUint32(b[leafBlock*4096:], XFS_BMAP_CRC_MAGIC)
+	binary.BigEndian.PutUint16(b[leafBlock*4096+4:], 0)
+	binary.BigEndian.PutUint16(b[leafBlock*4096+6:], 0)
+
+	fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		parse       func() error
+		expectedErr string
+	}{
+		{
+			name: "leaf referenced twice",
+			parse: func() error {
+				_, err := fileSystem.parseSingleLevelBtree(nil, []BmbtPtr{leafBlock, leafBlock}, btreeVisited{})
+				return err
+			},
+			expectedErr: "block 5000 is referenced twice",
+		},
+		{
+			name: "node level does not match parent",
+			parse: func() error {
+				_, err := fileSystem.parseMultiLevelBtree(2, nil, []BmbtPtr{leafBlock}, Inode{}, btreeVisited{})
+				return err
+			},
+			expectedErr: "unexpected level 0, expected 1",
+		},
+		{
+			name: "root level exceeds max levels",
+			parse: func() error {
+				root := make([]byte, 4)
+				binary.BigEndian.PutUint16(root, XFS_BTREE_MAXLEVELS+1)
+				_, err := fileSystem.parseBmbrBlock(bytes.NewReader(root), Inode{})
+				return err
+			},
+			expectedErr: "invalid root level 10",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.parse()
+			if !xerrors.Is(err, ErrCorrupted) || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("expected %s, actual %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestParseInodeSizes(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fmt_local_directory, a v3 inode with a shortform directory
+	const ino = 11075
+	expected, err := source.ParseInode(ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v3 := make([]byte, 512)
+	if _, err := f.ReadAt(v3, int64(source.PrimaryAG.SuperBlock.InodeAbsOffset(ino))); err != nil {
+		t.Fatal(err)
+	}
+	// v2 inode core ends after di_next_unlinked, the data fork follows it
+	v2 := make([]byte, 256)
+	copy(v2, v3[:INODEV2_SIZE])
+	v2[4] = 2
+	copy(v2[INODEV2_SIZE:], v3[INODEV3_SIZE:])
+	// the security.selinux attribute fork does not fit a 256 byte inode,
+	// clear di_anextents, di_forkoff and di_aformat
+	copy(v2[80:84], []byte{0, 0, 0, 0})
+
+	testCases := []struct {
+		name       string
+		inode      []byte
+		inodeLog   uint8
+		versionnum uint16
+	}{
+		{name: "v2 256 bytes", inode: v2, inodeLog: 8, versionnum: 4},
+		{name: "v3 512 bytes", inode: v3, inodeLog: 9, versionnum: 5},
+		{name: "v3 1024 bytes", inode: v3, inodeLog: 10, versionnum: 5},
+		{name: "v3 2048 bytes", inode: v3, inodeLog: 11, versionnum: 5},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// inode 3 is the fourth inode of block 0
+			inodeSize := 1 << tt.inodeLog
+			img := make([]byte, 64*4096)
+			copy(img[3*inodeSize:], tt.inode)
+			if tt.versionnum == 5 {
+				// di_ino of the v3 inode core
+				binary.BigEndian.PutUint64(img[3*inodeSize+152:], 3)
+			}
+			fileSystem := newBlockSizeFS(img, 12, 0)
+			sb := &fileSystem.PrimaryAG.SuperBlock
+			sb.Versionnum = tt.versionnum
+			sb.Inodesize = uint16(inodeSize)
+			sb.Inodelog = tt.inodeLog
+			sb.Inopblock = uint16(4096 / inodeSize)
+			sb.Inopblog = 12 - tt.inodeLog
+
+			inode, err := fileSystem.ParseInode(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inode.inodeCore.Mode != expected.inodeCore.Mode || inode.inodeCore.Size != expected.inodeCore.Size {
+				t.Errorf("expected mode %o size %d, actual mode %o size %d",
+					expected.inodeCore.Mode, expected.inodeCore.Size, inode.inodeCore.Mode, inode.inodeCore.Size)
+			}
+			if inode.directoryLocal == nil || len(inode.directoryLocal.entries) != len(expected.directoryLocal.entries) {
+				t.Fatalf("unexpected shortform directory: %+v", inode.directoryLocal)
+			}
+			for i, entry := range inode.directoryLocal.entries {
+				if entry.Name() != expected.directoryLocal.entries[i].Name() {
+					t.Errorf("expected %s, actual %s", expected.directoryLocal.entries[i].Name(), entry.Name())
+				}
+			}
+		})
+	}
+
+	t.Run("v3 inode on v4 file system", func(t *testing.T) {
+		img := make([]byte, 64*4096)
+		copy(img[3*512:], v3)
+		fileSystem := newBlockSizeFS(img, 12, 0)
+		fileSystem.PrimaryAG.SuperBlock.Versionnum = 4
+		if _, err := fileSystem.ParseInode(3); !xerrors.Is(err, ErrCorrupted) {
+			t.Fatalf("expected %v, actual %v", ErrCorrupted, err)
+		}
+	})
+}
+
+// sparseReaderAt reads zeros except for the chunks, it stands in for
+// large geometry images, that cannot be stored in testdata.
+type sparseReaderAt map[int64][]byte
+
+func (s sparseReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	for start, chunk := range s {
+		for i := range p {
+			if j := off + int64(i) - start; j >= 0 && j < int64(len(chunk)) {
+				p[i] = chunk[j]
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func TestParseInodeLargeGeometry(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sourceIno = 11075
+	inode := make([]byte, source.PrimaryAG.SuperBlock.Inodesize)
+	if _, err := f.ReadAt(inode, int64(source.PrimaryAG.SuperBlock.InodeAbsOffset(sourceIno))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 64 AGs of 2^30 4K blocks, 256 TiB, inode numbers need 39 bits
+	sb := source.PrimaryAG.SuperBlock
+	sb.Agblocks = 1 << 30
+	sb.Agblklog = 30
+	sb.Agcount = 64
+	sb.Dblocks = 63<<30 + 1<<29 + 8
+	if err := sb.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ino := uint64(63)<<(sb.Agblklog+sb.Inopblog) | uint64(1<<29+7)<<sb.Inopblog | 5
+	v3 := append([]byte(nil), inode...)
+	binary.BigEndian.PutUint64(v3[152:], ino)
+	image := sparseReaderAt{int64(sb.InodeAbsOffset(ino)): v3}
+	fileSystem := &FileSystem{
+		r:     io.NewSectionReader(image, 0, int64(sb.Dblocks)*int64(sb.BlockSize)),
+		cache: &mockCache[string, any]{},
+	}
+	fileSystem.PrimaryAG.SuperBlock = sb
+
+	parsed, err := fileSystem.ParseInode(ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.inodeCore.Ino != ino || !parsed.inodeCore.IsDir() {
+		t.Errorf("expected directory inode %d, actual %d mode %o", ino, parsed.inodeCore.Ino, parsed.inodeCore.Mode)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ino  uint64
+	}{
+		{name: "beyond AG count", ino: uint64(64) << (sb.Agblklog + sb.Inopblog)},
+		{name: "beyond the last AG", ino: uint64(63)<<(sb.Agblklog+sb.Inopblog) | uint64(1<<29+8)<<sb.Inopblog},
+		{name: "beyond the maximum inode number", ino: 1 << 56},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fileSystem.ParseInode(tt.ino); !xerrors.Is(err, ErrCorrupted) {
+				t.Errorf("expected %v, actual %v", ErrCorrupted, err)
+			}
+		})
+	}
+}
+
+func TestInodeCoreTimestamp(t *testing.T) {
+	bigtime := func(t time.Time) uint64 {
+		return uint64(t.Unix()+XFS_BIGTIME_EPOCH_OFFSET)*1e9 + uint64(t.Nanosecond())
+	}
+	legacy := func(t time.Time) uint64 {
+		return uint64(uint32(int32(t.Unix())))<<32 | uint64(t.Nanosecond())
+	}
+
+	tests := []struct {
+		name    string
+		core    InodeCore
+		ts      uint64
+		expects time.Time
+	}{
+		{
+			name:    "legacy",
+			core:    InodeCore{Version: 3},
+			ts:      legacy(time.Date(2021, 6, 5, 15, 25, 40, 81436510, time.UTC)),
+			expects: time.Date(2021, 6, 5, 15, 25, 40, 81436510, time.UTC),
+		},
+		{
+			name:    "legacy before the unix epoch",
+			core:    InodeCore{Version: 2},
+			ts:      legacy(time.Date(1901, 12, 14, 0, 0, 0, 5, time.UTC)),
+			expects: time.Date(1901, 12, 14, 0, 0, 0, 5, time.UTC),
+		},
+		{
+			name:    "bigtime after 2038",
+			core:    InodeCore{Version: 3, Flags2: XFS_DIFLAG2_BIGTIME},
+			ts:      bigtime(time.Date(2486, 7, 2, 1, 2, 3, 999999999, time.UTC)),
+			expects: time.Date(2486, 7, 2, 1, 2, 3, 999999999, time.UTC),
+		},
+		{
+			name:    "bigtime epoch",
+			core:    InodeCore{Version: 3, Flags2: XFS_DIFLAG2_BIGTIME},
+			ts:      0,
+			expects: time.Unix(-XFS_BIGTIME_EPOCH_OFFSET, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := tt.core.timestamp(tt.ts)
+			if !actual.Equal(tt.expects) {
+				t.Errorf("expected %s, actual %s", tt.expects, actual)
+			}
+		})
+	}
+}
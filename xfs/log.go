@@ -0,0 +1,152 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// LogState is the state of the internal log (journal) found at mount
+type LogState int
+
+const (
+	// LogUnknown is reported for external logs and logs, that could not be read
+	LogUnknown LogState = iota
+	// LogClean means the last log record is an unmount record
+	LogClean
+	// LogDirty means the file system was not cleanly unmounted, metadata may be
+	// stale relative to the transactions in the log
+	LogDirty
+)
+
+func (s LogState) String() string {
+	switch s {
+	case LogClean:
+		return "clean"
+	case LogDirty:
+		return "dirty"
+	default:
+		return "unknown"
+	}
+}
+
+// xlogRecHeader is xlog_rec_header, the first basic block of a log record
+// https://github.com/torvalds/linux/blob/v6.1/fs/xfs/libxfs/xfs_log_format.h#L151
+type xlogRecHeader struct {
+	Magicno   uint32
+	Cycle     uint32
+	Version   uint32
+	Len       uint32
+	Lsn       uint64
+	TailLsn   uint64
+	CRC       uint32
+	PrevBlock int32
+	NumLogops int32
+	CycleData [XLOG_HEADER_CYCLE_SIZE / BBSIZE]uint32
+	Fmt       int32
+	FsUUID    [16]byte
+	Size      int32
+}
+
+// xlogOpHeader is xlog_op_header, it starts each log operation in a record
+type xlogOpHeader struct {
+	Tid      uint32
+	Len      uint32
+	Clientid uint8
+	Flags    uint8
+	Res2     uint16
+}
+
+// maximum number of basic blocks, that are searched back from the head for the last record header
+const xlogMaxRecordBBs = 2 * XLOG_MAX_RECORD_BSIZE / BBSIZE
+
+// logState finds the head of the internal log and reports whether the last
+// record before it is an unmount record, as xlog_find_head does in the kernel.
+func (xfs *FileSystem) readLogState() (LogState, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	if sb.Logstart == 0 {
+		return LogUnknown, nil
+	}
+	offset := sb.BlockToPhysicalOffset(sb.Logstart) * int64(sb.BlockSize)
+	bbs := int64(sb.Logblocks) * int64(sb.BlockSize) / BBSIZE
+	if bbs == 0 {
+		return LogUnknown, newCorruptedError("log", offset, "zero log blocks")
+	}
+
+	readBB := func(bb int64) ([]byte, error) {
+		buf := make([]byte, BBSIZE)
+		if _, err := xfs.r.ReadAt(buf, offset+bb*BBSIZE); err != nil {
+			return nil, xerrors.Errorf("failed to read log block %d: %w", bb, err)
+		}
+		return buf, nil
+	}
+	// every basic block starts with the cycle number, except record headers
+	cycle := func(bb int64) (uint32, error) {
+		buf, err := readBB(bb)
+		if err != nil {
+			return 0, err
+		}
+		if binary.BigEndian.Uint32(buf) == XLOG_HEADER_MAGIC_NUM {
+			return binary.BigEndian.Uint32(buf[4:]), nil
+		}
+		return binary.BigEndian.Uint32(buf), nil
+	}
+
+	firstCycle, err := cycle(0)
+	if err != nil {
+		return LogUnknown, err
+	}
+	// blocks before the head have the current cycle, the ones after it the previous cycle
+	lo, hi := int64(0), bbs
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		c, err := cycle(mid)
+		if err != nil {
+			return LogUnknown, err
+		}
+		if c == firstCycle {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	head := lo
+
+	for i := int64(1); i <= xlogMaxRecordBBs && i <= bbs; i++ {
+		bb := ((head-i)%bbs + bbs) % bbs
+		buf, err := readBB(bb)
+		if err != nil {
+			return LogUnknown, err
+		}
+		if binary.BigEndian.Uint32(buf) != XLOG_HEADER_MAGIC_NUM {
+			continue
+		}
+
+		var hdr xlogRecHeader
+		if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &hdr); err != nil {
+			return LogUnknown, xerrors.Errorf("failed to read log record header: %w", err)
+		}
+		if hdr.NumLogops != 1 {
+			return LogDirty, nil
+		}
+		// v2 logs use one header block per XLOG_HEADER_CYCLE_SIZE bytes of record
+		hblks := int64(1)
+		if hdr.Version&XLOG_VERSION_2 != 0 && hdr.Size > XLOG_HEADER_CYCLE_SIZE {
+			hblks = (int64(hdr.Size) + XLOG_HEADER_CYCLE_SIZE - 1) / XLOG_HEADER_CYCLE_SIZE
+		}
+		data, err := readBB((bb + hblks) % bbs)
+		if err != nil {
+			return LogUnknown, err
+		}
+		var op xlogOpHeader
+		if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &op); err != nil {
+			return LogUnknown, xerrors.Errorf("failed to read log operation header: %w", err)
+		}
+		if op.Clientid == XFS_LOG && op.Flags&XLOG_UNMOUNT_TRANS != 0 {
+			return LogClean, nil
+		}
+		return LogDirty, nil
+	}
+	return LogUnknown, newCorruptedError("log", offset, "no record header before head block %d", head)
+}
@@ -0,0 +1,71 @@
+package xfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestReadLogState(t *testing.T) {
+	testCases := []struct {
+		name     string
+		image    string
+		modify   func(b []byte)
+		opts     []Option
+		expected LogState
+		err      error
+	}{
+		{
+			name:     "clean log",
+			image:    "testdata/image.xfs",
+			expected: LogClean,
+		},
+		{
+			name:     "clean log in AG 1",
+			image:    "testdata/image40.xfs",
+			expected: LogClean,
+		},
+		{
+			// clear XLOG_UNMOUNT_TRANS of the unmount record at basic block 1100 of the log
+			name:   "dirty log",
+			image:  "testdata/image.xfs",
+			modify: func(b []byte) { b[6*4096+1101*512+9] = 0 },
+			err:    ErrDirtyLog,
+		},
+		{
+			name:     "dirty log allowed",
+			image:    "testdata/image.xfs",
+			modify:   func(b []byte) { b[6*4096+1101*512+9] = 0 },
+			opts:     []Option{WithAllowDirty()},
+			expected: LogDirty,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := os.ReadFile(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.modify != nil {
+				tt.modify(b)
+			}
+			fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil, tt.opts...)
+			if tt.err != nil {
+				if !xerrors.Is(err, tt.err) {
+					t.Fatalf("expected %v, actual %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := fileSystem.Info().Log; actual != tt.expected {
+				t.Errorf("expected %s, actual %s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package xfs
+
+import (
+	"github.com/masahiro331/go-xfs-filesystem/log"
+)
+
+// Logger receives the warnings and debug messages of a FileSystem, set with
+// WithLogger. The args are key and value pairs of structured fields such as
+// "ino", "ag" and "offset", as *slog.Logger of log/slog takes them, so a
+// service scanning several images can tell their messages apart:
+//
+//	logger := slog.Default().With("image", id)
+//	fileSystem, err := xfs.OpenReaderAt(r, nil, xfs.WithLogger(logger))
+type Logger interface {
+	Debug(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// globalLogger is the Logger of file systems without WithLogger, it logs to
+// log.Logger
+type globalLogger struct{}
+
+func (globalLogger) Debug(msg string, args ...any) { log.Logger.Debugw(msg, args...) }
+
+func (globalLogger) Warn(msg string, args ...any) { log.Logger.Warnw(msg, args...) }
+
+// Logger returns the Logger of the file system, for packages reading it to
+// report to the same logger
+func (xfs *FileSystem) Logger() Logger {
+	if xfs.logger == nil {
+		return globalLogger{}
+	}
+	return xfs.logger
+}
+
+// inodeFields returns the structured fields locating the inode ino, followed
+// by args
+func (xfs *FileSystem) inodeFields(ino uint64, args ...any) []any {
+	sb := xfs.PrimaryAG.SuperBlock
+	agNumber, _, _ := sb.InodeOffset(ino)
+	return append([]any{"ino", ino, "ag", agNumber, "offset", sb.InodeAbsOffset(ino)}, args...)
+}
+
+// blockFields returns the structured fields locating the file system block,
+// followed by args
+func (xfs *FileSystem) blockFields(block uint64, args ...any) []any {
+	sb := xfs.PrimaryAG.SuperBlock
+	offset := sb.BlockToPhysicalOffset(block) * int64(sb.BlockSize)
+	return append([]any{"block", block, "ag", sb.BlockToAgNumber(block), "offset", offset}, args...)
+}
@@ -0,0 +1,97 @@
+package xfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordLogger is a Logger keeping the messages with their fields
+type recordLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordLogger) record(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+
+func (l *recordLogger) Warn(msg string, args ...any) { l.record("WARN", msg, args) }
+
+func (l *recordLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.messages, "\n")
+}
+
+func TestWithLogger(t *testing.T) {
+	img, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unsupported uuid format in the inode of etc/os-release, inodes of
+	// the first AG are at ino * 512
+	img[20453*512+5] = XFS_DINODE_FMT_UUID
+
+	logger := &recordLogger{}
+	fileSystem, err := OpenReaderAt(bytes.NewReader(img), nil, WithLogger(logger), WithPinnedPaths("missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileSystem.Logger() != logger {
+		t.Error("Logger does not return the logger")
+	}
+	if _, err := fileSystem.Stat("etc/os-release"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"DEBUG failed to pin [path missing error ",
+		"WARN unsupported uuid format inode [ino 20453 ag 0 offset 10471936]",
+	} {
+		if !strings.Contains(logger.String(), expected) {
+			t.Errorf("%q is missing from the log:\n%s", expected, logger)
+		}
+	}
+}
+
+func TestWithLoggerV4(t *testing.T) {
+	img, err := os.ReadFile("testdata/tiny/v4.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := OpenReaderAt(bytes.NewReader(img), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fileSystem.Lstat("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino := info.(FileInfo).Ino()
+	sb := fileSystem.PrimaryAG.SuperBlock
+	offset := sb.InodeAbsOffset(ino)
+
+	// v4 inode cores do not hold their number, the fields take it from the
+	// directory entry
+	img[offset+5] = XFS_DINODE_FMT_LOCAL
+	logger := &recordLogger{}
+	fileSystem, err = OpenReaderAt(bytes.NewReader(img), nil, WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.Stat("hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("WARN unsupported local format inode [ino %d ag 0 offset %d mode ", ino, offset)
+	if !strings.Contains(logger.String(), expected) {
+		t.Errorf("%q is missing from the log:\n%s", expected, logger)
+	}
+}
@@ -0,0 +1,183 @@
+package xfs_test
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// goldenEntry is a line of a .golden file written by testdata/matrix/generate.sh
+type goldenEntry struct {
+	typ    string
+	mode   fs.FileMode
+	size   int64
+	sha256 string
+	nlink  uint32
+}
+
+// TestFixtureMatrix compares the images of testdata/matrix against the kernel
+// view recorded when they were generated. The images are not committed, run
+// testdata/matrix/generate.sh as root to create them, missing images are skipped.
+func TestFixtureMatrix(t *testing.T) {
+	tests := []struct {
+		name string
+		// unsupported is the reason the image cannot be read yet
+		unsupported string
+	}{
+		{name: "v5"},
+		{name: "v4", unsupported: "XD2B and XD2D directory blocks"},
+		{name: "v4-noftype", unsupported: "XD2B and XD2D directory blocks"},
+		{name: "v5-bigtime"},
+		{name: "v5-sparse"},
+		{name: "v5-reflink"},
+		{name: "v5-1k"},
+		{name: "v5-64k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := filepath.Join("testdata", "matrix", tt.name+".xfs")
+			f, err := os.Open(image)
+			if os.IsNotExist(err) {
+				t.Skipf("%s does not exist, run testdata/matrix/generate.sh", image)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if tt.unsupported != "" {
+				t.Skipf("unsupported: %s", tt.unsupported)
+			}
+
+			expected, err := readGolden(filepath.Join("testdata", "matrix", tt.name+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := make(map[string]goldenEntry)
+			err = fs.WalkDir(fileSystem, ".", func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if path == "." {
+					return nil
+				}
+				entry, err := readEntry(fileSystem, path)
+				if err != nil {
+					return err
+				}
+				actual[path] = entry
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for name := range expected {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				a, ok := actual[name]
+				if !ok {
+					t.Errorf("%s: missing", name)
+					continue
+				}
+				if a != expected[name] {
+					t.Errorf("%s: expected %+v, actual %+v", name, expected[name], a)
+				}
+				delete(actual, name)
+			}
+			for name := range actual {
+				t.Errorf("%s: unexpected path", name)
+			}
+		})
+	}
+}
+
+func readEntry(fileSystem *xfs.FileSystem, path string) (goldenEntry, error) {
+	info, err := fileSystem.Lstat(path)
+	if err != nil {
+		return goldenEntry{}, err
+	}
+	entry := goldenEntry{
+		mode:  info.Mode() & 07777,
+		size:  info.Size(),
+		nlink: info.Sys().(*xfs.InodeCore).NLink,
+	}
+	switch {
+	case info.Mode().Type() == fs.ModeSymlink:
+		entry.typ = "symlink"
+		target, err := fileSystem.ReadLink(path)
+		if err != nil {
+			return goldenEntry{}, err
+		}
+		entry.sha256 = fmt.Sprintf("%x", sha256.Sum256([]byte(target)))
+	case info.IsDir():
+		entry.typ = "dir"
+		entry.sha256 = "-"
+	default:
+		entry.typ = "file"
+		b, err := fileSystem.ReadFile(path)
+		if err != nil {
+			return goldenEntry{}, err
+		}
+		entry.sha256 = fmt.Sprintf("%x", sha256.Sum256(b))
+	}
+	return entry, nil
+}
+
+func readGolden(name string) (map[string]goldenEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]goldenEntry)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid golden line: %q", scanner.Text())
+		}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode: %q", scanner.Text())
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size: %q", scanner.Text())
+		}
+		nlink, err := strconv.ParseUint(fields[5], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nlink: %q", scanner.Text())
+		}
+		entries[fields[0]] = goldenEntry{
+			typ:    fields[1],
+			mode:   fs.FileMode(mode),
+			size:   size,
+			sha256: fields[4],
+			nlink:  uint32(nlink),
+		}
+	}
+	return entries, scanner.Err()
+}
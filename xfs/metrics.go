@@ -0,0 +1,54 @@
+package xfs
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Metrics receives the measurements of a FileSystem, set with WithMetrics.
+// The methods are called concurrently when the FileSystem is shared. The
+// xfsprom package implements Metrics with Prometheus collectors.
+type Metrics interface {
+	// ImageRead is called after each read of the image with the bytes read
+	ImageRead(n int, err error)
+	// CacheLookup is called on lookups of the caches enabled with
+	// WithInodeCacheSize and WithDirCacheSize, cache is "inode" or "dir"
+	CacheLookup(cache string, hit bool)
+	// ParseError is called when an operation fails on a corrupted on-disk
+	// structure, such as "inode" or "superblock"
+	ParseError(structure string)
+	// Operation is called at the end of the operations "mount", "open",
+	// "stat", "read directory" and "read file" with their latency, the
+	// operations are reported once, Stat and ReadFile report no open.
+	Operation(op string, d time.Duration, err error)
+}
+
+// meteredReaderAt reports the reads of the image to the metrics
+type meteredReaderAt struct {
+	r       io.ReaderAt
+	metrics Metrics
+}
+
+func (m meteredReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := m.r.ReadAt(p, off)
+	m.metrics.ImageRead(n, err)
+	return n, err
+}
+
+// observe reports the operation op started at start to metrics
+func observe(metrics Metrics, op string, start time.Time, err error) {
+	metrics.Operation(op, time.Since(start), err)
+	var corrupted *CorruptedError
+	if xerrors.As(err, &corrupted) {
+		metrics.ParseError(corrupted.Structure)
+	}
+}
+
+// cacheLookup reports a lookup of an enabled cache to the metrics
+func (xfs *FileSystem) cacheLookup(cache string, enabled, hit bool) {
+	if xfs.metrics != nil && enabled {
+		xfs.metrics.CacheLookup(cache, hit)
+	}
+}
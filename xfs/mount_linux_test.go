@@ -0,0 +1,212 @@
+package xfs_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// TestDifferentialMount loop-mounts the fixture images read only and compares
+// every path, type, mode, size, mtime and content hash of the kernel view with the
+// library. It needs root and is enabled with XFS_MOUNT_TEST=1, it then fails
+// when the images cannot be mounted.
+func TestDifferentialMount(t *testing.T) {
+	if os.Getenv("XFS_MOUNT_TEST") == "" {
+		t.Skip("set XFS_MOUNT_TEST=1 to compare with the kernel")
+	}
+	if os.Geteuid() != 0 {
+		t.Fatal("mounting images needs root")
+	}
+
+	images := []string{"testdata/image.xfs", "testdata/image40.xfs"}
+	matrix, err := filepath.Glob("testdata/matrix/*.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images = append(images, matrix...)
+
+	for _, image := range images {
+		t.Run(image, func(t *testing.T) {
+			mnt := t.TempDir()
+			// norecovery keeps the image untouched even when its log is dirty
+			if out, err := exec.Command("mount", "-t", "xfs", "-o", "loop,ro,norecovery", image, mnt).CombinedOutput(); err != nil {
+				t.Fatalf("failed to mount %s: %s: %s", image, err, out)
+			}
+			defer func() {
+				if out, err := exec.Command("umount", mnt).CombinedOutput(); err != nil {
+					t.Errorf("failed to unmount %s: %s: %s", mnt, err, out)
+				}
+			}()
+
+			expected, err := kernelEntries(mnt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil, xfs.WithAllowDirty())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]bool)
+			err = fs.WalkDir(fileSystem, ".", func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					t.Errorf("%s: %s", path, err)
+					return nil
+				}
+				if path == "." {
+					return nil
+				}
+				seen[path] = true
+				e, ok := expected[path]
+				if !ok {
+					t.Errorf("%s: not in the kernel view", path)
+					return nil
+				}
+				actual, err := readEntry(fileSystem, path)
+				if err != nil {
+					t.Errorf("%s: %s", path, err)
+					return nil
+				}
+				if actual != e {
+					t.Errorf("%s: expected %+v, actual %+v", path, e, actual)
+				}
+
+				kernelInfo, err := os.Lstat(filepath.Join(mnt, path))
+				if err != nil {
+					return err
+				}
+				info, err := fileSystem.Lstat(path)
+				if err != nil {
+					return err
+				}
+				if !info.ModTime().Equal(kernelInfo.ModTime()) {
+					t.Errorf("%s: expected mtime %s, actual %s", path, kernelInfo.ModTime(), info.ModTime())
+				}
+
+				// syscall has no llistxattr, so the attributes of symlinks are not compared
+				if kernelInfo.Mode()&fs.ModeSymlink != 0 {
+					return nil
+				}
+				expectedXattrs, err := kernelXattrs(filepath.Join(mnt, path))
+				if err != nil {
+					return err
+				}
+				xattrs, err := fileSystem.ListXattrs(path)
+				if err != nil {
+					t.Errorf("%s: %s", path, err)
+					return nil
+				}
+				actualXattrs := make(map[string]string)
+				for _, x := range xattrs {
+					actualXattrs[x.Name] = string(x.Value)
+				}
+				if !reflect.DeepEqual(actualXattrs, expectedXattrs) {
+					t.Errorf("%s: expected xattrs %q, actual %q", path, expectedXattrs, actualXattrs)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path := range expected {
+				if !seen[path] {
+					t.Errorf("%s: missing", path)
+				}
+			}
+		})
+	}
+}
+
+// kernelEntries returns the entries of the mounted tree root by slash separated relative path.
+func kernelEntries(root string) (map[string]goldenEntry, error) {
+	entries := make(map[string]goldenEntry)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("%s: unexpected Sys %T", p, info.Sys())
+		}
+		entry := goldenEntry{
+			mode:  fs.FileMode(st.Mode & 07777),
+			size:  info.Size(),
+			nlink: uint32(st.Nlink),
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.typ = "symlink"
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			entry.sha256 = fmt.Sprintf("%x", sha256.Sum256([]byte(target)))
+		case info.IsDir():
+			entry.typ = "dir"
+			entry.sha256 = "-"
+		default:
+			entry.typ = "file"
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			entry.sha256 = fmt.Sprintf("%x", sha256.Sum256(b))
+		}
+		entries[filepath.ToSlash(rel)] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// kernelXattrs returns the extended attributes of p. The system namespace is left out, the kernel derives it from the trusted
+// attributes holding ACLs.
+func kernelXattrs(p string) (map[string]string, error) {
+	buf := make([]byte, 1<<16)
+	n, err := syscall.Listxattr(p, buf)
+	if err == syscall.ENOTSUP {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: listxattr: %w", p, err)
+	}
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:n]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, "system.") {
+			continue
+		}
+		value := make([]byte, 1<<16)
+		m, err := syscall.Getxattr(p, name, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: getxattr %s: %w", p, name, err)
+		}
+		xattrs[name] = string(value[:m])
+	}
+	return xattrs, nil
+}
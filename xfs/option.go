@@ -0,0 +1,138 @@
+package xfs
+
+import (
+	"io"
+	"path"
+	"strings"
+)
+
+// Option configures a FileSystem
+type Option func(*options)
+
+type options struct {
+	pinnedPaths []string
+
+	inodeCacheSize int
+	dirCacheSize   int
+	persistentDir  string
+
+	transforms []Transform
+
+	allowDirty bool
+
+	writer io.WriterAt
+
+	symlinkPolicy SymlinkPolicy
+
+	tracer  Tracer
+	metrics Metrics
+	logger  Logger
+}
+
+// WithInodeCacheSize enables an in-memory cache of up to n parsed inodes keyed
+// by inode number. The cache is pre-sized by the allocated inode count of the
+// superblock, so large walks do not rehash it.
+func WithInodeCacheSize(n int) Option {
+	return func(o *options) {
+		o.inodeCacheSize = n
+	}
+}
+
+// WithDirCacheSize enables an in-memory cache of the entries of up to n
+// directories keyed by directory inode number.
+func WithDirCacheSize(n int) Option {
+	return func(o *options) {
+		o.dirCacheSize = n
+	}
+}
+
+// WithPersistentCache keeps the superblocks, inodes, directory listings and
+// metadata blocks read from the image in a file of dir, written by Close, so
+// the next FileSystem of the same image starts warm instead of reading them
+// again from slow or remote storage. The file is keyed by the UUID of the
+// image and a hash of its superblock, which changes when the file system is
+// modified. Images with a dirty log are not cached.
+func WithPersistentCache(dir string) Option {
+	return func(o *options) {
+		o.persistentDir = dir
+	}
+}
+
+// WithTransform decodes the image with t between the reader given to NewFS
+// and the parsing of the file system, to read compressed, sparse or
+// encrypted images in place. Transforms given several times are stacked in
+// order, the first one reads the image. WithMetrics counts the reads of the
+// image before any transform.
+func WithTransform(t Transform) Option {
+	return func(o *options) {
+		o.transforms = append(o.transforms, t)
+	}
+}
+
+// WithAllowDirty mounts file systems, whose log is dirty. By default NewFS
+// returns ErrDirtyLog for them, as metadata may be stale relative to the log.
+func WithAllowDirty() Option {
+	return func(o *options) {
+		o.allowDirty = true
+	}
+}
+
+// WithReadWrite enables the write operations of the FileSystem, such as
+// WriteFile, which write the changed blocks to w at their offsets in the
+// image. w must write to the image NewFS reads, usually the same *os.File
+// opened read-write. Writing is supported on v5 file systems without a dirty
+// log, a realtime volume or the reverse mapping btree, and cannot be combined
+// with WithTransform, WithPersistentCache or WithPinnedPaths. The write
+// operations take no lock, they must not be called concurrently with each
+// other or with reads of the FileSystem.
+func WithReadWrite(w io.WriterAt) Option {
+	return func(o *options) {
+		o.writer = w
+	}
+}
+
+// WithSymlinkPolicy sets how Stat treats symlinks, the default is SymlinkNoFollow.
+func WithSymlinkPolicy(p SymlinkPolicy) Option {
+	return func(o *options) {
+		o.symlinkPolicy = p
+	}
+}
+
+// WithPinnedPaths pre-resolves the given directories right after mount and pins
+// their directory entries and child inodes in memory for the lifetime of the
+// FileSystem. Paths that do not exist in the image are ignored.
+func WithPinnedPaths(paths ...string) Option {
+	return func(o *options) {
+		for _, p := range paths {
+			p = path.Clean(strings.TrimPrefix(p, "/"))
+			if p == "" {
+				p = "."
+			}
+			o.pinnedPaths = append(o.pinnedPaths, p)
+		}
+	}
+}
+
+// WithTracer traces the mount, path resolution, directory decoding and block
+// reads of the FileSystem with t.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithMetrics reports the image reads, cache lookups, parse errors and the
+// latency of the operations of the FileSystem to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithLogger sends the warnings and debug messages of the FileSystem to l,
+// such as a *slog.Logger, instead of the global log.Logger.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
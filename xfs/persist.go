@@ -0,0 +1,232 @@
+package xfs
+
+import (
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// persistentCacheVersion is increased when the format of the cached
+// structures changes, files of other versions are ignored
+const persistentCacheVersion = 1
+
+// persistentCache is the metadata of an image kept in a file between
+// FileSystems, see WithPersistentCache
+type persistentCache struct {
+	path string
+
+	mu    sync.Mutex
+	dirty bool
+	data  persistentCacheData
+}
+
+// persistentCacheData is the gob encoded content of a cache file
+type persistentCacheData struct {
+	Version int
+	// AGs are the decoded superblocks and AG headers
+	AGs []AG
+	// Inodes are the raw inode records by inode number
+	Inodes map[uint64][]byte
+	// Blocks are the raw metadata blocks by physical block number:
+	// directory, extent btree and attribute blocks, never file data
+	Blocks map[int64][]byte
+	// Dirs are the complete directory listings by directory inode number
+	Dirs map[uint64][]Dir2DataEntry
+}
+
+// openPersistentCache returns the cache of the image r in dir, empty when
+// the image was not seen in this generation. The file is named after the
+// UUID and a hash of the primary superblock, which gets new counters and log
+// sequence numbers whenever the file system is modified and unmounted.
+func openPersistentCache(r io.ReaderAt, dir string) (*persistentCache, error) {
+	sector := make([]byte, BBSIZE)
+	if _, err := r.ReadAt(sector, 0); err != nil {
+		return nil, xerrors.Errorf("failed to read the superblock: %w", err)
+	}
+	h := fnv.New64a()
+	h.Write(sector)
+	// the UUID is at offset 32 of the superblock
+	uuid := fmt.Sprintf("%x", sector[32:48])
+	c := &persistentCache{
+		path: filepath.Join(dir, fmt.Sprintf("%s-%016x.gob", uuid, h.Sum64())),
+		data: persistentCacheData{
+			Version: persistentCacheVersion,
+			Inodes:  map[uint64][]byte{},
+			Blocks:  map[int64][]byte{},
+			Dirs:    map[uint64][]Dir2DataEntry{},
+		},
+	}
+
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var data persistentCacheData
+	if err := gob.NewDecoder(f).Decode(&data); err != nil || data.Version != persistentCacheVersion {
+		// a broken or outdated file is replaced on Close
+		return c, nil
+	}
+	c.data = data
+	return c, nil
+}
+
+// ags returns the cached AGs, nil when they are not cached
+func (c *persistentCache) ags() []AG {
+	if c == nil {
+		return nil
+	}
+	return c.data.AGs
+}
+
+func (c *persistentCache) setAGs(ags []AG) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.AGs = ags
+	c.dirty = true
+}
+
+func (c *persistentCache) inode(ino uint64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf, ok := c.data.Inodes[ino]
+	return buf, ok
+}
+
+func (c *persistentCache) addInode(ino uint64, buf []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.Inodes[ino] = buf
+	c.dirty = true
+}
+
+func (c *persistentCache) block(n int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.data.Blocks[n]
+	return b, ok
+}
+
+func (c *persistentCache) addBlock(n int64, b []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.Blocks[n] = b
+	c.dirty = true
+}
+
+func (c *persistentCache) dir(ino uint64) ([]Entry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.data.Dirs[ino]
+	if !ok {
+		return nil, false
+	}
+	entries := make([]Entry, len(cached))
+	for i, e := range cached {
+		entries[i] = e
+	}
+	return entries, true
+}
+
+func (c *persistentCache) addDir(ino uint64, entries []Entry) {
+	if c == nil {
+		return
+	}
+	cached := make([]Dir2DataEntry, len(entries))
+	for i, e := range entries {
+		cached[i] = Dir2DataEntry{
+			Inumber:   e.InodeNumber(),
+			Namelen:   uint8(len(e.Name())),
+			EntryName: e.Name(),
+			Filetype:  e.FileType(),
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.Dirs[ino] = cached
+	c.dirty = true
+}
+
+// save writes the cache file when metadata was added, and removes the files
+// of older generations of the image
+func (c *persistentCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	dir, name := filepath.Split(c.path)
+	f, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return xerrors.Errorf("failed to create the cache file: %w", err)
+	}
+	if err := gob.NewEncoder(f).Encode(&c.data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return xerrors.Errorf("failed to write the cache file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return xerrors.Errorf("failed to write the cache file: %w", err)
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		os.Remove(f.Name())
+		return xerrors.Errorf("failed to write the cache file: %w", err)
+	}
+	c.dirty = false
+
+	// the UUID and the generation are separated by the last dash
+	uuid := name[:len(name)-len("-0123456789abcdef.gob")]
+	if old, err := filepath.Glob(filepath.Join(dir, uuid+"-*.gob")); err == nil {
+		for _, p := range old {
+			if p != c.path {
+				os.Remove(p)
+			}
+		}
+	}
+	return nil
+}
+
+// readMetadataBlock reads the metadata block n through the persistent cache
+func (xfs *FileSystem) readMetadataBlock(n int64) ([]byte, error) {
+	if b, ok := xfs.persistent.block(n); ok {
+		return b, nil
+	}
+	b, err := xfs.readBlock(n, 1)
+	if err != nil {
+		return nil, err
+	}
+	xfs.persistent.addBlock(n, b)
+	return b, nil
+}
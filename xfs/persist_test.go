@@ -0,0 +1,91 @@
+package xfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// countingReaderAt counts the bytes read from r
+type countingReaderAt struct {
+	r     io.ReaderAt
+	bytes int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&c.bytes, int64(len(p)))
+	return c.r.ReadAt(p, off)
+}
+
+func TestWithPersistentCache(t *testing.T) {
+	img, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	// scan walks the image, returning the paths with their sizes, the content
+	// of etc/os-release and the number of bytes read
+	scan := func(img []byte) ([]string, []byte, int64) {
+		t.Helper()
+		r := &countingReaderAt{r: bytes.NewReader(img)}
+		fileSystem, err := NewFS(*io.NewSectionReader(r, 0, int64(len(img))), nil, WithPersistentCache(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		err = fs.WalkDir(fileSystem, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			paths = append(paths, p+":"+info.Mode().String())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := fileSystem.ReadFile("etc/os-release")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fileSystem.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return paths, content, r.bytes
+	}
+
+	coldPaths, coldContent, cold := scan(img)
+	files, err := filepath.Glob(filepath.Join(dir, "*.gob"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected a cache file, actual %v %v", files, err)
+	}
+	warmPaths, warmContent, warm := scan(img)
+	if !reflect.DeepEqual(warmPaths, coldPaths) || !bytes.Equal(warmContent, coldContent) {
+		t.Error("the warm scan differs from the cold one")
+	}
+	// the log state and file content are still read from the image
+	if warm*10 > cold {
+		t.Errorf("expected the warm scan to read a fraction of the %d bytes of the cold one, actual %d", cold, warm)
+	}
+
+	// a modified superblock is a new generation, replacing the cache file
+	modified := append([]byte(nil), img...)
+	// sb_fdblocks
+	modified[0x90]++
+	if _, _, read := scan(modified); read < cold/2 {
+		t.Errorf("expected a cold scan of the modified image, actual %d bytes read", read)
+	}
+	updated, err := filepath.Glob(filepath.Join(dir, "*.gob"))
+	if err != nil || len(updated) != 1 || updated[0] == files[0] {
+		t.Errorf("expected the cache file to be replaced, actual %v %v", updated, err)
+	}
+}
@@ -0,0 +1,32 @@
+package xfs
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// ReadBlock returns the raw file system block n, n is a file system block
+// number as in bmbt records, with the AG number above the low sb_agblklog bits.
+func (xfs *FileSystem) ReadBlock(n uint64) ([]byte, error) {
+	if err := xfs.validateExtent(n, 1); err != nil {
+		return nil, xfs.wrapBlockError(n, err)
+	}
+	b, err := xfs.readBlock(xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(n), 1)
+	if err != nil {
+		return nil, xfs.wrapBlockError(n, err)
+	}
+	return b, nil
+}
+
+// ReadRawInode returns the sb_inodesize bytes of the inode ino as stored on
+// disk, only the inode number is verified so corrupted inodes can be inspected.
+func (xfs *FileSystem) ReadRawInode(ino uint64) ([]byte, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	if err := sb.verifyInodeNumber(ino); err != nil {
+		return nil, xfs.wrapInodeError(ino, err)
+	}
+	buf := make([]byte, sb.Inodesize)
+	if _, err := xfs.r.ReadAt(buf, int64(sb.InodeAbsOffset(ino))); err != nil {
+		return nil, xfs.wrapInodeError(ino, xerrors.Errorf("failed to read inode: %w", err))
+	}
+	return buf, nil
+}
@@ -0,0 +1,96 @@
+package xfs_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+func TestFileSystemRawAccess(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := fileSystem.PrimaryAG.SuperBlock
+
+	block, err := fileSystem.ReadBlock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block) != int(sb.BlockSize) || string(block[:4]) != "XFSB" {
+		t.Errorf("expected the superblock, actual %q", block[:4])
+	}
+	if _, err := fileSystem.ReadBlock(sb.Dblocks); !xerrors.Is(err, xfs.ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+
+	inode, err := fileSystem.ReadRawInode(sb.Rootino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inode) != int(sb.Inodesize) || string(inode[:2]) != "IN" {
+		t.Errorf("expected an inode, actual %q", inode[:2])
+	}
+	if _, err := fileSystem.ReadRawInode(1 << 40); !xerrors.Is(err, xfs.ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+
+	// etc/os-release
+	file, err := fileSystem.OpenInode(20453)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedContent, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, expectedContent) {
+		t.Errorf("unexpected content %q", content)
+	}
+	if _, err := fileSystem.OpenInode(sb.Rootino); !xerrors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected %v for a directory, actual %v", fs.ErrInvalid, err)
+	}
+
+	expected, err := fileSystem.RawReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fileSystem.RawReadDirInode(sb.Rootino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d entries, actual %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if actual[i].Name() != expected[i].Name() || actual[i].InodeNumber() != expected[i].InodeNumber() {
+			t.Errorf("expected %s %d, actual %s %d", expected[i].Name(), expected[i].InodeNumber(), actual[i].Name(), actual[i].InodeNumber())
+		}
+	}
+	osRelease, err := fileSystem.Lstat("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.RawReadDirInode(osRelease.(xfs.FileInfo).Ino()); !xerrors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected ErrInvalid, actual %v", err)
+	}
+}
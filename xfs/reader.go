@@ -0,0 +1,210 @@
+package xfs
+
+import (
+	"io"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// SizeReaderAt is a random access disk image of known size, the interface to
+// stack this package on other readers. Each layer, a remote object, the
+// virtual disk of a qcow2, VMDK or VHD image read by the qcow2, vmdk and vhd
+// packages, a partition, is a SizeReaderAt reading through the one below:
+//
+//	object := s3ReaderAt(bucket, key)           // SizeReaderAt of the object
+//	disk, err := qcow2.Open(object)             // SizeReaderAt of the virtual disk
+//	part := io.NewSectionReader(disk, off, n)   // the XFS partition
+//	fileSystem, err := xfs.OpenReaderAt(part, nil)
+//
+// *io.SectionReader, *bytes.Reader and *strings.Reader implement it, readers
+// of a known size such as *os.File are wrapped with io.NewSectionReader.
+// The file system reads only the blocks it needs with ReadAt, which must be
+// safe for concurrent use when the FileSystem is shared between goroutines.
+type SizeReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// OpenReaderAt opens the file system occupying the whole of r, as NewFS does
+func OpenReaderAt(r SizeReaderAt, cache Cache[string, any], opts ...Option) (*FileSystem, error) {
+	return NewFS(*io.NewSectionReader(r, 0, r.Size()), cache, opts...)
+}
+
+// LinearSegment is a piece of a device assembled by Linear, the Size bytes at
+// Offset of the device are read from R at Start as in a device-mapper linear
+// target
+type LinearSegment struct {
+	Offset int64
+	Size   int64
+	R      io.ReaderAt
+	Start  int64
+}
+
+// linearReader is a device assembled from segments sorted by offset
+type linearReader struct {
+	size     int64
+	segments []LinearSegment
+}
+
+// Linear assembles a device of size bytes from segments given in any order,
+// for file systems spanning several disks or imaged in pieces at known
+// offsets. Ranges without a segment read as zeros, overlapping segments and
+// segments beyond size are errors.
+func Linear(size int64, segments ...LinearSegment) (SizeReaderAt, error) {
+	segments = append([]LinearSegment(nil), segments...)
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Offset < segments[j].Offset })
+	var end int64
+	for _, s := range segments {
+		switch {
+		case s.Offset < 0 || s.Size <= 0 || s.R == nil:
+			return nil, xerrors.Errorf("invalid segment of %d bytes at %d", s.Size, s.Offset)
+		case s.Offset < end:
+			return nil, xerrors.Errorf("segment at %d overlaps the previous one ending at %d", s.Offset, end)
+		case s.Offset+s.Size > size:
+			return nil, xerrors.Errorf("segment at %d ends at %d, beyond the device of %d bytes", s.Offset, s.Offset+s.Size, size)
+		}
+		end = s.Offset + s.Size
+	}
+	return &linearReader{size: size, segments: segments}, nil
+}
+
+// Concat assembles readers back to back, as the pieces of a split image
+func Concat(readers ...SizeReaderAt) SizeReaderAt {
+	l := &linearReader{}
+	for _, r := range readers {
+		if r.Size() == 0 {
+			continue
+		}
+		l.segments = append(l.segments, LinearSegment{Offset: l.size, Size: r.Size(), R: r})
+		l.size += r.Size()
+	}
+	return l
+}
+
+func (l *linearReader) Size() int64 { return l.size }
+
+func (l *linearReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	var n int
+	for n < len(p) {
+		if off >= l.size {
+			return n, io.EOF
+		}
+		chunk := p[n:]
+		if rest := l.size - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		// the first segment ending after off
+		i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].Offset+l.segments[i].Size > off })
+		if i == len(l.segments) || l.segments[i].Offset > off {
+			// a hole up to the next segment
+			if i < len(l.segments) && int64(len(chunk)) > l.segments[i].Offset-off {
+				chunk = chunk[:l.segments[i].Offset-off]
+			}
+			for j := range chunk {
+				chunk[j] = 0
+			}
+			n += len(chunk)
+			off += int64(len(chunk))
+			continue
+		}
+		s := l.segments[i]
+		if rest := s.Offset + s.Size - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		m, err := s.R.ReadAt(chunk, s.Start+off-s.Offset)
+		n += m
+		off += int64(m)
+		if m < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, xerrors.Errorf("failed to read the segment at %d: %w", s.Offset, err)
+		}
+	}
+	return n, nil
+}
+
+// Transform stacks a layer on the reader of the image, such as a decoder of
+// a compressed, sparse or encrypted image, see WithTransform
+type Transform func(r SizeReaderAt) (SizeReaderAt, error)
+
+// UnitFunc decodes in place the unit of an image read at off, the last unit
+// is shorter when the image is not a whole number of units
+type UnitFunc func(unit []byte, off int64) error
+
+// unitReader reads r by whole units decoded with fn
+type unitReader struct {
+	r        SizeReaderAt
+	unitSize int64
+	fn       UnitFunc
+}
+
+// TransformUnits returns a Transform reading the image by units of unitSize
+// bytes at multiples of unitSize, each decoded with fn, for images encoded
+// in place such as sectors encrypted at rest and tweaked by their number
+func TransformUnits(unitSize int64, fn UnitFunc) Transform {
+	return func(r SizeReaderAt) (SizeReaderAt, error) {
+		if unitSize <= 0 {
+			return nil, xerrors.Errorf("invalid unit size %d", unitSize)
+		}
+		return &unitReader{r: r, unitSize: unitSize, fn: fn}, nil
+	}
+}
+
+func (u *unitReader) Size() int64 { return u.r.Size() }
+
+func (u *unitReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset %d", off)
+	}
+	size := u.r.Size()
+	// the units are read up to about 1 MiB at a time
+	chunkSize := (1 << 20) / u.unitSize * u.unitSize
+	if chunkSize == 0 {
+		chunkSize = u.unitSize
+	}
+	var buf []byte
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= size {
+			return n, io.EOF
+		}
+		start := pos - pos%u.unitSize
+		end := pos + int64(len(p)-n)
+		if rem := end % u.unitSize; rem != 0 {
+			end += u.unitSize - rem
+		}
+		if end-start > chunkSize {
+			end = start + chunkSize
+		}
+		if end > size {
+			end = size
+		}
+		if buf == nil {
+			buf = make([]byte, end-start)
+		}
+		chunk := buf[:end-start]
+		if m, err := u.r.ReadAt(chunk, start); m < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, xerrors.Errorf("failed to read %d bytes at %d: %w", len(chunk), start, err)
+		}
+		for unit := int64(0); unit < int64(len(chunk)); unit += u.unitSize {
+			b := chunk[unit:]
+			if int64(len(b)) > u.unitSize {
+				b = b[:u.unitSize]
+			}
+			if err := u.fn(b, start+unit); err != nil {
+				return n, xerrors.Errorf("failed to decode the unit at %d: %w", start+unit, err)
+			}
+		}
+		n += copy(p[n:], chunk[pos-start:])
+	}
+	return n, nil
+}
@@ -0,0 +1,279 @@
+package xfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// remoteReaderAt stands for an object store, it counts the requests
+type remoteReaderAt struct {
+	*bytes.Reader
+	requests int64
+}
+
+func (r *remoteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&r.requests, 1)
+	return r.Reader.ReadAt(p, off)
+}
+
+// clusterDisk is a sparse virtual disk in the manner of qcow2, VMDK and
+// dynamic VHD: clusters of the virtual disk are stored in any order in the
+// underlying image, unallocated clusters read as zeros
+type clusterDisk struct {
+	image       SizeReaderAt
+	clusterSize int64
+	size        int64
+	// table maps the virtual clusters to their offset in image, 0 is unallocated
+	table []int64
+}
+
+func (d *clusterDisk) Size() int64 { return d.size }
+
+func (d *clusterDisk) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		cluster, within := off/d.clusterSize, off%d.clusterSize
+		chunk := p[n:]
+		if rest := d.clusterSize - within; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if rest := d.size - off; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+		if d.table[cluster] == 0 {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else if _, err := d.image.ReadAt(chunk, d.table[cluster]+within); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	return n, nil
+}
+
+// newClusterImage packs the non-zero clusters of disk after a header cluster
+// and returns the packed image with the cluster table
+func newClusterImage(disk []byte, clusterSize int64) ([]byte, []int64) {
+	image := make([]byte, clusterSize)
+	table := make([]int64, (int64(len(disk))+clusterSize-1)/clusterSize)
+	zero := make([]byte, clusterSize)
+	// store the clusters backwards, so virtual and image offsets differ
+	for i := len(table) - 1; i >= 0; i-- {
+		cluster := disk[int64(i)*clusterSize:]
+		if int64(len(cluster)) > clusterSize {
+			cluster = cluster[:clusterSize]
+		}
+		if bytes.Equal(cluster, zero[:len(cluster)]) {
+			continue
+		}
+		table[i] = int64(len(image))
+		image = append(image, cluster...)
+		image = append(image, zero[len(cluster):]...)
+	}
+	return image, table
+}
+
+func TestOpenReaderAtNested(t *testing.T) {
+	raw, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// XFS in a partition at 1 MiB of a sparse virtual disk stored remotely
+	const partOffset, clusterSize = 1 << 20, 64 << 10
+	disk := make([]byte, partOffset+len(raw)+partOffset)
+	copy(disk[partOffset:], raw)
+	image, table := newClusterImage(disk, clusterSize)
+	if len(image) >= len(disk) {
+		t.Fatalf("the fixture is not sparse: %d bytes for a disk of %d", len(image), len(disk))
+	}
+
+	remote := &remoteReaderAt{Reader: bytes.NewReader(image)}
+	virtual := &clusterDisk{image: remote, clusterSize: clusterSize, size: int64(len(disk)), table: table}
+	part := io.NewSectionReader(virtual, partOffset, int64(len(raw)))
+	fileSystem, err := OpenReaderAt(part, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("unexpected content %q", actual)
+	}
+	if remote.requests == 0 {
+		t.Error("the remote image was not read")
+	}
+
+	// the same layers answer Check, for probing images of unknown content
+	if !Check(io.NewSectionReader(part, 0, part.Size())) {
+		t.Error("Check failed on the nested image")
+	}
+	if Check(io.NewSectionReader(virtual, 0, virtual.Size())) {
+		t.Error("Check succeeded on the partitioned disk")
+	}
+}
+
+func TestLinear(t *testing.T) {
+	raw, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the image split in three pieces of uneven sizes
+	a, b := 3<<20+512, 11<<20
+	pieces := [][]byte{raw[:a], raw[a:b], raw[b:]}
+
+	// a disk image the file system extends onto, before the second piece
+	disk := make([]byte, 1<<20+len(pieces[1]))
+	copy(disk[1<<20:], pieces[1])
+	linear, err := Linear(int64(len(raw))+4096,
+		LinearSegment{Offset: int64(b), Size: int64(len(pieces[2])), R: bytes.NewReader(pieces[2])},
+		LinearSegment{Offset: 0, Size: int64(a), R: bytes.NewReader(pieces[0])},
+		LinearSegment{Offset: int64(a), Size: int64(len(pieces[1])), R: bytes.NewReader(disk), Start: 1 << 20},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, r := range map[string]SizeReaderAt{
+		"concat": Concat(bytes.NewReader(pieces[0]), bytes.NewReader(nil), bytes.NewReader(pieces[1]), bytes.NewReader(pieces[2])),
+		"linear": linear,
+	} {
+		t.Run(name, func(t *testing.T) {
+			fileSystem, err := OpenReaderAt(r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := fileSystem.ReadFile("etc/os-release")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("unexpected content %q", actual)
+			}
+
+			// a read across the pieces, then up to the end
+			buf := make([]byte, b-a+1024)
+			if _, err := r.ReadAt(buf, int64(a)-512); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, raw[a-512:b+512]) {
+				t.Error("unexpected content across the pieces")
+			}
+			n, err := r.ReadAt(buf, r.Size()-100)
+			if n != 100 || err != io.EOF {
+				t.Errorf("expected 100 bytes and EOF at the end, actual %d %v", n, err)
+			}
+		})
+	}
+
+	// the range after the last segment is a hole
+	buf := make([]byte, 4096)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	if _, err := linear.ReadAt(buf, int64(len(raw))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, make([]byte, 4096)) {
+		t.Error("the hole does not read as zeros")
+	}
+
+	for name, segments := range map[string][]LinearSegment{
+		"overlap": {
+			{Offset: 0, Size: 1024, R: bytes.NewReader(raw)},
+			{Offset: 512, Size: 1024, R: bytes.NewReader(raw)},
+		},
+		"beyond the end": {{Offset: int64(len(raw)), Size: 8192, R: bytes.NewReader(raw)}},
+		"without reader": {{Offset: 0, Size: 1024}},
+	} {
+		if _, err := Linear(int64(len(raw))+4096, segments...); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWithTransform(t *testing.T) {
+	raw, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// xorSectors stands for an encryption at rest, tweaked by the sector number
+	xorSectors := func(unit []byte, off int64) error {
+		key := byte(off/512) | 1
+		for i := range unit {
+			unit[i] ^= key
+		}
+		return nil
+	}
+	encrypted := append([]byte(nil), raw...)
+	for off := 0; off < len(encrypted); off += 512 {
+		xorSectors(encrypted[off:off+512], int64(off))
+	}
+	if Check(bytes.NewReader(encrypted)) {
+		t.Fatal("Check succeeded on the encrypted image")
+	}
+
+	r := &remoteReaderAt{Reader: bytes.NewReader(encrypted)}
+	var decoded SizeReaderAt
+	fileSystem, err := OpenReaderAt(r, nil,
+		WithTransform(TransformUnits(512, xorSectors)),
+		// a second layer sees the decoded image
+		WithTransform(func(r SizeReaderAt) (SizeReaderAt, error) {
+			decoded = r
+			return r, nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("unexpected content %q", actual)
+	}
+
+	// unaligned reads across units and up to the end
+	for _, tt := range []struct{ off, n int64 }{{100, 10}, {500, 2000}, {0, 3 << 20}, {int64(len(raw)) - 700, 700}} {
+		buf := make([]byte, tt.n)
+		if _, err := decoded.ReadAt(buf, tt.off); err != nil {
+			t.Fatalf("read of %d bytes at %d: %v", tt.n, tt.off, err)
+		}
+		if !bytes.Equal(buf, raw[tt.off:tt.off+tt.n]) {
+			t.Errorf("unexpected content of %d bytes at %d", tt.n, tt.off)
+		}
+	}
+	if n, err := decoded.ReadAt(make([]byte, 100), int64(len(raw))-50); n != 50 || err != io.EOF {
+		t.Errorf("expected 50 bytes and EOF at the end, actual %d %v", n, err)
+	}
+
+	failing := func(r SizeReaderAt) (SizeReaderAt, error) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := OpenReaderAt(r, nil, WithTransform(failing)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected the error of the transform, actual %v", err)
+	}
+}
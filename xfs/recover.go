@@ -0,0 +1,215 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// DeletedInode is a free inode, whose data fork still holds the extent
+// records of the file it belonged to. The kernel clears the mode, size and
+// extent count when it frees an inode, but leaves the records behind.
+type DeletedInode struct {
+	Ino uint64
+	// Core is the inode core of the free inode, Ctime is the time of deletion
+	Core InodeCore
+	// Extents are the surviving extent records sorted by offset, their blocks
+	// may have been reused since the inode was freed
+	Extents []BmbtIrec
+}
+
+// Size returns the size of the recoverable data. The kernel clears the size
+// of the file, it is rounded up to whole blocks then, a size left in the
+// inode core bounds the data.
+func (d DeletedInode) Size(blockSize uint32) int64 {
+	if len(d.Extents) == 0 {
+		return 0
+	}
+	last := d.Extents[len(d.Extents)-1]
+	size := int64(last.StartOff+last.BlockCount) * int64(blockSize)
+	if d.Core.Size > 0 && d.Core.Size < uint64(size) {
+		return int64(d.Core.Size)
+	}
+	return size
+}
+
+// DeletionTime returns the ctime of the inode, which is set when it is freed
+func (d DeletedInode) DeletionTime() time.Time {
+	return d.Core.timestamp(d.Core.Ctime)
+}
+
+// DeletedInodes scans the free inodes of the inode btrees and returns the ones
+// with extent records of a regular file left in their data fork.
+func (xfs *FileSystem) DeletedInodes() ([]DeletedInode, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	var deleted []DeletedInode
+	for agNumber := range xfs.AGs {
+		chunks, err := xfs.inodeChunks(uint64(agNumber))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read the inode btree of AG %d: %w", agNumber, err)
+		}
+		for _, chunk := range chunks {
+			for i := uint64(0); i < 64; i++ {
+				if chunk.Free&(1<<i) == 0 {
+					continue
+				}
+				ino := uint64(agNumber)<<(sb.Agblklog+sb.Inopblog) | (uint64(chunk.Startino) + i)
+				d, ok, err := xfs.deletedInode(ino)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					deleted = append(deleted, d)
+				}
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// deletedInode reads the free inode ino, ok is false when it does not hold
+// extent records, as inodes that were never used or sparse inode holes, or
+// when they are not the ones of a regular file.
+func (xfs *FileSystem) deletedInode(ino uint64) (DeletedInode, bool, error) {
+	b, err := xfs.ReadRawInode(ino)
+	if err != nil {
+		return DeletedInode{}, false, err
+	}
+	d := DeletedInode{Ino: ino}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &d.Core); err != nil {
+		return DeletedInode{}, false, xerrors.Errorf("failed to read InodeCore: %w", err)
+	}
+	if d.Core.Magic != XFS_DINODE_MAGIC || d.Core.Mode != 0 || d.Core.Format != XFS_DINODE_FMT_EXTENTS {
+		return DeletedInode{}, false, nil
+	}
+	if d.Core.Version < 3 {
+		d.Core.clearV3Fields()
+	}
+
+	// the records are read up to the first one, that is empty or invalid
+	fork := b[xfs.PrimaryAG.SuperBlock.InodeCoreSize():]
+	if n := xfs.DataForkSize(d.Core.Forkoff); n < len(fork) {
+		fork = fork[:n]
+	}
+	for len(fork) >= 16 {
+		rec := BmbtRec{L0: binary.BigEndian.Uint64(fork), L1: binary.BigEndian.Uint64(fork[8:])}
+		fork = fork[16:]
+		if rec.L0 == 0 && rec.L1 == 0 {
+			break
+		}
+		p := rec.Unpack()
+		if xfs.validateExtent(p.StartBlock, p.BlockCount) != nil {
+			break
+		}
+		if n := len(d.Extents); n > 0 && d.Extents[n-1].StartOff+d.Extents[n-1].BlockCount > p.StartOff {
+			break
+		}
+		d.Extents = append(d.Extents, p)
+	}
+	sort.Slice(d.Extents, func(i, j int) bool { return d.Extents[i].StartOff < d.Extents[j].StartOff })
+	if len(d.Extents) == 0 {
+		return DeletedInode{}, false, nil
+	}
+	regular, err := xfs.deletedRegular(d)
+	if err != nil {
+		return DeletedInode{}, false, err
+	}
+	return d, regular, nil
+}
+
+// deletedRegular reports whether the extents of d are the ones of a regular
+// file, the mode of a free inode is cleared. Directories map their leaf and
+// free blocks from XFS_DIR2_LEAF_OFFSET, and the first block of directories
+// and symlinks starts with a magic number.
+func (xfs *FileSystem) deletedRegular(d DeletedInode) (bool, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	last := d.Extents[len(d.Extents)-1]
+	if int64(last.StartOff+last.BlockCount)*int64(sb.BlockSize) > XFS_DIR2_LEAF_OFFSET {
+		return false, nil
+	}
+	first := d.Extents[0]
+	if first.StartOff != 0 || first.State == XFS_EXT_UNWRITTEN {
+		return true, nil
+	}
+	b, err := xfs.readBlock(sb.BlockToPhysicalOffset(first.StartBlock), 1)
+	if err != nil {
+		return false, xfs.wrapInodeError(d.Ino, err)
+	}
+	switch binary.BigEndian.Uint32(b) {
+	case XFS_DIR2_BLOCK_MAGIC, XFS_DIR3_BLOCK_MAGIC, XFS_DIR2_DATA_MAGIC, XFS_DIR3_DATA_MAGIC, XFS_SYMLINK_MAGIC:
+		return false, nil
+	}
+	return true, nil
+}
+
+// RecoverDeleted writes the blocks of the extents of d to w in file order up
+// to d.Size, unwritten extents are written as zeros. Holes are skipped with
+// Seek when w is an io.Seeker and written as zeros otherwise. It returns the
+// number of bytes written, holes included.
+func (xfs *FileSystem) RecoverDeleted(d DeletedInode, w io.Writer) (int64, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	blockSize := int64(sb.BlockSize)
+	size := d.Size(sb.BlockSize)
+	zero := make([]byte, blockSize)
+
+	var written int64
+	for _, e := range d.Extents {
+		start := int64(e.StartOff) * blockSize
+		if start >= size {
+			break
+		}
+		if n, err := writeHole(w, start-written); err != nil {
+			return written + n, err
+		}
+		written = start
+		for i := int64(0); i < int64(e.BlockCount) && written < size; i++ {
+			b := zero
+			if e.State != XFS_EXT_UNWRITTEN {
+				var err error
+				if b, err = xfs.readBlock(sb.BlockToPhysicalOffset(e.StartBlock)+i, 1); err != nil {
+					return written, xfs.wrapInodeError(d.Ino, err)
+				}
+			}
+			if rest := size - written; rest < int64(len(b)) {
+				b = b[:rest]
+			}
+			n, err := w.Write(b)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeHole skips n bytes of w with Seek, or writes them as zeros in chunks
+func writeHole(w io.Writer, n int64) (int64, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+	if s, ok := w.(io.Seeker); ok {
+		if _, err := s.Seek(n, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	zero := make([]byte, 1<<16)
+	var written int64
+	for written < n {
+		chunk := zero
+		if rest := n - written; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		m, err := w.Write(chunk)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
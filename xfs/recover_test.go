@@ -0,0 +1,127 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeletedInodes(t *testing.T) {
+	img, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(b []byte) *FileSystem {
+		fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileSystem
+	}
+	fileSystem := open(img)
+	deleted, err := fileSystem.DeletedInodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("expected no deleted inodes, actual %+v", deleted)
+	}
+
+	// free the unused inode 20454 the way the kernel does, the extent
+	// records of the first block of etc/os-release and a block after a
+	// hole are left behind
+	const ino = 20454
+	offset := fileSystem.PrimaryAG.SuperBlock.InodeAbsOffset(ino)
+	inode := img[offset : offset+512]
+	inode[5] = XFS_DINODE_FMT_EXTENTS
+	for i, rec := range []BmbtRec{packBmbtRec(0, 2784, 1), packBmbtRec(2, 2784, 1)} {
+		binary.BigEndian.PutUint64(inode[INODEV3_SIZE+i*16:], rec.L0)
+		binary.BigEndian.PutUint64(inode[INODEV3_SIZE+i*16+8:], rec.L1)
+	}
+	// an invalid record ends the list
+	binary.BigEndian.PutUint64(inode[INODEV3_SIZE+40:], 1<<60)
+
+	fileSystem = open(img)
+	deleted, err = fileSystem.DeletedInodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Ino != ino || len(deleted[0].Extents) != 2 {
+		t.Fatalf("unexpected deleted inodes %+v", deleted)
+	}
+	if size := deleted[0].Size(4096); size != 3*4096 {
+		t.Errorf("expected size %d, actual %d", 3*4096, size)
+	}
+
+	var buf bytes.Buffer
+	n, err := fileSystem.RecoverDeleted(deleted[0], &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3*4096 || int64(buf.Len()) != n {
+		t.Fatalf("expected %d bytes, actual %d", 3*4096, n)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, expected) || !bytes.HasPrefix(b[2*4096:], expected) {
+		t.Errorf("unexpected content %q", b[:len(expected)])
+	}
+	if !bytes.Equal(b[4096:2*4096], make([]byte, 4096)) {
+		t.Errorf("expected the hole as zeros")
+	}
+
+	// holes are skipped when the writer seeks
+	f, err := os.Create(filepath.Join(t.TempDir(), "recovered"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := fileSystem.RecoverDeleted(deleted[0], f); err != nil || n != 3*4096 {
+		t.Fatalf("expected %d bytes, actual %d, %v", 3*4096, n, err)
+	}
+	if actual, err := os.ReadFile(f.Name()); err != nil || !bytes.Equal(actual, b) {
+		t.Errorf("unexpected content of the seeking writer, %v", err)
+	}
+
+	deletedWith := func(patch func(inode []byte)) []DeletedInode {
+		patched := append([]byte(nil), img...)
+		patch(patched[offset : offset+512])
+		deleted, err := open(patched).DeletedInodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deleted
+	}
+
+	// a size left in the inode core bounds the data
+	const size = 2*4096 + 100
+	deleted = deletedWith(func(inode []byte) { binary.BigEndian.PutUint64(inode[56:], size) })
+	if len(deleted) != 1 || deleted[0].Size(4096) != size {
+		t.Fatalf("expected size %d, actual %+v", size, deleted)
+	}
+	buf.Reset()
+	if n, err := fileSystem.RecoverDeleted(deleted[0], &buf); err != nil || n != size || buf.Len() != size {
+		t.Fatalf("expected %d bytes, actual %d, %v", size, n, err)
+	}
+
+	// the extents of directories are not the ones of a regular file, the
+	// single data block of fmt_leaf_directories and a leaf block
+	for name, rec := range map[string]BmbtRec{
+		"data block": packBmbtRec(0, 1383, 1),
+		"leaf block": packBmbtRec(uint64(XFS_DIR2_LEAF_OFFSET/4096), 2784, 1),
+	} {
+		deleted = deletedWith(func(inode []byte) {
+			binary.BigEndian.PutUint64(inode[INODEV3_SIZE:], rec.L0)
+			binary.BigEndian.PutUint64(inode[INODEV3_SIZE+8:], rec.L1)
+		})
+		if len(deleted) != 0 {
+			t.Errorf("%s: expected no deleted inodes, actual %+v", name, deleted)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package xfs
 
+import (
+	"fmt"
+	"math/bits"
+)
+
 type SuperBlock struct {
 	Magicnum   uint32
 	BlockSize  uint32
@@ -62,18 +67,34 @@ type SuperBlock struct {
 }
 
 // return (AG number), (Inode Block), (Inode Offset)
+// An inode number is the AG number, the AG block number of sb_agblklog bits
+// and the index in the block of sb_inopblog bits, as XFS_INO_TO_AGNO,
+// XFS_INO_TO_AGBNO and XFS_INO_TO_OFFSET.
 func (sb SuperBlock) InodeOffset(inodeNumber uint64) (int, uint64, uint64) {
-	offsetAddress := sb.Inopblog + sb.Agblklog
-	lowMask := 1<<(offsetAddress) - 1
-	AGNumber := inodeNumber >> uint32(offsetAddress)
-
-	relativeInodeNumber := inodeNumber & uint64(lowMask)
-	InodeBlock := relativeInodeNumber / uint64(sb.Inopblock)
-	InodeOffset := relativeInodeNumber % uint64(sb.Inopblock)
+	agino := inodeNumber & Mask64Lo(int64(sb.Agblklog)+int64(sb.Inopblog))
+	AGNumber := inodeNumber >> (uint64(sb.Agblklog) + uint64(sb.Inopblog))
+	InodeBlock := agino >> sb.Inopblog
+	InodeOffset := agino & Mask64Lo(int64(sb.Inopblog))
 
 	return int(AGNumber), InodeBlock, InodeOffset
 }
 
+// verifyInodeNumber returns a *CorruptedError, when ino does not address an
+// inode inside the AGs of the file system, as xfs_verify_ino does.
+func (sb SuperBlock) verifyInodeNumber(ino uint64) error {
+	if ino > XFS_MAXINUMBER {
+		return newCorruptedError("inode number", -1, "%d exceeds the maximum inode number", ino)
+	}
+	agNumber, agBlock, _ := sb.InodeOffset(ino)
+	if uint64(agNumber) >= uint64(sb.Agcount) {
+		return newCorruptedError("inode number", -1, "%d is in AG %d, beyond AG count %d", ino, agNumber, sb.Agcount)
+	}
+	if agBlock >= sb.AGBlocks(uint64(agNumber)) {
+		return newCorruptedError("inode number", -1, "%d is in block %d, beyond AG size %d of AG %d", ino, agBlock, sb.AGBlocks(uint64(agNumber)), agNumber)
+	}
+	return nil
+}
+
 // return Offset
 func (sb SuperBlock) InodeAbsOffset(inodeNumber uint64) uint64 {
 	agNumber, blockCount, inodeOffset := sb.InodeOffset(inodeNumber)
@@ -96,3 +117,100 @@ func (sb SuperBlock) BlockToAgBlockNumber(n uint64) uint64 {
 func (sb SuperBlock) BlockToPhysicalOffset(n uint64) int64 {
 	return int64(sb.BlockToAgNumber(n)*uint64(sb.Agblocks) + sb.BlockToAgBlockNumber(n))
 }
+
+// IsV5 reports whether the file system is the v5 (CRC enabled) format, v5
+// file systems only have v3 inodes, older ones have v1 or v2 inodes.
+func (sb SuperBlock) IsV5() bool {
+	return sb.Versionnum&XFS_SB_VERSION_NUMBITS == XFS_SB_VERSION_5
+}
+
+// InodeCoreSize returns the size of the inode core, the data fork follows it
+func (sb SuperBlock) InodeCoreSize() int {
+	if sb.IsV5() {
+		return INODEV3_SIZE
+	}
+	return INODEV2_SIZE
+}
+
+// AGBlocks returns the number of blocks in the AG agNumber, the last AG is
+// shorter than agblocks when dblocks is not a multiple of it.
+func (sb SuperBlock) AGBlocks(agNumber uint64) uint64 {
+	if agNumber >= uint64(sb.Agcount) {
+		return 0
+	}
+	if agNumber == uint64(sb.Agcount)-1 {
+		return sb.Dblocks - agNumber*uint64(sb.Agblocks)
+	}
+	return uint64(sb.Agblocks)
+}
+
+// validate checks the geometry fields used for offset calculations, corrupt
+// values would otherwise cause divide by zero or huge allocations.
+// https://github.com/torvalds/linux/blob/v6.1/fs/xfs/libxfs/xfs_sb.c#L340
+func (sb SuperBlock) validate() error {
+	if !isPowerOfTwo(uint64(sb.Sectsize)) || sb.Sectsize < XFS_MIN_SECTORSIZE || sb.Sectsize > XFS_MAX_SECTORSIZE ||
+		uint32(1)<<sb.Sectlog != uint32(sb.Sectsize) {
+		return newCorruptedError("superblock", -1, "invalid sector size %d (log %d)", sb.Sectsize, sb.Sectlog)
+	}
+	if !isPowerOfTwo(uint64(sb.BlockSize)) || sb.BlockSize < XFS_MIN_BLOCKSIZE || sb.BlockSize > XFS_MAX_BLOCKSIZE ||
+		uint64(1)<<sb.Blocklog != uint64(sb.BlockSize) {
+		return newCorruptedError("superblock", -1, "invalid block size %d (log %d)", sb.BlockSize, sb.Blocklog)
+	}
+	if sb.Logsectsize != 0 && (!isPowerOfTwo(uint64(sb.Logsectsize)) || sb.Logsectsize < XFS_MIN_SECTORSIZE ||
+		sb.Logsectsize > XFS_MAX_SECTORSIZE || uint32(1)<<sb.Logsectlog != uint32(sb.Logsectsize)) {
+		return newCorruptedError("superblock", -1, "invalid log sector size %d (log %d)", sb.Logsectsize, sb.Logsectlog)
+	}
+	if int(sb.Blocklog)+int(sb.Dirblklog) > XFS_MAX_BLOCKSIZE_LOG {
+		return newCorruptedError("superblock", -1, "invalid directory block log %d", sb.Dirblklog)
+	}
+	if sb.BlockSize < uint32(sb.Sectsize) {
+		return newCorruptedError("superblock", -1, "block size %d is smaller than sector size %d", sb.BlockSize, sb.Sectsize)
+	}
+	if !isPowerOfTwo(uint64(sb.Inodesize)) || sb.Inodesize < XFS_DINODE_MIN_SIZE || sb.Inodesize > XFS_DINODE_MAX_SIZE ||
+		uint32(1)<<sb.Inodelog != uint32(sb.Inodesize) || uint32(sb.Inodesize) > sb.BlockSize {
+		return newCorruptedError("superblock", -1, "invalid inode size %d (log %d)", sb.Inodesize, sb.Inodelog)
+	}
+	if uint32(sb.Inopblock) != sb.BlockSize/uint32(sb.Inodesize) || sb.Inopblog != sb.Blocklog-sb.Inodelog {
+		return newCorruptedError("superblock", -1, "invalid inodes per block %d (log %d)", sb.Inopblock, sb.Inopblog)
+	}
+	if sb.Agcount == 0 {
+		return newCorruptedError("superblock", -1, "zero AG count")
+	}
+	if sb.Agblocks < XFS_MIN_AG_BLOCKS || int(sb.Agblklog) != bits.Len32(sb.Agblocks-1) {
+		return newCorruptedError("superblock", -1, "invalid AG blocks %d (log %d)", sb.Agblocks, sb.Agblklog)
+	}
+	if sb.Dblocks > uint64(sb.Agcount)*uint64(sb.Agblocks) ||
+		sb.Dblocks < uint64(sb.Agcount-1)*uint64(sb.Agblocks)+XFS_MIN_AG_BLOCKS {
+		return newCorruptedError("superblock", -1, "data blocks %d do not match %d AGs of %d blocks", sb.Dblocks, sb.Agcount, sb.Agblocks)
+	}
+	return nil
+}
+
+// geometryDiff returns the geometry fields, that differ between sb and other.
+// Counters such as icount and fdblocks are only maintained in the primary
+// superblock, so they are not compared.
+func (sb SuperBlock) geometryDiff(other SuperBlock) []string {
+	var diffs []string
+	compare := func(name string, a, b interface{}) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s %v (primary %v)", name, b, a))
+		}
+	}
+	compare("blocksize", sb.BlockSize, other.BlockSize)
+	compare("dblocks", sb.Dblocks, other.Dblocks)
+	compare("uuid", sb.UUID, other.UUID)
+	compare("rootino", sb.Rootino, other.Rootino)
+	compare("agblocks", sb.Agblocks, other.Agblocks)
+	compare("agcount", sb.Agcount, other.Agcount)
+	compare("versionnum", sb.Versionnum, other.Versionnum)
+	compare("sectsize", sb.Sectsize, other.Sectsize)
+	compare("inodesize", sb.Inodesize, other.Inodesize)
+	compare("inopblock", sb.Inopblock, other.Inopblock)
+	compare("agblklog", sb.Agblklog, other.Agblklog)
+	compare("features_incompat", sb.FeaturesIncompat, other.FeaturesIncompat)
+	return diffs
+}
+
+func isPowerOfTwo(n uint64) bool {
+	return n != 0 && n&(n-1) == 0
+}
@@ -1,9 +1,18 @@
 package xfs_test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"golang.org/x/xerrors"
 )
 
 /*
@@ -136,6 +145,24 @@ func TestSuperBlock_InodeOffset(t *testing.T) {
 			expectedInodeBlock:  0,
 			expectedInodeOffset: 0,
 		},
+		{
+			/*
+				63 << 33 | (2 ** 29 + 7) << 3 | 5
+				3 + 30 = 33, the inode number does not fit in 32 bits
+			*/
+			name: "happy path 64-bit inode number",
+			fields: fields{
+				Inopblog:  3,
+				Agblklog:  30,
+				Inopblock: 8,
+			},
+			args: args{
+				inodeNumber: 545460846653,
+			},
+			expectedAgNumber:    63,
+			expectedInodeBlock:  536870919,
+			expectedInodeOffset: 5,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -163,6 +190,7 @@ func TestSuperBlock_InodeAbsOffset(t *testing.T) {
 		Inopblog  uint8
 		Agblklog  uint8
 		Inopblock uint16
+		Agblocks  uint32
 		BlockSize uint32
 		InodeSize uint16
 	}
@@ -189,6 +217,21 @@ func TestSuperBlock_InodeAbsOffset(t *testing.T) {
 			},
 			expectedPhysicalOffset: 32768,
 		},
+		{
+			name: "happy path 64-bit inode number offset",
+			fields: fields{
+				Inopblog:  3,
+				Agblklog:  30,
+				Inopblock: 8,
+				Agblocks:  1 << 30,
+				BlockSize: 4096,
+				InodeSize: 512,
+			},
+			args: args{
+				inodeNumber: 545460846653,
+			},
+			expectedPhysicalOffset: 279275953486336,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -196,6 +239,7 @@ func TestSuperBlock_InodeAbsOffset(t *testing.T) {
 				Inopblock: tt.fields.Inopblock,
 				Inopblog:  tt.fields.Inopblog,
 				Agblklog:  tt.fields.Agblklog,
+				Agblocks:  tt.fields.Agblocks,
 				Inodesize: tt.fields.InodeSize,
 				BlockSize: tt.fields.BlockSize,
 			}
@@ -206,3 +250,244 @@ func TestSuperBlock_InodeAbsOffset(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckCorruptedSuperBlock(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sector := make([]byte, 512)
+	if _, err := io.ReadFull(f, sector); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		modify   func(b []byte)
+		expected bool
+	}{
+		{
+			name:     "valid superblock",
+			modify:   func(b []byte) {},
+			expected: true,
+		},
+		{
+			name:   "zero block size",
+			modify: func(b []byte) { binary.BigEndian.PutUint32(b[4:], 0) },
+		},
+		{
+			name:   "zero AG count",
+			modify: func(b []byte) { binary.BigEndian.PutUint32(b[88:], 0) },
+		},
+		{
+			name:   "zero inodes per block",
+			modify: func(b []byte) { binary.BigEndian.PutUint16(b[106:], 0) },
+		},
+		{
+			name:   "inode size is not power of two",
+			modify: func(b []byte) { binary.BigEndian.PutUint16(b[104:], 300) },
+		},
+		{
+			name:   "data blocks exceed AGs",
+			modify: func(b []byte) { binary.BigEndian.PutUint64(b[8:], 1<<40) },
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, len(sector))
+			copy(b, sector)
+			tt.modify(b)
+			if actual := xfs.Check(bytes.NewReader(b)); actual != tt.expected {
+				t.Fatalf("expected %t, actual %t", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFileSystemVerifySuperBlocks(t *testing.T) {
+	b, err := os.ReadFile("testdata/image40.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFS := func(b []byte) *xfs.FileSystem {
+		fileSystem, err := xfs.NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileSystem
+	}
+
+	if err := newFS(b).VerifySuperBlocks(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// root inode of the AG 1 superblock
+	agOffset := 5116 * 4096
+	binary.BigEndian.PutUint64(b[agOffset+56:], 64)
+	err = newFS(b).VerifySuperBlocks()
+	var corrupted *xfs.CorruptedError
+	if !xerrors.As(err, &corrupted) {
+		t.Fatalf("expected CorruptedError, actual %v", err)
+	}
+	if corrupted.Offset != int64(agOffset) || !strings.Contains(corrupted.Reason, "AG 1: rootino 64 (primary 128)") {
+		t.Errorf("unexpected divergence: %+v", corrupted)
+	}
+}
+
+func TestSuperBlock_AGBlocks(t *testing.T) {
+	testCases := []struct {
+		name     string
+		image    string
+		expected []uint64
+	}{
+		{
+			name:     "single AG",
+			image:    "testdata/image.xfs",
+			expected: []uint64{5111, 0},
+		},
+		{
+			name:     "short last AG",
+			image:    "testdata/image40.xfs",
+			expected: []uint64{5116, 5115, 0},
+		},
+		{
+			name:     "v4 single AG",
+			image:    "testdata/tiny/v4.xfs",
+			expected: []uint64{4096, 0},
+		},
+		{
+			name:     "v4 short last AG",
+			image:    "testdata/tiny/v4-2ag.xfs",
+			expected: []uint64{2048, 1024, 0},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var sb xfs.SuperBlock
+			if err := binary.Read(f, binary.BigEndian, &sb); err != nil {
+				t.Fatal(err)
+			}
+			for agNumber, expected := range tt.expected {
+				if actual := sb.AGBlocks(uint64(agNumber)); actual != expected {
+					t.Errorf("AG %d: expected %d, actual %d", agNumber, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestNewFSTruncatedImage(t *testing.T) {
+	b, err := os.ReadFile("testdata/image40.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut the image in the middle of the AG 1 headers
+	b = b[:5116*4096+512]
+	_, err = xfs.NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if !xerrors.Is(err, xfs.ErrCorrupted) {
+		t.Fatalf("expected %v, actual %v", xfs.ErrCorrupted, err)
+	}
+}
+
+// TestTinyImages reads the fixtures of testdata/tiny, written by
+// testdata/tiny/mkfs.go
+func TestTinyImages(t *testing.T) {
+	osRelease, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse := append(bytes.Repeat([]byte("a"), 4096), make([]byte, 4096)...)
+	sparse = append(sparse, bytes.Repeat([]byte("c"), 4096)...)
+	files := map[string][]byte{
+		"etc/hostname":   []byte("tiny\n"),
+		"etc/os-release": osRelease,
+		"hello.txt":      []byte("hello, world\n"),
+		"large":          bytes.Repeat([]byte("0123456789abcdef"), (4*4096+96)/16),
+		"sparse":         sparse,
+	}
+	dirs := []string{".", "etc", "empty"}
+
+	tests := []struct {
+		image   string
+		agCount uint32
+		files   map[string][]byte
+		dirs    []string
+	}{
+		{image: "testdata/tiny/v4.xfs", agCount: 1},
+		{
+			image:   "testdata/tiny/v4-2ag.xfs",
+			agCount: 2,
+			files:   map[string][]byte{"ag1/file": []byte("in the second AG\n")},
+			dirs:    []string{"ag1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			b, err := os.ReadFile(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := xfs.NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info := fileSystem.Info()
+			if info.Version != 4 || info.AGCount != tt.agCount || info.Log != xfs.LogClean {
+				t.Errorf("unexpected geometry: %+v", info)
+			}
+			problems, err := fileSystem.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range problems {
+				t.Errorf("%s (inode %d): %v", p.Path, p.Ino, p.Err)
+			}
+
+			expected := map[string][]byte{}
+			for _, m := range []map[string][]byte{files, tt.files} {
+				for name, data := range m {
+					expected[name] = data
+				}
+			}
+			expectedDirs := append(append([]string{}, dirs...), tt.dirs...)
+			var actualDirs []string
+			err = fs.WalkDir(fileSystem, ".", func(name string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					actualDirs = append(actualDirs, name)
+					return nil
+				}
+				data, err := fs.ReadFile(fileSystem, name)
+				if err != nil {
+					return err
+				}
+				if e, ok := expected[name]; !ok || !bytes.Equal(data, e) {
+					t.Errorf("%s: unexpected %d bytes", name, len(data))
+				}
+				delete(expected, name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name := range expected {
+				t.Errorf("%s: missing", name)
+			}
+			sort.Strings(actualDirs)
+			sort.Strings(expectedDirs)
+			if !reflect.DeepEqual(actualDirs, expectedDirs) {
+				t.Errorf("expected directories %q, actual %q", expectedDirs, actualDirs)
+			}
+		})
+	}
+}
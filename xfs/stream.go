@@ -0,0 +1,135 @@
+package xfs
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// StreamFunc receives the files of Stream. r reads the content of regular
+// files and is nil for other types, it is valid until the function returns.
+type StreamFunc func(path string, info fs.FileInfo, r io.Reader) error
+
+// Stream walks the tree at root selecting files with opts as Walk does, and
+// calls fn with the content of each regular file streamed from the image
+// block by block, for sinks such as uploaders, scanners and indexers, which
+// need neither temporary files nor an archive. Directories and other types
+// are reported without content, returning fs.SkipDir for a directory skips
+// it, other errors stop the walk.
+func (xfs *FileSystem) Stream(root string, opts WalkOptions, fn StreamFunc) error {
+	const op = "stream"
+
+	if !validPath(root) {
+		return xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	if err := xfs.stream(root, opts, fn); err != nil {
+		return xfs.wrapError(op, root, err)
+	}
+	return nil
+}
+
+// stream implements Stream, its errors are not wrapped in the operation
+func (xfs *FileSystem) stream(root string, opts WalkOptions, fn StreamFunc) error {
+	return xfs.Walk(root, opts, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		i, err := d.Info()
+		if err != nil {
+			return err
+		}
+		info := toFileInfo(i)
+		if !d.Type().IsRegular() {
+			return fn(name, info, nil)
+		}
+
+		f, err := xfs.newFile(dirEntry{info})
+		if err != nil {
+			return xerrors.Errorf("failed to open %s: %w", name, err)
+		}
+		defer f.Close()
+		return fn(name, info, f)
+	})
+}
+
+// OpenStream returns a reader of the regular file name, which follows
+// symlinks as Stat does. It reads straight from the extents of the file into
+// the buffers of Read, up to the size Stat reports, without a block table or
+// a buffer of its own, so its memory is bounded by the extent list whatever
+// the size of the file. Holes and unwritten extents read as zeros.
+func (xfs *FileSystem) OpenStream(name string) (io.ReadCloser, error) {
+	const op = "open stream"
+
+	i, err := xfs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	info := toFileInfo(i)
+	if !info.Mode().IsRegular() {
+		return nil, xfs.wrapError(op, name, xerrors.Errorf("not a regular file: %w", fs.ErrInvalid))
+	}
+	if info.inode.regularExtent == nil && info.inode.regularBtree == nil {
+		return nil, xfs.wrapError(op, name, newUnsupportedFeatureError("reading data fork of non extent inode"))
+	}
+	return &streamReader{
+		xfs:     xfs,
+		name:    name,
+		extents: blockMap(xfs.PrimaryAG.SuperBlock, info.Extents()),
+		size:    info.Size(),
+	}, nil
+}
+
+// streamReader reads a file by its extents, see OpenStream
+type streamReader struct {
+	xfs     *FileSystem
+	name    string
+	extents []mappedExtent
+	size    int64
+	offset  int64
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	blockSize := int64(r.xfs.PrimaryAG.SuperBlock.BlockSize)
+	block := r.offset / blockSize
+
+	// drop the extents before the offset, reads only go forward
+	i := sort.Search(len(r.extents), func(i int) bool { return r.extents[i].end > block })
+	r.extents = r.extents[i:]
+	if len(r.extents) == 0 || r.extents[0].start > block {
+		// a hole up to the next extent
+		if len(r.extents) > 0 {
+			if hole := r.extents[0].start*blockSize - r.offset; int64(len(p)) > hole {
+				p = p[:hole]
+			}
+		}
+		for i := range p {
+			p[i] = 0
+		}
+		r.offset += int64(len(p))
+		return len(p), nil
+	}
+
+	e := r.extents[0]
+	if n := e.end*blockSize - r.offset; int64(len(p)) > n {
+		p = p[:n]
+	}
+	n, err := r.xfs.r.ReadAt(p, e.block(block)*blockSize+r.offset%blockSize)
+	r.offset += int64(n)
+	if err != nil && n < len(p) {
+		return n, r.xfs.wrapError("read", r.name, xerrors.Errorf("failed to read at %d: %w", r.offset, err))
+	}
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.extents = nil
+	return nil
+}
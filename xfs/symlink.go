@@ -0,0 +1,227 @@
+package xfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// maxSymlinks is the number of symlinks followed while resolving a path, as MAXSYMLINKS of linux
+const maxSymlinks = 40
+
+// SymlinkPolicy defines how Stat treats symlinks.
+//
+// Symlink targets are resolved inside the image: absolute targets such as
+// "/proc/self/fd" start at the root directory of the image, and ".." never
+// leaves it, as in a chroot. A target that escapes the image is therefore
+// either found under the root or dangling.
+type SymlinkPolicy int
+
+const (
+	// SymlinkNoFollow returns the info of the symlink itself, as Lstat does
+	SymlinkNoFollow SymlinkPolicy = iota
+	// SymlinkFollow follows symlinks and returns a *DanglingSymlinkError when
+	// the target does not exist in the image
+	SymlinkFollow
+	// SymlinkFollowFallback follows symlinks and returns the info of the
+	// symlink itself when the target does not exist in the image
+	SymlinkFollowFallback
+)
+
+// Lstat returns the FileInfo of name, symlinks are never followed.
+func (xfs *FileSystem) Lstat(name string) (fs.FileInfo, error) {
+	const op = "lstat"
+
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	info, err := xfs.readDirInfo(name)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	return info, nil
+}
+
+// ReadLink returns the target of the symlink name.
+func (xfs *FileSystem) ReadLink(name string) (string, error) {
+	const op = "readlink"
+
+	if !validPath(name) {
+		return "", xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	dirName, fileName := path.Split(name)
+	ino, err := xfs.resolveDir(dirName)
+	if err != nil {
+		return "", xfs.wrapError(op, name, xerrors.Errorf("failed to resolve directory: %w", err))
+	}
+	entry, err := xfs.lookupEntry(ino, fileName)
+	if err != nil {
+		return "", xfs.wrapError(op, name, err)
+	}
+	inode, err := xfs.ParseInode(entry.InodeNumber())
+	if err != nil {
+		return "", xfs.wrapError(op, name, err)
+	}
+	target, err := symlinkTarget(inode)
+	if err != nil {
+		return "", xfs.wrapError(op, name, err)
+	}
+	return target, nil
+}
+
+// EvalSymlinks returns the path name refers to after following every symlink
+// on it, as filepath.EvalSymlinks does inside the image. At most 40 symlinks
+// are followed, a loop is reported as a *DanglingSymlinkError.
+func (xfs *FileSystem) EvalSymlinks(name string) (string, error) {
+	const op = "evalsymlinks"
+
+	if !validPath(name) {
+		return "", xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	resolved, err := xfs.resolveSymlinks(name)
+	if err != nil {
+		return "", xfs.wrapError(op, name, err)
+	}
+	return resolved, nil
+}
+
+// FollowFS returns an fs.FS of the image that opens files at the end of their
+// symlinks, Open of the FileSystem itself fails on symlinks. Dangling symlinks
+// and loops are reported as fs.ErrNotExist. It is what http.FS needs to serve
+// an image:
+//
+//	http.Handle("/", http.FileServer(http.FS(fileSystem.FollowFS())))
+func (xfs *FileSystem) FollowFS() fs.FS {
+	return followFS{xfs}
+}
+
+type followFS struct {
+	xfs *FileSystem
+}
+
+func (f followFS) Open(name string) (fs.File, error) {
+	resolved, err := f.xfs.EvalSymlinks(name)
+	if xerrors.Is(err, ErrDangling) {
+		return nil, f.xfs.wrapError("open", name, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.xfs.Open(resolved)
+}
+
+// statFollow returns the FileInfo of the file name refers to, according to the symlink policy.
+func (xfs *FileSystem) statFollow(name string) (fs.FileInfo, error) {
+	const op = "stat"
+
+	resolved, err := xfs.resolveSymlinks(name)
+	if err != nil {
+		if xerrors.Is(err, ErrDangling) && xfs.symlinkPolicy == SymlinkFollowFallback {
+			return xfs.Lstat(name)
+		}
+		return nil, xfs.wrapError(op, name, err)
+	}
+
+	info, err := xfs.readDirInfo(resolved)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	// the file info carries the name used by the caller, as os.Stat does
+	fileInfo := info.(FileInfo)
+	return newFileInfo(path.Base(name), fileInfo.inode), nil
+}
+
+// resolveSymlinks returns the path name refers to after following every symlink on it.
+// A missing component of a symlink target is reported as a *DanglingSymlinkError,
+// a missing component of name itself as fs.ErrNotExist.
+func (xfs *FileSystem) resolveSymlinks(name string) (string, error) {
+	// component is a path element still to resolve, with the symlink it comes from
+	type component struct {
+		name         string
+		link, target string
+	}
+	split := func(p, link, target string) []component {
+		var components []component
+		for _, n := range strings.Split(p, "/") {
+			components = append(components, component{name: n, link: link, target: target})
+		}
+		return components
+	}
+
+	// names and inodes of the resolved directories, inos[0] is the root
+	var names []string
+	inos := []uint64{xfs.PrimaryAG.SuperBlock.Rootino}
+	pending := split(name, "", "")
+	missing := func(c component, err error) error {
+		if c.link == "" || !xerrors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return &DanglingSymlinkError{Link: c.link, Target: c.target}
+	}
+
+	links := 0
+	for len(pending) > 0 {
+		c := pending[0]
+		pending = pending[1:]
+		switch c.name {
+		case "", ".":
+			continue
+		case "..":
+			if len(names) > 0 {
+				names = names[:len(names)-1]
+				inos = inos[:len(inos)-1]
+			}
+			continue
+		}
+
+		entry, err := xfs.lookupEntry(inos[len(inos)-1], c.name)
+		if err != nil {
+			return "", missing(c, err)
+		}
+		inode, err := xfs.ParseInode(entry.InodeNumber())
+		if err != nil {
+			return "", xerrors.Errorf("failed to parse inode %d: %w", entry.InodeNumber(), err)
+		}
+
+		if inode.inodeCore.IsSymlink() {
+			target, err := symlinkTarget(inode)
+			if err != nil {
+				return "", err
+			}
+			link := path.Join(path.Join(names...), c.name)
+			links++
+			if links > maxSymlinks {
+				return "", &DanglingSymlinkError{Link: link, Target: target, Reason: "too many levels of symbolic links"}
+			}
+			if strings.HasPrefix(target, "/") {
+				names, inos = nil, inos[:1]
+			}
+			pending = append(split(target, link, target), pending...)
+			continue
+		}
+
+		if len(pending) > 0 && !inode.inodeCore.IsDir() {
+			return "", missing(c, xerrors.Errorf("%s is file, directory: %w", c.name, fs.ErrNotExist))
+		}
+		names = append(names, c.name)
+		inos = append(inos, entry.InodeNumber())
+	}
+
+	if len(names) == 0 {
+		return ".", nil
+	}
+	return path.Join(names...), nil
+}
+
+// symlinkTarget returns the target stored in the data fork of a symlink inode.
+func symlinkTarget(inode *Inode) (string, error) {
+	if !inode.inodeCore.IsSymlink() {
+		return "", xerrors.Errorf("inode %d is not a symlink: %w", inode.ino, fs.ErrInvalid)
+	}
+	if inode.symlinkString == nil {
+		return "", newUnsupportedFeatureError("reading extent format symlink")
+	}
+	return inode.symlinkString.Name, nil
+}
@@ -0,0 +1,255 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestFileSystemSymlinkPolicy(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite regular files into local format symlinks
+	links := map[string]string{
+		"etc/os-release":         "/fmt_local_directory",
+		"fmt_extents_file_1024":  "/proc/self/exe",
+		"fmt_extents_file_4096":  "../../etc/os-release",
+		"fmt_extents_file_16384": "fmt_extents_file_16384",
+
+		"parent/child/child/child/child/executable": "nonexecutable",
+	}
+	sb := fileSystem.PrimaryAG.SuperBlock
+	for name, target := range links {
+		info, err := fileSystem.ReadDirInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := sb.InodeAbsOffset(info.(FileInfo).inode.inodeCore.Ino)
+		binary.BigEndian.PutUint16(b[offset+2:], S_IFLNK|0777)
+		b[offset+5] = XFS_DINODE_FMT_LOCAL
+		binary.BigEndian.PutUint64(b[offset+56:], uint64(len(target)))
+		copy(b[offset+uint64(sb.InodeCoreSize()):], target)
+	}
+
+	newFS := func(policy SymlinkPolicy) *FileSystem {
+		fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil, WithSymlinkPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileSystem
+	}
+
+	noFollow := newFS(SymlinkNoFollow)
+	for name, target := range links {
+		info, err := noFollow.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Type() != fs.ModeSymlink {
+			t.Errorf("%s: expected symlink, actual %s", name, info.Mode())
+		}
+		actual, err := noFollow.ReadLink(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != target {
+			t.Errorf("%s: expected target %s, actual %s", name, target, actual)
+		}
+	}
+	if _, err := noFollow.ReadLink("etc"); !xerrors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected %v, actual %v", fs.ErrInvalid, err)
+	}
+
+	// EvalSymlinks does not depend on the policy
+	for name, expected := range map[string]string{
+		"etc":                       "etc",
+		"etc/os-release":            "fmt_local_directory",
+		"etc/os-release/short_form": "fmt_local_directory/short_form",
+		"fmt_extents_file_4096":     "fmt_local_directory",
+		// S_IFREG shares a bit with S_IFLNK, regular files are not symlinks
+		"parent/child/child/child/child/executable": "parent/child/child/child/child/nonexecutable",
+	} {
+		resolved, err := noFollow.EvalSymlinks(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved != expected {
+			t.Errorf("%s: expected %s, actual %s", name, expected, resolved)
+		}
+	}
+	if _, err := noFollow.EvalSymlinks("fmt_extents_file_16384"); !xerrors.Is(err, ErrDangling) {
+		t.Errorf("expected %v for a loop, actual %v", ErrDangling, err)
+	}
+
+	for _, policy := range []SymlinkPolicy{SymlinkFollow, SymlinkFollowFallback} {
+		fileSystem := newFS(policy)
+		for _, name := range []string{"etc/os-release", "fmt_extents_file_4096"} {
+			info, err := fileSystem.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.IsDir() || info.Name() != path.Base(name) {
+				t.Errorf("%s: expected directory, actual %s %s", name, info.Name(), info.Mode())
+			}
+			lstat, err := fileSystem.Lstat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lstat.Mode().Type() != fs.ModeSymlink {
+				t.Errorf("%s: expected symlink, actual %s", name, lstat.Mode())
+			}
+		}
+		if _, err := fileSystem.Stat("etc/os-release/short_form"); err != nil {
+			t.Errorf("expected symlink in the middle of the path to be followed, actual %v", err)
+		}
+		// S_IFREG shares a bit with S_IFLNK, regular files are not followed
+		name := "parent/child/child/child/child/nonexecutable"
+		if info, err := fileSystem.Stat(name); err != nil || !info.Mode().IsRegular() {
+			t.Errorf("%s: expected regular file, actual %v", name, err)
+		}
+		f, err := fileSystem.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(f); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		f.Close()
+		for _, name := range []string{"etc/missing", "etc/os-release/missing"} {
+			if _, err := fileSystem.Stat(name); !xerrors.Is(err, fs.ErrNotExist) || xerrors.Is(err, ErrDangling) {
+				t.Errorf("%s: expected %v, actual %v", name, fs.ErrNotExist, err)
+			}
+		}
+
+		for _, name := range []string{"fmt_extents_file_1024", "fmt_extents_file_16384"} {
+			info, err := fileSystem.Stat(name)
+			switch policy {
+			case SymlinkFollow:
+				var dangling *DanglingSymlinkError
+				if !xerrors.As(err, &dangling) {
+					t.Errorf("%s: expected %v, actual %v", name, ErrDangling, err)
+				}
+			case SymlinkFollowFallback:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Type() != fs.ModeSymlink {
+					t.Errorf("%s: expected symlink, actual %s", name, info.Mode())
+				}
+			}
+		}
+	}
+}
+
+func TestFileSystemFollowFS(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"fmt_extents_file_1024":  "etc/os-release",
+		"fmt_extents_file_16384": "fmt_extents_file_16384",
+	}
+	sb := fileSystem.PrimaryAG.SuperBlock
+	for name, target := range links {
+		info, err := fileSystem.ReadDirInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := sb.InodeAbsOffset(info.(FileInfo).inode.inodeCore.Ino)
+		binary.BigEndian.PutUint16(b[offset+2:], S_IFLNK|0777)
+		b[offset+5] = XFS_DINODE_FMT_LOCAL
+		binary.BigEndian.PutUint64(b[offset+56:], uint64(len(target)))
+		copy(b[offset+uint64(sb.InodeCoreSize()):], target)
+	}
+	fileSystem, err = NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.FileServer(http.FS(fileSystem.FollowFS())))
+	defer server.Close()
+	get := func(path, rangeHeader string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	for _, name := range []string{"/etc/os-release", "/fmt_extents_file_1024"} {
+		if code, body := get(name, ""); code != http.StatusOK || body != string(expected) {
+			t.Errorf("%s: unexpected response %d %q", name, code, body)
+		}
+	}
+	if code, body := get("/etc/os-release", "bytes=5-9"); code != http.StatusPartialContent || body != string(expected[5:10]) {
+		t.Errorf("unexpected range response %d %q", code, body)
+	}
+	if code, body := get("/etc/", ""); code != http.StatusOK || !strings.Contains(body, `<a href="os-release">os-release</a>`) {
+		t.Errorf("unexpected listing %d %q", code, body)
+	}
+	for _, name := range []string{"/fmt_extents_file_16384", "/missing"} {
+		if code, _ := get(name, ""); code != http.StatusNotFound {
+			t.Errorf("%s: expected %d, actual %d", name, http.StatusNotFound, code)
+		}
+	}
+
+	// Seek rewinds a directory
+	f, err := fileSystem.Open("etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := f.(*Dir)
+	for i := 0; i < 2; i++ {
+		entries, err := dir.ReadDir(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "os-release" {
+			t.Fatalf("unexpected entries %v", entries)
+		}
+		if _, err := dir.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dir.Seek(1, io.SeekStart); !xerrors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected %v, actual %v", fs.ErrInvalid, err)
+	}
+}
@@ -0,0 +1,284 @@
+package xfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+// syncMode is the part of the mode SyncTo preserves
+const syncMode = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// SyncOptions are the options of SyncTo
+type SyncOptions struct {
+	// Checksum compares the content of files of the same size and
+	// modification time too, otherwise they are assumed to be unchanged
+	Checksum bool
+	// Delete removes the files of the destination missing in the image
+	Delete bool
+	// Owner sets the uid and gid of the files, which usually requires root
+	Owner bool
+}
+
+// SyncStats counts the files handled by SyncTo
+type SyncStats struct {
+	// Written are the files and symlinks created or rewritten
+	Written int
+	// Updated are the files of the same content whose mode, owners or
+	// times were set
+	Updated   int
+	Unchanged int
+	// Removed are the files removed from the destination, either missing in
+	// the image or of another type, counting a directory once
+	Removed int
+	// Bytes are the bytes of the files written
+	Bytes int64
+}
+
+// SyncTo updates the directory dst to a copy of the image, as a repeated
+// extract would, but only writes the files that differ. Regular files of
+// the same size and modification time are left alone, files of the same
+// size and another time are compared with the image and get their
+// attributes set when only those differ. Files are written to a temporary
+// file and renamed into place, so an interrupted sync leaves complete files,
+// and a symlink of dst is replaced rather than written through. Device
+// files, FIFOs and sockets are skipped.
+func (xfs *FileSystem) SyncTo(dst string, opts SyncOptions) (SyncStats, error) {
+	const op = "sync"
+
+	s := &syncer{xfs: xfs, dst: dst, opts: opts}
+	if info, err := os.Stat(dst); err != nil {
+		return s.stats, xfs.wrapError(op, ".", err)
+	} else if !info.IsDir() {
+		return s.stats, xfs.wrapError(op, ".", xerrors.Errorf("%s is not a directory", dst))
+	}
+	if err := xfs.Walk(".", WalkOptions{}, s.sync); err != nil {
+		return s.stats, xfs.wrapError(op, ".", err)
+	}
+	// the times of directories change while their entries are synced
+	for i := len(s.dirs) - 1; i >= 0; i-- {
+		if err := s.setAttributes(s.dirs[i].target, s.dirs[i].info, nil); err != nil {
+			return s.stats, xfs.wrapError(op, s.dirs[i].name, err)
+		}
+	}
+	return s.stats, nil
+}
+
+type syncedDir struct {
+	name, target string
+	info         FileInfo
+}
+
+type syncer struct {
+	xfs   *FileSystem
+	dst   string
+	opts  SyncOptions
+	stats SyncStats
+	dirs  []syncedDir
+}
+
+func (s *syncer) sync(name string, d fs.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+	if d.Type()&(fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0 {
+		s.xfs.Logger().Warn("skipping unsupported file type", "path", name, "type", d.Type())
+		return nil
+	}
+	i, err := d.Info()
+	if err != nil {
+		return err
+	}
+	info := toFileInfo(i)
+	target := filepath.Join(s.dst, filepath.FromSlash(name))
+
+	existing, err := os.Lstat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if existing != nil && existing.Mode().Type() != info.Mode().Type() {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		s.stats.Removed++
+		existing = nil
+	}
+
+	switch {
+	case info.IsDir():
+		return s.syncDir(name, target, info, existing)
+	case info.Mode().Type() == fs.ModeSymlink:
+		return s.syncSymlink(name, target, info, existing)
+	default:
+		return s.syncFile(name, target, info, existing)
+	}
+}
+
+func (s *syncer) syncDir(name, target string, info FileInfo, existing fs.FileInfo) error {
+	// directories stay writable until their entries are synced
+	if existing == nil {
+		if err := os.Mkdir(target, 0700); err != nil {
+			return err
+		}
+		s.stats.Written++
+	} else if err := os.Chmod(target, 0700); err != nil {
+		return err
+	}
+	s.dirs = append(s.dirs, syncedDir{name: name, target: target, info: info})
+	if !s.opts.Delete || existing == nil {
+		return nil
+	}
+
+	entries, err := s.xfs.ReadDir(name)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	local, err := os.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	for _, entry := range local {
+		if names[entry.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(target, entry.Name())); err != nil {
+			return err
+		}
+		s.stats.Removed++
+	}
+	return nil
+}
+
+func (s *syncer) syncSymlink(name, target string, info FileInfo, existing fs.FileInfo) error {
+	link, err := s.xfs.ReadLink(name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if current, err := os.Readlink(target); err == nil && current == link {
+			return s.setAttributes(target, info, existing)
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if err := os.Symlink(link, target); err != nil {
+		return err
+	}
+	s.stats.Written++
+	return s.chown(target, info)
+}
+
+func (s *syncer) syncFile(name, target string, info FileInfo, existing fs.FileInfo) error {
+	if existing != nil && existing.Size() == info.Size() {
+		same := existing.ModTime().Equal(info.ModTime()) && !s.opts.Checksum
+		if !same {
+			var err error
+			if same, err = s.sameContent(info, target); err != nil {
+				return err
+			}
+		}
+		if same {
+			return s.setAttributes(target, info, existing)
+		}
+	}
+
+	f, err := s.xfs.newFile(dirEntry{info})
+	if err != nil {
+		return xerrors.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".xfs-sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, f)
+	if err != nil {
+		tmp.Close()
+		return xerrors.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	s.stats.Written++
+	s.stats.Bytes += n
+	return s.setAttributes(target, info, nil)
+}
+
+// sameContent reports whether the local file target holds the content of
+// info
+func (s *syncer) sameContent(info FileInfo, target string) (bool, error) {
+	f, err := s.xfs.newFile(dirEntry{info})
+	if err != nil {
+		return false, xerrors.Errorf("failed to open %s: %w", info.Name(), err)
+	}
+	defer f.Close()
+	local, err := os.Open(target)
+	if err != nil {
+		return false, err
+	}
+	defer local.Close()
+
+	a, b := make([]byte, 64<<10), make([]byte, 64<<10)
+	for {
+		n, err := io.ReadFull(f, a)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+		m, lerr := io.ReadFull(local, b[:n])
+		if lerr != nil && lerr != io.EOF && lerr != io.ErrUnexpectedEOF {
+			return false, lerr
+		}
+		if m != n || !bytes.Equal(a[:n], b[:m]) {
+			return false, nil
+		}
+		if err != nil {
+			return true, nil
+		}
+	}
+}
+
+// setAttributes sets the owners, mode and times of target, existing is its
+// current info when it was not written, to count the files left unchanged
+func (s *syncer) setAttributes(target string, info FileInfo, existing fs.FileInfo) error {
+	if err := s.chown(target, info); err != nil {
+		return err
+	}
+	// the mode and times of symlinks are not set, as extract does
+	if info.Mode().Type() == fs.ModeSymlink {
+		if existing != nil {
+			s.stats.Unchanged++
+		}
+		return nil
+	}
+	if existing != nil {
+		if existing.Mode()&syncMode == info.Mode()&syncMode && existing.ModTime().Equal(info.ModTime()) {
+			s.stats.Unchanged++
+			return nil
+		}
+		s.stats.Updated++
+	}
+	if err := os.Chmod(target, info.Mode()&syncMode); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.AccessTime(), info.ModTime())
+}
+
+func (s *syncer) chown(target string, info FileInfo) error {
+	if !s.opts.Owner {
+		return nil
+	}
+	return os.Lchown(target, int(info.inode.inodeCore.UID), int(info.inode.inodeCore.GID))
+}
@@ -0,0 +1,108 @@
+package xfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSystemSyncTo(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	stats, err := fileSystem.SyncTo(dst, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written == 0 || stats.Updated != 0 || stats.Unchanged != 0 || stats.Bytes == 0 {
+		t.Fatalf("unexpected first sync %+v", stats)
+	}
+	written := stats.Written
+	osRelease := filepath.Join(dst, "etc", "os-release")
+	if actual, err := os.ReadFile(osRelease); err != nil || string(actual) != string(expected) {
+		t.Fatalf("expected %q, actual %q, %v", expected, actual, err)
+	}
+
+	stats, err = fileSystem.SyncTo(dst, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 0 || stats.Updated != 0 || stats.Unchanged == 0 {
+		t.Fatalf("unexpected second sync %+v", stats)
+	}
+
+	// the same size and time are only compared with Checksum
+	local, err := os.Stat(osRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := local.ModTime()
+	changed := []byte(string(expected))
+	changed[0] ^= 1
+	if err := os.WriteFile(osRelease, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(osRelease, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = fileSystem.SyncTo(dst, SyncOptions{}); err != nil || stats.Written != 0 {
+		t.Fatalf("unexpected sync %+v, %v", stats, err)
+	}
+	if stats, err = fileSystem.SyncTo(dst, SyncOptions{Checksum: true}); err != nil || stats.Written != 1 || stats.Bytes != int64(len(expected)) {
+		t.Fatalf("unexpected checksum sync %+v, %v", stats, err)
+	}
+	if actual, err := os.ReadFile(osRelease); err != nil || string(actual) != string(expected) {
+		t.Fatalf("expected %q, actual %q, %v", expected, actual, err)
+	}
+
+	// a touched file of the same content only gets its time back
+	if err := os.Chtimes(osRelease, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = fileSystem.SyncTo(dst, SyncOptions{}); err != nil || stats.Written != 0 || stats.Updated != 1 {
+		t.Fatalf("unexpected sync %+v, %v", stats, err)
+	}
+
+	// a symlink where the image has a directory is replaced, extra files
+	// are removed with Delete
+	if err := os.RemoveAll(filepath.Join(dst, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(dst, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "extra"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = fileSystem.SyncTo(dst, SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Removed != 2 || stats.Written == 0 || stats.Written >= written {
+		t.Fatalf("unexpected sync %+v", stats)
+	}
+	if info, err := os.Lstat(filepath.Join(dst, "etc")); err != nil || !info.IsDir() {
+		t.Fatalf("expected a directory, actual %v, %v", info, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "extra")); !os.IsNotExist(err) {
+		t.Fatalf("expected extra to be removed, actual %v", err)
+	}
+}
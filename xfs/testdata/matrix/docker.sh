@@ -0,0 +1,39 @@
+#!/bin/sh
+# docker.sh regenerates the fixture matrix and runs the whole test suite
+# against it in a privileged container, so hosts without xfsprogs, such as
+# macOS or CI runners, get the same images and the kernel comparison of
+# TestDifferentialMount.
+#
+# The container of Dockerfile mounts the repository, runs generate.sh as root
+# on loop devices, then go test with XFS_MOUNT_TEST=1. The images and .golden
+# files are left in testdata/matrix, owned by the calling user. The host
+# kernel mounts the images, it needs loop devices and XFS support.
+#
+# Usage: ./docker.sh [name...]
+#
+# The names are passed to generate.sh, all images are generated by default.
+# DOCKER selects the container engine (docker), GO_VERSION the go image (1.19)
+# and TEST_FLAGS the go test flags (-race).
+set -eu
+
+cd "$(dirname "$0")"
+ROOT=$(cd ../../.. && pwd)
+
+DOCKER=${DOCKER:-docker}
+GO_VERSION=${GO_VERSION:-1.19}
+TAG=go-xfs-filesystem-matrix:$GO_VERSION
+
+"$DOCKER" build -q --build-arg GO_VERSION="$GO_VERSION" -t "$TAG" - < Dockerfile
+
+# the module cache is kept in a volume between runs
+"$DOCKER" run --rm --privileged \
+	-v "$ROOT:/src" -w /src \
+	-v go-xfs-filesystem-mod:/go/pkg/mod \
+	-e HOST_UID="$(id -u)" -e HOST_GID="$(id -g)" \
+	-e TEST_FLAGS="${TEST_FLAGS:--race}" \
+	"$TAG" sh -euc '
+		trap "chown -R \"\$HOST_UID:\$HOST_GID\" xfs/testdata/matrix" EXIT
+		./xfs/testdata/matrix/generate.sh "$@"
+		# shellcheck disable=SC2086
+		XFS_MOUNT_TEST=1 go test $TEST_FLAGS ./...
+	' docker.sh "$@"
@@ -0,0 +1,79 @@
+#!/bin/sh
+# generate.sh builds the fixture matrix used by TestFixtureMatrix.
+#
+# For every mkfs.xfs feature combination below, it creates <name>.xfs, mounts
+# it on a loop device, writes the tree of populate.go, and records the kernel
+# view of the tree in <name>.golden, one line per path:
+#
+#   <path> TAB <type> TAB <mode & 07777 in octal> TAB <size> TAB <sha256> TAB <nlink>
+#
+# type is one of file, dir, symlink, the sha256 is the file content for files,
+# the link target for symlinks and "-" for directories.
+#
+# populate.go derives the contents from the paths and fixes the timestamps,
+# and mkfs.xfs gets a fixed UUID, so the trees are the same on every run.
+# New corner cases go into populate.go, new feature combinations into CONFIGS.
+#
+# Requires Linux, root, xfsprogs and go, docker.sh runs it in a container.
+# Usage: sudo ./generate.sh [name...]
+set -eu
+
+cd "$(dirname "$0")"
+
+# name and mkfs.xfs options
+CONFIGS='
+v5 -m crc=1
+v4 -m crc=0
+v4-noftype -m crc=0 -n ftype=0
+v5-bigtime -m crc=1,bigtime=1
+v5-sparse -m crc=1 -i sparse=1
+v5-reflink -m crc=1,reflink=1
+v5-1k -m crc=1 -b size=1024
+v5-64k -m crc=1 -b size=65536
+'
+
+# the UUID of every image
+UUID=5f0b2f39-5c0b-4d2e-9d35-3a6c4f1b7e21
+
+GO=${GO:-go}
+
+MNT=$(mktemp -d)
+trap 'umount "$MNT" 2>/dev/null || true; rmdir "$MNT"' EXIT
+
+golden() {
+	root=$1
+	(cd "$root" && find . -mindepth 1 | sed 's|^\./||' | LC_ALL=C sort) | while IFS= read -r p; do
+		f="$root/$p"
+		mode=$(stat -c '%a' "$f")
+		nlink=$(stat -c '%h' "$f")
+		if [ -L "$f" ]; then
+			printf '%s\tsymlink\t%s\t%s\t%s\t%s\n' "$p" "$mode" "$(stat -c '%s' "$f")" \
+				"$(readlink "$f" | tr -d '\n' | sha256sum | cut -d' ' -f1)" "$nlink"
+		elif [ -d "$f" ]; then
+			printf '%s\tdir\t%s\t%s\t-\t%s\n' "$p" "$mode" "$(stat -c '%s' "$f")" "$nlink"
+		else
+			printf '%s\tfile\t%s\t%s\t%s\t%s\n' "$p" "$mode" "$(stat -c '%s' "$f")" \
+				"$(sha256sum "$f" | cut -d' ' -f1)" "$nlink"
+		fi
+	done
+}
+
+echo "$CONFIGS" | while read -r name opts; do
+	[ -n "$name" ] || continue
+	if [ $# -gt 0 ]; then
+		case " $* " in
+		*" $name "*) ;;
+		*) continue ;;
+		esac
+	fi
+
+	echo "generating $name.xfs ($opts)"
+	rm -f "$name.xfs" "$name.golden"
+	truncate -s 64M "$name.xfs"
+	# shellcheck disable=SC2086
+	mkfs.xfs -q -f $opts -m uuid="$UUID" "$name.xfs"
+	mount -o loop "$name.xfs" "$MNT"
+	"$GO" run ./populate.go "$MNT"
+	golden "$MNT" > "$name.golden"
+	umount "$MNT"
+done
@@ -0,0 +1,164 @@
+//go:build ignore
+
+// populate writes the fixture tree of the matrix images into a directory,
+// generate.sh runs it on the mounted image. File contents come from a
+// generator seeded by the path and every timestamp is fixed, so the tree is
+// the same on every run.
+//
+// Usage: go run populate.go <dir>
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// fixedTime is the atime and mtime of every file and directory
+var fixedTime = time.Date(2021, 6, 5, 15, 25, 40, 0, time.UTC)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: go run populate.go <dir>")
+		os.Exit(2)
+	}
+	p := populator{root: os.Args[1]}
+	p.populate()
+	p.setTimes()
+}
+
+// populator creates files under root and exits on the first error
+type populator struct {
+	root string
+}
+
+func (p populator) path(name string) string {
+	return filepath.Join(p.root, filepath.FromSlash(name))
+}
+
+func (p populator) check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "populate: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// content returns size bytes, which only depend on name
+func content(name string, size int) []byte {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(h.Sum64()))).Read(b)
+	return b
+}
+
+func (p populator) mkdir(name string) {
+	p.check(os.MkdirAll(p.path(name), 0o755))
+}
+
+func (p populator) write(name string, b []byte) {
+	p.check(os.WriteFile(p.path(name), b, 0o644))
+}
+
+func (p populator) touch(dir string, count int, format string) {
+	for i := 0; i < count; i++ {
+		p.write(fmt.Sprintf("%s/"+format, dir, i), nil)
+	}
+}
+
+func (p populator) setxattr(name, attr string, value []byte) {
+	p.check(syscall.Setxattr(p.path(name), attr, value, 0))
+}
+
+func (p populator) populate() {
+	p.mkdir("parent/child/child/child")
+	p.write("parent/child/child/child/hello", []byte("hello\n"))
+	p.check(os.Chmod(p.path("parent/child/child/child/hello"), 0o755|fs.ModeSetuid))
+	p.check(os.Symlink("child/child/child/hello", p.path("parent/link")))
+	p.check(os.Symlink("/proc/self/exe", p.path("parent/dangling")))
+
+	for _, size := range []int{1, 1023, 1024, 4096, 65537, 1048576} {
+		name := fmt.Sprintf("file_%d", size)
+		p.write(name, content(name, size))
+	}
+
+	// a hole in the middle and an unwritten extent at the end
+	f, err := os.Create(p.path("sparse"))
+	p.check(err)
+	_, err = f.Write(content("sparse", 4096))
+	p.check(err)
+	_, err = f.WriteAt(content("sparse/end", 4096), 1<<20)
+	p.check(err)
+	// FALLOC_FL_KEEP_SIZE, the unwritten extent is optional
+	_ = syscall.Fallocate(int(f.Fd()), 1, 2<<20, 8<<20)
+	p.check(f.Close())
+
+	// shortform, block, leaf and node directories
+	for _, count := range []int{2, 16, 400, 4000} {
+		dir := fmt.Sprintf("dir_%d", count)
+		p.mkdir(dir)
+		p.touch(dir, count, "entry_with_a_long_name_%d")
+	}
+
+	// an empty directory, and one emptied after it grew to a block directory
+	p.mkdir("empty")
+	p.mkdir("emptied")
+	p.touch("emptied", 100, "entry_%d")
+	for i := 0; i < 100; i++ {
+		p.check(os.Remove(p.path(fmt.Sprintf("emptied/entry_%d", i))))
+	}
+
+	// removed entries leave unused regions in the directory blocks
+	p.mkdir("churned")
+	p.touch("churned", 2000, "entry_%d")
+	for i := 0; i < 2000; i += 7 {
+		p.check(os.Remove(p.path(fmt.Sprintf("churned/entry_%d", i))))
+		p.check(os.Remove(p.path(fmt.Sprintf("churned/entry_%d", i+1))))
+	}
+
+	// shortform, leaf and node attribute forks, and a remote value
+	p.mkdir("xattrs")
+	for _, count := range []int{1, 20, 600} {
+		name := fmt.Sprintf("xattrs/count_%d", count)
+		p.write(name, nil)
+		for i := 0; i < count; i++ {
+			p.setxattr(name, fmt.Sprintf("user.attribute_%d", i), []byte(fmt.Sprintf("value_%d", i)))
+		}
+	}
+	p.write("xattrs/remote", nil)
+	p.setxattr("xattrs/remote", "user.remote", content("xattrs/remote", 8192))
+	p.setxattr("xattrs/remote", "trusted.root", []byte("root"))
+
+	// one inode linked from three directory entries in two directories
+	p.mkdir("hardlinks/sub")
+	p.write("hardlinks/original", content("hardlinks/original", 5000))
+	p.check(os.Link(p.path("hardlinks/original"), p.path("hardlinks/link")))
+	p.check(os.Link(p.path("hardlinks/original"), p.path("hardlinks/sub/link")))
+}
+
+// setTimes sets fixedTime on everything but symlinks, directories last as
+// setting the times of their entries does not change them
+func (p populator) setTimes() {
+	var dirs []string
+	err := filepath.WalkDir(p.root, func(name string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir():
+			dirs = append(dirs, name)
+			return nil
+		case d.Type() == fs.ModeSymlink:
+			return nil
+		}
+		return os.Chtimes(name, fixedTime, fixedTime)
+	})
+	p.check(err)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		p.check(os.Chtimes(dirs[i], fixedTime, fixedTime))
+	}
+}
@@ -0,0 +1,402 @@
+//go:build ignore
+
+// mkfs writes the tiny fixtures, v4 file systems of a few MB in the layout of
+// mkfs.xfs -m crc=0, holding a small tree in shortform directories. mkfs.xfs
+// refuses file systems this small, so the images are built here and committed.
+//
+//	v4.xfs      16 MB, a single AG
+//	v4-2ag.xfs  12 MB, two AGs of 8 MB, the last one short, with a
+//	            directory and a file in the second AG
+//
+// Every field is fixed, the images are the same on every run.
+// Usage: go run mkfs.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+)
+
+const (
+	blockSize  = 4096
+	sectSize   = 512
+	inodeSize  = 256
+	inopblock  = blockSize / inodeSize
+	chunkSize  = 64
+	logBlocks  = 256
+	coreSize   = 100
+	nullAgbno  = 0xffffffff
+	nullAgino  = 0xffffffff
+	nullFsino  = 0xffffffffffffffff
+	versionNum = 0xb4a4 // v4, NLINK, ALIGN, LOGV2, EXTFLG, DIRV2, MOREBITS
+	features2  = 0x28a  // LAZYSBCOUNT, ATTR2, PROJID32BIT, FTYPE
+
+	// the AG blocks of the free space and inode btree roots, and of the
+	// inode chunk
+	bnoRoot  = 1
+	cntRoot  = 2
+	inoRoot  = 3
+	chunkBlk = 4
+
+	sIFDIR = 0x4000
+	sIFREG = 0x8000
+
+	ftypeReg = 1
+	ftypeDir = 2
+
+	fmtLocal   = 1
+	fmtExtents = 2
+)
+
+var (
+	uuid = [16]byte{0x7a, 0x1c, 0x3e, 0x52, 0x90, 0x4b, 0x4f, 0x0e, 0xa1, 0x6d, 0x2b, 0x85, 0xc4, 0x19, 0xe7, 0x33}
+	// timestamp of every inode, 2021-06-05 15:25:40 UTC
+	timestamp = uint64(1622906740) << 32
+)
+
+func main() {
+	osRelease, err := os.ReadFile("../os-release")
+	check(err)
+
+	img := newImage(4096, 4096)
+	populate(img, osRelease)
+	check(os.WriteFile("v4.xfs", img.finish(), 0o644))
+
+	img = newImage(3072, 2048)
+	populate(img, osRelease)
+	ag1 := img.mkdir(img.root, 1)
+	img.root.add("ag1", ftypeDir, ag1.ino)
+	ag1.add("file", ftypeReg, img.file([]byte("in the second AG\n"), 1))
+	check(os.WriteFile("v4-2ag.xfs", img.finish(), 0o644))
+}
+
+// populate writes the tree of every image to the first AG
+func populate(img *image, osRelease []byte) {
+	root := img.root
+	etc := img.mkdir(root, 0)
+	root.add("etc", ftypeDir, etc.ino)
+	etc.add("hostname", ftypeReg, img.file([]byte("tiny\n"), 0))
+	etc.add("os-release", ftypeReg, img.file(osRelease, 0))
+	root.add("hello.txt", ftypeReg, img.file([]byte("hello, world\n"), 0))
+	root.add("empty", ftypeDir, img.mkdir(root, 0).ino)
+
+	// a file of one extent of several blocks, and a sparse one of two
+	// extents with a hole between them
+	root.add("large", ftypeReg, img.file(bytes.Repeat([]byte("0123456789abcdef"), (4*blockSize+96)/16), 0))
+	sparse := img.inode(0)
+	first, second := img.alloc(0, 1), img.alloc(0, 1)
+	img.writeData(first, bytes.Repeat([]byte("a"), blockSize))
+	img.writeData(second, bytes.Repeat([]byte("c"), blockSize))
+	img.regular(sparse, 3*blockSize, []extent{{0, first, 1}, {2, second, 1}})
+	root.add("sparse", ftypeReg, sparse)
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mkfs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type extent struct {
+	off, block, count uint64
+}
+
+type dirEntry struct {
+	name  string
+	ftype uint8
+	ino   uint64
+}
+
+// dir is a shortform directory, written by finish
+type dir struct {
+	ino, parent uint64
+	entries     []dirEntry
+}
+
+func (d *dir) add(name string, ftype uint8, ino uint64) {
+	d.entries = append(d.entries, dirEntry{name, ftype, ino})
+}
+
+type image struct {
+	b        []byte
+	dblocks  uint64
+	agblocks uint64
+	agcount  int
+	agblklog uint8
+
+	// next is the next free block of each AG, and inodes the number of
+	// inodes used in the chunk of each AG
+	next   []uint64
+	inodes []int
+	dirs   []*dir
+	root   *dir
+
+	rootino, rbmino, rsumino uint64
+	logstart                 uint64
+}
+
+func newImage(dblocks, agblocks uint64) *image {
+	img := &image{
+		b:        make([]byte, dblocks*blockSize),
+		dblocks:  dblocks,
+		agblocks: agblocks,
+		agcount:  int((dblocks + agblocks - 1) / agblocks),
+		agblklog: uint8(bits.Len64(agblocks - 1)),
+	}
+	img.next = make([]uint64, img.agcount)
+	img.inodes = make([]int, img.agcount)
+	for ag := range img.next {
+		img.next[ag] = chunkBlk + chunkSize/inopblock
+	}
+	// the internal log follows the inode chunk of the first AG
+	img.logstart = img.alloc(0, logBlocks)
+
+	img.rootino = img.inode(0)
+	img.rbmino, img.rsumino = img.inode(0), img.inode(0)
+	img.regular(img.rbmino, 0, nil)
+	img.regular(img.rsumino, 0, nil)
+	img.root = &dir{ino: img.rootino, parent: img.rootino}
+	img.dirs = append(img.dirs, img.root)
+	return img
+}
+
+func (img *image) agLength(ag int) uint64 {
+	if ag == img.agcount-1 {
+		return img.dblocks - uint64(ag)*img.agblocks
+	}
+	return img.agblocks
+}
+
+// alloc returns the file system block number of count blocks in the AG ag
+func (img *image) alloc(ag int, count uint64) uint64 {
+	agbno := img.next[ag]
+	img.next[ag] += count
+	if img.next[ag] > img.agLength(ag) {
+		check(fmt.Errorf("AG %d is full", ag))
+	}
+	return uint64(ag)<<img.agblklog | agbno
+}
+
+// inode returns the next free inode of the chunk of the AG ag
+func (img *image) inode(ag int) uint64 {
+	if img.inodes[ag] == chunkSize {
+		check(fmt.Errorf("inode chunk of AG %d is full", ag))
+	}
+	agino := uint64(chunkBlk*inopblock + img.inodes[ag])
+	img.inodes[ag]++
+	return uint64(ag)<<(int(img.agblklog)+bits.Len(inopblock-1)) | agino
+}
+
+func (img *image) offset(block uint64) uint64 {
+	ag, agbno := block>>img.agblklog, block&(1<<img.agblklog-1)
+	return (ag*img.agblocks + agbno) * blockSize
+}
+
+func (img *image) inodeOffset(ino uint64) uint64 {
+	inopblog := uint(bits.Len(inopblock - 1))
+	block := ino >> inopblog
+	return img.offset(block) + ino&(inopblock-1)*inodeSize
+}
+
+func (img *image) writeData(block uint64, data []byte) {
+	copy(img.b[img.offset(block):], data)
+}
+
+// file writes data to new blocks of the AG ag and returns the inode
+func (img *image) file(data []byte, ag int) uint64 {
+	ino := img.inode(ag)
+	var extents []extent
+	if len(data) > 0 {
+		count := uint64(len(data)+blockSize-1) / blockSize
+		block := img.alloc(ag, count)
+		img.writeData(block, data)
+		extents = []extent{{0, block, count}}
+	}
+	img.regular(ino, uint64(len(data)), extents)
+	return ino
+}
+
+// mkdir returns a new directory in parent, with its inode in the AG ag
+func (img *image) mkdir(parent *dir, ag int) *dir {
+	d := &dir{ino: img.inode(ag), parent: parent.ino}
+	img.dirs = append(img.dirs, d)
+	return d
+}
+
+// core returns the v2 inode core of a new inode
+func core(mode uint16, format uint8, nlink uint32, size, nblocks uint64, nextents uint32) []byte {
+	var b bytes.Buffer
+	for _, v := range []interface{}{
+		uint16(0x494e), mode, uint8(2), format,
+		uint16(0),            // onlink
+		uint32(0), uint32(0), // uid, gid
+		nlink,                // nlink
+		uint16(0), uint16(0), // projid
+		[6]byte{}, uint16(0), // pad, flushiter
+		timestamp, timestamp, timestamp,
+		size, nblocks,
+		uint32(0), nextents, // extsize, nextents
+		uint16(0), uint8(0), uint8(0), // anextents, forkoff, aformat
+		uint32(0), uint16(0), // dmevmask, dmstate
+		uint16(0), uint32(1), // flags, gen
+		uint32(nullAgino), // next_unlinked
+	} {
+		check(binary.Write(&b, binary.BigEndian, v))
+	}
+	if b.Len() != coreSize {
+		check(fmt.Errorf("inode core of %d bytes", b.Len()))
+	}
+	return b.Bytes()
+}
+
+func (img *image) regular(ino, size uint64, extents []extent) {
+	var nblocks uint64
+	fork := new(bytes.Buffer)
+	for _, e := range extents {
+		nblocks += e.count
+		check(binary.Write(fork, binary.BigEndian, []uint64{e.off<<9 | e.block>>43, e.block<<21 | e.count}))
+	}
+	b := append(core(sIFREG|0o644, fmtExtents, 1, size, nblocks, uint32(len(extents))), fork.Bytes()...)
+	copy(img.b[img.inodeOffset(ino):], b)
+}
+
+// writeDir writes the shortform directory d, as xfs_dir2_sf_hdr and
+// xfs_dir2_sf_entry with the file type of the entries
+func (img *image) writeDir(d *dir) {
+	var fork bytes.Buffer
+	fork.Write([]byte{byte(len(d.entries)), 0})
+	check(binary.Write(&fork, binary.BigEndian, uint32(d.parent)))
+	// offsets follow the data block header and the . and .. entries
+	offset, nlink := 16+2*16, uint32(2)
+	for _, e := range d.entries {
+		fork.WriteByte(byte(len(e.name)))
+		check(binary.Write(&fork, binary.BigEndian, uint16(offset)))
+		fork.WriteString(e.name)
+		fork.WriteByte(e.ftype)
+		check(binary.Write(&fork, binary.BigEndian, uint32(e.ino)))
+		offset += (8 + 1 + len(e.name) + 1 + 2 + 7) &^ 7
+		if e.ftype == ftypeDir {
+			nlink++
+		}
+	}
+	if coreSize+fork.Len() > inodeSize {
+		check(fmt.Errorf("directory %d does not fit in its inode", d.ino))
+	}
+	b := append(core(sIFDIR|0o755, fmtLocal, nlink, uint64(fork.Len()), 0, 0), fork.Bytes()...)
+	copy(img.b[img.inodeOffset(d.ino):], b)
+}
+
+func (img *image) put(off uint64, values ...interface{}) {
+	var b bytes.Buffer
+	for _, v := range values {
+		check(binary.Write(&b, binary.BigEndian, v))
+	}
+	copy(img.b[off:], b.Bytes())
+}
+
+// finish writes the directories, the inode chunks, the AG headers and the
+// log, and returns the image
+func (img *image) finish() []byte {
+	for _, d := range img.dirs {
+		img.writeDir(d)
+	}
+
+	var icount, ifree, fdblocks uint64
+	for ag := 0; ag < img.agcount; ag++ {
+		base := uint64(ag) * img.agblocks * blockSize
+		length := img.agLength(ag)
+
+		// the unused inodes of the chunk are initialized as the kernel does
+		used := img.inodes[ag]
+		for i := used; i < chunkSize; i++ {
+			off := base + chunkBlk*blockSize + uint64(i)*inodeSize
+			img.put(off, uint16(0x494e), uint16(0), uint8(2))
+			img.put(off+96, uint32(nullAgino))
+		}
+		free := ^uint64(0) << used
+		icount += chunkSize
+		ifree += uint64(chunkSize - used)
+
+		freeStart, freeLen := img.next[ag], length-img.next[ag]
+		fdblocks += freeLen
+
+		// AGF, the AGFL is empty
+		img.put(base+sectSize,
+			uint32(0x58414746), uint32(1), uint32(ag), uint32(length),
+			[3]uint32{bnoRoot, cntRoot, 0}, [3]uint32{1, 1, 0},
+			uint32(0), uint32(sectSize/4-1), uint32(0), // flfirst, fllast, flcount
+			uint32(freeLen), uint32(freeLen), uint32(0), // freeblks, longest, btreeblks
+		)
+		// AGI
+		unlinked := make([]uint32, 64)
+		for i := range unlinked {
+			unlinked[i] = nullAgino
+		}
+		img.put(base+2*sectSize,
+			uint32(0x58414749), uint32(1), uint32(ag), uint32(length),
+			uint32(chunkSize), uint32(inoRoot), uint32(1), uint32(chunkSize-used),
+			uint32(chunkBlk*inopblock), uint32(nullAgino), unlinked,
+		)
+		// AGFL, v4 AGFLs are the bare array of block numbers
+		agfl := make([]uint32, sectSize/4)
+		for i := range agfl {
+			agfl[i] = nullAgbno
+		}
+		img.put(base+3*sectSize, agfl)
+
+		// the btree roots, the free space of the AG is a single extent
+		for _, r := range []struct {
+			block uint64
+			magic uint32
+		}{{bnoRoot, 0x41425442}, {cntRoot, 0x41425443}} {
+			img.put(base+r.block*blockSize, r.magic, uint16(0), uint16(1), uint32(nullAgbno), uint32(nullAgbno),
+				uint32(freeStart), uint32(freeLen))
+		}
+		img.put(base+inoRoot*blockSize, uint32(0x49414254), uint16(0), uint16(1), uint32(nullAgbno), uint32(nullAgbno),
+			uint32(chunkBlk*inopblock), uint32(chunkSize-used), free)
+	}
+	for ag := 0; ag < img.agcount; ag++ {
+		img.writeSuperBlock(uint64(ag)*img.agblocks*blockSize, icount, ifree, fdblocks)
+	}
+	img.writeLog()
+	return img.b
+}
+
+func (img *image) writeSuperBlock(off, icount, ifree, fdblocks uint64) {
+	var fname [12]byte
+	copy(fname[:], "tiny")
+	img.put(off,
+		uint32(0x58465342), uint32(blockSize), img.dblocks, uint64(0), uint64(0), uuid,
+		img.logstart, img.rootino, img.rbmino, img.rsumino,
+		uint32(1), uint32(img.agblocks), uint32(img.agcount), uint32(0), uint32(logBlocks), // rextsize, agblocks, agcount, rbmblocks, logblocks
+		uint16(versionNum), uint16(sectSize), uint16(inodeSize), uint16(inopblock), fname,
+		uint8(bits.Len(blockSize-1)), uint8(bits.Len(sectSize-1)), uint8(bits.Len(inodeSize-1)),
+		uint8(bits.Len(inopblock-1)), img.agblklog, uint8(0), uint8(0), uint8(25), // rextslog, inprogress, imax_pct
+		icount, ifree, fdblocks, uint64(0),
+		uint64(nullFsino), uint64(nullFsino), uint16(0), uint8(0), uint8(0), // quota inodes, qflags, flags, shared_vn
+		uint32(2), uint32(0), uint32(0), uint8(0), uint8(0), uint16(0), uint32(1), // inoalignmt, unit, width, dirblklog, logsectlog, logsectsize, logsunit
+		uint32(features2), uint32(features2),
+	)
+}
+
+// writeLog writes a log holding an unmount record, as a cleanly unmounted
+// file system with the head of the log after it
+func (img *image) writeLog() {
+	off := img.offset(img.logstart)
+	// xlog_rec_header, the first word of the following block is replaced by
+	// the cycle and kept in h_cycle_data
+	cycleData := make([]uint32, 32*1024/512)
+	cycleData[0] = 1 // the transaction ID of the unmount record
+	img.put(off,
+		uint32(0xfeedbabe), uint32(1), uint32(2), uint32(12+8), // magic, cycle, version, len
+		uint64(1)<<32, uint64(1)<<32, uint32(0), int32(-1), int32(1), // lsn, tail_lsn, crc, prev_block, num_logops
+		cycleData, int32(1), uuid, int32(32*1024), // fmt, fs_uuid, size
+	)
+	// xlog_op_header of XFS_LOG with XLOG_UNMOUNT_TRANS, and the unmount
+	// record magic XLOG_UNMOUNT_TYPE
+	img.put(off+512, uint32(1), uint32(8), uint8(0xaa), uint8(0x20), uint16(0), uint16(0x556e))
+}
@@ -0,0 +1,29 @@
+package xfs
+
+// Tracer starts spans around the operations of a FileSystem, set with
+// WithTracer. The spans are
+//
+//	xfs.mount    NewFS, with the "size" of the image
+//	xfs.resolve  the resolution of the directory "path"
+//	xfs.readdir  the decoding of all the entries of the directory "ino"
+//	xfs.lookup   the decoding of the directory "ino" up to the entry "name"
+//	xfs.read     the read of "count" blocks at the physical "block"
+//
+// Cached directories are not decoded again and have no span. The xfsotel
+// package implements Tracer with OpenTelemetry.
+type Tracer interface {
+	Start(name string, attrs ...TraceAttribute) TraceSpan
+}
+
+// TraceSpan is a span started by a Tracer, End is called once with the error
+// of the operation, nil on success
+type TraceSpan interface {
+	End(err error)
+}
+
+// TraceAttribute is an attribute of a span, Value is a string, an int64 or
+// an uint64
+type TraceAttribute struct {
+	Key   string
+	Value interface{}
+}
@@ -0,0 +1,50 @@
+package xfs
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// UnlinkedInode is an inode on an unlinked list of an AGI. These inodes were
+// unlinked while still open and are freed when the file system is mounted
+// again, or by xfs_repair.
+type UnlinkedInode struct {
+	Ino uint64
+	// AG and Bucket locate the list in agi_unlinked
+	AG     uint32
+	Bucket int
+}
+
+// UnlinkedInodes follows the unlinked lists of every AGI through
+// di_next_unlinked, which NULLAGINO terminates, and returns their inodes in
+// list order.
+func (xfs *FileSystem) UnlinkedInodes() ([]UnlinkedInode, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	var unlinked []UnlinkedInode
+	for agNumber, ag := range xfs.AGs {
+		agiOffset := int64(agNumber)*int64(sb.Agblocks)*int64(sb.BlockSize) + 2*int64(sb.Sectsize)
+		visited := map[uint32]bool{}
+		for bucket := 0; bucket < XFS_AGI_UNLINKED_BUCKETS; bucket++ {
+			agino := binary.BigEndian.Uint32(ag.Agi.Unlinked[bucket*4:])
+			for agino != NULLAGINO {
+				if visited[agino] {
+					return nil, newCorruptedError("agi", agiOffset, "unlinked bucket %d of AG %d loops at agino %d", bucket, agNumber, agino)
+				}
+				visited[agino] = true
+
+				ino := uint64(agNumber)<<(sb.Agblklog+sb.Inopblog) | uint64(agino)
+				if err := sb.verifyInodeNumber(ino); err != nil {
+					return nil, xerrors.Errorf("unlinked bucket %d of AG %d: %w", bucket, agNumber, err)
+				}
+				inode, err := xfs.ParseInode(ino)
+				if err != nil {
+					return nil, xerrors.Errorf("unlinked bucket %d of AG %d: %w", bucket, agNumber, err)
+				}
+				unlinked = append(unlinked, UnlinkedInode{Ino: ino, AG: uint32(agNumber), Bucket: bucket})
+				agino = inode.inodeCore.NextUnlinked
+			}
+		}
+	}
+	return unlinked, nil
+}
@@ -0,0 +1,58 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestUnlinkedInodes(t *testing.T) {
+	img, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(b []byte) *FileSystem {
+		fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileSystem
+	}
+	fileSystem := open(img)
+	unlinked, err := fileSystem.UnlinkedInodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unlinked) != 0 {
+		t.Errorf("expected no unlinked inodes, actual %+v", unlinked)
+	}
+
+	// link etc/os-release and fmt_extents_file_1024 into bucket 5 of AG 0,
+	// as the kernel does for files unlinked while open
+	sb := fileSystem.PrimaryAG.SuperBlock
+	agi := img[2*int(sb.Sectsize):]
+	binary.BigEndian.PutUint32(agi[40+5*4:], 20453)
+	binary.BigEndian.PutUint32(img[sb.InodeAbsOffset(20453)+96:], 20440)
+
+	unlinked, err = open(img).UnlinkedInodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []UnlinkedInode{{Ino: 20453, Bucket: 5}, {Ino: 20440, Bucket: 5}}
+	if !reflect.DeepEqual(unlinked, expected) {
+		t.Errorf("expected %+v, actual %+v", expected, unlinked)
+	}
+
+	// a corrupted list loops
+	binary.BigEndian.PutUint32(img[sb.InodeAbsOffset(20440)+96:], 20453)
+	_, err = open(img).UnlinkedInodes()
+	var corrupted *CorruptedError
+	if !xerrors.As(err, &corrupted) || corrupted.Structure != "agi" {
+		t.Errorf("expected a corrupted agi, actual %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package xfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"path"
+
+	"golang.org/x/xerrors"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Problem is an inconsistency found by Verify
+type Problem struct {
+	// Path is the first path found for the inode, empty for AG headers
+	Path string
+	// Ino is the inode number, 0 for AG headers
+	Ino uint64
+	// Err describes the problem, usually a *CorruptedError
+	Err error
+}
+
+func (p Problem) Error() string {
+	if p.Path == "" {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Err)
+}
+
+func (p Problem) Unwrap() error {
+	return p.Err
+}
+
+// Verify checks the file system without mounting it, as a read only
+// xfs_repair -n. The secondary superblocks are compared with the primary, the
+// directory tree is walked from the root and every entry must reference a
+// valid inode of the recorded file type, and the link counts must match the
+// entries. On v5 file systems the checksums of the AG headers, of the reached
+// inodes and of their directory blocks are verified.
+// An error is only returned when the AG headers cannot be read.
+func (xfs *FileSystem) Verify() ([]Problem, error) {
+	v := verifier{
+		xfs:     xfs,
+		paths:   map[uint64]string{},
+		inodes:  map[uint64]*Inode{},
+		links:   map[uint64]uint32{},
+		subdirs: map[uint64]uint32{},
+	}
+	if err := v.verifyAGs(); err != nil {
+		return nil, err
+	}
+	v.walk()
+	return v.problems, nil
+}
+
+type verifier struct {
+	xfs      *FileSystem
+	problems []Problem
+
+	// order holds the reached inodes in the order of the walk
+	order   []uint64
+	paths   map[uint64]string
+	inodes  map[uint64]*Inode
+	links   map[uint64]uint32
+	subdirs map[uint64]uint32
+}
+
+func (v *verifier) report(p string, ino uint64, err error) {
+	v.problems = append(v.problems, Problem{Path: p, Ino: ino, Err: err})
+}
+
+// verifyAGs checks the AG header checksums and sequence numbers
+func (v *verifier) verifyAGs() error {
+	sb := v.xfs.PrimaryAG.SuperBlock
+	if err := v.xfs.VerifySuperBlocks(); err != nil {
+		v.report("", 0, err)
+	}
+
+	sectSize := int64(sb.Sectsize)
+	AGSize := int64(sb.Agblocks) * int64(sb.BlockSize)
+	for i, ag := range v.xfs.AGs {
+		offset := AGSize * int64(i)
+		if ag.Agf.Seqno != uint32(i) || uint64(ag.Agf.Length) != sb.AGBlocks(uint64(i)) {
+			v.report("", 0, newCorruptedError("agf", offset+sectSize, "AG %d: seqno %d, length %d", i, ag.Agf.Seqno, ag.Agf.Length))
+		}
+		if ag.Agi.Seqno != uint32(i) || uint64(ag.Agi.Length) != sb.AGBlocks(uint64(i)) {
+			v.report("", 0, newCorruptedError("agi", offset+2*sectSize, "AG %d: seqno %d, length %d", i, ag.Agi.Seqno, ag.Agi.Length))
+		}
+		if !sb.IsV5() {
+			continue
+		}
+
+		buf := make([]byte, 4*sectSize)
+		if _, err := v.xfs.r.ReadAt(buf, offset); err != nil {
+			return xerrors.Errorf("failed to read AG %d headers: %w", i, err)
+		}
+		for j, h := range []struct {
+			structure string
+			crcOff    int
+		}{
+			{"superblock", XFS_SB_CRC_OFF},
+			{"agf", XFS_AGF_CRC_OFF},
+			{"agi", XFS_AGI_CRC_OFF},
+			{"agfl", XFS_AGFL_CRC_OFF},
+		} {
+			sector := buf[int64(j)*sectSize : int64(j+1)*sectSize]
+			if err := verifyCRC(h.structure, sector, h.crcOff); err != nil {
+				setCorruptedOffset(err, offset+int64(j)*sectSize)
+				v.report("", 0, xerrors.Errorf("AG %d: %w", i, err))
+			}
+		}
+	}
+	return nil
+}
+
+// walk visits the directory tree breadth first and checks the link counts
+func (v *verifier) walk() {
+	root := v.xfs.PrimaryAG.SuperBlock.Rootino
+	rootInode := v.reach(root, ".")
+	if rootInode == nil {
+		return
+	}
+	if !rootInode.inodeCore.IsDir() {
+		v.report(".", root, newCorruptedError("inode", -1, "root inode is not a directory"))
+		return
+	}
+
+	type dir struct {
+		ino, parent uint64
+	}
+	queue := []dir{{root, root}}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		dirPath := v.paths[d.ino]
+
+		entries, err := v.xfs.rawReadDir(d.ino)
+		if err != nil {
+			v.report(dirPath, d.ino, err)
+		}
+		for _, entry := range entries {
+			ino := entry.InodeNumber()
+			switch entry.Name() {
+			case ".":
+				if ino != d.ino {
+					v.report(dirPath, d.ino, newCorruptedError("directory", -1, ". references inode %d", ino))
+				}
+				continue
+			case "..":
+				if ino != d.parent {
+					v.report(dirPath, d.ino, newCorruptedError("directory", -1, ".. references inode %d, parent is %d", ino, d.parent))
+				}
+				continue
+			}
+
+			v.links[ino]++
+			entryPath := path.Join(dirPath, entry.Name())
+			inode, seen := v.inodes[ino]
+			if !seen {
+				inode = v.reach(ino, entryPath)
+			}
+			if inode == nil {
+				continue
+			}
+			if typ, ok := fileTypeToMode(entry.FileType()); ok && typ != inode.inodeCore.FileModeType() {
+				v.report(entryPath, ino, newCorruptedError("directory entry", -1, "file type %s, inode mode %s",
+					typ, inode.inodeCore.FileModeType()))
+			}
+			if inode.inodeCore.IsDir() {
+				v.subdirs[d.ino]++
+				if seen {
+					v.report(entryPath, ino, newCorruptedError("directory", -1, "directory is also linked from %s", v.paths[ino]))
+					continue
+				}
+				queue = append(queue, dir{ino, d.ino})
+			}
+		}
+	}
+
+	for _, ino := range v.order {
+		inode := v.inodes[ino]
+		if inode == nil {
+			continue
+		}
+		expected := v.links[ino]
+		if inode.inodeCore.IsDir() {
+			expected = 2 + v.subdirs[ino]
+		}
+		if inode.inodeCore.NLink != expected {
+			v.report(v.paths[ino], ino, v.xfs.wrapInodeError(ino,
+				newCorruptedError("inode", -1, "link count %d, %d links found", inode.inodeCore.NLink, expected)))
+		}
+	}
+}
+
+// reach parses and checks the inode ino found at p, nil is returned when the
+// inode cannot be parsed.
+func (v *verifier) reach(ino uint64, p string) *Inode {
+	v.order = append(v.order, ino)
+	v.paths[ino] = p
+	v.inodes[ino] = nil
+
+	inode, err := v.xfs.ParseInode(ino)
+	if err != nil {
+		v.report(p, ino, err)
+		return nil
+	}
+	v.inodes[ino] = inode
+	if !v.xfs.PrimaryAG.SuperBlock.IsV5() {
+		return inode
+	}
+
+	raw, err := v.xfs.ReadRawInode(ino)
+	if err != nil {
+		v.report(p, ino, err)
+		return inode
+	}
+	if err := verifyCRC("inode", raw, XFS_DINODE_CRC_OFF); err != nil {
+		v.report(p, ino, v.xfs.wrapInodeError(ino, err))
+	}
+	if inode.directoryExtents != nil {
+		for _, err := range v.xfs.verifyDirBlocks(inode) {
+			v.report(p, ino, err)
+		}
+	}
+	return inode
+}
+
+// verifyDirBlocks checks the checksums of the data, leaf, node and free
+// blocks of an extents format directory.
+func (xfs *FileSystem) verifyDirBlocks(inode *Inode) []error {
+	recs := inode.directoryExtents.bmbtRecs
+	if len(recs) == 0 {
+		return nil
+	}
+	it := &dirIterator{xfs: xfs, ino: inode.ino, recs: recs, irec: recs[0].Unpack()}
+
+	var errs []error
+	for {
+		for it.rec < len(it.recs) && it.block >= it.irec.BlockCount {
+			it.nextRec()
+		}
+		if it.rec >= len(it.recs) {
+			return errs
+		}
+		startBlock := it.irec.StartBlock + it.block
+		b, err := it.readDirBlock()
+		if err != nil {
+			errs = append(errs, xfs.wrapInodeError(inode.ino, xfs.wrapBlockError(startBlock, err)))
+			it.nextRec()
+			continue
+		}
+
+		var crcOff int
+		switch {
+		case binary.BigEndian.Uint32(b) == XFS_DIR3_BLOCK_MAGIC || binary.BigEndian.Uint32(b) == XFS_DIR3_DATA_MAGIC ||
+			binary.BigEndian.Uint32(b) == XFS_DIR3_FREE_MAGIC:
+			crcOff = XFS_DIR3_DATA_CRC_OFF
+		case binary.BigEndian.Uint16(b[8:]) == XFS_DIR3_LEAF1_MAGIC || binary.BigEndian.Uint16(b[8:]) == XFS_DIR3_LEAFN_MAGIC ||
+			binary.BigEndian.Uint16(b[8:]) == XFS_DA3_NODE_MAGIC:
+			crcOff = XFS_DA3_NODE_CRC_OFF
+		default:
+			err := newCorruptedError("directory block", -1, "invalid magic 0x%x", b[:4])
+			errs = append(errs, xfs.wrapInodeError(inode.ino, xfs.wrapBlockError(startBlock, err)))
+			continue
+		}
+		if err := verifyCRC("directory block", b, crcOff); err != nil {
+			errs = append(errs, xfs.wrapInodeError(inode.ino, xfs.wrapBlockError(startBlock, err)))
+		}
+	}
+}
+
+// verifyCRC returns a *CorruptedError, when the crc stored at crcOff of b
+// does not match the crc32c of b.
+func verifyCRC(structure string, b []byte, crcOff int) error {
+	stored := binary.LittleEndian.Uint32(b[crcOff:])
+	h := crc32.New(crc32c)
+	h.Write(b[:crcOff])
+	h.Write(make([]byte, 4))
+	h.Write(b[crcOff+4:])
+	if sum := h.Sum32(); sum != stored {
+		return newCorruptedError(structure, -1, "crc 0x%08x, computed 0x%08x", stored, sum)
+	}
+	return nil
+}
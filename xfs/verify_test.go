@@ -0,0 +1,120 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestVerify(t *testing.T) {
+	for _, image := range []string{"testdata/image.xfs", "testdata/image40.xfs"} {
+		t.Run(image, func(t *testing.T) {
+			b, err := os.ReadFile(image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			problems, err := fileSystem.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 0 {
+				t.Errorf("expected no problems, actual %v", problems)
+			}
+		})
+	}
+}
+
+func TestVerifyCorrupted(t *testing.T) {
+	img, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func(t *testing.T, b []byte) *FileSystem {
+		t.Helper()
+		fileSystem, err := NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fileSystem
+	}
+	sb := open(t, img).PrimaryAG.SuperBlock
+	// etc/os-release
+	const ino = 20453
+	inodeOffset := int(sb.InodeAbsOffset(ino))
+	updateCRC := func(b []byte, crcOff int) {
+		binary.LittleEndian.PutUint32(b[crcOff:], 0)
+		binary.LittleEndian.PutUint32(b[crcOff:], crc32.Checksum(b, crc32c))
+	}
+
+	tests := []struct {
+		name     string
+		corrupt  func(b []byte)
+		expected []string
+	}{
+		{
+			name: "agi crc",
+			corrupt: func(b []byte) {
+				// agi_freecount
+				b[2*int(sb.Sectsize)+31]++
+			},
+			expected: []string{"AG 0: corrupted agi at offset 0x400: crc"},
+		},
+		{
+			name: "inode crc",
+			corrupt: func(b []byte) {
+				// di_gen
+				b[inodeOffset+91]++
+			},
+			expected: []string{"etc/os-release: inode 20453 (AG 0, offset 0x9fca00): corrupted inode at offset 0x9fca00: crc"},
+		},
+		{
+			name: "link count",
+			corrupt: func(b []byte) {
+				// di_nlink
+				binary.BigEndian.PutUint32(b[inodeOffset+16:], 3)
+				updateCRC(b[inodeOffset:inodeOffset+int(sb.Inodesize)], XFS_DINODE_CRC_OFF)
+			},
+			expected: []string{"etc/os-release: inode 20453 (AG 0, offset 0x9fca00): corrupted inode at offset 0x9fca00: link count 3, 1 links found"},
+		},
+		{
+			name: "file type",
+			corrupt: func(b []byte) {
+				// di_mode, a symlink in place of a regular file
+				binary.BigEndian.PutUint16(b[inodeOffset+2:], 0120777)
+				updateCRC(b[inodeOffset:inodeOffset+int(sb.Inodesize)], XFS_DINODE_CRC_OFF)
+			},
+			expected: []string{"etc/os-release: corrupted directory entry: file type ----------, inode mode L---------"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, img...)
+			tt.corrupt(b)
+			problems, err := open(t, b).Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != len(tt.expected) {
+				t.Fatalf("expected %d problems, actual %v", len(tt.expected), problems)
+			}
+			for i, p := range problems {
+				if !strings.HasPrefix(p.Error(), tt.expected[i]) {
+					t.Errorf("expected %q, actual %q", tt.expected[i], p.Error())
+				}
+				if !xerrors.Is(p, ErrCorrupted) {
+					t.Errorf("expected ErrCorrupted, actual %v", p)
+				}
+			}
+		})
+	}
+}
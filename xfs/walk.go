@@ -0,0 +1,255 @@
+package xfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// WalkOptions select the files Walk reports. Names and file types come from
+// the directory entries, so directories that cannot hold a selected file are
+// never read and the inodes of skipped files are never parsed.
+type WalkOptions struct {
+	// Include are path.Match patterns matched component by component against
+	// the path from the root of the image, e.g. "usr/lib/*/os-release". A
+	// file is reported when a pattern matches it or one of its parents, only
+	// directories leading to a match are entered. Empty includes every file.
+	Include []string
+	// Exclude are path.Match patterns of paths from the root of the image to
+	// skip with everything below them. Patterns without a slash match the
+	// name alone, e.g. "*.log".
+	Exclude []string
+	// MaxDepth is the deepest level entered below root, whose entries are at
+	// depth 1. 0 is unlimited.
+	MaxDepth int
+	// MaxSize skips regular files larger than MaxSize bytes, 0 is unlimited.
+	// Only the inodes of regular files are parsed to check it.
+	MaxSize int64
+	// Types are the types reported as fs.FileMode.Type, 0 for regular files.
+	// Directories are entered whatever their type. Empty reports every type.
+	Types []fs.FileMode
+}
+
+// Walk walks the tree at root like fs.WalkDir, reporting only the files
+// selected by opts. fn is called with fs.SkipDir as fs.WalkDir does, the
+// fs.DirEntry parses its inode on the first Info call.
+func (xfs *FileSystem) Walk(root string, opts WalkOptions, fn fs.WalkDirFunc) error {
+	const op = "walk"
+
+	if !validPath(root) {
+		return xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	w, err := newWalker(xfs, opts, fn)
+	if err != nil {
+		return xfs.wrapError(op, root, err)
+	}
+
+	info, err := xfs.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	d := fs.FileInfoToDirEntry(info)
+	if w.report(root, d) {
+		err = fn(root, d, nil)
+		if err == fs.SkipDir {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !d.IsDir() || !w.enter(root, 0) {
+		return nil
+	}
+	if err := w.walkDir(root, d, info.(FileInfo).Ino(), 1); err != nil && err != fs.SkipDir {
+		return err
+	}
+	return nil
+}
+
+type walker struct {
+	xfs     *FileSystem
+	opts    WalkOptions
+	include [][]string
+	fn      fs.WalkDirFunc
+}
+
+func newWalker(xfs *FileSystem, opts WalkOptions, fn fs.WalkDirFunc) (*walker, error) {
+	w := &walker{xfs: xfs, opts: opts, fn: fn}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, xerrors.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range opts.Include {
+		w.include = append(w.include, splitPath(pattern))
+	}
+	return w, nil
+}
+
+// walkDir reports the entries of the directory dir at depth and enters its
+// subdirectories
+func (w *walker) walkDir(dir string, d fs.DirEntry, ino uint64, depth int) error {
+	entries, err := w.xfs.listEntries(ino)
+	if err != nil {
+		// the entries read before an unsupported block are still walked
+		if err := w.fn(dir, d, w.xfs.wrapError("walk", dir, err)); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "." || name == ".." {
+			continue
+		}
+		p := path.Join(dir, name)
+		if w.excluded(p, name) {
+			continue
+		}
+		typ, err := w.xfs.entryType(entry)
+		if err != nil {
+			if err := w.fn(p, nil, w.xfs.wrapError("walk", p, err)); err != nil && err != fs.SkipDir {
+				return err
+			}
+			continue
+		}
+		child := &walkEntry{xfs: w.xfs, entry: entry, typ: typ}
+
+		if w.report(p, child) {
+			if w.opts.MaxSize > 0 && typ.IsRegular() {
+				info, err := child.Info()
+				if err != nil {
+					if err := w.fn(p, child, w.xfs.wrapError("walk", p, err)); err != nil {
+						return err
+					}
+					continue
+				}
+				if info.Size() > w.opts.MaxSize {
+					continue
+				}
+			}
+			if err := w.fn(p, child, nil); err != nil {
+				if err == fs.SkipDir && typ.IsDir() {
+					continue
+				}
+				return err
+			}
+		}
+		if typ.IsDir() && w.enter(p, depth) {
+			if err := w.walkDir(p, child, entry.InodeNumber(), depth+1); err != nil {
+				if err == fs.SkipDir {
+					continue
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// report returns whether the file p is reported, its size is checked later
+func (w *walker) report(p string, d fs.DirEntry) bool {
+	if !w.included(p) {
+		return false
+	}
+	if len(w.opts.Types) == 0 {
+		return true
+	}
+	for _, typ := range w.opts.Types {
+		if d.Type() == typ.Type() {
+			return true
+		}
+	}
+	return false
+}
+
+// enter returns whether the directory p at depth may hold a reported file
+func (w *walker) enter(p string, depth int) bool {
+	if w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+		return false
+	}
+	if w.included(p) {
+		return true
+	}
+	elems := splitPath(p)
+	for _, pattern := range w.include {
+		if len(pattern) > len(elems) && matchElems(pattern[:len(elems)], elems) {
+			return true
+		}
+	}
+	return false
+}
+
+// included returns whether p or one of its parents matches an include pattern
+func (w *walker) included(p string) bool {
+	if len(w.include) == 0 {
+		return true
+	}
+	elems := splitPath(p)
+	for _, pattern := range w.include {
+		if len(pattern) <= len(elems) && matchElems(pattern, elems[:len(pattern)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *walker) excluded(p, name string) bool {
+	for _, pattern := range w.opts.Exclude {
+		target := p
+		if !strings.Contains(pattern, "/") {
+			target = name
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath returns the elements of a slash separated path, none for the root
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean(p), "/")
+	if p == "." || p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchElems(pattern, elems []string) bool {
+	for i := range pattern {
+		if ok, _ := path.Match(pattern[i], elems[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// walkEntry is a directory entry of Walk, its inode is parsed by Info only
+type walkEntry struct {
+	xfs   *FileSystem
+	entry Entry
+	typ   fs.FileMode
+	info  *FileInfo
+}
+
+func (e *walkEntry) Name() string { return e.entry.Name() }
+
+func (e *walkEntry) IsDir() bool { return e.typ.IsDir() }
+
+func (e *walkEntry) Type() fs.FileMode { return e.typ }
+
+func (e *walkEntry) Info() (fs.FileInfo, error) {
+	if e.info == nil {
+		inode, err := e.xfs.ParseInode(e.entry.InodeNumber())
+		if err != nil {
+			return nil, err
+		}
+		info := newFileInfo(e.entry.Name(), inode)
+		e.info = &info
+	}
+	return *e.info, nil
+}
@@ -0,0 +1,175 @@
+package xfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// WalkErrors are the errors returned by the function of WalkConcurrent, in
+// the lexical order of their paths
+type WalkErrors []*fs.PathError
+
+func (e WalkErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// WalkConcurrent walks the tree at root like fs.WalkDir, with workers
+// goroutines reading directories in parallel, GOMAXPROCS when workers is 0.
+// fn is called concurrently and in no particular order, a parent before its
+// children. Returning fs.SkipDir from fn skips a directory, other errors are
+// collected as WalkErrors without stopping the walk, a directory fn fails on
+// is not entered. The walk stops when ctx is done and returns ctx.Err().
+func (xfs *FileSystem) WalkConcurrent(ctx context.Context, root string, workers int, fn fs.WalkDirFunc) error {
+	const op = "walk"
+
+	if !validPath(root) {
+		return xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	info, err := xfs.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	w := &concurrentWalker{xfs: xfs, ctx: ctx, fn: fn}
+	w.cond = sync.NewCond(&w.mu)
+	d := fs.FileInfoToDirEntry(info)
+	if w.call(root, d, nil) && d.IsDir() {
+		w.push(walkDir{path: root, d: d, ino: info.(FileInfo).Ino()})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(w.errs) > 0 {
+		sort.Slice(w.errs, func(i, j int) bool { return w.errs[i].Path < w.errs[j].Path })
+		return w.errs
+	}
+	return nil
+}
+
+// walkDir is a directory queued by WalkConcurrent
+type walkDir struct {
+	path string
+	d    fs.DirEntry
+	ino  uint64
+}
+
+type concurrentWalker struct {
+	xfs *FileSystem
+	ctx context.Context
+	fn  fs.WalkDirFunc
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue is a stack, walking depth first keeps it short
+	queue []walkDir
+	// pending counts the directories queued or being read
+	pending int
+	errs    WalkErrors
+}
+
+func (w *concurrentWalker) push(dir walkDir) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.queue = append(w.queue, dir)
+	w.pending++
+	w.cond.Signal()
+}
+
+// work reads the queued directories until none is pending
+func (w *concurrentWalker) work() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.pending > 0 {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mu.Unlock()
+
+		// the queue is drained without reading once ctx is done
+		if w.ctx.Err() == nil {
+			w.readDir(dir)
+		}
+
+		w.mu.Lock()
+		w.pending--
+		if w.pending == 0 {
+			w.cond.Broadcast()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// readDir reports the entries of dir and queues its subdirectories
+func (w *concurrentWalker) readDir(dir walkDir) {
+	entries, err := w.xfs.listEntries(dir.ino)
+	if err != nil {
+		// the entries read before an unsupported block are still walked
+		w.call(dir.path, dir.d, w.xfs.wrapError("walk", dir.path, err))
+	}
+	for _, entry := range entries {
+		if w.ctx.Err() != nil {
+			return
+		}
+		name := entry.Name()
+		if name == "." || name == ".." {
+			continue
+		}
+		p := path.Join(dir.path, name)
+		typ, err := w.xfs.entryType(entry)
+		if err != nil {
+			w.call(p, nil, w.xfs.wrapError("walk", p, err))
+			continue
+		}
+		child := &walkEntry{xfs: w.xfs, entry: entry, typ: typ}
+		if w.call(p, child, nil) && typ.IsDir() {
+			w.push(walkDir{path: p, d: child, ino: entry.InodeNumber()})
+		}
+	}
+}
+
+// call calls fn and records its error, it returns whether a directory is
+// entered
+func (w *concurrentWalker) call(p string, d fs.DirEntry, err error) bool {
+	err = w.fn(p, d, err)
+	if err == nil {
+		return true
+	}
+	if err == fs.SkipDir {
+		return false
+	}
+	pathErr, ok := err.(*fs.PathError)
+	if !ok {
+		pathErr = &fs.PathError{Op: "walk", Path: p, Err: err}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errs = append(w.errs, pathErr)
+	return false
+}
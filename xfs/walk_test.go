@@ -0,0 +1,494 @@
+package xfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFileSystemWalk(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		root     string
+		opts     WalkOptions
+		expected []string
+	}{
+		{
+			name: "include",
+			root: ".",
+			opts: WalkOptions{Include: []string{"etc/os-release", "parent/*/child/child/child/executable"}},
+			expected: []string{
+				"etc/os-release",
+				"parent/child/child/child/child/executable",
+			},
+		},
+		{
+			name: "include directory",
+			root: ".",
+			opts: WalkOptions{Include: []string{"parent/child/child/child/child/child"}},
+			expected: []string{
+				"parent/child/child/child/child/child",
+				"parent/child/child/child/child/child/executable",
+			},
+		},
+		{
+			name: "exclude",
+			root: "parent",
+			opts: WalkOptions{Exclude: []string{"parent/child/child/child/child/child", "nonexecutable"}, Types: []fs.FileMode{0}},
+			expected: []string{
+				"parent/child/child/child/child/executable",
+			},
+		},
+		{
+			name: "depth",
+			root: ".",
+			opts: WalkOptions{MaxDepth: 1, Types: []fs.FileMode{fs.ModeDir}},
+			expected: []string{
+				".",
+				"etc",
+				"fmt_extents_block_directories",
+				"fmt_leaf_directories",
+				"fmt_local_directory",
+				"fmt_node_directories",
+				"parent",
+			},
+		},
+		{
+			name: "size",
+			root: ".",
+			opts: WalkOptions{MaxSize: 4096, Include: []string{"fmt_extents_file_*"}},
+			expected: []string{
+				"fmt_extents_file_1024",
+				"fmt_extents_file_4096",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil, WithInodeCacheSize(4096))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			err = fileSystem.Walk(tt.root, tt.opts, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				actual = append(actual, path)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(actual)
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, actual %v", tt.expected, actual)
+			}
+		})
+	}
+
+	t.Run("prune", func(t *testing.T) {
+		fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil, WithInodeCacheSize(4096))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ino uint64
+		err = fileSystem.Walk(".", WalkOptions{Include: []string{"etc/os-release"}}, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			ino = info.(FileInfo).Ino()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ino != 20453 {
+			t.Errorf("expected inode %d, actual %d", 20453, ino)
+		}
+
+		// the inodes of files and directories outside etc were never parsed
+		entries, err := fileSystem.RawReadDirInode(fileSystem.PrimaryAG.SuperBlock.Rootino)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			switch entry.Name() {
+			case ".", "..", "etc":
+				continue
+			}
+			if _, ok := fileSystem.inodeCache.Get(entry.InodeNumber()); ok {
+				t.Errorf("inode of %s was parsed", entry.Name())
+			}
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		err = fileSystem.Walk("parent", WalkOptions{Types: []fs.FileMode{fs.ModeDir}}, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			actual = append(actual, path)
+			if path == "parent/child/child" {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"parent", "parent/child", "parent/child/child"}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected %v, actual %v", expected, actual)
+		}
+	})
+
+	t.Run("v4", func(t *testing.T) {
+		// v4 inode cores do not hold their inode number
+		f, err := os.Open("testdata/tiny/v4.xfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		err = fileSystem.Walk(".", WalkOptions{Types: []fs.FileMode{0}}, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			actual = append(actual, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(actual)
+		expected := []string{"etc/hostname", "etc/os-release", "hello.txt", "large", "sparse"}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected %v, actual %v", expected, actual)
+		}
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = fileSystem.Walk(".", WalkOptions{Include: []string{"["}}, func(string, fs.DirEntry, error) error { return nil })
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestFileSystemWalkConcurrent(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil, WithInodeCacheSize(4096), WithDirCacheSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []string
+	err = fs.WalkDir(fileSystem, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(p, "fmt_leaf_directories/") {
+			expected = append(expected, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(expected)
+
+	// walk collects the paths, skips fmt_leaf_directories and fails on the
+	// files under parent
+	walk := func(ctx context.Context, workers int) ([]string, error) {
+		var mu sync.Mutex
+		var paths []string
+		err := fileSystem.WalkConcurrent(ctx, ".", workers, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			paths = append(paths, p)
+			mu.Unlock()
+			switch {
+			case p == "fmt_leaf_directories":
+				return fs.SkipDir
+			case strings.HasPrefix(p, "parent/") && !d.IsDir():
+				return errors.New("failed")
+			}
+			return nil
+		})
+		sort.Strings(paths)
+		return paths, err
+	}
+
+	for _, workers := range []int{0, 1, 8} {
+		paths, err := walk(context.Background(), workers)
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("workers %d: expected %d paths, actual %d", workers, len(expected), len(paths))
+		}
+		var walkErrs WalkErrors
+		if !errors.As(err, &walkErrs) {
+			t.Fatalf("workers %d: expected WalkErrors, actual %v", workers, err)
+		}
+		var failed []string
+		for _, e := range walkErrs {
+			failed = append(failed, e.Path)
+		}
+		if want := []string{
+			"parent/child/child/child/child/child/executable",
+			"parent/child/child/child/child/executable",
+			"parent/child/child/child/child/nonexecutable",
+		}; !reflect.DeepEqual(failed, want) {
+			t.Errorf("workers %d: expected errors on %v, actual %v", workers, want, failed)
+		}
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int
+		var mu sync.Mutex
+		err := fileSystem.WalkConcurrent(ctx, ".", 4, func(p string, d fs.DirEntry, err error) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if calls++; calls == 10 {
+				cancel()
+			}
+			return nil
+		})
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, actual %v", err)
+		}
+		if calls >= len(expected) {
+			t.Errorf("the walk went on after the cancellation, %d calls", calls)
+		}
+	})
+
+	t.Run("file root", func(t *testing.T) {
+		var paths []string
+		err := fileSystem.WalkConcurrent(context.Background(), "etc/os-release", 2, func(p string, d fs.DirEntry, err error) error {
+			paths = append(paths, p)
+			return err
+		})
+		if err != nil || !reflect.DeepEqual(paths, []string{"etc/os-release"}) {
+			t.Errorf("unexpected walk %v: %v", paths, err)
+		}
+	})
+
+	t.Run("v4", func(t *testing.T) {
+		f, err := os.Open("testdata/tiny/v4.xfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mu sync.Mutex
+		var actual []string
+		err = fileSystem.WalkConcurrent(context.Background(), ".", 4, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			actual = append(actual, p)
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(actual)
+		expected := []string{".", "empty", "etc", "etc/hostname", "etc/os-release", "hello.txt", "large", "sparse"}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected %v, actual %v", expected, actual)
+		}
+	})
+}
+
+func TestFileSystemStream(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string][]byte{}
+	var dirs []string
+	err = fileSystem.Stream(".", WalkOptions{Include: []string{"etc/os-release", "parent/child/child/child/child/*"}},
+		func(p string, info fs.FileInfo, r io.Reader) error {
+			if r == nil {
+				if !info.IsDir() {
+					t.Errorf("%s: no reader for a regular file", p)
+				}
+				dirs = append(dirs, p)
+				return nil
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if int64(len(b)) != info.Size() {
+				t.Errorf("%s: expected %d bytes, actual %d", p, info.Size(), len(b))
+			}
+			contents[p] = b
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents["etc/os-release"], expected) {
+		t.Errorf("unexpected content %q", contents["etc/os-release"])
+	}
+	if len(contents) != 4 {
+		t.Errorf("expected 4 files, actual %d", len(contents))
+	}
+	if want := []string{"parent/child/child/child/child/child"}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("expected directories %v, actual %v", want, dirs)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		failed := errors.New("failed")
+		err := fileSystem.Stream("etc", WalkOptions{}, func(p string, info fs.FileInfo, r io.Reader) error {
+			if r != nil {
+				return failed
+			}
+			return nil
+		})
+		var pathErr *fs.PathError
+		if !errors.Is(err, failed) || !errors.As(err, &pathErr) || pathErr.Op != "stream" {
+			t.Errorf("expected the error of the function, actual %v", err)
+		}
+	})
+}
+
+func TestFileSystemOpenStream(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files int
+	err = fileSystem.Walk(".", WalkOptions{}, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		expected, err := fileSystem.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		r, err := fileSystem.OpenStream(name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if err := iotest.TestReader(r, expected); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files == 0 {
+		t.Fatal("no regular files")
+	}
+
+	if _, err := fileSystem.OpenStream("etc"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected %v, actual %v", fs.ErrInvalid, err)
+	}
+	if _, err := fileSystem.OpenStream("etc/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %v, actual %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestStreamReaderHoles(t *testing.T) {
+	image := bytes.Repeat([]byte{0xff}, 8*512)
+	copy(image[2*512:], "extent")
+	fileSystem := &FileSystem{r: io.NewSectionReader(bytes.NewReader(image), 0, int64(len(image)))}
+	fileSystem.PrimaryAG.SuperBlock.BlockSize = 512
+	r := &streamReader{
+		xfs:     fileSystem,
+		extents: []mappedExtent{{start: 1, end: 2, physical: 2}},
+		size:    3*512 + 10,
+	}
+	expected := make([]byte, 3*512+10)
+	copy(expected[512:], image[2*512:3*512])
+	if err := iotest.TestReader(r, expected); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,816 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// the features the write operations maintain
+	writeIncompat = XFS_SB_FEAT_INCOMPAT_FTYPE | XFS_SB_FEAT_INCOMPAT_SPINODES |
+		XFS_SB_FEAT_INCOMPAT_META_UUID | XFS_SB_FEAT_INCOMPAT_BIGTIME
+	writeRoCompat = XFS_SB_FEAT_RO_COMPAT_FINOBT | XFS_SB_FEAT_RO_COMPAT_REFLINK |
+		XFS_SB_FEAT_RO_COMPAT_INOBTCNT
+)
+
+// writeSupported returns an error, when the file system has a log to replay
+// or features, whose metadata the write operations do not maintain
+func (xfs *FileSystem) writeSupported() error {
+	sb := xfs.PrimaryAG.SuperBlock
+	switch {
+	case xfs.logState == LogDirty:
+		return ErrDirtyLog
+	case xfs.logState != LogClean:
+		return newUnsupportedFeatureError("writing with an external or unreadable log")
+	case !sb.IsV5():
+		return newUnsupportedFeatureError("writing v4 file systems")
+	case sb.Versionnum&XFS_SB_VERSION_BORGBIT != 0:
+		return newUnsupportedFeatureError("writing case-insensitive file systems")
+	case sb.FeaturesIncompat&XFS_SB_FEAT_INCOMPAT_FTYPE == 0:
+		return newUnsupportedFeatureError("writing without file types in directory entries")
+	case sb.FeaturesIncompat&^writeIncompat != 0:
+		return newUnsupportedFeatureError(fmt.Sprintf("writing incompatible features 0x%x", sb.FeaturesIncompat&^writeIncompat))
+	case sb.FeaturesRoCompat&^writeRoCompat != 0:
+		return newUnsupportedFeatureError(fmt.Sprintf("writing read-only compatible features 0x%x", sb.FeaturesRoCompat&^writeRoCompat))
+	case sb.Rblocks != 0:
+		return newUnsupportedFeatureError("writing file systems with a realtime volume")
+	case sb.Qflags&(XFS_UQUOTA_ACCT|XFS_GQUOTA_ACCT|XFS_PQUOTA_ACCT) != 0:
+		// the usage in the quota files would not follow the writes
+		return newUnsupportedFeatureError("writing file systems with quota accounting")
+	}
+	return nil
+}
+
+// writable returns ErrReadOnly unless the FileSystem was opened WithReadWrite
+func (xfs *FileSystem) writable() error {
+	if xfs.w == nil {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// WriteFile creates the regular file name holding data, with the permission
+// and special bits of perm and owned by root. name must not exist and its
+// directory must. The data is written to blocks taken from the free space
+// btrees, in no more extents than the inode holds, and the inode is a free
+// inode of the allocated inode chunks, new chunks are not allocated.
+// ErrNoSpace is returned when the blocks or inodes are missing. The image is
+// only written once every check passed, but a failed write to the image may
+// leave it inconsistent.
+func (xfs *FileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	const op = "write file"
+
+	if err := xfs.writable(); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	if !validPath(name) || name == "." {
+		return xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	if err := xfs.writeFile(name, data, perm); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	return nil
+}
+
+func (xfs *FileSystem) writeFile(name string, data []byte, perm fs.FileMode) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	dir, base, err := xfs.newEntryDir(name)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	agNumber, _, _ := sb.InodeOffset(dir)
+	inodeAlloc, err := xfs.planInode(uint64(agNumber))
+	if err != nil {
+		return err
+	}
+	ino := inodeAlloc.ino
+	agNumber, _, _ = sb.InodeOffset(ino)
+	blockSize := uint64(sb.BlockSize)
+	blockAlloc, err := xfs.planBlocks(uint64(agNumber), (uint64(len(data))+blockSize-1)/blockSize, xfs.DataForkSize(0)/16)
+	if err != nil {
+		return err
+	}
+	d, err := xfs.loadDir(dir)
+	if err != nil {
+		return err
+	}
+	d.add(base, XFS_DIR3_FT_REG_FILE, ino)
+	dirChange, err := d.plan(now, blockAlloc)
+	if err != nil {
+		return err
+	}
+	core, err := xfs.newInodeCore(ino, S_IFREG|unixMode(perm), now)
+	if err != nil {
+		return err
+	}
+
+	if err := inodeAlloc.commit(); err != nil {
+		return err
+	}
+	if err := blockAlloc.commit(); err != nil {
+		return err
+	}
+	var fork bytes.Buffer
+	var offset uint64
+	for _, e := range blockAlloc.extents {
+		n := uint64(e.Count) * blockSize
+		if offset+n > uint64(len(data)) {
+			n = uint64(len(data)) - offset
+		}
+		b := make([]byte, uint64(e.Count)*blockSize)
+		copy(b, data[offset:offset+n])
+		block := uint64(e.AG)<<sb.Agblklog | uint64(e.Start)
+		if err := xfs.writeBlock(sb.BlockToPhysicalOffset(block), b); err != nil {
+			return xfs.wrapBlockError(block, err)
+		}
+		rec := BmbtIrec{StartOff: offset / blockSize, StartBlock: block, BlockCount: uint64(e.Count)}.Pack()
+		if err := binary.Write(&fork, binary.BigEndian, rec); err != nil {
+			return xerrors.Errorf("failed to encode extent: %w", err)
+		}
+		offset += n
+		core.Nblocks += uint64(e.Count)
+		core.Nextents++
+	}
+	core.Size = uint64(len(data))
+	if err := xfs.writeInode(ino, core, fork.Bytes()); err != nil {
+		return err
+	}
+	return dirChange.commit()
+}
+
+// Mkdir creates the empty directory name with the permission and special bits
+// of perm and owned by root. name must not exist and its directory must. The
+// inode is taken as in WriteFile, the directory is stored in it in shortform,
+// and a shortform parent directory is converted to a block directory when the
+// entry does not fit in it.
+func (xfs *FileSystem) Mkdir(name string, perm fs.FileMode) error {
+	const op = "mkdir"
+
+	if err := xfs.writable(); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	if !validPath(name) || name == "." {
+		return xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	if err := xfs.mkdir(name, perm); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	return nil
+}
+
+func (xfs *FileSystem) mkdir(name string, perm fs.FileMode) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	dir, base, err := xfs.newEntryDir(name)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	agNumber, _, _ := sb.InodeOffset(dir)
+	inodeAlloc, err := xfs.planInode(uint64(agNumber))
+	if err != nil {
+		return err
+	}
+	ino := inodeAlloc.ino
+	d, err := xfs.loadDir(dir)
+	if err != nil {
+		return err
+	}
+	d.add(base, XFS_DIR3_FT_DIR, ino)
+	// ".." of the new directory
+	d.core.NLink++
+	dirChange, err := d.plan(now)
+	if err != nil {
+		return err
+	}
+	core, err := xfs.newInodeCore(ino, S_IFDIR|unixMode(perm), now)
+	if err != nil {
+		return err
+	}
+	core.Format = XFS_DINODE_FMT_LOCAL
+	core.NLink = 2
+	fork, ok := (&writableDir{xfs: xfs, ino: ino, core: core, parent: dir}).encodeShortform()
+	if !ok {
+		return xerrors.Errorf("empty directory does not fit in inode %d", ino)
+	}
+	core.Size = uint64(len(fork))
+
+	if err := inodeAlloc.commit(); err != nil {
+		return err
+	}
+	if err := xfs.writeInode(ino, core, fork); err != nil {
+		return err
+	}
+	return dirChange.commit()
+}
+
+// Remove removes the file or empty directory name. The inode and its blocks
+// are freed with its last link, inodes of files sharing blocks by reflink
+// are unsupported. ErrNotEmpty is returned for a directory holding entries.
+func (xfs *FileSystem) Remove(name string) error {
+	const op = "remove"
+
+	if err := xfs.writable(); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	if !validPath(name) || name == "." {
+		return xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	if err := xfs.remove(name); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	return nil
+}
+
+func (xfs *FileSystem) remove(name string) error {
+	dirName, base := path.Split(name)
+	dir, err := xfs.resolveDir(dirName)
+	if err != nil {
+		return xerrors.Errorf("failed to resolve directory: %w", err)
+	}
+	entry, err := xfs.lookupEntry(dir, base)
+	if err != nil {
+		return err
+	}
+	ino := entry.InodeNumber()
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	d, err := xfs.loadDir(dir)
+	if err != nil {
+		return err
+	}
+	if _, ok := d.remove(base); !ok {
+		return xfs.wrapInodeError(dir, newCorruptedError("directory", -1, "entry %s is not in the directory", base))
+	}
+	if inode.inodeCore.IsDir() {
+		if err := xfs.emptyDir(ino); err != nil {
+			return err
+		}
+		// ".." of the removed directory
+		d.core.NLink--
+	}
+	dirChange, err := d.plan(now)
+	if err != nil {
+		return err
+	}
+	unlink, err := xfs.planUnlink(inode, now)
+	if err != nil {
+		return err
+	}
+
+	if err := dirChange.commit(); err != nil {
+		return err
+	}
+	return unlink.commit()
+}
+
+// emptyDir returns ErrNotEmpty, when the directory ino holds entries
+func (xfs *FileSystem) emptyDir(ino uint64) error {
+	entries, err := xfs.listEntries(ino)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() != "." && e.Name() != ".." {
+			return ErrNotEmpty
+		}
+	}
+	return nil
+}
+
+// inodeUnlink is the removal of a link of an inode planned by planUnlink,
+// commit writes it
+type inodeUnlink struct {
+	xfs  *FileSystem
+	ino  uint64
+	core InodeCore
+	// blockFree and inodeFree release the inode with its last link
+	blockFree *blockFree
+	inodeFree *inodeFree
+}
+
+// planUnlink removes a link of the inode, or the links of a directory, whose
+// entry is removed at now, and frees the inode with the last one
+func (xfs *FileSystem) planUnlink(inode *Inode, now time.Time) (*inodeUnlink, error) {
+	u := &inodeUnlink{xfs: xfs, ino: inode.ino, core: inode.inodeCore}
+	if u.core.IsDir() || u.core.NLink == 0 {
+		u.core.NLink = 0
+	} else {
+		u.core.NLink--
+	}
+	if u.core.NLink > 0 {
+		u.core.Ctime = u.core.encodeTimestamp(now)
+		u.core.Changecount++
+		return u, nil
+	}
+
+	extents, err := xfs.inodeExtents(u.ino, u.core)
+	if err != nil {
+		return nil, err
+	}
+	if u.blockFree, err = xfs.planFree(extents); err != nil {
+		return nil, err
+	}
+	if u.inodeFree, err = xfs.planInodeFree(u.ino); err != nil {
+		return nil, err
+	}
+	u.core.free(now)
+	return u, nil
+}
+
+func (u *inodeUnlink) commit() error {
+	if u.inodeFree == nil {
+		return u.xfs.writeInode(u.ino, u.core, nil)
+	}
+	if err := u.blockFree.commit(); err != nil {
+		return err
+	}
+	if err := u.inodeFree.commit(); err != nil {
+		return err
+	}
+	if err := u.xfs.writeInode(u.ino, u.core, []byte{}); err != nil {
+		return err
+	}
+	u.xfs.dirCache.Remove(u.ino)
+	return nil
+}
+
+// Rename renames the file or directory oldname to newname, in the same or
+// another directory. An existing newname is replaced and removed as by
+// Remove, a directory only by an empty directory. A directory is not moved
+// below itself.
+func (xfs *FileSystem) Rename(oldname, newname string) error {
+	const op = "rename"
+
+	if err := xfs.writable(); err != nil {
+		return xfs.wrapError(op, oldname, err)
+	}
+	if !validPath(oldname) || oldname == "." || !validPath(newname) || newname == "." {
+		return xfs.wrapError(op, oldname, fs.ErrInvalid)
+	}
+	if err := xfs.rename(oldname, newname); err != nil {
+		return xfs.wrapError(op, oldname, err)
+	}
+	return nil
+}
+
+func (xfs *FileSystem) rename(oldname, newname string) error {
+	oldDirName, oldBase := path.Split(oldname)
+	oldDir, err := xfs.resolveDir(oldDirName)
+	if err != nil {
+		return xerrors.Errorf("failed to resolve directory: %w", err)
+	}
+	entry, err := xfs.lookupEntry(oldDir, oldBase)
+	if err != nil {
+		return err
+	}
+	ino := entry.InodeNumber()
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return err
+	}
+	isDir := inode.inodeCore.IsDir()
+
+	newDirName, newBase := path.Split(newname)
+	if !validName(newBase) {
+		return xerrors.Errorf("%s: %w", newname, fs.ErrInvalid)
+	}
+	newDir, err := xfs.resolveDir(newDirName)
+	if err != nil {
+		return xerrors.Errorf("failed to resolve directory of %s: %w", newname, err)
+	}
+	if isDir && newDir != oldDir {
+		if err := xfs.notBelow(ino, newDir); err != nil {
+			return xerrors.Errorf("%s: %w", newname, err)
+		}
+	}
+	var target *Inode
+	switch t, err := xfs.lookupEntry(newDir, newBase); {
+	case err == nil:
+		if t.InodeNumber() == ino {
+			return nil
+		}
+		if target, err = xfs.ParseInode(t.InodeNumber()); err != nil {
+			return err
+		}
+		switch {
+		case isDir && !target.inodeCore.IsDir():
+			return xerrors.Errorf("%s is not a directory: %w", newname, fs.ErrExist)
+		case !isDir && target.inodeCore.IsDir():
+			return xerrors.Errorf("%s is a directory: %w", newname, fs.ErrExist)
+		case isDir:
+			if err := xfs.emptyDir(target.ino); err != nil {
+				return xerrors.Errorf("%s: %w", newname, err)
+			}
+		}
+	case !xerrors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	now := time.Now()
+
+	src, err := xfs.loadDir(oldDir)
+	if err != nil {
+		return err
+	}
+	dst := src
+	if newDir != oldDir {
+		if dst, err = xfs.loadDir(newDir); err != nil {
+			return err
+		}
+	}
+	slot, ok := src.remove(oldBase)
+	if !ok {
+		return xfs.wrapInodeError(oldDir, newCorruptedError("directory", -1, "entry %s is not in the directory", oldBase))
+	}
+	if target != nil {
+		dst.remove(newBase)
+		if isDir {
+			// ".." of the replaced directory
+			dst.core.NLink--
+		}
+	}
+	dst.add(newBase, slot.ftype, ino)
+	if isDir && dst != src {
+		src.core.NLink--
+		dst.core.NLink++
+	}
+
+	dirs := []*writableDir{dst}
+	if src != dst {
+		dirs = append(dirs, src)
+	}
+	var (
+		changes []*dirChange
+		planned []*blockAlloc
+	)
+	for _, d := range dirs {
+		c, err := d.plan(now, planned...)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, c)
+		if c.alloc != nil {
+			planned = append(planned, c.alloc)
+		}
+	}
+	// the moved inode changes, and so does ".." of a moved directory
+	core := inode.inodeCore
+	core.Ctime = core.encodeTimestamp(now)
+	core.Changecount++
+	moved := false
+	if isDir && dst != src {
+		d, err := xfs.loadDir(ino)
+		if err != nil {
+			return err
+		}
+		d.parent = newDir
+		c, err := d.plan(now, planned...)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, c)
+		moved = true
+	}
+	var unlink *inodeUnlink
+	if target != nil {
+		if unlink, err = xfs.planUnlink(target, now); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range changes {
+		if err := c.commit(); err != nil {
+			return err
+		}
+	}
+	if !moved {
+		if err := xfs.writeInode(ino, core, nil); err != nil {
+			return err
+		}
+	}
+	if unlink != nil {
+		return unlink.commit()
+	}
+	return nil
+}
+
+// notBelow returns fs.ErrInvalid, when the directory dir is the directory ino
+// or below it
+func (xfs *FileSystem) notBelow(ino, dir uint64) error {
+	visited := map[uint64]bool{}
+	for dir != xfs.PrimaryAG.SuperBlock.Rootino {
+		if dir == ino {
+			return xerrors.Errorf("directory inode %d is below itself: %w", ino, fs.ErrInvalid)
+		}
+		if visited[dir] {
+			return xfs.wrapInodeError(dir, newCorruptedError("directory", -1, ".. entries form a loop"))
+		}
+		visited[dir] = true
+		parent, err := xfs.dirParent(dir)
+		if err != nil {
+			return xerrors.Errorf("failed to find the parent of directory inode %d: %w", dir, err)
+		}
+		dir = parent
+	}
+	return nil
+}
+
+// dirParent returns the inode number of ".." of the directory ino, shortform
+// directories have it in their header only
+func (xfs *FileSystem) dirParent(ino uint64) (uint64, error) {
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return 0, err
+	}
+	if inode.directoryLocal != nil {
+		return inode.directoryLocal.dir2SfHdr.Parent, nil
+	}
+	entry, err := xfs.lookupEntry(ino, "..")
+	if err != nil {
+		return 0, err
+	}
+	return entry.InodeNumber(), nil
+}
+
+// inodeExtents returns the extents of the data and attribute forks of the
+// inode ino of core, forks in btree format are unsupported
+func (xfs *FileSystem) inodeExtents(ino uint64, core InodeCore) ([]BmbtIrec, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	if core.Flags2&XFS_DIFLAG2_REFLINK != 0 {
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("freeing the blocks of reflinked inode %d", ino))
+	}
+	raw, err := xfs.ReadRawInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	forks := []struct {
+		format uint8
+		offset int
+		count  uint32
+	}{
+		{core.Format, sb.InodeCoreSize(), core.Nextents},
+		{core.Aformat, sb.InodeCoreSize() + int(core.Forkoff)<<3, uint32(core.Anextents)},
+	}
+	if core.Forkoff == 0 {
+		forks = forks[:1]
+	}
+
+	var extents []BmbtIrec
+	for _, f := range forks {
+		switch f.format {
+		case XFS_DINODE_FMT_DEV, XFS_DINODE_FMT_LOCAL:
+			continue
+		case XFS_DINODE_FMT_EXTENTS:
+		default:
+			return nil, newUnsupportedFeatureError(fmt.Sprintf("freeing the blocks of a fork in format %d, inode %d", f.format, ino))
+		}
+		recs, err := xfs.parseBmbtRecs(bytes.NewReader(raw[f.offset:]), f.count)
+		if err != nil {
+			return nil, xfs.wrapInodeError(ino, err)
+		}
+		for _, rec := range recs {
+			extents = append(extents, rec.Unpack())
+		}
+	}
+	return extents, nil
+}
+
+// newEntryDir resolves the directory of name, which must not exist, and
+// returns the directory inode number and the base name
+func (xfs *FileSystem) newEntryDir(name string) (uint64, string, error) {
+	dirName, base := path.Split(name)
+	if !validName(base) {
+		return 0, "", fs.ErrInvalid
+	}
+	dir, err := xfs.resolveDir(dirName)
+	if err != nil {
+		return 0, "", xerrors.Errorf("failed to resolve directory: %w", err)
+	}
+	_, err = xfs.lookupEntry(dir, base)
+	switch {
+	case err == nil:
+		return 0, "", fs.ErrExist
+	case !xerrors.Is(err, fs.ErrNotExist):
+		return 0, "", err
+	}
+	return dir, base, nil
+}
+
+// validName reports whether name fits in a directory entry
+func validName(name string) bool {
+	return len(name) <= XFS_MAXNAMELEN && strings.IndexByte(name, 0) < 0
+}
+
+// unixMode returns the permission and special bits of perm as di_mode bits
+func unixMode(perm fs.FileMode) uint16 {
+	mode := uint16(perm.Perm())
+	if perm&fs.ModeSetuid != 0 {
+		mode |= S_ISUID
+	}
+	if perm&fs.ModeSetgid != 0 {
+		mode |= S_ISGID
+	}
+	if perm&fs.ModeSticky != 0 {
+		mode |= S_ISVTX
+	}
+	return mode
+}
+
+// newInodeCore returns the core of the new inode ino of mode, the free inode
+// it replaces gives the generation
+func (xfs *FileSystem) newInodeCore(ino uint64, mode uint16, now time.Time) (InodeCore, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	raw, err := xfs.ReadRawInode(ino)
+	if err != nil {
+		return InodeCore{}, err
+	}
+	var old InodeCore
+	if err := binary.Read(bytes.NewReader(raw), binary.BigEndian, &old); err != nil {
+		return InodeCore{}, xerrors.Errorf("failed to read InodeCore: %w", err)
+	}
+	var gen uint32
+	if old.Magic == XFS_DINODE_MAGIC {
+		if old.Mode != 0 {
+			return InodeCore{}, xfs.wrapInodeError(ino, newCorruptedError("inode", -1, "free inode has mode 0%o", old.Mode))
+		}
+		gen = old.Gen + 1
+	}
+
+	core := InodeCore{
+		Magic:        XFS_DINODE_MAGIC,
+		Mode:         mode,
+		Version:      3,
+		Format:       XFS_DINODE_FMT_EXTENTS,
+		Aformat:      XFS_DINODE_FMT_EXTENTS,
+		NLink:        1,
+		Gen:          gen,
+		NextUnlinked: NULLAGINO,
+		Changecount:  1,
+		Ino:          ino,
+		MetaUUID:     sb.UUID,
+	}
+	if sb.FeaturesIncompat&XFS_SB_FEAT_INCOMPAT_META_UUID != 0 {
+		core.MetaUUID = sb.MetaUUID
+	}
+	if sb.FeaturesIncompat&XFS_SB_FEAT_INCOMPAT_BIGTIME != 0 {
+		core.Flags2 |= XFS_DIFLAG2_BIGTIME
+	}
+	t := core.encodeTimestamp(now)
+	core.Atime, core.Mtime, core.Ctime, core.Crtime = t, t, t, t
+	return core, nil
+}
+
+// touch sets the modification and change times of an inode, whose content
+// changed
+func (ic *InodeCore) touch(now time.Time) {
+	ic.Mtime = ic.encodeTimestamp(now)
+	ic.Ctime = ic.Mtime
+	ic.Changecount++
+}
+
+// free resets an inode freed at now, as xfs_ifree. The generation is kept,
+// newInodeCore increments it.
+func (ic *InodeCore) free(now time.Time) {
+	*ic = InodeCore{
+		Magic:        ic.Magic,
+		Version:      ic.Version,
+		Format:       XFS_DINODE_FMT_EXTENTS,
+		Aformat:      XFS_DINODE_FMT_EXTENTS,
+		Atime:        ic.Atime,
+		Mtime:        ic.Mtime,
+		Gen:          ic.Gen,
+		NextUnlinked: NULLAGINO,
+		Changecount:  ic.Changecount + 1,
+		Flags2:       ic.Flags2 & XFS_DIFLAG2_BIGTIME,
+		Crtime:       ic.Crtime,
+		Ino:          ic.Ino,
+		MetaUUID:     ic.MetaUUID,
+	}
+	ic.Ctime = ic.encodeTimestamp(now)
+}
+
+// writeInode writes the core and the data fork of the inode ino, fork is
+// written at the start of the data fork and the rest of it is zeroed, a nil
+// fork keeps the data fork. The attribute fork is kept.
+func (xfs *FileSystem) writeInode(ino uint64, core InodeCore, fork []byte) error {
+	sb := xfs.PrimaryAG.SuperBlock
+	buf, err := xfs.ReadRawInode(ino)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, core); err != nil {
+		return xerrors.Errorf("failed to encode InodeCore: %w", err)
+	}
+	copy(buf, b.Bytes())
+	if fork != nil {
+		dataFork := buf[sb.InodeCoreSize() : sb.InodeCoreSize()+xfs.DataForkSize(core.Forkoff)]
+		if len(fork) > len(dataFork) {
+			return xfs.wrapInodeError(ino, xerrors.Errorf("data fork of %d bytes exceeds %d bytes", len(fork), len(dataFork)))
+		}
+		copy(dataFork, fork)
+		for i := len(fork); i < len(dataFork); i++ {
+			dataFork[i] = 0
+		}
+	}
+	return xfs.writeRawInode(ino, buf)
+}
+
+// writeRawInode writes the inode ino of sb_inodesize bytes buf, after
+// updating its crc
+func (xfs *FileSystem) writeRawInode(ino uint64, buf []byte) error {
+	setCRC(buf, XFS_DINODE_CRC_OFF)
+	xfs.inodeCache.Remove(ino)
+	if err := xfs.writeAt(buf, int64(xfs.PrimaryAG.SuperBlock.InodeAbsOffset(ino))); err != nil {
+		return xfs.wrapInodeError(ino, err)
+	}
+	return nil
+}
+
+// writeSuperBlock writes the primary superblock, the counters are only
+// maintained in the primary superblock
+func (xfs *FileSystem) writeSuperBlock() error {
+	xfs.AGs[0].SuperBlock = xfs.PrimaryAG.SuperBlock
+	if err := xfs.writeHeader(0, xfs.PrimaryAG.SuperBlock, XFS_SB_CRC_OFF); err != nil {
+		return xerrors.Errorf("failed to write superblock: %w", err)
+	}
+	return nil
+}
+
+// writeAGF writes the AGF of the AG agNumber from xfs.AGs
+func (xfs *FileSystem) writeAGF(agNumber uint64) error {
+	if agNumber == 0 {
+		xfs.PrimaryAG.Agf = xfs.AGs[0].Agf
+	}
+	offset := xfs.agOffset(agNumber) + int64(xfs.PrimaryAG.SuperBlock.Sectsize)
+	if err := xfs.writeHeader(offset, xfs.AGs[agNumber].Agf, XFS_AGF_CRC_OFF); err != nil {
+		return xerrors.Errorf("failed to write agf of AG %d: %w", agNumber, err)
+	}
+	return nil
+}
+
+// writeAGI writes the AGI of the AG agNumber from xfs.AGs
+func (xfs *FileSystem) writeAGI(agNumber uint64) error {
+	if agNumber == 0 {
+		xfs.PrimaryAG.Agi = xfs.AGs[0].Agi
+	}
+	offset := xfs.agOffset(agNumber) + 2*int64(xfs.PrimaryAG.SuperBlock.Sectsize)
+	if err := xfs.writeHeader(offset, xfs.AGs[agNumber].Agi, XFS_AGI_CRC_OFF); err != nil {
+		return xerrors.Errorf("failed to write agi of AG %d: %w", agNumber, err)
+	}
+	return nil
+}
+
+// agOffset returns the byte offset of the AG agNumber
+func (xfs *FileSystem) agOffset(agNumber uint64) int64 {
+	sb := xfs.PrimaryAG.SuperBlock
+	return int64(agNumber) * int64(sb.Agblocks) * int64(sb.BlockSize)
+}
+
+// writeHeader encodes v over the start of the sector at offset, the rest of
+// the sector is kept, and updates the crc of the sector at crcOff
+func (xfs *FileSystem) writeHeader(offset int64, v interface{}, crcOff int) error {
+	sector := make([]byte, xfs.PrimaryAG.SuperBlock.Sectsize)
+	if _, err := xfs.r.ReadAt(sector, offset); err != nil {
+		return xerrors.Errorf("failed to read sector at %d: %w", offset, err)
+	}
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, v); err != nil {
+		return xerrors.Errorf("failed to encode: %w", err)
+	}
+	copy(sector, b.Bytes())
+	setCRC(sector, crcOff)
+	return xfs.writeAt(sector, offset)
+}
+
+// writeBlock writes b at the physical block n
+func (xfs *FileSystem) writeBlock(n int64, b []byte) error {
+	return xfs.writeAt(b, n*int64(xfs.PrimaryAG.SuperBlock.BlockSize))
+}
+
+func (xfs *FileSystem) writeAt(b []byte, offset int64) error {
+	if _, err := xfs.w.WriteAt(b, offset); err != nil {
+		return xerrors.Errorf("failed to write %d bytes at %d: %w", len(b), offset, err)
+	}
+	return nil
+}
+
+// setCRC stores the crc of b at crcOff, as verifyCRC checks it
+func setCRC(b []byte, crcOff int) {
+	binary.LittleEndian.PutUint32(b[crcOff:], 0)
+	binary.LittleEndian.PutUint32(b[crcOff:], crc32.Checksum(b, crc32c))
+}
@@ -0,0 +1,515 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+// memImage is an image in memory opened WithReadWrite
+type memImage []byte
+
+func (m memImage) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m).ReadAt(p, off)
+}
+
+func (m memImage) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(m)) {
+		return 0, xerrors.Errorf("write of %d bytes at %d out of range", len(p), off)
+	}
+	return copy(m[off:], p), nil
+}
+
+func (m memImage) Size() int64 { return int64(len(m)) }
+
+func openWritable(t *testing.T, image string) (memImage, *FileSystem) {
+	t.Helper()
+	b, err := os.ReadFile(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := memImage(b)
+	fileSystem, err := OpenReaderAt(img, nil, WithReadWrite(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, fileSystem
+}
+
+// reopen opens the written image read only, and fails when Verify or, with
+// XFS_MOUNT_TEST=1, xfs_repair finds a problem in it
+func reopen(t *testing.T, img memImage) *FileSystem {
+	t.Helper()
+	fileSystem, err := OpenReaderAt(img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := fileSystem.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("%s (inode %d): %v", p.Path, p.Ino, p.Err)
+	}
+	// Verify does not check the btree blocks
+	sb := fileSystem.PrimaryAG.SuperBlock
+	for ag := range fileSystem.AGs {
+		for _, bt := range []shortBtree{
+			fileSystem.bnoBtree(uint64(ag)), fileSystem.cntBtree(uint64(ag)),
+			fileSystem.inoBtree(uint64(ag)), fileSystem.finoBtree(uint64(ag)),
+		} {
+			b, err := fileSystem.readBlock(sb.BlockToPhysicalOffset(uint64(ag)<<sb.Agblklog|uint64(bt.root)), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyCRC(bt.name, b, XFS_BTREE_SBLOCK_CRC_OFF); err != nil {
+				t.Errorf("AG %d: %v", ag, err)
+			}
+		}
+	}
+	repair(t, img)
+	return fileSystem
+}
+
+// repair checks the image with xfs_repair -n, which only reports problems.
+// It needs xfsprogs and is enabled with XFS_MOUNT_TEST=1 like the mount
+// tests, as in the container of testdata/matrix/docker.sh.
+func repair(t *testing.T, img memImage) {
+	t.Helper()
+	if os.Getenv("XFS_MOUNT_TEST") == "" {
+		return
+	}
+	name := filepath.Join(t.TempDir(), "image.xfs")
+	if err := os.WriteFile(name, img, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("xfs_repair", "-n", "-f", name).CombinedOutput(); err != nil {
+		t.Fatalf("xfs_repair: %s: %s", err, out)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	img, fileSystem := openWritable(t, "testdata/image.xfs")
+	before := fileSystem.PrimaryAG.SuperBlock
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 3*4096/16+100)
+	files := []struct {
+		name string
+		data []byte
+		perm fs.FileMode
+	}{
+		{name: "hello.txt", data: []byte("hello, world\n"), perm: 0644},
+		{name: "etc/large", data: large, perm: 0600 | fs.ModeSetuid},
+		{name: "empty", perm: 0755},
+	}
+	for _, f := range files {
+		if err := fileSystem.WriteFile(f.name, f.data, f.perm); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+	}
+
+	for _, fileSystem := range []*FileSystem{fileSystem, reopen(t, img)} {
+		for _, f := range files {
+			data, err := fs.ReadFile(fileSystem, f.name)
+			if err != nil {
+				t.Fatalf("%s: %v", f.name, err)
+			}
+			if !bytes.Equal(data, f.data) {
+				t.Errorf("%s: expected %d bytes, actual %d bytes", f.name, len(f.data), len(data))
+			}
+			info, err := fs.Stat(fileSystem, f.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// FileMode keeps the di_mode bits in the low bits
+			mode := info.Mode() & (fs.ModeType | fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+			if mode != f.perm || info.Size() != int64(len(f.data)) {
+				t.Errorf("%s: expected %v %d, actual %v %d", f.name, f.perm, len(f.data), mode, info.Size())
+			}
+
+			entries, err := fs.ReadDir(fileSystem, path.Dir(f.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found bool
+			for _, e := range entries {
+				found = found || e.Name() == path.Base(f.name)
+			}
+			if !found {
+				t.Errorf("%s: missing from its directory", f.name)
+			}
+		}
+	}
+
+	after := reopen(t, img).PrimaryAG.SuperBlock
+	if after.Ifree != before.Ifree-3 {
+		t.Errorf("expected %d free inodes, actual %d", before.Ifree-3, after.Ifree)
+	}
+	blocks := uint64(1 + (len(large)+4095)/4096)
+	if after.Fdblocks != before.Fdblocks-blocks {
+		t.Errorf("expected %d free blocks, actual %d", before.Fdblocks-blocks, after.Fdblocks)
+	}
+}
+
+func TestWriteFileErrors(t *testing.T) {
+	_, fileSystem := openWritable(t, "testdata/image.xfs")
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "etc/os-release", err: fs.ErrExist},
+		{name: "etc", err: fs.ErrExist},
+		{name: "missing/file", err: fs.ErrNotExist},
+		{name: "/abs", err: fs.ErrInvalid},
+		{name: ".", err: fs.ErrInvalid},
+		{name: string(bytes.Repeat([]byte("a"), 256)), err: fs.ErrInvalid},
+		{name: "fmt_leaf_directories/new", err: ErrUnsupportedFeature},
+		{name: "too-large", data: make([]byte, 10<<20), err: ErrNoSpace},
+	}
+	for _, tt := range tests {
+		err := fileSystem.WriteFile(tt.name, tt.data, 0644)
+		if !xerrors.Is(err, tt.err) {
+			t.Errorf("%.20s: expected %v, actual %v", tt.name, tt.err, err)
+		}
+	}
+
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := OpenReaderAt(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readOnly.WriteFile("new", nil, 0644); !xerrors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v, actual %v", ErrReadOnly, err)
+	}
+
+	// the quota usage is not updated, images accounting it are read only
+	for _, flag := range []uint16{XFS_UQUOTA_ACCT, XFS_GQUOTA_ACCT, XFS_PQUOTA_ACCT} {
+		img := memImage(append([]byte(nil), b...))
+		binary.BigEndian.PutUint16(img[176:], flag) // sb_qflags
+		setCRC(img[:512], XFS_SB_CRC_OFF)
+		if _, err := OpenReaderAt(img, nil, WithReadWrite(img)); !xerrors.Is(err, ErrUnsupportedFeature) {
+			t.Errorf("qflags 0x%x: expected %v, actual %v", flag, ErrUnsupportedFeature, err)
+		}
+		if _, err := OpenReaderAt(img, nil); err != nil {
+			t.Errorf("qflags 0x%x: %v", flag, err)
+		}
+	}
+}
+
+func TestEncodeBlockDirectory(t *testing.T) {
+	_, fileSystem := openWritable(t, "testdata/image.xfs")
+	d := &writableDir{xfs: fileSystem, ino: 128, parent: 64, isBlock: true}
+	for _, name := range []string{"a", "bb", "ccc", "dddd", "eeeee", "a name of some length"} {
+		d.add(name, XFS_DIR3_FT_REG_FILE, uint64(1000+len(name)))
+	}
+	b, ok := d.encodeBlock()
+	if !ok {
+		t.Fatal("entries do not fit")
+	}
+	block, err := fileSystem.parseDir2Block(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCRC("directory block", b, XFS_DIR3_DATA_CRC_OFF); err != nil {
+		t.Error(err)
+	}
+	if len(block.Entries) != len(d.entries)+2 {
+		t.Fatalf("expected %d entries, actual %d", len(d.entries)+2, len(block.Entries))
+	}
+	for i, e := range block.Entries[2:] {
+		if e.EntryName != d.entries[i].name || e.Inumber != d.entries[i].ino {
+			t.Errorf("expected %s %d, actual %s %d", d.entries[i].name, d.entries[i].ino, e.EntryName, e.Inumber)
+		}
+	}
+}
+
+func TestMkdir(t *testing.T) {
+	img, fileSystem := openWritable(t, "testdata/image.xfs")
+	before := fileSystem.PrimaryAG.SuperBlock
+
+	for _, name := range []string{"new", "new/sub", "etc/new"} {
+		if err := fileSystem.Mkdir(name, 0750); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if err := fileSystem.WriteFile("new/sub/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileSystem.Mkdir("new/sub", 0750); !xerrors.Is(err, fs.ErrExist) {
+		t.Errorf("expected %v, actual %v", fs.ErrExist, err)
+	}
+
+	// the entries do not fit in the shortform root directory and etc, the
+	// latter with an attribute fork, which are converted to block directories
+	var names []string
+	for i := 0; i < 8; i++ {
+		names = append(names, fmt.Sprintf("a directory entry with a long name %d", i))
+	}
+	for _, dir := range []string{".", "etc"} {
+		for _, name := range names {
+			if err := fileSystem.Mkdir(path.Join(dir, name), 0755); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	}
+
+	reopened := reopen(t, img)
+	sb := reopened.PrimaryAG.SuperBlock
+	for _, dir := range []string{".", "etc"} {
+		info, err := fs.Stat(reopened, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ino := info.(*FileInfo).Ino()
+		inode, err := reopened.ParseInode(ino)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inode.inodeCore.Format != XFS_DINODE_FMT_EXTENTS || inode.directoryExtents == nil {
+			t.Fatalf("%s: expected a block directory, actual format %d", dir, inode.inodeCore.Format)
+		}
+		block := inode.extents()[0].StartBlock
+		b, err := reopened.readBlock(sb.BlockToPhysicalOffset(block), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirBlock, err := reopened.parseDir2Block(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr := dirBlock.Header.Dir3BlkHdr
+		if hdr.Owner != ino || hdr.MetaUUID != sb.UUID || hdr.BlockNo != uint64(sb.BlockToPhysicalOffset(block))*8 {
+			t.Errorf("%s: unexpected header %+v", dir, hdr)
+		}
+
+		entries, err := fs.ReadDir(reopened, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := map[string]bool{}
+		for _, e := range entries {
+			found[e.Name()] = e.IsDir()
+		}
+		for _, name := range append(names, "new") {
+			if !found[name] {
+				t.Errorf("%s: missing directory %s", dir, name)
+			}
+		}
+	}
+	data, err := fs.ReadFile(reopened, "new/sub/file")
+	if err != nil || string(data) != "data" {
+		t.Errorf("expected data, actual %q %v", data, err)
+	}
+
+	if expected := before.Ifree - 4 - 2*uint64(len(names)); sb.Ifree != expected {
+		t.Errorf("expected %d free inodes, actual %d", expected, sb.Ifree)
+	}
+	// the file and the blocks of the converted directories
+	if expected := before.Fdblocks - 3; sb.Fdblocks != expected {
+		t.Errorf("expected %d free blocks, actual %d", expected, sb.Fdblocks)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	img, fileSystem := openWritable(t, "testdata/image.xfs")
+	before := fileSystem.PrimaryAG.SuperBlock
+	freeBefore, err := fileSystem.FreeExtents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// created and removed, the free space is merged back
+	if err := fileSystem.Mkdir("new", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileSystem.WriteFile("new/file", bytes.Repeat([]byte("x"), 5*4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileSystem.Remove("new"); !xerrors.Is(err, ErrNotEmpty) {
+		t.Errorf("expected %v, actual %v", ErrNotEmpty, err)
+	}
+	for _, name := range []string{"new/file", "new"} {
+		if err := fileSystem.Remove(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	freeAfter, err := fileSystem.FreeExtents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(freeAfter) != fmt.Sprint(freeBefore) {
+		t.Errorf("expected free extents %v, actual %v", freeBefore, freeAfter)
+	}
+
+	info, err := fs.Stat(fileSystem, "etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino := info.(*FileInfo).Ino()
+	inode, err := fileSystem.ParseInode(ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"etc/os-release", "fmt_local_directory/short_form"} {
+		if err := fileSystem.Remove(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	reopened := reopen(t, img)
+	for _, name := range []string{"new", "etc/os-release", "fmt_local_directory/short_form"} {
+		if _, err := fs.Stat(reopened, name); !xerrors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected %v, actual %v", name, fs.ErrNotExist, err)
+		}
+	}
+	sb := reopened.PrimaryAG.SuperBlock
+	if sb.Ifree != before.Ifree+2 {
+		t.Errorf("expected %d free inodes, actual %d", before.Ifree+2, sb.Ifree)
+	}
+	if expected := before.Fdblocks + inode.inodeCore.Nblocks; sb.Fdblocks != expected {
+		t.Errorf("expected %d free blocks, actual %d", expected, sb.Fdblocks)
+	}
+	raw, err := reopened.ReadRawInode(ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := uint16(raw[2])<<8 | uint16(raw[3]); mode != 0 {
+		t.Errorf("expected a free inode, actual mode 0%o", mode)
+	}
+
+	// the freed inodes are allocated again, the first one of the removed
+	// directory
+	for _, name := range []string{"etc/os-release", "etc/new"} {
+		if err := fileSystem.WriteFile(name, []byte("ID=test\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err = fs.Stat(reopen(t, img), "etc/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := info.(*FileInfo).Ino(); actual != ino {
+		t.Errorf("expected inode %d, actual %d", ino, actual)
+	}
+}
+
+func TestRemoveErrors(t *testing.T) {
+	_, fileSystem := openWritable(t, "testdata/image.xfs")
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "missing", err: fs.ErrNotExist},
+		{name: "etc/missing", err: fs.ErrNotExist},
+		{name: ".", err: fs.ErrInvalid},
+		{name: "etc", err: ErrNotEmpty},
+		{name: "fmt_leaf_directories", err: ErrNotEmpty},
+	}
+	for _, tt := range tests {
+		if err := fileSystem.Remove(tt.name); !xerrors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, actual %v", tt.name, tt.err, err)
+		}
+	}
+
+	entries, err := fs.ReadDir(fileSystem, "fmt_leaf_directories")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := path.Join("fmt_leaf_directories", entries[0].Name())
+	if err := fileSystem.Remove(name); !xerrors.Is(err, ErrUnsupportedFeature) {
+		t.Errorf("%s: expected %v, actual %v", name, ErrUnsupportedFeature, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	img, fileSystem := openWritable(t, "testdata/image.xfs")
+	osRelease, err := fs.ReadFile(fileSystem, "etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := fileSystem.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fileSystem.Mkdir("dir-"+name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := fileSystem.PrimaryAG.SuperBlock
+
+	renames := []struct{ oldname, newname string }{
+		{"etc/os-release", "etc/os-release.bak"},
+		{"etc/os-release.bak", "os-release"},
+		{"parent/child", "moved"},
+		{"moved/child", "etc/child"},
+		{"a", "b"},
+		{"dir-a", "dir-b"},
+		{"dir-b", "etc/dir-b"},
+		{"os-release", "os-release"},
+	}
+	for _, r := range renames {
+		if err := fileSystem.Rename(r.oldname, r.newname); err != nil {
+			t.Fatalf("%s to %s: %v", r.oldname, r.newname, err)
+		}
+	}
+
+	reopened := reopen(t, img)
+	for _, name := range []string{"etc/os-release", "etc/os-release.bak", "parent/child", "moved/child", "a", "dir-a", "dir-b"} {
+		if _, err := fs.Stat(reopened, name); !xerrors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected %v, actual %v", name, fs.ErrNotExist, err)
+		}
+	}
+	for _, name := range []string{"moved", "etc/child/child/child", "etc/dir-b", "parent"} {
+		if info, err := fs.Stat(reopened, name); err != nil || !info.IsDir() {
+			t.Errorf("%s: expected a directory, actual %v", name, err)
+		}
+	}
+	for name, expected := range map[string][]byte{"os-release": osRelease, "b": []byte("a")} {
+		if data, err := fs.ReadFile(reopened, name); err != nil || !bytes.Equal(data, expected) {
+			t.Errorf("%s: expected %q, actual %q %v", name, expected, data, err)
+		}
+	}
+	// the replaced file and directory are freed
+	if sb := reopened.PrimaryAG.SuperBlock; sb.Ifree != before.Ifree+2 {
+		t.Errorf("expected %d free inodes, actual %d", before.Ifree+2, sb.Ifree)
+	}
+}
+
+func TestRenameErrors(t *testing.T) {
+	_, fileSystem := openWritable(t, "testdata/image.xfs")
+	if err := fileSystem.Mkdir("empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		oldname, newname string
+		err              error
+	}{
+		{"missing", "new", fs.ErrNotExist},
+		{"etc/os-release", "missing/new", fs.ErrNotExist},
+		{"parent", "parent/child/new", fs.ErrInvalid},
+		{"parent", "parent/new", fs.ErrInvalid},
+		{".", "new", fs.ErrInvalid},
+		{"etc/os-release", ".", fs.ErrInvalid},
+		{"parent", "etc/os-release", fs.ErrExist},
+		{"etc/os-release", "empty", fs.ErrExist},
+		{"empty", "etc", ErrNotEmpty},
+		{"etc/os-release", "fmt_leaf_directories/new", ErrUnsupportedFeature},
+	}
+	for _, tt := range tests {
+		if err := fileSystem.Rename(tt.oldname, tt.newname); !xerrors.Is(err, tt.err) {
+			t.Errorf("%s to %s: expected %v, actual %v", tt.oldname, tt.newname, tt.err, err)
+		}
+	}
+}
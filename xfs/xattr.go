@@ -0,0 +1,379 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+
+	"golang.org/x/xerrors"
+)
+
+// Xattr is an extended attribute, Name carries the namespace prefix such as
+// "user." or "security.". POSIX ACLs are listed as trusted.SGI_ACL_FILE and
+// trusted.SGI_ACL_DEFAULT with their on-disk value.
+type Xattr struct {
+	Name  string
+	Value []byte
+}
+
+// https://github.com/torvalds/linux/blob/5bfc75d92efd494db37f5c4c173d3639d4772966/fs/xfs/libxfs/xfs_da_format.h#L583-L592
+type AttrShortformHdr struct {
+	Totsize uint16
+	Count   uint8
+	Padding uint8
+}
+
+// https://github.com/torvalds/linux/blob/5bfc75d92efd494db37f5c4c173d3639d4772966/fs/xfs/libxfs/xfs_da_format.h#L19-L24
+type DaBlkinfo struct {
+	Forw  uint32
+	Back  uint32
+	Magic uint16
+	Pad   uint16
+}
+
+// https://github.com/torvalds/linux/blob/5bfc75d92efd494db37f5c4c173d3639d4772966/fs/xfs/libxfs/xfs_da_format.h#L35-L43
+type Da3Blkinfo struct {
+	DaBlkinfo
+	CRC   uint32
+	Blkno uint64
+	Lsn   uint64
+	UUID  [16]byte
+	Owner uint64
+}
+
+// https://github.com/torvalds/linux/blob/5bfc75d92efd494db37f5c4c173d3639d4772966/fs/xfs/libxfs/xfs_da_format.h#L624-L643
+type AttrLeafHdrCommon struct {
+	Count     uint16
+	Usedbytes uint16
+	Firstused uint16
+	Holes     uint8
+	Pad1      uint8
+	Freemap   [3][2]uint16
+}
+
+// https://github.com/torvalds/linux/blob/5bfc75d92efd494db37f5c4c173d3639d4772966/fs/xfs/libxfs/xfs_da_format.h#L645-L650
+type AttrLeafEntry struct {
+	Hashval uint32
+	Nameidx uint16
+	Flags   uint8
+	Pad2    uint8
+}
+
+// https://github.com/torvalds/linux/blob/5bfc75d92efd494db37f5c4c173d3639d4772966/fs/xfs/libxfs/xfs_format.h#L1278-L1287
+type Attr3RmtHdr struct {
+	Magic  uint32
+	Offset uint32
+	Bytes  uint32
+	CRC    uint32
+	UUID   [16]byte
+	Owner  uint64
+	Blkno  uint64
+	Lsn    uint64
+}
+
+// ListXattrs returns the extended attributes of name, symlinks are not followed.
+// A file without attributes returns an empty slice.
+func (xfs *FileSystem) ListXattrs(name string) ([]Xattr, error) {
+	const op = "list xattrs"
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	info, err := xfs.readDirInfo(name)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	xattrs, err := xfs.inodeXattrs(info.(FileInfo).inode)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	return xattrs, nil
+}
+
+// GetXattr returns the value of the extended attribute attr of name, it returns
+// ErrNoAttribute when name does not have attr.
+func (xfs *FileSystem) GetXattr(name, attr string) ([]byte, error) {
+	xattrs, err := xfs.ListXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range xattrs {
+		if x.Name == attr {
+			return x.Value, nil
+		}
+	}
+	return nil, xfs.wrapError("get xattr", name, xerrors.Errorf("%s: %w", attr, ErrNoAttribute))
+}
+
+func (xfs *FileSystem) inodeXattrs(inode *Inode) ([]Xattr, error) {
+	if inode.inodeCore.Forkoff == 0 {
+		return []Xattr{}, nil
+	}
+	switch inode.inodeCore.Aformat {
+	case XFS_DINODE_FMT_LOCAL:
+		xattrs, err := parseAttrShortform(inode.attrFork)
+		if err != nil {
+			return nil, xfs.wrapInodeError(inode.ino, err)
+		}
+		return xattrs, nil
+	case XFS_DINODE_FMT_EXTENTS:
+		xattrs, err := xfs.readAttrBlocks(inode)
+		if err != nil {
+			return nil, xfs.wrapInodeError(inode.ino, err)
+		}
+		return xattrs, nil
+	default:
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("attribute fork in format %d", inode.inodeCore.Aformat))
+	}
+}
+
+func xattrName(flags uint8, name []byte) string {
+	switch {
+	case flags&XFS_ATTR_ROOT != 0:
+		return "trusted." + string(name)
+	case flags&XFS_ATTR_SECURE != 0:
+		return "security." + string(name)
+	default:
+		return "user." + string(name)
+	}
+}
+
+// parseAttrShortform parses the attribute fork of an inode in local format
+func parseAttrShortform(fork []byte) ([]Xattr, error) {
+	var hdr AttrShortformHdr
+	if err := binary.Read(bytes.NewReader(fork), binary.BigEndian, &hdr); err != nil {
+		return nil, newCorruptedError("shortform attributes", -1, "header: %s", err)
+	}
+	switch {
+	case int(hdr.Totsize) < binary.Size(hdr):
+		return nil, newCorruptedError("shortform attributes", -1, "size %d is smaller than the header", hdr.Totsize)
+	case int(hdr.Totsize) > len(fork):
+		return nil, newCorruptedError("shortform attributes", -1, "size %d exceeds attribute fork %d", hdr.Totsize, len(fork))
+	}
+
+	xattrs := []Xattr{}
+	b := fork[binary.Size(hdr):hdr.Totsize]
+	for i := 0; i < int(hdr.Count); i++ {
+		// namelen, valuelen, flags and name followed by value
+		if len(b) < 3 {
+			return nil, newCorruptedError("shortform attributes", -1, "entries[%d] exceeds size %d", i, hdr.Totsize)
+		}
+		nameLen, valueLen, flags := int(b[0]), int(b[1]), b[2]
+		if len(b) < 3+nameLen+valueLen {
+			return nil, newCorruptedError("shortform attributes", -1, "entries[%d] exceeds size %d", i, hdr.Totsize)
+		}
+		name, value := b[3:3+nameLen], b[3+nameLen:3+nameLen+valueLen]
+		b = b[3+nameLen+valueLen:]
+		if flags&XFS_ATTR_PARENT != 0 {
+			continue
+		}
+		xattrs = append(xattrs, Xattr{Name: xattrName(flags, name), Value: append([]byte{}, value...)})
+	}
+	return xattrs, nil
+}
+
+// attrFork maps the logical blocks of an attribute fork in extents format
+type attrFork struct {
+	xfs   *FileSystem
+	table dataTable
+}
+
+func (a attrFork) readBlock(lblk uint32) ([]byte, error) {
+	offset, ok := a.table[int64(lblk)]
+	if !ok {
+		return nil, newCorruptedError("attribute fork", -1, "block %d is not mapped", lblk)
+	}
+	return a.xfs.readMetadataBlock(offset)
+}
+
+// readAttrBlocks reads the attribute leaves of an attribute fork in extents
+// format, a node format fork is descended to its leftmost leaf and the leaves
+// are followed by their forward links.
+func (xfs *FileSystem) readAttrBlocks(inode *Inode) ([]Xattr, error) {
+	if len(inode.attrFork) < int(inode.inodeCore.Anextents)*16 {
+		return nil, newCorruptedError("inode", -1, "anextents %d exceeds attribute fork", inode.inodeCore.Anextents)
+	}
+	recs, err := xfs.parseBmbtRecs(bytes.NewReader(inode.attrFork), uint32(inode.inodeCore.Anextents))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse attribute bmbt recs: %w", err)
+	}
+	fork := attrFork{xfs: xfs, table: dataTable{}}
+	for _, rec := range recs {
+		p := rec.Unpack()
+		physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(p.StartBlock)
+		for i := int64(0); i < int64(p.BlockCount); i++ {
+			fork.table[int64(p.StartOff)+i] = physicalBlockOffset + i
+		}
+	}
+
+	lblk := uint32(0)
+	visited := btreeVisited{}
+	for depth := 0; ; depth++ {
+		if err := visited.visit(uint64(lblk)); err != nil {
+			return nil, err
+		}
+		b, err := fork.readBlock(lblk)
+		if err != nil {
+			return nil, err
+		}
+		var info DaBlkinfo
+		if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &info); err != nil {
+			return nil, xerrors.Errorf("failed to read attribute block info: %w", err)
+		}
+		if info.Magic == XFS_ATTR_LEAF_MAGIC || info.Magic == XFS_ATTR3_LEAF_MAGIC {
+			break
+		}
+		if info.Magic != XFS_DA_NODE_MAGIC && info.Magic != XFS_DA3_NODE_MAGIC {
+			return nil, newCorruptedError("attribute block", -1, "block %d: invalid magic 0x%x", lblk, info.Magic)
+		}
+		if depth >= XFS_DA_NODE_MAXDEPTH {
+			return nil, newCorruptedError("attribute node", -1, "depth exceeds %d", XFS_DA_NODE_MAXDEPTH)
+		}
+
+		// count and level follow the block info, v5 headers are padded to
+		// 8 bytes. The first entry holds a hash value and the block before it.
+		countOffset, entriesOffset := binary.Size(DaBlkinfo{}), binary.Size(DaBlkinfo{})+4
+		if info.Magic == XFS_DA3_NODE_MAGIC {
+			countOffset, entriesOffset = binary.Size(Da3Blkinfo{}), binary.Size(Da3Blkinfo{})+8
+		}
+		if len(b) < entriesOffset+8 || binary.BigEndian.Uint16(b[countOffset:]) == 0 {
+			return nil, newCorruptedError("attribute node", -1, "block %d has no entries", lblk)
+		}
+		lblk = binary.BigEndian.Uint32(b[entriesOffset+4:])
+	}
+
+	xattrs := []Xattr{}
+	for {
+		b, err := fork.readBlock(lblk)
+		if err != nil {
+			return nil, err
+		}
+		forw, leaf, err := fork.parseLeaf(lblk, b)
+		if err != nil {
+			return nil, err
+		}
+		xattrs = append(xattrs, leaf...)
+		if forw == 0 {
+			return xattrs, nil
+		}
+		if err := visited.visit(uint64(forw)); err != nil {
+			return nil, err
+		}
+		lblk = forw
+	}
+}
+
+// parseLeaf returns the forward link and the attributes of the leaf block b
+func (a attrFork) parseLeaf(lblk uint32, b []byte) (uint32, []Xattr, error) {
+	r := bytes.NewReader(b)
+	var info Da3Blkinfo
+	if err := binary.Read(r, binary.BigEndian, &info.DaBlkinfo); err != nil {
+		return 0, nil, xerrors.Errorf("failed to read attribute leaf info: %w", err)
+	}
+	v5 := info.Magic == XFS_ATTR3_LEAF_MAGIC
+	switch {
+	case v5:
+		r.Reset(b)
+		if err := binary.Read(r, binary.BigEndian, &info); err != nil {
+			return 0, nil, xerrors.Errorf("failed to read attribute leaf info: %w", err)
+		}
+	case info.Magic != XFS_ATTR_LEAF_MAGIC:
+		return 0, nil, newCorruptedError("attribute leaf", -1, "block %d: invalid magic 0x%x", lblk, info.Magic)
+	}
+	var hdr AttrLeafHdrCommon
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return 0, nil, xerrors.Errorf("failed to read attribute leaf header: %w", err)
+	}
+	if v5 {
+		// pad2 aligns the v5 header to 8 bytes
+		if _, err := r.Seek(4, 1); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	xattrs := []Xattr{}
+	for i := 0; i < int(hdr.Count); i++ {
+		var entry AttrLeafEntry
+		if err := binary.Read(r, binary.BigEndian, &entry); err != nil {
+			return 0, nil, newCorruptedError("attribute leaf", -1, "block %d: entries[%d]: %s", lblk, i, err)
+		}
+		if entry.Flags&(XFS_ATTR_INCOMPLETE|XFS_ATTR_PARENT) != 0 {
+			continue
+		}
+		if int(entry.Nameidx) >= len(b) {
+			return 0, nil, newCorruptedError("attribute leaf", -1, "block %d: entries[%d]: name index %d exceeds block", lblk, i, entry.Nameidx)
+		}
+		x, err := a.parseLeafName(b[entry.Nameidx:], entry.Flags, v5)
+		if err != nil {
+			return 0, nil, xerrors.Errorf("block %d: entries[%d]: %w", lblk, i, err)
+		}
+		xattrs = append(xattrs, x)
+	}
+	return info.Forw, xattrs, nil
+}
+
+func (a attrFork) parseLeafName(b []byte, flags uint8, v5 bool) (Xattr, error) {
+	if flags&XFS_ATTR_LOCAL != 0 {
+		// valuelen, namelen and name followed by value
+		if len(b) < 3 {
+			return Xattr{}, newCorruptedError("attribute leaf", -1, "name exceeds block")
+		}
+		valueLen, nameLen := int(binary.BigEndian.Uint16(b)), int(b[2])
+		if len(b) < 3+nameLen+valueLen {
+			return Xattr{}, newCorruptedError("attribute leaf", -1, "value length %d exceeds block", valueLen)
+		}
+		return Xattr{
+			Name:  xattrName(flags, b[3:3+nameLen]),
+			Value: append([]byte{}, b[3+nameLen:3+nameLen+valueLen]...),
+		}, nil
+	}
+
+	// valueblk, valuelen, namelen and name, the value is in remote blocks
+	if len(b) < 9 {
+		return Xattr{}, newCorruptedError("attribute leaf", -1, "remote name exceeds block")
+	}
+	valueBlk, valueLen, nameLen := binary.BigEndian.Uint32(b), int(binary.BigEndian.Uint32(b[4:])), int(b[8])
+	if len(b) < 9+nameLen {
+		return Xattr{}, newCorruptedError("attribute leaf", -1, "remote name exceeds block")
+	}
+	value, err := a.readRemoteValue(valueBlk, valueLen, v5)
+	if err != nil {
+		return Xattr{}, err
+	}
+	return Xattr{Name: xattrName(flags, b[9:9+nameLen]), Value: value}, nil
+}
+
+// readRemoteValue reads a value stored in attribute fork blocks from lblk,
+// v5 file systems prefix each block with an Attr3RmtHdr
+func (a attrFork) readRemoteValue(lblk uint32, length int, v5 bool) ([]byte, error) {
+	// XATTR_SIZE_MAX
+	if length > 1<<16 {
+		return nil, newCorruptedError("attribute leaf", -1, "remote value length %d", length)
+	}
+	value := make([]byte, 0, length)
+	for len(value) < length {
+		b, err := a.readBlock(lblk)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to read remote value: %w", err)
+		}
+		if v5 {
+			var hdr Attr3RmtHdr
+			if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &hdr); err != nil {
+				return nil, xerrors.Errorf("failed to read remote value header: %w", err)
+			}
+			if hdr.Magic != XFS_ATTR3_RMT_MAGIC {
+				return nil, newCorruptedError("remote attribute", -1, "block %d: invalid magic 0x%x", lblk, hdr.Magic)
+			}
+			b = b[binary.Size(hdr):]
+			if int(hdr.Bytes) < len(b) {
+				b = b[:hdr.Bytes]
+			}
+		}
+		if n := length - len(value); n < len(b) {
+			b = b[:n]
+		}
+		value = append(value, b...)
+		lblk++
+	}
+	return value, nil
+}
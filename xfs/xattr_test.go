@@ -0,0 +1,200 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestFileSystemXattrs(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selinux := []byte("unconfined_u:object_r:unlabeled_t:s0\x00")
+	tests := []struct {
+		name     string
+		expected []Xattr
+		err      error
+	}{
+		{name: ".", expected: []Xattr{}},
+		{name: "etc/os-release", expected: []Xattr{{Name: "security.selinux", Value: selinux}}},
+		{name: "fmt_node_directories", expected: []Xattr{{Name: "security.selinux", Value: selinux}}},
+		{name: "missing", err: fs.ErrNotExist},
+		{name: "/etc", err: fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := fileSystem.ListXattrs(tt.name)
+			if tt.err != nil {
+				if !xerrors.Is(err, tt.err) {
+					t.Fatalf("expected %v, actual %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %q, actual %q", tt.expected, actual)
+			}
+		})
+	}
+
+	value, err := fileSystem.GetXattr("etc/os-release", "security.selinux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, selinux) {
+		t.Errorf("expected %q, actual %q", selinux, value)
+	}
+	if _, err := fileSystem.GetXattr("etc/os-release", "user.missing"); !xerrors.Is(err, ErrNoAttribute) {
+		t.Errorf("expected ErrNoAttribute, actual %v", err)
+	}
+}
+
+type testAttr struct {
+	flags uint8
+	name  string
+	value string
+	// remote values are stored from valueBlk
+	valueBlk uint32
+}
+
+// buildAttr3Leaf returns a v5 attribute leaf block, names are packed from the
+// end of the block
+func buildAttr3Leaf(blockSize int, forw uint32, attrs []testAttr) []byte {
+	b := make([]byte, blockSize)
+	var hdr bytes.Buffer
+	binary.Write(&hdr, binary.BigEndian, Da3Blkinfo{DaBlkinfo: DaBlkinfo{Forw: forw, Magic: XFS_ATTR3_LEAF_MAGIC}})
+	binary.Write(&hdr, binary.BigEndian, AttrLeafHdrCommon{Count: uint16(len(attrs))})
+	hdr.Write(make([]byte, 4))
+
+	end := blockSize
+	for _, a := range attrs {
+		var name []byte
+		if a.flags&XFS_ATTR_LOCAL != 0 {
+			name = binary.BigEndian.AppendUint16(nil, uint16(len(a.value)))
+			name = append(name, uint8(len(a.name)))
+			name = append(append(name, a.name...), a.value...)
+		} else {
+			name = binary.BigEndian.AppendUint32(nil, a.valueBlk)
+			name = binary.BigEndian.AppendUint32(name, uint32(len(a.value)))
+			name = append(append(name, uint8(len(a.name))), a.name...)
+		}
+		end -= len(name)
+		copy(b[end:], name)
+		binary.Write(&hdr, binary.BigEndian, AttrLeafEntry{Nameidx: uint16(end), Flags: a.flags})
+	}
+	copy(b, hdr.Bytes())
+	return b
+}
+
+func TestReadAttrBlocks(t *testing.T) {
+	const blockSize = 4096
+	remote := bytes.Repeat([]byte("0123456789"), 600)
+
+	// the fork maps logical blocks 0-5 to blocks 2-7, block 0 is a node
+	// pointing at the leaf chain 1, 2 and the remote value fills 3 and 4
+	img := make([]byte, 16*blockSize)
+	node := new(bytes.Buffer)
+	binary.Write(node, binary.BigEndian, Da3Blkinfo{DaBlkinfo: DaBlkinfo{Magic: XFS_DA3_NODE_MAGIC}})
+	binary.Write(node, binary.BigEndian, []uint16{1, 1, 0, 0})
+	binary.Write(node, binary.BigEndian, []uint32{0xffffffff, 1})
+	copy(img[2*blockSize:], node.Bytes())
+	copy(img[3*blockSize:], buildAttr3Leaf(blockSize, 2, []testAttr{
+		{flags: XFS_ATTR_LOCAL, name: "a", value: "1"},
+		{flags: XFS_ATTR_LOCAL | XFS_ATTR_SECURE, name: "selinux", value: "context"},
+		{flags: XFS_ATTR_LOCAL | XFS_ATTR_INCOMPLETE, name: "incomplete", value: "x"},
+	}))
+	copy(img[4*blockSize:], buildAttr3Leaf(blockSize, 0, []testAttr{
+		{flags: XFS_ATTR_ROOT, name: "remote", value: string(remote), valueBlk: 3},
+	}))
+	for i, chunk := range [][]byte{remote[:blockSize-56], remote[blockSize-56:]} {
+		var hdr bytes.Buffer
+		binary.Write(&hdr, binary.BigEndian, Attr3RmtHdr{Magic: XFS_ATTR3_RMT_MAGIC, Bytes: uint32(len(chunk))})
+		copy(img[(5+i)*blockSize:], append(hdr.Bytes(), chunk...))
+	}
+	fileSystem := newBlockSizeFS(img, 12, 0)
+
+	newInode := func() *Inode {
+		rec := packBmbtRec(0, 2, 6)
+		fork := binary.BigEndian.AppendUint64(nil, rec.L0)
+		fork = binary.BigEndian.AppendUint64(fork, rec.L1)
+		inode := &Inode{attrFork: fork}
+		inode.inodeCore.Forkoff = 1
+		inode.inodeCore.Aformat = XFS_DINODE_FMT_EXTENTS
+		inode.inodeCore.Anextents = 1
+		return inode
+	}
+
+	actual, err := fileSystem.inodeXattrs(newInode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Xattr{
+		{Name: "user.a", Value: []byte("1")},
+		{Name: "security.selinux", Value: []byte("context")},
+		{Name: "trusted.remote", Value: remote},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	// the second leaf links back to the first
+	binary.BigEndian.PutUint32(img[4*blockSize:], 1)
+	if _, err := fileSystem.inodeXattrs(newInode()); !xerrors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+}
+
+func TestParseAttrShortform(t *testing.T) {
+	fork := []byte{
+		0, 19, 2, 0,
+		1, 2, 0, 'a', 'x', 'y',
+		3, 3, XFS_ATTR_ROOT, 'a', 'c', 'l', 1, 2, 3,
+		0xff,
+	}
+	actual, err := parseAttrShortform(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Xattr{
+		{Name: "user.a", Value: []byte("xy")},
+		{Name: "trusted.acl", Value: []byte{1, 2, 3}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, actual %q", expected, actual)
+	}
+
+	// a third entry beyond totsize
+	fork[2] = 3
+	if _, err := parseAttrShortform(fork); !xerrors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+	fork[0], fork[1] = 1, 0
+	if _, err := parseAttrShortform(fork); !xerrors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+	// totsize smaller than the header
+	fork[0], fork[1] = 0, 2
+	if _, err := parseAttrShortform(fork); !xerrors.Is(err, ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted, actual %v", err)
+	}
+}
@@ -0,0 +1,577 @@
+package xfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// attr3LeafHdrSize is the size of the v5 attribute leaf header,
+	// Da3Blkinfo, AttrLeafHdrCommon and pad2
+	attr3LeafHdrSize = 80
+	// xattrSizeMax is the largest value of an attribute, XATTR_SIZE_MAX
+	xattrSizeMax = 1 << 16
+
+	// the root of a bmap btree in an inode fork is a header followed by
+	// its keys and pointers, XFS_BMDR_SPACE_CALC
+	bmdrHdrSize   = 4
+	bmdrEntrySize = 16
+	// minimum numbers of keys of the roots of data and attribute fork
+	// btrees, MINDBTPTRS and MINABTPTRS
+	minDataBtreePtrs = 3
+	minAttrBtreePtrs = 2
+)
+
+// xattrNamespaces maps the name prefixes to the flags of the namespaces
+var xattrNamespaces = []struct {
+	prefix string
+	flags  uint8
+}{
+	{"user.", 0},
+	{"trusted.", XFS_ATTR_ROOT},
+	{"security.", XFS_ATTR_SECURE},
+}
+
+// splitXattrName returns the namespace flags and the on-disk name of the
+// attribute name carrying its namespace prefix, ok is false for an unknown
+// namespace or an invalid name
+func splitXattrName(name string) (_ uint8, _ []byte, ok bool) {
+	for _, ns := range xattrNamespaces {
+		if !strings.HasPrefix(name, ns.prefix) {
+			continue
+		}
+		n := name[len(ns.prefix):]
+		if n == "" || len(n) > XFS_MAXNAMELEN {
+			return 0, nil, false
+		}
+		return ns.flags, []byte(n), true
+	}
+	return 0, nil, false
+}
+
+// attrSlot is an attribute of a writableAttrs
+type attrSlot struct {
+	// flags are the xfs_attr_leaf_entry flags without XFS_ATTR_LOCAL
+	flags uint8
+	name  []byte
+	value []byte
+	// hash is the hash of a loaded leaf entry
+	hash uint32
+	// remote values are kept in the attribute fork blocks from valueBlk
+	remote   bool
+	valueBlk uint32
+	valueLen uint32
+}
+
+func (s attrSlot) hashval() uint32 {
+	if s.hash != 0 {
+		return s.hash
+	}
+	return daHashName(s.name)
+}
+
+// leafSize returns the size of the name entry of the attribute in a leaf,
+// xfs_attr_leaf_entsize_local and xfs_attr_leaf_entsize_remote
+func (s attrSlot) leafSize() int {
+	if s.remote {
+		return (9 + len(s.name) + 3) &^ 3
+	}
+	return (3 + len(s.name) + len(s.value) + 3) &^ 3
+}
+
+// writableAttrs are the attributes of an inode loaded to be changed, the
+// attribute fork is in shortform or a single leaf block
+type writableAttrs struct {
+	xfs   *FileSystem
+	ino   uint64
+	core  InodeCore
+	attrs []attrSlot
+
+	// leaf is the bmbt record of the leaf block of a fork in extents format
+	// and info its block info
+	leaf *BmbtIrec
+	info Da3Blkinfo
+}
+
+// loadAttrs loads the attributes of the inode ino to be changed
+func (xfs *FileSystem) loadAttrs(ino uint64) (*writableAttrs, error) {
+	sb := xfs.PrimaryAG.SuperBlock
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	a := &writableAttrs{xfs: xfs, ino: ino, core: inode.inodeCore}
+	if a.core.Forkoff == 0 {
+		return a, nil
+	}
+	switch a.core.Aformat {
+	case XFS_DINODE_FMT_LOCAL:
+		attrs, err := parseAttrShortformSlots(inode.attrFork)
+		if err != nil {
+			return nil, xfs.wrapInodeError(ino, err)
+		}
+		a.attrs = attrs
+		return a, nil
+	case XFS_DINODE_FMT_EXTENTS:
+	default:
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("changing attribute forks in format %d, inode %d", a.core.Aformat, ino))
+	}
+
+	recs, err := xfs.parseBmbtRecs(bytes.NewReader(inode.attrFork), uint32(a.core.Anextents))
+	if err != nil {
+		return nil, xfs.wrapInodeError(ino, xerrors.Errorf("failed to parse attribute bmbt recs: %w", err))
+	}
+	var leaf *BmbtIrec
+	for _, rec := range recs {
+		if p := rec.Unpack(); p.StartOff == 0 {
+			leaf = &p
+		}
+	}
+	if leaf == nil {
+		return nil, xfs.wrapInodeError(ino, newCorruptedError("attribute fork", -1, "block 0 is not mapped"))
+	}
+	b, err := xfs.readBlock(sb.BlockToPhysicalOffset(leaf.StartBlock), 1)
+	if err != nil {
+		return nil, xfs.wrapBlockError(leaf.StartBlock, err)
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &a.info); err != nil {
+		return nil, xerrors.Errorf("failed to read attribute leaf info: %w", err)
+	}
+	if a.info.Magic != XFS_ATTR3_LEAF_MAGIC || a.info.Forw != 0 {
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("changing attribute forks of more than one leaf, inode %d", ino))
+	}
+	if a.attrs, err = parseAttrLeafSlots(b); err != nil {
+		return nil, xfs.wrapInodeError(ino, xfs.wrapBlockError(leaf.StartBlock, err))
+	}
+	a.leaf = leaf
+	return a, nil
+}
+
+// parseAttrShortformSlots parses the attribute fork of an inode in local
+// format, keeping the flags of the entries
+func parseAttrShortformSlots(fork []byte) ([]attrSlot, error) {
+	var hdr AttrShortformHdr
+	if err := binary.Read(bytes.NewReader(fork), binary.BigEndian, &hdr); err != nil {
+		return nil, newCorruptedError("shortform attributes", -1, "header: %s", err)
+	}
+	if int(hdr.Totsize) > len(fork) || int(hdr.Totsize) < binary.Size(hdr) {
+		return nil, newCorruptedError("shortform attributes", -1, "size %d exceeds attribute fork %d", hdr.Totsize, len(fork))
+	}
+	var attrs []attrSlot
+	b := fork[binary.Size(hdr):hdr.Totsize]
+	for i := 0; i < int(hdr.Count); i++ {
+		if len(b) < 3 || len(b) < 3+int(b[0])+int(b[1]) {
+			return nil, newCorruptedError("shortform attributes", -1, "entries[%d] exceeds size %d", i, hdr.Totsize)
+		}
+		nameLen, valueLen := int(b[0]), int(b[1])
+		attrs = append(attrs, attrSlot{
+			flags: b[2],
+			name:  append([]byte{}, b[3:3+nameLen]...),
+			value: append([]byte{}, b[3+nameLen:3+nameLen+valueLen]...),
+		})
+		b = b[3+nameLen+valueLen:]
+	}
+	return attrs, nil
+}
+
+// parseAttrLeafSlots parses the entries of the v5 attribute leaf block b
+func parseAttrLeafSlots(b []byte) ([]attrSlot, error) {
+	count := int(binary.BigEndian.Uint16(b[binary.Size(Da3Blkinfo{}):]))
+	if attr3LeafHdrSize+count*binary.Size(AttrLeafEntry{}) > len(b) {
+		return nil, newCorruptedError("attribute leaf", -1, "count %d exceeds block", count)
+	}
+	attrs := make([]attrSlot, 0, count)
+	r := bytes.NewReader(b[attr3LeafHdrSize:])
+	for i := 0; i < count; i++ {
+		var entry AttrLeafEntry
+		if err := binary.Read(r, binary.BigEndian, &entry); err != nil {
+			return nil, newCorruptedError("attribute leaf", -1, "entries[%d]: %s", i, err)
+		}
+		if int(entry.Nameidx) >= len(b) {
+			return nil, newCorruptedError("attribute leaf", -1, "entries[%d]: name index %d exceeds block", i, entry.Nameidx)
+		}
+		n := b[entry.Nameidx:]
+		slot := attrSlot{flags: entry.Flags &^ XFS_ATTR_LOCAL, hash: entry.Hashval}
+		if entry.Flags&XFS_ATTR_LOCAL != 0 {
+			if len(n) < 3 || len(n) < 3+int(n[2])+int(binary.BigEndian.Uint16(n)) {
+				return nil, newCorruptedError("attribute leaf", -1, "entries[%d]: name exceeds block", i)
+			}
+			valueLen, nameLen := int(binary.BigEndian.Uint16(n)), int(n[2])
+			slot.name = append([]byte{}, n[3:3+nameLen]...)
+			slot.value = append([]byte{}, n[3+nameLen:3+nameLen+valueLen]...)
+		} else {
+			if len(n) < 9 || len(n) < 9+int(n[8]) {
+				return nil, newCorruptedError("attribute leaf", -1, "entries[%d]: remote name exceeds block", i)
+			}
+			slot.remote = true
+			slot.valueBlk, slot.valueLen = binary.BigEndian.Uint32(n), binary.BigEndian.Uint32(n[4:])
+			slot.name = append([]byte{}, n[9:9+int(n[8])]...)
+		}
+		attrs = append(attrs, slot)
+	}
+	return attrs, nil
+}
+
+// find returns the index of the attribute name of the namespace flags, or -1
+func (a *writableAttrs) find(flags uint8, name []byte) int {
+	const namespace = XFS_ATTR_ROOT | XFS_ATTR_SECURE | XFS_ATTR_PARENT
+	for i, s := range a.attrs {
+		if s.flags&namespace == flags&namespace && bytes.Equal(s.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// attrChange is a change of an attribute fork planned by writableAttrs.plan,
+// commit writes it
+type attrChange struct {
+	xfs  *FileSystem
+	ino  uint64
+	core InodeCore
+	// fork is the attribute fork, nil when the inode has none, and
+	// oldForkoff the fork offset before the change
+	fork       []byte
+	oldForkoff uint8
+	// data is the leaf block written at block
+	block uint64
+	data  []byte
+	alloc *blockAlloc
+	free  *blockFree
+}
+
+// plan encodes the attributes, changed at now, in shortform when they fit in
+// the inode and in a leaf block otherwise
+func (a *writableAttrs) plan(now time.Time) (*attrChange, error) {
+	sb := a.xfs.PrimaryAG.SuperBlock
+	c := &attrChange{xfs: a.xfs, ino: a.ino, core: a.core, oldForkoff: a.core.Forkoff}
+	c.core.Ctime = c.core.encodeTimestamp(now)
+	c.core.Changecount++
+
+	// the leaf is only released without extents of remote values
+	inInode := a.leaf == nil || a.core.Anextents == 1
+	if len(a.attrs) == 0 && inInode {
+		c.core.Forkoff, c.core.Aformat = 0, XFS_DINODE_FMT_EXTENTS
+	} else if fork, ok := a.encodeShortform(); ok && inInode {
+		if forkoff := a.xfs.attrForkoff(a.core, len(fork)); forkoff > 0 {
+			c.core.Forkoff, c.core.Aformat, c.fork = forkoff, XFS_DINODE_FMT_LOCAL, fork
+		} else {
+			inInode = false
+		}
+	} else {
+		inInode = false
+	}
+	if inInode {
+		if a.leaf != nil {
+			free, err := a.xfs.planFree([]BmbtIrec{*a.leaf})
+			if err != nil {
+				return nil, err
+			}
+			c.free = free
+			c.core.Nblocks -= a.leaf.BlockCount
+			c.core.Anextents = 0
+		}
+		return c, nil
+	}
+
+	leaf := a.leaf
+	if leaf == nil {
+		forkoff := a.core.Forkoff
+		if forkoff == 0 {
+			if forkoff = a.xfs.attrForkoff(a.core, bmdrEntrySize); forkoff == 0 {
+				return nil, newUnsupportedFeatureError(fmt.Sprintf("moving the data fork of inode %d to make room for an attribute fork", a.ino))
+			}
+		}
+		agNumber, _, _ := sb.InodeOffset(a.ino)
+		alloc, err := a.xfs.planBlocks(uint64(agNumber), 1, 1)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to allocate an attribute leaf for inode %d: %w", a.ino, err)
+		}
+		e := alloc.extents[0]
+		leaf = &BmbtIrec{StartBlock: uint64(e.AG)<<sb.Agblklog | uint64(e.Start), BlockCount: 1}
+		a.info = Da3Blkinfo{
+			DaBlkinfo: DaBlkinfo{Magic: XFS_ATTR3_LEAF_MAGIC},
+			Blkno:     uint64(sb.BlockToPhysicalOffset(leaf.StartBlock)) * uint64(sb.BlockSize) / BBSIZE,
+			UUID:      sb.UUID,
+			Owner:     a.ino,
+		}
+		if sb.FeaturesIncompat&XFS_SB_FEAT_INCOMPAT_META_UUID != 0 {
+			a.info.UUID = sb.MetaUUID
+		}
+		c.alloc = alloc
+		c.core.Forkoff, c.core.Aformat = forkoff, XFS_DINODE_FMT_EXTENTS
+		c.core.Nblocks++
+		c.core.Anextents = 1
+	}
+	data, ok := a.encodeLeaf()
+	if !ok {
+		return nil, newUnsupportedFeatureError(fmt.Sprintf("splitting the attribute leaf of inode %d", a.ino))
+	}
+	var fork bytes.Buffer
+	if a.leaf == nil {
+		if err := binary.Write(&fork, binary.BigEndian, leaf.Pack()); err != nil {
+			return nil, xerrors.Errorf("failed to encode extent: %w", err)
+		}
+	} else {
+		// the fork is kept with the extents of remote values
+		inode, err := a.xfs.ParseInode(a.ino)
+		if err != nil {
+			return nil, err
+		}
+		fork.Write(inode.attrFork[:16*int(a.core.Anextents)])
+	}
+	c.fork, c.block, c.data = fork.Bytes(), leaf.StartBlock, data
+	return c, nil
+}
+
+// attrForkoff returns the forkoff of an attribute fork of size bytes in the
+// inode of core, as xfs_attr_shortform_bytesfit, or 0 when it does not fit
+// in the inode
+func (xfs *FileSystem) attrForkoff(core InodeCore, size int) uint8 {
+	litino := xfs.DataForkSize(0)
+	offset := (litino - size) >> 3
+	var dsize int
+	switch core.Format {
+	case XFS_DINODE_FMT_DEV:
+		if offset >= 1 {
+			return 1
+		}
+		return 0
+	case XFS_DINODE_FMT_LOCAL:
+		dsize = int(core.Size)
+	case XFS_DINODE_FMT_EXTENTS:
+		dsize = int(core.Nextents) * bmdrEntrySize
+	default:
+		return 0
+	}
+	if core.Forkoff > 0 && size <= litino-int(core.Forkoff)<<3 {
+		return core.Forkoff
+	}
+	minForkoff := dsize
+	if n := bmdrHdrSize + minDataBtreePtrs*bmdrEntrySize; minForkoff < n {
+		minForkoff = n
+	}
+	minForkoff = (minForkoff + 7) >> 3
+	maxForkoff := (litino - bmdrHdrSize - minAttrBtreePtrs*bmdrEntrySize) >> 3
+	switch {
+	case offset >= maxForkoff:
+		return uint8(maxForkoff)
+	case offset >= minForkoff:
+		return uint8(offset)
+	}
+	return 0
+}
+
+// encodeShortform returns the attribute fork in shortform, ok is false when
+// a remote or large value does not fit in a shortform entry
+func (a *writableAttrs) encodeShortform() (_ []byte, ok bool) {
+	if len(a.attrs) > 0xff {
+		return nil, false
+	}
+	var b bytes.Buffer
+	b.Write(make([]byte, binary.Size(AttrShortformHdr{})))
+	for _, s := range a.attrs {
+		if s.remote || len(s.value) > 0xff {
+			return nil, false
+		}
+		b.WriteByte(uint8(len(s.name)))
+		b.WriteByte(uint8(len(s.value)))
+		b.WriteByte(s.flags)
+		b.Write(s.name)
+		b.Write(s.value)
+	}
+	if b.Len() > 0xffff {
+		return nil, false
+	}
+	fork := b.Bytes()
+	binary.BigEndian.PutUint16(fork, uint16(len(fork)))
+	fork[2] = uint8(len(a.attrs))
+	return fork, true
+}
+
+// encodeLeaf returns the attribute leaf block, ok is false when the entries
+// do not fit in it. The names are packed from the end of the block.
+func (a *writableAttrs) encodeLeaf() (_ []byte, ok bool) {
+	blockSize := int(a.xfs.PrimaryAG.SuperBlock.BlockSize)
+	b := make([]byte, blockSize)
+
+	attrs := append([]attrSlot(nil), a.attrs...)
+	sort.SliceStable(attrs, func(i, j int) bool { return attrs[i].hashval() < attrs[j].hashval() })
+	base := attr3LeafHdrSize + len(attrs)*binary.Size(AttrLeafEntry{})
+	firstUsed, usedBytes := blockSize, 0
+	var entries bytes.Buffer
+	for _, s := range attrs {
+		size := s.leafSize()
+		if firstUsed-size < base {
+			return nil, false
+		}
+		firstUsed -= size
+		usedBytes += size
+		n := b[firstUsed:]
+		flags := s.flags
+		if s.remote {
+			binary.BigEndian.PutUint32(n, s.valueBlk)
+			binary.BigEndian.PutUint32(n[4:], s.valueLen)
+			n[8] = uint8(len(s.name))
+			copy(n[9:], s.name)
+		} else {
+			flags |= XFS_ATTR_LOCAL
+			binary.BigEndian.PutUint16(n, uint16(len(s.value)))
+			n[2] = uint8(len(s.name))
+			copy(n[3:], s.name)
+			copy(n[3+len(s.name):], s.value)
+		}
+		binary.Write(&entries, binary.BigEndian, AttrLeafEntry{Hashval: s.hashval(), Nameidx: uint16(firstUsed), Flags: flags})
+	}
+
+	var hdr bytes.Buffer
+	binary.Write(&hdr, binary.BigEndian, a.info)
+	binary.Write(&hdr, binary.BigEndian, AttrLeafHdrCommon{
+		Count:     uint16(len(attrs)),
+		Usedbytes: uint16(usedBytes),
+		Firstused: uint16(firstUsed),
+		Freemap:   [3][2]uint16{{uint16(base), uint16(firstUsed - base)}},
+	})
+	copy(b, hdr.Bytes())
+	copy(b[attr3LeafHdrSize:], entries.Bytes())
+	setCRC(b, XFS_ATTR3_LEAF_CRC_OFF)
+	return b, true
+}
+
+func (c *attrChange) commit() error {
+	sb := c.xfs.PrimaryAG.SuperBlock
+	if c.alloc != nil {
+		if err := c.alloc.commit(); err != nil {
+			return err
+		}
+	}
+	if c.data != nil {
+		if err := c.xfs.writeBlock(sb.BlockToPhysicalOffset(c.block), c.data); err != nil {
+			return c.xfs.wrapBlockError(c.block, err)
+		}
+	}
+
+	buf, err := c.xfs.ReadRawInode(c.ino)
+	if err != nil {
+		return err
+	}
+	var core bytes.Buffer
+	if err := binary.Write(&core, binary.BigEndian, c.core); err != nil {
+		return xerrors.Errorf("failed to encode InodeCore: %w", err)
+	}
+	copy(buf, core.Bytes())
+	// the data fork keeps its content, which fits in front of the new fork
+	// offset, the literal area after it is replaced
+	literal := buf[sb.InodeCoreSize():]
+	dataEnd := c.xfs.DataForkSize(c.core.Forkoff)
+	if old := c.xfs.DataForkSize(c.oldForkoff); old < dataEnd {
+		dataEnd = old
+	}
+	for i := dataEnd; i < len(literal); i++ {
+		literal[i] = 0
+	}
+	if c.core.Forkoff > 0 {
+		copy(literal[int(c.core.Forkoff)<<3:], c.fork)
+	}
+	if err := c.xfs.writeRawInode(c.ino, buf); err != nil {
+		return err
+	}
+
+	if c.free != nil {
+		return c.free.commit()
+	}
+	return nil
+}
+
+// SetXattr sets the extended attribute attr of name to value, attr carries
+// the namespace prefix "user.", "trusted." or "security." as in ListXattrs,
+// and symlinks are not followed. The attributes are kept in the inode while
+// they fit and in a single leaf block otherwise, values stored in remote
+// blocks are unsupported.
+func (xfs *FileSystem) SetXattr(name, attr string, value []byte) error {
+	const op = "set xattr"
+
+	if err := xfs.writable(); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	flags, attrName, ok := splitXattrName(attr)
+	if !validPath(name) || !ok || len(value) > xattrSizeMax {
+		return xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	if err := xfs.changeXattr(name, func(a *writableAttrs) error {
+		s := attrSlot{flags: flags, name: attrName, value: append([]byte{}, value...)}
+		if s.leafSize() >= int(xfs.PrimaryAG.SuperBlock.BlockSize)*3/4 {
+			return newUnsupportedFeatureError(fmt.Sprintf("writing remote attribute values, %s of %d bytes", attr, len(value)))
+		}
+		i := a.find(flags, attrName)
+		switch {
+		case i < 0:
+			a.attrs = append(a.attrs, s)
+		case a.attrs[i].remote:
+			return newUnsupportedFeatureError(fmt.Sprintf("replacing the remote value of %s", attr))
+		default:
+			s.hash = a.attrs[i].hash
+			a.attrs[i] = s
+		}
+		return nil
+	}); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	return nil
+}
+
+// RemoveXattr removes the extended attribute attr of name, it returns
+// ErrNoAttribute when name does not have attr. The attribute fork is removed
+// with its last attribute.
+func (xfs *FileSystem) RemoveXattr(name, attr string) error {
+	const op = "remove xattr"
+
+	if err := xfs.writable(); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	flags, attrName, ok := splitXattrName(attr)
+	if !validPath(name) || !ok {
+		return xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	if err := xfs.changeXattr(name, func(a *writableAttrs) error {
+		i := a.find(flags, attrName)
+		switch {
+		case i < 0:
+			return xerrors.Errorf("%s: %w", attr, ErrNoAttribute)
+		case a.attrs[i].remote:
+			return newUnsupportedFeatureError(fmt.Sprintf("removing the remote value of %s", attr))
+		}
+		a.attrs = append(a.attrs[:i], a.attrs[i+1:]...)
+		return nil
+	}); err != nil {
+		return xfs.wrapError(op, name, err)
+	}
+	return nil
+}
+
+// changeXattr applies change to the attributes of name and writes them
+func (xfs *FileSystem) changeXattr(name string, change func(a *writableAttrs) error) error {
+	info, err := xfs.readDirInfo(name)
+	if err != nil {
+		return err
+	}
+	a, err := xfs.loadAttrs(info.(FileInfo).inode.ino)
+	if err != nil {
+		return err
+	}
+	if err := change(a); err != nil {
+		return err
+	}
+	c, err := a.plan(time.Now())
+	if err != nil {
+		return err
+	}
+	return c.commit()
+}
@@ -0,0 +1,195 @@
+package xfs
+
+import (
+	"bytes"
+	"io/fs"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/xerrors"
+)
+
+func TestSetXattr(t *testing.T) {
+	img, fileSystem := openWritable(t, "testdata/image.xfs")
+	before := fileSystem.PrimaryAG.SuperBlock
+	osRelease, err := fs.ReadFile(fileSystem, "etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selinux, err := fileSystem.GetXattr("etc/os-release", "security.selinux")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check verifies the attributes of name in the reopened image, and the
+	// format of its attribute fork
+	check := func(t *testing.T, name string, expected []Xattr, format uint8) {
+		t.Helper()
+		reopened := reopen(t, img)
+		actual, err := reopened.ListXattrs(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// leaf entries are in hash order
+		sort.Slice(actual, func(i, j int) bool { return actual[i].Name < actual[j].Name })
+		sort.Slice(expected, func(i, j int) bool { return expected[i].Name < expected[j].Name })
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %q, actual %q", name, expected, actual)
+		}
+		info, err := reopened.readDirInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := reopened.loadAttrs(info.(FileInfo).inode.ino)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case a.core.Forkoff == 0:
+			if len(expected) > 0 {
+				t.Errorf("%s: expected an attribute fork", name)
+			}
+		case a.core.Aformat != format:
+			t.Errorf("%s: expected attribute fork format %d, actual %d", name, format, a.core.Aformat)
+		case a.leaf != nil:
+			sb := reopened.PrimaryAG.SuperBlock
+			b, err := reopened.readBlock(sb.BlockToPhysicalOffset(a.leaf.StartBlock), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyCRC("attribute leaf", b, XFS_ATTR3_LEAF_CRC_OFF); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			if a.info.Owner != a.ino {
+				t.Errorf("%s: expected owner %d, actual %d", name, a.ino, a.info.Owner)
+			}
+		}
+		data, err := fs.ReadFile(reopened, "etc/os-release")
+		if err != nil || !bytes.Equal(data, osRelease) {
+			t.Errorf("expected the content of etc/os-release, actual %v", err)
+		}
+	}
+
+	t.Run("shortform", func(t *testing.T) {
+		relabeled := []byte("system_u:object_r:etc_t:s0\x00")
+		if err := fileSystem.SetXattr("etc/os-release", "security.selinux", relabeled); err != nil {
+			t.Fatal(err)
+		}
+		if err := fileSystem.SetXattr("etc/os-release", "user.a", []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "etc/os-release", []Xattr{
+			{Name: "security.selinux", Value: relabeled},
+			{Name: "user.a", Value: []byte("1")},
+		}, XFS_DINODE_FMT_LOCAL)
+		if err := fileSystem.SetXattr("etc/os-release", "security.selinux", selinux); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// the attributes outgrow the inode, and return to it when removed
+	large := bytes.Repeat([]byte("v"), 200)
+	t.Run("leaf", func(t *testing.T) {
+		for _, name := range []string{"user.b", "trusted.c", "user.d"} {
+			if err := fileSystem.SetXattr("etc/os-release", name, large); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(t, "etc/os-release", []Xattr{
+			{Name: "security.selinux", Value: selinux},
+			{Name: "user.a", Value: []byte("1")},
+			{Name: "user.b", Value: large},
+			{Name: "trusted.c", Value: large},
+			{Name: "user.d", Value: large},
+		}, XFS_DINODE_FMT_EXTENTS)
+
+		if err := fileSystem.SetXattr("etc/os-release", "user.a", []byte("2")); err != nil {
+			t.Fatal(err)
+		}
+		if err := fileSystem.RemoveXattr("etc/os-release", "user.b"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "etc/os-release", []Xattr{
+			{Name: "security.selinux", Value: selinux},
+			{Name: "user.a", Value: []byte("2")},
+			{Name: "trusted.c", Value: large},
+			{Name: "user.d", Value: large},
+		}, XFS_DINODE_FMT_EXTENTS)
+		if sb := reopen(t, img).PrimaryAG.SuperBlock; sb.Fdblocks != before.Fdblocks-1 {
+			t.Errorf("expected %d free blocks, actual %d", before.Fdblocks-1, sb.Fdblocks)
+		}
+
+		if err := fileSystem.RemoveXattr("etc/os-release", "trusted.c"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "etc/os-release", []Xattr{
+			{Name: "security.selinux", Value: selinux},
+			{Name: "user.a", Value: []byte("2")},
+			{Name: "user.d", Value: large},
+		}, XFS_DINODE_FMT_LOCAL)
+		if sb := reopen(t, img).PrimaryAG.SuperBlock; sb.Fdblocks != before.Fdblocks {
+			t.Errorf("expected %d free blocks, actual %d", before.Fdblocks, sb.Fdblocks)
+		}
+	})
+
+	// the root directory has no attribute fork, and a new file a leaf from
+	// its first attribute
+	t.Run("new fork", func(t *testing.T) {
+		if err := fileSystem.SetXattr(".", "user.root", []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		check(t, ".", []Xattr{{Name: "user.root", Value: []byte("x")}}, XFS_DINODE_FMT_LOCAL)
+		if err := fileSystem.RemoveXattr(".", "user.root"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, ".", []Xattr{}, 0)
+		if err := fileSystem.Mkdir("new", 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fileSystem.WriteFile("new/file", []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := fileSystem.SetXattr("new/file", "user.large", bytes.Repeat(large, 4)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "new/file", []Xattr{{Name: "user.large", Value: bytes.Repeat(large, 4)}}, XFS_DINODE_FMT_EXTENTS)
+		// the blocks of the attribute fork are freed with the file
+		if err := fileSystem.Remove("new/file"); err != nil {
+			t.Fatal(err)
+		}
+		reopen(t, img)
+	})
+}
+
+func TestSetXattrErrors(t *testing.T) {
+	img, fileSystem := openWritable(t, "testdata/image.xfs")
+	tests := []struct {
+		name, attr string
+		value      []byte
+		err        error
+	}{
+		{name: "etc/os-release", attr: "unknown.a", err: fs.ErrInvalid},
+		{name: "etc/os-release", attr: "user.", err: fs.ErrInvalid},
+		{name: "etc/os-release", attr: "user.a", value: make([]byte, 1<<16+1), err: fs.ErrInvalid},
+		{name: "missing", attr: "user.a", err: fs.ErrNotExist},
+		{name: "etc/os-release", attr: "user.remote", value: make([]byte, 4000), err: ErrUnsupportedFeature},
+	}
+	for _, tt := range tests {
+		if err := fileSystem.SetXattr(tt.name, tt.attr, tt.value); !xerrors.Is(err, tt.err) {
+			t.Errorf("%s %s: expected %v, actual %v", tt.name, tt.attr, tt.err, err)
+		}
+	}
+	if err := fileSystem.RemoveXattr("etc/os-release", "user.missing"); !xerrors.Is(err, ErrNoAttribute) {
+		t.Errorf("expected %v, actual %v", ErrNoAttribute, err)
+	}
+
+	readOnly, err := OpenReaderAt(bytes.NewReader(img), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readOnly.SetXattr("etc/os-release", "user.a", nil); !xerrors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v, actual %v", ErrReadOnly, err)
+	}
+}
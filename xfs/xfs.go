@@ -2,27 +2,27 @@ package xfs
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
 	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"golang.org/x/xerrors"
-
-	"github.com/masahiro331/go-xfs-filesystem/log"
-	"github.com/masahiro331/go-xfs-filesystem/xfs/utils"
 )
 
 var (
-	_ fs.FS        = &FileSystem{}
-	_ fs.ReadDirFS = &FileSystem{}
-	_ fs.StatFS    = &FileSystem{}
+	_ fs.FS         = &FileSystem{}
+	_ fs.ReadDirFS  = &FileSystem{}
+	_ fs.StatFS     = &FileSystem{}
+	_ fs.ReadFileFS = &FileSystem{}
 
-	_ fs.File     = &File{}
-	_ fs.FileInfo = &FileInfo{}
-	_ fs.DirEntry = dirEntry{}
+	_ fs.File        = &File{}
+	_ io.ReadSeeker  = &File{}
+	_ fs.FileInfo    = &FileInfo{}
+	_ fs.DirEntry    = dirEntry{}
+	_ fs.ReadDirFile = &Dir{}
 
 	ErrOpenSymlink = xerrors.New("symlink open not support")
 )
@@ -32,13 +32,39 @@ var (
 	ErrSeekOffsetFormat = "failed to seek offset error: actual(%d), expected(%d)"
 )
 
-// FileSystem is implemented io/fs FS interface
+// FileSystem is implemented io/fs FS interface.
+// After NewFS returns, it only reads the image with ReadAt, so a FileSystem is
+// safe for concurrent use when the underlying io.ReaderAt is, as *os.File and
+// *bytes.Reader are. A File or Dir must not be used from multiple goroutines.
+// In the read-write mode of WithReadWrite, write operations must not run
+// concurrently with any other operation.
 type FileSystem struct {
 	r         *io.SectionReader
 	PrimaryAG AG
 	AGs       []AG
 
+	// w is nil unless set with WithReadWrite
+	w io.WriterAt
+
 	cache Cache[string, any]
+
+	inodeCache *intCache[Inode]
+	dirCache   *intCache[[]Entry]
+	// persistent is nil unless set with WithPersistentCache
+	persistent *persistentCache
+
+	// pinned inodes and directory entries, they are populated by NewFS and
+	// read only after that.
+	pinnedInodes  map[uint64]*Inode
+	pinnedEntries map[uint64][]Entry
+
+	logState      LogState
+	symlinkPolicy SymlinkPolicy
+
+	// tracer and metrics are nil unless set with WithTracer and WithMetrics
+	tracer  Tracer
+	metrics Metrics
+	logger  Logger
 }
 
 func Check(r io.Reader) bool {
@@ -49,10 +75,61 @@ func Check(r io.Reader) bool {
 	return true
 }
 
-func NewFS(r io.SectionReader, cache Cache[string, any]) (*FileSystem, error) {
-	primaryAG, err := ParseAG(&r)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to parse primary allocation group: %w", err)
+func NewFS(r io.SectionReader, cache Cache[string, any], opts ...Option) (_ *FileSystem, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tracer != nil {
+		span := o.tracer.Start("xfs.mount", TraceAttribute{"size", r.Size()})
+		defer func() { span.End(err) }()
+	}
+	if o.metrics != nil {
+		defer func(start time.Time) { observe(o.metrics, "mount", start, err) }(time.Now())
+		base := r
+		r = *io.NewSectionReader(meteredReaderAt{r: &base, metrics: o.metrics}, 0, base.Size())
+	}
+	if o.writer != nil {
+		// writes go to the offsets of the image, and cached metadata would
+		// go stale
+		switch {
+		case len(o.transforms) > 0:
+			return nil, xerrors.Errorf("read-write mode with a transform: %w", fs.ErrInvalid)
+		case o.persistentDir != "":
+			return nil, xerrors.Errorf("read-write mode with a persistent cache: %w", fs.ErrInvalid)
+		case len(o.pinnedPaths) > 0:
+			return nil, xerrors.Errorf("read-write mode with pinned paths: %w", fs.ErrInvalid)
+		}
+		// the cache of the caller cannot drop the inodes that change
+		cache = nil
+	}
+	for _, t := range o.transforms {
+		base := r
+		decoded, err := t(&base)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to transform the image: %w", err)
+		}
+		r = *io.NewSectionReader(decoded, 0, decoded.Size())
+	}
+
+	var persistent *persistentCache
+	if o.persistentDir != "" {
+		persistent, err = openPersistentCache(&r, o.persistentDir)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to open the persistent cache: %w", err)
+		}
+	}
+	cachedAGs := persistent.ags()
+
+	var primaryAG *AG
+	if len(cachedAGs) > 0 {
+		primaryAG = &cachedAGs[0]
+	} else {
+		primaryAG, err = ParseAG(&r)
+		if err != nil {
+			setCorruptedOffset(err, 0)
+			return nil, xerrors.Errorf("failed to parse primary allocation group: %w", err)
+		}
 	}
 
 	if cache == nil {
@@ -60,39 +137,202 @@ func NewFS(r io.SectionReader, cache Cache[string, any]) (*FileSystem, error) {
 	}
 	fileSystem := FileSystem{
 		r:         &r,
+		w:         o.writer,
 		PrimaryAG: *primaryAG,
 		AGs:       []AG{*primaryAG},
 		cache:     cache,
+
+		persistent:    persistent,
+		symlinkPolicy: o.symlinkPolicy,
+		tracer:        o.tracer,
+		metrics:       o.metrics,
+		logger:        o.logger,
+	}
+
+	allocated := primaryAG.SuperBlock.Icount - primaryAG.SuperBlock.Ifree
+	if primaryAG.SuperBlock.Ifree > primaryAG.SuperBlock.Icount {
+		allocated = 0
+	}
+	fileSystem.inodeCache = newIntCache[Inode](o.inodeCacheSize, allocated)
+	fileSystem.dirCache = newIntCache[[]Entry](o.dirCacheSize, allocated)
+
+	if len(cachedAGs) > 0 {
+		fileSystem.AGs = cachedAGs
+	} else {
+		AGSize := int64(primaryAG.SuperBlock.Agblocks) * int64(primaryAG.SuperBlock.BlockSize)
+		for i := int64(1); i < int64(primaryAG.SuperBlock.Agcount); i++ {
+			// superblock, AGF, AGI and AGFL each take one sector
+			if AGSize*i+4*int64(primaryAG.SuperBlock.Sectsize) > r.Size() {
+				return nil, newCorruptedError("allocation group", AGSize*i, "AG %d headers exceed image size %d", i, r.Size())
+			}
+			n, err := r.Seek(AGSize*i, 0)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to seek file: %w", err)
+			}
+			if n != AGSize*i {
+				return nil, xerrors.Errorf(ErrSeekOffsetFormat, n, AGSize*i)
+			}
+			ag, err := ParseAG(&r)
+			if err != nil {
+				// the image is xfs, a broken secondary superblock is corruption
+				if xerrors.Is(err, ErrNotXFS) {
+					err = newCorruptedError("superblock", AGSize*i, "magic byte error")
+				}
+				setCorruptedOffset(err, AGSize*i)
+				return nil, xerrors.Errorf("failed to parse allocation group %d (offset 0x%x): %w", i, AGSize*i, err)
+			}
+			fileSystem.AGs = append(fileSystem.AGs, *ag)
+		}
+		persistent.setAGs(fileSystem.AGs)
+	}
+	fileSystem.logState, err = fileSystem.readLogState()
+	if err != nil {
+		fileSystem.Logger().Warn("failed to read log state", "error", err)
+	}
+	if fileSystem.logState == LogDirty && !o.allowDirty {
+		return nil, xerrors.Errorf("failed to mount: %w", ErrDirtyLog)
+	}
+	if fileSystem.logState == LogDirty {
+		// the superblock of a dirty file system may not reflect its changes
+		fileSystem.persistent = nil
+	}
+	if fileSystem.w != nil {
+		if err := fileSystem.writeSupported(); err != nil {
+			return nil, xerrors.Errorf("failed to mount read-write: %w", err)
+		}
+	}
+
+	// the kernel only reads the primary superblock, divergent secondaries are
+	// reported but do not prevent reading the file system
+	if err := fileSystem.VerifySuperBlocks(); err != nil {
+		fileSystem.Logger().Warn("secondary superblocks differ from the primary", "error", err)
 	}
 
-	AGSize := int64(primaryAG.SuperBlock.Agblocks) * int64(primaryAG.SuperBlock.BlockSize)
-	for i := int64(1); i < int64(primaryAG.SuperBlock.Agcount); i++ {
-		n, err := r.Seek(AGSize*i, 0)
+	if len(o.pinnedPaths) > 0 {
+		pinnedInodes := make(map[uint64]*Inode, len(o.pinnedPaths))
+		pinnedEntries := make(map[uint64][]Entry, len(o.pinnedPaths))
+		for _, p := range o.pinnedPaths {
+			if err := fileSystem.pin(p, pinnedInodes, pinnedEntries); err != nil {
+				fileSystem.Logger().Debug("failed to pin", "path", p, "error", err)
+			}
+		}
+		fileSystem.pinnedInodes = pinnedInodes
+		fileSystem.pinnedEntries = pinnedEntries
+	}
+	return &fileSystem, nil
+}
+
+// pin resolves the directory name, and stores the inodes and entries of every
+// directory on the way and the inodes of the directory children.
+func (xfs *FileSystem) pin(name string, inodes map[uint64]*Inode, entries map[uint64][]Entry) error {
+	pinEntries := func(ino uint64) ([]Entry, error) {
+		if e, ok := entries[ino]; ok {
+			return e, nil
+		}
+		inode, err := xfs.ParseInode(ino)
 		if err != nil {
-			return nil, xerrors.Errorf("failed to seek file: %w", err)
+			return nil, xerrors.Errorf("failed to parse inode %d: %w", ino, err)
 		}
-		if n != AGSize*i {
-			return nil, xerrors.Errorf(ErrSeekOffsetFormat, n, AGSize*i)
+		inodes[ino] = inode
+		e, err := xfs.listEntries(ino)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to list entries inode: %d: %w", ino, err)
+		}
+		entries[ino] = e
+		return e, nil
+	}
+
+	ino := xfs.PrimaryAG.SuperBlock.Rootino
+	if name != "." {
+		for _, n := range strings.Split(name, "/") {
+			dirEntries, err := pinEntries(ino)
+			if err != nil {
+				return err
+			}
+			var found Entry
+			for _, entry := range dirEntries {
+				if entry.Name() == n {
+					found = entry
+					break
+				}
+			}
+			if found == nil {
+				return fs.ErrNotExist
+			}
+			ino = found.InodeNumber()
+		}
+	}
+
+	dirEntries, err := pinEntries(ino)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if entry.Name() == "." || entry.Name() == ".." {
+			continue
 		}
-		ag, err := ParseAG(&r)
+		inode, err := xfs.ParseInode(entry.InodeNumber())
 		if err != nil {
-			return nil, xerrors.Errorf("failed to parse allocation group %d: %w", i, err)
+			return xerrors.Errorf("failed to parse inode %d: %w", entry.InodeNumber(), err)
 		}
-		fileSystem.AGs = append(fileSystem.AGs, *ag)
+		inodes[entry.InodeNumber()] = inode
 	}
-	return &fileSystem, nil
+	return nil
 }
 
+// VerifySuperBlocks compares the geometry of the secondary superblocks with
+// the primary superblock, divergences usually indicate an interrupted growfs
+// or corruption and are returned as a *CorruptedError.
+func (xfs *FileSystem) VerifySuperBlocks() error {
+	primary := xfs.PrimaryAG.SuperBlock
+	AGSize := int64(primary.Agblocks) * int64(primary.BlockSize)
+
+	var divergences []string
+	offset := int64(-1)
+	for i, ag := range xfs.AGs {
+		if i == 0 {
+			continue
+		}
+		diffs := primary.geometryDiff(ag.SuperBlock)
+		if len(diffs) == 0 {
+			continue
+		}
+		if offset < 0 {
+			offset = AGSize * int64(i)
+		}
+		divergences = append(divergences, fmt.Sprintf("AG %d: %s", i, strings.Join(diffs, ", ")))
+	}
+	if len(divergences) > 0 {
+		return newCorruptedError("superblock", offset, "secondary superblocks diverge from primary: %s", strings.Join(divergences, "; "))
+	}
+	return nil
+}
+
+// Close writes the metadata read to the cache of WithPersistentCache
 func (xfs *FileSystem) Close() error {
+	if err := xfs.persistent.save(); err != nil {
+		return xerrors.Errorf("failed to save the persistent cache: %w", err)
+	}
 	return nil
 }
 
-func (xfs *FileSystem) Stat(name string) (fs.FileInfo, error) {
+func (xfs *FileSystem) Stat(name string) (_ fs.FileInfo, err error) {
 	const op = "stat"
 
-	f, err := xfs.Open(name)
+	if xfs.metrics != nil {
+		defer func(start time.Time) { observe(xfs.metrics, op, start, err) }(time.Now())
+	}
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+
+	if xfs.symlinkPolicy != SymlinkNoFollow {
+		return xfs.statFollow(name)
+	}
+
+	f, err := xfs.open(name)
 	if err != nil {
-		info, err := xfs.ReadDirInfo(name)
+		info, err := xfs.readDirInfo(name)
 		if err != nil {
 			return nil, xfs.wrapError(op, name, xerrors.Errorf("failed to read dir info: %w", err))
 		}
@@ -101,6 +341,59 @@ func (xfs *FileSystem) Stat(name string) (fs.FileInfo, error) {
 	return f.Stat()
 }
 
+// Exists reports whether name exists and returns its file type.
+// The path is resolved from directory entries only, so neither the target inode
+// nor any file content is read when the file system records file types in
+// directory entries.
+func (xfs *FileSystem) Exists(name string) (bool, fs.FileMode, error) {
+	const op = "exists"
+
+	if !validPath(name) {
+		return false, 0, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	if name == "." {
+		return true, fs.ModeDir, nil
+	}
+
+	ino := xfs.PrimaryAG.SuperBlock.Rootino
+	names := strings.Split(name, "/")
+	for i, n := range names {
+		entry, err := xfs.lookupEntry(ino, n)
+		if err != nil {
+			if xerrors.Is(err, fs.ErrNotExist) {
+				return false, 0, nil
+			}
+			return false, 0, xfs.wrapError(op, name, err)
+		}
+
+		typ, err := xfs.entryType(entry)
+		if err != nil {
+			return false, 0, xfs.wrapError(op, name, err)
+		}
+		if i == len(names)-1 {
+			return true, typ, nil
+		}
+		if !typ.IsDir() {
+			return false, 0, nil
+		}
+		ino = entry.InodeNumber()
+	}
+	return false, 0, nil
+}
+
+// entryType returns the file type of a directory entry.
+// the inode is parsed only when the entry does not record its file type.
+func (xfs *FileSystem) entryType(entry Entry) (fs.FileMode, error) {
+	if typ, ok := fileTypeToMode(entry.FileType()); ok {
+		return typ, nil
+	}
+	inode, err := xfs.ParseInode(entry.InodeNumber())
+	if err != nil {
+		return 0, xerrors.Errorf("failed to parse inode %d: %w", entry.InodeNumber(), err)
+	}
+	return inode.inodeCore.FileModeType(), nil
+}
+
 func (xfs *FileSystem) newFile(dirEntry dirEntry) (*File, error) {
 	var recs []BmbtRec
 	if dirEntry.inode.regularExtent != nil {
@@ -108,14 +401,37 @@ func (xfs *FileSystem) newFile(dirEntry dirEntry) (*File, error) {
 	} else if dirEntry.inode.regularBtree != nil {
 		recs = dirEntry.inode.regularBtree.bmbtRecs
 	} else {
-		return nil, xerrors.Errorf("unsupported inode: %+v", dirEntry.inode)
+		return nil, xerrors.Errorf("inode %d: %w", dirEntry.inode.ino, newUnsupportedFeatureError("reading data fork of non extent inode"))
+	}
+
+	// blocks beyond the inode size are preallocated and never read
+	blockSize := uint64(xfs.PrimaryAG.SuperBlock.BlockSize)
+	sizeBlocks := (dirEntry.inode.inodeCore.Size + blockSize - 1) / blockSize
+
+	var blocks uint64
+	for _, rec := range recs {
+		blocks += rec.Unpack().BlockCount
+	}
+	if blocks > sizeBlocks {
+		blocks = sizeBlocks
 	}
 
-	dt := make(dataTable)
+	dt := make(dataTable, blocks)
 	for _, rec := range recs {
 		p := rec.Unpack()
+		// unwritten extents are preallocated and have no data yet
+		if p.State == XFS_EXT_UNWRITTEN {
+			continue
+		}
+		if p.StartOff >= sizeBlocks {
+			continue
+		}
+		count := p.BlockCount
+		if p.StartOff+count > sizeBlocks {
+			count = sizeBlocks - p.StartOff
+		}
 		physicalBlockOffset := xfs.PrimaryAG.SuperBlock.BlockToPhysicalOffset(p.StartBlock)
-		for i := int64(0); i < int64(p.BlockCount); i++ {
+		for i := int64(0); i < int64(count); i++ {
 			dt[int64(p.StartOff)+i] = physicalBlockOffset + i
 		}
 	}
@@ -130,42 +446,143 @@ func (xfs *FileSystem) newFile(dirEntry dirEntry) (*File, error) {
 	}, nil
 }
 
-func (xfs *FileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+// ReadDir returns the entries of the directory name without "." and "..",
+// an empty directory returns an empty slice and a nil error.
+func (xfs *FileSystem) ReadDir(name string) (_ []fs.DirEntry, err error) {
 	const op = "read directory"
 
+	if xfs.metrics != nil {
+		defer func(start time.Time) { observe(xfs.metrics, op, start, err) }(time.Now())
+	}
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+
 	dirEntries, err := xfs.readDirEntry(name)
 	if err != nil {
-		return nil, xfs.wrapError(op, name, err)
+		// a partial listing is returned along with the error, as os.ReadDir does
+		return dirEntries, xfs.wrapError(op, name, err)
 	}
 	return dirEntries, nil
 }
 
+// RawReadDir returns the directory entries of name as they are stored on
+// disk, in on-disk order and including "." and "..". Shortform directories do
+// not store the dot entries, they are returned first and built from the inode
+// number of the directory and the parent inode number of the shortform header.
+// The entries are Dir2SfEntry or Dir2DataEntry values behind the Entry interface.
+func (xfs *FileSystem) RawReadDir(name string) ([]Entry, error) {
+	const op = "read raw directory"
+
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	ino, err := xfs.resolveDir(name)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	raw, err := xfs.rawReadDir(ino)
+	if err != nil {
+		return raw, xfs.wrapError(op, name, err)
+	}
+	return raw, nil
+}
+
+// RawReadDirInode is RawReadDir for the directory inode ino
+func (xfs *FileSystem) RawReadDirInode(ino uint64) ([]Entry, error) {
+	raw, err := xfs.rawReadDir(ino)
+	if err != nil {
+		return raw, xerrors.Errorf("read raw directory inode %d: %w", ino, err)
+	}
+	return raw, nil
+}
+
+func (xfs *FileSystem) rawReadDir(ino uint64) ([]Entry, error) {
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	if !inode.inodeCore.IsDir() {
+		return nil, xerrors.Errorf("inode %d is not a directory: %w", ino, fs.ErrInvalid)
+	}
+
+	entries, partialErr := xfs.listEntries(ino)
+	if partialErr != nil && !xerrors.Is(partialErr, ErrPartialDirectory) {
+		return nil, partialErr
+	}
+
+	var raw []Entry
+	if inode.directoryLocal != nil {
+		raw = append(raw,
+			Dir2SfEntry{Namelen: 1, EntryName: ".", Filetype: XFS_DIR3_FT_DIR, Inumber: ino},
+			Dir2SfEntry{Namelen: 2, EntryName: "..", Filetype: XFS_DIR3_FT_DIR, Inumber: inode.directoryLocal.dir2SfHdr.Parent},
+		)
+	}
+	raw = append(raw, entries...)
+	return raw, partialErr
+}
+
+// ReadDirInfo returns the FileInfo of name from the entries of its parent directory
 func (xfs *FileSystem) ReadDirInfo(name string) (fs.FileInfo, error) {
-	if name == "/" {
+	const op = "read directory info"
+
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	info, err := xfs.readDirInfo(name)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	return info, nil
+}
+
+func (xfs *FileSystem) readDirInfo(name string) (fs.FileInfo, error) {
+	if name == "." {
 		inode, err := xfs.getRootInode()
 		if err != nil {
 			return nil, xerrors.Errorf("failed to parse root inode: %w", err)
 		}
-		return FileInfo{
-			name:  "/",
-			inode: inode,
-			mode:  fs.FileMode(inode.inodeCore.Mode),
-		}, nil
+		return newFileInfo(".", inode), nil
 	}
-	name = strings.TrimRight(name, string(filepath.Separator))
 
-	dirs, dir := path.Split(name)
-	dirEntries, err := xfs.readDirEntry(dirs)
+	// names without a directory, such as "etc", resolve to the root directory
+	dirName, fileName := path.Split(name)
+	ino, err := xfs.resolveDir(dirName)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to read dir entry: %w", err)
+		return nil, xerrors.Errorf("failed to resolve directory: %w", err)
 	}
-	for _, entry := range dirEntries {
-		if entry.Name() == strings.Trim(dir, string(filepath.Separator)) {
-			return entry.Info()
-		}
+	entry, err := xfs.lookupEntry(ino, fileName)
+	if err != nil {
+		return nil, err
+	}
+	inode, err := xfs.ParseInode(entry.InodeNumber())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse inode %d: %w", entry.InodeNumber(), err)
 	}
+	return newFileInfo(fileName, inode), nil
+}
 
-	return nil, fs.ErrNotExist
+// InodeInfo returns the FileInfo of the inode ino, its name is empty as inodes
+// do not record the names linking to them.
+func (xfs *FileSystem) InodeInfo(ino uint64) (FileInfo, error) {
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return newFileInfo("", inode), nil
+}
+
+// OpenInode opens the regular file of the inode ino without a path, which
+// also reads files unlinked from every directory. Its name is empty.
+func (xfs *FileSystem) OpenInode(ino uint64) (*File, error) {
+	inode, err := xfs.ParseInode(ino)
+	if err != nil {
+		return nil, err
+	}
+	if !inode.inodeCore.IsRegular() {
+		return nil, xerrors.Errorf("inode %d is not a regular file: %w", ino, fs.ErrInvalid)
+	}
+	return xfs.newFile(dirEntry{newFileInfo("", inode)})
 }
 
 func (xfs *FileSystem) getRootInode() (*Inode, error) {
@@ -176,16 +593,47 @@ func (xfs *FileSystem) getRootInode() (*Inode, error) {
 	return inode, nil
 }
 
-// TODO: support ReadFile Interface
-func (xfs *FileSystem) ReadFile(name string) ([]byte, error) {
-	panic("implement me")
-	return []byte{}, nil
+// ReadFile reads the named file and returns its contents
+func (xfs *FileSystem) ReadFile(name string) (_ []byte, err error) {
+	const op = "read file"
+
+	if xfs.metrics != nil {
+		defer func(start time.Time) { observe(xfs.metrics, op, start, err) }(time.Now())
+	}
+	if !validPath(name) {
+		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
+	}
+	f, err := xfs.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, err)
+	}
+	return b, nil
 }
 
 // TODO: support GlobFS Interface
 func (xfs *FileSystem) Glob(pattern string) ([]string, error) {
 	panic("implement me")
-	return []string{}, nil
+}
+
+// validPath reports whether name is a valid path as fs.ValidPath does, except
+// that elements may hold any bytes but "/". XFS names are byte strings, so
+// names, that are not valid UTF-8, can be opened as ReadDir returns them.
+func validPath(name string) bool {
+	if name == "." {
+		return true
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
 }
 
 func (xfs *FileSystem) wrapError(op, path string, err error) error {
@@ -196,131 +644,183 @@ func (xfs *FileSystem) wrapError(op, path string, err error) error {
 	}
 }
 
-func (xfs *FileSystem) Open(name string) (fs.File, error) {
-	const op = "open"
+func (xfs *FileSystem) Open(name string) (_ fs.File, err error) {
+	if xfs.metrics != nil {
+		defer func(start time.Time) { observe(xfs.metrics, "open", start, err) }(time.Now())
+	}
+	return xfs.open(name)
+}
 
-	if !fs.ValidPath(name) {
+// open implements Open, other operations call it to report only themselves
+// to the metrics
+func (xfs *FileSystem) open(name string) (fs.File, error) {
+	const op = "open"
+	if !validPath(name) {
 		return nil, xfs.wrapError(op, name, fs.ErrInvalid)
 	}
 
-	dirName, fileName := filepath.Split(name)
-	dirEntries, err := xfs.ReadDir(dirName)
-	if err != nil {
-		return nil, xfs.wrapError(op, name, xerrors.Errorf("railed to read directory: %w", err))
+	if name == "." {
+		inode, err := xfs.getRootInode()
+		if err != nil {
+			return nil, xfs.wrapError(op, name, err)
+		}
+		return xfs.newDir(inode, newFileInfo(".", inode)), nil
 	}
 
-	for _, entry := range dirEntries {
-		if !entry.IsDir() && entry.Name() == fileName {
-			if dir, ok := entry.(dirEntry); ok {
-				if dir.Type().Perm()&0xA000 != 0 {
-					return nil, ErrOpenSymlink
-				}
-
-				f, err := xfs.newFile(dir)
-				if err != nil {
-					return nil, xerrors.Errorf("failed to new file: %w", err)
-				}
-
-				return f, nil
-			}
-		}
+	dirName, fileName := path.Split(name)
+	ino, err := xfs.resolveDir(dirName)
+	if err != nil {
+		return nil, xfs.wrapError(op, name, xerrors.Errorf("failed to resolve directory: %w", err))
 	}
-	return nil, fs.ErrNotExist
-}
 
-func (xfs *FileSystem) seekInode(n uint64) (int64, error) {
-	offset := int64(xfs.PrimaryAG.SuperBlock.InodeAbsOffset(n))
-	off, err := xfs.r.Seek(offset, io.SeekStart)
+	// look up only the requested name, siblings are never parsed
+	entry, err := xfs.lookupEntry(ino, fileName)
 	if err != nil {
-		return 0, err
+		return nil, xfs.wrapError(op, name, err)
 	}
-	if off != offset {
-		return 0, xerrors.Errorf(ErrSeekOffsetFormat, off, offset)
+	inode, err := xfs.ParseInode(entry.InodeNumber())
+	if err != nil {
+		return nil, xfs.wrapError(op, name, xerrors.Errorf("failed to parse inode %d: %w", entry.InodeNumber(), err))
+	}
+	if inode.inodeCore.IsDir() {
+		return xfs.newDir(inode, newFileInfo(fileName, inode)), nil
+	}
+	if inode.inodeCore.FileModeType() == fs.ModeSymlink {
+		return nil, ErrOpenSymlink
 	}
-	return off, nil
-}
 
-func (xfs *FileSystem) seekBlock(n int64) (int64, error) {
-	offset := n * int64(xfs.PrimaryAG.SuperBlock.BlockSize)
-	off, err := xfs.r.Seek(offset, io.SeekStart)
+	f, err := xfs.newFile(dirEntry{newFileInfo(fileName, inode)})
 	if err != nil {
-		return 0, err
+		return nil, xfs.wrapError(op, name, xerrors.Errorf("failed to new file: %w", err))
 	}
-	if off != offset {
-		return 0, xerrors.Errorf(ErrSeekOffsetFormat, off, offset)
-	}
-	return off, nil
+	return f, nil
 }
 
-func (xfs *FileSystem) readBlock(count uint32) ([]byte, error) {
-	buf := make([]byte, 0, xfs.PrimaryAG.SuperBlock.BlockSize*count)
-	for i := uint32(0); i < count; i++ {
-		b, err := utils.ReadBlock(xfs.r)
-		if err != nil {
-			return nil, err
-		}
-		buf = append(buf, b...)
+// readBlock reads count file system blocks of sb_blocksize bytes from the physical block n.
+// It uses ReadAt only, so concurrent calls do not share a file offset.
+func (xfs *FileSystem) readBlock(n int64, count uint32) (_ []byte, err error) {
+	if xfs.tracer != nil {
+		span := xfs.tracer.Start("xfs.read", TraceAttribute{"block", n}, TraceAttribute{"count", int64(count)})
+		defer func() { span.End(err) }()
+	}
+	buf := make([]byte, int(xfs.PrimaryAG.SuperBlock.BlockSize)*int(count))
+	if _, err := xfs.r.ReadAt(buf, n*int64(xfs.PrimaryAG.SuperBlock.BlockSize)); err != nil {
+		return nil, xerrors.Errorf("failed to read %d blocks at block %d: %w", count, n, err)
 	}
 	return buf, nil
 }
 
 func (xfs *FileSystem) readDirEntry(name string) ([]fs.DirEntry, error) {
-	inode, err := xfs.getRootInode()
+	ino, err := xfs.resolveDir(name)
 	if err != nil {
-		return nil, xerrors.Errorf("failed to get root inode: %w", err)
+		return nil, err
 	}
+	return xfs.listDirEntries(ino)
+}
 
-	fileInfos, err := xfs.listFileInfo(inode.inodeCore.Ino)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to list root inode directory entries: %w", err)
+// listDirEntries returns the entries of the directory ino without "." and "..",
+// a partial listing is returned with a *PartialDirectoryError.
+func (xfs *FileSystem) listDirEntries(ino uint64) ([]fs.DirEntry, error) {
+	fileInfos, partialErr := xfs.listFileInfo(ino)
+	if partialErr != nil && !xerrors.Is(partialErr, ErrPartialDirectory) {
+		return nil, xerrors.Errorf("failed to list directory entries inode: %d: %w", ino, partialErr)
 	}
 
-	currentInode := inode
-	dirs := strings.Split(strings.Trim(filepath.Clean(name), string(filepath.Separator)), string(filepath.Separator))
-	for i, dir := range dirs {
-		found := false
-		for _, fileInfo := range fileInfos {
-			if fileInfo.Name() == dir {
-				if !fileInfo.IsDir() {
-					return nil, xerrors.Errorf("%s is file, directory: %w", fileInfo.Name(), fs.ErrNotExist)
-				}
-				found = true
-				currentInode = fileInfo.inode
-				break
-			}
+	dirEntries := []fs.DirEntry{}
+	for _, fileInfo := range fileInfos {
+		// Skip current directory and parent directory
+		// infinit loop in walkDir
+		if fileInfo.Name() == "." || fileInfo.Name() == ".." {
+			continue
 		}
+
+		dirEntries = append(dirEntries, dirEntry{fileInfo})
+	}
+	return dirEntries, partialErr
+}
+
+// resolveDir returns the inode number of the directory name.
+// Only directory entries are consulted on the way, so each directory on the
+// path is decoded exactly once and sibling inodes are never parsed.
+func (xfs *FileSystem) resolveDir(name string) (_ uint64, err error) {
+	if xfs.tracer != nil {
+		span := xfs.tracer.Start("xfs.resolve", TraceAttribute{"path", name})
+		defer func() { span.End(err) }()
+	}
+	ino := xfs.PrimaryAG.SuperBlock.Rootino
+	dirs := strings.Split(strings.Trim(path.Clean(name), "/"), "/")
+	for _, dir := range dirs {
 		// when dir string is empty ("", "."), that is root directory
-		if !found && (dir != "" && dir != ".") {
-			return nil, fs.ErrNotExist
+		if dir == "" || dir == "." {
+			continue
 		}
 
-		fileInfos, err = xfs.listFileInfo(currentInode.inodeCore.Ino)
+		entry, err := xfs.lookupEntry(ino, dir)
 		if err != nil {
-			return nil, xerrors.Errorf("failed to list directory entries inode: %d: %w", currentInode.inodeCore.Ino, err)
+			return 0, err
 		}
+		typ, err := xfs.entryType(entry)
+		if err != nil {
+			return 0, err
+		}
+		if !typ.IsDir() {
+			return 0, xerrors.Errorf("%s is file, directory: %w", entry.Name(), fs.ErrNotExist)
+		}
+		ino = entry.InodeNumber()
+	}
+	return ino, nil
+}
 
-		// list last directory
-		if i == len(dirs)-1 {
-			var dirEntries []fs.DirEntry
-			for _, fileInfo := range fileInfos {
-				// Skip current directory and parent directory
-				// infinit loop in walkDir
-				if fileInfo.Name() == "." || fileInfo.Name() == ".." {
-					continue
-				}
+// lookupEntry returns the entry called name in the directory ino
+// the directory blocks after the one holding the entry are never read.
+func (xfs *FileSystem) lookupEntry(ino uint64, name string) (_ Entry, err error) {
+	entries, ok := xfs.pinnedEntries[ino]
+	if !ok {
+		entries, ok = xfs.dirCache.Get(ino)
+		xfs.cacheLookup("dir", xfs.dirCache != nil, ok)
+	}
+	if !ok {
+		entries, ok = xfs.persistent.dir(ino)
+	}
+	if ok {
+		for _, entry := range entries {
+			if entry.Name() == name {
+				return entry, nil
+			}
+		}
+		return nil, fs.ErrNotExist
+	}
 
-				dirEntries = append(dirEntries, dirEntry{fileInfo})
+	if xfs.tracer != nil {
+		span := xfs.tracer.Start("xfs.lookup", TraceAttribute{"ino", ino}, TraceAttribute{"name", name})
+		defer func() { span.End(err) }()
+	}
+	it, err := xfs.newDirIterator(ino)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to list entries inode: %d: %w", ino, err)
+	}
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			// the entry may be in a skipped block
+			if err := it.partialError(); err != nil {
+				return nil, err
 			}
-			return dirEntries, nil
+			return nil, fs.ErrNotExist
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("failed to list entries inode: %d: %w", ino, err)
+		}
+		if entry.Name() == name {
+			return entry, nil
 		}
 	}
-	return nil, fs.ErrNotExist
 }
 
 func (xfs *FileSystem) listFileInfo(ino uint64) ([]FileInfo, error) {
-	entries, err := xfs.listEntries(ino)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to list entries: %w", err)
+	entries, partialErr := xfs.listEntries(ino)
+	if partialErr != nil && !xerrors.Is(partialErr, ErrPartialDirectory) {
+		return nil, xerrors.Errorf("failed to list entries: %w", partialErr)
 	}
 
 	var fileInfos []FileInfo
@@ -329,64 +829,53 @@ func (xfs *FileSystem) listFileInfo(ino uint64) ([]FileInfo, error) {
 		if err != nil {
 			return nil, xerrors.Errorf("failed to parse inode %d: %w", entry.InodeNumber(), err)
 		}
-		// TODO: mode use inode.InodeCore.Mode
-		fileInfos = append(fileInfos,
-			FileInfo{
-				name:  entry.Name(),
-				inode: inode,
-				mode:  fs.FileMode(inode.inodeCore.Mode),
-			},
-		)
+		fileInfos = append(fileInfos, newFileInfo(entry.Name(), inode))
 	}
-	return fileInfos, nil
+	return fileInfos, partialErr
 }
 
-func (xfs *FileSystem) listEntries(ino uint64) ([]Entry, error) {
-	inode, err := xfs.ParseInode(ino)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to parse inode: %w", err)
+// listEntries returns all the entries of the directory ino, when unsupported
+// blocks were skipped the entries read are returned with a *PartialDirectoryError
+// and the listing is not cached.
+func (xfs *FileSystem) listEntries(ino uint64) (_ []Entry, err error) {
+	if entries, ok := xfs.pinnedEntries[ino]; ok {
+		return entries, nil
+	}
+	cached, ok := xfs.dirCache.Get(ino)
+	xfs.cacheLookup("dir", xfs.dirCache != nil, ok)
+	if ok {
+		return cached, nil
+	}
+	if cached, ok := xfs.persistent.dir(ino); ok {
+		xfs.dirCache.Add(ino, cached)
+		return cached, nil
+	}
+	if xfs.tracer != nil {
+		span := xfs.tracer.Start("xfs.readdir", TraceAttribute{"ino", ino})
+		defer func() { span.End(err) }()
 	}
 
-	if !inode.inodeCore.IsDir() {
-		return nil, xerrors.New("error inode is not directory")
+	it, err := xfs.newDirIterator(ino)
+	if err != nil {
+		return nil, err
 	}
 
 	var entries []Entry
-	if inode.directoryLocal != nil {
-		for _, entry := range inode.directoryLocal.entries {
-			entries = append(entries, entry)
-		}
-	} else if inode.directoryExtents != nil {
-		if len(inode.directoryExtents.bmbtRecs) == 0 {
-			return nil, xerrors.New("directory extents tree bmbtRecs is empty error")
-		}
-
-		for _, b := range inode.directoryExtents.bmbtRecs {
-			p := b.Unpack()
-			for i := 0; i < int(p.BlockCount); i++ {
-				block, err := xfs.parseDir2Block(p)
-				if err != nil {
-					if !xerrors.Is(err, UnsupportedDir2BlockHeaderErr) {
-						return nil, xerrors.Errorf("failed to parse dir2 block: %w", err)
-					}
-					log.Logger.Warn(err)
-				}
-
-				if block == nil {
-					break
-				}
-
-				for _, entry := range block.Entries {
-					entries = append(entries, entry)
-				}
-				p.StartBlock++
-				p.StartOff++
+	for {
+		entry, err := it.Next()
+		if err == io.EOF {
+			if err := it.partialError(); err != nil {
+				return entries, err
 			}
+			xfs.dirCache.Add(ino, entries)
+			xfs.persistent.addDir(ino, entries)
+			return entries, nil
 		}
-	} else {
-		return nil, xerrors.New("not found entries")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
 	}
-	return entries, nil
 }
 
 // FileInfo is implemented io/fs FileInfo interface
@@ -397,12 +886,48 @@ type FileInfo struct {
 	mode fs.FileMode
 }
 
+func newFileInfo(name string, inode *Inode) FileInfo {
+	return FileInfo{
+		name:  name,
+		inode: inode,
+		mode:  inode.inodeCore.FileMode(),
+	}
+}
+
+// toFileInfo returns the FileInfo behind info. Stat returns a *FileInfo for
+// the files it opens, Lstat, ReadDir and Walk return FileInfo values.
+func toFileInfo(info fs.FileInfo) FileInfo {
+	if i, ok := info.(*FileInfo); ok {
+		return *i
+	}
+	return info.(FileInfo)
+}
+
 func (i FileInfo) IsDir() bool {
 	return i.inode.inodeCore.IsDir()
 }
 
 func (i FileInfo) ModTime() time.Time {
-	return time.Unix(int64(i.inode.inodeCore.Mtime), 0)
+	return i.inode.inodeCore.timestamp(i.inode.inodeCore.Mtime)
+}
+
+// AccessTime returns the last access time with nanosecond precision
+func (i FileInfo) AccessTime() time.Time {
+	return i.inode.inodeCore.timestamp(i.inode.inodeCore.Atime)
+}
+
+// ChangeTime returns the last inode change time with nanosecond precision
+func (i FileInfo) ChangeTime() time.Time {
+	return i.inode.inodeCore.timestamp(i.inode.inodeCore.Ctime)
+}
+
+// BirthTime returns the creation time, v1 and v2 inodes do not record it and
+// ok is false for them.
+func (i FileInfo) BirthTime() (t time.Time, ok bool) {
+	if i.inode.inodeCore.Version < 3 {
+		return time.Time{}, false
+	}
+	return i.inode.inodeCore.timestamp(i.inode.inodeCore.Crtime), true
 }
 
 func (i FileInfo) Size() int64 {
@@ -413,8 +938,28 @@ func (i FileInfo) Name() string {
 	return i.name
 }
 
+// NameBytes returns the name as stored in the directory entry, names are byte
+// strings and may not be valid UTF-8.
+func (i FileInfo) NameBytes() []byte {
+	return []byte(i.name)
+}
+
+// Extents returns the data fork extents of regular files and directories in
+// extents or btree format, other inodes have none.
+func (i FileInfo) Extents() []BmbtIrec {
+	return i.inode.extents()
+}
+
+// Ino returns the inode number
+func (i FileInfo) Ino() uint64 {
+	return i.inode.ino
+}
+
+// Sys returns a copy of the *InodeCore, which holds owners, link count, flags
+// and the raw timestamps.
 func (i FileInfo) Sys() interface{} {
-	return nil
+	core := i.inode.inodeCore
+	return &core
 }
 
 func (i FileInfo) Mode() fs.FileMode {
@@ -442,6 +987,9 @@ type File struct {
 	blockSize    int64
 	currentBlock int64
 	table        dataTable
+
+	// offset is the position of the next Read
+	offset int64
 }
 
 // map[offset]
@@ -451,45 +999,173 @@ func (f *File) Stat() (fs.FileInfo, error) {
 	return &f.FileInfo, nil
 }
 
+// Read reads up to len(buf) bytes across block and extent boundaries.
+// It returns n > 0 with a nil error until the end of the file, and 0, io.EOF
+// after that.
 func (f *File) Read(buf []byte) (int, error) {
-	if f.buffer.Len() == 0 {
-		f.currentBlock++
-		if f.currentBlock*f.blockSize >= f.Size() {
-			f.buffer = nil
-			return 0, io.EOF
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	var n int
+	for n < len(buf) {
+		if f.buffer.Len() == 0 {
+			if err := f.readNextBlock(); err != nil {
+				if err == io.EOF && n > 0 {
+					return n, nil
+				}
+				return n, err
+			}
 		}
-	} else {
-		return f.buffer.Read(buf)
+		m, _ := f.buffer.Read(buf[n:])
+		n += m
+		f.offset += int64(m)
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker, only the block holding the new offset is read.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.Name(), Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.Name(), Err: fs.ErrInvalid}
 	}
 
+	f.buffer.Reset()
+	f.offset = offset
+	if offset >= f.Size() {
+		// past the last block, the next Read returns io.EOF
+		f.currentBlock = (f.Size()+f.blockSize-1)/f.blockSize - 1
+		return offset, nil
+	}
+	f.currentBlock = offset/f.blockSize - 1
+	if offset%f.blockSize != 0 {
+		if err := f.readNextBlock(); err != nil {
+			return 0, err
+		}
+		f.buffer.Next(int(offset % f.blockSize))
+	}
+	return offset, nil
+}
+
+// readNextBlock fills the buffer with the next block of the file
+func (f *File) readNextBlock() error {
+	if (f.currentBlock+1)*f.blockSize >= f.Size() {
+		return io.EOF
+	}
+	f.currentBlock++
+
+	// the last block is trimmed to the inode size
+	remaining := f.Size() - f.blockSize*f.currentBlock
 	offset, ok := f.table[f.currentBlock]
 	if !ok {
-		if f.Size()-f.blockSize*f.currentBlock < f.blockSize {
-			f.buffer.Write(make([]byte, f.Size()-f.blockSize*f.currentBlock))
+		// sparse hole or unwritten extent reads as zeros
+		if remaining > f.blockSize {
+			remaining = f.blockSize
 		}
-		f.buffer.Write(make([]byte, f.blockSize))
-	} else {
-		_, err := f.fs.seekBlock(offset)
-		if err != nil {
-			return 0, xerrors.Errorf("failed to seek block: %w", err)
-		}
-		b, err := f.fs.readBlock(1)
-		if err != nil {
-			return 0, xerrors.Errorf("failed to read block: %w", err)
+		f.buffer.Write(make([]byte, remaining))
+		return nil
+	}
+
+	b, err := f.fs.readBlock(offset, 1)
+	if err != nil {
+		return xerrors.Errorf("failed to read block: %w", err)
+	}
+
+	if remaining < f.blockSize {
+		b = b[:remaining]
+	}
+	n, err := f.buffer.Write(b)
+	if n != len(b) {
+		return xerrors.Errorf("write buffer error: actual(%d), expected(%d)", n, len(b))
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+// Dir is implemented io/fs ReadDirFile interface
+type Dir struct {
+	fs *FileSystem
+	FileInfo
+
+	ino     uint64
+	entries []fs.DirEntry
+	read    bool
+	err     error
+}
+
+func (xfs *FileSystem) newDir(inode *Inode, info FileInfo) *Dir {
+	return &Dir{
+		fs:       xfs,
+		FileInfo: info,
+		ino:      inode.ino,
+	}
+}
+
+func (d *Dir) Stat() (fs.FileInfo, error) {
+	return &d.FileInfo, nil
+}
+
+func (d *Dir) Read([]byte) (int, error) {
+	return 0, d.fs.wrapError("read", d.Name(), xerrors.New("is a directory"))
+}
+
+// ReadDir reads the entries of the directory in order, as fs.ReadDirFile.
+// The directory is listed on the first call, when unsupported blocks were
+// skipped the entries read are returned and the *PartialDirectoryError is
+// reported once they are consumed.
+func (d *Dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries, d.err = d.fs.listDirEntries(d.ino)
+		if d.err != nil && !xerrors.Is(d.err, ErrPartialDirectory) {
+			return nil, d.fs.wrapError("readdir", d.Name(), d.err)
 		}
+		d.read = true
+	}
 
-		if f.Size()-f.blockSize*f.currentBlock < f.blockSize {
-			b = b[:f.Size()-f.blockSize*f.currentBlock]
+	if n <= 0 || n > len(d.entries) {
+		entries := d.entries
+		d.entries = nil
+		if d.err != nil {
+			err := d.err
+			d.err = nil
+			return entries, d.fs.wrapError("readdir", d.Name(), err)
+		}
+		if n > 0 && len(entries) == 0 {
+			return nil, io.EOF
 		}
-		n, err := f.buffer.Write(b)
-		if n != len(b) {
-			return 0, xerrors.Errorf("write buffer error: actual(%d), expected(%d)", n, len(b))
+		if entries == nil {
+			entries = []fs.DirEntry{}
 		}
+		return entries, nil
 	}
 
-	return f.buffer.Read(buf)
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
 }
 
-func (f *File) Close() error {
+// Seek rewinds the directory for ReadDir, as os.File does. Only offset 0 from
+// the start is supported.
+func (d *Dir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, d.fs.wrapError("seek", d.Name(), fs.ErrInvalid)
+	}
+	d.entries, d.read, d.err = nil, false, nil
+	return 0, nil
+}
+
+func (d *Dir) Close() error {
 	return nil
 }
@@ -1,6 +1,7 @@
 package xfs_test
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
@@ -8,6 +9,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/masahiro331/go-xfs-filesystem/xfs"
 	"golang.org/x/xerrors"
@@ -115,18 +117,18 @@ func TestFileSystemCheckWalkDir(t *testing.T) {
 		{
 			filesystem: "testdata/image.xfs",
 			name:       "search executable file with root node",
-			parentPath: "/",
+			parentPath: ".",
 			expectedFiles: []string{
-				"/parent/child/child/child/child/child/executable",
-				"/parent/child/child/child/child/executable",
+				"parent/child/child/child/child/child/executable",
+				"parent/child/child/child/child/executable",
 			},
 		},
 		{
 			filesystem: "testdata/image.xfs",
 			name:       "search executable file with deep path",
-			parentPath: "/parent/child/child/child/child/child/",
+			parentPath: "parent/child/child/child/child/child",
 			expectedFiles: []string{
-				"/parent/child/child/child/child/child/executable",
+				"parent/child/child/child/child/child/executable",
 			},
 		},
 	}
@@ -291,3 +293,723 @@ func TestFileSystemCheckReadFile(t *testing.T) {
 		})
 	}
 }
+
+func TestFileSystemExists(t *testing.T) {
+	testCases := []struct {
+		filesystem   string
+		name         string
+		expected     bool
+		expectedType fs.FileMode
+		expectedErr  error
+	}{
+		{
+			filesystem:   "testdata/image.xfs",
+			name:         "etc/os-release",
+			expected:     true,
+			expectedType: 0,
+		},
+		{
+			filesystem:   "testdata/image.xfs",
+			name:         "parent/child/child",
+			expected:     true,
+			expectedType: fs.ModeDir,
+		},
+		{
+			filesystem:   "testdata/image.xfs",
+			name:         ".",
+			expected:     true,
+			expectedType: fs.ModeDir,
+		},
+		{
+			filesystem:   "testdata/image.xfs",
+			name:         "fmt_leaf_directories/200",
+			expected:     true,
+			expectedType: 0,
+		},
+		{
+			filesystem:   "testdata/image.xfs",
+			name:         "fmt_node_directories/1024",
+			expected:     true,
+			expectedType: 0,
+		},
+		{
+			filesystem: "testdata/image.xfs",
+			name:       "fmt_node_directories/1025",
+			expected:   false,
+		},
+		{
+			filesystem: "testdata/image.xfs",
+			name:       "etc/alpine-release",
+			expected:   false,
+		},
+		{
+			filesystem: "testdata/image.xfs",
+			name:       "etc/os-release/child",
+			expected:   false,
+		},
+		{
+			filesystem:  "testdata/image.xfs",
+			name:        "/etc/os-release",
+			expectedErr: fs.ErrInvalid,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.filesystem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			exists, typ, err := fileSystem.Exists(tt.name)
+			if tt.expectedErr != nil {
+				if !xerrors.Is(err, tt.expectedErr) {
+					t.Fatalf("expected %v, actual %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != tt.expected {
+				t.Errorf("expected %t, actual %t", tt.expected, exists)
+			}
+			if typ != tt.expectedType {
+				t.Errorf("expected %s, actual %s", tt.expectedType, typ)
+			}
+		})
+	}
+}
+
+func TestFileSystemPinnedPaths(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil,
+		xfs.WithPinnedPaths("/", "/etc", "fmt_extents_block_directories", "no_exist_directory"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pinned directories must be served without touching the image
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		entriesLen int
+	}{
+		{
+			name:       "etc",
+			entriesLen: 1,
+		},
+		{
+			name:       "fmt_extents_block_directories",
+			entriesLen: 8,
+		},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dirEntries, err := fileSystem.ReadDir(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dirEntries) != tt.entriesLen {
+				t.Errorf("expected %d, actual %d", tt.entriesLen, len(dirEntries))
+			}
+		})
+	}
+}
+
+func TestFileSystemTypedErrors(t *testing.T) {
+	_, err := xfs.NewFS(*io.NewSectionReader(bytes.NewReader(make([]byte, 1<<16)), 0, 1<<16), nil)
+	if !xerrors.Is(err, xfs.ErrNotXFS) {
+		t.Fatalf("expected %v, actual %v", xfs.ErrNotXFS, err)
+	}
+
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fileSystem.ParseInode(9999)
+	if !xerrors.Is(err, xfs.ErrCorrupted) {
+		t.Fatalf("expected %v, actual %v", xfs.ErrCorrupted, err)
+	}
+	var corrupted *xfs.CorruptedError
+	if !xerrors.As(err, &corrupted) {
+		t.Fatalf("expected CorruptedError, actual %T", err)
+	}
+	if corrupted.Structure != "inode" || corrupted.Offset <= 0 {
+		t.Errorf("unexpected corruption context: %+v", corrupted)
+	}
+	if xerrors.Is(err, xfs.ErrUnsupportedFeature) {
+		t.Error("corruption must not match ErrUnsupportedFeature")
+	}
+}
+
+func TestFileSystemErrorContext(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := fileSystem.PrimaryAG.SuperBlock.InodeAbsOffset(9999)
+	_, err = fileSystem.ParseInode(9999)
+	expected := fmt.Sprintf("inode 9999 (AG 0, offset 0x%x): ", offset)
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected prefix %q, actual %v", expected, err)
+	}
+	var corrupted *xfs.CorruptedError
+	if !xerrors.As(err, &corrupted) || corrupted.Offset != int64(offset) {
+		t.Errorf("expected offset 0x%x, actual %+v", offset, corrupted)
+	}
+}
+
+func TestFileSystemInvalidPath(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	methods := map[string]func(name string) error{
+		"Open": func(name string) error {
+			_, err := fileSystem.Open(name)
+			return err
+		},
+		"Stat": func(name string) error {
+			_, err := fileSystem.Stat(name)
+			return err
+		},
+		"ReadDir": func(name string) error {
+			_, err := fileSystem.ReadDir(name)
+			return err
+		},
+		"ReadFile": func(name string) error {
+			_, err := fileSystem.ReadFile(name)
+			return err
+		},
+		"ReadDirInfo": func(name string) error {
+			_, err := fileSystem.ReadDirInfo(name)
+			return err
+		},
+		"Exists": func(name string) error {
+			_, _, err := fileSystem.Exists(name)
+			return err
+		},
+	}
+	for method, fn := range methods {
+		for _, name := range []string{"/", "/etc", "etc/", "etc/../etc", "./etc", ""} {
+			t.Run(fmt.Sprintf("%s(%q)", method, name), func(t *testing.T) {
+				err := fn(name)
+				var pathErr *fs.PathError
+				if !xerrors.Is(err, fs.ErrInvalid) || !xerrors.As(err, &pathErr) {
+					t.Fatalf("expected %v PathError, actual %v", fs.ErrInvalid, err)
+				}
+			})
+		}
+	}
+
+	b, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("expected %q, actual %q", expected, b)
+	}
+}
+
+func TestFileSystemOpenDir(t *testing.T) {
+	// v1 and v2 inode cores do not hold the inode number
+	for _, image := range []string{"testdata/image.xfs", "testdata/tiny/v4.xfs"} {
+		t.Run(image, func(t *testing.T) {
+			f, err := os.Open(image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testOpenDir(t, fileSystem)
+		})
+	}
+}
+
+func testOpenDir(t *testing.T, fileSystem *xfs.FileSystem) {
+	for _, name := range []string{".", "etc"} {
+		t.Run(name, func(t *testing.T) {
+			expected, err := fileSystem.ReadDir(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir, err := fileSystem.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dir.Close()
+			stat, err := dir.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stat.IsDir() || !stat.Mode().IsDir() {
+				t.Errorf("expected directory, actual mode %s", stat.Mode())
+			}
+			if _, err := dir.Read(make([]byte, 1)); err == nil {
+				t.Error("expected read error on directory")
+			}
+
+			rd, ok := dir.(fs.ReadDirFile)
+			if !ok {
+				t.Fatalf("expected fs.ReadDirFile, actual %T", dir)
+			}
+			var actual []fs.DirEntry
+			for {
+				entries, err := rd.ReadDir(2)
+				actual = append(actual, entries...)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) == 0 {
+					t.Fatal("expected entries or io.EOF")
+				}
+			}
+			if len(actual) != len(expected) {
+				t.Fatalf("expected %d entries, actual %d", len(expected), len(actual))
+			}
+			for i := range expected {
+				if actual[i].Name() != expected[i].Name() || actual[i].Type() != expected[i].Type() {
+					t.Errorf("expected %s %s, actual %s %s", expected[i].Name(), expected[i].Type(), actual[i].Name(), actual[i].Type())
+				}
+			}
+
+			entries, err := rd.ReadDir(-1)
+			if err != nil || len(entries) != 0 {
+				t.Errorf("expected no entries at end of directory, actual %d, %v", len(entries), err)
+			}
+		})
+	}
+
+	entries, err := fileSystem.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == "etc" && entry.Type() != fs.ModeDir {
+			t.Errorf("expected etc type %s, actual %s", fs.ModeDir, entry.Type())
+		}
+	}
+}
+
+func TestFileSystemNotExist(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	methods := map[string]func(name string) error{
+		"Open": func(name string) error {
+			_, err := fileSystem.Open(name)
+			return err
+		},
+		"Stat": func(name string) error {
+			_, err := fileSystem.Stat(name)
+			return err
+		},
+		"Lstat": func(name string) error {
+			_, err := fileSystem.Lstat(name)
+			return err
+		},
+		"ReadDir": func(name string) error {
+			_, err := fileSystem.ReadDir(name)
+			return err
+		},
+		"ReadFile": func(name string) error {
+			_, err := fileSystem.ReadFile(name)
+			return err
+		},
+		"ReadDirInfo": func(name string) error {
+			_, err := fileSystem.ReadDirInfo(name)
+			return err
+		},
+		"ReadLink": func(name string) error {
+			_, err := fileSystem.ReadLink(name)
+			return err
+		},
+	}
+	for method, fn := range methods {
+		for _, name := range []string{"missing", "etc/missing", "missing/os-release", "etc/os-release/missing", "fmt_local_directory/short_form/missing"} {
+			t.Run(fmt.Sprintf("%s(%q)", method, name), func(t *testing.T) {
+				err := fn(name)
+				var pathErr *fs.PathError
+				if !xerrors.Is(err, fs.ErrNotExist) || !xerrors.As(err, &pathErr) {
+					t.Fatalf("expected %v PathError, actual %v", fs.ErrNotExist, err)
+				}
+			})
+		}
+	}
+
+	if _, err := fileSystem.ReadDir("etc/os-release"); !xerrors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %v, actual %v", fs.ErrNotExist, err)
+	}
+}
+
+func TestFileSystemRawReadDir(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := fileSystem.RawReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootIno := fileSystem.PrimaryAG.SuperBlock.Rootino
+	inodes := make(map[string]uint64)
+	for _, entry := range root {
+		inodes[entry.Name()] = entry.InodeNumber()
+	}
+
+	tests := []struct {
+		name   string
+		parent uint64
+	}{
+		// shortform directories, the dot entries are built from the header
+		{name: ".", parent: rootIno},
+		{name: "fmt_local_directory", parent: rootIno},
+		// extent directories, the dot entries are stored in the first data block
+		{name: "fmt_leaf_directories", parent: rootIno},
+		{name: "fmt_node_directories", parent: rootIno},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := fileSystem.RawReadDir(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := fileSystem.ReadDir(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(raw) != len(entries)+2 {
+				t.Fatalf("expected %d entries, actual %d", len(entries)+2, len(raw))
+			}
+
+			self := rootIno
+			if tt.name != "." {
+				self = inodes[tt.name]
+			}
+			if raw[0].Name() != "." || raw[0].InodeNumber() != self {
+				t.Errorf("expected . %d, actual %s %d", self, raw[0].Name(), raw[0].InodeNumber())
+			}
+			if raw[1].Name() != ".." || raw[1].InodeNumber() != tt.parent {
+				t.Errorf("expected .. %d, actual %s %d", tt.parent, raw[1].Name(), raw[1].InodeNumber())
+			}
+			for i, entry := range entries {
+				if raw[i+2].Name() != entry.Name() {
+					t.Errorf("expected %s, actual %s", entry.Name(), raw[i+2].Name())
+				}
+			}
+		})
+	}
+}
+
+func TestFileInfoTimestamps(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := fileSystem.Stat("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as stat(1) reports it on the mounted image
+	expected := time.Date(2021, 6, 5, 15, 25, 40, 81436510, time.UTC)
+	if !stat.ModTime().Equal(expected) {
+		t.Errorf("expected %s, actual %s", expected, stat.ModTime())
+	}
+	fileInfo, ok := stat.(*xfs.FileInfo)
+	if !ok {
+		t.Fatalf("expected *xfs.FileInfo, actual %T", stat)
+	}
+	birth, ok := fileInfo.BirthTime()
+	for name, actual := range map[string]time.Time{"atime": fileInfo.AccessTime(), "ctime": fileInfo.ChangeTime(), "crtime": birth} {
+		if !actual.Equal(expected) {
+			t.Errorf("%s: expected %s, actual %s", name, expected, actual)
+		}
+	}
+	if !ok {
+		t.Error("expected crtime of a v3 inode")
+	}
+}
+
+func TestFileSystemReadDirInfo(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		expectedName string
+		isDir        bool
+		size         int64
+	}{
+		{name: ".", expectedName: ".", isDir: true},
+		{name: "etc", expectedName: "etc", isDir: true},
+		{name: "fmt_extents_file_1024", expectedName: "fmt_extents_file_1024", size: 1024},
+		{name: "etc/os-release", expectedName: "os-release", size: 333},
+		{name: "parent/child/child", expectedName: "child", isDir: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := fileSystem.ReadDirInfo(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name() != tt.expectedName || info.IsDir() != tt.isDir {
+				t.Errorf("expected %s dir %t, actual %s dir %t", tt.expectedName, tt.isDir, info.Name(), info.IsDir())
+			}
+			if !tt.isDir && info.Size() != tt.size {
+				t.Errorf("expected size %d, actual %d", tt.size, info.Size())
+			}
+
+			stat, err := fileSystem.Stat(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Name() != info.Name() || stat.Mode() != info.Mode() || stat.Size() != info.Size() {
+				t.Errorf("expected Stat %s %s %d, actual %s %s %d", info.Name(), info.Mode(), info.Size(), stat.Name(), stat.Mode(), stat.Size())
+			}
+		})
+	}
+}
+
+func TestFileSystemEmptyDirectory(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a shortform directory without entries
+	const name = "fmt_local_directory/short_form"
+	entries, err := fileSystem.ReadDir(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries == nil || len(entries) != 0 {
+		t.Errorf("expected an empty slice, actual %#v", entries)
+	}
+
+	raw, err := fileSystem.RawReadDir(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2 || raw[0].Name() != "." || raw[1].Name() != ".." {
+		t.Errorf("expected . and .., actual %v", raw)
+	}
+
+	dir, err := fileSystem.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	entries, err = dir.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("expected an empty slice, actual %#v, %v", entries, err)
+	}
+	if _, err := dir.(fs.ReadDirFile).ReadDir(1); err != io.EOF {
+		t.Errorf("expected %v, actual %v", io.EOF, err)
+	}
+
+	var walked []string
+	err = fs.WalkDir(fileSystem, "fmt_local_directory", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(walked, ",") != "fmt_local_directory,"+name {
+		t.Errorf("unexpected walk %v", walked)
+	}
+}
+
+func TestFileSystemNonUTF8Names(t *testing.T) {
+	b, err := os.ReadFile("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rename the shortform entry short_form of fmt_local_directory in place
+	const name = "sh\xffrt_f\xc3(m"
+	sb := fileSystem.PrimaryAG.SuperBlock
+	offset := int(sb.InodeAbsOffset(11075))
+	i := bytes.Index(b[offset:offset+int(sb.Inodesize)], []byte("short_form"))
+	if i < 0 {
+		t.Fatal("short_form is not in fmt_local_directory")
+	}
+	copy(b[offset+i:], name)
+
+	fileSystem, err = xfs.NewFS(*io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := fileSystem.ReadDir("fmt_local_directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != name {
+		t.Fatalf("expected %q, actual %v", name, entries)
+	}
+	info, err := entries[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(info.(xfs.FileInfo).NameBytes(), []byte(name)) {
+		t.Errorf("expected %q, actual %q", name, info.(xfs.FileInfo).NameBytes())
+	}
+
+	p := "fmt_local_directory/" + name
+	if _, err := fileSystem.Stat(p); err != nil {
+		t.Errorf("Stat: %v", err)
+	}
+	if _, err := fileSystem.ReadDirInfo(p); err != nil {
+		t.Errorf("ReadDirInfo: %v", err)
+	}
+	if _, err := fileSystem.ReadDir(p); err != nil {
+		t.Errorf("ReadDir: %v", err)
+	}
+	f, err := fileSystem.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Name() != name || !stat.IsDir() {
+		t.Errorf("expected directory %q, actual %q", name, stat.Name())
+	}
+
+	var walked []string
+	if err := fs.WalkDir(fileSystem, "fmt_local_directory", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 2 || walked[1] != p {
+		t.Errorf("unexpected walk %q", walked)
+	}
+}
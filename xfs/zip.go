@@ -0,0 +1,113 @@
+package xfs
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// zipUnixExtraID is the Info-ZIP "ux" extra field holding the owners
+const zipUnixExtraID = 0x7875
+
+// WriteZip writes the tree at root to w as a zip archive. Names are relative
+// to the parent of root and the contents of the image root are written
+// without the root itself, as xfs tar does. The unix mode bits are kept in
+// the external attributes, the modification time in the extended timestamp
+// field and the owners in the Info-ZIP unix field. Symlinks are stored with
+// their target as content, hard links as copies. Device files, FIFOs and
+// sockets are skipped.
+func (xfs *FileSystem) WriteZip(w io.Writer, root string) error {
+	const op = "zip"
+
+	if !validPath(root) {
+		return xfs.wrapError(op, root, fs.ErrInvalid)
+	}
+	zw := zip.NewWriter(w)
+	err := fs.WalkDir(xfs, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		rel := name
+		if root != "." {
+			rel = strings.TrimPrefix(name, path.Dir(root)+"/")
+		}
+		return xfs.writeZipEntry(zw, name, rel, d)
+	})
+	if err != nil {
+		return xfs.wrapError(op, root, err)
+	}
+	return zw.Close()
+}
+
+func (xfs *FileSystem) writeZipEntry(zw *zip.Writer, name, rel string, d fs.DirEntry) error {
+	if d.Type()&(fs.ModeDevice|fs.ModeNamedPipe|fs.ModeSocket) != 0 {
+		xfs.Logger().Warn("skipping unsupported file type", "path", name, "type", d.Type())
+		return nil
+	}
+	i, err := d.Info()
+	if err != nil {
+		return err
+	}
+	info := toFileInfo(i)
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if d.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Modified = info.ModTime()
+	hdr.Extra = zipUnixExtra(info.inode.inodeCore.UID, info.inode.inodeCore.GID)
+	if d.Type().IsRegular() {
+		hdr.Method = zip.Deflate
+	} else {
+		hdr.Method = zip.Store
+	}
+
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case d.Type() == fs.ModeSymlink:
+		target, err := xfs.ReadLink(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, target)
+		return err
+	case d.Type().IsRegular():
+		f, err := xfs.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(fw, f); err != nil {
+			return xerrors.Errorf("failed to copy %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// zipUnixExtra returns the Info-ZIP unix extra field version 1 with 32 bit IDs
+func zipUnixExtra(uid, gid uint32) []byte {
+	b := make([]byte, 15)
+	binary.LittleEndian.PutUint16(b, zipUnixExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 11)
+	b[4] = 1
+	b[5] = 4
+	binary.LittleEndian.PutUint32(b[6:], uid)
+	b[10] = 4
+	binary.LittleEndian.PutUint32(b[11:], gid)
+	return b
+}
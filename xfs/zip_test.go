@@ -0,0 +1,103 @@
+package xfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileSystemWriteZip(t *testing.T) {
+	f, err := os.Open("testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	osRelease, err := fileSystem.Stat("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := fileSystem.WriteZip(&buf, "."); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1251 {
+		t.Errorf("expected %d entries, actual %d", 1251, len(zr.File))
+	}
+	modes := map[string]fs.FileMode{
+		"etc/":           fs.ModeDir | 0o755,
+		"etc/os-release": 0o644,
+		"parent/child/child/child/child/executable": 0o755,
+	}
+	for _, zf := range zr.File {
+		mode, ok := modes[zf.Name]
+		if !ok {
+			continue
+		}
+		delete(modes, zf.Name)
+		if zf.Mode() != mode {
+			t.Errorf("%s: expected mode %s, actual %s", zf.Name, mode, zf.Mode())
+		}
+		if zf.Name != "etc/os-release" {
+			continue
+		}
+
+		if !zf.Modified.Equal(osRelease.ModTime().Truncate(1e9)) {
+			t.Errorf("expected modification time %s, actual %s", osRelease.ModTime(), zf.Modified)
+		}
+		if !bytes.Contains(zf.Extra, zipUnixExtra(0, 0)) {
+			t.Errorf("owners are missing from %x", zf.Extra)
+		}
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(expected) {
+			t.Errorf("unexpected content %q", data)
+		}
+	}
+	if len(modes) != 0 {
+		t.Errorf("missing entries %v", modes)
+	}
+
+	buf.Reset()
+	if err := fileSystem.WriteZip(&buf, "etc"); err != nil {
+		t.Fatal(err)
+	}
+	zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"etc/", "etc/os-release"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
@@ -0,0 +1,141 @@
+package xfs9p
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// the 9P2000.L message types, a reply is the request type + 1
+const (
+	rlerror      = 7
+	tstatfs      = 8
+	tlopen       = 12
+	tlcreate     = 14
+	tsymlink     = 16
+	tmknod       = 18
+	trename      = 20
+	treadlink    = 22
+	tgetattr     = 24
+	tsetattr     = 26
+	txattrwalk   = 30
+	txattrcreate = 32
+	treaddir     = 40
+	tfsync       = 50
+	tlink        = 70
+	tmkdir       = 72
+	trenameat    = 74
+	tunlinkat    = 76
+	tversion     = 100
+	tauth        = 102
+	tattach      = 104
+	tflush       = 108
+	twalk        = 110
+	tread        = 116
+	twrite       = 118
+	tclunk       = 120
+	tremove      = 122
+)
+
+const (
+	version   = "9P2000.L"
+	maxMsize  = 1 << 20
+	headerLen = 7
+	// maxWalk is the most names a Twalk may carry
+	maxWalk = 16
+)
+
+// qid types
+const (
+	qtDir     = 0x80
+	qtSymlink = 0x02
+	qtFile    = 0x00
+)
+
+// getattrBasic is the P9_GETATTR_BASIC mask, getattrBtime adds the birth time
+const (
+	getattrBasic = 0x000007ff
+	getattrBtime = 0x00000800
+)
+
+// Linux errno values, 9P2000.L carries them whatever the server runs on
+const (
+	eNOENT     = 2
+	eIO        = 5
+	eBADF      = 9
+	eNOTDIR    = 20
+	eISDIR     = 21
+	eINVAL     = 22
+	eROFS      = 30
+	eNODATA    = 61
+	eOPNOTSUPP = 95
+)
+
+// Linux open flags of Tlopen
+const (
+	oAccmode = 0x3
+	oTrunc   = 0x200
+)
+
+// errno is the error replied with Rlerror
+type errno uint32
+
+func (e errno) Error() string {
+	return fmt.Sprintf("errno %d", uint32(e))
+}
+
+type qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+// encoder appends the little endian fields of a message to b
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u8(v uint8) { e.b = append(e.b, v) }
+
+func (e *encoder) u16(v uint16) { e.b = append(e.b, byte(v), byte(v>>8)) }
+
+func (e *encoder) u32(v uint32) { e.u16(uint16(v)); e.u16(uint16(v >> 16)) }
+
+func (e *encoder) u64(v uint64) { e.u32(uint32(v)); e.u32(uint32(v >> 32)) }
+
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.typ)
+	e.u32(q.version)
+	e.u64(q.path)
+}
+
+// decoder reads the fields of a message, reads beyond the end set err and
+// return zero values
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = xerrors.New("short message")
+		return make([]byte, n)
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) u16() uint16 { return binary.LittleEndian.Uint16(d.next(2)) }
+
+func (d *decoder) u32() uint32 { return binary.LittleEndian.Uint32(d.next(4)) }
+
+func (d *decoder) u64() uint64 { return binary.LittleEndian.Uint64(d.next(8)) }
+
+func (d *decoder) str() string { return string(d.next(int(d.u16()))) }
@@ -0,0 +1,569 @@
+// Package xfs9p serves an xfs.FileSystem read-only over 9P2000.L, the dialect
+// of the Linux v9fs client, QEMU and WSL.
+package xfs9p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// Serve serves fileSystem to every client connecting to l until l is closed,
+// e.g. for mount -t 9p -o trans=tcp,version=9p2000.L
+func Serve(l net.Listener, fileSystem *xfs.FileSystem) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ServeConn(c, fileSystem)
+	}
+}
+
+// ServeConn serves fileSystem on a single connection until the client closes
+// it or sends a malformed message. Requests are answered in order.
+func ServeConn(rwc io.ReadWriteCloser, fileSystem *xfs.FileSystem) error {
+	defer rwc.Close()
+	c := &conn{
+		fileSystem: fileSystem,
+		msize:      maxMsize,
+		fids:       map[uint32]*fid{},
+	}
+	defer c.clunkAll()
+
+	r := bufio.NewReader(rwc)
+	w := bufio.NewWriter(rwc)
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.LittleEndian.Uint32(size)
+		if n < headerLen || n > c.msize {
+			return xerrors.Errorf("invalid message size %d", n)
+		}
+		msg := make([]byte, n-4)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return err
+		}
+		typ, tag := msg[0], binary.LittleEndian.Uint16(msg[1:])
+
+		reply := encoder{b: make([]byte, headerLen, 64)}
+		d := &decoder{b: msg[3:]}
+		err := c.handle(typ, d, &reply)
+		if err == nil && d.err != nil {
+			err = errno(eINVAL)
+		}
+		rtyp := typ + 1
+		if err != nil {
+			reply.b = reply.b[:headerLen]
+			reply.u32(uint32(toErrno(err)))
+			rtyp = rlerror
+		}
+		binary.LittleEndian.PutUint32(reply.b, uint32(len(reply.b)))
+		reply.b[4] = rtyp
+		binary.LittleEndian.PutUint16(reply.b[5:], tag)
+		if _, err := w.Write(reply.b); err != nil {
+			return err
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// fid is a file of the image a client refers to
+type fid struct {
+	name string
+	qid  qid
+	// opened is set by Tlopen, file is the open regular file
+	opened bool
+	file   *xfs.File
+	// dirents are the entries of a directory, read by the first Treaddir
+	dirents []dirent
+	// xattr is the value or the name list read by a Txattrwalk fid
+	xattr   []byte
+	isXattr bool
+}
+
+type dirent struct {
+	qid  qid
+	typ  uint8
+	name string
+}
+
+type conn struct {
+	fileSystem *xfs.FileSystem
+	msize      uint32
+	fids       map[uint32]*fid
+}
+
+func (c *conn) handle(typ uint8, d *decoder, r *encoder) error {
+	switch typ {
+	case tversion:
+		return c.version(d, r)
+	case tattach:
+		return c.attach(d, r)
+	case twalk:
+		return c.walk(d, r)
+	case tlopen:
+		return c.lopen(d, r)
+	case tread:
+		return c.read(d, r)
+	case treaddir:
+		return c.readdir(d, r)
+	case tgetattr:
+		return c.getattr(d, r)
+	case treadlink:
+		return c.readlink(d, r)
+	case tstatfs:
+		return c.statfs(d, r)
+	case txattrwalk:
+		return c.xattrwalk(d, r)
+	case tclunk:
+		return c.clunk(d.u32())
+	case tremove:
+		// Tremove clunks the fid even when it fails
+		if err := c.clunk(d.u32()); err != nil {
+			return err
+		}
+		return errno(eROFS)
+	case tflush, tfsync:
+		// requests are answered in order, there is nothing to flush
+		return nil
+	case tlcreate, tsymlink, tmknod, trename, tsetattr, txattrcreate, tlink, tmkdir, trenameat, tunlinkat, twrite:
+		return errno(eROFS)
+	}
+	return errno(eOPNOTSUPP)
+}
+
+func (c *conn) version(d *decoder, r *encoder) error {
+	msize, v := d.u32(), d.str()
+	if msize < c.msize {
+		c.msize = msize
+	}
+	// a version resets the session
+	c.clunkAll()
+	if !strings.HasPrefix(v, version) {
+		v = "unknown"
+	} else {
+		v = version
+	}
+	r.u32(c.msize)
+	r.str(v)
+	return nil
+}
+
+func (c *conn) attach(d *decoder, r *encoder) error {
+	id, _, _, aname := d.u32(), d.u32(), d.str(), d.str()
+	if _, ok := c.fids[id]; ok {
+		return errno(eBADF)
+	}
+	// aname selects the exported directory, the root by default
+	name := strings.Trim(path.Clean("/"+aname), "/")
+	if name == "" {
+		name = "."
+	}
+	q, err := c.qid(name)
+	if err != nil {
+		return err
+	}
+	if q.typ != qtDir {
+		return errno(eNOTDIR)
+	}
+	c.fids[id] = &fid{name: name, qid: q}
+	r.qid(q)
+	return nil
+}
+
+func (c *conn) walk(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	newID := d.u32()
+	names := make([]string, d.u16())
+	if len(names) > maxWalk {
+		return errno(eINVAL)
+	}
+	for i := range names {
+		names[i] = d.str()
+	}
+	// newfid may be fid itself, it is then replaced by the walk
+	if other, ok := c.fids[newID]; ok && other != f {
+		return errno(eBADF)
+	}
+
+	// walking stops at the first name that does not exist, only a full walk
+	// makes newfid
+	name, q := f.name, f.qid
+	var qids []qid
+	for _, n := range names {
+		if q.typ != qtDir {
+			break
+		}
+		next := path.Join(name, n)
+		if n == ".." {
+			next = path.Dir(name)
+		}
+		nq, err := c.qid(next)
+		if err != nil {
+			if len(qids) == 0 {
+				return err
+			}
+			break
+		}
+		name, q = next, nq
+		qids = append(qids, nq)
+	}
+	if len(qids) == len(names) {
+		c.fids[newID] = &fid{name: name, qid: q}
+	} else if len(qids) == 0 {
+		return errno(eNOTDIR)
+	}
+	r.u16(uint16(len(qids)))
+	for _, q := range qids {
+		r.qid(q)
+	}
+	return nil
+}
+
+func (c *conn) lopen(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	flags := d.u32()
+	if f.opened {
+		return errno(eBADF)
+	}
+	if flags&oAccmode != 0 || flags&oTrunc != 0 {
+		return errno(eROFS)
+	}
+	switch f.qid.typ {
+	case qtSymlink:
+		return errno(eINVAL)
+	case qtFile:
+		file, err := c.fileSystem.Open(f.name)
+		if err != nil {
+			return err
+		}
+		xf, ok := file.(*xfs.File)
+		if !ok {
+			file.Close()
+			return errno(eISDIR)
+		}
+		f.file = xf
+	}
+	f.opened = true
+	r.qid(f.qid)
+	// 0 lets the client use msize
+	r.u32(0)
+	return nil
+}
+
+func (c *conn) read(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	offset, count := d.u64(), c.limit(d.u32())
+
+	var data []byte
+	switch {
+	case f.isXattr:
+		if offset < uint64(len(f.xattr)) {
+			data = f.xattr[offset:]
+		}
+		if uint32(len(data)) > count {
+			data = data[:count]
+		}
+	case f.file != nil:
+		if _, err := f.file.Seek(int64(offset), io.SeekStart); err != nil {
+			return err
+		}
+		data = make([]byte, count)
+		n, err := io.ReadFull(f.file, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		data = data[:n]
+	case f.qid.typ == qtDir:
+		return errno(eISDIR)
+	default:
+		return errno(eBADF)
+	}
+	r.u32(uint32(len(data)))
+	r.b = append(r.b, data...)
+	return nil
+}
+
+func (c *conn) readdir(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	offset, count := d.u64(), c.limit(d.u32())
+	if !f.opened || f.qid.typ != qtDir {
+		return errno(eBADF)
+	}
+	if offset == 0 || f.dirents == nil {
+		if f.dirents, err = c.dirents(f); err != nil {
+			return err
+		}
+	}
+
+	// the offset of an entry is the offset of the next one
+	sizeAt := len(r.b)
+	r.u32(0)
+	start := len(r.b)
+	for i := offset; i < uint64(len(f.dirents)); i++ {
+		e := f.dirents[i]
+		if len(r.b)-start+13+8+1+2+len(e.name) > int(count) {
+			break
+		}
+		r.qid(e.qid)
+		r.u64(i + 1)
+		r.u8(e.typ)
+		r.str(e.name)
+	}
+	binary.LittleEndian.PutUint32(r.b[sizeAt:], uint32(len(r.b)-start))
+	return nil
+}
+
+// dirents returns the entries of the directory f with . and ..
+func (c *conn) dirents(f *fid) ([]dirent, error) {
+	parent, err := c.qid(path.Dir(f.name))
+	if err != nil {
+		return nil, err
+	}
+	dirents := []dirent{
+		{qid: f.qid, typ: direntType(fs.ModeDir), name: "."},
+		{qid: parent, typ: direntType(fs.ModeDir), name: ".."},
+	}
+	entries, err := c.fileSystem.ReadDir(f.name)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		dirents = append(dirents, dirent{qid: newQid(info.(xfs.FileInfo)), typ: direntType(entry.Type()), name: entry.Name()})
+	}
+	return dirents, nil
+}
+
+func (c *conn) getattr(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	info, err := c.stat(f.name)
+	if err != nil {
+		return err
+	}
+	core := info.Sys().(*xfs.InodeCore)
+	btime, hasBtime := info.BirthTime()
+	valid := uint64(getattrBasic)
+	if hasBtime {
+		valid |= getattrBtime
+	}
+	blockSize := c.fileSystem.PrimaryAG.SuperBlock.BlockSize
+
+	r.u64(valid)
+	r.qid(newQid(info))
+	r.u32(uint32(core.Mode))
+	r.u32(core.UID)
+	r.u32(core.GID)
+	r.u64(uint64(core.NLink))
+	// the device number of special files is not decoded
+	r.u64(0)
+	r.u64(uint64(info.Size()))
+	r.u64(uint64(blockSize))
+	r.u64(core.Nblocks * uint64(blockSize) / 512)
+	for _, t := range []time.Time{info.AccessTime(), info.ModTime(), info.ChangeTime(), btime} {
+		if t.IsZero() {
+			r.u64(0)
+			r.u64(0)
+			continue
+		}
+		r.u64(uint64(t.Unix()))
+		r.u64(uint64(t.Nanosecond()))
+	}
+	// gen and data_version
+	r.u64(0)
+	r.u64(0)
+	return nil
+}
+
+func (c *conn) readlink(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	if f.qid.typ != qtSymlink {
+		return errno(eINVAL)
+	}
+	target, err := c.fileSystem.ReadLink(f.name)
+	if err != nil {
+		return err
+	}
+	r.str(target)
+	return nil
+}
+
+func (c *conn) statfs(d *decoder, r *encoder) error {
+	if _, err := c.fid(d.u32()); err != nil {
+		return err
+	}
+	sb := c.fileSystem.PrimaryAG.SuperBlock
+	r.u32(xfs.XFS_SB_MAGIC)
+	r.u32(sb.BlockSize)
+	r.u64(sb.Dblocks)
+	r.u64(sb.Fdblocks)
+	r.u64(sb.Fdblocks)
+	r.u64(sb.Icount)
+	r.u64(sb.Ifree)
+	r.u64(0)
+	r.u32(255)
+	return nil
+}
+
+func (c *conn) xattrwalk(d *decoder, r *encoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	newID, name := d.u32(), d.str()
+	if _, ok := c.fids[newID]; ok {
+		return errno(eBADF)
+	}
+
+	// an empty name lists the names, NUL terminated
+	var value []byte
+	if name == "" {
+		xattrs, err := c.fileSystem.ListXattrs(f.name)
+		if err != nil {
+			return err
+		}
+		for _, x := range xattrs {
+			value = append(append(value, x.Name...), 0)
+		}
+	} else if value, err = c.fileSystem.GetXattr(f.name, name); err != nil {
+		return err
+	}
+	c.fids[newID] = &fid{name: f.name, qid: f.qid, xattr: value, isXattr: true, opened: true}
+	r.u64(uint64(len(value)))
+	return nil
+}
+
+func (c *conn) clunk(id uint32) error {
+	f, err := c.fid(id)
+	if err != nil {
+		return err
+	}
+	delete(c.fids, id)
+	if f.file != nil {
+		return f.file.Close()
+	}
+	return nil
+}
+
+func (c *conn) clunkAll() {
+	for id := range c.fids {
+		c.clunk(id)
+	}
+}
+
+func (c *conn) fid(id uint32) (*fid, error) {
+	f, ok := c.fids[id]
+	if !ok {
+		return nil, errno(eBADF)
+	}
+	return f, nil
+}
+
+// limit caps the count of a Tread or Treaddir to what fits in msize
+func (c *conn) limit(count uint32) uint32 {
+	if max := c.msize - headerLen - 4; count > max {
+		return max
+	}
+	return count
+}
+
+func (c *conn) stat(name string) (xfs.FileInfo, error) {
+	info, err := c.fileSystem.Lstat(name)
+	if err != nil {
+		return xfs.FileInfo{}, err
+	}
+	return info.(xfs.FileInfo), nil
+}
+
+func (c *conn) qid(name string) (qid, error) {
+	info, err := c.stat(name)
+	if err != nil {
+		return qid{}, err
+	}
+	return newQid(info), nil
+}
+
+func newQid(info xfs.FileInfo) qid {
+	q := qid{typ: qtFile, path: info.Ino()}
+	switch info.Mode().Type() {
+	case fs.ModeDir:
+		q.typ = qtDir
+	case fs.ModeSymlink:
+		q.typ = qtSymlink
+	}
+	return q
+}
+
+// direntType returns the DT_ type of readdir
+func direntType(mode fs.FileMode) uint8 {
+	switch mode.Type() {
+	case fs.ModeDir:
+		return 4
+	case fs.ModeSymlink:
+		return 10
+	case fs.ModeNamedPipe:
+		return 1
+	case fs.ModeSocket:
+		return 12
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return 2
+	case fs.ModeDevice:
+		return 6
+	}
+	return 8
+}
+
+// toErrno returns the Linux errno of err, corrupted or unsupported
+// structures are I/O errors
+func toErrno(err error) errno {
+	var e errno
+	switch {
+	case xerrors.As(err, &e):
+		return e
+	case xerrors.Is(err, fs.ErrNotExist):
+		return eNOENT
+	case xerrors.Is(err, xfs.ErrNoAttribute):
+		return eNODATA
+	}
+	return eIO
+}
@@ -0,0 +1,236 @@
+package xfs9p
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// client sends one request at a time and returns the decoder of the reply
+type client struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func (c *client) call(typ uint8, fields func(e *encoder)) (uint8, *decoder) {
+	c.t.Helper()
+	e := encoder{b: make([]byte, headerLen)}
+	fields(&e)
+	binary.LittleEndian.PutUint32(e.b, uint32(len(e.b)))
+	e.b[4] = typ
+	binary.LittleEndian.PutUint16(e.b[5:], 1)
+	if _, err := c.conn.Write(e.b); err != nil {
+		c.t.Fatal(err)
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]byte, binary.LittleEndian.Uint32(header)-headerLen)
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		c.t.Fatal(err)
+	}
+	return header[4], &decoder{b: body}
+}
+
+// ok sends a request that must succeed
+func (c *client) ok(typ uint8, fields func(e *encoder)) *decoder {
+	c.t.Helper()
+	rtyp, d := c.call(typ, fields)
+	if rtyp == rlerror {
+		c.t.Fatalf("request %d failed: errno %d", typ, d.u32())
+	}
+	if rtyp != typ+1 {
+		c.t.Fatalf("request %d: unexpected reply %d", typ, rtyp)
+	}
+	return d
+}
+
+// fail sends a request that must fail with expected
+func (c *client) fail(typ uint8, expected errno, fields func(e *encoder)) {
+	c.t.Helper()
+	rtyp, d := c.call(typ, fields)
+	if rtyp != rlerror {
+		c.t.Fatalf("request %d: expected errno %d, got reply %d", typ, expected, rtyp)
+	}
+	if e := errno(d.u32()); e != expected {
+		c.t.Fatalf("request %d: expected errno %d, got %d", typ, expected, e)
+	}
+}
+
+func (c *client) walk(fid, newfid uint32, names ...string) {
+	c.t.Helper()
+	d := c.ok(twalk, func(e *encoder) {
+		e.u32(fid)
+		e.u32(newfid)
+		e.u16(uint16(len(names)))
+		for _, name := range names {
+			e.str(name)
+		}
+	})
+	if n := d.u16(); int(n) != len(names) {
+		c.t.Fatalf("walk %v: expected %d qids, got %d", names, len(names), n)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go ServeConn(server, fileSystem)
+	c := &client{t: t, conn: conn}
+
+	d := c.ok(tversion, func(e *encoder) {
+		e.u32(8192)
+		e.str("9P2000.L")
+	})
+	if msize, v := d.u32(), d.str(); msize != 8192 || v != version {
+		t.Fatalf("unexpected version %d %q", msize, v)
+	}
+
+	d = c.ok(tattach, func(e *encoder) {
+		e.u32(0)
+		e.u32(^uint32(0))
+		e.str("root")
+		e.str("")
+	})
+	if typ := d.next(1)[0]; typ != qtDir {
+		t.Fatalf("root is not a directory: %#x", typ)
+	}
+
+	t.Run("readdir", func(t *testing.T) {
+		c.t = t
+		c.walk(0, 1, "etc")
+		c.ok(tlopen, func(e *encoder) {
+			e.u32(1)
+			e.u32(0)
+		})
+		d := c.ok(treaddir, func(e *encoder) {
+			e.u32(1)
+			e.u64(0)
+			e.u32(4096)
+		})
+		entries := &decoder{b: d.next(int(d.u32()))}
+		var names []string
+		for len(entries.b) > 0 {
+			entries.next(13)
+			entries.u64()
+			entries.next(1)
+			names = append(names, entries.str())
+		}
+		if !reflect.DeepEqual(names, []string{".", "..", "os-release"}) {
+			t.Fatalf("unexpected entries %v", names)
+		}
+		c.ok(tclunk, func(e *encoder) { e.u32(1) })
+	})
+
+	t.Run("read", func(t *testing.T) {
+		c.t = t
+		c.walk(0, 2, "etc", "os-release")
+		d := c.ok(tgetattr, func(e *encoder) {
+			e.u32(2)
+			e.u64(getattrBasic)
+		})
+		d.u64()
+		d.next(5)
+		if ino := d.u64(); ino != 20453 {
+			t.Fatalf("unexpected inode %d", ino)
+		}
+		d.next(4 + 4 + 4 + 8 + 8)
+		if size := d.u64(); size != uint64(len(expected)) {
+			t.Fatalf("unexpected size %d", size)
+		}
+
+		c.fail(tlopen, eROFS, func(e *encoder) {
+			e.u32(2)
+			e.u32(2)
+		})
+		c.ok(tlopen, func(e *encoder) {
+			e.u32(2)
+			e.u32(0)
+		})
+		var data []byte
+		for {
+			d := c.ok(tread, func(e *encoder) {
+				e.u32(2)
+				e.u64(uint64(len(data)))
+				e.u32(100)
+			})
+			n := d.u32()
+			if n == 0 {
+				break
+			}
+			data = append(data, d.next(int(n))...)
+		}
+		if string(data) != string(expected) {
+			t.Fatalf("unexpected content %q", data)
+		}
+		c.ok(tclunk, func(e *encoder) { e.u32(2) })
+	})
+
+	t.Run("xattr", func(t *testing.T) {
+		c.t = t
+		c.walk(0, 3, "etc", "os-release")
+		d := c.ok(txattrwalk, func(e *encoder) {
+			e.u32(3)
+			e.u32(4)
+			e.str("security.selinux")
+		})
+		size := d.u64()
+		d = c.ok(tread, func(e *encoder) {
+			e.u32(4)
+			e.u64(0)
+			e.u32(uint32(size))
+		})
+		if v := string(d.next(int(d.u32()))); v != "unconfined_u:object_r:unlabeled_t:s0\x00" {
+			t.Fatalf("unexpected xattr %q", v)
+		}
+		c.fail(txattrwalk, eNODATA, func(e *encoder) {
+			e.u32(3)
+			e.u32(5)
+			e.str("user.missing")
+		})
+		c.ok(tclunk, func(e *encoder) { e.u32(4) })
+		c.ok(tclunk, func(e *encoder) { e.u32(3) })
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c.t = t
+		c.fail(twalk, eNOENT, func(e *encoder) {
+			e.u32(0)
+			e.u32(6)
+			e.u16(1)
+			e.str("missing")
+		})
+		c.fail(tmkdir, eROFS, func(e *encoder) {
+			e.u32(0)
+			e.str("new")
+			e.u32(0o755)
+			e.u32(0)
+		})
+		c.fail(tclunk, eBADF, func(e *encoder) { e.u32(6) })
+	})
+}
@@ -0,0 +1,18 @@
+module github.com/masahiro331/go-xfs-filesystem/xfsafero
+
+go 1.18
+
+require (
+	github.com/masahiro331/go-xfs-filesystem v0.0.0-00010101000000-000000000000
+	github.com/spf13/afero v1.10.0
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.23.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
+
+replace github.com/masahiro331/go-xfs-filesystem => ../
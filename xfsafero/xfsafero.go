@@ -0,0 +1,267 @@
+// Package xfsafero exposes an xfs.FileSystem as a read-only afero.Fs.
+package xfsafero
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// Fs is a read-only afero.Fs of an image, writes fail with syscall.EPERM like
+// afero.ReadOnlyFs. Paths are relative to the root of the image, a leading
+// slash is ignored.
+type Fs struct {
+	fileSystem *xfs.FileSystem
+}
+
+var (
+	_ afero.Fs         = &Fs{}
+	_ afero.Lstater    = &Fs{}
+	_ afero.LinkReader = &Fs{}
+)
+
+// New returns the afero.Fs of fileSystem
+func New(fileSystem *xfs.FileSystem) *Fs {
+	return &Fs{fileSystem: fileSystem}
+}
+
+// name returns the path in the image of the afero path filename
+func (*Fs) name(filename string) string {
+	name := strings.Trim(path.Clean("/"+filepath.ToSlash(filename)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (*Fs) Name() string { return "xfs" }
+
+func (a *Fs) Open(name string) (afero.File, error) {
+	resolved, err := a.fileSystem.EvalSymlinks(a.name(name))
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	f, err := a.fileSystem.Open(resolved)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, pathError("open", name, err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return &dir{fs: a, name: name, resolved: resolved, info: info}, nil
+	}
+	return &file{name: name, f: f.(*xfs.File)}, nil
+}
+
+func (a *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, syscall.EPERM
+	}
+	return a.Open(name)
+}
+
+func (a *Fs) Stat(name string) (os.FileInfo, error) {
+	info, err := a.fileSystem.Stat(a.name(name))
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// LstatIfPossible returns the symlink itself, it is always possible
+func (a *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	info, err := a.fileSystem.Lstat(a.name(name))
+	if err != nil {
+		return nil, true, pathError("lstat", name, err)
+	}
+	return info, true, nil
+}
+
+func (a *Fs) ReadlinkIfPossible(name string) (string, error) {
+	target, err := a.fileSystem.ReadLink(a.name(name))
+	if err != nil {
+		return "", pathError("readlink", name, err)
+	}
+	return target, nil
+}
+
+func (*Fs) Create(name string) (afero.File, error)                      { return nil, syscall.EPERM }
+func (*Fs) Mkdir(name string, perm os.FileMode) error                   { return syscall.EPERM }
+func (*Fs) MkdirAll(path string, perm os.FileMode) error                { return syscall.EPERM }
+func (*Fs) Remove(name string) error                                    { return syscall.EPERM }
+func (*Fs) RemoveAll(path string) error                                 { return syscall.EPERM }
+func (*Fs) Rename(oldname, newname string) error                        { return syscall.EPERM }
+func (*Fs) Chmod(name string, mode os.FileMode) error                   { return syscall.EPERM }
+func (*Fs) Chown(name string, uid, gid int) error                       { return syscall.EPERM }
+func (*Fs) Chtimes(name string, atime time.Time, mtime time.Time) error { return syscall.EPERM }
+
+// pathError returns err as a *fs.PathError, missing files are reported
+// with fs.ErrNotExist itself as os.IsNotExist does not unwrap
+func pathError(op, name string, err error) error {
+	if xerrors.Is(err, fs.ErrNotExist) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// readOnly holds the write methods of afero.File
+type readOnly struct{}
+
+func (readOnly) Write(p []byte) (int, error)              { return 0, syscall.EPERM }
+func (readOnly) WriteAt(p []byte, off int64) (int, error) { return 0, syscall.EPERM }
+func (readOnly) WriteString(s string) (int, error)        { return 0, syscall.EPERM }
+func (readOnly) Truncate(size int64) error                { return syscall.EPERM }
+func (readOnly) Sync() error                              { return nil }
+
+// file is an open regular file, ReadAt calls share the offset of f
+type file struct {
+	readOnly
+	name string
+	mu   sync.Mutex
+	f    *xfs.File
+}
+
+var _ afero.File = &file{}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Stat() (os.FileInfo, error) { return f.f.Stat() }
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cur, err := f.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer f.f.Seek(cur, io.SeekStart)
+	if _, err := f.f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Seek(offset, whence)
+}
+
+func (f *file) Close() error { return f.f.Close() }
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+func (f *file) Readdirnames(n int) ([]string, error) {
+	return nil, &fs.PathError{Op: "readdirnames", Path: f.name, Err: syscall.ENOTDIR}
+}
+
+// dir is an open directory, its entries are read at the first Readdir and
+// returned in order like os.File.Readdir
+type dir struct {
+	readOnly
+	fs       *Fs
+	name     string
+	resolved string
+	info     os.FileInfo
+
+	mu      sync.Mutex
+	entries []os.FileInfo
+	read    bool
+}
+
+var _ afero.File = &dir{}
+
+func (d *dir) Name() string { return d.name }
+
+func (d *dir) Stat() (os.FileInfo, error) { return d.info, nil }
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *dir) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+// Seek to the start rewinds Readdir
+func (d *dir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, &fs.PathError{Op: "seek", Path: d.name, Err: syscall.EINVAL}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries, d.read = nil, false
+	return 0, nil
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.read {
+		entries, err := d.fs.fileSystem.ReadDir(d.resolved)
+		if err != nil {
+			return nil, pathError("readdir", d.name, err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, pathError("readdir", d.name, err)
+			}
+			d.entries = append(d.entries, info)
+		}
+		d.read = true
+	}
+
+	if count <= 0 {
+		infos := d.entries
+		d.entries = nil
+		return infos, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	infos := d.entries[:count]
+	d.entries = d.entries[count:]
+	return infos, nil
+}
+
+func (d *dir) Readdirnames(n int) ([]string, error) {
+	infos, err := d.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
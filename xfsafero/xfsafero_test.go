@@ -0,0 +1,92 @@
+package xfsafero_test
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsafero"
+)
+
+func TestFs(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := xfsafero.New(fileSystem)
+
+	data, err := afero.ReadFile(a, "/etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expected) {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	infos, err := afero.ReadDir(a, "/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "os-release" {
+		t.Fatalf("unexpected entries %v", infos)
+	}
+
+	d, err := a.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	first, err := d.Readdirnames(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := d.Readdirnames(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := afero.ReadDir(a, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var allNames []string
+	for _, info := range all {
+		allNames = append(allNames, info.Name())
+	}
+	// afero.ReadDir sorts the entries, Readdir returns them in directory order
+	names := append(first, rest...)
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, allNames) {
+		t.Fatalf("unexpected names %v %v, expected %v", first, rest, allNames)
+	}
+	if _, err := d.Readdirnames(1); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	if _, err := a.Stat("missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	if _, err := a.Create("new"); err == nil {
+		t.Fatal("expected Create to fail")
+	}
+	if err := afero.WriteFile(a, "etc/os-release", nil, 0o644); err == nil {
+		t.Fatal("expected WriteFile to fail")
+	}
+}
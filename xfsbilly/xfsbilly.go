@@ -0,0 +1,196 @@
+// Package xfsbilly exposes an xfs.FileSystem as a read-only billy.Filesystem,
+// for go-git and other billy based tools.
+package xfsbilly
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// Filesystem is a read-only billy.Filesystem of an image, writes fail with
+// billy.ErrReadOnly. root is the path of the directory it is chrooted to.
+type Filesystem struct {
+	fileSystem *xfs.FileSystem
+	root       string
+}
+
+var (
+	_ billy.Filesystem = &Filesystem{}
+	_ billy.Capable    = &Filesystem{}
+)
+
+// New returns the billy.Filesystem of fileSystem, rooted at the root of the
+// image
+func New(fileSystem *xfs.FileSystem) *Filesystem {
+	return &Filesystem{fileSystem: fileSystem}
+}
+
+// name returns the path in the image of the billy path filename
+func (b *Filesystem) name(filename string) string {
+	name := strings.Trim(path.Join(b.root, filename), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (b *Filesystem) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.SeekCapability
+}
+
+func (b *Filesystem) Create(filename string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (b *Filesystem) Open(filename string) (billy.File, error) {
+	return b.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (b *Filesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+	resolved, err := b.fileSystem.EvalSymlinks(b.name(filename))
+	if err != nil {
+		return nil, pathError("open", filename, err)
+	}
+	f, err := b.fileSystem.Open(resolved)
+	if err != nil {
+		return nil, pathError("open", filename, err)
+	}
+	xf, ok := f.(*xfs.File)
+	if !ok {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: xerrors.New("is a directory")}
+	}
+	return &file{name: filename, f: xf}, nil
+}
+
+func (b *Filesystem) Stat(filename string) (os.FileInfo, error) {
+	info, err := b.fileSystem.Stat(b.name(filename))
+	if err != nil {
+		return nil, pathError("stat", filename, err)
+	}
+	return info, nil
+}
+
+func (b *Filesystem) Lstat(filename string) (os.FileInfo, error) {
+	info, err := b.fileSystem.Lstat(b.name(filename))
+	if err != nil {
+		return nil, pathError("lstat", filename, err)
+	}
+	return info, nil
+}
+
+func (b *Filesystem) ReadDir(dirname string) ([]os.FileInfo, error) {
+	entries, err := b.fileSystem.ReadDir(b.name(dirname))
+	if err != nil {
+		return nil, pathError("readdir", dirname, err)
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, pathError("readdir", dirname, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (b *Filesystem) Readlink(link string) (string, error) {
+	target, err := b.fileSystem.ReadLink(b.name(link))
+	if err != nil {
+		return "", pathError("readlink", link, err)
+	}
+	return target, nil
+}
+
+func (b *Filesystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (b *Filesystem) Chroot(p string) (billy.Filesystem, error) {
+	return &Filesystem{fileSystem: b.fileSystem, root: path.Join("/", b.root, p)}, nil
+}
+
+func (b *Filesystem) Root() string {
+	if b.root == "" {
+		return "/"
+	}
+	return b.root
+}
+
+func (b *Filesystem) Rename(oldpath, newpath string) error             { return billy.ErrReadOnly }
+func (b *Filesystem) Remove(filename string) error                     { return billy.ErrReadOnly }
+func (b *Filesystem) TempFile(dir, prefix string) (billy.File, error)  { return nil, billy.ErrReadOnly }
+func (b *Filesystem) MkdirAll(filename string, perm os.FileMode) error { return billy.ErrReadOnly }
+func (b *Filesystem) Symlink(target, link string) error                { return billy.ErrReadOnly }
+
+// pathError returns err as a *fs.PathError, missing files are reported
+// with fs.ErrNotExist itself as os.IsNotExist does not unwrap
+func pathError(op, name string, err error) error {
+	if xerrors.Is(err, fs.ErrNotExist) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// file is an open regular file, ReadAt calls share the offset of f
+type file struct {
+	name string
+	mu   sync.Mutex
+	f    *xfs.File
+}
+
+var _ billy.File = &file{}
+
+func (f *file) Name() string { return f.name }
+
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Read(p)
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cur, err := f.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer f.f.Seek(cur, io.SeekStart)
+	if _, err := f.f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Seek(offset, whence)
+}
+
+func (f *file) Close() error {
+	return f.f.Close()
+}
+
+func (f *file) Write(p []byte) (int, error) { return 0, billy.ErrReadOnly }
+func (f *file) Truncate(size int64) error   { return billy.ErrReadOnly }
+func (f *file) Lock() error                 { return nil }
+func (f *file) Unlock() error               { return nil }
@@ -0,0 +1,74 @@
+package xfsbilly_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsbilly"
+)
+
+func TestFilesystem(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := xfsbilly.New(fileSystem)
+
+	data, err := util.ReadFile(b, "/etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expected) {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	etc, err := b.Chroot("etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := etc.Root(); root != "/etc" {
+		t.Fatalf("unexpected root %q", root)
+	}
+	infos, err := etc.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "os-release" {
+		t.Fatalf("unexpected entries %v", infos)
+	}
+	data, err = util.ReadFile(etc, "os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expected) {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	if _, err := b.Stat("missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	if _, err := b.Create("new"); err != billy.ErrReadOnly {
+		t.Fatalf("expected billy.ErrReadOnly, got %v", err)
+	}
+	if _, err := b.OpenFile("etc/os-release", os.O_RDWR, 0); err != billy.ErrReadOnly {
+		t.Fatalf("expected billy.ErrReadOnly, got %v", err)
+	}
+}
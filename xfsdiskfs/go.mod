@@ -0,0 +1,24 @@
+module github.com/masahiro331/go-xfs-filesystem/xfsdiskfs
+
+go 1.18
+
+require (
+	github.com/diskfs/go-diskfs v1.3.0
+	github.com/masahiro331/go-xfs-filesystem v0.0.0-00010101000000-000000000000
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+)
+
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/pierrec/lz4 v2.3.0+incompatible // indirect
+	github.com/pkg/xattr v0.4.1 // indirect
+	github.com/sirupsen/logrus v1.7.0 // indirect
+	github.com/ulikunitz/xz v0.5.10 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.23.0 // indirect
+	golang.org/x/sys v0.24.0 // indirect
+	gopkg.in/djherbis/times.v1 v1.2.0 // indirect
+)
+
+replace github.com/masahiro331/go-xfs-filesystem => ../
@@ -0,0 +1,144 @@
+// Package xfsdiskfs implements the filesystem.FileSystem interface of
+// go-diskfs on top of an xfs.FileSystem, so its users read XFS partitions as
+// they read FAT32, ISO 9660 and squashfs ones. The file system is read-only.
+package xfsdiskfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// TypeXFS is the filesystem.Type of FileSystem, go-diskfs has none for XFS
+const TypeXFS filesystem.Type = 0x58465342 // "XFSB"
+
+// FileSystem implements filesystem.FileSystem, the paths are absolute as in
+// go-diskfs
+type FileSystem struct {
+	fs *xfs.FileSystem
+}
+
+var _ filesystem.FileSystem = &FileSystem{}
+
+// New returns the filesystem.FileSystem of fileSystem
+func New(fileSystem *xfs.FileSystem) *FileSystem {
+	return &FileSystem{fs: fileSystem}
+}
+
+// Read opens the XFS of size bytes at start of file, as fat32.Read and
+// squashfs.Read do
+func Read(file io.ReaderAt, size, start int64) (*FileSystem, error) {
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(file, start, size), nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(fileSystem), nil
+}
+
+// GetFilesystem returns the file system of partition part of d, 0 for the
+// whole disk, as d.GetFilesystem does with XFS among the file systems tried
+func GetFilesystem(d *disk.Disk, part int) (filesystem.FileSystem, error) {
+	fileSystem, err := d.GetFilesystem(part)
+	if err == nil {
+		return fileSystem, nil
+	}
+	start, size := int64(0), d.Size
+	if part != 0 {
+		if d.Table == nil {
+			return nil, err
+		}
+		partitions := d.Table.GetPartitions()
+		if part < 0 || part > len(partitions) {
+			return nil, err
+		}
+		start, size = partitions[part-1].GetStart(), partitions[part-1].GetSize()
+	}
+	if !xfs.Check(io.NewSectionReader(d.File, start, size)) {
+		return nil, err
+	}
+	return Read(d.File, size, start)
+}
+
+// fsPath converts an absolute go-diskfs path to an io/fs path
+func fsPath(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// Type implements filesystem.FileSystem
+func (f *FileSystem) Type() filesystem.Type {
+	return TypeXFS
+}
+
+// Mkdir implements filesystem.FileSystem, it returns xfs.ErrReadOnly
+func (f *FileSystem) Mkdir(name string) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: xfs.ErrReadOnly}
+}
+
+// ReadDir implements filesystem.FileSystem
+func (f *FileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := f.fs.ReadDir(fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// OpenFile implements filesystem.FileSystem, flags other than os.O_RDONLY
+// return xfs.ErrReadOnly
+func (f *FileSystem) OpenFile(name string, flag int) (filesystem.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: xfs.ErrReadOnly}
+	}
+	opened, err := f.fs.Open(fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	r, ok := opened.(*xfs.File)
+	if !ok {
+		opened.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: xerrors.New("is a directory")}
+	}
+	return &file{File: r}, nil
+}
+
+// Label implements filesystem.FileSystem, it returns the label of the
+// superblock
+func (f *FileSystem) Label() string {
+	label := f.fs.PrimaryAG.SuperBlock.Fname
+	return string(bytes.TrimRight(label[:], "\x00"))
+}
+
+// SetLabel implements filesystem.FileSystem, it returns xfs.ErrReadOnly
+func (f *FileSystem) SetLabel(string) error {
+	return xfs.ErrReadOnly
+}
+
+// file implements filesystem.File, Write returns xfs.ErrReadOnly
+type file struct {
+	*xfs.File
+}
+
+// Write implements io.Writer, it returns xfs.ErrReadOnly
+func (f *file) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.Name(), Err: xfs.ErrReadOnly}
+}
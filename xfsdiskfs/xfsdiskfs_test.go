@@ -0,0 +1,128 @@
+package xfsdiskfs_test
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsdiskfs"
+)
+
+func TestGetFilesystem(t *testing.T) {
+	img, err := os.ReadFile("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("../xfs/testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a whole disk image with the XFS in an MBR partition at 1 MiB
+	const start = 2048
+	disk := make([]byte, start*512+len(img))
+	copy(disk[start*512:], img)
+	disk[446+4] = 0x83
+	binary.LittleEndian.PutUint32(disk[446+8:], start)
+	binary.LittleEndian.PutUint32(disk[446+12:], uint32(len(img)/512))
+	binary.LittleEndian.PutUint16(disk[510:], 0xaa55)
+	name := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(name, disk, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := diskfs.Open(name, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.File.Close()
+	fileSystem, err := xfsdiskfs.GetFilesystem(d, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileSystem.Type() != xfsdiskfs.TypeXFS {
+		t.Errorf("unexpected type %d", fileSystem.Type())
+	}
+
+	infos, err := fileSystem.ReadDir("/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "os-release" || infos[0].Size() != int64(len(expected)) {
+		t.Errorf("unexpected entries %v", infos)
+	}
+	infos, err = fileSystem.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if i := sort.SearchStrings(names, "parent"); i == len(names) || names[i] != "parent" {
+		t.Errorf("parent is missing from %v", names)
+	}
+
+	f, err := fileSystem.OpenFile("/etc/os-release", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(expected[5:]) {
+		t.Errorf("unexpected content %q", data)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("expected an error writing")
+	}
+	f.Close()
+
+	for name, err := range map[string]error{
+		"open for writing": openErr(fileSystem, "/etc/os-release", os.O_RDWR),
+		"open a directory": openErr(fileSystem, "/etc", os.O_RDONLY),
+		"open missing":     openErr(fileSystem, "/etc/missing", os.O_RDONLY),
+		"mkdir":            fileSystem.Mkdir("/new"),
+		"set label":        fileSystem.SetLabel("new"),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := xfsdiskfs.GetFilesystem(d, 0); err == nil {
+		t.Error("expected an error for the whole disk")
+	}
+
+	r, err := xfsdiskfs.Read(d.File, int64(len(img)), start*512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := xfs.NewFS(*io.NewSectionReader(d.File, start*512, int64(len(img))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	label := raw.PrimaryAG.SuperBlock.Fname
+	if r.Label() != string(label[:len(r.Label())]) {
+		t.Errorf("unexpected label %q", r.Label())
+	}
+}
+
+func openErr(fileSystem filesystem.FileSystem, name string, flag int) error {
+	f, err := fileSystem.OpenFile(name, flag)
+	if err == nil {
+		f.Close()
+	}
+	return err
+}
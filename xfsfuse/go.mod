@@ -0,0 +1,18 @@
+module github.com/masahiro331/go-xfs-filesystem/xfsfuse
+
+go 1.18
+
+require (
+	github.com/hanwen/go-fuse/v2 v2.7.2
+	github.com/masahiro331/go-xfs-filesystem v0.0.0-00010101000000-000000000000
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.23.0 // indirect
+	golang.org/x/sys v0.24.0 // indirect
+)
+
+replace github.com/masahiro331/go-xfs-filesystem => ../
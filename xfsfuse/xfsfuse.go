@@ -0,0 +1,224 @@
+//go:build linux || darwin
+
+// Package xfsfuse serves an xfs.FileSystem read-only over FUSE with go-fuse.
+package xfsfuse
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// Mount mounts fileSystem read-only on mountpoint, the returned server
+// serves requests until it is unmounted. Root mounts without fusermount.
+func Mount(fileSystem *xfs.FileSystem, mountpoint string, opts fuse.MountOptions) (*fuse.Server, error) {
+	opts.Options = append(opts.Options, "ro")
+	opts.DirectMount = true
+	return fs.Mount(mountpoint, NewRoot(fileSystem), &fs.Options{
+		MountOptions:   opts,
+		RootStableAttr: RootStableAttr(fileSystem),
+	})
+}
+
+// NewRoot returns the root directory of fileSystem, for fs.Mount or
+// fs.NewNodeFS
+func NewRoot(fileSystem *xfs.FileSystem) fs.InodeEmbedder {
+	return &node{fileSystem: fileSystem, name: "."}
+}
+
+// RootStableAttr returns the attributes of the root directory, so it keeps
+// its inode number
+func RootStableAttr(fileSystem *xfs.FileSystem) *fs.StableAttr {
+	return &fs.StableAttr{Mode: syscall.S_IFDIR, Ino: fileSystem.PrimaryAG.SuperBlock.Rootino}
+}
+
+// node is a file of the image, name is the path of the first link it was
+// looked up by
+type node struct {
+	fs.Inode
+	fileSystem *xfs.FileSystem
+	name       string
+}
+
+var (
+	_ fs.NodeLookuper    = (*node)(nil)
+	_ fs.NodeGetattrer   = (*node)(nil)
+	_ fs.NodeReaddirer   = (*node)(nil)
+	_ fs.NodeOpener      = (*node)(nil)
+	_ fs.NodeReadlinker  = (*node)(nil)
+	_ fs.NodeGetxattrer  = (*node)(nil)
+	_ fs.NodeListxattrer = (*node)(nil)
+)
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child := path.Join(n.name, name)
+	info, err := n.fileSystem.Lstat(child)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	fi := fileInfo(info)
+	fillAttr(fi, &out.Attr, n.fileSystem.Info().BlockSize)
+	stable := fs.StableAttr{Mode: out.Attr.Mode & syscall.S_IFMT, Ino: fi.Ino()}
+	return n.NewInode(ctx, &node{fileSystem: n.fileSystem, name: child}, stable), 0
+}
+
+func (n *node) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	info, err := n.fileSystem.Lstat(n.name)
+	if err != nil {
+		return toErrno(err)
+	}
+	fillAttr(fileInfo(info), &out.Attr, n.fileSystem.Info().BlockSize)
+	return 0
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries, err := n.fileSystem.ReadDir(n.name)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	list := make([]fuse.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, toErrno(err)
+		}
+		fi := fileInfo(info)
+		list = append(list, fuse.DirEntry{
+			Name: entry.Name(),
+			Mode: uint32(fi.Sys().(*xfs.InodeCore).Mode) & syscall.S_IFMT,
+			Ino:  fi.Ino(),
+		})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	f, err := n.fileSystem.Open(n.name)
+	if err != nil {
+		return nil, 0, toErrno(err)
+	}
+	xf, ok := f.(*xfs.File)
+	if !ok {
+		f.Close()
+		return nil, 0, syscall.EISDIR
+	}
+	// the image does not change, the page cache stays valid
+	return &handle{f: xf}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *node) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := n.fileSystem.ReadLink(n.name)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	return []byte(target), 0
+}
+
+func (n *node) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
+	value, err := n.fileSystem.GetXattr(n.name, attr)
+	if err != nil {
+		return 0, toErrno(err)
+	}
+	return copyXattr(dest, value)
+}
+
+func (n *node) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
+	xattrs, err := n.fileSystem.ListXattrs(n.name)
+	if err != nil {
+		return 0, toErrno(err)
+	}
+	var names []byte
+	for _, x := range xattrs {
+		names = append(append(names, x.Name...), 0)
+	}
+	return copyXattr(dest, names)
+}
+
+// copyXattr copies b to dest, an empty dest asks for the size only
+func copyXattr(dest, b []byte) (uint32, syscall.Errno) {
+	if len(dest) == 0 {
+		return uint32(len(b)), 0
+	}
+	if len(dest) < len(b) {
+		return uint32(len(b)), syscall.ERANGE
+	}
+	return uint32(copy(dest, b)), 0
+}
+
+// handle is an open regular file, reads of a file handle may come in
+// concurrently and share its offset
+type handle struct {
+	mu sync.Mutex
+	f  *xfs.File
+}
+
+var (
+	_ fs.FileReader   = (*handle)(nil)
+	_ fs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.f.Seek(off, io.SeekStart); err != nil {
+		return nil, toErrno(err)
+	}
+	n, err := io.ReadFull(h.f, dest)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, toErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	return toErrno(h.f.Close())
+}
+
+// fillAttr fills out with the inode core of info
+func fillAttr(info xfs.FileInfo, out *fuse.Attr, blockSize uint32) {
+	core := info.Sys().(*xfs.InodeCore)
+	out.Ino = info.Ino()
+	out.Mode = uint32(core.Mode)
+	out.Nlink = core.NLink
+	out.Owner = fuse.Owner{Uid: core.UID, Gid: core.GID}
+	out.Size = uint64(info.Size())
+	out.Blocks = core.Nblocks * uint64(blockSize) / 512
+	out.Blksize = blockSize
+	atime, mtime, ctime := info.AccessTime(), info.ModTime(), info.ChangeTime()
+	out.SetTimes(&atime, &mtime, &ctime)
+}
+
+// toErrno maps the errors of the image to the errno returned to the kernel,
+// corrupted or unsupported structures are I/O errors
+func toErrno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case xerrors.Is(err, iofs.ErrNotExist):
+		return syscall.ENOENT
+	case xerrors.Is(err, xfs.ErrNoAttribute):
+		return fs.ENOATTR
+	}
+	return syscall.EIO
+}
+
+// fileInfo returns the xfs.FileInfo behind info, Stat of the root returns a
+// *xfs.FileInfo
+func fileInfo(info iofs.FileInfo) xfs.FileInfo {
+	if i, ok := info.(*xfs.FileInfo); ok {
+		return *i
+	}
+	return info.(xfs.FileInfo)
+}
@@ -0,0 +1,102 @@
+package xfsfuse_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsfuse"
+)
+
+// TestMount mounts the test image with FUSE and compares the mounted files
+// with the library. It needs root and is enabled with XFS_FUSE_TEST=1.
+func TestMount(t *testing.T) {
+	if os.Getenv("XFS_FUSE_TEST") == "" {
+		t.Skip("set XFS_FUSE_TEST=1 to mount with FUSE")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("mounting without fusermount needs root")
+	}
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt := t.TempDir()
+	server, err := xfsfuse.Mount(img, mnt, fuse.MountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Unmount()
+
+	err = fs.WalkDir(img, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		local := filepath.Join(mnt, filepath.FromSlash(name))
+		mounted, err := os.Lstat(local)
+		if err != nil {
+			return err
+		}
+		if mounted.Mode().Type() != info.Mode().Type() || mounted.Mode().Perm() != info.Mode().Perm() || !mounted.ModTime().Equal(info.ModTime()) {
+			t.Errorf("%s: expected %v %v, actual %v %v", name, info.Mode(), info.ModTime(), mounted.Mode(), mounted.ModTime())
+		}
+		if ino, expected := mounted.Sys().(*syscall.Stat_t).Ino, info.(interface{ Ino() uint64 }).Ino(); ino != expected {
+			t.Errorf("%s: expected inode %d, actual %d", name, expected, ino)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		expected, err := img.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		actual, err := os.ReadFile(local)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s: content differs", name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := getxattr(filepath.Join(mnt, "etc", "os-release"), "security.selinux")
+	if err != nil || value != "unconfined_u:object_r:unlabeled_t:s0\x00" {
+		t.Errorf("expected the selinux label, actual %q: %v", value, err)
+	}
+	if err := os.WriteFile(filepath.Join(mnt, "new"), nil, 0o644); !os.IsPermission(err) && err.(*fs.PathError).Err != syscall.EROFS {
+		t.Errorf("expected EROFS, actual %v", err)
+	}
+}
+
+func getxattr(name, attr string) (string, error) {
+	b := make([]byte, 256)
+	n, err := syscall.Getxattr(name, attr, b)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
@@ -0,0 +1,223 @@
+package xfsgrpc
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfsgrpc/xfspb"
+)
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// FS is an image of a Server as an io/fs file system, every call is a
+// request bound to the context of the FS
+type FS struct {
+	ctx    context.Context
+	client xfspb.FileSystemClient
+	image  string
+}
+
+// NewFS returns the image named image of the server at the end of conn
+func NewFS(ctx context.Context, conn grpc.ClientConnInterface, image string) *FS {
+	return &FS{ctx: ctx, client: xfspb.NewFileSystemClient(conn), image: image}
+}
+
+// Stat returns the file info of name, symlinks are not followed
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := fsys.client.Stat(fsys.ctx, &xfspb.StatRequest{Image: fsys.image, Name: name})
+	if err != nil {
+		return nil, toPathError("stat", name, err)
+	}
+	return fileInfo{info}, nil
+}
+
+// ReadDir returns the entries of the directory name sorted by name
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	stream, err := fsys.client.ReadDir(fsys.ctx, &xfspb.ReadDirRequest{Image: fsys.image, Name: name})
+	if err != nil {
+		return nil, toPathError("readdir", name, err)
+	}
+	var entries []fs.DirEntry
+	for {
+		info, err := stream.Recv()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, toPathError("readdir", name, err)
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fileInfo{info}))
+	}
+}
+
+// ReadFile returns the content of the file name
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	r, err := fsys.readFile(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Open returns the file name, its content is streamed on the first Read
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := fsys.client.Stat(fsys.ctx, &xfspb.StatRequest{Image: fsys.image, Name: name})
+	if err != nil {
+		return nil, toPathError("open", name, err)
+	}
+	return &file{fsys: fsys, name: name, info: fileInfo{info}}, nil
+}
+
+// readFile returns the content of name from offset
+func (fsys *FS) readFile(name string, offset int64) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(fsys.ctx)
+	stream, err := fsys.client.ReadFile(ctx, &xfspb.ReadFileRequest{Image: fsys.image, Name: name, Offset: offset})
+	if err != nil {
+		cancel()
+		return nil, toPathError("read", name, err)
+	}
+	return &chunkReader{name: name, stream: stream, cancel: cancel}, nil
+}
+
+// chunkReader reads the chunks of a ReadFile stream
+type chunkReader struct {
+	name   string
+	stream xfspb.FileSystem_ReadFileClient
+	cancel context.CancelFunc
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, toPathError("read", r.name, err)
+		}
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// file is an open file of an FS
+type file struct {
+	fsys *FS
+	name string
+	info fileInfo
+
+	r io.ReadCloser
+	// entries are the unread entries of a directory, read on the first
+	// ReadDir
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if f.r == nil {
+		r, err := f.fsys.readFile(f.name, 0)
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+	return f.r.Read(p)
+}
+
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if !f.listed {
+		entries, err := f.fsys.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+func (f *file) Close() error {
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+// fileInfo is a file info of the server, Sys returns the *xfspb.FileInfo
+type fileInfo struct {
+	pb *xfspb.FileInfo
+}
+
+func (i fileInfo) Name() string       { return i.pb.Name }
+func (i fileInfo) Size() int64        { return i.pb.Size }
+func (i fileInfo) Mode() fs.FileMode  { return fs.FileMode(i.pb.Mode) }
+func (i fileInfo) ModTime() time.Time { return time.Unix(0, i.pb.ModTimeUnixNano) }
+func (i fileInfo) IsDir() bool        { return i.Mode().IsDir() }
+func (i fileInfo) Sys() interface{}   { return i.pb }
+
+// toPathError returns the io/fs error of a status of the server
+func toPathError(op, name string, err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		err = fs.ErrNotExist
+	case codes.InvalidArgument:
+		err = fs.ErrInvalid
+	case codes.PermissionDenied:
+		err = fs.ErrPermission
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
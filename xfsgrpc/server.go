@@ -0,0 +1,163 @@
+// Package xfsgrpc serves xfs.FileSystems over gRPC, and reads them back as an
+// io/fs file system, so a service with fast access to the image storage can
+// feed many scanners without it.
+package xfsgrpc
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sort"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsgrpc/xfspb"
+)
+
+// chunkSize is the most bytes of a ReadFile chunk
+const chunkSize = 64 << 10
+
+// Server is the FileSystem service of a set of images
+type Server struct {
+	xfspb.UnimplementedFileSystemServer
+
+	images map[string]*xfs.FileSystem
+}
+
+// NewServer returns the service of images by their names, the server does not
+// close them
+func NewServer(images map[string]*xfs.FileSystem) *Server {
+	return &Server{images: images}
+}
+
+// Register registers the service of images with s
+func Register(s grpc.ServiceRegistrar, images map[string]*xfs.FileSystem) {
+	xfspb.RegisterFileSystemServer(s, NewServer(images))
+}
+
+func (s *Server) image(name string) (*xfs.FileSystem, error) {
+	fileSystem, ok := s.images[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown image %q", name)
+	}
+	return fileSystem, nil
+}
+
+func (s *Server) Stat(_ context.Context, req *xfspb.StatRequest) (*xfspb.FileInfo, error) {
+	fileSystem, err := s.image(req.Image)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fileSystem.Lstat(req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toFileInfo(info), nil
+}
+
+func (s *Server) ReadDir(req *xfspb.ReadDirRequest, stream xfspb.FileSystem_ReadDirServer) error {
+	fileSystem, err := s.image(req.Image)
+	if err != nil {
+		return err
+	}
+	entries, err := fileSystem.ReadDir(req.Name)
+	if err != nil {
+		return toStatus(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return toStatus(err)
+		}
+		if err := stream.Send(toFileInfo(info)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) ReadFile(req *xfspb.ReadFileRequest, stream xfspb.FileSystem_ReadFileServer) error {
+	fileSystem, err := s.image(req.Image)
+	if err != nil {
+		return err
+	}
+	if req.Offset < 0 || req.Length < 0 {
+		return status.Error(codes.InvalidArgument, "negative offset or length")
+	}
+	f, err := fileSystem.Open(req.Name)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer f.Close()
+	file, ok := f.(*xfs.File)
+	if !ok {
+		return toStatus(&fs.PathError{Op: "read", Path: req.Name, Err: errIsDir})
+	}
+	if _, err := file.Seek(req.Offset, io.SeekStart); err != nil {
+		return toStatus(err)
+	}
+	var r io.Reader = file
+	if req.Length > 0 {
+		r = io.LimitReader(file, req.Length)
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := stream.Send(&xfspb.Chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+// errIsDir is the error of reading a directory as a file
+var errIsDir = xerrors.New("is a directory")
+
+func toFileInfo(info fs.FileInfo) *xfspb.FileInfo {
+	pb := &xfspb.FileInfo{
+		Name:            info.Name(),
+		Size:            info.Size(),
+		Mode:            uint32(info.Mode()),
+		ModTimeUnixNano: info.ModTime().UnixNano(),
+	}
+	if core, ok := info.Sys().(*xfs.InodeCore); ok {
+		pb.Ino = core.Ino
+		pb.Uid = core.UID
+		pb.Gid = core.GID
+		pb.Nlink = core.NLink
+	}
+	switch i := info.(type) {
+	case xfs.FileInfo:
+		pb.Ino = i.Ino()
+	case *xfs.FileInfo:
+		pb.Ino = i.Ino()
+	}
+	return pb
+}
+
+// toStatus returns the status of a file system error, the client maps the
+// codes back to the io/fs errors
+func toStatus(err error) error {
+	switch {
+	case xerrors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case xerrors.Is(err, fs.ErrInvalid), xerrors.Is(err, errIsDir):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case xerrors.Is(err, fs.ErrPermission):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
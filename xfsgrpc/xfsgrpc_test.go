@@ -0,0 +1,115 @@
+package xfsgrpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsgrpc/xfspb"
+)
+
+func TestFS(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("../xfs/testdata/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	Register(server, map[string]*xfs.FileSystem{"image": fileSystem})
+	go server.Serve(l)
+	defer server.Stop()
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fsys := NewFS(context.Background(), conn, "image")
+
+	t.Run("fstest", func(t *testing.T) {
+		if err := fstest.TestFS(fsys, "etc/os-release"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		actual, err := fs.ReadFile(fsys, "etc/os-release")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Fatalf("expected %q, actual %q", expected, actual)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		client := xfspb.NewFileSystemClient(conn)
+		stream, err := client.ReadFile(context.Background(), &xfspb.ReadFileRequest{Image: "image", Name: "etc/os-release", Offset: 5, Length: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []byte
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual = append(actual, chunk.Data...)
+		}
+		if !bytes.Equal(actual, expected[5:15]) {
+			t.Fatalf("expected %q, actual %q", expected[5:15], actual)
+		}
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		info, err := fsys.Stat("etc/os-release")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pb := info.Sys().(*xfspb.FileInfo)
+		if info.Size() != int64(len(expected)) || pb.Ino == 0 || pb.Nlink == 0 {
+			t.Fatalf("unexpected info %+v", pb)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := fsys.Stat("etc/missing"); !xerrors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist, actual %v", err)
+		}
+		if _, err := fsys.ReadFile("etc"); !xerrors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("expected fs.ErrInvalid, actual %v", err)
+		}
+		if _, err := NewFS(context.Background(), conn, "other").Stat("."); !xerrors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist, actual %v", err)
+		}
+	})
+}
@@ -0,0 +1,4 @@
+// Package xfspb holds the protocol buffers of the xfsgrpc service.
+package xfspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative xfs.proto
@@ -0,0 +1,244 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: xfs.proto
+
+package xfspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	FileSystem_Stat_FullMethodName     = "/xfs.v1.FileSystem/Stat"
+	FileSystem_ReadDir_FullMethodName  = "/xfs.v1.FileSystem/ReadDir"
+	FileSystem_ReadFile_FullMethodName = "/xfs.v1.FileSystem/ReadFile"
+)
+
+// FileSystemClient is the client API for FileSystem service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FileSystemClient interface {
+	// Stat returns the file info of a path, symlinks are not followed.
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error)
+	// ReadDir streams the entries of a directory sorted by name.
+	ReadDir(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (FileSystem_ReadDirClient, error)
+	// ReadFile streams the content of a regular file in chunks.
+	ReadFile(ctx context.Context, in *ReadFileRequest, opts ...grpc.CallOption) (FileSystem_ReadFileClient, error)
+}
+
+type fileSystemClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFileSystemClient(cc grpc.ClientConnInterface) FileSystemClient {
+	return &fileSystemClient{cc}
+}
+
+func (c *fileSystemClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, FileSystem_Stat_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileSystemClient) ReadDir(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (FileSystem_ReadDirClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileSystem_ServiceDesc.Streams[0], FileSystem_ReadDir_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileSystemReadDirClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileSystem_ReadDirClient interface {
+	Recv() (*FileInfo, error)
+	grpc.ClientStream
+}
+
+type fileSystemReadDirClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileSystemReadDirClient) Recv() (*FileInfo, error) {
+	m := new(FileInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileSystemClient) ReadFile(ctx context.Context, in *ReadFileRequest, opts ...grpc.CallOption) (FileSystem_ReadFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileSystem_ServiceDesc.Streams[1], FileSystem_ReadFile_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileSystemReadFileClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileSystem_ReadFileClient interface {
+	Recv() (*Chunk, error)
+	grpc.ClientStream
+}
+
+type fileSystemReadFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileSystemReadFileClient) Recv() (*Chunk, error) {
+	m := new(Chunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FileSystemServer is the server API for FileSystem service.
+// All implementations must embed UnimplementedFileSystemServer
+// for forward compatibility
+type FileSystemServer interface {
+	// Stat returns the file info of a path, symlinks are not followed.
+	Stat(context.Context, *StatRequest) (*FileInfo, error)
+	// ReadDir streams the entries of a directory sorted by name.
+	ReadDir(*ReadDirRequest, FileSystem_ReadDirServer) error
+	// ReadFile streams the content of a regular file in chunks.
+	ReadFile(*ReadFileRequest, FileSystem_ReadFileServer) error
+	mustEmbedUnimplementedFileSystemServer()
+}
+
+// UnimplementedFileSystemServer must be embedded to have forward compatible implementations.
+type UnimplementedFileSystemServer struct {
+}
+
+func (UnimplementedFileSystemServer) Stat(context.Context, *StatRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedFileSystemServer) ReadDir(*ReadDirRequest, FileSystem_ReadDirServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReadDir not implemented")
+}
+func (UnimplementedFileSystemServer) ReadFile(*ReadFileRequest, FileSystem_ReadFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReadFile not implemented")
+}
+func (UnimplementedFileSystemServer) mustEmbedUnimplementedFileSystemServer() {}
+
+// UnsafeFileSystemServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileSystemServer will
+// result in compilation errors.
+type UnsafeFileSystemServer interface {
+	mustEmbedUnimplementedFileSystemServer()
+}
+
+func RegisterFileSystemServer(s grpc.ServiceRegistrar, srv FileSystemServer) {
+	s.RegisterService(&FileSystem_ServiceDesc, srv)
+}
+
+func _FileSystem_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileSystemServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileSystem_Stat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileSystemServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileSystem_ReadDir_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadDirRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileSystemServer).ReadDir(m, &fileSystemReadDirServer{stream})
+}
+
+type FileSystem_ReadDirServer interface {
+	Send(*FileInfo) error
+	grpc.ServerStream
+}
+
+type fileSystemReadDirServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileSystemReadDirServer) Send(m *FileInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileSystem_ReadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadFileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileSystemServer).ReadFile(m, &fileSystemReadFileServer{stream})
+}
+
+type FileSystem_ReadFileServer interface {
+	Send(*Chunk) error
+	grpc.ServerStream
+}
+
+type fileSystemReadFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileSystemReadFileServer) Send(m *Chunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// FileSystem_ServiceDesc is the grpc.ServiceDesc for FileSystem service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FileSystem_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "xfs.v1.FileSystem",
+	HandlerType: (*FileSystemServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Stat",
+			Handler:    _FileSystem_Stat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadDir",
+			Handler:       _FileSystem_ReadDir_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReadFile",
+			Handler:       _FileSystem_ReadFile_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "xfs.proto",
+}
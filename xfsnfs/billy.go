@@ -0,0 +1,69 @@
+package xfsnfs
+
+import (
+	"io/fs"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs/file"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// nfsFS is the xfsbilly.Filesystem go-nfs serves, with the file information
+// go-nfs understands
+type nfsFS struct {
+	billy.Filesystem
+}
+
+var _ billy.Capable = nfsFS{}
+
+func (n nfsFS) Capabilities() billy.Capability {
+	return billy.Capabilities(n.Filesystem)
+}
+
+func (n nfsFS) Stat(filename string) (os.FileInfo, error) {
+	info, err := n.Filesystem.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{info}, nil
+}
+
+func (n nfsFS) Lstat(filename string) (os.FileInfo, error) {
+	info, err := n.Filesystem.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{info}, nil
+}
+
+func (n nfsFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	infos, err := n.Filesystem.ReadDir(dirname)
+	for i, info := range infos {
+		infos[i] = fileInfo{info}
+	}
+	return infos, err
+}
+
+func (n nfsFS) Chroot(p string) (billy.Filesystem, error) {
+	chroot, err := n.Filesystem.Chroot(p)
+	if err != nil {
+		return nil, err
+	}
+	return nfsFS{chroot}, nil
+}
+
+// fileInfo hands the owners, link count and inode number to go-nfs
+type fileInfo struct {
+	fs.FileInfo
+}
+
+func (i fileInfo) Sys() interface{} {
+	core := i.FileInfo.Sys().(*xfs.InodeCore)
+	info := &file.FileInfo{Nlink: core.NLink, UID: core.UID, GID: core.GID}
+	if ino, ok := i.FileInfo.(interface{ Ino() uint64 }); ok {
+		info.Fileid = ino.Ino()
+	}
+	return info
+}
@@ -0,0 +1,27 @@
+module github.com/masahiro331/go-xfs-filesystem/xfsnfs
+
+go 1.18
+
+require (
+	github.com/go-git/go-billy/v5 v5.6.0
+	github.com/masahiro331/go-xfs-filesystem v0.0.0-00010101000000-000000000000
+	github.com/masahiro331/go-xfs-filesystem/xfsbilly v0.0.0-00010101000000-000000000000
+	github.com/willscott/go-nfs v0.0.3
+	github.com/willscott/go-nfs-client v0.0.0-20240104095149-b44639837b00
+)
+
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/rasky/go-xdr v0.0.0-20170124162913-1a41d1a06c93 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/zap v1.23.0 // indirect
+	golang.org/x/sys v0.24.0 // indirect
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
+)
+
+replace (
+	github.com/masahiro331/go-xfs-filesystem => ../
+	github.com/masahiro331/go-xfs-filesystem/xfsbilly => ../xfsbilly
+)
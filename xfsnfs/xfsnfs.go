@@ -0,0 +1,50 @@
+// Package xfsnfs serves an xfs.FileSystem read-only over NFSv3 with go-nfs.
+package xfsnfs
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsbilly"
+)
+
+// handleCacheSize is the number of file handles kept, NFS clients use a handle
+// per file and directory they visit
+const handleCacheSize = 1 << 16
+
+// NewHandler returns an NFS handler exporting fileSystem at / to every client
+// without authentication
+func NewHandler(fileSystem *xfs.FileSystem) nfs.Handler {
+	h := statHandler{
+		Handler:    helpers.NewNullAuthHandler(nfsFS{xfsbilly.New(fileSystem)}),
+		fileSystem: fileSystem,
+	}
+	return helpers.NewCachingHandler(h, handleCacheSize)
+}
+
+// Serve serves fileSystem on l until l is closed, the mount protocol is on
+// the same port as NFS
+func Serve(l net.Listener, fileSystem *xfs.FileSystem) error {
+	return nfs.Serve(l, NewHandler(fileSystem))
+}
+
+// statHandler reports the size and inode counts of the superblock, go-nfs
+// reports nothing available on a read-only export
+type statHandler struct {
+	nfs.Handler
+	fileSystem *xfs.FileSystem
+}
+
+func (h statHandler) FSStat(ctx context.Context, f billy.Filesystem, s *nfs.FSStat) error {
+	sb := h.fileSystem.PrimaryAG.SuperBlock
+	s.TotalSize = sb.Dblocks * uint64(sb.BlockSize)
+	s.FreeSize = sb.Fdblocks * uint64(sb.BlockSize)
+	s.TotalFiles = sb.Icount
+	s.FreeFiles = sb.Ifree
+	return nil
+}
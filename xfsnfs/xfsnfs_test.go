@@ -0,0 +1,94 @@
+package xfsnfs_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsnfs"
+)
+
+func TestServe(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go xfsnfs.Serve(l, fileSystem)
+
+	c, err := rpc.DialTCP("tcp", l.Addr().String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	mounter := nfsc.Mount{Client: c}
+	target, err := mounter.Mount("/", rpc.AuthNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mounter.Unmount()
+
+	entries, err := target.ReadDirPlus("/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"os-release"}) {
+		t.Errorf("expected os-release in etc, actual %v", names)
+	}
+
+	attr, err := target.Getattr("/etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.Fileid != 20453 || attr.Filesize != uint64(len(expected)) || attr.Nlink != 1 {
+		t.Errorf("expected inode 20453 of %d bytes, actual %+v", len(expected), attr)
+	}
+
+	file, err := target.Open("/etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	b, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(expected) {
+		t.Errorf("expected %q, actual %q", expected, b)
+	}
+
+	if _, err := target.Open("/etc/missing"); err == nil {
+		t.Error("expected an error opening a missing file")
+	}
+	if _, err := target.Create("/new", 0o644); err == nil {
+		t.Error("expected an error creating a file on a read-only export")
+	}
+}
@@ -0,0 +1,182 @@
+// Package xfsoci writes the tree of an xfs.FileSystem as an OCI image layer,
+// to turn VM images into container images.
+package xfsoci
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// whiteoutPrefix marks deleted files in OCI layers, files of the image with
+// such names would delete files of the lower layers and are skipped
+const whiteoutPrefix = ".wh."
+
+// capabilityXattr is the only extended attribute recorded, as docker does,
+// SELinux labels and the like belong to the host
+const capabilityXattr = "security.capability"
+
+// IDMap maps the IDs From to From+Count-1 to To to To+Count-1
+type IDMap struct {
+	From, To, Count uint32
+}
+
+// LayerOptions are the options of WriteLayer
+type LayerOptions struct {
+	// UIDMaps and GIDMaps remap the owners, IDs outside every map are kept
+	UIDMaps []IDMap
+	GIDMaps []IDMap
+}
+
+// WriteLayer writes the tree at root of fileSystem to w as an uncompressed
+// layer tarball, root becomes the root of the layer. The layer holds no
+// whiteouts, it is meant to be the only or the lowest layer. It returns the
+// diff ID of the layer, the sha256 digest of the tarball.
+//
+// Device files and sockets are skipped as the tarball cannot hold them.
+func WriteLayer(w io.Writer, fileSystem *xfs.FileSystem, root string, opts LayerOptions) (string, error) {
+	h := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(w, h))
+	l := layer{fileSystem: fileSystem, tw: tw, opts: opts, links: map[uint64]string{}}
+	if err := fs.WalkDir(fileSystem, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return l.add(root, name, d)
+	}); err != nil {
+		return "", xerrors.Errorf("failed to write the layer of %s: %w", root, err)
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+type layer struct {
+	fileSystem *xfs.FileSystem
+	tw         *tar.Writer
+	opts       LayerOptions
+
+	// links maps the inode numbers of files with several links to their
+	// first name in the layer
+	links map[uint64]string
+}
+
+// add writes the file name found under root
+func (l *layer) add(root, name string, d fs.DirEntry) error {
+	if name == root {
+		return nil
+	}
+	rel := name
+	if root != "." {
+		rel = strings.TrimPrefix(name, root+"/")
+	}
+	if strings.HasPrefix(d.Name(), whiteoutPrefix) {
+		l.fileSystem.Logger().Warn("skipping whiteout name", "path", name)
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	}
+	if d.Type()&(fs.ModeDevice|fs.ModeSocket) != 0 {
+		l.fileSystem.Logger().Warn("skipping unsupported file type", "path", name, "type", d.Type())
+		return nil
+	}
+
+	i, err := d.Info()
+	if err != nil {
+		return err
+	}
+	info := i.(xfs.FileInfo)
+	var link string
+	if d.Type() == fs.ModeSymlink {
+		if link, err = l.fileSystem.ReadLink(name); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if d.IsDir() {
+		hdr.Name += "/"
+	}
+	core := info.Sys().(*xfs.InodeCore)
+	hdr.Uid = int(mapID(l.opts.UIDMaps, core.UID))
+	hdr.Gid = int(mapID(l.opts.GIDMaps, core.GID))
+	// only the modification time is kept, for reproducible layers
+	hdr.ModTime = info.ModTime().Truncate(time.Second)
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Format = tar.FormatPAX
+
+	capability, err := l.fileSystem.GetXattr(name, capabilityXattr)
+	if err == nil {
+		hdr.PAXRecords = map[string]string{"SCHILY.xattr." + capabilityXattr: string(capability)}
+	} else if !xerrors.Is(err, xfs.ErrNoAttribute) {
+		return err
+	}
+
+	if d.Type().IsRegular() && core.NLink > 1 {
+		if first, ok := l.links[info.Ino()]; ok {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			return l.tw.WriteHeader(hdr)
+		}
+		l.links[info.Ino()] = hdr.Name
+	}
+	if err := l.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !d.Type().IsRegular() {
+		return nil
+	}
+	f, err := l.fileSystem.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(l.tw, f)
+	return err
+}
+
+// mapID returns id after the first map holding it
+func mapID(maps []IDMap, id uint32) uint32 {
+	for _, m := range maps {
+		if id >= m.From && id-m.From < m.Count {
+			return m.To + id - m.From
+		}
+	}
+	return id
+}
+
+// ParseIDMap parses FROM:TO[:COUNT], COUNT defaults to 1
+func ParseIDMap(s string) (IDMap, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) == 2 {
+		fields = append(fields, "1")
+	}
+	if len(fields) != 3 {
+		return IDMap{}, xerrors.Errorf("invalid ID map %q, expected FROM:TO[:COUNT]", s)
+	}
+	var ids [3]uint32
+	for i, field := range fields {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return IDMap{}, xerrors.Errorf("invalid ID map %q: %w", s, err)
+		}
+		ids[i] = uint32(id)
+	}
+	if ids[2] == 0 {
+		return IDMap{}, xerrors.Errorf("invalid ID map %q: the count is 0", s)
+	}
+	return IDMap{From: ids[0], To: ids[1], Count: ids[2]}, nil
+}
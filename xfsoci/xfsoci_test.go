@@ -0,0 +1,126 @@
+package xfsoci_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsoci"
+)
+
+func TestWriteLayer(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := fileSystem.ReadFile("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	diffID, err := xfsoci.WriteLayer(&buf, fileSystem, ".", xfsoci.LayerOptions{
+		UIDMaps: []xfsoci.IDMap{{From: 0, To: 100000, Count: 65536}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(buf.Bytes())); diffID != digest {
+		t.Errorf("expected diff ID %s, actual %s", digest, diffID)
+	}
+
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[hdr.Name] = hdr
+		if hdr.Name != "etc/os-release" {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(expected) {
+			t.Errorf("unexpected content %q", data)
+		}
+		if hdr.Uid != 100000 || hdr.Gid != 0 {
+			t.Errorf("unexpected owner %d:%d", hdr.Uid, hdr.Gid)
+		}
+		if _, ok := hdr.PAXRecords["SCHILY.xattr.security.selinux"]; ok {
+			t.Error("SELinux label was recorded")
+		}
+		if hdr.Mode != 0o644 || !hdr.AccessTime.IsZero() {
+			t.Errorf("unexpected header %+v", hdr)
+		}
+	}
+	for _, name := range []string{"etc/", "etc/os-release", "parent/child/child/child/child/executable"} {
+		if _, ok := headers[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	if len(headers) != 1251 {
+		t.Errorf("expected %d entries, actual %d", 1251, len(headers))
+	}
+
+	buf.Reset()
+	if _, err := xfsoci.WriteLayer(&buf, fileSystem, "etc", xfsoci.LayerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr = tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"os-release"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
+
+func TestParseIDMap(t *testing.T) {
+	for s, expected := range map[string]xfsoci.IDMap{
+		"0:1000":        {From: 0, To: 1000, Count: 1},
+		"0:100000:6553": {From: 0, To: 100000, Count: 6553},
+	} {
+		m, err := xfsoci.ParseIDMap(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != expected {
+			t.Errorf("%s: expected %+v, actual %+v", s, expected, m)
+		}
+	}
+	for _, s := range []string{"0", "0:1:0", "a:1", "0:1:2:3", "-1:0"} {
+		if _, err := xfsoci.ParseIDMap(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Package xfsotel traces the operations of an xfs.FileSystem with
+// OpenTelemetry, to see where the time goes for each scanned image.
+package xfsotel
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// Tracer implements xfs.Tracer, the spans are children of the span of its
+// context
+type Tracer struct {
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+var _ xfs.Tracer = &Tracer{}
+
+// New returns the xfs.Tracer starting spans of tracer under the span of ctx,
+// such as the span of the scan of the image:
+//
+//	ctx, span := tracer.Start(ctx, "scan")
+//	defer span.End()
+//	fileSystem, err := xfs.OpenReaderAt(r, nil, xfs.WithTracer(xfsotel.New(ctx, tracer)))
+func New(ctx context.Context, tracer trace.Tracer) *Tracer {
+	return &Tracer{ctx: ctx, tracer: tracer}
+}
+
+// Start implements xfs.Tracer
+func (t *Tracer) Start(name string, attrs ...xfs.TraceAttribute) xfs.TraceSpan {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		key := "xfs." + a.Key
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(key, v))
+		case uint64:
+			kvs = append(kvs, attribute.Int64(key, int64(v)))
+		default:
+			kvs = append(kvs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	_, span := t.tracer.Start(t.ctx, name, trace.WithAttributes(kvs...))
+	return otelSpan{span}
+}
+
+// otelSpan ends the OpenTelemetry span with the error status of the operation
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,90 @@
+package xfsotel_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsotel"
+)
+
+func TestTracer(t *testing.T) {
+	f, err := os.Open("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	ctx, scan := tracer.Start(context.Background(), "scan")
+	fileSystem, err := xfs.NewFS(*io.NewSectionReader(f, 0, info.Size()), nil, xfs.WithTracer(xfsotel.New(ctx, tracer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.ReadFile("etc/os-release"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.ReadDir("fmt_leaf_directories"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.ReadDir("etc/missing"); err == nil {
+		t.Fatal("expected an error")
+	}
+	scan.End()
+
+	counts := map[string]int{}
+	var resolved []string
+	var failed bool
+	for _, span := range recorder.Ended() {
+		name := span.Name()
+		counts[name]++
+		if name == "scan" {
+			continue
+		}
+		if span.Parent().SpanID() != scan.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the scan span", name)
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		switch name {
+		case "xfs.mount":
+			if attrs["xfs.size"].AsInt64() != info.Size() {
+				t.Errorf("unexpected mount attributes %v", span.Attributes())
+			}
+		case "xfs.resolve":
+			resolved = append(resolved, attrs["xfs.path"].AsString())
+			if attrs["xfs.path"].AsString() == "etc/missing" && span.Status().Code == codes.Error {
+				failed = true
+			}
+		case "xfs.read":
+			if attrs["xfs.count"].AsInt64() == 0 {
+				t.Errorf("unexpected read attributes %v", span.Attributes())
+			}
+		}
+	}
+	for _, name := range []string{"xfs.mount", "xfs.resolve", "xfs.readdir", "xfs.lookup", "xfs.read"} {
+		if counts[name] == 0 {
+			t.Errorf("no %s span in %v", name, counts)
+		}
+	}
+	if counts["xfs.mount"] != 1 {
+		t.Errorf("expected one mount span, actual %d", counts["xfs.mount"])
+	}
+	if !failed {
+		t.Errorf("the resolution of etc/missing is not an error span, resolved %v", resolved)
+	}
+}
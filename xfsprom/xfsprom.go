@@ -0,0 +1,111 @@
+// Package xfsprom implements xfs.Metrics with Prometheus collectors.
+package xfsprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+)
+
+// Metrics is an xfs.Metrics and a prometheus.Collector, one Metrics is shared
+// by the FileSystems of a process:
+//
+//	metrics := xfsprom.New()
+//	prometheus.MustRegister(metrics)
+//	fileSystem, err := xfs.OpenReaderAt(r, nil, xfs.WithMetrics(metrics))
+type Metrics struct {
+	reads       *prometheus.CounterVec
+	readBytes   prometheus.Counter
+	cache       *prometheus.CounterVec
+	parseErrors *prometheus.CounterVec
+	operations  *prometheus.HistogramVec
+}
+
+var (
+	_ xfs.Metrics          = &Metrics{}
+	_ prometheus.Collector = &Metrics{}
+)
+
+// New returns the collectors of the xfs_ metrics
+func New() *Metrics {
+	return &Metrics{
+		reads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "xfs",
+			Name:      "image_reads_total",
+			Help:      "Reads of the images, by result.",
+		}, []string{"result"}),
+		readBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "xfs",
+			Name:      "image_read_bytes_total",
+			Help:      "Bytes read from the images.",
+		}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "xfs",
+			Name:      "cache_lookups_total",
+			Help:      "Lookups of the inode and directory caches, by cache and result.",
+		}, []string{"cache", "result"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "xfs",
+			Name:      "parse_errors_total",
+			Help:      "Operations failed on corrupted on-disk structures, by structure.",
+		}, []string{"structure"}),
+		operations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "xfs",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the file system operations, by operation and result.",
+			Buckets:   prometheus.ExponentialBuckets(1e-5, 4, 10),
+		}, []string{"op", "result"}),
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ImageRead implements xfs.Metrics
+func (m *Metrics) ImageRead(n int, err error) {
+	m.reads.WithLabelValues(result(err)).Inc()
+	m.readBytes.Add(float64(n))
+}
+
+// CacheLookup implements xfs.Metrics
+func (m *Metrics) CacheLookup(cache string, hit bool) {
+	r := "miss"
+	if hit {
+		r = "hit"
+	}
+	m.cache.WithLabelValues(cache, r).Inc()
+}
+
+// ParseError implements xfs.Metrics
+func (m *Metrics) ParseError(structure string) {
+	m.parseErrors.WithLabelValues(structure).Inc()
+}
+
+// Operation implements xfs.Metrics
+func (m *Metrics) Operation(op string, d time.Duration, err error) {
+	m.operations.WithLabelValues(op, result(err)).Observe(d.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.reads.Describe(ch)
+	m.readBytes.Describe(ch)
+	m.cache.Describe(ch)
+	m.parseErrors.Describe(ch)
+	m.operations.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.reads.Collect(ch)
+	m.readBytes.Collect(ch)
+	m.cache.Collect(ch)
+	m.parseErrors.Collect(ch)
+	m.operations.Collect(ch)
+}
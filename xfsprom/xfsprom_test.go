@@ -0,0 +1,97 @@
+package xfsprom_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/masahiro331/go-xfs-filesystem/xfs"
+	"github.com/masahiro331/go-xfs-filesystem/xfsprom"
+)
+
+func TestMetrics(t *testing.T) {
+	img, err := os.ReadFile("../xfs/testdata/image.xfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := xfsprom.New()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+
+	fileSystem, err := xfs.OpenReaderAt(bytes.NewReader(img), nil,
+		xfs.WithMetrics(metrics), xfs.WithInodeCacheSize(100), xfs.WithDirCacheSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := fileSystem.ReadDir("etc"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileSystem.ReadFile("etc/os-release"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ReadFile reports no open of its own
+	f, err := fileSystem.Open("etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// a broken inode magic of etc/os-release, inodes of the first AG are at
+	// ino * 512
+	broken := append([]byte(nil), img...)
+	copy(broken[20453*512:], "XX")
+	fileSystem, err = xfs.OpenReaderAt(bytes.NewReader(broken), nil, xfs.WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSystem.ReadFile("etc/os-release"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the values by metric name and labels
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, l := range m.GetLabel() {
+				key += " " + l.GetName() + "=" + l.GetValue()
+			}
+			values[key] = value(m)
+		}
+	}
+
+	for key, check := range map[string]func(float64) bool{
+		"xfs_image_reads_total result=ok":                            func(v float64) bool { return v > 0 },
+		"xfs_image_read_bytes_total":                                 func(v float64) bool { return v > 333 },
+		"xfs_cache_lookups_total cache=inode result=hit":             func(v float64) bool { return v > 0 },
+		"xfs_cache_lookups_total cache=inode result=miss":            func(v float64) bool { return v > 0 },
+		"xfs_cache_lookups_total cache=dir result=hit":               func(v float64) bool { return v > 0 },
+		"xfs_parse_errors_total structure=inode":                     func(v float64) bool { return v == 1 },
+		"xfs_operation_duration_seconds op=mount result=ok":          func(v float64) bool { return v == 2 },
+		"xfs_operation_duration_seconds op=read directory result=ok": func(v float64) bool { return v == 2 },
+		"xfs_operation_duration_seconds op=read file result=ok":      func(v float64) bool { return v == 2 },
+		"xfs_operation_duration_seconds op=read file result=error":   func(v float64) bool { return v == 1 },
+		"xfs_operation_duration_seconds op=open result=ok":           func(v float64) bool { return v == 1 },
+	} {
+		if v, ok := values[key]; !ok || !check(v) {
+			t.Errorf("unexpected %s: %v", key, v)
+		}
+	}
+}
+
+// value returns the value of a counter, or the count of a histogram
+func value(m *dto.Metric) float64 {
+	if m.GetHistogram() != nil {
+		return float64(m.GetHistogram().GetSampleCount())
+	}
+	return m.GetCounter().GetValue()
+}